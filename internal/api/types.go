@@ -1,6 +1,7 @@
 package api
 
 import (
+	"context"
 	"regexp"
 
 	"github.com/replit/upm/internal/util"
@@ -23,7 +24,7 @@ type PkgVersion string
 //
 // Note: the PkgInfo struct is parsed with reflection in several
 // places. It must have "json" and "pretty" tags, and the only allowed
-// types are string and []string.
+// types are string, []string, int and int64.
 type PkgInfo struct {
 
 	// The name of the package, e.g. "flask". Package names cannot
@@ -69,6 +70,152 @@ type PkgInfo struct {
 	// no dependencies and a package whose language backend did
 	// not provide dependency information.
 	Dependencies []string `json:"dependencies,omitempty" pretty:"Dependencies"`
+
+	// Names of optional features of the package that pull in
+	// additional dependencies, e.g. "async" and "dotenv" for
+	// Flask (which Python calls extras).
+	Extras []string `json:"extras,omitempty" pretty:"Extras"`
+
+	// Number of packages which are direct dependencies of this
+	// package. This is given even by backends that can't list
+	// them in Dependencies; zero means that there are none or
+	// that the backend did not provide the information.
+	DependencyCount int `json:"dependencyCount,omitempty" pretty:"Direct dependencies"`
+
+	// Date and time at which Version was released, in RFC 3339
+	// format, e.g. "2023-09-30T18:54:12Z".
+	ReleaseDate string `json:"releaseDate,omitempty" pretty:"Released"`
+
+	// Size in bytes of the artifact that is downloaded to install
+	// Version, e.g. a wheel for Python or a tarball for Node.js.
+	// If there are several to choose from, this is the largest.
+	Size int64 `json:"size,omitempty" pretty:"Size (bytes)"`
+
+	// Keywords or topics under which the package is listed in
+	// its registry, e.g. "wsgi" and "web" for Flask.
+	Keywords []string `json:"keywords,omitempty" pretty:"Keywords"`
+
+	// Number of times the package has been downloaded, as of
+	// DownloadsDate. It is only filled in by 'upm info
+	// --downloads', from the Downloads method of the backend.
+	Downloads int `json:"downloads,omitempty" pretty:"Downloads"`
+
+	// Date on which Downloads was counted, as YYYY-MM-DD, or
+	// empty if that isn't known.
+	DownloadsDate string `json:"downloadsDate,omitempty" pretty:"Downloads as of"`
+}
+
+// PkgDownloads is how many times a package has been downloaded.
+type PkgDownloads struct {
+	Count int
+	// The date on which the downloads were counted, as
+	// YYYY-MM-DD, or empty if that isn't known.
+	Date string
+}
+
+// MapStatus describes the map of an ecosystem that a backend guesses
+// and searches packages with, for 'upm index status'.
+type MapStatus struct {
+
+	// The ecosystem, such as "pypi".
+	Ecosystem string `json:"ecosystem"`
+
+	// The file that 'upm index update' downloaded the map to, or
+	// empty if the map is the one built into UPM.
+	File string `json:"file,omitempty"`
+
+	// The date on which the map was generated, and on which its
+	// download counts were taken, as YYYY-MM-DD, or empty if
+	// that isn't known.
+	Generated     string `json:"generated"`
+	DownloadsDate string `json:"downloadsDate,omitempty"`
+
+	// How many imports, such as modules or required paths, the
+	// map knows the package of.
+	Imports int `json:"imports"`
+
+	// The URL of the service that 'upm search' queries, or empty
+	// if it only searches the map.
+	RemoteSearch string `json:"remoteSearch"`
+
+	// True if the information about packages that the backend
+	// fetches is cached in the store for --cache-ttl.
+	CachesInfo bool `json:"-"`
+}
+
+// GuessedPkg describes why a package was guessed to be a dependency
+// of the project.
+type GuessedPkg struct {
+
+	// The files that import the package, relative to the project
+	// directory and sorted.
+	Files []string `json:"files,omitempty"`
+
+	// How likely it is that the package is the one that the
+	// imports need, from 0 to 1, or 0 if that can't be told. A
+	// package named by a pragma in the code has a confidence of 1.
+	Confidence float64 `json:"confidence,omitempty"`
+
+	// The names of the guess sources, other than the backend's
+	// own scan of the imports, that suggested the package (see
+	// the guess package).
+	Sources []string `json:"sources,omitempty"`
+
+	// The spec to add the package with, as given by a pragma in
+	// the code, or empty to add any version.
+	Spec PkgSpec `json:"spec,omitempty"`
+
+	// True if a pragma in the code says that the package is only
+	// needed for development, so it is added to the dev group.
+	Dev bool `json:"dev,omitempty"`
+}
+
+// InstalledPkg describes a package as it is installed in the package
+// dir, for 'upm verify'.
+type InstalledPkg struct {
+
+	// The version that is installed.
+	Version PkgVersion
+
+	// The installed files of the package that no longer match
+	// the hashes recorded when it was installed, or that are
+	// missing, relative to the directory the package was
+	// installed in and sorted.
+	Modified []string
+
+	// True if the package is installed as a matter of course
+	// without being in the lockfile, such as pip in a virtualenv
+	// or the project itself, so it isn't reported when the
+	// lockfile doesn't have it.
+	Implicit bool
+}
+
+// ExecEnv is how to run a command in the environment that a backend
+// installs packages into, for 'upm exec'.
+type ExecEnv struct {
+
+	// Directories to put in front of $PATH, such as the bin
+	// directory of a virtualenv.
+	Path []string
+
+	// Environment variables to set, such as VIRTUAL_ENV.
+	Vars map[string]string
+
+	// The command to run the command with, such as "bundle exec",
+	// or none to run it as is.
+	Prefix []string
+}
+
+// Problem is something wrong with the environment that a backend
+// found, as 'upm doctor' reports it.
+type Problem struct {
+
+	// What is wrong, e.g. "VIRTUAL_ENV is not the project's
+	// virtualenv".
+	Summary string
+
+	// What to do about it, as a command or an instruction.
+	Fix string
 }
 
 // Quirks is a bitmask enum used to indicate how specific language
@@ -128,6 +275,16 @@ const (
 // (The limitation should be noted in the backend feature matrix in
 // the README.)
 //
+// The methods that may use the network or run the package manager to
+// do their work, such as Search and Lock, take the context of the
+// operation that calls them, and must make their requests and run
+// their subprocesses under it, with the functions of package util
+// that take a context. Those functions abort once it is done (see
+// util.Abort), so a method need not check it itself unless it does
+// something else for a long time. The methods that only list or
+// inspect what is already there take no context, even if they run a
+// command to do so.
+//
 // Make sure to update the Check method when adding/removing fields
 // from this struct.
 type LanguageBackend struct {
@@ -147,15 +304,66 @@ type LanguageBackend struct {
 	// The filename of the specfile, e.g. "pyproject.toml" for
 	// Poetry.
 	//
-	// This field is mandatory.
+	// This field is mandatory, unless GetSpecfile is given.
 	Specfile string
 
+	// Return the filename of the specfile, for a package manager
+	// whose specfile is named after the project, e.g.
+	// "myproject-dev-1.rockspec" for LuaRocks. Setup sets
+	// Specfile to it, so it must be called from the project
+	// directory.
+	//
+	// This field is optional.
+	GetSpecfile func() string
+
 	// The filename of the lockfile, e.g. "poetry.lock" for
 	// Poetry.
 	//
 	// This field is mandatory.
 	Lockfile string
 
+	// The filenames that the lockfile may have instead, if the
+	// package manager has more than one flavor of lockfile, in
+	// the order in which it prefers them and including Lockfile,
+	// e.g. "npm-shrinkwrap.json" and then "package-lock.json" for
+	// NPM. The backend returned by backends.GetBackend has the
+	// first of them that exists as its Lockfile, which is the one
+	// that UPM reads and keeps up to date, and the others are
+	// ignored, as by the package manager; if none exists, Lockfile
+	// is the one that is created. The methods of the backend must
+	// read the same one.
+	//
+	// This field is optional; if it is omitted, then the lockfile
+	// is always Lockfile.
+	LockfileFlavors []string
+
+	// The string that starts a line comment in the lockfile, e.g.
+	// "#" for poetry.lock, so that with --provenance, UPM can
+	// note in the lockfile which version of UPM locked it, when,
+	// and on which platform.
+	//
+	// This field is optional; it should be omitted if the format
+	// of the lockfile has no comments, or if the package manager
+	// refuses a lockfile that it didn't write every line of.
+	LockfileComment string
+
+	// The name of the ecosystem of the backend's packages in the
+	// vulnerability database of OSV.dev, e.g. "PyPI" for Poetry,
+	// so that 'upm audit' can look up the packages in the
+	// lockfile there.
+	//
+	// This field is optional; if it is omitted, then the
+	// packages can't be audited.
+	OSVEcosystem string
+
+	// Return the URL of the registry that the backend fetches
+	// packages from, which may be configured for the project,
+	// so that 'upm doctor' can check that it can be reached.
+	//
+	// This field is optional; if it is omitted, then no
+	// registry is checked.
+	Registry func() string
+
 	// List of filename globs that match against files written in
 	// this programming language, e.g. "*.py" for Python. These
 	// should not include any slashes, because they may be matched
@@ -170,6 +378,16 @@ type LanguageBackend struct {
 	// This field is mandatory.
 	FilenamePatterns []string
 
+	// Return true if the specfile, which exists, is meant for
+	// this language backend in particular, e.g. if a
+	// pyproject.toml has a [tool.pdm] table. This lets a backend
+	// claim a specfile that several backends share (and would
+	// otherwise go to whichever of them comes first) when there is
+	// no lockfile to tell them apart.
+	//
+	// This field is optional.
+	OwnsSpecfile func() bool
+
 	// QuirksNone if the language backend conforms to the core
 	// abstractions of UPM, and some bitwise disjunction of the
 	// Quirks constant values otherwise.
@@ -177,6 +395,16 @@ type LanguageBackend struct {
 	// This field is optional, and defaults to QuirksNone.
 	Quirks Quirks
 
+	// The programs that the language backend runs, by name (to be
+	// looked up on $PATH) or by path, e.g. "poetry" for Poetry.
+	// If a command policy is in effect, the backend is rejected
+	// when it is selected unless the policy allows all of them,
+	// rather than failing partway through an operation.
+	//
+	// This field is optional; programs that are not listed are
+	// still checked against the policy when they are run.
+	Executables []string
+
 	// Function that normalizes a package name. This is used to
 	// prevent duplicate packages getting added to the specfile.
 	// For example, in Python the package names "flask" and
@@ -190,6 +418,36 @@ type LanguageBackend struct {
 	// which packages are installed. The path need not exist.
 	GetPackageDir func() string
 
+	// Return how to run a command with the packages in the package
+	// dir available to it, as if the environment of the project
+	// were activated, for 'upm exec'. The package dir may not
+	// exist.
+	//
+	// This field is optional; if it is omitted, then 'upm exec'
+	// is not supported by the backend.
+	GetExecEnv func() ExecEnv
+
+	// Return the path of the directory containing the installed
+	// source of the given package, so that it can be edited by
+	// 'upm patch'. The package is guaranteed to be in the
+	// lockfile. If it is not actually installed, the path need
+	// not exist.
+	//
+	// This field is optional; if it is omitted, then patching
+	// installed packages is not supported by the backend.
+	GetInstalledPackageDir func(PkgName) string
+
+	// Arrange for packages to be installed inside the project
+	// directory for the rest of the process, even if they would
+	// normally go somewhere outside it (such as a virtualenv in
+	// a global cache). Return a function that undoes this. This
+	// is used by 'upm upgrade --canary' to try out an upgrade in
+	// a throwaway copy of the project.
+	//
+	// This field is optional; it is only needed if GetPackageDir
+	// may return a path outside the project.
+	IsolatePackageDir func() (restore func())
+
 	// Search for packages using an online index. The query may
 	// contain any characters, including whitespace. Return a list
 	// of search results, which can be of any length. (It will be
@@ -198,13 +456,31 @@ type LanguageBackend struct {
 	// results, return an empty slice.
 	//
 	// This field is mandatory.
-	Search func(query string) []PkgInfo
+	Search func(ctx context.Context, query string) []PkgInfo
 
 	// Retrieve information about a package from an online index.
 	// If the package doesn't exist, return a zero struct.
 	//
 	// This field is mandatory.
-	Info func(PkgName) PkgInfo
+	Info func(context.Context, PkgName) PkgInfo
+
+	// Return the newest version of the given package in the
+	// online index that the given spec from the specfile allows,
+	// or an empty string if there is none or the spec can't be
+	// resolved, for example because it names a Git repository.
+	// It is used by 'upm outdated'.
+	//
+	// This field is optional; if it is omitted, then 'upm
+	// outdated' leaves the wanted version blank.
+	WantedVersion func(context.Context, PkgName, PkgSpec) PkgVersion
+
+	// Return how many times the given package has been
+	// downloaded, from a snapshot of the counts that is built
+	// into UPM, or false if it isn't in the snapshot.
+	//
+	// This field is optional; if it is omitted, then download
+	// counts are not available for the backend.
+	Downloads func(PkgName) (PkgDownloads, bool)
 
 	// Add packages to the specfile. The map is guaranteed to have
 	// at least one package, and all of the packages are
@@ -224,7 +500,39 @@ type LanguageBackend struct {
 	// it does not exist already.
 	//
 	// This field is mandatory.
-	Add func(map[PkgName]PkgSpec, string)
+	Add func(context.Context, map[PkgName]PkgSpec, string)
+
+	// Create the specfile of a new project with the given name and
+	// no dependencies, non-interactively, as the package manager
+	// does (e.g. 'poetry init --no-interaction' or 'npm init -y').
+	// The specfile is guaranteed not to exist already, and the
+	// name is never empty, although a backend may ignore it.
+	//
+	// This field is optional; if it is omitted, then 'upm init'
+	// can only start a project of the backend from a template.
+	InitSpecfile func(ctx context.Context, projectName string)
+
+	// Add packages to the given dependency group of the specfile,
+	// such as "dev" for development dependencies, rather than to
+	// the main dependencies. Otherwise, the same as Add.
+	//
+	// This field is optional; if it is omitted, then dependency
+	// groups are not supported by the backend.
+	AddToGroup func(ctx context.Context, pkgs map[PkgName]PkgSpec, projectName string, group string)
+
+	// Add a package from a local directory as an editable
+	// dependency, so that changes to its source take effect
+	// without reinstalling it (e.g. pip install -e or a Cargo
+	// path dependency). The path is relative to the project
+	// directory. The package manager determines the name of the
+	// package, which must subsequently be returned by
+	// ListSpecfile. As with Add, the specfile is *not* guaranteed
+	// to exist already, and the string is the project name to use
+	// if it has to be created. Quirks apply as for Add.
+	//
+	// This field is optional; if it is omitted, then editable
+	// installs are not supported by the backend.
+	AddEditable func(ctx context.Context, path string, projectName string)
 
 	// Remove packages from the specfile. The map is guaranteed to
 	// have at least one package, and all of the packages are
@@ -237,7 +545,7 @@ type LanguageBackend struct {
 	// it does not exist already.
 	//
 	// This field is mandatory.
-	Remove func(map[PkgName]bool)
+	Remove func(context.Context, map[PkgName]bool)
 
 	// Generate the lockfile from the specfile. The specfile is
 	// guaranteed to already exist. This method must create the
@@ -247,7 +555,30 @@ type LanguageBackend struct {
 	//
 	// This field is mandatory, unless QuirksNotReproducible in
 	// which case this field *may* not be specified.
-	Lock func()
+	Lock func(context.Context)
+
+	// Update the given packages in the lockfile to the latest
+	// versions allowed by the specfile, leaving the rest of the
+	// lockfile alone. The packages need not be direct
+	// dependencies. The specfile is guaranteed to already exist.
+	// Quirks apply as for Lock.
+	//
+	// This field is optional; if it is omitted, then only
+	// upgrading every package at once is supported by the
+	// backend.
+	Upgrade func(context.Context, map[PkgName]bool)
+
+	// Raise the specs of the given packages in the specfile, or of
+	// every package in it if the map is empty, to the newest
+	// versions that they allow, or if the bool is true, to the
+	// latest versions, even if they are new major versions. A
+	// spec that can't be raised, such as a Git URL, is left
+	// alone. The packages are guaranteed to be in the specfile,
+	// which is guaranteed to exist. Quirks apply as for Add.
+	//
+	// This field is optional; if it is omitted, then 'upm update'
+	// can only upgrade within the specs, with --lock-only.
+	Update func(context.Context, map[PkgName]bool, bool)
 
 	// Install packages from the lockfile. The specfile and
 	// lockfile are guaranteed to already exist, unless
@@ -255,7 +586,7 @@ type LanguageBackend struct {
 	// guaranteed to exist.
 	//
 	// This field is mandatory.
-	Install func()
+	Install func(context.Context)
 
 	// List the packages in the specfile. Names and specs should
 	// be returned in a format suitable for the Add method. The
@@ -264,6 +595,73 @@ type LanguageBackend struct {
 	// This field is mandatory.
 	ListSpecfile func() map[PkgName]PkgSpec
 
+	// Return the dependency group of each package returned by
+	// ListSpecfile that is only in a group, such as "dev", rather
+	// than among the main dependencies. The specfile is
+	// guaranteed to exist already.
+	//
+	// This field is optional, but should be given if AddToGroup
+	// is.
+	ListSpecfileGroups func() map[PkgName]string
+
+	// Return where a package with the given spec, as returned by
+	// ListSpecfile, is installed from when it isn't the registry:
+	// for example "git", "url", or "path". For an ordinary version
+	// constraint, return the empty string. 'upm list' uses this to
+	// show such packages distinctly.
+	//
+	// This field is optional.
+	SpecSource func(spec PkgSpec) string
+
+	// Filenames, other than the specfile, in which a project may
+	// declare its dependencies in a way that the package manager
+	// doesn't read, e.g. "setup.py" for Poetry. If one of them
+	// exists but the specfile doesn't, 'upm migrate' moves the
+	// dependencies to the specfile.
+	//
+	// This field is optional, but should be given if
+	// ListLegacySpecfile is.
+	LegacySpecfiles []string
+
+	// List the packages declared in the files named by
+	// LegacySpecfiles, in a format suitable for the Add method.
+	// At least one of those files is guaranteed to exist.
+	//
+	// This field is optional; if it is omitted, then 'upm
+	// migrate' is not supported by the backend.
+	ListLegacySpecfile func() map[PkgName]PkgSpec
+
+	// Check whether the specfile can be used with a version of
+	// the language that is still supported. Return why not, such
+	// as a constraint that only allows Python 2, or the empty
+	// string if it can, along with the packages in the specfile
+	// that are only required on versions that are no longer
+	// supported. 'upm add' and 'upm lock' refuse to change a
+	// project with either, and 'upm migrate --python3' fixes it
+	// with UpgradeRuntime and Remove. The specfile may not exist,
+	// in which case there is nothing wrong.
+	//
+	// This field is optional.
+	CheckRuntime func() (string, []PkgName)
+
+	// Rewrite the constraints on the version of the language in
+	// the specfile that CheckRuntime objects to, so that they
+	// require a supported version instead. The specfile is
+	// guaranteed to exist already.
+	//
+	// This field is optional, but should be given if
+	// CheckRuntime is.
+	UpgradeRuntime func()
+
+	// Return what is wrong with the environment for the backend,
+	// beyond the programs and the registry that 'upm doctor'
+	// checks for every backend, such as an activated virtualenv
+	// that isn't the project's.
+	//
+	// This field is optional; if it is omitted, then nothing
+	// else is checked.
+	Diagnose func() []Problem
+
 	// List the packages in the lockfile. Names should be returned
 	// in a format suitable for the Add method. The lockfile is
 	// guaranteed to exist already.
@@ -271,6 +669,54 @@ type LanguageBackend struct {
 	// This field is mandatory.
 	ListLockfile func() map[PkgName]PkgVersion
 
+	// List the packages that each package in the lockfile depends
+	// on directly, with names as ListLockfile returns them. A
+	// package that depends on nothing may be left out. The
+	// lockfile is guaranteed to exist already.
+	//
+	// This field is optional; if it is omitted, then the depth of
+	// the dependencies can't be checked against a policy.
+	ListLockfileDependencies func() map[PkgName][]PkgName
+
+	// Write the packages in the lockfile that the main
+	// dependencies need, and those that the given dependency
+	// groups need as well, to the given file in the format of
+	// requirements.txt for Python, pinned to their locked
+	// versions, for deploy targets that can't read the lockfile.
+	// The lockfile is guaranteed to exist already.
+	//
+	// This field is optional; if it is omitted, then the
+	// lockfile can't be exported.
+	ExportLockfile func(ctx context.Context, groups []string, file string)
+
+	// List the packages installed in the package dir, checking
+	// their files against the hashes recorded when they were
+	// installed, if the package manager records any. Names should
+	// be returned in a format suitable for the Add method. If the
+	// package dir doesn't exist, return an empty map.
+	//
+	// This field is optional; if it is omitted, then 'upm
+	// verify' is not supported by the backend.
+	ListInstalled func() map[PkgName]InstalledPkg
+
+	// List the scripts that the project defines for the package
+	// manager to run, such as the scripts in package.json, mapped
+	// to what each one runs, or to its description if that is all
+	// there is to tell. If the project defines none, return an
+	// empty map.
+	//
+	// This field is optional; if it is omitted, then 'upm run' is
+	// not supported by the backend.
+	ListScripts func() map[string]string
+
+	// Run the given script, one that ListScripts returns, with
+	// the given arguments, attached to the terminal. If the
+	// script fails, exit the process with its exit code.
+	//
+	// This field is optional, but should be given if ListScripts
+	// is.
+	RunScript func(ctx context.Context, name string, args []string)
+
 	// Regexps used to determine if the Guess method really needs
 	// to be invoked, or if its previous return value can be
 	// re-used.
@@ -291,6 +737,16 @@ type LanguageBackend struct {
 	// always be run without recourse to caching.
 	GuessRegexps []*regexp.Regexp
 
+	// Return a string that changes whenever the return value of
+	// Guess might change for a reason other than the imports
+	// matched by GuessRegexps, such as a configurable map from
+	// imports to packages. It is hashed along with the imports,
+	// and can be of any length.
+	//
+	// This field is optional; if it is omitted, then only the
+	// imports determine whether Guess needs to be re-run.
+	GuessCacheKey func() string
+
 	// Return a list of packages that are probably needed as
 	// dependencies of the project. It is better to be safe than
 	// sorry: only packages which are *definitely* project
@@ -308,7 +764,15 @@ type LanguageBackend struct {
 	// (which is now wrong).
 	//
 	// This field is mandatory.
-	Guess func() (map[PkgName]bool, bool)
+	Guess func(context.Context) (map[PkgName]bool, bool)
+
+	// Like Guess, but also say why each package was guessed, so
+	// that 'upm guess --interactive' can show it. The keys must
+	// be the same as those Guess returns.
+	//
+	// This field is optional; if it is omitted, then only the
+	// names of guessed packages are shown.
+	GuessDetails func(context.Context) (map[PkgName]GuessedPkg, bool)
 }
 
 // Setup panics if the given language backend does not specify all of
@@ -319,6 +783,10 @@ type LanguageBackend struct {
 // a builder function which can perform this normalization and
 // validation.
 func (b *LanguageBackend) Setup() {
+	if b.GetSpecfile != nil {
+		b.Specfile = b.GetSpecfile()
+	}
+
 	condition2flag := map[string]bool{
 		"missing name":                     b.Name == "",
 		"missing specfile":                 b.Specfile == "",
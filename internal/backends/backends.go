@@ -6,15 +6,24 @@ import (
 	"strings"
 
 	"github.com/replit/upm/internal/api"
+	"github.com/replit/upm/internal/backends/cpp"
 	"github.com/replit/upm/internal/backends/dart"
+	"github.com/replit/upm/internal/backends/dlang"
 	"github.com/replit/upm/internal/backends/dotnet"
 	"github.com/replit/upm/internal/backends/elisp"
+	"github.com/replit/upm/internal/backends/fortran"
+	"github.com/replit/upm/internal/backends/haxe"
 	"github.com/replit/upm/internal/backends/java"
+	"github.com/replit/upm/internal/backends/lua"
 	"github.com/replit/upm/internal/backends/nodejs"
+	"github.com/replit/upm/internal/backends/perl"
 	"github.com/replit/upm/internal/backends/python"
+	"github.com/replit/upm/internal/backends/racket"
 	"github.com/replit/upm/internal/backends/rlang"
 	"github.com/replit/upm/internal/backends/ruby"
 	"github.com/replit/upm/internal/backends/rust"
+	"github.com/replit/upm/internal/backends/scala"
+	"github.com/replit/upm/internal/backends/swift"
 	"github.com/replit/upm/internal/util"
 )
 
@@ -25,6 +34,10 @@ import (
 // that comes first in this list will be used.
 var languageBackends = []api.LanguageBackend{
 	python.Python3Backend,
+	python.Python3CondaBackend,
+	python.Python3PipBackend,
+	python.Python3UvBackend,
+	python.Python3PdmBackend,
 	nodejs.NodejsNPMBackend,
 	nodejs.NodejsYarnBackend,
 	ruby.RubyBackend,
@@ -34,6 +47,16 @@ var languageBackends = []api.LanguageBackend{
 	rlang.RlangBackend,
 	dotnet.DotNetBackend,
 	rust.RustBackend,
+	lua.LuaRocksBackend,
+	perl.PerlCartonBackend,
+	scala.ScalaSbtBackend,
+	dlang.DubBackend,
+	cpp.ConanBackend,
+	cpp.VcpkgBackend,
+	swift.CocoaPodsBackend,
+	fortran.FpmBackend,
+	racket.RacoBackend,
+	haxe.HaxelibBackend,
 }
 
 // matchesLanguage checks if a language backend matches a value for
@@ -42,8 +65,15 @@ var languageBackends = []api.LanguageBackend{
 // --lang=python2. This is used to filter the available language
 // backends before trying to guess which one should be used.
 func matchesLanguage(b api.LanguageBackend, language string) bool {
+	return NameMatchesLanguage(b.Name, language)
+}
+
+// NameMatchesLanguage is like matchesLanguage, but takes the name of
+// the backend, for what is known of a backend without looking at the
+// project, such as what the store has cached for it.
+func NameMatchesLanguage(name string, language string) bool {
 	bParts := map[string]bool{}
-	for _, bPart := range strings.Split(b.Name, "-") {
+	for _, bPart := range strings.Split(name, "-") {
 		bParts[bPart] = true
 	}
 	for _, lPart := range strings.Split(language, "-") {
@@ -55,8 +85,83 @@ func matchesLanguage(b api.LanguageBackend, language string) bool {
 }
 
 // GetBackend returns the language backend for a given --lang argument
-// value. If none is applicable, it exits the process.
+// value. If none is applicable, or the one that is runs programs that
+// the command policy does not allow, it exits the process.
 func GetBackend(language string) api.LanguageBackend {
+	b := detectBackend(language)
+	checkCommandPolicy(b)
+	return withLockfileFlavor(b)
+}
+
+// GetNewBackend returns the language backend that a new project in an
+// empty directory would use for a given --lang argument value: the
+// first one that it matches, just as GetBackend would return there.
+// If there is none, or it runs programs that the command policy does
+// not allow, it exits the process.
+func GetNewBackend(language string) api.LanguageBackend {
+	for _, b := range languageBackends {
+		if matchesLanguage(b, language) {
+			checkCommandPolicy(b)
+			return b
+		}
+	}
+	util.Die("no such language: %s", language)
+	return api.LanguageBackend{}
+}
+
+// checkCommandPolicy exits the process if the given backend runs
+// programs that the command policy does not allow.
+func checkCommandPolicy(b api.LanguageBackend) {
+	for _, program := range b.Executables {
+		if !util.IsCommandAllowed(program) {
+			util.Die("the %s backend runs %s, which is not allowed by the command policy", b.Name, program)
+		}
+	}
+}
+
+// withLockfileFlavor returns the given backend with the flavor of its
+// lockfile that exists, if any, as its lockfile.
+func withLockfileFlavor(b api.LanguageBackend) api.LanguageBackend {
+	for _, flavor := range b.LockfileFlavors {
+		if util.Exists(flavor) {
+			b.Lockfile = flavor
+			break
+		}
+	}
+	return b
+}
+
+// hasLockfile returns true if the lockfile of the given backend
+// exists, in any of its flavors.
+func hasLockfile(b api.LanguageBackend) bool {
+	if util.Exists(b.Lockfile) {
+		return true
+	}
+	for _, flavor := range b.LockfileFlavors {
+		if util.Exists(flavor) {
+			return true
+		}
+	}
+	return false
+}
+
+// IgnoredLockfiles returns the flavors of the lockfile of the given
+// backend, as returned by GetBackend, that exist but aren't its
+// Lockfile, since the package manager prefers that one. They can only
+// go stale, since nothing that UPM runs updates them.
+func IgnoredLockfiles(b api.LanguageBackend) []string {
+	ignored := []string{}
+	for _, flavor := range b.LockfileFlavors {
+		if flavor != b.Lockfile && util.Exists(flavor) {
+			ignored = append(ignored, flavor)
+		}
+	}
+	return ignored
+}
+
+// detectBackend implements GetBackend, without checking the command
+// policy.
+func detectBackend(language string) api.LanguageBackend {
 	backends := languageBackends
 	if language != "" {
 		filteredBackends := []api.LanguageBackend{}
@@ -75,29 +180,63 @@ func GetBackend(language string) api.LanguageBackend {
 		}
 
 	}
+	if b, ok := autodetect(backends); ok {
+		return b
+	}
+	if language == "" {
+		util.Die("could not autodetect a language for your project")
+	}
+	return backends[0]
+}
+
+// autodetect returns the first of the given backends that the project
+// uses, preferring one whose specfile and lockfile both exist, or
+// false if the project uses none of them.
+func autodetect(backends []api.LanguageBackend) (api.LanguageBackend, bool) {
 	for _, b := range backends {
-		if util.Exists(b.Specfile) &&
-			util.Exists(b.Lockfile) {
-			return b
+		if util.Exists(b.Specfile) && hasLockfile(b) {
+			return b, true
 		}
 	}
 	for _, b := range backends {
-		if util.Exists(b.Specfile) ||
-			util.Exists(b.Lockfile) {
-			return b
+		if b.OwnsSpecfile != nil && util.Exists(b.Specfile) &&
+			b.OwnsSpecfile() {
+			return b, true
+		}
+	}
+	for _, b := range backends {
+		if util.Exists(b.Specfile) || hasLockfile(b) {
+			return b, true
 		}
 	}
 	for _, b := range backends {
 		for _, p := range b.FilenamePatterns {
 			if util.PatternExists(p) {
-				return b
+				return b, true
 			}
 		}
 	}
+	return api.LanguageBackend{}, false
+}
+
+// RelevantBackends returns the backends that the given --lang argument
+// value matches, or if it is empty, the one that is autodetected for
+// the project, if any, with the flavor of the lockfile that exists.
+// Unlike GetBackend, it never exits the process.
+func RelevantBackends(language string) []api.LanguageBackend {
 	if language == "" {
-		util.Die("could not autodetect a language for your project")
+		if b, ok := autodetect(languageBackends); ok {
+			return []api.LanguageBackend{withLockfileFlavor(b)}
+		}
+		return []api.LanguageBackend{}
 	}
-	return backends[0]
+	matched := []api.LanguageBackend{}
+	for _, b := range languageBackends {
+		if matchesLanguage(b, language) {
+			matched = append(matched, withLockfileFlavor(b))
+		}
+	}
+	return matched
 }
 
 // GetBackendNames returns a slice of the canonical names (e.g.
@@ -42,3 +42,53 @@ func TestGetBackends(t *testing.T) {
 		os.Remove(tmpfile)
 	}
 }
+
+func TestGetBackendOwnsSpecfile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "TestGetBackendOwnsSpecfile")
+	if err != nil {
+		t.Fatalf("failed to create a temp directory %v", err)
+	}
+	defer os.RemoveAll(dir)
+	if err := os.Chdir(dir); err != nil {
+		t.Fatalf("failed to change to directory: %s err: %v", dir, err)
+	}
+
+	contentsToBackend := map[string]string{
+		"[tool.poetry]\nname = \"demo\"\n":                                 "python-python3-poetry",
+		"[project]\nname = \"demo\"\n\n[tool.pdm]\ndistribution = false\n": "python-python3-pdm",
+	}
+	for contents, backend := range contentsToBackend {
+		if err := ioutil.WriteFile("pyproject.toml", []byte(contents), 0666); err != nil {
+			t.Fatalf("failed to write pyproject.toml: %v", err)
+		}
+		if actualBackend := GetBackend(""); backend != actualBackend.Name {
+			t.Errorf("expected backend: %s but got backend %s", backend, actualBackend.Name)
+		}
+	}
+}
+
+func TestGetBackendLockfileFlavors(t *testing.T) {
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	for _, file := range []string{"package.json", "package-lock.json"} {
+		if err := ioutil.WriteFile(file, []byte("{}"), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if b := GetBackend("nodejs"); b.Lockfile != "package-lock.json" {
+		t.Errorf("expected package-lock.json but got %s", b.Lockfile)
+	}
+
+	// NPM prefers a shrinkwrap, and so does UPM.
+	if err := ioutil.WriteFile("npm-shrinkwrap.json", []byte("{}"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	b := GetBackend("nodejs")
+	if b.Name != "nodejs-npm" || b.Lockfile != "npm-shrinkwrap.json" {
+		t.Errorf("expected nodejs-npm with npm-shrinkwrap.json but got %s with %s", b.Name, b.Lockfile)
+	}
+	if ignored := IgnoredLockfiles(b); len(ignored) != 1 || ignored[0] != "package-lock.json" {
+		t.Errorf("expected package-lock.json to be ignored but got %v", ignored)
+	}
+}
@@ -0,0 +1,308 @@
+// Package cpp provides backends for C and C++.
+package cpp
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/hashicorp/go-version"
+	"github.com/replit/upm/internal/api"
+	"github.com/replit/upm/internal/util"
+)
+
+// cppPatterns is the FilenamePatterns value for the C and C++
+// backends.
+var cppPatterns = []string{"*.c", "*.cc", "*.cpp", "*.cxx", "*.h", "*.hh", "*.hpp"}
+
+// conanCenterURL is the base URL of the ConanCenter remote, which
+// implements the Conan REST API.
+const conanCenterURL = "https://center2.conan.io/v2/conans/"
+
+// conanIndexURL is the base URL of the conan-center-index repository,
+// which holds the recipes for every package on ConanCenter.
+const conanIndexURL = "https://raw.githubusercontent.com/conan-io/conan-center-index/master/recipes/"
+
+// conanSearchResults represents the response we get from ConanCenter
+// when searching.
+type conanSearchResults struct {
+	// References such as zlib/1.2.13, possibly followed by
+	// @user/channel (or @_/_).
+	Results []string `json:"results"`
+}
+
+// conanFindSpecfile returns the name of the Conan recipe in the
+// current directory, which is conanfile.py if that exists and
+// conanfile.txt otherwise (Conan itself prefers conanfile.py if there
+// are both).
+func conanFindSpecfile() string {
+	if util.Exists("conanfile.py") {
+		return "conanfile.py"
+	}
+	return "conanfile.txt"
+}
+
+// conanGet fetches the given URL and returns the response body, or nil
+// if there is no such resource.
+func conanGet(ctx context.Context, url string) []byte {
+	resp, err := util.HTTPGet(ctx, url)
+	if err != nil {
+		util.Die("ConanCenter: %s", err)
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case 200:
+		break
+	case 404:
+		return nil
+	default:
+		util.Die("ConanCenter: HTTP status %d", resp.StatusCode)
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		util.Die("ConanCenter: %s", err)
+	}
+	return body
+}
+
+// conanSearchVersions searches ConanCenter with the given pattern and
+// returns the latest version of each matching package, in order of
+// first appearance.
+func conanSearchVersions(ctx context.Context, pattern string) ([]api.PkgName, map[api.PkgName]string) {
+	body := conanGet(ctx, conanCenterURL+"search?q="+url.QueryEscape(pattern))
+	names := []api.PkgName{}
+	latest := map[api.PkgName]string{}
+	if body == nil {
+		return names, latest
+	}
+
+	var results conanSearchResults
+	if err := json.Unmarshal(body, &results); err != nil {
+		util.Die("ConanCenter response: %s", err)
+	}
+
+	for _, ref := range results.Results {
+		name, spec := parseRef(strings.SplitN(ref, "@", 2)[0])
+		current, ok := latest[name]
+		if !ok {
+			names = append(names, name)
+			latest[name] = string(spec)
+			continue
+		}
+		// Some versions (e.g. cci.20230101) aren't numbers, in
+		// which case we keep whichever came first.
+		v1, err1 := version.NewVersion(current)
+		v2, err2 := version.NewVersion(string(spec))
+		if err2 == nil && (err1 != nil || v2.GreaterThan(v1)) {
+			latest[name] = string(spec)
+		}
+	}
+	return names, latest
+}
+
+// conanSearch implements Search for Conan.
+func conanSearch(ctx context.Context, query string) []api.PkgInfo {
+	names, latest := conanSearchVersions(ctx, "*"+query+"*")
+	results := []api.PkgInfo{}
+	for _, name := range names {
+		results = append(results, api.PkgInfo{
+			Name:    string(name),
+			Version: latest[name],
+		})
+	}
+	return results
+}
+
+// conanRecipeFieldRegexp returns a regexp matching a string-valued
+// attribute with the given key in a recipe.
+func conanRecipeFieldRegexp(key string) *regexp.Regexp {
+	return regexp.MustCompile(`(?m)^[ \t]+` + key + `[ \t]*=[ \t]*(?:"([^"]*)"|'([^']*)')`)
+}
+
+// conanFolderRegexp returns a regexp matching the entry for the given
+// version in a config.yml file from conan-center-index, capturing the
+// folder that holds its recipe.
+func conanFolderRegexp(version string) *regexp.Regexp {
+	return regexp.MustCompile(
+		`(?m)^[ \t]+["']?` + regexp.QuoteMeta(version) + `["']?:[ \t]*\n[ \t]+folder:[ \t]*["']?([^"'\s]+)`,
+	)
+}
+
+// conanInfo implements Info for Conan. The version comes from
+// ConanCenter, and the rest of the metadata from the recipe for that
+// version in conan-center-index.
+func conanInfo(ctx context.Context, name api.PkgName) api.PkgInfo {
+	_, latest := conanSearchVersions(ctx, string(name)+"/*")
+	v, ok := latest[name]
+	if !ok {
+		return api.PkgInfo{}
+	}
+
+	result := api.PkgInfo{
+		Name:             string(name),
+		Version:          v,
+		DocumentationURL: "https://conan.io/center/recipes/" + util.EscapePathSegment(string(name)),
+	}
+
+	recipeURL := conanIndexURL + util.EscapePathSegment(string(name)) + "/"
+	config := conanGet(ctx, recipeURL+"config.yml")
+	if config == nil {
+		return result
+	}
+	m := conanFolderRegexp(v).FindSubmatch(config)
+	if m == nil {
+		return result
+	}
+	recipe := conanGet(ctx, recipeURL+util.EscapePathSegment(string(m[1]))+"/conanfile.py")
+	if recipe == nil {
+		return result
+	}
+
+	field := func(key string) string {
+		if m := conanRecipeFieldRegexp(key).FindStringSubmatch(string(recipe)); m != nil {
+			return m[1] + m[2]
+		}
+		return ""
+	}
+	result.Description = field("description")
+	result.HomepageURL = field("homepage")
+	result.SourceCodeURL = field("url")
+	result.License = field("license")
+	result.Author = field("author")
+
+	deps := []string{}
+	for dep := range listPySpecfile(string(recipe)) {
+		deps = append(deps, string(dep))
+	}
+	result.Dependencies = deps
+
+	return result
+}
+
+// conanReadSpecfile returns the name and contents of the Conan recipe.
+func conanReadSpecfile() (string, string) {
+	specfile := conanFindSpecfile()
+	contentsB, err := ioutil.ReadFile(specfile)
+	if err != nil {
+		util.Die("%s: %s", specfile, err)
+	}
+	return specfile, string(contentsB)
+}
+
+// conanAdd implements Add for Conan. Conan requires every reference to
+// have a version, so packages without a spec are pinned to the latest
+// version on ConanCenter.
+func conanAdd(ctx context.Context, pkgs map[api.PkgName]api.PkgSpec, projectName string) {
+	specfile := conanFindSpecfile()
+	contents := ""
+	if util.Exists(specfile) {
+		_, contents = conanReadSpecfile()
+	} else {
+		// A recipe that works with CMake out of the box,
+		// which is what 'conan new cmake_exe' would generate.
+		contents = "[generators]\nCMakeDeps\nCMakeToolchain\n\n[layout]\ncmake_layout\n"
+	}
+
+	versioned := map[api.PkgName]api.PkgSpec{}
+	for name, spec := range pkgs {
+		if spec == "" {
+			_, latest := conanSearchVersions(ctx, string(name)+"/*")
+			v, ok := latest[name]
+			if !ok {
+				util.Die("no such package on ConanCenter: %s", name)
+			}
+			spec = api.PkgSpec(v)
+		}
+		versioned[name] = spec
+	}
+
+	if specfile == "conanfile.py" {
+		contents = addToPySpecfile(contents, versioned)
+	} else {
+		contents = addToTxtSpecfile(contents, versioned)
+	}
+	util.ProgressMsg("write " + specfile)
+	util.TryWriteAtomic(specfile, []byte(contents))
+}
+
+// conanRemove implements Remove for Conan.
+func conanRemove(ctx context.Context, pkgs map[api.PkgName]bool) {
+	specfile, contents := conanReadSpecfile()
+	if specfile == "conanfile.py" {
+		contents = removeFromPySpecfile(contents, pkgs)
+	} else {
+		contents = removeFromTxtSpecfile(contents, pkgs)
+	}
+	util.ProgressMsg("write " + specfile)
+	util.TryWriteAtomic(specfile, []byte(contents))
+}
+
+// conanDetectProfile creates the default Conan profile for this
+// machine, which Conan needs before it can resolve anything, unless
+// there is one already.
+func conanDetectProfile(ctx context.Context) {
+	util.RunCmd(ctx, []string{"conan", "profile", "detect", "--exist-ok"})
+}
+
+// ConanBackend is a UPM backend for C and C++ that uses Conan 2.x.
+var ConanBackend = api.LanguageBackend{
+	Name:             "cpp-conan",
+	Specfile:         conanFindSpecfile(),
+	Lockfile:         "conan.lock",
+	FilenamePatterns: cppPatterns,
+	Quirks:           api.QuirksNone,
+	Executables:      []string{"conan"},
+	GetPackageDir: func() string {
+		// Conan keeps packages in its cache rather than in
+		// the project.
+		if home := os.Getenv("CONAN_HOME"); home != "" {
+			return filepath.Join(home, "p")
+		}
+		home, err := os.UserHomeDir()
+		if err != nil {
+			util.Die("%s", err)
+		}
+		return filepath.Join(home, ".conan2", "p")
+	},
+	Search: conanSearch,
+	Info:   conanInfo,
+	Add:    conanAdd,
+	Remove: conanRemove,
+	Lock: func(ctx context.Context) {
+		conanDetectProfile(ctx)
+		util.RunCmd(ctx, []string{
+			"conan", "lock", "create", ".", "--lockfile-out=conan.lock",
+		})
+	},
+	Install: func(ctx context.Context) {
+		conanDetectProfile(ctx)
+		util.RunCmd(ctx, []string{
+			"conan", "install", ".", "--lockfile=conan.lock", "--build=missing",
+		})
+	},
+	ListSpecfile: func() map[api.PkgName]api.PkgSpec {
+		specfile, contents := conanReadSpecfile()
+		if specfile == "conanfile.py" {
+			return listPySpecfile(contents)
+		}
+		return listTxtSpecfile(contents)
+	},
+	ListLockfile: func() map[api.PkgName]api.PkgVersion {
+		contentsB, err := ioutil.ReadFile("conan.lock")
+		if err != nil {
+			util.Die("conan.lock: %s", err)
+		}
+		return listConanLockfile(contentsB)
+	},
+	Guess: func(ctx context.Context) (map[api.PkgName]bool, bool) {
+		util.NotImplemented()
+		return nil, false
+	},
+}
@@ -0,0 +1,125 @@
+package cpp
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/replit/upm/internal/api"
+)
+
+const testConanfileTxt = `[requires]
+zlib/1.2.13
+fmt/[>=9 <10] # formatting
+
+[tool_requires]
+cmake/3.27.1
+
+[generators]
+CMakeDeps
+`
+
+const testConanfilePy = `from conan import ConanFile
+
+
+class AppConan(ConanFile):
+    settings = "os", "compiler", "build_type", "arch"
+    requires = "zlib/1.2.13", "fmt/9.1.0"
+    tool_requires = ("cmake/3.27.1",)
+
+    def requirements(self):
+        self.requires("boost/1.83.0")
+`
+
+func TestListTxtSpecfile(t *testing.T) {
+	require.Equal(t, map[api.PkgName]api.PkgSpec{
+		"zlib":  "1.2.13",
+		"fmt":   "[>=9 <10]",
+		"cmake": "3.27.1",
+	}, listTxtSpecfile(testConanfileTxt))
+}
+
+func TestAddAndRemoveTxt(t *testing.T) {
+	contents := addToTxtSpecfile(testConanfileTxt, map[api.PkgName]api.PkgSpec{
+		"openssl": "3.1.2",
+	})
+	require.Contains(t, contents, "fmt/[>=9 <10] # formatting\nopenssl/3.1.2\n\n[tool_requires]")
+
+	contents = removeFromTxtSpecfile(contents, map[api.PkgName]bool{
+		"zlib":  true,
+		"cmake": true,
+	})
+	require.Equal(t, map[api.PkgName]api.PkgSpec{
+		"fmt":     "[>=9 <10]",
+		"openssl": "3.1.2",
+	}, listTxtSpecfile(contents))
+	require.Contains(t, contents, "[generators]\nCMakeDeps\n")
+
+	require.Equal(t, "[requires]\nzlib/1.2.13\n", addToTxtSpecfile("", map[api.PkgName]api.PkgSpec{
+		"zlib": "1.2.13",
+	}))
+}
+
+func TestListPySpecfile(t *testing.T) {
+	require.Equal(t, map[api.PkgName]api.PkgSpec{
+		"zlib":  "1.2.13",
+		"fmt":   "9.1.0",
+		"cmake": "3.27.1",
+		"boost": "1.83.0",
+	}, listPySpecfile(testConanfilePy))
+}
+
+func TestAddAndRemovePy(t *testing.T) {
+	contents := addToPySpecfile(testConanfilePy, map[api.PkgName]api.PkgSpec{
+		"openssl": "3.1.2",
+	})
+	require.Contains(t, contents, "        self.requires(\"boost/1.83.0\")\n        self.requires(\"openssl/3.1.2\")\n")
+
+	contents = removeFromPySpecfile(contents, map[api.PkgName]bool{
+		"fmt":     true,
+		"cmake":   true,
+		"boost":   true,
+		"openssl": true,
+	})
+	require.Contains(t, contents, "    requires = \"zlib/1.2.13\"\n")
+	require.Contains(t, contents, "    tool_requires = ()\n")
+	require.Contains(t, contents, "    def requirements(self):\n        pass\n")
+	require.Equal(t, map[api.PkgName]api.PkgSpec{
+		"zlib": "1.2.13",
+	}, listPySpecfile(contents))
+
+	// Without a requirements method, the requires attribute is
+	// used instead.
+	contents = addToPySpecfile(`from conan import ConanFile
+
+class AppConan(ConanFile):
+    requires = ["zlib/1.2.13"]
+`, map[api.PkgName]api.PkgSpec{"fmt": "9.1.0"})
+	require.Contains(t, contents, `    requires = ["zlib/1.2.13", "fmt/9.1.0"]`)
+
+	contents = addToPySpecfile(`from conan import ConanFile
+
+class AppConan(ConanFile):
+    settings = "os"
+`, map[api.PkgName]api.PkgSpec{"fmt": "9.1.0"})
+	require.Contains(t, contents, "class AppConan(ConanFile):\n    requires = \"fmt/9.1.0\"\n    settings")
+}
+
+func TestListConanLockfile(t *testing.T) {
+	contents := `{
+    "version": "0.5",
+    "requires": [
+        "zlib/1.2.13#97d5730b529b4224045fe7090592d4c1%1692672717.049",
+        "fmt/9.1.0#e747928f85b03f48aaf227ff897d9634%1694389706.827"
+    ],
+    "build_requires": [
+        "cmake/3.27.1#6b5d0d4a4e1a8b6c3e1b5b1c0f4c5c5e%1691044388.38"
+    ],
+    "python_requires": []
+}`
+	require.Equal(t, map[api.PkgName]api.PkgVersion{
+		"zlib":  "1.2.13",
+		"fmt":   "9.1.0",
+		"cmake": "3.27.1",
+	}, listConanLockfile([]byte(contents)))
+}
@@ -0,0 +1,379 @@
+package cpp
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/replit/upm/internal/api"
+	"github.com/replit/upm/internal/util"
+)
+
+// conanRequireSections are the sections of conanfile.txt, and the
+// attributes and methods of conanfile.py, that declare dependencies.
+// New dependencies always go in the first one.
+var conanRequireSections = []string{"requires", "tool_requires", "test_requires"}
+
+// conanLock represents the relevant parts of a conan.lock file.
+type conanLock struct {
+	Requires       []string `json:"requires"`
+	BuildRequires  []string `json:"build_requires"`
+	PythonRequires []string `json:"python_requires"`
+}
+
+// parseRef splits a Conan reference such as zlib/1.2.13 or
+// fmt/[>=9 <10]@user/channel into the package name and everything
+// after it.
+func parseRef(ref string) (api.PkgName, api.PkgSpec) {
+	parts := strings.SplitN(strings.TrimSpace(ref), "/", 2)
+	if len(parts) == 1 {
+		return api.PkgName(parts[0]), ""
+	}
+	return api.PkgName(parts[0]), api.PkgSpec(parts[1])
+}
+
+// makeRef is the inverse of parseRef.
+func makeRef(name api.PkgName, spec api.PkgSpec) string {
+	return string(name) + "/" + string(spec)
+}
+
+// sortedRefs returns the references for the given packages, sorted so
+// that the specfile is written deterministically.
+func sortedRefs(pkgs map[api.PkgName]api.PkgSpec) []string {
+	refs := []string{}
+	for name, spec := range pkgs {
+		refs = append(refs, makeRef(name, spec))
+	}
+	sort.Strings(refs)
+	return refs
+}
+
+// txtSectionRegexp matches a section header in conanfile.txt.
+var txtSectionRegexp = regexp.MustCompile(`^\s*\[([a-z_]+)\]\s*$`)
+
+// isRequireSection returns true if the given conanfile.txt section
+// declares dependencies.
+func isRequireSection(section string) bool {
+	for _, s := range conanRequireSections {
+		if section == s {
+			return true
+		}
+	}
+	return false
+}
+
+// listTxtSpecfile returns the dependencies in the given conanfile.txt
+// contents.
+func listTxtSpecfile(contents string) map[api.PkgName]api.PkgSpec {
+	pkgs := map[api.PkgName]api.PkgSpec{}
+	section := ""
+	for _, line := range strings.Split(contents, "\n") {
+		if m := txtSectionRegexp.FindStringSubmatch(line); m != nil {
+			section = m[1]
+			continue
+		}
+		line = strings.TrimSpace(strings.SplitN(line, "#", 2)[0])
+		if line == "" || !isRequireSection(section) {
+			continue
+		}
+		name, spec := parseRef(line)
+		pkgs[name] = spec
+	}
+	return pkgs
+}
+
+// addToTxtSpecfile returns the given conanfile.txt contents with the
+// given dependencies added to the [requires] section, which is
+// created if necessary.
+func addToTxtSpecfile(contents string, pkgs map[api.PkgName]api.PkgSpec) string {
+	lines := strings.Split(contents, "\n")
+	refs := sortedRefs(pkgs)
+
+	// Find the end of the [requires] section, ignoring any blank
+	// lines before the next section.
+	start := -1
+	end := -1
+	for i, line := range lines {
+		if m := txtSectionRegexp.FindStringSubmatch(line); m != nil {
+			if start != -1 {
+				break
+			}
+			if m[1] == "requires" {
+				start = i
+				end = i + 1
+			}
+			continue
+		}
+		if start != -1 && strings.TrimSpace(line) != "" {
+			end = i + 1
+		}
+	}
+
+	if start == -1 {
+		section := "[requires]\n" + strings.Join(refs, "\n") + "\n"
+		if strings.TrimSpace(contents) == "" {
+			return section
+		}
+		return section + "\n" + contents
+	}
+
+	result := append([]string{}, lines[:end]...)
+	result = append(result, refs...)
+	return strings.Join(append(result, lines[end:]...), "\n")
+}
+
+// removeFromTxtSpecfile returns the given conanfile.txt contents with
+// the given dependencies deleted.
+func removeFromTxtSpecfile(contents string, pkgs map[api.PkgName]bool) string {
+	result := []string{}
+	section := ""
+	for _, line := range strings.Split(contents, "\n") {
+		if m := txtSectionRegexp.FindStringSubmatch(line); m != nil {
+			section = m[1]
+		} else if ref := strings.TrimSpace(strings.SplitN(line, "#", 2)[0]); ref != "" && isRequireSection(section) {
+			if name, _ := parseRef(ref); pkgs[name] {
+				continue
+			}
+		}
+		result = append(result, line)
+	}
+	return strings.Join(result, "\n")
+}
+
+// pyStringRegexp matches a Python string literal, without escapes.
+var pyStringRegexp = regexp.MustCompile(`"([^"\\]*)"|'([^'\\]*)'`)
+
+// pyString returns the contents of a match of pyStringRegexp.
+func pyString(match []string) string {
+	if match[1] != "" {
+		return match[1]
+	}
+	return match[2]
+}
+
+// pyRequireCallRegexp matches a call such as self.requires("zlib/1.2.13")
+// on a line of its own. The first capture group is the indentation
+// and the second is the reference.
+var pyRequireCallRegexp = regexp.MustCompile(
+	`(?m)^([ \t]*)self\.(?:` + strings.Join(conanRequireSections, "|") +
+		`)\(\s*(?:"([^"]*)"|'([^']*)')[^\n]*\n?`,
+)
+
+// pyRequireAttrRegexp matches the start of an attribute such as
+// requires = "zlib/1.2.13", "fmt/9.1.0" in a ConanFile class. The
+// first capture group is the name of the attribute.
+var pyRequireAttrRegexp = regexp.MustCompile(
+	`(?m)^[ \t]+(` + strings.Join(conanRequireSections, "|") + `)[ \t]*=[ \t]*`,
+)
+
+// pyRequirementsMethodRegexp matches the line declaring the
+// requirements method. The capture group is its indentation.
+var pyRequirementsMethodRegexp = regexp.MustCompile(`(?m)^([ \t]*)def requirements\(self\):[^\n]*\n`)
+
+// pyClassRegexp matches the line declaring a ConanFile class.
+var pyClassRegexp = regexp.MustCompile(`(?m)^class\s+\w+\s*\([^)]*ConanFile[^)]*\)\s*:[^\n]*\n`)
+
+// pyAttr is an attribute declaring dependencies in conanfile.py.
+type pyAttr struct {
+	// name is requires, tool_requires, or test_requires.
+	name string
+	// start and end delimit the value of the attribute.
+	start, end int
+	// open and close are the brackets around the value, or
+	// empty strings if it is a bare string or tuple.
+	open, close string
+	refs        []string
+}
+
+// findPyAttrs returns every attribute declaring dependencies in the
+// given conanfile.py contents.
+func findPyAttrs(contents string) []pyAttr {
+	attrs := []pyAttr{}
+	for _, loc := range pyRequireAttrRegexp.FindAllStringSubmatchIndex(contents, -1) {
+		attr := pyAttr{name: contents[loc[2]:loc[3]], start: loc[1]}
+		if attr.start < len(contents) && (contents[attr.start] == '(' || contents[attr.start] == '[') {
+			attr.open = contents[attr.start : attr.start+1]
+			attr.close = map[string]string{"(": ")", "[": "]"}[attr.open]
+			end := strings.Index(contents[attr.start:], attr.close)
+			if end == -1 {
+				continue
+			}
+			attr.end = attr.start + end + 1
+		} else {
+			end := strings.IndexByte(contents[attr.start:], '\n')
+			if end == -1 {
+				end = len(contents) - attr.start
+			}
+			attr.end = attr.start + end
+		}
+		for _, match := range pyStringRegexp.FindAllStringSubmatch(contents[attr.start:attr.end], -1) {
+			attr.refs = append(attr.refs, pyString(match))
+		}
+		attrs = append(attrs, attr)
+	}
+	return attrs
+}
+
+// formatPyAttr returns the Python source for the value of the given
+// attribute, with the given references.
+func formatPyAttr(attr pyAttr, refs []string) string {
+	quoted := []string{}
+	for _, ref := range refs {
+		quoted = append(quoted, fmt.Sprintf("%q", ref))
+	}
+	value := strings.Join(quoted, ", ")
+	switch {
+	case attr.open == "(" && len(refs) == 1:
+		return "(" + value + ",)"
+	case attr.open != "":
+		return attr.open + value + attr.close
+	case len(refs) == 0:
+		return "()"
+	default:
+		return value
+	}
+}
+
+// listPySpecfile returns the dependencies declared in the given
+// conanfile.py contents. Since the recipe is a Python program, only
+// the common ways of declaring dependencies are understood: the
+// requires, tool_requires, and test_requires attributes, and calls to
+// the methods of the same names with a string literal.
+func listPySpecfile(contents string) map[api.PkgName]api.PkgSpec {
+	pkgs := map[api.PkgName]api.PkgSpec{}
+	for _, attr := range findPyAttrs(contents) {
+		for _, ref := range attr.refs {
+			name, spec := parseRef(ref)
+			pkgs[name] = spec
+		}
+	}
+	for _, match := range pyRequireCallRegexp.FindAllStringSubmatch(contents, -1) {
+		name, spec := parseRef(match[2] + match[3])
+		pkgs[name] = spec
+	}
+	return pkgs
+}
+
+// addToPySpecfile returns the given conanfile.py contents with the
+// given dependencies added. They go after the last self.requires()
+// call if there is one, otherwise at the start of the requirements
+// method, otherwise in the requires attribute, which is created if
+// necessary.
+func addToPySpecfile(contents string, pkgs map[api.PkgName]api.PkgSpec) string {
+	refs := sortedRefs(pkgs)
+
+	calls := func(indent string) string {
+		result := ""
+		for _, ref := range refs {
+			result += fmt.Sprintf("%sself.requires(%q)\n", indent, ref)
+		}
+		return result
+	}
+
+	if locs := pyRequireCallRegexp.FindAllStringSubmatchIndex(contents, -1); len(locs) > 0 {
+		last := locs[len(locs)-1]
+		indent := contents[last[2]:last[3]]
+		end := last[1]
+		prefix := contents[:end]
+		if !strings.HasSuffix(prefix, "\n") {
+			prefix += "\n"
+		}
+		return prefix + calls(indent) + contents[end:]
+	}
+
+	if loc := pyRequirementsMethodRegexp.FindStringSubmatchIndex(contents); loc != nil {
+		indent := contents[loc[2]:loc[3]] + "    "
+		return contents[:loc[1]] + calls(indent) + contents[loc[1]:]
+	}
+
+	for _, attr := range findPyAttrs(contents) {
+		if attr.name == "requires" {
+			value := formatPyAttr(attr, append(attr.refs, refs...))
+			return contents[:attr.start] + value + contents[attr.end:]
+		}
+	}
+
+	loc := pyClassRegexp.FindStringIndex(contents)
+	if loc == nil {
+		util.Die("conanfile.py: no ConanFile class found")
+	}
+	value := formatPyAttr(pyAttr{}, refs)
+	return contents[:loc[1]] + "    requires = " + value + "\n" + contents[loc[1]:]
+}
+
+// removeFromPySpecfile returns the given conanfile.py contents with
+// the given dependencies deleted, wherever they are declared.
+func removeFromPySpecfile(contents string, pkgs map[api.PkgName]bool) string {
+	contents = pyRequireCallRegexp.ReplaceAllStringFunc(contents, func(call string) string {
+		m := pyRequireCallRegexp.FindStringSubmatch(call)
+		if name, _ := parseRef(m[2] + m[3]); pkgs[name] {
+			return ""
+		}
+		return call
+	})
+
+	// The values change length as they are rewritten, so go
+	// backwards to keep the offsets of earlier ones valid.
+	attrs := findPyAttrs(contents)
+	for i := len(attrs) - 1; i >= 0; i-- {
+		attr := attrs[i]
+		refs := []string{}
+		for _, ref := range attr.refs {
+			if name, _ := parseRef(ref); !pkgs[name] {
+				refs = append(refs, ref)
+			}
+		}
+		if len(refs) != len(attr.refs) {
+			contents = contents[:attr.start] + formatPyAttr(attr, refs) + contents[attr.end:]
+		}
+	}
+
+	// Removing every call from the requirements method would
+	// leave it without a body, which is a syntax error.
+	if loc := pyRequirementsMethodRegexp.FindStringSubmatchIndex(contents); loc != nil {
+		indent := contents[loc[2]:loc[3]]
+		body := contents[loc[1]:]
+		empty := true
+		for _, line := range strings.Split(body, "\n") {
+			if strings.TrimSpace(line) == "" {
+				continue
+			}
+			trimmed := strings.TrimLeft(line, " \t")
+			empty = len(line)-len(trimmed) <= len(indent)
+			break
+		}
+		if empty {
+			contents = contents[:loc[1]] + indent + "    pass\n" + body
+		}
+	}
+
+	return contents
+}
+
+// listConanLockfile returns the locked versions in the given
+// conan.lock contents, including those of build and Python
+// requirements.
+func listConanLockfile(contents []byte) map[api.PkgName]api.PkgVersion {
+	var lock conanLock
+	if err := json.Unmarshal(contents, &lock); err != nil {
+		util.Die("conan.lock: %s", err)
+	}
+
+	pkgs := map[api.PkgName]api.PkgVersion{}
+	for _, refs := range [][]string{lock.Requires, lock.BuildRequires, lock.PythonRequires} {
+		for _, ref := range refs {
+			// References are locked with their revision,
+			// e.g. zlib/1.2.13#97d5730b%1692672717.049.
+			name, spec := parseRef(ref)
+			version := strings.FieldsFunc(string(spec), func(r rune) bool {
+				return r == '#' || r == '@'
+			})
+			if len(version) > 0 {
+				pkgs[name] = api.PkgVersion(version[0])
+			}
+		}
+	}
+	return pkgs
+}
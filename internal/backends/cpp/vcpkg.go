@@ -0,0 +1,278 @@
+package cpp
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/replit/upm/internal/api"
+	"github.com/replit/upm/internal/util"
+)
+
+// vcpkgRegistryURL is the base URL of the vcpkg registry, which is the
+// microsoft/vcpkg repository. It is followed by a commit or branch.
+const vcpkgRegistryURL = "https://raw.githubusercontent.com/microsoft/vcpkg/"
+
+// vcpkgInstallRoot is the directory, relative to the project, into
+// which vcpkg installs ports in manifest mode.
+const vcpkgInstallRoot = "vcpkg_installed"
+
+// vcpkgPackagesFile is the lockfile for vcpkg. vcpkg has no lockfile
+// of its own (versions are pinned by the builtin-baseline in
+// vcpkg.json instead), so this just records what was installed.
+const vcpkgPackagesFile = "vcpkg-packages.txt"
+
+// vcpkgBaseline represents the versions/baseline.json file in the
+// registry, which holds the version of every port at a given commit.
+type vcpkgBaseline struct {
+	Default map[string]struct {
+		Baseline    string `json:"baseline"`
+		PortVersion int    `json:"port-version"`
+	} `json:"default"`
+}
+
+// vcpkgPortManifest represents the relevant parts of the vcpkg.json
+// file of a port in the registry.
+type vcpkgPortManifest struct {
+	// Either a string or a list of strings (one per paragraph).
+	Description interface{}       `json:"description"`
+	Homepage    string            `json:"homepage"`
+	License     string            `json:"license"`
+	Maintainers interface{}       `json:"maintainers"`
+	Deps        []json.RawMessage `json:"dependencies"`
+}
+
+// joinStrings returns the given value, which is either a string or a
+// list of strings, as a single string.
+func joinStrings(value interface{}, sep string) string {
+	switch value := value.(type) {
+	case string:
+		return value
+	case []interface{}:
+		strs := []string{}
+		for _, s := range value {
+			if s, ok := s.(string); ok {
+				strs = append(strs, s)
+			}
+		}
+		return strings.Join(strs, sep)
+	}
+	return ""
+}
+
+// vcpkgReadManifest returns the contents of vcpkg.json.
+func vcpkgReadManifest() []byte {
+	contentsB, err := ioutil.ReadFile("vcpkg.json")
+	if err != nil {
+		util.Die("vcpkg.json: %s", err)
+	}
+	return contentsB
+}
+
+// vcpkgRegistryRef returns the commit of the registry that the project
+// is pinned to, so that search results match what would actually be
+// installed, or the default branch if there is none.
+func vcpkgRegistryRef() string {
+	if util.Exists("vcpkg.json") {
+		if baseline := parseVcpkgManifest(vcpkgReadManifest()).BuiltinBaseline; baseline != "" {
+			return baseline
+		}
+	}
+	return "master"
+}
+
+// vcpkgGet fetches the given path from the registry, at the commit
+// returned by vcpkgRegistryRef, and returns the response body, or nil
+// if there is no such file.
+func vcpkgGet(ctx context.Context, path string) []byte {
+	resp, err := util.HTTPGet(ctx, vcpkgRegistryURL+vcpkgRegistryRef()+"/"+path)
+	if err != nil {
+		util.Die("vcpkg registry: %s", err)
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case 200:
+		break
+	case 404:
+		return nil
+	default:
+		util.Die("vcpkg registry: HTTP status %d", resp.StatusCode)
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		util.Die("vcpkg registry: %s", err)
+	}
+	return body
+}
+
+// vcpkgGetBaseline returns the version of every port in the registry.
+func vcpkgGetBaseline(ctx context.Context) map[api.PkgName]string {
+	body := vcpkgGet(ctx, "versions/baseline.json")
+	if body == nil {
+		util.Die("vcpkg registry: no versions/baseline.json at %s", vcpkgRegistryRef())
+	}
+	var baseline vcpkgBaseline
+	if err := json.Unmarshal(body, &baseline); err != nil {
+		util.Die("vcpkg registry response: %s", err)
+	}
+
+	versions := map[api.PkgName]string{}
+	for name, entry := range baseline.Default {
+		version := entry.Baseline
+		if entry.PortVersion != 0 {
+			version += "#" + strconv.Itoa(entry.PortVersion)
+		}
+		versions[api.PkgName(name)] = version
+	}
+	return versions
+}
+
+// vcpkgSearch implements Search for vcpkg. The registry has no search
+// API, so this looks for the query in the names of all the ports,
+// with exact matches first.
+func vcpkgSearch(ctx context.Context, query string) []api.PkgInfo {
+	query = strings.ToLower(strings.TrimSpace(query))
+	versions := vcpkgGetBaseline(ctx)
+
+	names := []string{}
+	for name := range versions {
+		if strings.Contains(string(name), query) {
+			names = append(names, string(name))
+		}
+	}
+	sort.Slice(names, func(i, j int) bool {
+		if (names[i] == query) != (names[j] == query) {
+			return names[i] == query
+		}
+		return names[i] < names[j]
+	})
+
+	results := []api.PkgInfo{}
+	for _, name := range names {
+		results = append(results, api.PkgInfo{
+			Name:    name,
+			Version: versions[api.PkgName(name)],
+		})
+	}
+	return results
+}
+
+// vcpkgInfo implements Info for vcpkg, using the port's own manifest
+// from the registry.
+func vcpkgInfo(ctx context.Context, name api.PkgName) api.PkgInfo {
+	body := vcpkgGet(ctx, "ports/"+util.EscapePathSegment(string(name))+"/vcpkg.json")
+	if body == nil {
+		return api.PkgInfo{}
+	}
+	var port vcpkgPortManifest
+	if err := json.Unmarshal(body, &port); err != nil {
+		util.Die("vcpkg registry response: %s", err)
+	}
+
+	deps := []string{}
+	for _, raw := range port.Deps {
+		dep, _ := parseVcpkgDependency(raw)
+		// These are helpers for building ports, which are
+		// never of interest to the user.
+		if !strings.HasPrefix(string(dep), "vcpkg-") {
+			deps = append(deps, string(dep))
+		}
+	}
+
+	return api.PkgInfo{
+		Name:             string(name),
+		Description:      joinStrings(port.Description, " "),
+		Version:          vcpkgGetBaseline(ctx)[name],
+		HomepageURL:      port.Homepage,
+		DocumentationURL: "https://vcpkg.io/en/package/" + util.EscapePathSegment(string(name)),
+		License:          port.License,
+		Author:           joinStrings(port.Maintainers, ", "),
+		Dependencies:     deps,
+	}
+}
+
+// vcpkgAdd implements Add for vcpkg. If the manifest is not pinned to
+// a version of the registry yet, it is pinned to the current one, so
+// that installs are reproducible.
+func vcpkgAdd(ctx context.Context, pkgs map[api.PkgName]api.PkgSpec, projectName string) {
+	contents := "{\n}\n"
+	if util.Exists("vcpkg.json") {
+		contents = string(vcpkgReadManifest())
+	}
+	contents = addToVcpkgManifest(contents, pkgs)
+	util.ProgressMsg("write vcpkg.json")
+	util.TryWriteAtomic("vcpkg.json", []byte(contents))
+
+	// A vcpkg-configuration.json sets the baseline of the default
+	// registry itself.
+	if parseVcpkgManifest([]byte(contents)).BuiltinBaseline == "" && !util.Exists("vcpkg-configuration.json") {
+		util.RunCmd(ctx, []string{"vcpkg", "x-update-baseline", "--add-initial-baseline"})
+	}
+}
+
+// vcpkgRemove implements Remove for vcpkg.
+func vcpkgRemove(ctx context.Context, pkgs map[api.PkgName]bool) {
+	contents := removeFromVcpkgManifest(string(vcpkgReadManifest()), pkgs)
+	util.ProgressMsg("write vcpkg.json")
+	util.TryWriteAtomic("vcpkg.json", []byte(contents))
+}
+
+// vcpkgInstall implements Install for vcpkg, and then writes the
+// ports that were installed (including indirect dependencies) to the
+// lockfile. In manifest mode, vcpkg also removes ports that are no
+// longer needed.
+func vcpkgInstall(ctx context.Context) {
+	util.RunCmd(ctx, []string{"vcpkg", "install", "--x-install-root=" + vcpkgInstallRoot})
+
+	status := filepath.Join(vcpkgInstallRoot, "vcpkg", "status")
+	pkgs := map[api.PkgName]api.PkgVersion{}
+	if util.Exists(status) {
+		contentsB, err := ioutil.ReadFile(status)
+		if err != nil {
+			util.Die("%s: %s", status, err)
+		}
+		pkgs = listVcpkgStatus(string(contentsB))
+	}
+
+	util.ProgressMsg("write " + vcpkgPackagesFile)
+	util.TryWriteAtomic(vcpkgPackagesFile, []byte(formatVcpkgPackages(pkgs)))
+}
+
+// VcpkgBackend is a UPM backend for C and C++ that uses vcpkg in
+// manifest mode.
+var VcpkgBackend = api.LanguageBackend{
+	Name:             "cpp-vcpkg",
+	Specfile:         "vcpkg.json",
+	Lockfile:         vcpkgPackagesFile,
+	FilenamePatterns: cppPatterns,
+	Quirks:           api.QuirksNotReproducible,
+	Executables:      []string{"vcpkg"},
+	GetPackageDir: func() string {
+		return vcpkgInstallRoot
+	},
+	Search:  vcpkgSearch,
+	Info:    vcpkgInfo,
+	Add:     vcpkgAdd,
+	Remove:  vcpkgRemove,
+	Install: vcpkgInstall,
+	ListSpecfile: func() map[api.PkgName]api.PkgSpec {
+		return listVcpkgManifest(vcpkgReadManifest())
+	},
+	ListLockfile: func() map[api.PkgName]api.PkgVersion {
+		contentsB, err := ioutil.ReadFile(vcpkgPackagesFile)
+		if err != nil {
+			util.Die("%s: %s", vcpkgPackagesFile, err)
+		}
+		return listVcpkgPackages(string(contentsB))
+	},
+	Guess: func(ctx context.Context) (map[api.PkgName]bool, bool) {
+		util.NotImplemented()
+		return nil, false
+	},
+}
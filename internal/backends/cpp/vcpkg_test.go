@@ -0,0 +1,112 @@
+package cpp
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/replit/upm/internal/api"
+)
+
+const testVcpkgManifest = `{
+  "name": "app",
+  "dependencies": [
+    "fmt",
+    {
+      "name": "boost-asio",
+      "version>=": "1.83.0",
+      "features": ["ssl"]
+    }
+  ],
+  "builtin-baseline": "3426db05b996481ca31e95fff3734cf23e0f51bc"
+}
+`
+
+func TestListVcpkgManifest(t *testing.T) {
+	require.Equal(t, map[api.PkgName]api.PkgSpec{
+		"fmt":        "",
+		"boost-asio": "1.83.0",
+	}, listVcpkgManifest([]byte(testVcpkgManifest)))
+}
+
+func TestAddAndRemoveVcpkg(t *testing.T) {
+	contents := addToVcpkgManifest(testVcpkgManifest, map[api.PkgName]api.PkgSpec{
+		"zlib":    "",
+		"sqlite3": "3.43.0",
+	})
+	require.Equal(t, `{
+  "name": "app",
+  "dependencies": [
+    "fmt",
+    {
+      "name": "boost-asio",
+      "version>=": "1.83.0",
+      "features": ["ssl"]
+    },
+    {
+      "name": "sqlite3",
+      "version>=": "3.43.0"
+    },
+    "zlib"
+  ],
+  "builtin-baseline": "3426db05b996481ca31e95fff3734cf23e0f51bc"
+}
+`, contents)
+
+	contents = removeFromVcpkgManifest(contents, map[api.PkgName]bool{
+		"fmt":        true,
+		"boost-asio": true,
+		"sqlite3":    true,
+	})
+	require.Equal(t, `{
+  "name": "app",
+  "dependencies": [
+    "zlib"
+  ],
+  "builtin-baseline": "3426db05b996481ca31e95fff3734cf23e0f51bc"
+}
+`, contents)
+
+	contents = removeFromVcpkgManifest(contents, map[api.PkgName]bool{"zlib": true})
+	require.Contains(t, contents, `"dependencies": [],`)
+
+	require.Equal(t, "{\n  \"dependencies\": [\n    \"fmt\"\n  ]\n}\n", addToVcpkgManifest("{\n}\n", map[api.PkgName]api.PkgSpec{
+		"fmt": "",
+	}))
+	require.Equal(t, "{\n  \"dependencies\": [\n    \"fmt\"\n  ],\n  \"name\": \"app\"}", addToVcpkgManifest(
+		`{"name": "app"}`, map[api.PkgName]api.PkgSpec{"fmt": ""},
+	))
+}
+
+func TestListVcpkgStatus(t *testing.T) {
+	contents := `Package: fmt
+Version: 10.1.1
+Port-Version: 0
+Architecture: x64-linux
+Multi-Arch: same
+Abi: 0c5b9ee8b2a83e0b6c6c9c0e93a25a2b7d95f8b7d5d8f1c0f3b4a2d1e0c9b8a7
+Status: install ok installed
+
+Package: curl
+Version: 8.4.0
+Port-Version: 2
+Architecture: x64-linux
+Status: install ok installed
+
+Package: curl
+Feature: ssl
+Architecture: x64-linux
+Status: install ok installed
+
+Package: zlib
+Version: 1.3
+Architecture: x64-linux
+Status: purge ok not-installed
+`
+	require.Equal(t, map[api.PkgName]api.PkgVersion{
+		"fmt":  "10.1.1",
+		"curl": "8.4.0#2",
+	}, listVcpkgStatus(contents))
+
+	require.Equal(t, listVcpkgStatus(contents), listVcpkgPackages(formatVcpkgPackages(listVcpkgStatus(contents))))
+}
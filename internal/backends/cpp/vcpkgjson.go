@@ -0,0 +1,250 @@
+package cpp
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/replit/upm/internal/api"
+	"github.com/replit/upm/internal/util"
+)
+
+// vcpkgManifest represents the relevant parts of a vcpkg.json file.
+type vcpkgManifest struct {
+	// Each element is either a port name or a vcpkgDependency.
+	Dependencies    []json.RawMessage `json:"dependencies"`
+	BuiltinBaseline string            `json:"builtin-baseline"`
+}
+
+// vcpkgDependency is a dependency in vcpkg.json given as an object
+// rather than just a port name. Only the fields UPM writes are listed;
+// existing entries are never rewritten, so nothing else is lost.
+type vcpkgDependency struct {
+	Name      string `json:"name"`
+	VersionGE string `json:"version>=,omitempty"`
+}
+
+// parseVcpkgDependency returns the port name and minimum version of
+// an element of the dependencies array in vcpkg.json.
+func parseVcpkgDependency(raw json.RawMessage) (api.PkgName, api.PkgSpec) {
+	var name string
+	if err := json.Unmarshal(raw, &name); err == nil {
+		return api.PkgName(name), ""
+	}
+	var dep vcpkgDependency
+	if err := json.Unmarshal(raw, &dep); err != nil {
+		util.Die("vcpkg.json: %s", err)
+	}
+	return api.PkgName(dep.Name), api.PkgSpec(dep.VersionGE)
+}
+
+// parseVcpkgManifest parses the given vcpkg.json contents.
+func parseVcpkgManifest(contents []byte) vcpkgManifest {
+	var manifest vcpkgManifest
+	if err := json.Unmarshal(contents, &manifest); err != nil {
+		util.Die("vcpkg.json: %s", err)
+	}
+	return manifest
+}
+
+// listVcpkgManifest returns the dependencies in the given vcpkg.json
+// contents.
+func listVcpkgManifest(contents []byte) map[api.PkgName]api.PkgSpec {
+	pkgs := map[api.PkgName]api.PkgSpec{}
+	for _, raw := range parseVcpkgManifest(contents).Dependencies {
+		name, spec := parseVcpkgDependency(raw)
+		pkgs[name] = spec
+	}
+	return pkgs
+}
+
+// findJSONEnd returns the index just past the closing bracket of the
+// JSON array or object whose opening bracket is at contents[start],
+// or -1 if it is not closed.
+func findJSONEnd(contents string, start int) int {
+	depth := 0
+	inString := false
+	for i := start; i < len(contents); i++ {
+		switch c := contents[i]; {
+		case inString && c == '\\':
+			i++
+		case c == '"':
+			inString = !inString
+		case inString:
+			continue
+		case c == '[' || c == '{':
+			depth++
+		case c == ']' || c == '}':
+			depth--
+			if depth == 0 {
+				return i + 1
+			}
+		}
+	}
+	return -1
+}
+
+// vcpkgDependenciesRegexp matches the start of the dependencies array
+// in vcpkg.json. The capture group is the indentation of its key.
+var vcpkgDependenciesRegexp = regexp.MustCompile(`(?m)^([ \t]*)"dependencies"\s*:\s*\[`)
+
+// formatVcpkgDependency returns the JSON for a new dependency, which
+// is just the port name unless there is a minimum version.
+func formatVcpkgDependency(name api.PkgName, spec api.PkgSpec, indent string, unit string) string {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	// Otherwise the > in version>= would be escaped.
+	enc.SetEscapeHTML(false)
+	enc.SetIndent(indent, unit)
+	var value interface{} = string(name)
+	if spec != "" {
+		value = vcpkgDependency{Name: string(name), VersionGE: string(spec)}
+	}
+	if err := enc.Encode(value); err != nil {
+		util.Panicf("formatVcpkgDependency: %s", err)
+	}
+	return strings.TrimSuffix(buf.String(), "\n")
+}
+
+// rewriteVcpkgDependencies returns the given vcpkg.json contents with
+// the dependencies array replaced by the result of passing its
+// elements (formatted as JSON at the given indentation) to the given
+// function. The array is created if necessary. Elements are kept
+// exactly as they were written, so that formatting and fields such as
+// features survive.
+func rewriteVcpkgDependencies(contents string, rewrite func(deps []string, indent string, unit string) []string) string {
+	manifest := parseVcpkgManifest([]byte(contents))
+
+	loc := vcpkgDependenciesRegexp.FindStringSubmatchIndex(contents)
+	var start, end int
+	var keyIndent string
+	if loc == nil {
+		// Put the array at the start of the top-level
+		// object.
+		keyIndent = "  "
+		start = strings.IndexByte(contents, '{') + 1
+		end = start
+	} else {
+		keyIndent = contents[loc[2]:loc[3]]
+		start = loc[1] - 1
+		end = findJSONEnd(contents, start)
+		if end == -1 {
+			util.Die("vcpkg.json: unterminated dependencies array")
+		}
+	}
+	unit := keyIndent
+	if unit == "" {
+		unit = "  "
+	}
+	indent := keyIndent + unit
+
+	deps := []string{}
+	for _, raw := range manifest.Dependencies {
+		deps = append(deps, string(raw))
+	}
+	deps = rewrite(deps, indent, unit)
+
+	array := "[]"
+	if len(deps) > 0 {
+		array = "[\n" + indent + strings.Join(deps, ",\n"+indent) + "\n" + keyIndent + "]"
+	}
+
+	if loc == nil {
+		sep := ""
+		if !strings.HasPrefix(strings.TrimSpace(contents[start:]), "}") {
+			sep = ","
+			if !strings.HasPrefix(contents[start:], "\n") {
+				sep += "\n" + keyIndent
+			}
+		}
+		return contents[:start] + "\n" + keyIndent + `"dependencies": ` + array + sep + contents[start:]
+	}
+	return contents[:start] + array + contents[end:]
+}
+
+// addToVcpkgManifest returns the given vcpkg.json contents with the
+// given dependencies added.
+func addToVcpkgManifest(contents string, pkgs map[api.PkgName]api.PkgSpec) string {
+	return rewriteVcpkgDependencies(contents, func(deps []string, indent string, unit string) []string {
+		names := []string{}
+		for name := range pkgs {
+			names = append(names, string(name))
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			deps = append(deps, formatVcpkgDependency(
+				api.PkgName(name), pkgs[api.PkgName(name)], indent, unit,
+			))
+		}
+		return deps
+	})
+}
+
+// removeFromVcpkgManifest returns the given vcpkg.json contents with
+// the given dependencies deleted.
+func removeFromVcpkgManifest(contents string, pkgs map[api.PkgName]bool) string {
+	return rewriteVcpkgDependencies(contents, func(deps []string, indent string, unit string) []string {
+		kept := []string{}
+		for _, dep := range deps {
+			if name, _ := parseVcpkgDependency(json.RawMessage(dep)); !pkgs[name] {
+				kept = append(kept, dep)
+			}
+		}
+		return kept
+	})
+}
+
+// listVcpkgStatus returns the ports recorded as installed in the given
+// contents of vcpkg's status database (vcpkg_installed/vcpkg/status).
+// It is made up of paragraphs in the format used by dpkg, one for each
+// port and feature on each triplet.
+func listVcpkgStatus(contents string) map[api.PkgName]api.PkgVersion {
+	pkgs := map[api.PkgName]api.PkgVersion{}
+	for _, paragraph := range strings.Split(contents, "\n\n") {
+		fields := map[string]string{}
+		for _, line := range strings.Split(paragraph, "\n") {
+			parts := strings.SplitN(line, ":", 2)
+			if len(parts) == 2 {
+				fields[parts[0]] = strings.TrimSpace(parts[1])
+			}
+		}
+		if fields["Package"] == "" || fields["Feature"] != "" ||
+			!strings.HasSuffix(fields["Status"], " installed") {
+			continue
+		}
+		version := fields["Version"]
+		if portVersion := fields["Port-Version"]; portVersion != "" && portVersion != "0" {
+			version += "#" + portVersion
+		}
+		pkgs[api.PkgName(fields["Package"])] = api.PkgVersion(version)
+	}
+	return pkgs
+}
+
+// formatVcpkgPackages returns the contents of the lockfile for the
+// given installed ports.
+func formatVcpkgPackages(pkgs map[api.PkgName]api.PkgVersion) string {
+	lines := []string{}
+	for name, version := range pkgs {
+		lines = append(lines, fmt.Sprintf("%s %s\n", name, version))
+	}
+	sort.Strings(lines)
+	return strings.Join(lines, "")
+}
+
+// listVcpkgPackages returns the ports recorded in the given lockfile
+// contents.
+func listVcpkgPackages(contents string) map[api.PkgName]api.PkgVersion {
+	pkgs := map[api.PkgName]api.PkgVersion{}
+	for _, line := range strings.Split(contents, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			continue
+		}
+		pkgs[api.PkgName(fields[0])] = api.PkgVersion(fields[1])
+	}
+	return pkgs
+}
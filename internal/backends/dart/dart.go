@@ -3,6 +3,7 @@
 package dart
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
@@ -11,6 +12,7 @@ import (
 	"os"
 	"path"
 	"runtime"
+	"sort"
 
 	"github.com/replit/upm/internal/api"
 	"github.com/replit/upm/internal/util"
@@ -116,8 +118,8 @@ type pubDevSearchResults struct {
 }
 
 // dartSearch implements Search for Pub.dev.
-func dartSearch(query string) []api.PkgInfo {
-	endpoint := fmt.Sprintf("%s/api/search/?q=%s", getPubBaseURL(), url.QueryEscape(query))
+func dartSearch(ctx context.Context, query string) []api.PkgInfo {
+	endpoint := util.JoinURL(getPubBaseURL(), "api", "search", "") + "?q=" + url.QueryEscape(query)
 
 	req, err := http.NewRequest("GET", endpoint, nil)
 	if err != nil {
@@ -127,7 +129,7 @@ func dartSearch(query string) []api.PkgInfo {
 	req.Header.Add("User-Agent", "upm (+https://github.com/replit/upm)")
 	req.Header.Add("Accept", "application/json")
 
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := util.HTTPDo(ctx, req)
 	if err != nil {
 		util.Die("Pub.dev: %s", err)
 	}
@@ -159,19 +161,24 @@ type pubDevInfoResults struct {
 	Name   string `json:"name"`
 	Latest struct {
 		ArchiveURL string `json:"archive_url"`
+		Published  string `json:"published"`
 		Pubspec    struct {
-			Version     string `json:"version"`
-			Author      string `json:"author"`
-			Description string `json:"description"`
-			Homepage    string `json:"homepage"`
+			Version      string                 `json:"version"`
+			Author       string                 `json:"author"`
+			Description  string                 `json:"description"`
+			Homepage     string                 `json:"homepage"`
+			Repository   string                 `json:"repository"`
+			IssueTracker string                 `json:"issue_tracker"`
+			Topics       []string               `json:"topics"`
+			Dependencies map[string]interface{} `json:"dependencies"`
 		} `json:"pubspec"`
 	} `json:"latest"`
 	Version string `json:"version"`
 }
 
 // dartInfo implements Info for Pub.dev.
-func dartInfo(name api.PkgName) api.PkgInfo {
-	endpoint := fmt.Sprintf("%s/api/packages/%s", getPubBaseURL(), name)
+func dartInfo(ctx context.Context, name api.PkgName) api.PkgInfo {
+	endpoint := util.JoinURL(getPubBaseURL(), "api", "packages", string(name))
 
 	req, err := http.NewRequest("GET", endpoint, nil)
 	if err != nil {
@@ -181,7 +188,7 @@ func dartInfo(name api.PkgName) api.PkgInfo {
 	req.Header.Add("User-Agent", "upm (+https://github.com/replit/upm)")
 	req.Header.Add("Accept", "application/json")
 
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := util.HTTPDo(ctx, req)
 	if err != nil {
 		util.Die("Pub.dev: %s", err)
 	}
@@ -197,19 +204,30 @@ func dartInfo(name api.PkgName) api.PkgInfo {
 		util.Die("Pub.dev: %s", err)
 	}
 
+	deps := []string{}
+	for dep := range pubDevResults.Latest.Pubspec.Dependencies {
+		deps = append(deps, dep)
+	}
+	sort.Strings(deps)
+
 	return api.PkgInfo{
 		Name:          pubDevResults.Name,
 		Description:   pubDevResults.Latest.Pubspec.Description,
 		Version:       pubDevResults.Version,
 		HomepageURL:   pubDevResults.Latest.Pubspec.Homepage,
-		SourceCodeURL: "",
-		BugTrackerURL: "",
+		SourceCodeURL: pubDevResults.Latest.Pubspec.Repository,
+		BugTrackerURL: pubDevResults.Latest.Pubspec.IssueTracker,
 		Author: util.AuthorInfo{
 			Name:  pubDevResults.Latest.Pubspec.Author,
 			Email: "",
 			URL:   "",
 		}.String(),
-		License: ""}
+		License:         "",
+		Dependencies:    deps,
+		DependencyCount: len(deps),
+		ReleaseDate:     util.FormatTimestamp(pubDevResults.Latest.Published),
+		Keywords:        pubDevResults.Latest.Pubspec.Topics,
+	}
 }
 
 func createSpecFile() {
@@ -248,7 +266,7 @@ func readSpecFile() dartPubspecYaml {
 	return specs
 }
 
-func dartAdd(pkgs map[api.PkgName]api.PkgSpec, projectName string) {
+func dartAdd(ctx context.Context, pkgs map[api.PkgName]api.PkgSpec, projectName string) {
 	if !util.Exists("pubspec.yaml") {
 		createSpecFile()
 	}
@@ -268,7 +286,7 @@ func dartAdd(pkgs map[api.PkgName]api.PkgSpec, projectName string) {
 	writeSpecFile(specs)
 }
 
-func dartRemove(pkgs map[api.PkgName]bool) {
+func dartRemove(ctx context.Context, pkgs map[api.PkgName]bool) {
 	var specs dartPubspecYaml
 	specs = readSpecFile()
 
@@ -280,7 +298,7 @@ func dartRemove(pkgs map[api.PkgName]bool) {
 }
 
 // dartGuess stub.
-func dartGuess() (map[api.PkgName]bool, bool) {
+func dartGuess(ctx context.Context) (map[api.PkgName]bool, bool) {
 	util.Die("Guess not implemented!")
 
 	return nil, false
@@ -291,18 +309,29 @@ var DartPubBackend = api.LanguageBackend{
 	Name:             "dart-pub",
 	Specfile:         "pubspec.yaml",
 	Lockfile:         "pubspec.lock",
+	LockfileComment:  "#",
+	OSVEcosystem:     "Pub",
+	Registry:         getPubBaseURL,
 	FilenamePatterns: []string{"*.dart"},
 	Quirks:           api.QuirksLockAlsoInstalls,
+	Executables:      []string{"pub"},
 	GetPackageDir:    dartGetPackageDir,
 	Search:           dartSearch,
 	Info:             dartInfo,
 	Add:              dartAdd,
 	Remove:           dartRemove,
-	Lock: func() {
-		util.RunCmd([]string{"pub", "get"})
+	Lock: func(ctx context.Context) {
+		util.RunCmd(ctx, []string{"pub", "get"})
+	},
+	Upgrade: func(ctx context.Context, pkgs map[api.PkgName]bool) {
+		cmd := []string{"pub", "upgrade"}
+		for name := range pkgs {
+			cmd = append(cmd, string(name))
+		}
+		util.RunCmd(ctx, cmd)
 	},
-	Install: func() {
-		util.RunCmd([]string{"pub", "get"})
+	Install: func(ctx context.Context) {
+		util.RunCmd(ctx, []string{"pub", "get"})
 	},
 	ListSpecfile: dartListPubspecYaml,
 	ListLockfile: dartListPubspecLock,
@@ -0,0 +1,243 @@
+// Package dlang provides a backend for D using dub.
+package dlang
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/hashicorp/go-version"
+	"github.com/replit/upm/internal/api"
+	"github.com/replit/upm/internal/util"
+)
+
+// registryURL is the base URL of the dub package registry API.
+const registryURL = "https://code.dlang.org/api/packages/"
+
+// registrySearchResult represents one element of the response we get
+// from the registry when searching.
+type registrySearchResult struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Version     string `json:"version"`
+}
+
+// registryInfo represents the response we get from the registry for
+// /api/packages/[name]/info.
+type registryInfo struct {
+	Name       string `json:"name"`
+	Repository struct {
+		Kind    string `json:"kind"`
+		Owner   string `json:"owner"`
+		Project string `json:"project"`
+	} `json:"repository"`
+	Versions []struct {
+		Version string `json:"version"`
+		Info    struct {
+			Description  string                 `json:"description"`
+			Homepage     string                 `json:"homepage"`
+			License      string                 `json:"license"`
+			Authors      []string               `json:"authors"`
+			Dependencies map[string]interface{} `json:"dependencies"`
+		} `json:"info"`
+	} `json:"versions"`
+}
+
+// repositoryHosts maps the repository kinds known to the registry to
+// the hosts they refer to.
+var repositoryHosts = map[string]string{
+	"github":    "https://github.com/",
+	"gitlab":    "https://gitlab.com/",
+	"bitbucket": "https://bitbucket.org/",
+}
+
+// findSpecfile returns the name of the dub recipe in the current
+// directory, which is dub.sdl if that exists and dub.json otherwise.
+func findSpecfile() string {
+	if util.Exists("dub.sdl") {
+		return "dub.sdl"
+	}
+	return "dub.json"
+}
+
+// registryGet fetches the given registry path and decodes the JSON
+// response into v. It returns false if the registry reports that the
+// package does not exist.
+func registryGet(ctx context.Context, path string, v interface{}) bool {
+	resp, err := util.HTTPGet(ctx, registryURL+path)
+	if err != nil {
+		util.Die("code.dlang.org: %s", err)
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case 200:
+		break
+	case 404:
+		return false
+	default:
+		util.Die("code.dlang.org: HTTP status %d", resp.StatusCode)
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		util.Die("code.dlang.org: %s", err)
+	}
+	if err := json.Unmarshal(body, v); err != nil {
+		util.Die("code.dlang.org response: %s", err)
+	}
+	return true
+}
+
+// search implements Search for dub.
+func search(ctx context.Context, query string) []api.PkgInfo {
+	var results []registrySearchResult
+	registryGet(ctx, "search?q="+url.QueryEscape(query), &results)
+
+	pkgs := []api.PkgInfo{}
+	for _, result := range results {
+		pkgs = append(pkgs, api.PkgInfo{
+			Name:        result.Name,
+			Description: result.Description,
+			Version:     result.Version,
+		})
+	}
+	return pkgs
+}
+
+// info implements Info for dub. The registry returns every version
+// of the package, so the metadata is taken from the latest release
+// (branches such as ~master are ignored).
+func info(ctx context.Context, name api.PkgName) api.PkgInfo {
+	// Sub-packages (e.g. vibe-d:http) are documented under their
+	// parent package.
+	parent := strings.SplitN(string(name), ":", 2)[0]
+
+	var res registryInfo
+	if !registryGet(ctx, util.EscapePathSegment(parent)+"/info", &res) {
+		return api.PkgInfo{}
+	}
+
+	latest := -1
+	var latestVersion *version.Version
+	for i, v := range res.Versions {
+		parsed, err := version.NewVersion(v.Version)
+		if err != nil {
+			continue
+		}
+		if latestVersion == nil || parsed.GreaterThan(latestVersion) {
+			latest, latestVersion = i, parsed
+		}
+	}
+
+	pkg := api.PkgInfo{Name: string(name)}
+	if host, ok := repositoryHosts[res.Repository.Kind]; ok {
+		pkg.SourceCodeURL = host + res.Repository.Owner + "/" + res.Repository.Project
+	}
+	if latest == -1 {
+		return pkg
+	}
+
+	v := res.Versions[latest]
+	pkg.Version = v.Version
+	pkg.Description = v.Info.Description
+	pkg.HomepageURL = v.Info.Homepage
+	pkg.DocumentationURL = "https://code.dlang.org/packages/" + util.EscapePathSegment(parent)
+	pkg.License = v.Info.License
+	pkg.Author = strings.Join(v.Info.Authors, ", ")
+
+	deps := []string{}
+	for dep := range v.Info.Dependencies {
+		deps = append(deps, dep)
+	}
+	pkg.Dependencies = deps
+
+	return pkg
+}
+
+// readSpecfile returns the contents of the dub recipe, and whether it
+// is in SDL format.
+func readSpecfile() ([]byte, bool) {
+	specfile := findSpecfile()
+	contentsB, err := ioutil.ReadFile(specfile)
+	if err != nil {
+		util.Die("%s: %s", specfile, err)
+	}
+	return contentsB, specfile == "dub.sdl"
+}
+
+// DubBackend is a UPM backend for D that uses dub.
+var DubBackend = api.LanguageBackend{
+	Name:             "dlang-dub",
+	Specfile:         findSpecfile(),
+	Lockfile:         "dub.selections.json",
+	FilenamePatterns: []string{"*.d"},
+	Quirks:           api.QuirksLockAlsoInstalls,
+	Executables:      []string{"dub"},
+	GetPackageDir: func() string {
+		// dub keeps packages in a per-user cache rather than
+		// in the project.
+		if home := os.Getenv("DUB_HOME"); home != "" {
+			return filepath.Join(home, "packages")
+		}
+		home, err := os.UserHomeDir()
+		if err != nil {
+			util.Die("%s", err)
+		}
+		return filepath.Join(home, ".dub", "packages")
+	},
+	Search: search,
+	Info:   info,
+	Add: func(ctx context.Context, pkgs map[api.PkgName]api.PkgSpec, projectName string) {
+		if !util.Exists("dub.json") && !util.Exists("dub.sdl") {
+			// dub always names the project after the
+			// directory, so projectName can't be used.
+			util.RunCmd(ctx, []string{"dub", "init", "--non-interactive", "--format=json"})
+		}
+
+		cmd := []string{"dub", "add"}
+		for name, spec := range pkgs {
+			arg := string(name)
+			if spec != "" {
+				arg += "@" + string(spec)
+			}
+			cmd = append(cmd, arg)
+		}
+		util.RunCmd(ctx, cmd)
+	},
+	Remove: func(ctx context.Context, pkgs map[api.PkgName]bool) {
+		contentsB, sdl := readSpecfile()
+		contents := removeFromSpecfileContents(string(contentsB), pkgs, sdl)
+		util.ProgressMsg("write " + findSpecfile())
+		util.TryWriteAtomic(findSpecfile(), []byte(contents))
+	},
+	Lock: func(ctx context.Context) {
+		// Resolves any dependencies that are not already in
+		// dub.selections.json, and fetches them.
+		util.RunCmd(ctx, []string{"dub", "upgrade", "--missing-only"})
+	},
+	Install: func(ctx context.Context) {
+		// Since everything is already in dub.selections.json,
+		// this just fetches the selected versions.
+		util.RunCmd(ctx, []string{"dub", "upgrade", "--missing-only"})
+	},
+	ListSpecfile: func() map[api.PkgName]api.PkgSpec {
+		contentsB, sdl := readSpecfile()
+		return listSpecfileWithContents(contentsB, sdl)
+	},
+	ListLockfile: func() map[api.PkgName]api.PkgVersion {
+		contentsB, err := ioutil.ReadFile("dub.selections.json")
+		if err != nil {
+			util.Die("dub.selections.json: %s", err)
+		}
+		return listLockfileWithContents(contentsB)
+	},
+	Guess: func(ctx context.Context) (map[api.PkgName]bool, bool) {
+		util.NotImplemented()
+		return nil, false
+	},
+}
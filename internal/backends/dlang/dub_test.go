@@ -0,0 +1,114 @@
+package dlang
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/replit/upm/internal/api"
+)
+
+const testDubJSON = `{
+	"name": "myapp",
+	"dependencies": {
+		"vibe-d": "~>0.9.5",
+		"mir-algorithm": {"version": ">=3.0.0"},
+		"mylib": {"path": "../mylib"}
+	},
+	"targetType": "executable"
+}
+`
+
+const testDubSDL = `name "myapp"
+dependency "vibe-d" version="~>0.9.5"
+dependency "mylib" path="../mylib"
+dependency "vibe-d:http" version="~>0.9.5" optional=true
+targetType "executable"
+`
+
+func TestListSpecfileJSON(t *testing.T) {
+	require.Equal(t, map[api.PkgName]api.PkgSpec{
+		"vibe-d":        "~>0.9.5",
+		"mir-algorithm": ">=3.0.0",
+		"mylib":         "../mylib",
+	}, listSpecfileWithContents([]byte(testDubJSON), false))
+}
+
+func TestListSpecfileSDL(t *testing.T) {
+	require.Equal(t, map[api.PkgName]api.PkgSpec{
+		"vibe-d":      "~>0.9.5",
+		"mylib":       "../mylib",
+		"vibe-d:http": "~>0.9.5",
+	}, listSpecfileWithContents([]byte(testDubSDL), true))
+}
+
+func TestListLockfile(t *testing.T) {
+	contents := `{
+	"fileVersion": 1,
+	"versions": {
+		"vibe-d": "0.9.5",
+		"mylib": {"path": "../mylib"}
+	}
+}`
+	require.Equal(t, map[api.PkgName]api.PkgVersion{
+		"vibe-d": "0.9.5",
+		"mylib":  "../mylib",
+	}, listLockfileWithContents([]byte(contents)))
+}
+
+func TestRemoveJSON(t *testing.T) {
+	for _, tc := range []struct {
+		remove   []api.PkgName
+		expected map[api.PkgName]api.PkgSpec
+	}{
+		{
+			remove: []api.PkgName{"vibe-d"},
+			expected: map[api.PkgName]api.PkgSpec{
+				"mir-algorithm": ">=3.0.0",
+				"mylib":         "../mylib",
+			},
+		},
+		{
+			remove: []api.PkgName{"mir-algorithm"},
+			expected: map[api.PkgName]api.PkgSpec{
+				"vibe-d": "~>0.9.5",
+				"mylib":  "../mylib",
+			},
+		},
+		{
+			remove: []api.PkgName{"mylib"},
+			expected: map[api.PkgName]api.PkgSpec{
+				"vibe-d":        "~>0.9.5",
+				"mir-algorithm": ">=3.0.0",
+			},
+		},
+		{
+			remove:   []api.PkgName{"vibe-d", "mir-algorithm", "mylib"},
+			expected: map[api.PkgName]api.PkgSpec{},
+		},
+	} {
+		pkgs := map[api.PkgName]bool{}
+		for _, name := range tc.remove {
+			pkgs[name] = true
+		}
+		contents := removeFromSpecfileContents(testDubJSON, pkgs, false)
+
+		var recipe map[string]interface{}
+		require.NoError(t, json.Unmarshal([]byte(contents), &recipe), contents)
+		require.Equal(t, "executable", recipe["targetType"])
+		require.Equal(t, tc.expected, listSpecfileWithContents([]byte(contents), false))
+	}
+}
+
+func TestRemoveSDL(t *testing.T) {
+	contents := removeFromSpecfileContents(testDubSDL, map[api.PkgName]bool{
+		"vibe-d": true,
+	}, true)
+
+	require.Equal(t, `name "myapp"
+dependency "mylib" path="../mylib"
+dependency "vibe-d:http" version="~>0.9.5" optional=true
+targetType "executable"
+`, contents)
+}
@@ -0,0 +1,166 @@
+package dlang
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/replit/upm/internal/api"
+	"github.com/replit/upm/internal/util"
+)
+
+// dubJSON represents the relevant parts of a dub.json file.
+type dubJSON struct {
+	// interface{} because dependencies can be either version
+	// strings or objects such as {"version": "~>1.0"} or
+	// {"path": "../lib"}.
+	Dependencies map[string]interface{} `json:"dependencies"`
+}
+
+// dubSelections represents the relevant parts of a
+// dub.selections.json file.
+type dubSelections struct {
+	// interface{} for the same reason as in dubJSON.
+	Versions map[string]interface{} `json:"versions"`
+}
+
+// sdlDependencyRegexp matches a dependency in dub.sdl, e.g.
+// dependency "vibe-d" version="~>0.9.5". The first capture group is
+// the package name and the second is the rest of the line, which holds
+// its attributes.
+var sdlDependencyRegexp = regexp.MustCompile(`(?m)^[ \t]*dependency[ \t]+"([^"]+)"(.*)$`)
+
+// sdlAttributeRegexp matches an attribute of an SDL tag, e.g.
+// version="~>0.9.5".
+var sdlAttributeRegexp = regexp.MustCompile(`([a-zA-Z]+)="([^"]*)"`)
+
+// normalizeSpec returns the version string from a dub dependency,
+// which is either a string or an object with a "version" key. Local
+// dependencies have no version, so their path is returned instead.
+func normalizeSpec(spec interface{}) string {
+	switch spec := spec.(type) {
+	case string:
+		return spec
+	case map[string]interface{}:
+		for _, key := range []string{"version", "path", "repository"} {
+			if value, ok := spec[key].(string); ok {
+				return value
+			}
+		}
+	}
+	return ""
+}
+
+// listSpecfileWithContents returns the dependencies in the given
+// recipe contents, which are in SDL format if sdl is true and JSON
+// format otherwise.
+func listSpecfileWithContents(contents []byte, sdl bool) map[api.PkgName]api.PkgSpec {
+	pkgs := map[api.PkgName]api.PkgSpec{}
+
+	if sdl {
+		for _, match := range sdlDependencyRegexp.FindAllStringSubmatch(string(contents), -1) {
+			spec := ""
+			for _, attr := range sdlAttributeRegexp.FindAllStringSubmatch(match[2], -1) {
+				if attr[1] == "version" || (spec == "" && attr[1] == "path") {
+					spec = attr[2]
+				}
+			}
+			pkgs[api.PkgName(match[1])] = api.PkgSpec(spec)
+		}
+		return pkgs
+	}
+
+	var recipe dubJSON
+	if err := json.Unmarshal(contents, &recipe); err != nil {
+		util.Die("dub.json: %s", err)
+	}
+	for name, spec := range recipe.Dependencies {
+		pkgs[api.PkgName(name)] = api.PkgSpec(normalizeSpec(spec))
+	}
+	return pkgs
+}
+
+// listLockfileWithContents returns the selected versions in the given
+// dub.selections.json contents.
+func listLockfileWithContents(contents []byte) map[api.PkgName]api.PkgVersion {
+	var selections dubSelections
+	if err := json.Unmarshal(contents, &selections); err != nil {
+		util.Die("dub.selections.json: %s", err)
+	}
+	pkgs := map[api.PkgName]api.PkgVersion{}
+	for name, version := range selections.Versions {
+		pkgs[api.PkgName(name)] = api.PkgVersion(normalizeSpec(version))
+	}
+	return pkgs
+}
+
+// findJSONObjectEnd returns the index just past the closing brace of
+// the JSON object whose opening brace is at contents[start], or -1 if
+// it is not closed.
+func findJSONObjectEnd(contents string, start int) int {
+	depth := 0
+	inString := false
+	for i := start; i < len(contents); i++ {
+		switch c := contents[i]; {
+		case inString && c == '\\':
+			i++
+		case c == '"':
+			inString = !inString
+		case inString:
+			continue
+		case c == '{':
+			depth++
+		case c == '}':
+			depth--
+			if depth == 0 {
+				return i + 1
+			}
+		}
+	}
+	return -1
+}
+
+// jsonDependenciesRegexp matches the start of the dependencies object
+// in dub.json.
+var jsonDependenciesRegexp = regexp.MustCompile(`"dependencies"\s*:\s*\{`)
+
+// removeFromSpecfileContents returns the recipe contents with the
+// given dependencies deleted. dub has no command for this (dub remove
+// deletes packages from the cache instead), so the recipe is edited
+// directly, leaving everything else as it was.
+func removeFromSpecfileContents(contents string, pkgs map[api.PkgName]bool, sdl bool) string {
+	if sdl {
+		for name := range pkgs {
+			contents = regexp.MustCompile(
+				fmt.Sprintf(`(?m)^[ \t]*dependency[ \t]+"%s".*(?:\n|$)`, regexp.QuoteMeta(string(name))),
+			).ReplaceAllLiteralString(contents, "")
+		}
+		return contents
+	}
+
+	loc := jsonDependenciesRegexp.FindStringIndex(contents)
+	if loc == nil {
+		return contents
+	}
+	start := loc[1] - 1
+	end := findJSONObjectEnd(contents, start)
+	if end == -1 {
+		util.Die("dub.json: unterminated dependencies object")
+	}
+
+	deps := contents[start:end]
+	for name := range pkgs {
+		deps = regexp.MustCompile(
+			fmt.Sprintf(`\s*"%s"\s*:\s*(?:"[^"]*"|\{[^{}]*\})(?:\s*,)?`, regexp.QuoteMeta(string(name))),
+		).ReplaceAllLiteralString(deps, "")
+	}
+	// If the last dependency was removed, the one before it is
+	// now followed by a comma that JSON does not allow.
+	deps = regexp.MustCompile(`,(\s*)\}$`).ReplaceAllString(deps, "$1}")
+	if strings.TrimSpace(deps[1:len(deps)-1]) == "" {
+		deps = "{}"
+	}
+
+	return contents[:start] + deps + contents[end:]
+}
@@ -2,24 +2,36 @@
 package dotnet
 
 import (
+	"context"
+
 	"github.com/replit/upm/internal/api"
 	"github.com/replit/upm/internal/util"
 )
 
+// nugetRegistry implements Registry, giving the NuGet gallery.
+func nugetRegistry() string {
+	return "https://api.nuget.org/v3/index.json"
+}
+
 // DotNetBackend is the UPM language backend .NET languages with support for C#
 var DotNetBackend = api.LanguageBackend{
 	Name:             "dotnet",
 	Specfile:         findSpecFile(),
 	Lockfile:         lockFileName,
+	OSVEcosystem:     "NuGet",
+	Registry:         nugetRegistry,
 	FilenamePatterns: []string{"*.cs", "*.csproj", "*.fs", "*.fsproj"},
-	Remove:           func(pkgs map[api.PkgName]bool) { removePackages(pkgs, findSpecFile(), util.RunCmd) },
-	Add: func(pkgs map[api.PkgName]api.PkgSpec, projectName string) {
-		addPackages(pkgs, projectName, util.RunCmd)
+	Executables:      []string{"dotnet"},
+	Remove: func(ctx context.Context, pkgs map[api.PkgName]bool) {
+		removePackages(pkgs, findSpecFile(), func(cmd []string) { util.RunCmd(ctx, cmd) })
+	},
+	Add: func(ctx context.Context, pkgs map[api.PkgName]api.PkgSpec, projectName string) {
+		addPackages(pkgs, projectName, func(cmd []string) { util.RunCmd(ctx, cmd) })
 	},
 	Search:       search,
 	Info:         info,
-	Install:      func() { install(util.RunCmd) },
-	Lock:         func() { lock(util.RunCmd) },
+	Install:      func(ctx context.Context) { install(func(cmd []string) { util.RunCmd(ctx, cmd) }) },
+	Lock:         func(ctx context.Context) { lock(func(cmd []string) { util.RunCmd(ctx, cmd) }) },
 	ListSpecfile: listSpecfile,
 	ListLockfile: listLockfile,
 	GetPackageDir: func() string {
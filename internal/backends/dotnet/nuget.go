@@ -1,12 +1,14 @@
 package dotnet
 
 import (
+	"context"
 	"encoding/json"
 	"encoding/xml"
 	"fmt"
 	"io/ioutil"
 	"net/http"
 	"net/url"
+	"sort"
 	"strings"
 
 	"github.com/replit/upm/internal/api"
@@ -39,6 +41,21 @@ type packageMetadata struct {
 	License     string     `xml:"license"`
 	Repository  repository `xml:"repository"`
 	ProjectURL  string     `xml:"projectUrl"`
+	// Space-separated.
+	Tags string `xml:"tags"`
+	// The dependencies for each target framework, or for all of
+	// them.
+	Dependencies struct {
+		Groups []struct {
+			Dependencies []nugetDependency `xml:"dependency"`
+		} `xml:"group"`
+		Dependencies []nugetDependency `xml:"dependency"`
+	} `xml:"dependencies"`
+}
+
+// a dependency in a .nuspec file
+type nugetDependency struct {
+	ID string `xml:"id,attr"`
 }
 
 // nuget.org .nuspec file data
@@ -64,11 +81,11 @@ type searchResult struct {
 const searchQueryURL = "https://azuresearch-usnc.nuget.org/query"
 
 // find the first ten projects that match the query string on nuget.org
-func search(query string) []api.PkgInfo {
+func search(ctx context.Context, query string) []api.PkgInfo {
 	pkgs := []api.PkgInfo{}
 	queryURL := fmt.Sprintf("%s?q=%s&take=10", searchQueryURL, url.QueryEscape(query))
 
-	res, err := http.Get(queryURL)
+	res, err := util.HTTPGet(ctx, queryURL)
 	if err != nil {
 		util.Die("failed to query for packages: %s", err)
 	}
@@ -102,11 +119,11 @@ func search(query string) []api.PkgInfo {
 }
 
 // looks up all the versions of the package and gets retails for the latest version from nuget.org
-func info(pkgName api.PkgName) api.PkgInfo {
-	lowID := url.PathEscape(strings.ToLower(string(pkgName)))
+func info(ctx context.Context, pkgName api.PkgName) api.PkgInfo {
+	lowID := util.EscapePathSegment(strings.ToLower(string(pkgName)))
 	infoURL := fmt.Sprintf("https://api.nuget.org/v3-flatcontainer/%s/index.json", lowID)
 
-	res, err := http.Get(infoURL)
+	res, err := util.HTTPGet(ctx, infoURL)
 	if err != nil {
 		util.Die("failed to get the versions: %s", err)
 	}
@@ -122,9 +139,9 @@ func info(pkgName api.PkgName) api.PkgInfo {
 	}
 	latestVersion := infoResult.Versions[len(infoResult.Versions)-1]
 	util.ProgressMsg(fmt.Sprintf("latest version of %s is %s", pkgName, latestVersion))
-	specURL := fmt.Sprintf("https://api.nuget.org/v3-flatcontainer/%s/%s/%s.nuspec", lowID, url.PathEscape(latestVersion), lowID)
+	specURL := fmt.Sprintf("https://api.nuget.org/v3-flatcontainer/%s/%s/%s.nuspec", lowID, util.EscapePathSegment(latestVersion), lowID)
 	util.ProgressMsg(fmt.Sprintf("Getting spec from %s", specURL))
-	res, err = http.Get(specURL)
+	res, err = util.HTTPGet(ctx, specURL)
 	if err != nil {
 		util.Die("failed to get the spec: %s", err)
 	}
@@ -147,6 +164,23 @@ func info(pkgName api.PkgName) api.PkgInfo {
 		License:       nugetPackage.Metadata.License,
 		SourceCodeURL: nugetPackage.Metadata.Repository.URL,
 		HomepageURL:   nugetPackage.Metadata.ProjectURL,
+		Keywords:      strings.Fields(nugetPackage.Metadata.Tags),
+	}
+
+	// A package usually depends on the same packages for every
+	// target framework, so they are only counted once.
+	seen := map[string]bool{}
+	deps := nugetPackage.Metadata.Dependencies.Dependencies
+	for _, group := range nugetPackage.Metadata.Dependencies.Groups {
+		deps = append(deps, group.Dependencies...)
+	}
+	for _, dep := range deps {
+		if !seen[dep.ID] {
+			seen[dep.ID] = true
+			pkgInfo.Dependencies = append(pkgInfo.Dependencies, dep.ID)
+		}
 	}
+	sort.Strings(pkgInfo.Dependencies)
+	pkgInfo.DependencyCount = len(pkgInfo.Dependencies)
 	return pkgInfo
 }
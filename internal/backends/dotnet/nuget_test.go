@@ -1,11 +1,12 @@
 package dotnet
 
 import (
+	"context"
 	"testing"
 )
 
 func TestSearchNuget(t *testing.T) {
-	pkgs := search("Microsoft.Extensions.Logging")
+	pkgs := search(context.Background(), "Microsoft.Extensions.Logging")
 
 	if len(pkgs) < 1 {
 		t.Error("No results found for Micorosft.Extensions.Logging")
@@ -22,7 +23,7 @@ func TestSearchNuget(t *testing.T) {
 }
 
 func TestInfoFromNuget(t *testing.T) {
-	pkg := info("Microsoft.Extensions.Logging")
+	pkg := info(context.Background(), "Microsoft.Extensions.Logging")
 
 	if pkg.Name == "" {
 		t.Errorf("pkg %q has no name", pkg)
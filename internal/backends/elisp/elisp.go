@@ -2,6 +2,7 @@
 package elisp
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
@@ -24,15 +25,13 @@ var ElispBackend = api.LanguageBackend{
 	Lockfile:         "packages.txt",
 	FilenamePatterns: elispPatterns,
 	Quirks:           api.QuirksNotReproducible,
+	Executables:      []string{"cask", "emacs", "sqlite3"},
 	GetPackageDir: func() string {
 		return ".cask"
 	},
-	Search: func(query string) []api.PkgInfo {
-		tmpdir, err := ioutil.TempDir("", "elpa")
-		if err != nil {
-			util.Die("%s", err)
-		}
-		defer os.RemoveAll(tmpdir)
+	Search: func(ctx context.Context, query string) []api.PkgInfo {
+		tmpdir := util.TempDir("elpa")
+		defer util.RemoveTemp(tmpdir)
 
 		// Run script with lexical binding (any header comment
 		// in the script would not be respected, so we have to
@@ -41,7 +40,7 @@ var ElispBackend = api.LanguageBackend{
 			"(eval '(progn %s) t)", util.GetResource("/elisp/elpa-search.el"),
 		)
 		code = strings.Replace(code, "~", "`", -1)
-		outputB := util.GetCmdOutput([]string{
+		outputB := util.GetCmdOutput(ctx, []string{
 			"emacs", "-Q", "--batch", "--eval", code,
 			tmpdir, "search", query,
 		})
@@ -51,12 +50,9 @@ var ElispBackend = api.LanguageBackend{
 		}
 		return results
 	},
-	Info: func(name api.PkgName) api.PkgInfo {
-		tmpdir, err := ioutil.TempDir("", "elpa")
-		if err != nil {
-			util.Die("%s", err)
-		}
-		defer os.RemoveAll(tmpdir)
+	Info: func(ctx context.Context, name api.PkgName) api.PkgInfo {
+		tmpdir := util.TempDir("elpa")
+		defer util.RemoveTemp(tmpdir)
 
 		// Run script with lexical binding (any header comment
 		// in the script would not be respected, so we have to
@@ -65,7 +61,7 @@ var ElispBackend = api.LanguageBackend{
 			"(eval '(progn %s) t)", util.GetResource("/elisp/elpa-search.el"),
 		)
 		code = strings.Replace(code, "~", "`", -1)
-		outputB := util.GetCmdOutput([]string{
+		outputB := util.GetCmdOutput(ctx, []string{
 			"emacs", "-Q", "--batch", "--eval", code,
 			tmpdir, "info", string(name),
 		})
@@ -75,7 +71,7 @@ var ElispBackend = api.LanguageBackend{
 		}
 		return info
 	},
-	Add: func(pkgs map[api.PkgName]api.PkgSpec, projectName string) {
+	Add: func(ctx context.Context, pkgs map[api.PkgName]api.PkgSpec, projectName string) {
 		contentsB, err := ioutil.ReadFile("Cask")
 		var contents string
 		if os.IsNotExist(err) {
@@ -107,7 +103,7 @@ var ElispBackend = api.LanguageBackend{
 		util.ProgressMsg("write Cask")
 		util.TryWriteAtomic("Cask", contentsB)
 	},
-	Remove: func(pkgs map[api.PkgName]bool) {
+	Remove: func(ctx context.Context, pkgs map[api.PkgName]bool) {
 		contentsB, err := ioutil.ReadFile("Cask")
 		if err != nil {
 			util.Die("Cask: %s", err)
@@ -127,9 +123,9 @@ var ElispBackend = api.LanguageBackend{
 		util.ProgressMsg("write Cask")
 		util.TryWriteAtomic("Cask", contentsB)
 	},
-	Install: func() {
-		util.RunCmd([]string{"cask", "install"})
-		outputB := util.GetCmdOutput(
+	Install: func(ctx context.Context) {
+		util.RunCmd(ctx, []string{"cask", "install"})
+		outputB := util.GetCmdOutput(ctx,
 			[]string{"cask", "eval", util.GetResource(
 				"/elisp/cask-list-installed.el",
 			)},
@@ -138,7 +134,7 @@ var ElispBackend = api.LanguageBackend{
 		util.TryWriteAtomic("packages.txt", outputB)
 	},
 	ListSpecfile: func() map[api.PkgName]api.PkgSpec {
-		outputB := util.GetCmdOutput(
+		outputB := util.GetCmdOutput(context.Background(),
 			[]string{"cask", "eval", util.GetResource(
 				"/elisp/cask-list-specfile.el",
 			)},
@@ -176,12 +172,12 @@ var ElispBackend = api.LanguageBackend{
 	GuessRegexps: util.Regexps([]string{
 		`\(\s*require\s*'\s*([^)[:space:]]+)[^)]*\)`,
 	}),
-	Guess: func() (map[api.PkgName]bool, bool) {
+	Guess: func(ctx context.Context) (map[api.PkgName]bool, bool) {
 		r := regexp.MustCompile(
 			`\(\s*require\s*'\s*([^)[:space:]]+)[^)]*\)`,
 		)
 		required := map[string]bool{}
-		for _, match := range util.SearchRecursive(r, elispPatterns) {
+		for _, match := range util.SearchRecursive(ctx, r, elispPatterns) {
 			required[match[1]] = true
 		}
 
@@ -193,19 +189,16 @@ var ElispBackend = api.LanguageBackend{
 			`\(\s*provide\s*'\s*([^)[:space:]]+)[^)]*\)`,
 		)
 		provided := map[string]bool{}
-		for _, match := range util.SearchRecursive(r, elispPatterns) {
+		for _, match := range util.SearchRecursive(ctx, r, elispPatterns) {
 			provided[match[1]] = true
 		}
 
-		tempdir, err := ioutil.TempDir("", "epkgs")
-		if err != nil {
-			util.Die("%s", err)
-		}
-		defer os.RemoveAll(tempdir)
+		tempdir := util.TempDir("epkgs")
+		defer util.RemoveTemp(tempdir)
 
 		url := "https://github.com/emacsmirror/epkgs/raw/master/epkg.sqlite"
 		epkgs := filepath.Join(tempdir, "epkgs.sqlite")
-		util.DownloadFile(epkgs, url)
+		util.DownloadFile(ctx, epkgs, url)
 
 		clauses := []string{}
 		for feature := range required {
@@ -228,7 +221,7 @@ var ElispBackend = api.LanguageBackend{
 			"WHERE PR.package = PK.name AND PK.class = 'builtin');",
 			where,
 		)
-		output := string(util.GetCmdOutput([]string{"sqlite3", epkgs, query}))
+		output := string(util.GetCmdOutput(ctx, []string{"sqlite3", epkgs, query}))
 
 		r = regexp.MustCompile(`"(.+?)"`)
 		names := map[api.PkgName]bool{}
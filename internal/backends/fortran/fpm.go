@@ -0,0 +1,298 @@
+// Package fortran provides a backend for Fortran using the Fortran
+// Package Manager (fpm).
+package fortran
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/replit/upm/internal/api"
+	"github.com/replit/upm/internal/util"
+)
+
+// fortranPatterns is the FilenamePatterns value for FpmBackend.
+var fortranPatterns = []string{"*.f90", "*.F90", "*.f95", "*.f03", "*.f08", "*.f", "*.F"}
+
+// fpmRegistryURL is the base URL of the fpm registry, which is the one
+// fpm itself uses by default.
+const fpmRegistryURL = "https://registry-apis.vercel.app/packages"
+
+// fpmCacheFile is where fpm records the dependencies it has fetched,
+// which serves as the lockfile. fpm has no lockfile of its own.
+var fpmCacheFile = filepath.Join("build", "cache.toml")
+
+// fpmMetapackages are the dependencies that fpm provides itself,
+// rather than fetching them from the registry or git. They are always
+// written as name = "*".
+var fpmMetapackages = map[api.PkgName]bool{
+	"blas":    true,
+	"hdf5":    true,
+	"minpack": true,
+	"mpi":     true,
+	"netcdf":  true,
+	"openmp":  true,
+	"stdlib":  true,
+}
+
+// fpmPackage represents a package in the response we get from the fpm
+// registry, either when searching or as the data for Info.
+type fpmPackage struct {
+	Name        string `json:"name"`
+	Namespace   string `json:"namespace"`
+	Description string `json:"description"`
+	Author      string `json:"author"`
+	License     string `json:"license"`
+	Homepage    string `json:"homepage"`
+	Repository  string `json:"repository"`
+
+	LatestVersion struct {
+		Version      string          `json:"version"`
+		License      string          `json:"license"`
+		Dependencies json.RawMessage `json:"dependencies"`
+	} `json:"latest_version_data"`
+}
+
+// fpmSearchResults represents the response we get from the fpm
+// registry when searching.
+type fpmSearchResults struct {
+	Packages []fpmPackage `json:"packages"`
+}
+
+// fpmInfoResults represents the response we get from the fpm registry
+// when asking about a single package.
+type fpmInfoResults struct {
+	Data fpmPackage `json:"data"`
+}
+
+// fpmGet fetches the given path from the fpm registry and returns the
+// response body, or nil if there is no such package.
+func fpmGet(ctx context.Context, path string) []byte {
+	resp, err := util.HTTPGet(ctx, fpmRegistryURL+path)
+	if err != nil {
+		util.Die("fpm registry: %s", err)
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case 200:
+		break
+	case 404:
+		return nil
+	default:
+		util.Die("fpm registry: HTTP status %d", resp.StatusCode)
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		util.Die("fpm registry: %s", err)
+	}
+	return body
+}
+
+// fpmSearchPackages searches the fpm registry and returns the
+// packages that match.
+func fpmSearchPackages(ctx context.Context, query string) []fpmPackage {
+	body := fpmGet(ctx, "?query="+url.QueryEscape(query))
+	if body == nil {
+		return []fpmPackage{}
+	}
+	var results fpmSearchResults
+	if err := json.Unmarshal(body, &results); err != nil {
+		util.Die("fpm registry response: %s", err)
+	}
+	return results.Packages
+}
+
+// fpmQualifiedName returns the name under which a package from the
+// registry can be added, which includes its namespace.
+func fpmQualifiedName(pkg fpmPackage) string {
+	return pkg.Namespace + "/" + pkg.Name
+}
+
+// fpmSearch implements Search for fpm.
+func fpmSearch(ctx context.Context, query string) []api.PkgInfo {
+	results := []api.PkgInfo{}
+	for _, pkg := range fpmSearchPackages(ctx, query) {
+		results = append(results, api.PkgInfo{
+			Name:        fpmQualifiedName(pkg),
+			Description: pkg.Description,
+			Version:     pkg.LatestVersion.Version,
+		})
+	}
+	return results
+}
+
+// fpmFindNamespace returns the namespace of the package in the
+// registry with the given name, or the empty string if there is none.
+func fpmFindNamespace(ctx context.Context, name api.PkgName) string {
+	for _, pkg := range fpmSearchPackages(ctx, string(name)) {
+		if pkg.Name == string(name) {
+			return pkg.Namespace
+		}
+	}
+	return ""
+}
+
+// fpmInfo implements Info for fpm. The name may be given with or
+// without its namespace.
+func fpmInfo(ctx context.Context, name api.PkgName) api.PkgInfo {
+	parts := strings.SplitN(string(name), "/", 2)
+	if len(parts) == 1 {
+		namespace := fpmFindNamespace(ctx, name)
+		if namespace == "" {
+			return api.PkgInfo{}
+		}
+		parts = []string{namespace, string(name)}
+	}
+
+	body := fpmGet(ctx, "/"+util.EscapePathSegment(parts[0])+"/"+util.EscapePathSegment(parts[1]))
+	if body == nil {
+		return api.PkgInfo{}
+	}
+	var results fpmInfoResults
+	if err := json.Unmarshal(body, &results); err != nil {
+		util.Die("fpm registry response: %s", err)
+	}
+	pkg := results.Data
+
+	license := pkg.LatestVersion.License
+	if license == "" {
+		license = pkg.License
+	}
+
+	// The dependencies are in the same format as in fpm.toml,
+	// keyed by name.
+	deps := []string{}
+	var depsMap map[string]json.RawMessage
+	if json.Unmarshal(pkg.LatestVersion.Dependencies, &depsMap) == nil {
+		for dep := range depsMap {
+			deps = append(deps, dep)
+		}
+		sort.Strings(deps)
+	}
+
+	return api.PkgInfo{
+		Name:          fpmQualifiedName(pkg),
+		Description:   pkg.Description,
+		Version:       pkg.LatestVersion.Version,
+		HomepageURL:   pkg.Homepage,
+		SourceCodeURL: pkg.Repository,
+		License:       license,
+		Author:        pkg.Author,
+		Dependencies:  deps,
+	}
+}
+
+// fpmReadManifest returns the contents of fpm.toml.
+func fpmReadManifest() string {
+	contentsB, err := ioutil.ReadFile("fpm.toml")
+	if err != nil {
+		util.Die("fpm.toml: %s", err)
+	}
+	return string(contentsB)
+}
+
+// fpmAdd implements Add for fpm. Unless a package is a metapackage,
+// comes from git (its spec is a URL), or already has a namespace, it
+// is looked up in the registry to find its namespace.
+func fpmAdd(ctx context.Context, pkgs map[api.PkgName]api.PkgSpec, projectName string) {
+	contents := ""
+	if util.Exists("fpm.toml") {
+		contents = fpmReadManifest()
+	} else {
+		if projectName == "" {
+			cwd, err := os.Getwd()
+			if err != nil {
+				util.Die("%s", err)
+			}
+			projectName = filepath.Base(cwd)
+		}
+		contents = fmt.Sprintf("name = %q\n", projectName)
+	}
+
+	qualified := map[api.PkgName]api.PkgSpec{}
+	for name, spec := range pkgs {
+		if !fpmMetapackages[name] && !isGitURL(string(spec)) && !strings.Contains(string(name), "/") {
+			namespace := fpmFindNamespace(ctx, name)
+			if namespace == "" {
+				util.Die("no such package in the fpm registry: %s", name)
+			}
+			name = api.PkgName(namespace + "/" + string(name))
+		}
+		qualified[name] = spec
+	}
+
+	contents = addToFpmManifest(contents, qualified)
+	util.ProgressMsg("write fpm.toml")
+	util.TryWriteAtomic("fpm.toml", []byte(contents))
+}
+
+// fpmRemove implements Remove for fpm.
+func fpmRemove(ctx context.Context, pkgs map[api.PkgName]bool) {
+	names := map[api.PkgName]bool{}
+	for name := range pkgs {
+		names[fpmNormalizePackageName(name)] = true
+	}
+	contents := removeFromFpmManifest(fpmReadManifest(), names)
+	util.ProgressMsg("write fpm.toml")
+	util.TryWriteAtomic("fpm.toml", []byte(contents))
+}
+
+// fpmNormalizePackageName implements NormalizePackageName for fpm. In
+// fpm.toml, packages from the registry are keyed by name alone,
+// without their namespace.
+func fpmNormalizePackageName(name api.PkgName) api.PkgName {
+	parts := strings.SplitN(string(name), "/", 2)
+	return api.PkgName(parts[len(parts)-1])
+}
+
+// FpmBackend is a UPM backend for Fortran that uses fpm.
+var FpmBackend = api.LanguageBackend{
+	Name:                 "fortran-fpm",
+	Specfile:             "fpm.toml",
+	Lockfile:             fpmCacheFile,
+	FilenamePatterns:     fortranPatterns,
+	Quirks:               api.QuirksNotReproducible,
+	Executables:          []string{"fpm"},
+	NormalizePackageName: fpmNormalizePackageName,
+	GetPackageDir: func() string {
+		return filepath.Join("build", "dependencies")
+	},
+	Search: fpmSearch,
+	Info:   fpmInfo,
+	Add:    fpmAdd,
+	Remove: fpmRemove,
+	Upgrade: func(ctx context.Context, pkgs map[api.PkgName]bool) {
+		cmd := []string{"fpm", "update"}
+		for name := range pkgs {
+			cmd = append(cmd, string(fpmNormalizePackageName(name)))
+		}
+		util.RunCmd(ctx, cmd)
+	},
+	// Dependencies that have been fetched already are left at the
+	// revision they were fetched at.
+	Install: func(ctx context.Context) {
+		util.RunCmd(ctx, []string{"fpm", "update", "--fetch-only"})
+	},
+	ListSpecfile: func() map[api.PkgName]api.PkgSpec {
+		return listFpmManifest(fpmReadManifest())
+	},
+	ListLockfile: func() map[api.PkgName]api.PkgVersion {
+		contentsB, err := ioutil.ReadFile(fpmCacheFile)
+		if err != nil {
+			util.Die("%s: %s", fpmCacheFile, err)
+		}
+		return listFpmCache(string(contentsB))
+	},
+	Guess: func(ctx context.Context) (map[api.PkgName]bool, bool) {
+		util.NotImplemented()
+		return nil, false
+	},
+}
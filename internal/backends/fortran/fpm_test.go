@@ -0,0 +1,117 @@
+package fortran
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/replit/upm/internal/api"
+)
+
+const testManifest = `name = "myproject"
+version = "0.1.0"
+
+[dependencies]
+stdlib = "*"
+M_CLI2 = { git = "https://github.com/urbanjost/M_CLI2.git", tag = "v3.2.0" }
+toml-f.git = "https://github.com/toml-f/toml-f"
+example.namespace = "example-ns"
+example.v = "1.0.0"
+
+[dependencies.fortran-regex]
+git = "https://github.com/perazz/fortran-regex"
+branch = "main"
+
+[dev-dependencies]
+test-drive = { git = "https://github.com/fortran-lang/test-drive" }
+
+[build]
+auto-executables = true
+`
+
+func TestListManifest(t *testing.T) {
+	require.Equal(t, map[api.PkgName]api.PkgSpec{
+		"stdlib":  "*",
+		"M_CLI2":  "https://github.com/urbanjost/M_CLI2.git#v3.2.0",
+		"toml-f":  "https://github.com/toml-f/toml-f",
+		"example": "1.0.0",
+
+		"fortran-regex": "https://github.com/perazz/fortran-regex#main",
+	}, listFpmManifest(testManifest))
+}
+
+func TestAddToManifest(t *testing.T) {
+	contents := addToFpmManifest(testManifest, map[api.PkgName]api.PkgSpec{
+		"fortran-lang/fftpack": "0.2.0",
+		"openmp":               "",
+		"json-fortran":         "https://github.com/jacobwilliams/json-fortran#8.3.0",
+	})
+	require.Contains(t, contents, `example.v = "1.0.0"
+fftpack = { namespace = "fortran-lang", v = "0.2.0" }
+json-fortran = { git = "https://github.com/jacobwilliams/json-fortran", tag = "8.3.0" }
+openmp = "*"
+
+[dependencies.fortran-regex]
+`)
+	require.Equal(t, api.PkgSpec("0.2.0"), listFpmManifest(contents)["fftpack"])
+}
+
+func TestAddToManifestWithoutDependencies(t *testing.T) {
+	contents := addToFpmManifest("name = \"x\"\n", map[api.PkgName]api.PkgSpec{
+		"stdlib": "",
+	})
+	require.Equal(t, "name = \"x\"\n\n[dependencies]\nstdlib = \"*\"\n", contents)
+}
+
+func TestRemoveFromManifest(t *testing.T) {
+	contents := removeFromFpmManifest(testManifest, map[api.PkgName]bool{
+		"example": true,
+		"M_CLI2":  true,
+	})
+	require.Equal(t, map[api.PkgName]api.PkgSpec{
+		"stdlib": "*",
+		"toml-f": "https://github.com/toml-f/toml-f",
+
+		"fortran-regex": "https://github.com/perazz/fortran-regex#main",
+	}, listFpmManifest(contents))
+	require.Contains(t, contents, "[dev-dependencies]\ntest-drive")
+}
+
+func TestRemoveTableFromManifest(t *testing.T) {
+	contents := removeFromFpmManifest(testManifest, map[api.PkgName]bool{
+		"fortran-regex": true,
+	})
+	require.NotContains(t, contents, "fortran-regex")
+	require.Contains(t, contents, "example.v = \"1.0.0\"\n\n[dev-dependencies]\n")
+}
+
+func TestListCache(t *testing.T) {
+	pkgs := listFpmCache(`unit = 0
+verbosity = 1
+ndep = 3
+
+[dependencies]
+
+[dependencies.myproject]
+name = "myproject"
+version = "0.1.0"
+proj-dir = "./."
+
+[dependencies.toml-f]
+name = "toml-f"
+version = "0.4.1"
+proj-dir = "build/dependencies/toml-f"
+git = "https://github.com/toml-f/toml-f"
+revision = "d7b892b1d074b7cfc5d75c3e0eb36ebc1f7958c1"
+
+[dependencies.M_CLI2]
+name = "M_CLI2"
+proj-dir = "build/dependencies/M_CLI2"
+git = "https://github.com/urbanjost/M_CLI2.git"
+revision = "7264878cdb1baff7323cc48596d829ccfe7751b8"
+`)
+	require.Equal(t, map[api.PkgName]api.PkgVersion{
+		"toml-f": "0.4.1",
+		"M_CLI2": "7264878cdb1baff7323cc48596d829ccfe7751b8",
+	}, pkgs)
+}
@@ -0,0 +1,340 @@
+package fortran
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+
+	"github.com/replit/upm/internal/api"
+	"github.com/replit/upm/internal/util"
+)
+
+// fpmDependency is a dependency in fpm.toml given as a table rather
+// than a string.
+type fpmDependency struct {
+	// For packages from the registry.
+	Namespace string `toml:"namespace"`
+	V         string `toml:"v"`
+
+	// For packages from git.
+	Git    string `toml:"git"`
+	Tag    string `toml:"tag"`
+	Branch string `toml:"branch"`
+	Rev    string `toml:"rev"`
+
+	// For packages in a local directory.
+	Path string `toml:"path"`
+}
+
+// fpmSpec returns the spec of a dependency given as a table, which is
+// the version for a package from the registry, the URL (with the tag,
+// branch, or revision after a #) for a package from git, and the path
+// for a local package.
+func fpmSpec(dep fpmDependency) api.PkgSpec {
+	switch {
+	case dep.Git != "":
+		ref := dep.Tag + dep.Branch + dep.Rev
+		if ref != "" {
+			return api.PkgSpec(dep.Git + "#" + ref)
+		}
+		return api.PkgSpec(dep.Git)
+	case dep.Path != "":
+		return api.PkgSpec(dep.Path)
+	}
+	return api.PkgSpec(dep.V)
+}
+
+// fpmValue is the value of a key/value pair in fpm.toml, which can be
+// decoded on its own.
+type fpmValue struct {
+	Value toml.Primitive `toml:"value"`
+}
+
+// listFpmManifest returns the dependencies in the given fpm.toml
+// contents. The version of the TOML library we use predates dotted
+// keys, which fpm's documentation uses for dependencies from the
+// registry (example.namespace = "..."), so the [dependencies] table
+// and any [dependencies.NAME] tables are read a line at a time, and
+// only each value is decoded as TOML.
+func listFpmManifest(contents string) map[api.PkgName]api.PkgSpec {
+	deps := map[string]*fpmDependency{}
+	order := []string{}
+	dep := func(name string) *fpmDependency {
+		if deps[name] == nil {
+			deps[name] = &fpmDependency{}
+			order = append(order, name)
+		}
+		return deps[name]
+	}
+
+	// The dependency that the current table is for, if it is a
+	// [dependencies.NAME] table.
+	table := ""
+	inDependencies := false
+	for _, line := range strings.Split(contents, "\n") {
+		if m := fpmTableRegexp.FindStringSubmatch(line); m != nil {
+			inDependencies = m[1] == "dependencies"
+			table = ""
+			if strings.HasPrefix(m[1], "dependencies.") {
+				table = unquoteKey(strings.TrimPrefix(m[1], "dependencies."))
+				dep(table)
+			}
+			continue
+		}
+		if !inDependencies && table == "" {
+			continue
+		}
+		m := fpmPairRegexp.FindStringSubmatch(line)
+		if m == nil {
+			continue
+		}
+		name, field := unquoteKey(m[1]), unquoteKey(m[2])
+		if table != "" {
+			name, field = table, unquoteKey(m[1])
+		}
+
+		var value fpmValue
+		md, err := toml.Decode("value = "+m[3], &value)
+		if err != nil {
+			util.Die("fpm.toml: dependency %s: %s", name, err)
+		}
+		d := dep(name)
+		if field != "" {
+			var s string
+			if err := md.PrimitiveDecode(value.Value, &s); err != nil {
+				util.Die("fpm.toml: dependency %s: %s", name, err)
+			}
+			setFpmField(d, field, s)
+			continue
+		}
+		var s string
+		if err := md.PrimitiveDecode(value.Value, &s); err == nil {
+			d.V = s
+			continue
+		}
+		if err := md.PrimitiveDecode(value.Value, d); err != nil {
+			util.Die("fpm.toml: dependency %s: %s", name, err)
+		}
+	}
+
+	pkgs := map[api.PkgName]api.PkgSpec{}
+	for _, name := range order {
+		pkgs[api.PkgName(name)] = fpmSpec(*deps[name])
+	}
+	return pkgs
+}
+
+// setFpmField sets the field of the given dependency with the given
+// key, if it is one we care about.
+func setFpmField(dep *fpmDependency, key string, value string) {
+	switch key {
+	case "namespace":
+		dep.Namespace = value
+	case "v":
+		dep.V = value
+	case "git":
+		dep.Git = value
+	case "tag":
+		dep.Tag = value
+	case "branch":
+		dep.Branch = value
+	case "rev":
+		dep.Rev = value
+	case "path":
+		dep.Path = value
+	}
+}
+
+// unquoteKey returns the given TOML key without quotes.
+func unquoteKey(key string) string {
+	key = strings.TrimSpace(key)
+	if len(key) >= 2 && (key[0] == '"' || key[0] == '\'') && key[len(key)-1] == key[0] {
+		return key[1 : len(key)-1]
+	}
+	return key
+}
+
+// fpmTableRegexp matches a table header, such as [dependencies].
+var fpmTableRegexp = regexp.MustCompile(`^[ \t]*\[[ \t]*([^\]]*?)[ \t]*\][ \t]*(?:#.*)?$`)
+
+// fpmPairRegexp matches a key/value pair, capturing the first part
+// of the key, the second part if it is dotted, and the value.
+var fpmPairRegexp = regexp.MustCompile(
+	`^[ \t]*("[^"]+"|'[^']+'|[A-Za-z0-9_-]+)(?:[ \t]*\.[ \t]*("[^"]+"|'[^']+'|[A-Za-z0-9_-]+))?[ \t]*=[ \t]*(.*)$`,
+)
+
+// fpmKeyRegexp matches the start of a key/value pair, capturing the
+// first part of the key (before any dot), bare or quoted.
+var fpmKeyRegexp = regexp.MustCompile(`^[ \t]*(?:"([^"]+)"|'([^']+)'|([A-Za-z0-9_-]+))[ \t]*[.=]`)
+
+// findFpmDependencies returns the indices of the lines just after the
+// [dependencies] header and at the end of its contents (before any
+// blank lines that separate it from the next table), or -1 and -1 if
+// there is no such table.
+func findFpmDependencies(lines []string) (int, int) {
+	start := -1
+	for i, line := range lines {
+		m := fpmTableRegexp.FindStringSubmatch(line)
+		if m == nil {
+			continue
+		}
+		if start != -1 {
+			return start, trimBlankLines(lines, start, i)
+		}
+		if m[1] == "dependencies" {
+			start = i + 1
+		}
+	}
+	if start == -1 {
+		return -1, -1
+	}
+	return start, trimBlankLines(lines, start, len(lines))
+}
+
+// trimBlankLines returns end moved back past any blank lines, but not
+// before start.
+func trimBlankLines(lines []string, start int, end int) int {
+	for end > start && strings.TrimSpace(lines[end-1]) == "" {
+		end--
+	}
+	return end
+}
+
+// formatFpmDependency returns the line for a new dependency. A name of
+// the form namespace/name is a package from the registry, and a spec
+// that is a URL is a package from git (with an optional #tag).
+// Otherwise, the spec is written as is, such as "*" for a
+// metapackage.
+func formatFpmDependency(name api.PkgName, spec api.PkgSpec) string {
+	if parts := strings.SplitN(string(name), "/", 2); len(parts) == 2 {
+		line := fmt.Sprintf("%s = { namespace = %s", parts[1], strconv.Quote(parts[0]))
+		if spec != "" {
+			line += fmt.Sprintf(", v = %s", strconv.Quote(string(spec)))
+		}
+		return line + " }"
+	}
+	if isGitURL(string(spec)) {
+		parts := strings.SplitN(string(spec), "#", 2)
+		line := fmt.Sprintf("%s = { git = %s", name, strconv.Quote(parts[0]))
+		if len(parts) == 2 {
+			line += fmt.Sprintf(", tag = %s", strconv.Quote(parts[1]))
+		}
+		return line + " }"
+	}
+	if spec == "" {
+		spec = "*"
+	}
+	return fmt.Sprintf("%s = %s", name, strconv.Quote(string(spec)))
+}
+
+// isGitURL returns true if the given spec is the URL of a git
+// repository rather than a version.
+func isGitURL(spec string) bool {
+	return strings.Contains(spec, "://") || strings.HasPrefix(spec, "git@")
+}
+
+// addToFpmManifest returns the given fpm.toml contents with the given
+// dependencies added to the end of the [dependencies] table, which is
+// created if necessary. Names should already be in the form described
+// by formatFpmDependency.
+func addToFpmManifest(contents string, pkgs map[api.PkgName]api.PkgSpec) string {
+	names := []string{}
+	for name := range pkgs {
+		names = append(names, string(name))
+	}
+	sort.Strings(names)
+	added := []string{}
+	for _, name := range names {
+		added = append(added, formatFpmDependency(api.PkgName(name), pkgs[api.PkgName(name)]))
+	}
+
+	lines := strings.Split(contents, "\n")
+	_, end := findFpmDependencies(lines)
+	if end == -1 {
+		end = trimBlankLines(lines, 0, len(lines))
+		header := []string{"[dependencies]"}
+		if end > 0 {
+			header = []string{"", "[dependencies]"}
+		}
+		added = append(header, added...)
+	}
+
+	result := append([]string{}, lines[:end]...)
+	result = append(result, added...)
+	result = append(result, lines[end:]...)
+	if result[len(result)-1] != "" {
+		result = append(result, "")
+	}
+	return strings.Join(result, "\n")
+}
+
+// removeFromFpmManifest returns the given fpm.toml contents with the
+// given dependencies deleted from the [dependencies] table, including
+// all the lines of those written with dotted keys, and with their
+// [dependencies.NAME] tables deleted.
+func removeFromFpmManifest(contents string, pkgs map[api.PkgName]bool) string {
+	lines := strings.Split(contents, "\n")
+	start, end := findFpmDependencies(lines)
+
+	result := []string{}
+	skipping := false
+	for i, line := range lines {
+		if m := fpmTableRegexp.FindStringSubmatch(line); m != nil {
+			name := unquoteKey(strings.TrimPrefix(m[1], "dependencies."))
+			skipping = name != m[1] && pkgs[api.PkgName(name)]
+			if skipping {
+				// The blank lines that separate the table
+				// from the next one go with it.
+				continue
+			}
+		}
+		if skipping {
+			continue
+		}
+		if i >= start && i < end {
+			m := fpmKeyRegexp.FindStringSubmatch(line)
+			if m != nil && pkgs[api.PkgName(m[1]+m[2]+m[3])] {
+				continue
+			}
+		}
+		result = append(result, line)
+	}
+	return strings.Join(result, "\n")
+}
+
+// fpmCache represents the relevant parts of build/cache.toml, where
+// fpm records the dependencies it has fetched.
+type fpmCache struct {
+	Dependencies map[string]struct {
+		Version  string `toml:"version"`
+		Revision string `toml:"revision"`
+		ProjDir  string `toml:"proj-dir"`
+	} `toml:"dependencies"`
+}
+
+// listFpmCache returns the packages recorded in the given
+// build/cache.toml contents, including indirect dependencies but not
+// the project itself.
+func listFpmCache(contents string) map[api.PkgName]api.PkgVersion {
+	var cache fpmCache
+	if _, err := toml.Decode(contents, &cache); err != nil {
+		util.Die("%s: %s", fpmCacheFile, err)
+	}
+
+	pkgs := map[api.PkgName]api.PkgVersion{}
+	for name, dep := range cache.Dependencies {
+		if dep.ProjDir == "./." || dep.ProjDir == "." {
+			continue
+		}
+		version := dep.Version
+		if version == "" {
+			version = dep.Revision
+		}
+		pkgs[api.PkgName(name)] = api.PkgVersion(version)
+	}
+	return pkgs
+}
@@ -0,0 +1,143 @@
+package haxe
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/replit/upm/internal/api"
+)
+
+const testManifest = `{
+    "name": "demo",
+    "dependencies": {
+        "lime": "",
+        "openfl": "9.2.0"
+    },
+    "classPath": "src"
+}
+`
+
+func TestListSpecfile(t *testing.T) {
+	require.Equal(t, map[api.PkgName]api.PkgSpec{
+		"lime":   "",
+		"openfl": "9.2.0",
+	}, listHaxelibManifest(testManifest))
+
+	require.Equal(t, map[api.PkgName]api.PkgSpec{
+		"heaps":  "",
+		"format": "3.5.0",
+		"hxcpp":  "git:https://github.com/HaxeFoundation/hxcpp",
+	}, listHxmlLibs("-cp src\n-lib heaps\n-L format:3.5.0\r\n# -lib commented\n--library hxcpp:git:https://github.com/HaxeFoundation/hxcpp\n"))
+}
+
+func TestAddToHaxelibManifest(t *testing.T) {
+	require.Equal(t, `{
+    "name": "demo",
+    "dependencies": {
+        "lime": "8.0.1",
+        "openfl": "9.2.0",
+        "actuate": "",
+        "hxcpp": "git:https://github.com/HaxeFoundation/hxcpp#v4.3"
+    },
+    "classPath": "src"
+}
+`, addToHaxelibManifest(testManifest, map[api.PkgName]api.PkgSpec{
+		"lime":    "8.0.1",
+		"hxcpp":   "git:https://github.com/HaxeFoundation/hxcpp#v4.3",
+		"actuate": "",
+	}))
+
+	require.Equal(t,
+		"{\n  \"name\": \"demo\",\n  \"dependencies\": {\n    \"lime\": \"\"\n  }\n}\n",
+		addToHaxelibManifest("{\n  \"name\": \"demo\"\n}\n", map[api.PkgName]api.PkgSpec{"lime": ""}),
+	)
+	require.Equal(t,
+		"{\n  \"dependencies\": {\n    \"lime\": \"\"\n  }\n}",
+		addToHaxelibManifest("{}", map[api.PkgName]api.PkgSpec{"lime": ""}),
+	)
+}
+
+func TestRemove(t *testing.T) {
+	require.Equal(t, `{
+    "name": "demo",
+    "dependencies": {
+        "openfl": "9.2.0"
+    },
+    "classPath": "src"
+}
+`, removeFromHaxelibManifest(testManifest, map[api.PkgName]bool{"lime": true}))
+
+	require.Contains(t,
+		removeFromHaxelibManifest(testManifest, map[api.PkgName]bool{"lime": true, "openfl": true}),
+		`"dependencies": {},`,
+	)
+
+	require.Equal(t,
+		"-cp src\n-main Main\n",
+		removeFromHxml("-cp src\n-lib heaps:1.9.1\n-main Main\n-lib format\n", map[api.PkgName]bool{"heaps": true, "format": true}),
+	)
+}
+
+func TestListRepo(t *testing.T) {
+	repo := t.TempDir()
+	write := func(filename string, contents string) {
+		path := filepath.Join(repo, filename)
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0777))
+		require.NoError(t, ioutil.WriteFile(path, []byte(contents), 0666))
+	}
+	write("lime/.current", "8.0.1\n")
+	write("haxeui,core/.current", "1.6.0")
+	write("mylib/.dev", "/home/user/mylib")
+	write("empty/README", "")
+
+	pkgs := listRepo(repo)
+	require.Equal(t, map[api.PkgName]api.PkgVersion{
+		"lime":        "8.0.1",
+		"haxeui.core": "1.6.0",
+		"mylib":       "dev",
+	}, pkgs)
+	require.Equal(t, "haxeui.core 1.6.0\nlime 8.0.1\nmylib dev\n", formatLockfile(pkgs))
+}
+
+func TestInstallCommand(t *testing.T) {
+	require.Equal(t, []string{"haxelib", "--always", "install", "lime"}, installCommand("lime", ""))
+	require.Equal(t, []string{"haxelib", "--always", "install", "lime", "8.0.1"}, installCommand("lime", "8.0.1"))
+	require.Equal(t,
+		[]string{"haxelib", "--always", "git", "hxcpp", "https://github.com/HaxeFoundation/hxcpp", "v4.3"},
+		installCommand("hxcpp", "git:https://github.com/HaxeFoundation/hxcpp#v4.3"),
+	)
+}
+
+func TestSerializeCall(t *testing.T) {
+	require.Equal(t, "ay3:apiy5:infoshay13:haxeui%20coreh", serializeCall("infos", "haxeui core"))
+}
+
+func TestParseRemotingResponse(t *testing.T) {
+	value, err := parseRemotingResponse(
+		"hxrloy2:idi12y4:namey4:limegoR0i-3R1y6:openflgh",
+	)
+	require.NoError(t, err)
+	require.Equal(t, []interface{}{
+		map[string]interface{}{"id": 12, "name": "lime"},
+		map[string]interface{}{"id": -3, "name": "openfl"},
+	}, value)
+
+	value, err = parseRemotingResponse(
+		"hxroy4:namey4:limey8:versionsau2ay5:8.0.0hr2hy4:datev2023-01-02 03:04:05y3:gotzg",
+	)
+	require.NoError(t, err)
+	require.Equal(t, map[string]interface{}{
+		"name":     "lime",
+		"versions": []interface{}{nil, nil, []interface{}{"8.0.0"}, []interface{}{"8.0.0"}},
+		"date":     time.Date(2023, 1, 2, 3, 4, 5, 0, time.Local),
+		"got":      0,
+	}, value)
+
+	_, err = parseRemotingResponse("hxrxy19:No%20such%20Project")
+	require.EqualError(t, err, "No such Project")
+}
@@ -0,0 +1,297 @@
+// Package haxe provides a backend for Haxe using haxelib.
+package haxe
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/replit/upm/internal/api"
+	"github.com/replit/upm/internal/util"
+)
+
+// haxePatterns is the FilenamePatterns value for HaxelibBackend.
+var haxePatterns = []string{"*.hx"}
+
+// haxelibRepo is the project-local repository that libraries are
+// installed into, which haxelib uses in preference to the global one
+// whenever it is run from the project.
+const haxelibRepo = ".haxelib"
+
+// haxelibLockfile is where Install records the libraries haxelib has
+// installed, since haxelib has no lockfile of its own.
+const haxelibLockfile = "haxelib-packages.txt"
+
+// getString returns the string field with the given name of a value
+// returned by the lib.haxe.org API, or the empty string if there is
+// none.
+func getString(object map[string]interface{}, name string) string {
+	s, _ := object[name].(string)
+	return s
+}
+
+// search implements Search for haxelib. The API only returns the
+// names of the libraries that match.
+func search(ctx context.Context, query string) []api.PkgInfo {
+	result, err := haxelibCall(ctx, "search", query)
+	if err != nil {
+		util.Die("lib.haxe.org: %s", err)
+	}
+	results := []api.PkgInfo{}
+	elems, _ := result.([]interface{})
+	for _, elem := range elems {
+		if object, ok := elem.(map[string]interface{}); ok {
+			results = append(results, api.PkgInfo{Name: getString(object, "name")})
+		}
+	}
+	return results
+}
+
+// info implements Info for haxelib.
+func info(ctx context.Context, name api.PkgName) api.PkgInfo {
+	result, err := haxelibCall(ctx, "infos", string(name))
+	if err != nil {
+		// This is what the server throws for an unknown
+		// library.
+		if strings.HasPrefix(err.Error(), "No such Project") {
+			return api.PkgInfo{}
+		}
+		util.Die("lib.haxe.org: %s", err)
+	}
+	object, ok := result.(map[string]interface{})
+	if !ok {
+		util.Die("lib.haxe.org: invalid response")
+	}
+
+	owner := getString(object, "owner")
+	if contributors, ok := object["contributors"].([]interface{}); ok && len(contributors) > 0 {
+		if contributor, ok := contributors[0].(map[string]interface{}); ok {
+			if owner = getString(contributor, "fullname"); owner == "" {
+				owner = getString(contributor, "name")
+			}
+		}
+	}
+
+	pkgName := getString(object, "name")
+	return api.PkgInfo{
+		Name:             pkgName,
+		Description:      getString(object, "desc"),
+		Version:          getString(object, "curversion"),
+		HomepageURL:      getString(object, "website"),
+		DocumentationURL: util.JoinURL("https://lib.haxe.org/p", pkgName, ""),
+		Author:           owner,
+		License:          getString(object, "license"),
+	}
+}
+
+// readManifest returns the contents of haxelib.json.
+func readManifest() string {
+	contentsB, err := ioutil.ReadFile("haxelib.json")
+	if err != nil {
+		util.Die("haxelib.json: %s", err)
+	}
+	return string(contentsB)
+}
+
+// listSpecfile implements ListSpecfile for haxelib. The libraries
+// given with -lib in .hxml files count as well as those in
+// haxelib.json, which take precedence.
+func listSpecfile() map[api.PkgName]api.PkgSpec {
+	pkgs := map[api.PkgName]api.PkgSpec{}
+	for _, filename := range hxmlFiles() {
+		contentsB, err := ioutil.ReadFile(filename)
+		if err != nil {
+			util.Die("%s: %s", filename, err)
+		}
+		for name, spec := range listHxmlLibs(string(contentsB)) {
+			pkgs[name] = spec
+		}
+	}
+	if util.Exists("haxelib.json") {
+		for name, spec := range listHaxelibManifest(readManifest()) {
+			pkgs[name] = spec
+		}
+	}
+	return pkgs
+}
+
+// add implements Add for haxelib. If there is no haxelib.json yet, one
+// is created with the name of the project.
+func add(ctx context.Context, pkgs map[api.PkgName]api.PkgSpec, projectName string) {
+	contents := ""
+	if util.Exists("haxelib.json") {
+		contents = readManifest()
+	} else {
+		if projectName == "" {
+			cwd, err := os.Getwd()
+			if err != nil {
+				util.Die("%s", err)
+			}
+			projectName = filepath.Base(cwd)
+		}
+		contents = fmt.Sprintf("{\n  \"name\": %s\n}\n", jsonString(projectName))
+	}
+
+	contents = addToHaxelibManifest(contents, pkgs)
+	util.ProgressMsg("write haxelib.json")
+	util.TryWriteAtomic("haxelib.json", []byte(contents))
+}
+
+// remove implements Remove for haxelib. The libraries are removed from
+// .hxml files too.
+func remove(ctx context.Context, pkgs map[api.PkgName]bool) {
+	if util.Exists("haxelib.json") {
+		contents := removeFromHaxelibManifest(readManifest(), pkgs)
+		util.ProgressMsg("write haxelib.json")
+		util.TryWriteAtomic("haxelib.json", []byte(contents))
+	}
+
+	for _, filename := range hxmlFiles() {
+		contentsB, err := ioutil.ReadFile(filename)
+		if err != nil {
+			util.Die("%s: %s", filename, err)
+		}
+		contents := removeFromHxml(string(contentsB), pkgs)
+		if contents != string(contentsB) {
+			util.ProgressMsg("write " + filename)
+			util.TryWriteAtomic(filename, []byte(contents))
+		}
+	}
+}
+
+// installCommand returns the haxelib command that installs the given
+// library. The spec is a version, or a VCS source such as
+// git:https://github.com/user/repo#branch.
+func installCommand(name api.PkgName, spec api.PkgSpec) []string {
+	for _, vcs := range []string{"git", "hg"} {
+		if strings.HasPrefix(string(spec), vcs+":") {
+			source := strings.SplitN(strings.TrimPrefix(string(spec), vcs+":"), "#", 2)
+			return append([]string{"haxelib", "--always", vcs, string(name)}, source...)
+		}
+	}
+	cmd := []string{"haxelib", "--always", "install", string(name)}
+	if spec != "" {
+		cmd = append(cmd, string(spec))
+	}
+	return cmd
+}
+
+// install implements Install for haxelib. Libraries go in a local
+// repository inside the project. Those whose version isn't given are
+// installed at the version in the lockfile, if there is one, so that
+// installing again doesn't upgrade them.
+func install(ctx context.Context) {
+	if !util.Exists(haxelibRepo) {
+		util.RunCmd(ctx, []string{"haxelib", "newrepo"})
+	}
+
+	locked := map[api.PkgName]api.PkgVersion{}
+	if util.Exists(haxelibLockfile) {
+		locked = listLockfile()
+	}
+
+	pkgs := listSpecfile()
+	names := []string{}
+	for name := range pkgs {
+		names = append(names, string(name))
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		spec := pkgs[api.PkgName(name)]
+		// Libraries from VCS or a development directory are
+		// recorded as such rather than by version.
+		switch version := locked[api.PkgName(name)]; version {
+		case "", "git", "hg", "dev":
+		default:
+			if spec == "" {
+				spec = api.PkgSpec(version)
+			}
+		}
+		util.RunCmd(ctx, installCommand(api.PkgName(name), spec))
+	}
+
+	contents := formatLockfile(listRepo(haxelibRepo))
+	util.ProgressMsg("write " + haxelibLockfile)
+	util.TryWriteAtomic(haxelibLockfile, []byte(contents))
+}
+
+// listRepo returns the libraries installed in the given haxelib
+// repository, with their current versions. Each library has a
+// directory, named after it with dots replaced by commas, holding a
+// .current file with the version in use, or a .dev file if a
+// development directory is used instead.
+func listRepo(repo string) map[api.PkgName]api.PkgVersion {
+	pkgs := map[api.PkgName]api.PkgVersion{}
+	infos, err := ioutil.ReadDir(repo)
+	if err != nil {
+		util.Die("%s: %s", repo, err)
+	}
+	for _, info := range infos {
+		if !info.IsDir() {
+			continue
+		}
+		name := api.PkgName(strings.Replace(info.Name(), ",", ".", -1))
+		dir := filepath.Join(repo, info.Name())
+		if util.Exists(filepath.Join(dir, ".dev")) {
+			pkgs[name] = "dev"
+		} else if contentsB, err := ioutil.ReadFile(filepath.Join(dir, ".current")); err == nil {
+			pkgs[name] = api.PkgVersion(strings.TrimSpace(string(contentsB)))
+		}
+	}
+	return pkgs
+}
+
+// formatLockfile returns the contents of the lockfile for the given
+// installed libraries.
+func formatLockfile(pkgs map[api.PkgName]api.PkgVersion) string {
+	lines := []string{}
+	for name, version := range pkgs {
+		lines = append(lines, fmt.Sprintf("%s %s\n", name, version))
+	}
+	sort.Strings(lines)
+	return strings.Join(lines, "")
+}
+
+// listLockfile implements ListLockfile for haxelib.
+func listLockfile() map[api.PkgName]api.PkgVersion {
+	contentsB, err := ioutil.ReadFile(haxelibLockfile)
+	if err != nil {
+		util.Die("%s: %s", haxelibLockfile, err)
+	}
+	pkgs := map[api.PkgName]api.PkgVersion{}
+	for _, line := range strings.Split(string(contentsB), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 2 {
+			pkgs[api.PkgName(fields[0])] = api.PkgVersion(fields[1])
+		}
+	}
+	return pkgs
+}
+
+// HaxelibBackend is a UPM backend for Haxe that uses haxelib.
+var HaxelibBackend = api.LanguageBackend{
+	Name:             "haxe-haxelib",
+	Specfile:         "haxelib.json",
+	Lockfile:         haxelibLockfile,
+	FilenamePatterns: haxePatterns,
+	Quirks:           api.QuirksNotReproducible,
+	Executables:      []string{"haxelib"},
+	GetPackageDir: func() string {
+		return haxelibRepo
+	},
+	Search:       search,
+	Info:         info,
+	Add:          add,
+	Remove:       remove,
+	Install:      install,
+	ListSpecfile: listSpecfile,
+	ListLockfile: listLockfile,
+	Guess: func(ctx context.Context) (map[api.PkgName]bool, bool) {
+		util.NotImplemented()
+		return nil, false
+	},
+}
@@ -0,0 +1,209 @@
+package haxe
+
+import (
+	"bytes"
+	"encoding/json"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/replit/upm/internal/api"
+	"github.com/replit/upm/internal/util"
+)
+
+// haxelibManifest represents the relevant parts of a haxelib.json
+// file.
+type haxelibManifest struct {
+	Name string `json:"name"`
+	// Each value is a version, the empty string for any version,
+	// or a VCS source such as git:https://github.com/user/repo.
+	Dependencies map[string]string `json:"dependencies"`
+}
+
+// haxelibDependency is an entry in the dependencies object of
+// haxelib.json. Entries are kept in a slice rather than a map so that
+// their order survives editing.
+type haxelibDependency struct {
+	name    string
+	version string
+}
+
+// parseHaxelibManifest parses the given haxelib.json contents.
+func parseHaxelibManifest(contents string) haxelibManifest {
+	var manifest haxelibManifest
+	if err := json.Unmarshal([]byte(contents), &manifest); err != nil {
+		util.Die("haxelib.json: %s", err)
+	}
+	return manifest
+}
+
+// listHaxelibManifest returns the dependencies in the given
+// haxelib.json contents.
+func listHaxelibManifest(contents string) map[api.PkgName]api.PkgSpec {
+	pkgs := map[api.PkgName]api.PkgSpec{}
+	for name, version := range parseHaxelibManifest(contents).Dependencies {
+		pkgs[api.PkgName(name)] = api.PkgSpec(version)
+	}
+	return pkgs
+}
+
+// findJSONEnd returns the index just past the closing bracket of the
+// JSON array or object whose opening bracket is at contents[start],
+// or -1 if it is not closed.
+func findJSONEnd(contents string, start int) int {
+	depth := 0
+	inString := false
+	for i := start; i < len(contents); i++ {
+		switch c := contents[i]; {
+		case inString && c == '\\':
+			i++
+		case c == '"':
+			inString = !inString
+		case inString:
+			continue
+		case c == '[' || c == '{':
+			depth++
+		case c == ']' || c == '}':
+			depth--
+			if depth == 0 {
+				return i + 1
+			}
+		}
+	}
+	return -1
+}
+
+// haxelibDependenciesRegexp matches the start of the dependencies
+// object in haxelib.json. The capture group is the indentation of its
+// key.
+var haxelibDependenciesRegexp = regexp.MustCompile(`(?m)^([ \t]*)"dependencies"\s*:\s*\{`)
+
+// parseHaxelibDependencies returns the entries of the given JSON
+// object, in order.
+func parseHaxelibDependencies(object string) []haxelibDependency {
+	dec := json.NewDecoder(strings.NewReader(object))
+	if _, err := dec.Token(); err != nil {
+		util.Die("haxelib.json: %s", err)
+	}
+	deps := []haxelibDependency{}
+	for dec.More() {
+		key, err := dec.Token()
+		if err != nil {
+			util.Die("haxelib.json: %s", err)
+		}
+		var version string
+		if err := dec.Decode(&version); err != nil {
+			util.Die("haxelib.json: dependency %v: %s", key, err)
+		}
+		deps = append(deps, haxelibDependency{name: key.(string), version: version})
+	}
+	return deps
+}
+
+// jsonString returns the given string as a JSON string.
+func jsonString(s string) string {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	// Otherwise the & in a URL would be escaped.
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(s); err != nil {
+		util.Panicf("jsonString: %s", err)
+	}
+	return strings.TrimSuffix(buf.String(), "\n")
+}
+
+// rewriteHaxelibDependencies returns the given haxelib.json contents
+// with the dependencies object replaced by the result of passing its
+// entries to the given function. The object is created if necessary.
+// The rest of the file is left exactly as it was.
+func rewriteHaxelibDependencies(contents string, rewrite func(deps []haxelibDependency) []haxelibDependency) string {
+	// Check that the file is valid before editing it by hand.
+	parseHaxelibManifest(contents)
+
+	loc := haxelibDependenciesRegexp.FindStringSubmatchIndex(contents)
+	var start, end int
+	var keyIndent string
+	deps := []haxelibDependency{}
+	if loc == nil {
+		// Put the object at the end of the top-level object.
+		keyIndent = "  "
+		start = strings.LastIndexByte(contents, '}')
+		end = start
+	} else {
+		keyIndent = contents[loc[2]:loc[3]]
+		start = loc[1] - 1
+		end = findJSONEnd(contents, start)
+		if end == -1 {
+			util.Die("haxelib.json: unterminated dependencies object")
+		}
+		deps = parseHaxelibDependencies(contents[start:end])
+	}
+	unit := keyIndent
+	if unit == "" {
+		unit = "  "
+	}
+	indent := keyIndent + unit
+
+	deps = rewrite(deps)
+
+	object := "{}"
+	if len(deps) > 0 {
+		entries := []string{}
+		for _, dep := range deps {
+			entries = append(entries, jsonString(dep.name)+": "+jsonString(dep.version))
+		}
+		object = "{\n" + indent + strings.Join(entries, ",\n"+indent) + "\n" + keyIndent + "}"
+	}
+
+	if loc == nil {
+		before := strings.TrimRight(contents[:start], " \t\r\n")
+		sep := ""
+		if !strings.HasSuffix(before, "{") {
+			sep = ","
+		}
+		return before + sep + "\n" + keyIndent + `"dependencies": ` + object + "\n" + contents[start:]
+	}
+	return contents[:start] + object + contents[end:]
+}
+
+// addToHaxelibManifest returns the given haxelib.json contents with
+// the given dependencies added. Those that are there already have
+// their version changed in place.
+func addToHaxelibManifest(contents string, pkgs map[api.PkgName]api.PkgSpec) string {
+	return rewriteHaxelibDependencies(contents, func(deps []haxelibDependency) []haxelibDependency {
+		added := map[api.PkgName]bool{}
+		for i, dep := range deps {
+			if spec, ok := pkgs[api.PkgName(dep.name)]; ok {
+				deps[i].version = string(spec)
+				added[api.PkgName(dep.name)] = true
+			}
+		}
+		names := []string{}
+		for name := range pkgs {
+			if !added[name] {
+				names = append(names, string(name))
+			}
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			deps = append(deps, haxelibDependency{
+				name: name, version: string(pkgs[api.PkgName(name)]),
+			})
+		}
+		return deps
+	})
+}
+
+// removeFromHaxelibManifest returns the given haxelib.json contents
+// with the given dependencies deleted.
+func removeFromHaxelibManifest(contents string, pkgs map[api.PkgName]bool) string {
+	return rewriteHaxelibDependencies(contents, func(deps []haxelibDependency) []haxelibDependency {
+		kept := []haxelibDependency{}
+		for _, dep := range deps {
+			if !pkgs[api.PkgName(dep.name)] {
+				kept = append(kept, dep)
+			}
+		}
+		return kept
+	})
+}
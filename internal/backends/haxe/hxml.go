@@ -0,0 +1,50 @@
+package haxe
+
+import (
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/replit/upm/internal/api"
+	"github.com/replit/upm/internal/util"
+)
+
+// hxmlLibRegexp matches a line of an .hxml file that makes a library
+// available to the compiler, capturing its name and the version or
+// VCS source after the colon, if any. The compiler takes one option
+// per line, so there is nothing else on it.
+var hxmlLibRegexp = regexp.MustCompile(`^\s*(?:-lib|-L|--library)\s+([^\s:]+)(?::(\S+))?\s*$`)
+
+// hxmlFiles returns the .hxml files at the top of the project.
+func hxmlFiles() []string {
+	filenames, err := filepath.Glob("*.hxml")
+	if err != nil {
+		util.Panicf("hxmlFiles: %s", err)
+	}
+	return filenames
+}
+
+// listHxmlLibs returns the libraries used by the given .hxml file
+// contents.
+func listHxmlLibs(contents string) map[api.PkgName]api.PkgSpec {
+	pkgs := map[api.PkgName]api.PkgSpec{}
+	for _, line := range strings.Split(contents, "\n") {
+		if m := hxmlLibRegexp.FindStringSubmatch(line); m != nil {
+			pkgs[api.PkgName(m[1])] = api.PkgSpec(m[2])
+		}
+	}
+	return pkgs
+}
+
+// removeFromHxml returns the given .hxml file contents without the
+// lines that use any of the given libraries.
+func removeFromHxml(contents string, pkgs map[api.PkgName]bool) string {
+	kept := []string{}
+	for _, line := range strings.SplitAfter(contents, "\n") {
+		m := hxmlLibRegexp.FindStringSubmatch(line)
+		if m == nil || !pkgs[api.PkgName(m[1])] {
+			kept = append(kept, line)
+		}
+	}
+	return strings.Join(kept, "")
+}
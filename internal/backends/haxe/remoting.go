@@ -0,0 +1,345 @@
+package haxe
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/replit/upm/internal/util"
+)
+
+// haxelibAPIURL is the endpoint of the lib.haxe.org API, which is the
+// one haxelib itself talks to. It uses Haxe remoting: each call is a
+// POST whose __x parameter holds the method path and arguments in the
+// Haxe serialization format, and the response is "hxr" followed by
+// the serialized result.
+const haxelibAPIURL = "https://lib.haxe.org/api/3.0/index.n/"
+
+// remotingException is an exception thrown by the server while
+// handling a remoting call. Its value is usually a message string.
+type remotingException struct {
+	value interface{}
+}
+
+func (e remotingException) Error() string {
+	return fmt.Sprint(e.value)
+}
+
+// isURIUnreserved returns true if encodeURIComponent leaves the given
+// byte as it is, which is what the Haxe serializer uses for strings.
+func isURIUnreserved(c byte) bool {
+	return 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' ||
+		strings.IndexByte("-_.!~*'()", c) != -1
+}
+
+// serializeString returns the given string in the Haxe serialization
+// format.
+func serializeString(s string) string {
+	var encoded strings.Builder
+	for i := 0; i < len(s); i++ {
+		if isURIUnreserved(s[i]) {
+			encoded.WriteByte(s[i])
+		} else {
+			fmt.Fprintf(&encoded, "%%%02X", s[i])
+		}
+	}
+	return fmt.Sprintf("y%d:%s", encoded.Len(), encoded.String())
+}
+
+// serializeCall returns the __x parameter of a remoting call to the
+// given method of the API with the given string arguments.
+func serializeCall(method string, args ...string) string {
+	var buf strings.Builder
+	buf.WriteString("a" + serializeString("api") + serializeString(method) + "h")
+	buf.WriteString("a")
+	for _, arg := range args {
+		buf.WriteString(serializeString(arg))
+	}
+	buf.WriteString("h")
+	return buf.String()
+}
+
+// unserializer decodes a value in the Haxe serialization format. Only
+// the parts of the format that the API can return are supported:
+// class instances and enums are not.
+type unserializer struct {
+	buf string
+	pos int
+	// Strings seen so far, which can be referred to with R.
+	strings []string
+	// Objects, arrays, lists, maps and dates seen so far, which
+	// can be referred to with r.
+	cache []interface{}
+}
+
+// unserialize decodes the given string, returning strings, ints,
+// float64s, bools, nil, time.Times, []interface{}s for arrays and
+// lists, and map[string]interface{}s for objects and string maps.
+func unserialize(s string) (interface{}, error) {
+	u := &unserializer{buf: s}
+	value, err := u.value()
+	if err != nil {
+		return nil, err
+	}
+	if u.pos != len(u.buf) {
+		return nil, fmt.Errorf("unexpected data at offset %d", u.pos)
+	}
+	return value, nil
+}
+
+// next returns the next byte of input, or 0 at the end.
+func (u *unserializer) next() byte {
+	if u.pos >= len(u.buf) {
+		return 0
+	}
+	c := u.buf[u.pos]
+	u.pos++
+	return c
+}
+
+// peek returns the next byte of input without consuming it, or 0 at
+// the end.
+func (u *unserializer) peek() byte {
+	if u.pos >= len(u.buf) {
+		return 0
+	}
+	return u.buf[u.pos]
+}
+
+// readWhile consumes and returns the longest run of bytes that are in
+// the given set.
+func (u *unserializer) readWhile(set string) string {
+	start := u.pos
+	for u.pos < len(u.buf) && strings.IndexByte(set, u.buf[u.pos]) != -1 {
+		u.pos++
+	}
+	return u.buf[start:u.pos]
+}
+
+// readInt consumes an integer, such as the one after i, R or r.
+func (u *unserializer) readInt() (int, error) {
+	digits := u.readWhile("-0123456789")
+	n, err := strconv.Atoi(digits)
+	if err != nil {
+		return 0, fmt.Errorf("invalid integer at offset %d", u.pos-len(digits))
+	}
+	return n, nil
+}
+
+// readFloat consumes a floating-point number, such as the one after
+// d.
+func (u *unserializer) readFloat() (float64, error) {
+	digits := u.readWhile("-+.eE0123456789")
+	f, err := strconv.ParseFloat(digits, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid number at offset %d", u.pos-len(digits))
+	}
+	return f, nil
+}
+
+// readString consumes a string, which is either y followed by its
+// length and URL-encoded contents or R followed by the index of a
+// string seen already.
+func (u *unserializer) readString() (string, error) {
+	switch u.next() {
+	case 'y':
+		length, err := u.readInt()
+		if err != nil {
+			return "", err
+		}
+		if u.next() != ':' || length < 0 || u.pos+length > len(u.buf) {
+			return "", fmt.Errorf("invalid string at offset %d", u.pos)
+		}
+		s, err := url.QueryUnescape(u.buf[u.pos : u.pos+length])
+		if err != nil {
+			return "", err
+		}
+		u.pos += length
+		u.strings = append(u.strings, s)
+		return s, nil
+	case 'R':
+		n, err := u.readInt()
+		if err != nil {
+			return "", err
+		}
+		if n < 0 || n >= len(u.strings) {
+			return "", fmt.Errorf("invalid string reference %d", n)
+		}
+		return u.strings[n], nil
+	default:
+		return "", fmt.Errorf("expected a string at offset %d", u.pos-1)
+	}
+}
+
+// value consumes and returns the next value.
+func (u *unserializer) value() (interface{}, error) {
+	switch c := u.peek(); c {
+	case 'y', 'R':
+		return u.readString()
+	}
+
+	start := u.pos
+	switch c := u.next(); c {
+	case 'n':
+		return nil, nil
+	case 't':
+		return true, nil
+	case 'f':
+		return false, nil
+	case 'z':
+		return 0, nil
+	case 'i':
+		return u.readInt()
+	case 'd':
+		return u.readFloat()
+	case 'k', 'm', 'p':
+		// NaN and the infinities, which the API never really
+		// returns.
+		return nil, nil
+	case 'a', 'l':
+		index := len(u.cache)
+		u.cache = append(u.cache, nil)
+		elems := []interface{}{}
+		for u.peek() != 'h' {
+			if u.peek() == 0 {
+				return nil, fmt.Errorf("unterminated array at offset %d", start)
+			}
+			if c == 'a' && u.peek() == 'u' {
+				// A run of nulls.
+				u.pos++
+				n, err := u.readInt()
+				if err != nil {
+					return nil, err
+				}
+				for i := 0; i < n; i++ {
+					elems = append(elems, nil)
+				}
+				continue
+			}
+			elem, err := u.value()
+			if err != nil {
+				return nil, err
+			}
+			elems = append(elems, elem)
+		}
+		u.pos++
+		u.cache[index] = elems
+		return elems, nil
+	case 'o', 'b':
+		// Objects end with g and string maps with h; both are
+		// keyed by strings.
+		end := byte('g')
+		if c == 'b' {
+			end = 'h'
+		}
+		object := map[string]interface{}{}
+		u.cache = append(u.cache, object)
+		for u.peek() != end {
+			if u.peek() == 0 {
+				return nil, fmt.Errorf("unterminated object at offset %d", start)
+			}
+			key, err := u.readString()
+			if err != nil {
+				return nil, err
+			}
+			value, err := u.value()
+			if err != nil {
+				return nil, err
+			}
+			object[key] = value
+		}
+		u.pos++
+		return object, nil
+	case 'r':
+		n, err := u.readInt()
+		if err != nil {
+			return nil, err
+		}
+		if n < 0 || n >= len(u.cache) {
+			return nil, fmt.Errorf("invalid reference %d", n)
+		}
+		return u.cache[n], nil
+	case 'v':
+		// Older versions of Haxe serialize dates as local time
+		// in the format below; newer ones as milliseconds since
+		// the epoch.
+		var date time.Time
+		const layout = "2006-01-02 15:04:05"
+		if u.pos+4 < len(u.buf) && u.buf[u.pos+4] == '-' {
+			if u.pos+len(layout) > len(u.buf) {
+				return nil, fmt.Errorf("invalid date at offset %d", start)
+			}
+			var err error
+			date, err = time.ParseInLocation(layout, u.buf[u.pos:u.pos+len(layout)], time.Local)
+			if err != nil {
+				return nil, err
+			}
+			u.pos += len(layout)
+		} else {
+			ms, err := u.readFloat()
+			if err != nil {
+				return nil, err
+			}
+			date = time.Unix(0, int64(ms)*int64(time.Millisecond))
+		}
+		u.cache = append(u.cache, date)
+		return date, nil
+	case 0:
+		return nil, fmt.Errorf("unexpected end of data")
+	default:
+		return nil, fmt.Errorf("unsupported value %q at offset %d", c, start)
+	}
+}
+
+// haxelibCall calls the given method of the lib.haxe.org API, and
+// returns its result. If the server throws an exception, it is
+// returned as a remotingException.
+func haxelibCall(ctx context.Context, method string, args ...string) (interface{}, error) {
+	form := url.Values{"__x": {serializeCall(method, args...)}}
+	req, err := http.NewRequest("POST", haxelibAPIURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		util.Panicf("haxelibCall: %s", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("X-Haxe-Remoting", "1")
+
+	resp, err := util.HTTPDo(ctx, req)
+	if err != nil {
+		util.Die("lib.haxe.org: %s", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		util.Die("lib.haxe.org: HTTP status %d", resp.StatusCode)
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		util.Die("lib.haxe.org: %s", err)
+	}
+	return parseRemotingResponse(string(body))
+}
+
+// parseRemotingResponse decodes the body of the response to a
+// remoting call.
+func parseRemotingResponse(body string) (interface{}, error) {
+	if !strings.HasPrefix(body, "hxr") {
+		util.Die("lib.haxe.org: invalid response")
+	}
+	data := strings.TrimPrefix(body, "hxr")
+	exception := strings.HasPrefix(data, "x")
+	if exception {
+		data = data[1:]
+	}
+	value, err := unserialize(data)
+	if err != nil {
+		util.Die("lib.haxe.org response: %s", err)
+	}
+	if exception {
+		return nil, remotingException{value}
+	}
+	return value, nil
+}
@@ -2,6 +2,7 @@
 package java
 
 import (
+	"context"
 	"encoding/xml"
 	"fmt"
 	"io/ioutil"
@@ -113,7 +114,7 @@ func readProjectOrMakeEmpty(path string) Project {
 
 const pomdotxml = "pom.xml"
 
-func addPackages(pkgs map[api.PkgName]api.PkgSpec, projectName string) {
+func addPackages(ctx context.Context, pkgs map[api.PkgName]api.PkgSpec, projectName string) {
 	project := readProjectOrMakeEmpty(pomdotxml)
 	existingDependencies := map[api.PkgName]api.PkgVersion{}
 	for _, dependency := range project.Dependencies {
@@ -147,7 +148,7 @@ func addPackages(pkgs map[api.PkgName]api.PkgSpec, projectName string) {
 		} else {
 			query = fmt.Sprintf("g:%s AND a:%s AND v:%s", groupId, artifactId, pkgSpec)
 		}
-		searchDocs, err := Search(query)
+		searchDocs, err := Search(ctx, query)
 		if err != nil {
 			util.Die(
 				"error searching maven for latest version of %s:%s: %s",
@@ -200,7 +201,7 @@ func addPackages(pkgs map[api.PkgName]api.PkgSpec, projectName string) {
 	util.TryWriteAtomic("pom.xml", contentsB)
 }
 
-func removePackages(pkgs map[api.PkgName]bool) {
+func removePackages(ctx context.Context, pkgs map[api.PkgName]bool) {
 	project := readProjectOrMakeEmpty(pomdotxml)
 
 	dependenciesToKeep := []Dependency{}
@@ -255,8 +256,8 @@ func listLockfile() map[api.PkgName]api.PkgVersion {
 	return pkgs
 }
 
-func search(query string) []api.PkgInfo {
-	searchDocs, err := Search(query)
+func search(ctx context.Context, query string) []api.PkgInfo {
+	searchDocs, err := Search(ctx, query)
 	if err != nil {
 		util.Die("error searching maven %s", err)
 	}
@@ -271,8 +272,8 @@ func search(query string) []api.PkgInfo {
 	return pkgInfos
 }
 
-func info(pkgName api.PkgName) api.PkgInfo {
-	searchDoc, err := Info(string(pkgName))
+func info(ctx context.Context, pkgName api.PkgName) api.PkgInfo {
+	searchDoc, err := Info(ctx, string(pkgName))
 
 	if err != nil {
 		util.Die("error searching maven %s", err)
@@ -294,8 +295,10 @@ var JavaBackend = api.LanguageBackend{
 	Name:             "java-maven",
 	Specfile:         pomdotxml,
 	Lockfile:         pomdotxml,
+	OSVEcosystem:     "Maven",
 	FilenamePatterns: javaPatterns,
 	Quirks:           api.QuirksAddRemoveAlsoLocks,
+	Executables:      []string{"mvn"},
 	GetPackageDir: func() string {
 		return "target/dependency"
 	},
@@ -303,8 +306,8 @@ var JavaBackend = api.LanguageBackend{
 	Info:   info,
 	Add:    addPackages,
 	Remove: removePackages,
-	Install: func() {
-		util.RunCmd([]string{
+	Install: func(ctx context.Context) {
+		util.RunCmd(ctx, []string{
 			"mvn",
 			"de.qaware.maven:go-offline-maven-plugin:resolve-dependencies",
 			"dependency:copy-dependencies",
@@ -312,5 +315,5 @@ var JavaBackend = api.LanguageBackend{
 	},
 	ListSpecfile: listSpecfile,
 	ListLockfile: listLockfile,
-	Lock:         func() {},
+	Lock:         func(ctx context.Context) {},
 }
@@ -24,12 +24,14 @@
 package java
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
-	"net/http"
 	"net/url"
 	"strings"
+
+	"github.com/replit/upm/internal/util"
 )
 
 const (
@@ -50,8 +52,8 @@ type SearchResult struct {
 	} `json:"response"`
 }
 
-func mavenSearch(searchURL string) ([]SearchDoc, error) {
-	res, err := http.Get(searchURL)
+func mavenSearch(ctx context.Context, searchURL string) ([]SearchDoc, error) {
+	res, err := util.HTTPGet(ctx, searchURL)
 	if err != nil {
 		return []SearchDoc{}, err
 	}
@@ -72,13 +74,13 @@ func mavenSearch(searchURL string) ([]SearchDoc, error) {
 	return searchResult.Response.Docs, nil
 }
 
-func Search(keyword string) ([]SearchDoc, error) {
+func Search(ctx context.Context, keyword string) ([]SearchDoc, error) {
 	searchURL := mavenURL + url.QueryEscape(keyword)
 
-	return mavenSearch(searchURL)
+	return mavenSearch(ctx, searchURL)
 }
 
-func Info(name string) (SearchDoc, error) {
+func Info(ctx context.Context, name string) (SearchDoc, error) {
 	parts := strings.Split(string(name), ":")
 
 	var searchURL string
@@ -88,7 +90,7 @@ func Info(name string) (SearchDoc, error) {
 		searchURL = fmt.Sprintf("%sa:%s&core=gav", mavenURL, url.QueryEscape(fmt.Sprintf("%q", parts[0])))
 	}
 
-	docs, err := mavenSearch(searchURL)
+	docs, err := mavenSearch(ctx, searchURL)
 
 	if err != nil {
 		return SearchDoc{}, err
@@ -1,11 +1,12 @@
 package java
 
 import (
+	"context"
 	"testing"
 )
 
 func TestSearchMavenCentral(t *testing.T) {
-	results, err := Search("junit")
+	results, err := Search(context.Background(), "junit")
 
 	if err != nil {
 		t.Errorf("Search failed with \n%q\n", err)
@@ -28,7 +29,7 @@ func TestSearchMavenCentral(t *testing.T) {
 
 func TestInfoMavenCentral(t *testing.T) {
 	pkg := "org.apache.logging.log4j:log4j-core"
-	info, err := Info(pkg)
+	info, err := Info(context.Background(), pkg)
 
 	if err != nil {
 		t.Errorf("Failed to find package with \n%q\n", err)
@@ -41,7 +42,7 @@ func TestInfoMavenCentral(t *testing.T) {
 
 func TestInfoWithArtifactNameOnly(t *testing.T) {
 	artifact := "log4j-core"
-	info, err := Info(artifact)
+	info, err := Info(context.Background(), artifact)
 
 	if err != nil {
 		t.Errorf("Failed to find package with \n%q\n", err)
@@ -54,7 +55,7 @@ func TestInfoWithArtifactNameOnly(t *testing.T) {
 
 func TestInfoWithUnknownArtifact(t *testing.T) {
 	artifact := "yyy"
-	info, err := Info(artifact)
+	info, err := Info(context.Background(), artifact)
 
 	if err != nil {
 		t.Errorf("Failed to find package with \n%q\n", err)
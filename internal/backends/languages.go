@@ -0,0 +1,214 @@
+package backends
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/replit/upm/internal/api"
+	"github.com/replit/upm/internal/util"
+)
+
+// DirLanguage is one language that ScanLanguages found in one
+// directory.
+type DirLanguage struct {
+	// The directory, relative to where the scan started, which is
+	// ".".
+	Dir string `json:"dir"`
+	// The language, such as python or nodejs: the first part of the
+	// names of its backends.
+	Language string `json:"language"`
+	// How many files directly in the directory match the filename
+	// patterns of the language.
+	Files int `json:"files"`
+	// The specfiles and lockfiles of the language that are in the
+	// directory, sorted.
+	Manifests []string `json:"manifests"`
+	// The nearest directory, this one or one above it, with a
+	// manifest of the language, or empty if there is none, in which
+	// case nothing manages the packages of the files.
+	Project string `json:"project"`
+}
+
+// Suggestion is a directory whose files of a language aren't part of
+// a project, as ScanLanguages says, with a manifest that it could be
+// given.
+type Suggestion struct {
+	Dir      string `json:"dir"`
+	Language string `json:"language"`
+	// How many files of the language are in the directory and the
+	// directories in it, none of which have a manifest.
+	Files int `json:"files"`
+	// The specfile of the first backend of the language, which is
+	// the one that would be used for a new project.
+	Specfile string `json:"specfile"`
+}
+
+// BackendLanguage returns the language of the given backend, the
+// first part of its name.
+func BackendLanguage(b api.LanguageBackend) string {
+	return strings.SplitN(b.Name, "-", 2)[0]
+}
+
+// languageManifests returns, by language, the specfiles and lockfiles
+// that its backends use.
+func languageManifests() map[string][]string {
+	manifests := map[string][]string{}
+	for _, b := range languageBackends {
+		language := BackendLanguage(b)
+		for _, filename := range append([]string{b.Specfile, b.Lockfile}, b.LockfileFlavors...) {
+			known := filename == ""
+			for _, other := range manifests[language] {
+				known = known || other == filename
+			}
+			if !known {
+				manifests[language] = append(manifests[language], filename)
+			}
+		}
+	}
+	return manifests
+}
+
+// ScanLanguages walks the current directory, skipping
+// util.IgnoredPaths, and returns each language that each directory has
+// files or manifests of, sorted by directory and then language, and
+// the directories that have files of a language but no manifest of it
+// in them or any directory above them. Only the topmost of those is
+// suggested, with the files of the directories in it, since a project
+// there would cover them all. If ctx is done, ScanLanguages aborts
+// (see util.Abort).
+func ScanLanguages(ctx context.Context) ([]DirLanguage, []Suggestion) {
+	manifests := languageManifests()
+	patterns := map[string][]string{}
+	specfiles := map[string]string{}
+	languages := []string{}
+	for _, b := range languageBackends {
+		language := BackendLanguage(b)
+		if _, ok := specfiles[language]; !ok {
+			specfiles[language] = b.Specfile
+			languages = append(languages, language)
+		}
+		patterns[language] = append(patterns[language], b.FilenamePatterns...)
+	}
+	sort.Strings(languages)
+
+	found := map[string]map[string]*DirLanguage{}
+	get := func(dir string, language string) *DirLanguage {
+		if found[dir] == nil {
+			found[dir] = map[string]*DirLanguage{}
+		}
+		if found[dir][language] == nil {
+			found[dir][language] = &DirLanguage{Dir: dir, Language: language, Manifests: []string{}}
+		}
+		return found[dir][language]
+	}
+
+	filepath.Walk(".", func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			util.Die("%s: %s", path, err)
+		}
+		util.Abort(ctx)
+		name := filepath.Base(path)
+		if info.IsDir() {
+			for _, ignored := range util.IgnoredPaths {
+				if path != "." && name == ignored {
+					return filepath.SkipDir
+				}
+			}
+			return nil
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+
+		dir := filepath.Dir(path)
+		for _, language := range languages {
+			for _, manifest := range manifests[language] {
+				if name == manifest {
+					entry := get(dir, language)
+					entry.Manifests = append(entry.Manifests, name)
+				}
+			}
+			for _, pattern := range patterns[language] {
+				if matched, _ := filepath.Match(pattern, name); matched {
+					get(dir, language).Files++
+					break
+				}
+			}
+		}
+		return nil
+	})
+
+	dirs := []DirLanguage{}
+	for _, languages := range found {
+		for _, entry := range languages {
+			sort.Strings(entry.Manifests)
+			dirs = append(dirs, *entry)
+		}
+	}
+	sort.Slice(dirs, func(i, j int) bool {
+		if dirs[i].Dir != dirs[j].Dir {
+			return dirs[i].Dir < dirs[j].Dir
+		}
+		return dirs[i].Language < dirs[j].Language
+	})
+
+	// A directory comes before those in it, so the suggestion for
+	// the topmost one is made first.
+	suggested := map[string]map[string]*Suggestion{}
+	for _, language := range languages {
+		suggested[language] = map[string]*Suggestion{}
+	}
+	suggestions := []*Suggestion{}
+	for i := range dirs {
+		entry := &dirs[i]
+		for dir := entry.Dir; ; dir = filepath.Dir(dir) {
+			if above := found[dir][entry.Language]; above != nil && len(above.Manifests) > 0 {
+				entry.Project = dir
+				break
+			}
+			if dir == "." {
+				break
+			}
+		}
+		if entry.Project != "" || entry.Files == 0 {
+			continue
+		}
+		var suggestion *Suggestion
+		for dir := entry.Dir; suggestion == nil; dir = filepath.Dir(dir) {
+			suggestion = suggested[entry.Language][dir]
+			if dir == "." {
+				break
+			}
+		}
+		if suggestion == nil {
+			suggestion = &Suggestion{
+				Dir:      entry.Dir,
+				Language: entry.Language,
+				Specfile: specfiles[entry.Language],
+			}
+			suggested[entry.Language][entry.Dir] = suggestion
+			suggestions = append(suggestions, suggestion)
+		}
+		suggestion.Files += entry.Files
+	}
+
+	result := []Suggestion{}
+	for _, suggestion := range suggestions {
+		result = append(result, *suggestion)
+	}
+	return dirs, result
+}
+
+// String returns the suggestion as a sentence.
+func (s Suggestion) String() string {
+	files := "files"
+	if s.Files == 1 {
+		files = "file"
+	}
+	return fmt.Sprintf("%s has %d %s %s but no manifest; consider adding %s there",
+		s.Dir, s.Files, s.Language, files, s.Specfile)
+}
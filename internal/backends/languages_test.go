@@ -0,0 +1,44 @@
+package backends
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestScanLanguages(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.Chdir(dir))
+
+	for _, file := range []string{
+		"services/api/app.py",
+		"services/api/handlers/users.py",
+		"services/api/handlers/items.py",
+		"web/package.json",
+		"web/src/index.js",
+		"web/node_modules/left-pad/index.js",
+		"tools/lint/main.py",
+		"tools/lint/pyproject.toml",
+	} {
+		require.NoError(t, os.MkdirAll(filepath.Dir(file), 0777))
+		require.NoError(t, ioutil.WriteFile(file, []byte{}, 0666))
+	}
+
+	dirs, suggestions := ScanLanguages(context.Background())
+	require.Equal(t, []DirLanguage{
+		{Dir: "services/api", Language: "python", Files: 1, Manifests: []string{}},
+		{Dir: "services/api/handlers", Language: "python", Files: 2, Manifests: []string{}},
+		{Dir: "tools/lint", Language: "python", Files: 1, Manifests: []string{"pyproject.toml"}, Project: "tools/lint"},
+		{Dir: "web", Language: "nodejs", Files: 0, Manifests: []string{"package.json"}, Project: "web"},
+		{Dir: "web/src", Language: "nodejs", Files: 1, Manifests: []string{}, Project: "web"},
+	}, dirs)
+	require.Equal(t, []Suggestion{
+		{Dir: "services/api", Language: "python", Files: 3, Specfile: "pyproject.toml"},
+	}, suggestions)
+	require.Equal(t, "services/api has 3 python files but no manifest; consider adding pyproject.toml there",
+		suggestions[0].String())
+}
@@ -0,0 +1,226 @@
+// Package lua provides a backend for Lua using LuaRocks.
+package lua
+
+import (
+	"context"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/replit/upm/internal/api"
+	"github.com/replit/upm/internal/util"
+)
+
+// luaPatterns is the FilenamePatterns value for LuaRocksBackend.
+var luaPatterns = []string{"*.lua"}
+
+// luaTree is the directory, relative to the project, into which
+// rocks are installed. It matches what 'luarocks init' sets up.
+const luaTree = "lua_modules"
+
+// luaStdlibModules are modules provided by the Lua interpreter (or
+// LuaJIT) itself, which must never be guessed as rocks.
+var luaStdlibModules = map[string]bool{
+	"_G":        true,
+	"bit":       true,
+	"bit32":     true,
+	"coroutine": true,
+	"debug":     true,
+	"ffi":       true,
+	"io":        true,
+	"jit":       true,
+	"math":      true,
+	"os":        true,
+	"package":   true,
+	"string":    true,
+	"table":     true,
+	"utf8":      true,
+}
+
+// luaModuleToRock maps commonly used modules to the rocks that
+// provide them, for the cases where the names differ.
+var luaModuleToRock = map[string]string{
+	"cjson":  "lua-cjson",
+	"lfs":    "luafilesystem",
+	"ltn12":  "luasocket",
+	"mime":   "luasocket",
+	"pl":     "penlight",
+	"posix":  "luaposix",
+	"socket": "luasocket",
+	"ssl":    "luasec",
+}
+
+// luaRequireRegexp matches require("mod"), require "mod", and
+// require 'mod'.
+var luaRequireRegexp = regexp.MustCompile(`require\s*\(?\s*["']([^"']+)["']`)
+
+// searchWithPorcelain runs 'luarocks search --porcelain' and returns
+// the latest version of each matching rock, in the order reported.
+func searchWithPorcelain(ctx context.Context, query string) []api.PkgInfo {
+	outputB := util.GetCmdOutput(ctx, []string{
+		"luarocks", "search", "--porcelain", query,
+	})
+	results := []api.PkgInfo{}
+	seen := map[string]bool{}
+	for _, line := range strings.Split(string(outputB), "\n") {
+		fields := strings.Split(line, "\t")
+		if len(fields) < 2 || seen[fields[0]] {
+			continue
+		}
+		seen[fields[0]] = true
+		results = append(results, api.PkgInfo{
+			Name:    fields[0],
+			Version: fields[1],
+		})
+	}
+	return results
+}
+
+// rockspecFieldRegexp returns a regexp matching a string-valued field
+// with the given key in a rockspec.
+func rockspecFieldRegexp(key string) *regexp.Regexp {
+	return regexp.MustCompile(`(?m)^\s*` + key + `\s*=\s*["']([^"']*)["']`)
+}
+
+// info implements Info for LuaRocks. The version comes from the
+// search index, and the rest of the metadata from the published
+// rockspec for that version.
+func info(ctx context.Context, name api.PkgName) api.PkgInfo {
+	var result api.PkgInfo
+	for _, pkg := range searchWithPorcelain(ctx, string(name)) {
+		if pkg.Name == string(name) {
+			result = pkg
+			break
+		}
+	}
+	if result.Name == "" {
+		return api.PkgInfo{}
+	}
+
+	endpoint := "https://luarocks.org/"
+	path := util.EscapePathSegment(result.Name + "-" + result.Version + ".rockspec")
+
+	resp, err := util.HTTPGet(ctx, endpoint+path)
+	if err != nil {
+		util.Die("LuaRocks: %s", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		// The index knows about the rock, so it is better to
+		// return what we have than nothing at all.
+		return result
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		util.Die("LuaRocks: %s", err)
+	}
+	contents := string(body)
+
+	field := func(key string) string {
+		if m := rockspecFieldRegexp(key).FindStringSubmatch(contents); m != nil {
+			return m[1]
+		}
+		return ""
+	}
+	result.Description = field("summary")
+	result.HomepageURL = field("homepage")
+	result.License = field("license")
+	result.Author = field("maintainer")
+
+	deps := []string{}
+	for dep := range listSpecfileWithContents(contents) {
+		deps = append(deps, string(dep))
+	}
+	result.Dependencies = deps
+
+	return result
+}
+
+// add implements Add for LuaRocks by rewriting the dependencies table
+// of the rockspec.
+func add(ctx context.Context, pkgs map[api.PkgName]api.PkgSpec, projectName string) {
+	specfile, contents := readSpecfile(projectName)
+	contents = addToSpecfileContents(contents, pkgs)
+	util.ProgressMsg("write " + specfile)
+	util.TryWriteAtomic(specfile, []byte(contents))
+}
+
+// remove implements Remove for LuaRocks by rewriting the dependencies
+// table of the rockspec.
+func remove(ctx context.Context, pkgs map[api.PkgName]bool) {
+	specfile, contents := readSpecfile("")
+	contents = removeFromSpecfileContents(contents, pkgs)
+	util.ProgressMsg("write " + specfile)
+	util.TryWriteAtomic(specfile, []byte(contents))
+}
+
+// guess implements Guess for LuaRocks. Modules that are part of the
+// standard library, or that are provided by files in the project
+// itself, are skipped.
+func guess(ctx context.Context) (map[api.PkgName]bool, bool) {
+	pkgs := map[api.PkgName]bool{}
+	for _, match := range util.SearchRecursive(ctx, luaRequireRegexp, luaPatterns) {
+		mod := strings.SplitN(match[1], ".", 2)[0]
+		if mod == "" || luaStdlibModules[mod] {
+			continue
+		}
+		if util.Exists(mod+".lua") || util.Exists(filepath.Join(mod, "init.lua")) {
+			continue
+		}
+		if rock, ok := luaModuleToRock[mod]; ok {
+			mod = rock
+		}
+		pkgs[api.PkgName(mod)] = true
+	}
+	return pkgs, true
+}
+
+// LuaRocksBackend is a UPM backend for Lua that uses LuaRocks.
+var LuaRocksBackend = api.LanguageBackend{
+	Name:             "lua-luarocks",
+	GetSpecfile:      findSpecfile,
+	Lockfile:         "luarocks.lock",
+	FilenamePatterns: luaPatterns,
+	Quirks:           api.QuirksLockAlsoInstalls,
+	Executables:      []string{"luarocks"},
+	GetPackageDir: func() string {
+		return luaTree
+	},
+	Search: searchWithPorcelain,
+	Info:   info,
+	Add:    add,
+	Remove: remove,
+	Lock: func(ctx context.Context) {
+		// --pin writes luarocks.lock next to the rockspec,
+		// recording the exact version of every dependency
+		// that was installed.
+		util.RunCmd(ctx, []string{
+			"luarocks", "build", "--only-deps", "--pin",
+			"--tree", luaTree, findSpecfile(),
+		})
+	},
+	Install: func(ctx context.Context) {
+		// When luarocks.lock is present, LuaRocks installs
+		// exactly the versions it lists.
+		util.RunCmd(ctx, []string{
+			"luarocks", "build", "--only-deps",
+			"--tree", luaTree, findSpecfile(),
+		})
+	},
+	ListSpecfile: func() map[api.PkgName]api.PkgSpec {
+		_, contents := readSpecfile("")
+		return listSpecfileWithContents(contents)
+	},
+	ListLockfile: func() map[api.PkgName]api.PkgVersion {
+		contentsB, err := ioutil.ReadFile("luarocks.lock")
+		if err != nil {
+			util.Die("luarocks.lock: %s", err)
+		}
+		return listLockfileWithContents(string(contentsB))
+	},
+	GuessRegexps: []*regexp.Regexp{luaRequireRegexp},
+	Guess:        guess,
+}
@@ -0,0 +1,120 @@
+package lua
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/replit/upm/internal/api"
+)
+
+const testRockspec = `package = "myproject"
+version = "dev-1"
+source = {
+   url = "git+https://example.com/myproject.git"
+}
+dependencies = {
+   "lua >= 5.1",
+   "luasocket ~> 3.0",
+   'penlight',
+}
+build = {
+   type = "builtin",
+   modules = {}
+}
+`
+
+func TestListSpecfile(t *testing.T) {
+	pkgs := listSpecfileWithContents(testRockspec)
+
+	require.Equal(t, map[api.PkgName]api.PkgSpec{
+		"luasocket": "~> 3.0",
+		"penlight":  "",
+	}, pkgs)
+}
+
+func TestParseDependency(t *testing.T) {
+	for dep, expected := range map[string][2]string{
+		"penlight":         {"penlight", ""},
+		"luasocket ~> 3.0": {"luasocket", "~> 3.0"},
+		"luasocket>=3.0":   {"luasocket", ">=3.0"},
+		"lua-cjson == 2.1": {"lua-cjson", "== 2.1"},
+		" lpeg~=1.0 ":      {"lpeg", "~=1.0"},
+		"inspect 3.1":      {"inspect", "3.1"},
+	} {
+		name, spec := parseDependency(dep)
+		require.Equal(t, expected, [2]string{string(name), string(spec)}, dep)
+	}
+}
+
+func TestFindSpecfile(t *testing.T) {
+	cwd, err := os.Getwd()
+	require.NoError(t, err)
+	defer os.Chdir(cwd)
+	dir := t.TempDir()
+	require.NoError(t, os.Chdir(dir))
+
+	// The rockspec is looked for in the directory that UPM is
+	// in when the backend is set up, not when it is loaded.
+	b := LuaRocksBackend
+	b.Setup()
+	require.Equal(t, strings.ToLower(filepath.Base(dir))+"-dev-1.rockspec", b.Specfile)
+
+	require.NoError(t, ioutil.WriteFile("myproject-1.0-1.rockspec", []byte(testRockspec), 0644))
+	b = LuaRocksBackend
+	b.Setup()
+	require.Equal(t, "myproject-1.0-1.rockspec", b.Specfile)
+}
+
+func TestAddAndRemove(t *testing.T) {
+	contents := addToSpecfileContents(testRockspec, map[api.PkgName]api.PkgSpec{
+		"lua-cjson": ">= 2.1",
+	})
+	require.Equal(t, map[api.PkgName]api.PkgSpec{
+		"luasocket": "~> 3.0",
+		"penlight":  "",
+		"lua-cjson": ">= 2.1",
+	}, listSpecfileWithContents(contents))
+	require.Contains(t, contents, `   "lua >= 5.1",`)
+	require.Contains(t, contents, "build = {")
+
+	contents = removeFromSpecfileContents(contents, map[api.PkgName]bool{
+		"luasocket": true,
+	})
+	require.Equal(t, map[api.PkgName]api.PkgSpec{
+		"penlight":  "",
+		"lua-cjson": ">= 2.1",
+	}, listSpecfileWithContents(contents))
+}
+
+func TestAddWithoutDependenciesTable(t *testing.T) {
+	contents := addToSpecfileContents(`package = "x"`, map[api.PkgName]api.PkgSpec{
+		"lpeg":    "",
+		"inspect": ">= 3.1",
+	})
+	require.Equal(t, map[api.PkgName]api.PkgSpec{
+		"lpeg":    "",
+		"inspect": ">= 3.1",
+	}, listSpecfileWithContents(contents))
+	require.Less(t, strings.Index(contents, `"inspect >= 3.1"`), strings.Index(contents, `"lpeg"`))
+}
+
+func TestListLockfile(t *testing.T) {
+	pkgs := listLockfileWithContents(`return {
+   dependencies = {
+      ["lua-cjson"] = "2.1.0.10-1",
+      lpeg = "1.0.2-1",
+      lua = "5.4-1"
+   },
+}
+`)
+
+	require.Equal(t, map[api.PkgName]api.PkgVersion{
+		"lua-cjson": "2.1.0.10-1",
+		"lpeg":      "1.0.2-1",
+	}, pkgs)
+}
@@ -0,0 +1,201 @@
+package lua
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/replit/upm/internal/api"
+	"github.com/replit/upm/internal/util"
+)
+
+// initialRockspec is the template used to create a rockspec when
+// adding packages to a project that does not have one yet. The
+// package name is substituted in.
+const initialRockspec = `package = %q
+version = "dev-1"
+source = {
+   url = "*** please add URL for source tarball, zip or repository here ***"
+}
+description = {
+   summary = "",
+}
+dependencies = {
+   "lua >= 5.1",
+}
+build = {
+   type = "builtin",
+   modules = {}
+}
+`
+
+// dependenciesRegexp matches the dependencies table of a rockspec.
+// The first capture group is the body of the table. Dependency tables
+// never contain nested tables, so matching up to the first closing
+// brace is sufficient.
+var dependenciesRegexp = regexp.MustCompile(`(?m)^dependencies\s*=\s*\{([^}]*)\}`)
+
+// dependencyStringRegexp matches a single string literal inside the
+// dependencies table, e.g. "luasocket ~> 3.0".
+var dependencyStringRegexp = regexp.MustCompile(`["']([^"']+)["']`)
+
+// findSpecfile returns the name of the rockspec in the current
+// directory. If there is none, it returns the name that a new
+// rockspec for the current directory would get.
+func findSpecfile() string {
+	if matches, err := filepath.Glob("*.rockspec"); err == nil && len(matches) > 0 {
+		return matches[0]
+	}
+	return defaultSpecfile("")
+}
+
+// defaultSpecfile returns the rockspec filename for a new project.
+// If projectName is empty, the basename of the current directory is
+// used instead.
+func defaultSpecfile(projectName string) string {
+	if projectName == "" {
+		cwd, err := os.Getwd()
+		if err != nil {
+			util.Die("%s", err)
+		}
+		projectName = strings.ToLower(filepath.Base(cwd))
+	}
+	return projectName + "-dev-1.rockspec"
+}
+
+// parseDependency splits a rockspec dependency string like
+// "luasocket ~> 3.0" into a package name and spec. LuaRocks doesn't
+// require a space between them, as in "luasocket>=3.0", so the name
+// ends at the first space or version operator.
+func parseDependency(dep string) (api.PkgName, api.PkgSpec) {
+	dep = strings.TrimSpace(dep)
+	end := strings.IndexAny(dep, " \t=~<>")
+	if end == -1 {
+		return api.PkgName(dep), ""
+	}
+	return api.PkgName(dep[:end]), api.PkgSpec(strings.TrimSpace(dep[end:]))
+}
+
+// listSpecfileWithContents returns the dependencies listed in the
+// given rockspec contents. The implicit "lua" dependency, which
+// constrains the interpreter version, is skipped.
+func listSpecfileWithContents(contents string) map[api.PkgName]api.PkgSpec {
+	pkgs := map[api.PkgName]api.PkgSpec{}
+	match := dependenciesRegexp.FindStringSubmatch(contents)
+	if match == nil {
+		return pkgs
+	}
+	for _, dep := range dependencyStringRegexp.FindAllStringSubmatch(match[1], -1) {
+		name, spec := parseDependency(dep[1])
+		if name == "lua" {
+			continue
+		}
+		pkgs[name] = spec
+	}
+	return pkgs
+}
+
+// rewriteDependencies applies fn to the list of dependency strings
+// in the rockspec contents and returns the rewritten contents. If the
+// rockspec has no dependencies table, one is appended.
+func rewriteDependencies(contents string, fn func(deps []string) []string) string {
+	deps := []string{}
+	match := dependenciesRegexp.FindStringSubmatchIndex(contents)
+	if match != nil {
+		body := contents[match[2]:match[3]]
+		for _, dep := range dependencyStringRegexp.FindAllStringSubmatch(body, -1) {
+			deps = append(deps, dep[1])
+		}
+	}
+
+	deps = fn(deps)
+
+	table := "dependencies = {\n"
+	for _, dep := range deps {
+		table += fmt.Sprintf("   %q,\n", dep)
+	}
+	table += "}"
+
+	if match == nil {
+		if len(contents) > 0 && contents[len(contents)-1] != '\n' {
+			contents += "\n"
+		}
+		return contents + table + "\n"
+	}
+	return contents[:match[0]] + table + contents[match[1]:]
+}
+
+// addToSpecfileContents returns the rockspec contents with the given
+// packages appended to the dependencies table, sorted, so that the
+// rockspec comes out the same however the map is ordered.
+func addToSpecfileContents(contents string, pkgs map[api.PkgName]api.PkgSpec) string {
+	return rewriteDependencies(contents, func(deps []string) []string {
+		added := []string{}
+		for name, spec := range pkgs {
+			dep := string(name)
+			if spec != "" {
+				dep += " " + string(spec)
+			}
+			added = append(added, dep)
+		}
+		sort.Strings(added)
+		return append(deps, added...)
+	})
+}
+
+// removeFromSpecfileContents returns the rockspec contents with the
+// given packages dropped from the dependencies table.
+func removeFromSpecfileContents(contents string, pkgs map[api.PkgName]bool) string {
+	return rewriteDependencies(contents, func(deps []string) []string {
+		kept := []string{}
+		for _, dep := range deps {
+			name, _ := parseDependency(dep)
+			if !pkgs[name] {
+				kept = append(kept, dep)
+			}
+		}
+		return kept
+	})
+}
+
+// readSpecfile returns the contents of the project rockspec, creating
+// it from initialRockspec first if it does not exist.
+func readSpecfile(projectName string) (string, string) {
+	specfile := findSpecfile()
+	if !util.Exists(specfile) {
+		specfile = defaultSpecfile(projectName)
+		name := strings.TrimSuffix(specfile, "-dev-1.rockspec")
+		return specfile, fmt.Sprintf(initialRockspec, name)
+	}
+	contentsB, err := ioutil.ReadFile(specfile)
+	if err != nil {
+		util.Die("%s: %s", specfile, err)
+	}
+	return specfile, string(contentsB)
+}
+
+// lockDependencyRegexp matches one entry of the dependencies table
+// in luarocks.lock, which may be written either as
+// ["lua-cjson"] = "2.1.0-1" or as lpeg = "1.0.2-1".
+var lockDependencyRegexp = regexp.MustCompile(`(?m)^\s*(?:\["([^"]+)"\]|([A-Za-z0-9_.-]+))\s*=\s*"([^"]+)"`)
+
+// listLockfileWithContents returns the exact versions pinned in the
+// given luarocks.lock contents.
+func listLockfileWithContents(contents string) map[api.PkgName]api.PkgVersion {
+	pkgs := map[api.PkgName]api.PkgVersion{}
+	for _, match := range lockDependencyRegexp.FindAllStringSubmatch(contents, -1) {
+		name := match[1]
+		if name == "" {
+			name = match[2]
+		}
+		if name == "lua" {
+			continue
+		}
+		pkgs[api.PkgName(name)] = api.PkgVersion(match[3])
+	}
+	return pkgs
+}
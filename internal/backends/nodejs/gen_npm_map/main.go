@@ -0,0 +1,25 @@
+// This command generates go source holding a mapping of:
+// specifiers -> the package they import and how likely it is wanted
+// and
+// packages -> their types on DefinitelyTyped
+//
+// these are provided as the data of the tables specifierToNpmPackage and
+// npmPackageToTypes respectively, as gen_pypi_map does for Python. It is
+// what go generate runs; 'upm admin gen-map --ecosystem npm' does the same,
+// and can also fetch the JSON file that the maps are generated from.
+package main
+
+import (
+	"flag"
+
+	"github.com/replit/upm/internal/backends/nodejs/npmmap"
+)
+
+func main() {
+	from := flag.String("from", "", "a json file to generate the map from")
+	pkg := flag.String("pkg", "", "the pkg name for the output source")
+	out := flag.String("out", "", "the destination file for the generated code")
+	flag.Parse()
+
+	npmmap.Generate(*from, *pkg, *out)
+}
@@ -9,6 +9,7 @@ import (
 	"io/ioutil"
 	"log"
 	"path/filepath"
+	"regexp"
 	"strings"
 )
 
@@ -64,8 +65,9 @@ func getExt(path string) string {
 }
 
 type parseResult struct {
-	ast ast.AST
-	ok  bool
+	path string
+	ast  ast.AST
+	ok   bool
 }
 
 func parseFile(source logging.Source, results chan parseResult) {
@@ -85,13 +87,27 @@ func parseFile(source logging.Source, results chan parseResult) {
 
 	ast, ok := parser.Parse(logo, source, parseOptions)
 
-	results <- parseResult{ast, ok}
+	results <- parseResult{source.AbsolutePath, ast, ok}
 }
 
-func guessBareImports() map[api.PkgName]bool {
-	pkgs := map[api.PkgName]bool{}
+// npmNameRegexp matches the name of a package that npm would publish,
+// which a bare specifier that is really a path alias, such as @/lib or
+// ~/lib, or a subpath import, such as #lib, isn't.
+var npmNameRegexp = regexp.MustCompile(`^(@[A-Za-z0-9-][A-Za-z0-9-._~]*/)?[A-Za-z0-9-][A-Za-z0-9-._~]*$`)
+
+// guessBareImports returns the packages that the files of the project
+// import, each with the files that import it, relative to the project
+// directory, and the files that import the modules built into Node.js.
+func guessBareImports() (map[api.PkgName][]string, []string) {
+	pkgs := map[api.PkgName][]string{}
+	builtins := []string{}
 	results := make(chan parseResult)
 	numParsedFiles := 0
+	dir, err := filepath.Abs(".")
+	if err != nil {
+		log.Fatalln(err)
+	}
+
 	var visitDir func(dirName string)
 
 	visitDir = func(dirName string) {
@@ -121,6 +137,10 @@ func guessBareImports() map[api.PkgName]bool {
 				continue
 			}
 
+			if rel, err := filepath.Rel(dir, absPath); err == nil && !util.IsScanned(rel) {
+				continue
+			}
+
 			contents, err := ioutil.ReadFile(absPath)
 			if err != nil {
 				log.Fatalln(err)
@@ -138,11 +158,6 @@ func guessBareImports() map[api.PkgName]bool {
 
 	}
 
-	dir, err := filepath.Abs(".")
-	if err != nil {
-		log.Fatalln(err)
-	}
-
 	visitDir(dir)
 
 	for i := 0; i < numParsedFiles; i++ {
@@ -150,6 +165,11 @@ func guessBareImports() map[api.PkgName]bool {
 		if !result.ok {
 			continue
 		}
+		file, err := filepath.Rel(dir, result.path)
+		if err != nil {
+			file = result.path
+		}
+		file = filepath.ToSlash(file)
 
 		for _, importPath := range result.ast.ImportPaths {
 			mod := importPath.Path.Text
@@ -157,6 +177,7 @@ func guessBareImports() map[api.PkgName]bool {
 			// Since Node.js 16, you can prefix the import path with `node:` to denote that the
 			// module is a core module.
 			if strings.HasPrefix(mod, "node:") {
+				builtins = append(builtins, file)
 				continue
 			}
 
@@ -168,6 +189,7 @@ func guessBareImports() map[api.PkgName]bool {
 				}
 			}
 			if isInternalMod {
+				builtins = append(builtins, file)
 				continue
 			}
 
@@ -208,9 +230,13 @@ func guessBareImports() map[api.PkgName]bool {
 				mod = parts[0]
 			}
 
-			pkgs[api.PkgName(mod)] = true
+			if !npmNameRegexp.MatchString(mod) {
+				continue
+			}
+
+			pkgs[api.PkgName(mod)] = append(pkgs[api.PkgName(mod)], file)
 		}
 	}
 
-	return pkgs
+	return pkgs, builtins
 }
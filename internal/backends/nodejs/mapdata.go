@@ -0,0 +1,33 @@
+package nodejs
+
+import (
+	"github.com/replit/upm/internal/api"
+	"github.com/replit/upm/internal/backends/python/pypimap"
+)
+
+// The tables of the map of npm, from the data in npm_map.gen.go. Each
+// is only decoded as far as it is used, as for Python.
+var (
+	// specifierToNpmPackage holds every known package by the bare
+	// specifier that imports it, with how likely it is that an
+	// import of it wants the package rather than, say, a path
+	// alias, as pypimap.EncodeGuess writes them.
+	specifierToNpmPackage = pypimap.NewTable(specifierToNpmPackageData)
+
+	// npmPackageToTypes holds every known package that doesn't
+	// ship its own type declarations, and the package of
+	// DefinitelyTyped that a TypeScript project needs along with
+	// it.
+	npmPackageToTypes = pypimap.NewTable(npmPackageToTypesData)
+)
+
+// MapStatus implements 'upm index status' for nodejs-yarn and
+// nodejs-npm.
+func MapStatus() api.MapStatus {
+	return api.MapStatus{
+		Ecosystem:    "npm",
+		Generated:    npmMapGenerated,
+		Imports:      specifierToNpmPackage.Len(),
+		RemoteSearch: npmSearchURL,
+	}
+}
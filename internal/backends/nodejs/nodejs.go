@@ -1,16 +1,25 @@
 // Package nodejs provides backends for Node.js using Yarn and NPM.
 package nodejs
 
+//go:generate go run ./gen_npm_map -from npm_packages.json -pkg nodejs -out npm_map.gen.go
+
 import (
+	"context"
 	"encoding/json"
+	"fmt"
 	"io/ioutil"
-	"net/http"
 	"net/url"
-	"os"
+	"path/filepath"
 	"regexp"
+	"sort"
+	"strings"
 
 	"github.com/hashicorp/go-version"
 	"github.com/replit/upm/internal/api"
+	"github.com/replit/upm/internal/backends/nodejs/npmmap"
+	"github.com/replit/upm/internal/backends/python/pypimap"
+	"github.com/replit/upm/internal/config"
+	"github.com/replit/upm/internal/project"
 	"github.com/replit/upm/internal/util"
 )
 
@@ -44,9 +53,11 @@ type npmSearchResults struct {
 // See https://github.com/npm/registry/blob/5db1bb329f554454467531a3e1bae5e97da160df/docs/responses/package-metadata.md
 // for documentation on the format.
 type npmInfoResult struct {
-	Name     string                 `json:"name"`
-	Versions map[string]interface{} `json:"versions"`
-	Author   struct {
+	Name     string                    `json:"name"`
+	Versions map[string]npmVersionInfo `json:"versions"`
+	// Map from each version to the time it was published.
+	Time   map[string]string `json:"time"`
+	Author struct {
 		Name  string `json:"name"`
 		Email string `json:"email"`
 		URL   string `json:"url"`
@@ -61,12 +72,20 @@ type npmInfoResult struct {
 		Type string `json:"type"`
 		URL  string `json:"url"`
 	} `json:"repository"`
+	Keywords []string `json:"keywords"`
+}
+
+// npmVersionInfo represents the relevant data about one version of a
+// package in the NPM registry.
+type npmVersionInfo struct {
+	Dependencies map[string]string `json:"dependencies"`
 }
 
 // packageJSON represents the relevant data in a package.json file.
 type packageJSON struct {
 	Dependencies    map[string]string `json:"dependencies"`
 	DevDependencies map[string]string `json:"devDependencies"`
+	Scripts         map[string]string `json:"scripts"`
 }
 
 // packageLockJSON represents the relevant data in a package-lock.json
@@ -74,21 +93,84 @@ type packageJSON struct {
 type packageLockJSON struct {
 	Dependencies map[string]struct {
 		Version string `json:"version"`
+		// The packages that this one depends on, in lockfiles
+		// of version 1.
+		Requires map[string]string `json:"requires"`
 	} `json:"dependencies"`
+	// Each package by its path in node_modules, with "" for the
+	// project itself, in lockfiles of version 2 and later.
+	Packages map[string]struct {
+		Dependencies         map[string]string `json:"dependencies"`
+		OptionalDependencies map[string]string `json:"optionalDependencies"`
+	} `json:"packages"`
+}
+
+// nodejsListPackageLockDependencies implements ListLockfileDependencies
+// for nodejs-npm, with the given contents of its lockfile. A
+// package that is nested in node_modules more than once, at different
+// versions, depends on what any of its copies do.
+func nodejsListPackageLockDependencies(contents []byte) map[api.PkgName][]api.PkgName {
+	var cfg packageLockJSON
+	if err := json.Unmarshal(contents, &cfg); err != nil {
+		util.Die("%s: %s", npmLockfile(), err)
+	}
+	sets := map[api.PkgName]map[api.PkgName]bool{}
+	add := func(pkg string, on map[string]string) {
+		if sets[api.PkgName(pkg)] == nil {
+			sets[api.PkgName(pkg)] = map[api.PkgName]bool{}
+		}
+		for name := range on {
+			sets[api.PkgName(pkg)][api.PkgName(name)] = true
+		}
+	}
+	if cfg.Packages == nil {
+		for name, data := range cfg.Dependencies {
+			add(name, data.Requires)
+		}
+	}
+	for path, data := range cfg.Packages {
+		i := strings.LastIndex(path, "node_modules/")
+		if i < 0 {
+			// The project itself, or a package of a
+			// workspace.
+			continue
+		}
+		add(path[i+len("node_modules/"):], data.Dependencies)
+		add(path[i+len("node_modules/"):], data.OptionalDependencies)
+	}
+
+	deps := map[api.PkgName][]api.PkgName{}
+	for pkg, set := range sets {
+		names := []api.PkgName{}
+		for name := range set {
+			names = append(names, name)
+		}
+		sort.Slice(names, func(i, j int) bool { return names[i] < names[j] })
+		deps[pkg] = names
+	}
+	return deps
 }
 
 // nodejsPatterns is the FilenamePatterns value for NodejsBackend.
 var nodejsPatterns = []string{"*.js", "*.ts", "*.jsx", "*.tsx"}
 
+// npmRegistry implements Registry for nodejs-yarn and nodejs-npm.
+func npmRegistry() string {
+	return "https://registry.npmjs.org/"
+}
+
+// npmSearchURL is the search API of the npm registry.
+const npmSearchURL = "https://registry.npmjs.org/-/v1/search"
+
 // nodejsSearch implements Search for nodejs-yarn and nodejs-npm.
-func nodejsSearch(query string) []api.PkgInfo {
+func nodejsSearch(ctx context.Context, query string) []api.PkgInfo {
 	// Special case: if search query is only one character, the
 	// API doesn't return any results. The web interface to NPM
 	// deals with this by just jumping to the package with that
 	// exact name, or returning a 404 if there isn't one. Let's
 	// try to do something similar.
 	if len(query) == 1 {
-		info := nodejsInfo(api.PkgName(query))
+		info := nodejsInfo(ctx, api.PkgName(query))
 		if info.Name != "" {
 			return []api.PkgInfo{info}
 		} else {
@@ -96,10 +178,9 @@ func nodejsSearch(query string) []api.PkgInfo {
 		}
 	}
 
-	endpoint := "https://registry.npmjs.org/-/v1/search"
 	queryParams := "?text=" + url.QueryEscape(query)
 
-	resp, err := http.Get(endpoint + queryParams)
+	resp, err := util.HTTPGet(ctx, npmSearchURL+queryParams)
 	if err != nil {
 		util.Die("NPM registry: %s", err)
 	}
@@ -134,12 +215,12 @@ func nodejsSearch(query string) []api.PkgInfo {
 	return results
 }
 
-// nodejsInfo implements Info for nodejs-yarn and nodejs-npm.
-func nodejsInfo(name api.PkgName) api.PkgInfo {
-	endpoint := "https://registry.npmjs.org"
-	path := "/" + url.QueryEscape(string(name))
-
-	resp, err := http.Get(endpoint + path)
+// npmRegistryInfo looks up the given package in the NPM registry, or
+// returns false if there is no such package.
+func npmRegistryInfo(ctx context.Context, name api.PkgName) (npmInfoResult, bool) {
+	// A scoped name such as @types/node is requested as a single
+	// segment, @types%2Fnode, as npm does.
+	resp, err := util.HTTPGet(ctx, util.JoinURL("https://registry.npmjs.org", string(name)))
 	if err != nil {
 		util.Die("NPM registry: %s", err)
 	}
@@ -149,7 +230,7 @@ func nodejsInfo(name api.PkgName) api.PkgInfo {
 	case 200:
 		break
 	case 404:
-		return api.PkgInfo{}
+		return npmInfoResult{}, false
 	default:
 		util.Die("NPM registry: HTTP status %d", resp.StatusCode)
 	}
@@ -159,28 +240,50 @@ func nodejsInfo(name api.PkgName) api.PkgInfo {
 	if err := json.Unmarshal(body, &npmInfo); err != nil {
 		util.Die("NPM registry: %s", err)
 	}
+	return npmInfo, true
+}
 
-	lastVersionStr := ""
-	if len(npmInfo.Versions) > 0 {
-		var lastVersion *version.Version = nil
-		for versionStr := range npmInfo.Versions {
-			version, err := version.NewVersion(versionStr)
-			if err != nil {
-				continue
-			}
-
-			if version.Prerelease() != "" {
-				continue
-			}
+// latestVersion returns the latest version of the package that isn't a
+// prerelease, and the key of that version in the maps of the result,
+// which may be written differently, or empty strings if there is none.
+func (npmInfo npmInfoResult) latestVersion() (string, string) {
+	var lastVersion *version.Version
+	lastVersionKey := ""
+	for versionStr := range npmInfo.Versions {
+		version, err := version.NewVersion(versionStr)
+		if err != nil {
+			continue
+		}
 
-			if lastVersion == nil || version.GreaterThan(lastVersion) {
-				lastVersion = version
-			}
+		if version.Prerelease() != "" {
+			continue
 		}
-		if lastVersion != nil {
-			lastVersionStr = lastVersion.String()
+
+		if lastVersion == nil || version.GreaterThan(lastVersion) {
+			lastVersion = version
+			lastVersionKey = versionStr
 		}
 	}
+	if lastVersion == nil {
+		return "", ""
+	}
+	return lastVersion.String(), lastVersionKey
+}
+
+// nodejsInfo implements Info for nodejs-yarn and nodejs-npm.
+func nodejsInfo(ctx context.Context, name api.PkgName) api.PkgInfo {
+	npmInfo, ok := npmRegistryInfo(ctx, name)
+	if !ok {
+		return api.PkgInfo{}
+	}
+
+	lastVersionStr, lastVersionKey := npmInfo.latestVersion()
+
+	deps := []string{}
+	for dep := range npmInfo.Versions[lastVersionKey].Dependencies {
+		deps = append(deps, dep)
+	}
+	sort.Strings(deps)
 
 	return api.PkgInfo{
 		Name:          npmInfo.Name,
@@ -194,8 +297,49 @@ func nodejsInfo(name api.PkgName) api.PkgInfo {
 			Email: npmInfo.Author.Email,
 			URL:   npmInfo.Author.URL,
 		}.String(),
-		License: npmInfo.License,
+		License:         npmInfo.License,
+		Dependencies:    deps,
+		DependencyCount: len(deps),
+		ReleaseDate:     util.FormatTimestamp(npmInfo.Time[lastVersionKey]),
+		Keywords:        npmInfo.Keywords,
+	}
+}
+
+// nodejsGetInstalledPackageDir implements GetInstalledPackageDir for
+// nodejs-yarn and nodejs-npm.
+func nodejsGetInstalledPackageDir(name api.PkgName) string {
+	return filepath.Join("node_modules", string(name))
+}
+
+// nodejsListInstalled implements ListInstalled for nodejs-npm. Only
+// the packages at the top of node_modules are listed, as only they
+// are in the dependencies of package-lock.json. NPM records no hashes
+// of the files it installs, so they aren't checked.
+func nodejsListInstalled() map[api.PkgName]api.InstalledPkg {
+	pkgs := map[api.PkgName]api.InstalledPkg{}
+	for _, pattern := range []string{"node_modules/*/package.json", "node_modules/@*/*/package.json"} {
+		paths, err := filepath.Glob(pattern)
+		if err != nil {
+			panic(err)
+		}
+		for _, path := range paths {
+			contentsB, err := ioutil.ReadFile(path)
+			if err != nil {
+				util.Die("%s: %s", path, err)
+			}
+			var cfg struct {
+				Version string `json:"version"`
+			}
+			if err := json.Unmarshal(contentsB, &cfg); err != nil {
+				util.Die("%s: %s", path, err)
+			}
+			// The directory is named after the package, or
+			// after its alias, as in package-lock.json.
+			name := strings.TrimPrefix(filepath.ToSlash(filepath.Dir(path)), "node_modules/")
+			pkgs[api.PkgName(name)] = api.InstalledPkg{Version: api.PkgVersion(cfg.Version)}
+		}
 	}
+	return pkgs
 }
 
 // nodejsListSpecfile implements ListSpecfile for nodejs-yarn and
@@ -219,6 +363,83 @@ func nodejsListSpecfile() map[api.PkgName]api.PkgSpec {
 	return pkgs
 }
 
+// nodejsListSpecfileGroups implements ListSpecfileGroups for
+// nodejs-yarn and nodejs-npm. The only group is dev, for the
+// devDependencies of package.json.
+func nodejsListSpecfileGroups() map[api.PkgName]string {
+	contentsB, err := ioutil.ReadFile("package.json")
+	if err != nil {
+		util.Die("package.json: %s", err)
+	}
+	var cfg packageJSON
+	if err := json.Unmarshal(contentsB, &cfg); err != nil {
+		util.Die("package.json: %s", err)
+	}
+	groups := map[api.PkgName]string{}
+	for nameStr := range cfg.DevDependencies {
+		if _, ok := cfg.Dependencies[nameStr]; !ok {
+			groups[api.PkgName(nameStr)] = "dev"
+		}
+	}
+	return groups
+}
+
+// nodejsExecEnv implements GetExecEnv for nodejs-yarn and nodejs-npm,
+// putting the programs of the installed packages on $PATH, as the
+// package managers do for scripts.
+func nodejsExecEnv() api.ExecEnv {
+	bin, err := filepath.Abs(filepath.Join("node_modules", ".bin"))
+	if err != nil {
+		util.Die("%s", err)
+	}
+	return api.ExecEnv{Path: []string{bin}}
+}
+
+// nodejsListScripts implements ListScripts for nodejs-yarn and
+// nodejs-npm, returning the scripts in package.json.
+func nodejsListScripts() map[string]string {
+	if !util.Exists("package.json") {
+		return map[string]string{}
+	}
+	contentsB, err := ioutil.ReadFile("package.json")
+	if err != nil {
+		util.Die("package.json: %s", err)
+	}
+	var cfg packageJSON
+	if err := json.Unmarshal(contentsB, &cfg); err != nil {
+		util.Die("package.json: %s", err)
+	}
+	if cfg.Scripts == nil {
+		return map[string]string{}
+	}
+	return cfg.Scripts
+}
+
+// nodejsAdd implements Add and AddToGroup for nodejs-yarn and
+// nodejs-npm, adding the given packages with the given command of the
+// package manager, after creating package.json if there is none.
+func nodejsAdd(ctx context.Context, cmd []string, pkgs map[api.PkgName]api.PkgSpec) {
+	if !util.Exists("package.json") {
+		util.RunCmd(ctx, []string{cmd[0], "init", "-y"})
+	}
+	for name, spec := range pkgs {
+		arg := string(name)
+		if spec != "" {
+			arg += "@" + string(spec)
+		}
+		cmd = append(cmd, arg)
+	}
+	util.RunCmd(ctx, cmd)
+}
+
+// checkGroup terminates the process unless the given dependency group
+// is dev, the only one that package.json has.
+func checkGroup(group string) {
+	if group != "dev" {
+		util.Die("package.json has no dependency group %q; the only group is \"dev\"", group)
+	}
+}
+
 // nodejsGuessRegexps is the value of GuessRegexps for nodejs-yarn and
 // nodejs-npm.
 var nodejsGuessRegexps = util.Regexps([]string{
@@ -234,11 +455,113 @@ var nodejsGuessRegexps = util.Regexps([]string{
 })
 
 // nodejsGuess implements Guess for nodejs-yarn and nodejs-npm.
-func nodejsGuess() (map[api.PkgName]bool, bool) {
-	tempdir := util.TempDir()
-	defer os.RemoveAll(tempdir)
-	pkgs := guessBareImports()
+func nodejsGuess(ctx context.Context) (map[api.PkgName]bool, bool) {
+	details, success := nodejsGuessDetails(ctx)
+	pkgs := map[api.PkgName]bool{}
+	for name := range details {
+		pkgs[name] = true
+	}
+	return pkgs, success
+}
+
+// isTypeScript returns true if the project is written in TypeScript,
+// so that it needs the type declarations of the packages it imports.
+func isTypeScript() bool {
+	return util.Exists("tsconfig.json")
+}
+
+// nodejsGuessCacheKey implements GuessCacheKey for nodejs-yarn and
+// nodejs-npm, since the packages of type declarations are only guessed
+// for TypeScript, and project.OverridesFile can change the packages
+// that imports need.
+func nodejsGuessCacheKey() string {
+	key := fmt.Sprint(project.Overrides("nodejs"))
+	if isTypeScript() {
+		return "typescript" + key
+	}
+	return key
+}
+
+// nodeTypesConfidence is how likely it is that a TypeScript project
+// that imports the modules built into Node.js wants their type
+// declarations, which it may have got some other way.
+const nodeTypesConfidence = 0.9
+
+// nodejsGuessDetails implements GuessDetails for nodejs-yarn and
+// nodejs-npm, like nodejsGuess. The confidence of a package is the one
+// that the map of npm gives it, which is lower for one that is hardly
+// downloaded, or 0 for one that isn't in the map, unless
+// project.OverridesFile names the package for the import, which is
+// certain. A TypeScript project
+// also gets, as development dependencies, the packages of
+// DefinitelyTyped for those that don't ship their own type
+// declarations, and for the modules built into Node.js.
+func nodejsGuessDetails(ctx context.Context) (map[api.PkgName]api.GuessedPkg, bool) {
+	imports, builtins := guessBareImports()
+	typescript := isTypeScript()
+	overrides := project.Overrides("nodejs")
+
+	pkgs := map[api.PkgName]api.GuessedPkg{}
+	addPkg := func(name api.PkgName, files []string, confidence float64, dev bool) {
+		pkg := pkgs[name]
+		pkg.Files = append(pkg.Files, files...)
+		if confidence > pkg.Confidence {
+			pkg.Confidence = confidence
+		}
+		pkg.Dev = pkg.Dev || dev
+		pkgs[name] = pkg
+	}
+	explain := func(pkg string, mod string, why string) {
+		if config.Explain {
+			util.Log(fmt.Sprintf("guessing %s for the import of %s: %s", pkg, mod, why))
+		}
+	}
+
+	names := []string{}
+	for name := range imports {
+		names = append(names, string(name))
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		files := imports[api.PkgName(name)]
+		var pkg string
+		var confidence float64
+		if override, ok := overrides[name]; ok {
+			pkg, confidence = override, 1
+			addPkg(api.PkgName(pkg), files, confidence, false)
+			explain(pkg, name, project.OverridesFile+" says so")
+		} else if value, ok := specifierToNpmPackage.Get(name); ok {
+			pkg, confidence = pypimap.DecodeGuess(value)
+			addPkg(api.PkgName(pkg), files, confidence, false)
+			explain(pkg, name, fmt.Sprintf("it is named by the import (confidence %.2f)", confidence))
+		} else {
+			addPkg(api.PkgName(name), files, 0, false)
+			explain(name, name, "it is named by the import, but isn't in the map of npm")
+			continue
+		}
 
+		if types, ok := npmPackageToTypes.Get(pkg); ok && typescript {
+			addPkg(api.PkgName(types), files, confidence, true)
+			explain(types, name, pkg+" has no type declarations of its own")
+		}
+	}
+	if typescript && len(builtins) > 0 {
+		types := npmmap.TypesPackage("node")
+		addPkg(api.PkgName(types), builtins, nodeTypesConfidence, true)
+		explain(types, "the modules built into Node.js", "they have no type declarations of their own")
+	}
+
+	for name, pkg := range pkgs {
+		sort.Strings(pkg.Files)
+		files := []string{}
+		for i, file := range pkg.Files {
+			if i == 0 || file != pkg.Files[i-1] {
+				files = append(files, file)
+			}
+		}
+		pkg.Files = files
+		pkgs[name] = pkg
+	}
 	return pkgs, true
 }
 
@@ -247,43 +570,66 @@ var NodejsYarnBackend = api.LanguageBackend{
 	Name:             "nodejs-yarn",
 	Specfile:         "package.json",
 	Lockfile:         "yarn.lock",
+	LockfileComment:  "#",
+	OSVEcosystem:     "npm",
+	Registry:         npmRegistry,
 	FilenamePatterns: nodejsPatterns,
+	Executables:      []string{"yarn"},
 	Quirks: api.QuirksAddRemoveAlsoLocks |
 		api.QuirksAddRemoveAlsoInstalls |
 		api.QuirksLockAlsoInstalls,
 	GetPackageDir: func() string {
 		return "node_modules"
 	},
-	Search: nodejsSearch,
-	Info:   nodejsInfo,
-	Add: func(pkgs map[api.PkgName]api.PkgSpec, projectName string) {
+	GetExecEnv:             nodejsExecEnv,
+	GetInstalledPackageDir: nodejsGetInstalledPackageDir,
+	Search:                 nodejsSearch,
+	Info:                   nodejsInfo,
+	WantedVersion:          nodejsWantedVersion,
+	Add: func(ctx context.Context, pkgs map[api.PkgName]api.PkgSpec, projectName string) {
+		nodejsAdd(ctx, []string{"yarn", "add"}, pkgs)
+	},
+	AddToGroup: func(ctx context.Context, pkgs map[api.PkgName]api.PkgSpec, projectName string, group string) {
+		checkGroup(group)
+		nodejsAdd(ctx, []string{"yarn", "add", "--dev"}, pkgs)
+	},
+	InitSpecfile: func(ctx context.Context, projectName string) {
+		util.RunCmd(ctx, []string{"yarn", "init", "-y"})
+	},
+	AddEditable: func(ctx context.Context, path string, projectName string) {
 		if !util.Exists("package.json") {
-			util.RunCmd([]string{"yarn", "init", "-y"})
-		}
-		cmd := []string{"yarn", "add"}
-		for name, spec := range pkgs {
-			arg := string(name)
-			if spec != "" {
-				arg += "@" + string(spec)
-			}
-			cmd = append(cmd, arg)
+			util.RunCmd(ctx, []string{"yarn", "init", "-y"})
 		}
-		util.RunCmd(cmd)
+		// The link: protocol symlinks the directory into
+		// node_modules, like 'yarn link' but recorded in
+		// package.json.
+		util.RunCmd(ctx, []string{"yarn", "add", "link:" + path})
 	},
-	Remove: func(pkgs map[api.PkgName]bool) {
+	Remove: func(ctx context.Context, pkgs map[api.PkgName]bool) {
 		cmd := []string{"yarn", "remove"}
 		for name, _ := range pkgs {
 			cmd = append(cmd, string(name))
 		}
-		util.RunCmd(cmd)
+		util.RunCmd(ctx, cmd)
 	},
-	Lock: func() {
-		util.RunCmd([]string{"yarn", "install"})
+	Lock: func(ctx context.Context) {
+		util.RunCmd(ctx, []string{"yarn", "install"})
 	},
-	Install: func() {
-		util.RunCmd([]string{"yarn", "install"})
+	Upgrade: func(ctx context.Context, pkgs map[api.PkgName]bool) {
+		cmd := []string{"yarn", "upgrade"}
+		for name := range pkgs {
+			cmd = append(cmd, string(name))
+		}
+		util.RunCmd(ctx, cmd)
 	},
-	ListSpecfile: nodejsListSpecfile,
+	Update: func(ctx context.Context, pkgs map[api.PkgName]bool, latest bool) {
+		nodejsUpdate(ctx, []string{"yarn", "add"}, []string{"yarn", "add", "--dev"}, pkgs, latest)
+	},
+	Install: func(ctx context.Context) {
+		util.RunCmd(ctx, []string{"yarn", "install"})
+	},
+	ListSpecfile:       nodejsListSpecfile,
+	ListSpecfileGroups: nodejsListSpecfileGroups,
 	ListLockfile: func() map[api.PkgName]api.PkgVersion {
 		contentsB, err := ioutil.ReadFile("yarn.lock")
 		if err != nil {
@@ -299,9 +645,32 @@ var NodejsYarnBackend = api.LanguageBackend{
 		}
 		return pkgs
 	},
+	ListScripts: nodejsListScripts,
+	RunScript: func(ctx context.Context, name string, args []string) {
+		util.RunInteractiveCmd(ctx, append([]string{"yarn", "run", name}, args...))
+	},
 	// https://developer.mozilla.org/en-US/docs/Web/JavaScript/Reference/Statements/import
-	GuessRegexps: nodejsGuessRegexps,
-	Guess:        nodejsGuess,
+	GuessRegexps:  nodejsGuessRegexps,
+	GuessCacheKey: nodejsGuessCacheKey,
+	Guess:         nodejsGuess,
+	GuessDetails:  nodejsGuessDetails,
+}
+
+// npmLockfiles are the flavors of the lockfile of NPM, in the order in
+// which it prefers them: npm-shrinkwrap.json, which is published along
+// with a package, over package-lock.json, which isn't.
+var npmLockfiles = []string{"npm-shrinkwrap.json", "package-lock.json"}
+
+// npmLockfile returns the flavor of the lockfile that NPM reads, which
+// is the Lockfile of nodejs-npm: the first of npmLockfiles that exists,
+// or else package-lock.json, which NPM creates.
+func npmLockfile() string {
+	for _, lockfile := range npmLockfiles {
+		if util.Exists(lockfile) {
+			return lockfile
+		}
+	}
+	return "package-lock.json"
 }
 
 // NodejsNPMBackend is a UPM backend for Node.js that uses NPM.
@@ -309,51 +678,74 @@ var NodejsNPMBackend = api.LanguageBackend{
 	Name:             "nodejs-npm",
 	Specfile:         "package.json",
 	Lockfile:         "package-lock.json",
+	OSVEcosystem:     "npm",
+	Registry:         npmRegistry,
+	LockfileFlavors:  npmLockfiles,
 	FilenamePatterns: nodejsPatterns,
+	Executables:      []string{"npm"},
 	Quirks: api.QuirksAddRemoveAlsoLocks |
 		api.QuirksAddRemoveAlsoInstalls |
 		api.QuirksLockAlsoInstalls,
 	GetPackageDir: func() string {
 		return "node_modules"
 	},
-	Search: nodejsSearch,
-	Info:   nodejsInfo,
-	Add: func(pkgs map[api.PkgName]api.PkgSpec, projectName string) {
+	GetExecEnv:             nodejsExecEnv,
+	GetInstalledPackageDir: nodejsGetInstalledPackageDir,
+	Search:                 nodejsSearch,
+	Info:                   nodejsInfo,
+	WantedVersion:          nodejsWantedVersion,
+	Add: func(ctx context.Context, pkgs map[api.PkgName]api.PkgSpec, projectName string) {
+		nodejsAdd(ctx, []string{"npm", "install"}, pkgs)
+	},
+	AddToGroup: func(ctx context.Context, pkgs map[api.PkgName]api.PkgSpec, projectName string, group string) {
+		checkGroup(group)
+		nodejsAdd(ctx, []string{"npm", "install", "--save-dev"}, pkgs)
+	},
+	InitSpecfile: func(ctx context.Context, projectName string) {
+		util.RunCmd(ctx, []string{"npm", "init", "-y"})
+	},
+	AddEditable: func(ctx context.Context, path string, projectName string) {
 		if !util.Exists("package.json") {
-			util.RunCmd([]string{"npm", "init", "-y"})
-		}
-		cmd := []string{"npm", "install"}
-		for name, spec := range pkgs {
-			arg := string(name)
-			if spec != "" {
-				arg += "@" + string(spec)
-			}
-			cmd = append(cmd, arg)
+			util.RunCmd(ctx, []string{"npm", "init", "-y"})
 		}
-		util.RunCmd(cmd)
+		// NPM symlinks local directories into node_modules,
+		// like 'npm link' but recorded in package.json.
+		util.RunCmd(ctx, []string{"npm", "install", "file:" + path})
 	},
-	Remove: func(pkgs map[api.PkgName]bool) {
+	Remove: func(ctx context.Context, pkgs map[api.PkgName]bool) {
 		cmd := []string{"npm", "uninstall"}
 		for name, _ := range pkgs {
 			cmd = append(cmd, string(name))
 		}
-		util.RunCmd(cmd)
+		util.RunCmd(ctx, cmd)
 	},
-	Lock: func() {
-		util.RunCmd([]string{"npm", "install"})
+	Lock: func(ctx context.Context) {
+		util.RunCmd(ctx, []string{"npm", "install"})
 	},
-	Install: func() {
-		util.RunCmd([]string{"npm", "ci"})
+	Upgrade: func(ctx context.Context, pkgs map[api.PkgName]bool) {
+		cmd := []string{"npm", "update"}
+		for name := range pkgs {
+			cmd = append(cmd, string(name))
+		}
+		util.RunCmd(ctx, cmd)
+	},
+	Update: func(ctx context.Context, pkgs map[api.PkgName]bool, latest bool) {
+		nodejsUpdate(ctx, []string{"npm", "install"}, []string{"npm", "install", "--save-dev"}, pkgs, latest)
+	},
+	Install: func(ctx context.Context) {
+		util.RunCmd(ctx, []string{"npm", "ci"})
 	},
-	ListSpecfile: nodejsListSpecfile,
+	ListSpecfile:       nodejsListSpecfile,
+	ListSpecfileGroups: nodejsListSpecfileGroups,
 	ListLockfile: func() map[api.PkgName]api.PkgVersion {
-		contentsB, err := ioutil.ReadFile("package-lock.json")
+		lockfile := npmLockfile()
+		contentsB, err := ioutil.ReadFile(lockfile)
 		if err != nil {
-			util.Die("package-lock.json: %s", err)
+			util.Die("%s: %s", lockfile, err)
 		}
 		var cfg packageLockJSON
 		if err := json.Unmarshal(contentsB, &cfg); err != nil {
-			util.Die("package-lock.json: %s", err)
+			util.Die("%s: %s", lockfile, err)
 		}
 		pkgs := map[api.PkgName]api.PkgVersion{}
 		for nameStr, data := range cfg.Dependencies {
@@ -361,7 +753,24 @@ var NodejsNPMBackend = api.LanguageBackend{
 		}
 		return pkgs
 	},
+	ListLockfileDependencies: func() map[api.PkgName][]api.PkgName {
+		lockfile := npmLockfile()
+		contentsB, err := ioutil.ReadFile(lockfile)
+		if err != nil {
+			util.Die("%s: %s", lockfile, err)
+		}
+		return nodejsListPackageLockDependencies(contentsB)
+	},
+	ListInstalled: nodejsListInstalled,
+	ListScripts:   nodejsListScripts,
+	// Everything after -- is passed to the script, even what npm
+	// would otherwise take as its own flags.
+	RunScript: func(ctx context.Context, name string, args []string) {
+		util.RunInteractiveCmd(ctx, append([]string{"npm", "run", name, "--"}, args...))
+	},
 	// https://developer.mozilla.org/en-US/docs/Web/JavaScript/Reference/Statements/import
-	GuessRegexps: nodejsGuessRegexps,
-	Guess:        nodejsGuess,
+	GuessRegexps:  nodejsGuessRegexps,
+	GuessCacheKey: nodejsGuessCacheKey,
+	Guess:         nodejsGuess,
+	GuessDetails:  nodejsGuessDetails,
 }
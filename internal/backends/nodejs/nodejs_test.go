@@ -1,10 +1,14 @@
 package nodejs
 
 import (
+	"context"
 	"io/ioutil"
 	"os"
+	"path/filepath"
 	"testing"
 
+	"github.com/stretchr/testify/require"
+
 	"github.com/replit/upm/internal/api"
 )
 
@@ -73,6 +77,19 @@ func TestNodejsYarnBackend_Guess(t *testing.T) {
 				"@material-ui/core": true,
 			},
 		},
+		{
+			scenario: "Ignore path aliases and subpath imports",
+			backend:  NodejsNPMBackend,
+			fileContent: `
+			import Button from '@/components/Button';
+			import { db } from '~/lib/db';
+			import config from '#config';
+			import React from 'react';
+		`,
+			expected: map[api.PkgName]bool{
+				"react": true,
+			},
+		},
 	}
 
 	for _, tc := range tcs {
@@ -94,7 +111,7 @@ func TestNodejsYarnBackend_Guess(t *testing.T) {
 				t.Error(err)
 			}
 
-			result, ok := tc.backend.Guess()
+			result, ok := tc.backend.Guess(context.Background())
 			if !ok {
 				t.Errorf("Guess return a non true value")
 			}
@@ -111,3 +128,128 @@ func TestNodejsYarnBackend_Guess(t *testing.T) {
 		})
 	}
 }
+
+func TestNodejsGuessDetails(t *testing.T) {
+	dir, err := ioutil.TempDir(".", "temp")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "app.ts"), []byte(`
+		import fs from 'fs';
+		import React from 'react';
+		import axios from 'axios';
+		import { thing } from 'not-a-known-package';
+
+		export const app = [fs, React, axios, thing];
+	`), 0666))
+	file := filepath.ToSlash(filepath.Join(dir, "app.ts"))
+
+	// Without a tsconfig.json, no type declarations are needed.
+	pkgs, ok := nodejsGuessDetails(context.Background())
+	require.True(t, ok)
+	require.Equal(t, map[api.PkgName]api.GuessedPkg{
+		"react":               {Files: []string{file}, Confidence: 0.95},
+		"axios":               {Files: []string{file}, Confidence: 0.95},
+		"not-a-known-package": {Files: []string{file}},
+	}, pkgs)
+
+	require.NoError(t, ioutil.WriteFile("tsconfig.json", []byte("{}"), 0666))
+	defer os.Remove("tsconfig.json")
+	pkgs, ok = nodejsGuessDetails(context.Background())
+	require.True(t, ok)
+	// axios ships its own.
+	require.Equal(t, map[api.PkgName]api.GuessedPkg{
+		"react":               {Files: []string{file}, Confidence: 0.95},
+		"@types/react":        {Files: []string{file}, Confidence: 0.95, Dev: true},
+		"axios":               {Files: []string{file}, Confidence: 0.95},
+		"not-a-known-package": {Files: []string{file}},
+		"@types/node":         {Files: []string{file}, Confidence: 0.9, Dev: true},
+	}, pkgs)
+}
+
+func TestNodejsListPackageLockDependencies(t *testing.T) {
+	// Version 1 lists what each package requires.
+	require.Equal(t, map[api.PkgName][]api.PkgName{
+		"express": {"body-parser", "debug"},
+		"debug":   {},
+	}, nodejsListPackageLockDependencies([]byte(`{
+  "lockfileVersion": 1,
+  "dependencies": {
+    "express": {"version": "4.18.2", "requires": {"debug": "2.6.9", "body-parser": "1.20.1"}},
+    "debug": {"version": "2.6.9"}
+  }
+}`)))
+
+	// Later versions list each copy of a package in node_modules.
+	require.Equal(t, map[api.PkgName][]api.PkgName{
+		"express":     {"body-parser", "debug", "fsevents"},
+		"debug":       {"ms"},
+		"@types/node": {},
+	}, nodejsListPackageLockDependencies([]byte(`{
+  "lockfileVersion": 3,
+  "packages": {
+    "": {"dependencies": {"express": "^4.18.2"}},
+    "node_modules/express": {
+      "dependencies": {"debug": "2.6.9", "body-parser": "1.20.1"},
+      "optionalDependencies": {"fsevents": "*"}
+    },
+    "node_modules/debug": {"dependencies": {"ms": "2.0.0"}},
+    "node_modules/express/node_modules/debug": {"dependencies": {"ms": "2.1.0"}},
+    "node_modules/@types/node": {}
+  }
+}`)))
+}
+
+func TestNpmRange(t *testing.T) {
+	versions := []string{
+		"0.0.3", "0.0.4", "0.2.1", "0.3.0",
+		"1.2.3", "1.2.9", "1.3.0", "1.9.1", "2.0.0", "2.1.0-beta.1", "3.4.0",
+	}
+	for spec, wanted := range map[string]string{
+		"^1.2.3":         "1.9.1",
+		"~1.2.3":         "1.2.9",
+		"~1":             "1.9.1",
+		"1.2.x":          "1.2.9",
+		"1.2":            "1.2.9",
+		"1.2.3":          "1.2.3",
+		"=1.3.0":         "1.3.0",
+		"^0.2.1":         "0.2.1",
+		"^0.0.3":         "0.0.3",
+		"^0.0":           "0.0.4",
+		">=1.2.3 <2":     "1.9.1",
+		">= 1.2.3 < 1.3": "1.2.9",
+		"<=1.2":          "1.2.9",
+		">1.9":           "3.4.0",
+		"1.2 - 1.3":      "1.3.0",
+		"^1.2.3 || ^3.0": "3.4.0",
+		"*":              "3.4.0",
+		"":               "3.4.0",
+		"^2.1.0":         "",
+		"^4.0.0":         "",
+	} {
+		alternatives, ok := npmRange(spec)
+		require.True(t, ok, spec)
+		require.Equal(t, wanted, npmMaxSatisfying(versions, alternatives), spec)
+	}
+
+	for _, spec := range []string{"latest", "github:user/repo", "file:../lib", "1.2-beta"} {
+		_, ok := npmRange(spec)
+		require.False(t, ok, spec)
+	}
+}
+
+func TestRaiseNpmSpec(t *testing.T) {
+	for spec, raised := range map[api.PkgSpec]api.PkgSpec{
+		"^1.2.0": "^2.0.1",
+		"~1.2":   "~2.0.1",
+		"1.2.3":  "2.0.1",
+	} {
+		got, ok := raiseNpmSpec(spec, "2.0.1")
+		require.True(t, ok, spec)
+		require.Equal(t, raised, got)
+	}
+
+	for _, spec := range []api.PkgSpec{">=1.2.0 <2", "1.x", "*", "latest", "github:user/repo", "^1 || ^2"} {
+		_, ok := raiseNpmSpec(spec, "2.0.1")
+		require.False(t, ok, spec)
+	}
+}
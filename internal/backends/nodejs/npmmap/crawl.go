@@ -0,0 +1,377 @@
+// Package npmmap builds the map from the bare specifiers that
+// JavaScript and TypeScript code imports, such as react or
+// @babel/core, to the npm packages that provide them, for guessing the
+// packages of a Node.js project, much as pypimap does for Python. An
+// import of a package is an import of its name, so the map is rather
+// about which specifiers name a package that is likely to be wanted,
+// judging by its downloads, and which packages need their type
+// declarations from DefinitelyTyped.
+package npmmap
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/replit/upm/internal/util"
+)
+
+// progressInterval is how often Crawl says how far it has got.
+const progressInterval = 2 * time.Second
+
+// listPageSize is how many packages are listed per request.
+const listPageSize = 10000
+
+// downloadsBatchSize is how many packages the downloads API counts per
+// request. It can't count scoped packages together, so those are
+// counted one at a time.
+const downloadsBatchSize = 128
+
+// Registry is where Crawl finds the packages of npm.
+type Registry struct {
+	// The registry that the latest release of each package is
+	// fetched from.
+	URL string
+	// The CouchDB replica of the registry, which lists every
+	// package.
+	ReplicateURL string
+	// The API that counts the downloads of packages.
+	DownloadsURL string
+}
+
+// DefaultRegistry is the public registry of npm.
+var DefaultRegistry = Registry{
+	URL:          "https://registry.npmjs.org",
+	ReplicateURL: "https://replicate.npmjs.com",
+	DownloadsURL: "https://api.npmjs.org/downloads",
+}
+
+// npmManifest is the part of the manifest of the latest release of a
+// package that says whether it ships its own type declarations.
+type npmManifest struct {
+	Name    string          `json:"name"`
+	Version string          `json:"version"`
+	Types   string          `json:"types"`
+	Typings string          `json:"typings"`
+	Exports json.RawMessage `json:"exports"`
+}
+
+// hasTypes returns true if the release ships type declarations, in
+// its types or typings field or in a types condition of its exports.
+func (m npmManifest) hasTypes() bool {
+	if m.Types != "" || m.Typings != "" {
+		return true
+	}
+	var hasTypes func(exports interface{}) bool
+	hasTypes = func(exports interface{}) bool {
+		switch exports := exports.(type) {
+		case map[string]interface{}:
+			for key, value := range exports {
+				if key == "types" || hasTypes(value) {
+					return true
+				}
+			}
+		case []interface{}:
+			for _, value := range exports {
+				if hasTypes(value) {
+					return true
+				}
+			}
+		}
+		return false
+	}
+	var exports interface{}
+	return len(m.Exports) > 0 && json.Unmarshal(m.Exports, &exports) == nil && hasTypes(exports)
+}
+
+// Crawl fetches the latest release and the downloads in the last month
+// of every package in the given registry, and writes an Entry for each
+// to the file out, one per line.
+//
+// The entries are written to out + ".partial" as they are fetched, and
+// it replaces out when the crawl is done, so an interrupted crawl
+// leaves out as it was. Running it again with resume picks up where it
+// left off, without fetching the packages that are already in the
+// partial file again. Unlike with PyPI, nothing is downloaded but the
+// manifest of each release, so there is no cache of earlier crawls to
+// save downloading it again.
+func Crawl(registry Registry, out string, resume bool) {
+	partial := out + ".partial"
+	done := map[string]bool{}
+	flags := os.O_CREATE | os.O_WRONLY | os.O_APPEND
+	if resume {
+		for name := range readEntries(partial, true) {
+			done[name] = true
+		}
+	} else {
+		flags |= os.O_TRUNC
+	}
+	file, err := os.OpenFile(partial, flags, 0666)
+	if err != nil {
+		util.Die("%s", err)
+	}
+	defer file.Close()
+
+	util.ProgressMsg("list the packages in " + registry.ReplicateURL)
+	names := registry.listPackages()
+	if len(done) > 0 {
+		util.Log(fmt.Sprintf("resuming: %d packages are already in %s", len(done), partial))
+	} else if resume {
+		util.Log(fmt.Sprintf("nothing to resume in %s, starting over", partial))
+	}
+
+	todo := []string{}
+	for _, name := range names {
+		if !done[name] {
+			todo = append(todo, name)
+		}
+	}
+
+	encoder := json.NewEncoder(file)
+	lastProgress := time.Now()
+	fetched := 0
+	for start := 0; start < len(todo); start += downloadsBatchSize {
+		end := start + downloadsBatchSize
+		if end > len(todo) {
+			end = len(todo)
+		}
+		batch := todo[start:end]
+		downloads := registry.fetchDownloads(batch)
+		for _, name := range batch {
+			entry, ok := registry.fetchEntry(name)
+			if !ok {
+				continue
+			}
+			entry.Downloads = downloads[name]
+			if err := encoder.Encode(entry); err != nil {
+				util.Die("%s: %s", partial, err)
+			}
+			fetched++
+		}
+
+		if time.Since(lastProgress) >= progressInterval || end == len(todo) {
+			util.Log(fmt.Sprintf("%d of %d packages (%d fetched this run)",
+				len(names)-len(todo)+end, len(names), fetched))
+			lastProgress = time.Now()
+		}
+	}
+
+	if err := file.Close(); err != nil {
+		util.Die("%s", err)
+	}
+	if err := os.Rename(partial, out); err != nil {
+		util.Die("%s", err)
+	}
+}
+
+// readEntries returns the entries in the given file, left by an
+// earlier crawl, by the names of their packages. The file need not
+// exist. A line that was cut off when a crawl was interrupted is
+// ignored, and with truncate, removed, so the file can be appended to.
+func readEntries(filename string, truncate bool) map[string]Entry {
+	entries := map[string]Entry{}
+	file, err := os.Open(filename)
+	if os.IsNotExist(err) {
+		return entries
+	} else if err != nil {
+		util.Die("%s", err)
+	}
+	defer file.Close()
+
+	reader := bufio.NewReader(file)
+	var valid int64
+	for {
+		line, err := reader.ReadBytes('\n')
+		var entry Entry
+		if err != nil || json.Unmarshal(line, &entry) != nil {
+			if err != nil && err != io.EOF {
+				util.Die("%s: %s", filename, err)
+			}
+			break
+		}
+		entries[entry.Pkg] = entry
+		valid += int64(len(line))
+	}
+	if truncate {
+		if err := os.Truncate(filename, valid); err != nil {
+			util.Die("%s", err)
+		}
+	}
+	return entries
+}
+
+// tryFetch fetches the given URL and returns the response body, or nil
+// if there is no such resource.
+func tryFetch(url string) ([]byte, error) {
+	res, err := util.HTTPClient.Get(url)
+	if err != nil {
+		return nil, fmt.Errorf("HTTP Request failed with error: %s", err)
+	}
+	defer res.Body.Close()
+
+	switch res.StatusCode {
+	case 200:
+		break
+	case 404:
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("Received status code: %d", res.StatusCode)
+	}
+
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, fmt.Errorf("Res body read failed with error: %s", err)
+	}
+	return body, nil
+}
+
+// listPackages returns the names of every package in the registry, a
+// page at a time, as CouchDB lists documents. If there is an error, it
+// terminates the process, since there is nothing to crawl without the
+// list.
+func (registry Registry) listPackages() []string {
+	names := []string{}
+	last := ""
+	for {
+		u := registry.ReplicateURL + "/_all_docs?limit=" + strconv.Itoa(listPageSize)
+		if last != "" {
+			// The page starts with the last package of the
+			// one before, which is skipped.
+			u += "&skip=1&startkey=" + url.QueryEscape(strconv.Quote(last))
+		}
+		body, err := tryFetch(u)
+		if err == nil && body == nil {
+			err = fmt.Errorf("not found")
+		}
+		if err != nil {
+			util.Die("%s: %s", registry.ReplicateURL, err)
+		}
+		var page struct {
+			Rows []struct {
+				ID string `json:"id"`
+			} `json:"rows"`
+		}
+		if err := json.Unmarshal(body, &page); err != nil {
+			util.Die("%s: %s", registry.ReplicateURL, err)
+		}
+		for _, row := range page.Rows {
+			if !strings.HasPrefix(row.ID, "_design/") {
+				names = append(names, row.ID)
+			}
+		}
+		if len(page.Rows) < listPageSize {
+			return names
+		}
+		last = page.Rows[len(page.Rows)-1].ID
+	}
+}
+
+// fetchEntry fetches the latest release of the given package, without
+// its downloads. It returns false if the package has no releases or
+// can't be fetched, which is only reported, so that one broken package
+// doesn't stop the crawl.
+func (registry Registry) fetchEntry(name string) (Entry, bool) {
+	body, err := tryFetch(util.JoinURL(registry.URL, name, "latest"))
+	if err != nil {
+		util.Log(fmt.Sprintf("warning: %s: %s", name, err))
+		return Entry{}, false
+	}
+	if body == nil {
+		// The registry lists packages that were unpublished.
+		return Entry{}, false
+	}
+	var manifest npmManifest
+	if err := json.Unmarshal(body, &manifest); err != nil {
+		util.Log(fmt.Sprintf("warning: %s: %s", name, err))
+		return Entry{}, false
+	}
+	if manifest.Name != "" {
+		name = manifest.Name
+	}
+	return Entry{
+		Pkg:     name,
+		Version: manifest.Version,
+		Types:   manifest.hasTypes() || typedPackage(name) != "",
+	}, true
+}
+
+// downloadsURL returns the URL of the downloads in the last month of
+// the given packages. Unlike the registry, the API wants the slash of
+// a scoped package as it is, and the names of several packages
+// separated by commas.
+func (registry Registry) downloadsURL(names []string) string {
+	escaped := make([]string, len(names))
+	for i, name := range names {
+		parts := strings.SplitN(name, "/", 2)
+		for j, part := range parts {
+			parts[j] = util.EscapePathSegment(part)
+		}
+		escaped[i] = strings.Join(parts, "/")
+	}
+	return util.JoinURL(registry.DownloadsURL, "point", "last-month") + "/" + strings.Join(escaped, ",")
+}
+
+// npmDownloads is the count of the downloads of a package by the
+// downloads API.
+type npmDownloads struct {
+	Downloads int `json:"downloads"`
+}
+
+// fetchDownloads returns how many times each of the given packages,
+// which are at most downloadsBatchSize, was downloaded in the last
+// month. A package whose downloads can't be fetched is left out, which
+// is only reported.
+func (registry Registry) fetchDownloads(names []string) map[string]int {
+	downloads := map[string]int{}
+	var unscoped []string
+	for _, name := range names {
+		if !strings.HasPrefix(name, "@") {
+			unscoped = append(unscoped, name)
+			continue
+		}
+		body, err := tryFetch(registry.downloadsURL([]string{name}))
+		var count npmDownloads
+		if err == nil && body != nil {
+			err = json.Unmarshal(body, &count)
+		}
+		if err != nil {
+			util.Log(fmt.Sprintf("warning: downloads of %s: %s", name, err))
+			continue
+		}
+		downloads[name] = count.Downloads
+	}
+	if len(unscoped) == 0 {
+		return downloads
+	}
+
+	// The counts of several packages are by name, and null for one
+	// that the API doesn't know; that of just one is as for a
+	// scoped package.
+	body, err := tryFetch(registry.downloadsURL(unscoped))
+	counts := map[string]*npmDownloads{}
+	if err == nil && body != nil {
+		if len(unscoped) == 1 {
+			var count npmDownloads
+			err = json.Unmarshal(body, &count)
+			counts[unscoped[0]] = &count
+		} else {
+			err = json.Unmarshal(body, &counts)
+		}
+	}
+	if err != nil {
+		util.Log(fmt.Sprintf("warning: downloads of %s and others: %s", unscoped[0], err))
+	}
+	for name, count := range counts {
+		if count != nil {
+			downloads[name] = count.Downloads
+		}
+	}
+	return downloads
+}
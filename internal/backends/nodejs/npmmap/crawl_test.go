@@ -0,0 +1,60 @@
+package npmmap
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCrawl(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.EscapedPath() {
+		case "/replicate/_all_docs":
+			fmt.Fprint(w, `{"rows": [{"id": "@acme/ui"}, {"id": "_design/app"}, {"id": "acme"}, {"id": "gone"}, {"id": "left-pad"}]}`)
+		case "/registry/@acme%2Fui/latest":
+			fmt.Fprint(w, `{"name": "@acme/ui", "version": "2.0.0", "exports": {".": {"types": "./index.d.ts", "default": "./index.js"}}}`)
+		case "/registry/acme/latest":
+			fmt.Fprint(w, `{"name": "acme", "version": "1.0.0"}`)
+		case "/registry/left-pad/latest":
+			fmt.Fprint(w, `{"name": "left-pad", "version": "1.3.0", "typings": "index.d.ts"}`)
+		case "/downloads/point/last-month/@acme/ui":
+			fmt.Fprint(w, `{"downloads": 12, "package": "@acme/ui"}`)
+		case "/downloads/point/last-month/acme,gone,left-pad":
+			fmt.Fprint(w, `{"acme": {"downloads": 3456, "package": "acme"}, "gone": null, "left-pad": {"downloads": 78, "package": "left-pad"}}`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	out := filepath.Join(t.TempDir(), "npm.json")
+	Crawl(Registry{
+		URL:          server.URL + "/registry",
+		ReplicateURL: server.URL + "/replicate",
+		DownloadsURL: server.URL + "/downloads",
+	}, out, false)
+
+	contents, err := ioutil.ReadFile(out)
+	require.NoError(t, err)
+	require.Equal(t, strings.Join([]string{
+		`{"p":"@acme/ui","d":12,"v":"2.0.0","t":true}`,
+		`{"p":"acme","d":3456,"v":"1.0.0"}`,
+		`{"p":"left-pad","d":78,"v":"1.3.0","t":true}`,
+		"",
+	}, "\n"), string(contents))
+	require.NoFileExists(t, out+".partial")
+}
+
+func TestTypesPackage(t *testing.T) {
+	require.Equal(t, "@types/react", TypesPackage("react"))
+	require.Equal(t, "@types/babel__core", TypesPackage("@babel/core"))
+	require.Equal(t, "@babel/core", typedPackage("@types/babel__core"))
+	require.Equal(t, "react", typedPackage("@types/react"))
+	require.Equal(t, "", typedPackage("react"))
+}
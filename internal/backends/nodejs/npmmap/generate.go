@@ -0,0 +1,165 @@
+package npmmap
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/replit/upm/internal/backends/python/pypimap"
+	"github.com/replit/upm/internal/util"
+)
+
+// Entry is what Crawl found out about a package, as one line of the
+// JSON file that Generate reads.
+type Entry struct {
+	// The name of the package, such as react or @babel/core.
+	Pkg string `json:"p"`
+	// How many times the package was downloaded in the last
+	// month.
+	Downloads int `json:"d"`
+	// The version of the latest release of the package, or empty
+	// if that isn't known.
+	Version string `json:"v,omitempty"`
+	// Whether the latest release ships its own type declarations,
+	// so that a TypeScript project needs nothing else to use it.
+	Types bool `json:"t,omitempty"`
+}
+
+// typesScope is the scope of the packages of DefinitelyTyped.
+const typesScope = "@types/"
+
+// TypesPackage returns the name of the package of DefinitelyTyped that
+// would hold the type declarations of the given package, such as
+// @types/react for react and @types/babel__core for @babel/core.
+func TypesPackage(pkg string) string {
+	if strings.HasPrefix(pkg, "@") {
+		pkg = strings.Replace(pkg[1:], "/", "__", 1)
+	}
+	return typesScope + pkg
+}
+
+// typedPackage returns the package whose type declarations the given
+// package of DefinitelyTyped holds, undoing TypesPackage, or "" if it
+// isn't one.
+func typedPackage(pkg string) string {
+	if !strings.HasPrefix(pkg, typesScope) || len(pkg) == len(typesScope) {
+		return ""
+	}
+	pkg = strings.TrimPrefix(pkg, typesScope)
+	if strings.Contains(pkg, "__") {
+		return "@" + strings.Replace(pkg, "__", "/", 1)
+	}
+	return pkg
+}
+
+// The confidences that Generate gives the package that it guesses for
+// a specifier, from 0 to 1.
+const (
+	// A package with at least popularDownloads, or of a map
+	// without download counts.
+	popularConfidence = 0.95
+	// A package with fewer downloads, which is as likely to have
+	// been meant by a path alias or a typo as to be wanted.
+	obscureConfidence = 0.5
+)
+
+// popularDownloads is the threshold of popularConfidence, in the
+// downloads of a month. It also keeps a package of DefinitelyTyped out
+// of the map if nobody uses it.
+const popularDownloads = 100
+
+// tables returns the pairs of keys and values of the tables of the map
+// of the given entries, by package: the guess for the specifier of
+// each package, and the package of DefinitelyTyped for each that needs
+// it. Without counted, the downloads of the entries aren't known, and
+// are taken to be popular.
+func tables(entries map[string]Entry, counted bool) ([][2]string, [][2]string) {
+	popular := func(entry Entry) bool {
+		return !counted || entry.Downloads >= popularDownloads
+	}
+
+	guesses := [][2]string{}
+	types := [][2]string{}
+	for name, entry := range entries {
+		if typedPackage(name) != "" {
+			continue
+		}
+		confidence := obscureConfidence
+		if popular(entry) {
+			confidence = popularConfidence
+		}
+		guesses = append(guesses, [2]string{name, pypimap.EncodeGuess(name, confidence)})
+
+		if typesEntry, ok := entries[TypesPackage(name)]; ok && !entry.Types && popular(typesEntry) {
+			types = append(types, [2]string{name, typesEntry.Pkg})
+		}
+	}
+	return guesses, types
+}
+
+// Generate reads the entries in the JSON file from, as Crawl writes
+// them, and writes the Go source of the tables of the map, in the
+// given Go package, to the file out. They are encoded by
+// pypimap.EncodeTable, since a table of npm is as big as one of PyPI.
+//
+// A map whose entries have no downloads at all, such as one written by
+// hand, is taken to hold only popular packages.
+func Generate(from string, pkg string, out string) {
+	file, err := os.Open(from)
+	if err != nil {
+		util.Die("%s", err)
+	}
+	defer file.Close()
+
+	entries := map[string]Entry{}
+	// Whether any entry has downloads.
+	counted := false
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(nil, 1<<20)
+	for scanner.Scan() {
+		var entry Entry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			util.Die("%s: %s", from, err)
+		}
+		entries[entry.Pkg] = entry
+		counted = counted || entry.Downloads > 0
+	}
+	if err := scanner.Err(); err != nil {
+		util.Die("%s: %s", from, err)
+	}
+	guesses, types := tables(entries, counted)
+
+	outgo, err := os.Create(out)
+	if err != nil {
+		util.Die("%s", err)
+	}
+	fmt.Fprintf(outgo, "package %s\n", pkg)
+	fmt.Fprintf(outgo, `
+// npmMapGenerated is the date on which the map of npm was generated,
+// as YYYY-MM-DD.
+const npmMapGenerated = %q
+`, time.Now().UTC().Format("2006-01-02"))
+	fmt.Fprintf(outgo, `
+// The data of the tables of the map of npm, made by
+// pypimap.EncodeTable.
+const (
+	// Each known specifier, and the package that it imports and
+	// how likely it is to be wanted, as pypimap.EncodeGuess writes
+	// them.
+	specifierToNpmPackageData = %q
+
+	// Each known package that doesn't ship its own type
+	// declarations, and the package of DefinitelyTyped that has
+	// them.
+	npmPackageToTypesData = %q
+)
+`, pypimap.EncodeTable(guesses), pypimap.EncodeTable(types))
+
+	err = outgo.Close()
+	if err != nil {
+		util.Die("%s", err)
+	}
+}
@@ -0,0 +1,61 @@
+package npmmap
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func sortedPairs(pairs [][2]string) [][2]string {
+	sort.Slice(pairs, func(i, j int) bool { return pairs[i][0] < pairs[j][0] })
+	return pairs
+}
+
+func TestTables(t *testing.T) {
+	entries := map[string]Entry{
+		"react":          {Pkg: "react", Downloads: 50000},
+		"@types/react":   {Pkg: "@types/react", Downloads: 40000, Types: true},
+		"axios":          {Pkg: "axios", Downloads: 30000, Types: true},
+		"@types/axios":   {Pkg: "@types/axios", Downloads: 500, Types: true},
+		"obscure":        {Pkg: "obscure", Downloads: 3},
+		"@types/obscure": {Pkg: "@types/obscure", Downloads: 1, Types: true},
+	}
+	guesses, types := tables(entries, true)
+	require.Equal(t, [][2]string{
+		{"axios", "axios 0.95"},
+		{"obscure", "obscure 0.50"},
+		{"react", "react 0.95"},
+	}, sortedPairs(guesses))
+	// axios ships its own, and nobody uses @types/obscure.
+	require.Equal(t, [][2]string{{"react", "@types/react"}}, types)
+
+	// Without downloads, everything is popular.
+	guesses, types = tables(entries, false)
+	require.Contains(t, guesses, [2]string{"obscure", "obscure 0.95"})
+	require.Equal(t, [][2]string{
+		{"obscure", "@types/obscure"},
+		{"react", "@types/react"},
+	}, sortedPairs(types))
+}
+
+func TestGenerate(t *testing.T) {
+	dir := t.TempDir()
+	from := filepath.Join(dir, "npm.json")
+	require.NoError(t, ioutil.WriteFile(from, []byte(strings.Join([]string{
+		`{"p":"react","d":0}`,
+		`{"p":"@types/react","d":0,"t":true}`,
+		"",
+	}, "\n")), 0666))
+	out := filepath.Join(dir, "npm_map.gen.go")
+	Generate(from, "nodejs", out)
+
+	contents, err := ioutil.ReadFile(out)
+	require.NoError(t, err)
+	require.Contains(t, string(contents), "package nodejs\n")
+	require.Contains(t, string(contents), "specifierToNpmPackageData = ")
+	require.Contains(t, string(contents), "npmPackageToTypesData = ")
+}
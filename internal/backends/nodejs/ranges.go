@@ -0,0 +1,317 @@
+package nodejs
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/hashicorp/go-version"
+	"github.com/replit/upm/internal/api"
+	"github.com/replit/upm/internal/util"
+)
+
+// npmPartial is a version in an npm range, which may leave out its
+// minor and patch numbers or give them as wildcards, as in "1", "1.2",
+// or "1.x".
+type npmPartial struct {
+	// The numbers that are given, up to the first wildcard.
+	nums []int
+	// The version as it was written, without any leading "v" or
+	// build metadata, for when all three numbers are given.
+	text string
+}
+
+// parseNpmPartial parses a version in an npm range, or returns false
+// if it isn't one.
+func parseNpmPartial(s string) (npmPartial, bool) {
+	s = strings.TrimPrefix(strings.TrimPrefix(s, "="), "v")
+	if i := strings.Index(s, "+"); i >= 0 {
+		s = s[:i]
+	}
+	core := s
+	if i := strings.Index(s, "-"); i >= 0 {
+		core = s[:i]
+	}
+	p := npmPartial{text: s}
+	if core == "" {
+		return npmPartial{}, false
+	}
+	parts := strings.Split(core, ".")
+	if len(parts) > 3 {
+		return npmPartial{}, false
+	}
+	for _, part := range parts {
+		if part == "x" || part == "X" || part == "*" {
+			break
+		}
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 {
+			return npmPartial{}, false
+		}
+		p.nums = append(p.nums, n)
+	}
+	if len(p.nums) < 3 && core != s {
+		// A prerelease of a partial version, like "1.2-beta".
+		return npmPartial{}, false
+	}
+	return p, true
+}
+
+// floor returns the lowest version that the partial version covers.
+func (p npmPartial) floor() string {
+	if len(p.nums) == 3 {
+		return p.text
+	}
+	nums := append([]int{}, p.nums...)
+	for len(nums) < 3 {
+		nums = append(nums, 0)
+	}
+	return fmt.Sprintf("%d.%d.%d", nums[0], nums[1], nums[2])
+}
+
+// bump returns the lowest version past those that the first n numbers
+// of the partial version cover, such as 1.3.0 for the first two of
+// 1.2.5.
+func (p npmPartial) bump(n int) string {
+	nums := append([]int{}, p.nums[:n]...)
+	nums[n-1]++
+	for len(nums) < 3 {
+		nums = append(nums, 0)
+	}
+	return fmt.Sprintf("%d.%d.%d", nums[0], nums[1], nums[2])
+}
+
+// npmComparator returns the go-version constraints, all of which a
+// version must satisfy, for one comparator of an npm range, such as
+// "^1.2.0" or "<2". No constraints match every version.
+func npmComparator(op string, p npmPartial) []string {
+	n := len(p.nums)
+	switch op {
+	case "", "=":
+		switch n {
+		case 0:
+			return nil
+		case 3:
+			return []string{"= " + p.text}
+		}
+		return []string{">= " + p.floor(), "< " + p.bump(n)}
+	case ">=":
+		if n == 0 {
+			return nil
+		}
+		return []string{">= " + p.floor()}
+	case ">":
+		switch n {
+		case 0:
+			return []string{"< 0.0.0"}
+		case 3:
+			return []string{"> " + p.text}
+		}
+		return []string{">= " + p.bump(n)}
+	case "<":
+		if n == 0 {
+			return []string{"< 0.0.0"}
+		}
+		return []string{"< " + p.floor()}
+	case "<=":
+		switch n {
+		case 0:
+			return nil
+		case 3:
+			return []string{"<= " + p.text}
+		}
+		return []string{"< " + p.bump(n)}
+	case "~":
+		switch n {
+		case 0:
+			return nil
+		case 1:
+			return []string{">= " + p.floor(), "< " + p.bump(1)}
+		}
+		return []string{">= " + p.floor(), "< " + p.bump(2)}
+	case "^":
+		// The first number that isn't zero may not change.
+		switch {
+		case n == 0:
+			return nil
+		case p.nums[0] != 0 || n == 1:
+			return []string{">= " + p.floor(), "< " + p.bump(1)}
+		case p.nums[1] != 0 || n == 2:
+			return []string{">= " + p.floor(), "< " + p.bump(2)}
+		}
+		return []string{">= " + p.floor(), "< " + p.bump(3)}
+	}
+	return nil
+}
+
+// npmOperators are the operators that may start a comparator in an
+// npm range, longest first.
+var npmOperators = []string{">=", "<=", ">", "<", "=", "^", "~"}
+
+// npmRange parses a range from package.json, such as "^1.2.0", "1.x",
+// or ">=2.1.0 <3 || ~4.1", into the constraints of which a version must
+// satisfy any one. It returns false for a spec that isn't a range, such
+// as a Git URL, a path, or a dist-tag like "latest".
+func npmRange(spec string) ([]version.Constraints, bool) {
+	if strings.ContainsAny(spec, ":/") {
+		return nil, false
+	}
+	alternatives := []version.Constraints{}
+	for _, alternative := range strings.Split(spec, "||") {
+		fields := strings.Fields(alternative)
+		constraints := []string{}
+		if len(fields) == 3 && fields[1] == "-" {
+			// A hyphen range, like "1.2 - 2.3.4".
+			from, fromOK := parseNpmPartial(fields[0])
+			to, toOK := parseNpmPartial(fields[2])
+			if !fromOK || !toOK {
+				return nil, false
+			}
+			constraints = append(constraints, npmComparator(">=", from)...)
+			constraints = append(constraints, npmComparator("<=", to)...)
+			fields = nil
+		}
+		for i := 0; i < len(fields); i++ {
+			field := fields[i]
+			op := ""
+			for _, candidate := range npmOperators {
+				if strings.HasPrefix(field, candidate) {
+					op = candidate
+					break
+				}
+			}
+			field = field[len(op):]
+			if field == "" && op != "" && i+1 < len(fields) {
+				// An operator apart from its version,
+				// like ">= 1.2".
+				i++
+				field = fields[i]
+			}
+			p, ok := parseNpmPartial(field)
+			if !ok && field != "" {
+				return nil, false
+			}
+			constraints = append(constraints, npmComparator(op, p)...)
+		}
+		if len(constraints) == 0 {
+			constraints = []string{">= 0.0.0"}
+		}
+		parsed, err := version.NewConstraint(strings.Join(constraints, ", "))
+		if err != nil {
+			return nil, false
+		}
+		alternatives = append(alternatives, parsed)
+	}
+	return alternatives, true
+}
+
+// nodejsWantedVersion implements WantedVersion for nodejs-yarn and
+// nodejs-npm. Prereleases are never wanted, as they aren't the latest
+// version either.
+func nodejsWantedVersion(ctx context.Context, name api.PkgName, spec api.PkgSpec) api.PkgVersion {
+	alternatives, ok := npmRange(string(spec))
+	if !ok {
+		return ""
+	}
+	npmInfo, ok := npmRegistryInfo(ctx, name)
+	if !ok {
+		return ""
+	}
+	versions := []string{}
+	for versionStr := range npmInfo.Versions {
+		versions = append(versions, versionStr)
+	}
+	return api.PkgVersion(npmMaxSatisfying(versions, alternatives))
+}
+
+// npmMaxSatisfying returns the newest of the given versions that isn't
+// a prerelease and satisfies any of the given constraints, or an empty
+// string if there is none.
+func npmMaxSatisfying(versions []string, alternatives []version.Constraints) string {
+	var wanted *version.Version
+	for _, versionStr := range versions {
+		v, err := version.NewVersion(versionStr)
+		if err != nil || v.Prerelease() != "" {
+			continue
+		}
+		if wanted != nil && !v.GreaterThan(wanted) {
+			continue
+		}
+		for _, constraints := range alternatives {
+			if constraints.Check(v) {
+				wanted = v
+				break
+			}
+		}
+	}
+	if wanted == nil {
+		return ""
+	}
+	return wanted.String()
+}
+
+// raiseNpmSpec returns the given spec with its version replaced by the
+// given one, keeping its ^ or ~, or false if it isn't a single version
+// with one of those or none, like "^1.2.0", "~1.2", or "1.2.3", since a
+// more complex range can't be raised without changing what it means.
+func raiseNpmSpec(spec api.PkgSpec, to string) (api.PkgSpec, bool) {
+	op := ""
+	rest := string(spec)
+	if strings.HasPrefix(rest, "^") || strings.HasPrefix(rest, "~") {
+		op, rest = rest[:1], rest[1:]
+	}
+	p, ok := parseNpmPartial(rest)
+	if !ok || len(p.nums) == 0 || strings.ContainsAny(rest, " <>=|") ||
+		op == "" && len(p.nums) < 3 {
+		return "", false
+	}
+	return api.PkgSpec(op + to), true
+}
+
+// nodejsUpdate implements Update for nodejs-yarn and nodejs-npm,
+// adding the packages again with their raised specs, with the given
+// commands for dependencies and devDependencies.
+func nodejsUpdate(ctx context.Context, add []string, addDev []string, pkgs map[api.PkgName]bool, latest bool) {
+	groups := nodejsListSpecfileGroups()
+	raised := map[api.PkgName]api.PkgSpec{}
+	raisedDev := map[api.PkgName]api.PkgSpec{}
+	for name, spec := range nodejsListSpecfile() {
+		if len(pkgs) > 0 && !pkgs[name] {
+			continue
+		}
+		if _, ok := raiseNpmSpec(spec, "0.0.0"); !ok {
+			continue
+		}
+		var to string
+		if latest {
+			if npmInfo, ok := npmRegistryInfo(ctx, name); ok {
+				to, _ = npmInfo.latestVersion()
+			}
+		} else {
+			to = string(nodejsWantedVersion(ctx, name, spec))
+		}
+		if to == "" {
+			continue
+		}
+		newSpec, _ := raiseNpmSpec(spec, to)
+		if newSpec == spec {
+			continue
+		}
+		if groups[name] == "dev" {
+			raisedDev[name] = newSpec
+		} else {
+			raised[name] = newSpec
+		}
+	}
+	if len(raised) == 0 && len(raisedDev) == 0 {
+		util.Log("no specs can be raised")
+		return
+	}
+	if len(raised) > 0 {
+		nodejsAdd(ctx, add, raised)
+	}
+	if len(raisedDev) > 0 {
+		nodejsAdd(ctx, addDev, raisedDev)
+	}
+}
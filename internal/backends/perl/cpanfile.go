@@ -0,0 +1,135 @@
+package perl
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/replit/upm/internal/api"
+	"github.com/replit/upm/internal/util"
+)
+
+// requiresRegexp matches a requires (or recommends, etc.) statement
+// in a cpanfile, e.g. requires 'Plack', '1.0'; or
+// requires "DBI" => ">= 1.6";. The first capture group is the module
+// name and the optional second one is the version requirement.
+var requiresRegexp = regexp.MustCompile(
+	`(?m)^\s*(?:requires|recommends|suggests|test_requires|author_requires|configure_requires|build_requires)\s+['"]([^'"]+)['"]\s*(?:(?:,|=>)\s*['"]?([^'";]+?)['"]?)?\s*;`,
+)
+
+// listSpecfileWithContents returns the modules required by the given
+// cpanfile contents, including those required only in a particular
+// phase (e.g. inside an on 'test' => sub { ... } block).
+func listSpecfileWithContents(contents string) map[api.PkgName]api.PkgSpec {
+	pkgs := map[api.PkgName]api.PkgSpec{}
+	for _, match := range requiresRegexp.FindAllStringSubmatch(contents, -1) {
+		if match[1] == "perl" {
+			continue
+		}
+		pkgs[api.PkgName(match[1])] = api.PkgSpec(strings.TrimSpace(match[2]))
+	}
+	return pkgs
+}
+
+// addToSpecfileContents returns the cpanfile contents with a requires
+// line appended for each of the given packages, sorted by name. The
+// names and specs are quoted with '...' in the Perl source, so they
+// may not contain a quote or a backslash.
+func addToSpecfileContents(contents string, pkgs map[api.PkgName]api.PkgSpec) string {
+	names := []string{}
+	for name, spec := range pkgs {
+		if strings.ContainsAny(string(name), `'\`) {
+			util.Die("cannot add %s to cpanfile: package names may not contain ' or \\", name)
+		}
+		if strings.ContainsAny(string(spec), `'\`) {
+			util.Die("cannot add %s %s to cpanfile: versions may not contain ' or \\", name, spec)
+		}
+		names = append(names, string(name))
+	}
+	sort.Strings(names)
+
+	// Ensure newline before the stuff we add, for readability.
+	if len(contents) > 0 && contents[len(contents)-1] != '\n' {
+		contents += "\n"
+	}
+
+	for _, name := range names {
+		spec := pkgs[api.PkgName(name)]
+		if spec != "" {
+			contents += fmt.Sprintf("requires '%s', '%s';\n", name, spec)
+		} else {
+			contents += fmt.Sprintf("requires '%s';\n", name)
+		}
+	}
+	return contents
+}
+
+// removeFromSpecfileContents returns the cpanfile contents with every
+// requires line for the given packages deleted.
+func removeFromSpecfileContents(contents string, pkgs map[api.PkgName]bool) string {
+	for name := range pkgs {
+		contents = regexp.MustCompile(
+			fmt.Sprintf(
+				`(?m)^\s*(?:requires|recommends|suggests|test_requires|author_requires|configure_requires|build_requires)\s+['"]%s['"][^;]*;[ \t]*\n?`,
+				regexp.QuoteMeta(string(name)),
+			),
+		).ReplaceAllLiteralString(contents, "")
+	}
+	return contents
+}
+
+// listLockfileWithContents returns the distributions recorded in the
+// given cpanfile.snapshot contents. Packages are named after the main
+// module of each distribution (e.g. Plack for Plack-1.0047), so that
+// they line up with the names used in the cpanfile.
+func listLockfileWithContents(contents string) map[api.PkgName]api.PkgVersion {
+	pkgs := map[api.PkgName]api.PkgVersion{}
+
+	var dist, version string
+	provides := []string{}
+	inProvides := false
+	flush := func() {
+		if dist == "" {
+			return
+		}
+		name := strings.ReplaceAll(dist, "-", "::")
+		found := false
+		for _, mod := range provides {
+			if mod == name {
+				found = true
+				break
+			}
+		}
+		if !found && len(provides) > 0 {
+			name = provides[0]
+		}
+		pkgs[api.PkgName(name)] = api.PkgVersion(version)
+		dist, version, provides = "", "", []string{}
+	}
+
+	for _, line := range strings.Split(contents, "\n") {
+		indent := len(line) - len(strings.TrimLeft(line, " "))
+		trimmed := strings.TrimSpace(line)
+		switch {
+		case trimmed == "":
+			continue
+		case indent == 2:
+			// A new distribution, e.g. "  Plack-1.0047".
+			flush()
+			sep := strings.LastIndex(trimmed, "-")
+			if sep == -1 {
+				continue
+			}
+			dist, version = trimmed[:sep], trimmed[sep+1:]
+			inProvides = false
+		case indent == 4:
+			inProvides = trimmed == "provides:"
+		case indent == 6 && inProvides:
+			provides = append(provides, strings.Fields(trimmed)[0])
+		}
+	}
+	flush()
+
+	return pkgs
+}
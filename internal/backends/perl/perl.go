@@ -0,0 +1,297 @@
+// Package perl provides a backend for Perl using Carton.
+package perl
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/replit/upm/internal/api"
+	"github.com/replit/upm/internal/util"
+)
+
+// perlPatterns is the FilenamePatterns value for PerlCartonBackend.
+var perlPatterns = []string{"*.pl", "*.pm"}
+
+// metacpanURL is the base URL of the MetaCPAN API.
+const metacpanURL = "https://fastapi.metacpan.org/v1"
+
+// metacpanSuggestions represents the data we get from MetaCPAN when
+// calling /search/autocomplete/suggest.
+type metacpanSuggestions struct {
+	Suggestions []struct {
+		Name         string `json:"name"`
+		Author       string `json:"author"`
+		Release      string `json:"release"`
+		Distribution string `json:"distribution"`
+	} `json:"suggestions"`
+}
+
+// metacpanModule represents the data we get from MetaCPAN when
+// calling /module/[module name].
+type metacpanModule struct {
+	Distribution string `json:"distribution"`
+	Version      string `json:"version"`
+}
+
+// metacpanRelease represents the data we get from MetaCPAN when
+// calling /release/[distribution name].
+type metacpanRelease struct {
+	Abstract string   `json:"abstract"`
+	Author   string   `json:"author"`
+	License  []string `json:"license"`
+	Version  string   `json:"version"`
+	Date     string   `json:"date"`
+	Stat     struct {
+		Size int64 `json:"size"`
+	} `json:"stat"`
+	Dependency []struct {
+		Module       string `json:"module"`
+		Phase        string `json:"phase"`
+		Relationship string `json:"relationship"`
+	} `json:"dependency"`
+	Resources struct {
+		Homepage   string `json:"homepage"`
+		Repository struct {
+			Web string `json:"web"`
+			URL string `json:"url"`
+		} `json:"repository"`
+		Bugtracker struct {
+			Web string `json:"web"`
+		} `json:"bugtracker"`
+	} `json:"resources"`
+}
+
+// metacpanGet fetches the given MetaCPAN path and decodes the JSON
+// response into v. It returns false if MetaCPAN reports that the
+// resource does not exist.
+func metacpanGet(ctx context.Context, path string, v interface{}) bool {
+	resp, err := util.HTTPGet(ctx, metacpanURL+path)
+	if err != nil {
+		util.Die("MetaCPAN: %s", err)
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case 200:
+		break
+	case 404:
+		return false
+	default:
+		util.Die("MetaCPAN: HTTP status %d", resp.StatusCode)
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		util.Die("MetaCPAN: %s", err)
+	}
+
+	if err := json.Unmarshal(body, v); err != nil {
+		util.Die("MetaCPAN response: %s", err)
+	}
+	return true
+}
+
+// search implements Search for Carton using MetaCPAN.
+func search(ctx context.Context, query string) []api.PkgInfo {
+	var suggestions metacpanSuggestions
+	metacpanGet(ctx, "/search/autocomplete/suggest?q="+url.QueryEscape(query), &suggestions)
+
+	results := []api.PkgInfo{}
+	for _, s := range suggestions.Suggestions {
+		version := ""
+		if sep := strings.LastIndex(s.Release, "-"); sep != -1 {
+			version = s.Release[sep+1:]
+		}
+		results = append(results, api.PkgInfo{
+			Name:    s.Name,
+			Version: version,
+			Author:  s.Author,
+		})
+	}
+	return results
+}
+
+// info implements Info for Carton using MetaCPAN. Packages are module
+// names, so the module is resolved to its distribution first.
+func info(ctx context.Context, name api.PkgName) api.PkgInfo {
+	var module metacpanModule
+	if !metacpanGet(ctx, "/module/"+util.EscapePathSegment(string(name)), &module) {
+		return api.PkgInfo{}
+	}
+
+	var release metacpanRelease
+	if !metacpanGet(ctx, "/release/"+util.EscapePathSegment(module.Distribution), &release) {
+		return api.PkgInfo{}
+	}
+
+	deps := []string{}
+	for _, dep := range release.Dependency {
+		if dep.Phase != "runtime" || dep.Relationship != "requires" || dep.Module == "perl" {
+			continue
+		}
+		deps = append(deps, dep.Module)
+	}
+
+	sourceCodeURL := release.Resources.Repository.Web
+	if sourceCodeURL == "" {
+		sourceCodeURL = release.Resources.Repository.URL
+	}
+
+	return api.PkgInfo{
+		Name:             string(name),
+		Description:      release.Abstract,
+		Version:          release.Version,
+		HomepageURL:      release.Resources.Homepage,
+		DocumentationURL: "https://metacpan.org/pod/" + util.EscapePathSegment(string(name)),
+		SourceCodeURL:    sourceCodeURL,
+		BugTrackerURL:    release.Resources.Bugtracker.Web,
+		Author:           release.Author,
+		License:          strings.Join(release.License, ", "),
+		Dependencies:     deps,
+		DependencyCount:  len(deps),
+		ReleaseDate:      util.FormatTimestamp(release.Date),
+		Size:             release.Stat.Size,
+	}
+}
+
+// perlCoreModules are modules that ship with Perl itself (or are
+// pragmas), which must never be guessed as dependencies.
+var perlCoreModules = map[string]bool{
+	"B":                  true,
+	"Benchmark":          true,
+	"Carp":               true,
+	"Config":             true,
+	"Cwd":                true,
+	"Data::Dumper":       true,
+	"Digest::MD5":        true,
+	"Digest::SHA":        true,
+	"Encode":             true,
+	"English":            true,
+	"Errno":              true,
+	"Exporter":           true,
+	"Fcntl":              true,
+	"File::Basename":     true,
+	"File::Copy":         true,
+	"File::Find":         true,
+	"File::Path":         true,
+	"File::Spec":         true,
+	"File::Temp":         true,
+	"FindBin":            true,
+	"Getopt::Long":       true,
+	"Getopt::Std":        true,
+	"HTTP::Tiny":         true,
+	"IO::File":           true,
+	"IO::Handle":         true,
+	"IO::Socket":         true,
+	"IPC::Open3":         true,
+	"JSON::PP":           true,
+	"List::Util":         true,
+	"MIME::Base64":       true,
+	"Math::BigInt":       true,
+	"POSIX":              true,
+	"Pod::Usage":         true,
+	"Scalar::Util":       true,
+	"Socket":             true,
+	"Storable":           true,
+	"Sys::Hostname":      true,
+	"Term::ANSIColor":    true,
+	"Test::More":         true,
+	"Text::Wrap":         true,
+	"Tie::Hash":          true,
+	"Time::HiRes":        true,
+	"Time::Local":        true,
+	"Time::Piece":        true,
+	"UNIVERSAL":          true,
+	"Unicode::Normalize": true,
+}
+
+// perlUseRegexp matches use and require statements that load a
+// module by name, e.g. use Plack::Request; or require JSON::XS;.
+var perlUseRegexp = regexp.MustCompile(`(?m)^\s*(?:use|require|no)\s+([A-Za-z][A-Za-z0-9_]*(?:::[A-Za-z0-9_]+)*)`)
+
+// guess implements Guess for Carton. Pragmas (which are lowercase by
+// convention), core modules, and modules provided by the project
+// itself are skipped.
+func guess(ctx context.Context) (map[api.PkgName]bool, bool) {
+	pkgs := map[api.PkgName]bool{}
+	for _, match := range util.SearchRecursive(ctx, perlUseRegexp, perlPatterns) {
+		mod := match[1]
+		if mod[0] >= 'a' && mod[0] <= 'z' {
+			continue
+		}
+		if perlCoreModules[mod] {
+			continue
+		}
+		path := filepath.Join(strings.Split(mod, "::")...) + ".pm"
+		if util.Exists(path) || util.Exists(filepath.Join("lib", path)) {
+			continue
+		}
+		pkgs[api.PkgName(mod)] = true
+	}
+	return pkgs, true
+}
+
+// readSpecfile returns the contents of the cpanfile, or the empty
+// string if it does not exist yet.
+func readSpecfile() string {
+	contentsB, err := ioutil.ReadFile("cpanfile")
+	if os.IsNotExist(err) {
+		return ""
+	} else if err != nil {
+		util.Die("cpanfile: %s", err)
+	}
+	return string(contentsB)
+}
+
+// PerlCartonBackend is a UPM backend for Perl that uses Carton.
+var PerlCartonBackend = api.LanguageBackend{
+	Name:             "perl-carton",
+	Specfile:         "cpanfile",
+	Lockfile:         "cpanfile.snapshot",
+	FilenamePatterns: perlPatterns,
+	Quirks:           api.QuirksLockAlsoInstalls,
+	Executables:      []string{"carton"},
+	GetPackageDir: func() string {
+		return "local"
+	},
+	Search: search,
+	Info:   info,
+	Add: func(ctx context.Context, pkgs map[api.PkgName]api.PkgSpec, projectName string) {
+		contents := addToSpecfileContents(readSpecfile(), pkgs)
+		util.ProgressMsg("write cpanfile")
+		util.TryWriteAtomic("cpanfile", []byte(contents))
+	},
+	Remove: func(ctx context.Context, pkgs map[api.PkgName]bool) {
+		contents := removeFromSpecfileContents(readSpecfile(), pkgs)
+		util.ProgressMsg("write cpanfile")
+		util.TryWriteAtomic("cpanfile", []byte(contents))
+	},
+	Lock: func(ctx context.Context) {
+		// Carton resolves, installs, and writes the snapshot
+		// all in one step.
+		util.RunCmd(ctx, []string{"carton", "install"})
+	},
+	Install: func(ctx context.Context) {
+		// --deployment installs exactly what is recorded in
+		// cpanfile.snapshot, without re-resolving.
+		util.RunCmd(ctx, []string{"carton", "install", "--deployment"})
+	},
+	ListSpecfile: func() map[api.PkgName]api.PkgSpec {
+		return listSpecfileWithContents(readSpecfile())
+	},
+	ListLockfile: func() map[api.PkgName]api.PkgVersion {
+		contentsB, err := ioutil.ReadFile("cpanfile.snapshot")
+		if err != nil {
+			util.Die("cpanfile.snapshot: %s", err)
+		}
+		return listLockfileWithContents(string(contentsB))
+	},
+	GuessRegexps: []*regexp.Regexp{perlUseRegexp},
+	Guess:        guess,
+}
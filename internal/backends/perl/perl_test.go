@@ -0,0 +1,75 @@
+package perl
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/replit/upm/internal/api"
+)
+
+const testCpanfile = `requires 'perl', '5.010';
+requires 'Plack', '1.0';
+requires "DBI" => ">= 1.6";
+requires 'JSON::XS';
+
+on 'test' => sub {
+    requires 'Test::Deep';
+};
+`
+
+func TestListSpecfile(t *testing.T) {
+	pkgs := listSpecfileWithContents(testCpanfile)
+
+	require.Equal(t, map[api.PkgName]api.PkgSpec{
+		"Plack":      "1.0",
+		"DBI":        ">= 1.6",
+		"JSON::XS":   "",
+		"Test::Deep": "",
+	}, pkgs)
+}
+
+func TestAddAndRemove(t *testing.T) {
+	contents := addToSpecfileContents(testCpanfile, map[api.PkgName]api.PkgSpec{
+		"Moo":  "2.0",
+		"Carp": "",
+	})
+	require.True(t, strings.HasSuffix(contents, "};\nrequires 'Carp';\nrequires 'Moo', '2.0';\n"))
+
+	contents = removeFromSpecfileContents(contents, map[api.PkgName]bool{
+		"DBI":        true,
+		"Test::Deep": true,
+	})
+	require.Equal(t, map[api.PkgName]api.PkgSpec{
+		"Plack":    "1.0",
+		"JSON::XS": "",
+		"Moo":      "2.0",
+		"Carp":     "",
+	}, listSpecfileWithContents(contents))
+	require.Contains(t, contents, "on 'test' => sub {")
+}
+
+func TestListLockfile(t *testing.T) {
+	pkgs := listLockfileWithContents(`# carton snapshot format: version 1.0
+DISTRIBUTIONS
+  JSON-XS-4.03
+    pathname: M/ML/MLEHMANN/JSON-XS-4.03.tar.gz
+    provides:
+      JSON::XS 4.03
+    requirements:
+      Types::Serialiser 0
+  libwww-perl-6.72
+    pathname: O/OA/OALDERS/libwww-perl-6.72.tar.gz
+    provides:
+      LWP 6.72
+      LWP::UserAgent 6.72
+    requirements:
+      URI 1.10
+`)
+
+	require.Equal(t, map[api.PkgName]api.PkgVersion{
+		"JSON::XS": "4.03",
+		"LWP":      "6.72",
+	}, pkgs)
+}
@@ -0,0 +1,173 @@
+package python
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/url"
+	"strings"
+
+	"github.com/replit/upm/internal/api"
+	"github.com/replit/upm/internal/util"
+)
+
+// anacondaURL is the base URL of the anaconda.org API.
+const anacondaURL = "https://api.anaconda.org"
+
+// anacondaPackage represents a package in the responses of the
+// anaconda.org API.
+type anacondaPackage struct {
+	Name          string `json:"name"`
+	Summary       string `json:"summary"`
+	LatestVersion string `json:"latest_version"`
+	Home          string `json:"home"`
+	DevURL        string `json:"dev_url"`
+	DocURL        string `json:"doc_url"`
+	License       string `json:"license"`
+	// The channel the package is in, such as
+	// "conda-forge/numpy".
+	FullName string `json:"full_name"`
+	Files    []struct {
+		Version string `json:"version"`
+		Attrs   struct {
+			Subdir  string   `json:"subdir"`
+			Depends []string `json:"depends"`
+		} `json:"attrs"`
+	} `json:"files"`
+}
+
+// anacondaGet fetches the given path from the anaconda.org API and
+// returns the response body, or nil if there is no such package.
+func anacondaGet(ctx context.Context, path string) []byte {
+	resp, err := util.HTTPGet(ctx, anacondaURL+path)
+	if err != nil {
+		util.Die("anaconda.org: %s", err)
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case 200:
+		break
+	case 404:
+		return nil
+	default:
+		util.Die("anaconda.org: HTTP status %d", resp.StatusCode)
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		util.Die("anaconda.org: %s", err)
+	}
+	return body
+}
+
+// condaChannels returns the channels on anaconda.org that the project
+// gets its packages from, in order of priority. They are read from
+// environment.yml if it has any, and otherwise default to
+// conda-forge. Channels given by URL are not on anaconda.org, so they
+// are left out.
+func condaChannels() []string {
+	configured := []string{}
+	if util.Exists(condaSpecfile) {
+		configured = parseCondaEnvironment(readTextFile(condaSpecfile)).Channels
+	}
+	channels := []string{}
+	for _, channel := range configured {
+		switch {
+		case channel == "defaults":
+			channels = append(channels, "anaconda")
+		case !strings.Contains(channel, "/"):
+			channels = append(channels, channel)
+		}
+	}
+	if len(channels) == 0 {
+		channels = []string{"conda-forge"}
+	}
+	return channels
+}
+
+// anacondaChannel returns the channel of the given package.
+func anacondaChannel(pkg anacondaPackage) string {
+	return strings.SplitN(pkg.FullName, "/", 2)[0]
+}
+
+// condaSearch implements Search for conda. Only packages in the
+// channels of the project are returned, each one from the channel
+// with the highest priority that has it.
+func condaSearch(ctx context.Context, query string) []api.PkgInfo {
+	body := anacondaGet(ctx, "/search?type=conda&name="+url.QueryEscape(query))
+	if body == nil {
+		return []api.PkgInfo{}
+	}
+	var pkgs []anacondaPackage
+	if err := json.Unmarshal(body, &pkgs); err != nil {
+		util.Die("anaconda.org response: %s", err)
+	}
+
+	results := []api.PkgInfo{}
+	seen := map[string]bool{}
+	for _, channel := range condaChannels() {
+		for _, pkg := range pkgs {
+			if anacondaChannel(pkg) != channel || seen[pkg.Name] {
+				continue
+			}
+			seen[pkg.Name] = true
+			results = append(results, api.PkgInfo{
+				Name:        pkg.Name,
+				Description: pkg.Summary,
+				Version:     pkg.LatestVersion,
+				HomepageURL: pkg.Home,
+			})
+		}
+	}
+	return results
+}
+
+// condaInfo implements Info for conda, looking the package up in each
+// of the channels of the project in turn.
+func condaInfo(ctx context.Context, name api.PkgName) api.PkgInfo {
+	for _, channel := range condaChannels() {
+		body := anacondaGet(ctx, "/package/"+util.EscapePathSegment(channel)+"/"+util.EscapePathSegment(string(name)))
+		if body == nil {
+			continue
+		}
+		var pkg anacondaPackage
+		if err := json.Unmarshal(body, &pkg); err != nil {
+			util.Die("anaconda.org response: %s", err)
+		}
+
+		// Dependencies differ between platforms, so take them
+		// from the build of the latest version for this one,
+		// or failing that the one that works everywhere.
+		deps := []string{}
+		platform := currentCondaPlatform()
+		for _, subdir := range []string{platform, "noarch"} {
+			for _, file := range pkg.Files {
+				if file.Version != pkg.LatestVersion || file.Attrs.Subdir != subdir {
+					continue
+				}
+				for _, dep := range file.Attrs.Depends {
+					if depName, _ := parseCondaMatchSpec(dep); depName != "" {
+						deps = append(deps, string(depName))
+					}
+				}
+				break
+			}
+			if len(deps) > 0 {
+				break
+			}
+		}
+
+		return api.PkgInfo{
+			Name:             pkg.Name,
+			Description:      pkg.Summary,
+			Version:          pkg.LatestVersion,
+			HomepageURL:      pkg.Home,
+			DocumentationURL: pkg.DocURL,
+			SourceCodeURL:    pkg.DevURL,
+			License:          pkg.License,
+			Dependencies:     deps,
+		}
+	}
+	return api.PkgInfo{}
+}
@@ -0,0 +1,134 @@
+package python
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+
+	"github.com/replit/upm/internal/api"
+	"github.com/replit/upm/internal/util"
+)
+
+// condaSpecfile and condaLockfile are the specfile and lockfile of the
+// conda backend.
+const (
+	condaSpecfile = "environment.yml"
+	condaLockfile = "conda-lock.yml"
+)
+
+// condaEnvDir is the environment that packages are installed into,
+// relative to the project, when no other conda environment is active.
+const condaEnvDir = ".conda"
+
+// getConda returns the value of UPM_CONDA if it is set, and otherwise
+// "mamba" if it is installed (since it is much faster), or else
+// "conda".
+func getConda() string {
+	if conda := os.Getenv("UPM_CONDA"); conda != "" {
+		return conda
+	}
+	if _, err := exec.LookPath("mamba"); err == nil {
+		return "mamba"
+	}
+	return "conda"
+}
+
+// condaPrefix returns the conda environment that packages are
+// installed into: the active one, unless that is the base
+// environment, and otherwise condaEnvDir within the project.
+func condaPrefix() string {
+	if prefix := os.Getenv("CONDA_PREFIX"); prefix != "" &&
+		os.Getenv("CONDA_DEFAULT_ENV") != "base" {
+		return prefix
+	}
+	prefix, err := filepath.Abs(condaEnvDir)
+	if err != nil {
+		util.Die("%s: %s", condaEnvDir, err)
+	}
+	return prefix
+}
+
+// condaMakeBackend returns a language backend for Python that uses
+// the given conda executable (or a compatible one, such as mamba),
+// with environment.yml as the specfile. The lockfile is generated and
+// installed by conda-lock.
+func condaMakeBackend(conda string, python string) api.LanguageBackend {
+	// condaLock returns the command that runs the given conda-lock
+	// command.
+	condaLock := func(command string, args ...string) []string {
+		return append([]string{"conda-lock", command, "--conda", conda}, args...)
+	}
+
+	// listSpecfile lists the packages in the specfile for guess,
+	// which doesn't guess them again.
+	listSpecfile := func() (map[api.PkgName]api.PkgSpec, error) {
+		if !util.Exists(condaSpecfile) {
+			return nil, os.ErrNotExist
+		}
+		return listCondaEnvironment(readTextFile(condaSpecfile)), nil
+	}
+
+	return api.LanguageBackend{
+		Name:                 "python-python3-conda",
+		Specfile:             condaSpecfile,
+		Lockfile:             condaLockfile,
+		FilenamePatterns:     []string{"*.py", "*.ipynb"},
+		Executables:          []string{"conda-lock", conda},
+		NormalizePackageName: condaNormalizePackageName,
+		GetPackageDir:        condaPrefix,
+		Search:               condaSearch,
+		Info:                 condaInfo,
+		Add: func(ctx context.Context, pkgs map[api.PkgName]api.PkgSpec, projectName string) {
+			contents := ""
+			if util.Exists(condaSpecfile) {
+				contents = readTextFile(condaSpecfile)
+			}
+			contents = addToCondaEnvironment(contents, pkgs, projectName)
+			util.ProgressMsg("write " + condaSpecfile)
+			util.TryWriteAtomic(condaSpecfile, []byte(contents))
+		},
+		Remove: func(ctx context.Context, pkgs map[api.PkgName]bool) {
+			contents := removeFromCondaEnvironment(readTextFile(condaSpecfile), pkgs)
+			util.ProgressMsg("write " + condaSpecfile)
+			util.TryWriteAtomic(condaSpecfile, []byte(contents))
+		},
+		// conda-lock solves for every platform listed in
+		// environment.yml (or its own defaults if there are
+		// none), so the lockfile works on all of them.
+		Lock: func(ctx context.Context) {
+			util.RunCmd(ctx, condaLock(
+				"lock", "--file", condaSpecfile, "--lockfile", condaLockfile,
+			))
+		},
+		Upgrade: func(ctx context.Context, pkgs map[api.PkgName]bool) {
+			cmd := condaLock("lock", "--file", condaSpecfile, "--lockfile", condaLockfile)
+			for name := range pkgs {
+				cmd = append(cmd, "--update", string(name))
+			}
+			util.RunCmd(ctx, cmd)
+		},
+		Install: func(ctx context.Context) {
+			util.RunCmd(ctx, condaLock("install", "--prefix", condaPrefix(), condaLockfile))
+		},
+		ListSpecfile: func() map[api.PkgName]api.PkgSpec {
+			return listCondaEnvironment(readTextFile(condaSpecfile))
+		},
+		ListLockfile: func() map[api.PkgName]api.PkgVersion {
+			return listCondaLock(readTextFile(condaLockfile), currentCondaPlatform())
+		},
+		GuessRegexps:  pythonGuessRegexps,
+		GuessCacheKey: mapOverlayCacheKey,
+		// Most packages have the same name on conda-forge as on
+		// PyPI, so the guesses are made in the same way.
+		Guess: func(ctx context.Context) (map[api.PkgName]bool, bool) {
+			return guess(ctx, python, listSpecfile)
+		},
+		GuessDetails: func(ctx context.Context) (map[api.PkgName]api.GuessedPkg, bool) {
+			return guessDetails(ctx, python, listSpecfile)
+		},
+	}
+}
+
+// UPM backend for Python 3 that uses conda (or mamba) and conda-lock.
+var Python3CondaBackend = condaMakeBackend(getConda(), getPython3())
@@ -0,0 +1,112 @@
+package python
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/replit/upm/internal/api"
+)
+
+var (
+	downloadsOnce sync.Once
+	// Map from normalized package names to their download
+	// counts in pypiPackageToDownloads.
+	normDownloads map[api.PkgName]int
+
+	providersOnce sync.Once
+	// Map from modules to the packages in pypiPackageToModules
+	// that provide them.
+	providers map[string][]string
+)
+
+// downloads implements Downloads for the Python backends, from the
+// counts in the module map. A count of zero means that the count wasn't
+// known when the map was generated, as for a crawled map.
+func downloads(name api.PkgName) (api.PkgDownloads, bool) {
+	var count int
+	if value, ok := pypiPackageToDownloads.Get(string(name)); ok {
+		count, _ = strconv.Atoi(value)
+	} else {
+		// Only decode the whole table if the name isn't
+		// spelled as in the map.
+		downloadsOnce.Do(func() {
+			normDownloads = map[api.PkgName]int{}
+			pypiPackageToDownloads.Each(func(pkg string, value string) {
+				count, _ := strconv.Atoi(value)
+				normDownloads[normalizePackageName(api.PkgName(pkg))] = count
+			})
+		})
+		count = normDownloads[normalizePackageName(name)]
+	}
+	if count == 0 {
+		return api.PkgDownloads{}, false
+	}
+	return api.PkgDownloads{Count: count, Date: downloadsDate()}, true
+}
+
+// downloadCount returns the number of downloads of the given package,
+// or zero if it isn't known.
+func downloadCount(name string) int {
+	pkg, _ := downloads(api.PkgName(name))
+	return pkg.Count
+}
+
+// moduleProviders returns the packages in the generated map that
+// provide the given module, most downloaded first.
+func moduleProviders(mod string) []string {
+	providersOnce.Do(func() {
+		providers = map[string][]string{}
+		pypiPackageToModules.Each(func(pkg string, mods string) {
+			for _, m := range strings.Split(mods, ",") {
+				providers[m] = append(providers[m], pkg)
+			}
+		})
+	})
+	pkgs := append([]string{}, providers[mod]...)
+	sortByDownloads(pkgs)
+	return pkgs
+}
+
+// sortByDownloads sorts the given packages by how many times they have
+// been downloaded, most first, and then by name.
+func sortByDownloads(pkgs []string) {
+	sort.Slice(pkgs, func(i, j int) bool {
+		di, dj := downloadCount(pkgs[i]), downloadCount(pkgs[j])
+		if di != dj {
+			return di > dj
+		}
+		return pkgs[i] < pkgs[j]
+	})
+}
+
+// formatDownloads returns the number of downloads of the given package
+// for an explanation, such as "1200 downloads as of 2024-05-01".
+func formatDownloads(pkg string) string {
+	text := fmt.Sprintf("%d downloads", downloadCount(pkg))
+	if date := downloadsDate(); date != "" {
+		text += " as of " + date
+	}
+	return text
+}
+
+// explainGeneratedGuess says why the generated map guesses that the
+// given module is provided by the given package, as for --explain.
+func explainGeneratedGuess(mod string, pkg string) string {
+	if moduleMatchesPackage(mod, pkg) {
+		return "its name matches"
+	}
+	others := []string{}
+	for _, other := range moduleProviders(mod) {
+		if other != pkg {
+			others = append(others, other)
+		}
+	}
+	if len(others) == 0 {
+		return fmt.Sprintf("it is the only package that provides it (%s)", formatDownloads(pkg))
+	}
+	return fmt.Sprintf("it has the most downloads of the %d packages that provide it (%s; next is %s with %d)",
+		len(others)+1, formatDownloads(pkg), others[0], downloadCount(others[0]))
+}
@@ -0,0 +1,311 @@
+package python
+
+import (
+	"regexp"
+	"runtime"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+
+	"github.com/replit/upm/internal/api"
+	"github.com/replit/upm/internal/util"
+)
+
+// condaMatchSpecRegexp matches a conda match spec, such as
+// "conda-forge::numpy>=1.26", capturing the channel (if any), the
+// name of the package, and the version constraint (which may be
+// empty).
+var condaMatchSpecRegexp = regexp.MustCompile(`^(?:([^:\s]+)::)?([A-Za-z0-9_][A-Za-z0-9_.-]*)\s*(.*)$`)
+
+// condaListItemRegexp matches an item of a YAML block sequence,
+// capturing its indentation and its value.
+var condaListItemRegexp = regexp.MustCompile(`^(\s*)-\s+(.*?)\s*$`)
+
+// condaEnvironment represents the parts of environment.yml that UPM
+// reads.
+type condaEnvironment struct {
+	Name     string   `yaml:"name"`
+	Channels []string `yaml:"channels"`
+	// Each dependency is either a match spec or, for pip, a map
+	// from "pip" to a list of requirements.
+	Dependencies []interface{} `yaml:"dependencies"`
+}
+
+// condaLock represents the parts of a conda-lock.yml lockfile that
+// UPM reads.
+type condaLock struct {
+	Package []struct {
+		Name     string `yaml:"name"`
+		Version  string `yaml:"version"`
+		Manager  string `yaml:"manager"`
+		Platform string `yaml:"platform"`
+	} `yaml:"package"`
+}
+
+// condaNormalizePackageName implements NormalizePackageName for
+// conda, whose package names are always lowercase.
+func condaNormalizePackageName(name api.PkgName) api.PkgName {
+	return api.PkgName(strings.ToLower(string(name)))
+}
+
+// parseCondaEnvironment parses the given contents of environment.yml.
+func parseCondaEnvironment(contents string) condaEnvironment {
+	var env condaEnvironment
+	if err := yaml.Unmarshal([]byte(contents), &env); err != nil {
+		util.Die("environment.yml: %s", err)
+	}
+	return env
+}
+
+// parseCondaMatchSpec splits a conda match spec into the name of the
+// package and its constraint, dropping the channel. The name is empty
+// if the spec is not valid.
+func parseCondaMatchSpec(spec string) (api.PkgName, api.PkgSpec) {
+	m := condaMatchSpecRegexp.FindStringSubmatch(strings.TrimSpace(spec))
+	if m == nil {
+		return "", ""
+	}
+	return api.PkgName(m[2]), api.PkgSpec(strings.TrimSpace(m[3]))
+}
+
+// listCondaEnvironment returns the conda packages in the given
+// contents of environment.yml. Requirements in the pip section are
+// left out, because they don't come from a conda channel.
+func listCondaEnvironment(contents string) map[api.PkgName]api.PkgSpec {
+	pkgs := map[api.PkgName]api.PkgSpec{}
+	for _, dep := range parseCondaEnvironment(contents).Dependencies {
+		if str, ok := dep.(string); ok {
+			if name, spec := parseCondaMatchSpec(str); name != "" {
+				pkgs[name] = spec
+			}
+		}
+	}
+	return pkgs
+}
+
+// currentCondaPlatform returns the name conda gives to the platform
+// UPM is running on, such as "linux-64".
+func currentCondaPlatform() string {
+	goos := map[string]string{
+		"darwin":  "osx",
+		"linux":   "linux",
+		"windows": "win",
+	}[runtime.GOOS]
+	arch := map[string]string{
+		"386":     "32",
+		"amd64":   "64",
+		"arm64":   "arm64",
+		"ppc64le": "ppc64le",
+		"s390x":   "s390x",
+	}[runtime.GOARCH]
+	if goos == "linux" && arch == "arm64" {
+		arch = "aarch64"
+	}
+	return goos + "-" + arch
+}
+
+// listCondaLock returns the packages in the given contents of a
+// conda-lock.yml lockfile, for the given platform. Packages that were
+// locked only for other platforms are included too, with the version
+// locked for whichever of them comes first.
+func listCondaLock(contents string, platform string) map[api.PkgName]api.PkgVersion {
+	var lock condaLock
+	if err := yaml.Unmarshal([]byte(contents), &lock); err != nil {
+		util.Die("%s: %s", condaLockfile, err)
+	}
+	pkgs := map[api.PkgName]api.PkgVersion{}
+	exact := map[api.PkgName]bool{}
+	for _, pkg := range lock.Package {
+		name := api.PkgName(pkg.Name)
+		if pkg.Platform == platform && !exact[name] {
+			pkgs[name] = api.PkgVersion(pkg.Version)
+			exact[name] = true
+		} else if _, ok := pkgs[name]; !ok {
+			pkgs[name] = api.PkgVersion(pkg.Version)
+		}
+	}
+	return pkgs
+}
+
+// condaDependencyLine is a line of environment.yml that is part of the
+// list of dependencies.
+type condaDependencyLine struct {
+	// The line, without its newline.
+	text string
+	// The package it requires, or the empty string if it isn't a
+	// conda package (for example the pip section, or a line
+	// inside it).
+	name api.PkgName
+	// The indentation and channel to keep when the line is
+	// replaced.
+	indent  string
+	channel string
+}
+
+// splitCondaEnvironment splits the lines of the given contents of
+// environment.yml into those before the list of dependencies, the
+// list itself, and those after it. If there is no list, then the
+// second and third results are empty and found is false.
+func splitCondaEnvironment(contents string) (before []string, deps []condaDependencyLine, after []string, found bool) {
+	lines := strings.Split(strings.TrimSuffix(contents, "\n"), "\n")
+	start := -1
+	for i, line := range lines {
+		switch strings.TrimRight(line, " \t\r") {
+		case "dependencies: []":
+			lines[i] = "dependencies:"
+			fallthrough
+		case "dependencies:":
+			start = i + 1
+		}
+		if start != -1 {
+			break
+		}
+	}
+	if start == -1 {
+		return lines, nil, nil, false
+	}
+
+	end := start
+	itemIndent := -1
+	for ; end < len(lines); end++ {
+		line := strings.TrimRight(lines[end], "\r")
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+		indent := len(line) - len(strings.TrimLeft(line, " \t"))
+		if itemIndent == -1 {
+			if !strings.HasPrefix(trimmed, "- ") {
+				break
+			}
+			itemIndent = indent
+		}
+		if indent < itemIndent || (indent == itemIndent && !strings.HasPrefix(trimmed, "- ")) {
+			break
+		}
+	}
+	// Blank lines and comments at the end belong to whatever
+	// follows.
+	for end > start {
+		trimmed := strings.TrimSpace(lines[end-1])
+		if trimmed != "" && !strings.HasPrefix(trimmed, "#") {
+			break
+		}
+		end--
+	}
+
+	for _, line := range lines[start:end] {
+		dep := condaDependencyLine{text: line}
+		m := condaListItemRegexp.FindStringSubmatch(strings.TrimRight(line, "\r"))
+		indent := len(line) - len(strings.TrimLeft(line, " \t"))
+		if m != nil && indent == itemIndent {
+			value := m[2]
+			if idx := strings.Index(value, " #"); idx != -1 {
+				value = strings.TrimSpace(value[:idx])
+			}
+			value = strings.Trim(value, `"'`)
+			if sm := condaMatchSpecRegexp.FindStringSubmatch(value); sm != nil && !strings.HasSuffix(value, ":") {
+				dep.name = api.PkgName(sm[2])
+				dep.indent = m[1]
+				dep.channel = sm[1]
+			}
+		}
+		deps = append(deps, dep)
+	}
+	return lines[:start], deps, lines[end:], true
+}
+
+// formatCondaMatchSpec returns the match spec that requires the given
+// package. A spec that is just a version, such as "1.26", requires a
+// version that starts with it.
+func formatCondaMatchSpec(channel string, name api.PkgName, spec api.PkgSpec) string {
+	prefix := ""
+	if channel != "" {
+		prefix = channel + "::"
+	}
+	switch {
+	case spec == "":
+		return prefix + string(name)
+	case '0' <= spec[0] && spec[0] <= '9':
+		return prefix + string(name) + "=" + string(spec)
+	default:
+		return prefix + string(name) + string(spec)
+	}
+}
+
+// joinCondaEnvironment puts the parts returned by
+// splitCondaEnvironment back together.
+func joinCondaEnvironment(before []string, deps []condaDependencyLine, after []string) string {
+	lines := append([]string{}, before...)
+	for _, dep := range deps {
+		lines = append(lines, dep.text)
+	}
+	lines = append(lines, after...)
+	return strings.Join(lines, "\n") + "\n"
+}
+
+// addToCondaEnvironment returns the given contents of environment.yml
+// with the given packages added to its dependencies. A package that
+// is there already has its line replaced, keeping its name as written
+// and its channel; the others are added at the end of the list. If
+// the contents are empty, a new environment with the given name is
+// created, using the conda-forge channel.
+func addToCondaEnvironment(contents string, pkgs map[api.PkgName]api.PkgSpec, projectName string) string {
+	if strings.TrimSpace(contents) == "" {
+		contents = "name: " + projectName + "\nchannels:\n  - conda-forge\n"
+	}
+	before, deps, after, found := splitCondaEnvironment(contents)
+	if !found {
+		before = append(before, "dependencies:")
+	}
+
+	indent := "  "
+	added := map[api.PkgName]bool{}
+	for i, dep := range deps {
+		if dep.name == "" {
+			continue
+		}
+		indent = dep.indent
+		for name, spec := range pkgs {
+			if condaNormalizePackageName(name) == condaNormalizePackageName(dep.name) {
+				deps[i].text = dep.indent + "- " + formatCondaMatchSpec(dep.channel, dep.name, spec)
+				added[name] = true
+			}
+		}
+	}
+
+	names := []string{}
+	for name := range pkgs {
+		if !added[name] {
+			names = append(names, string(name))
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		deps = append(deps, condaDependencyLine{
+			text: indent + "- " + formatCondaMatchSpec("", api.PkgName(name), pkgs[api.PkgName(name)]),
+		})
+	}
+	return joinCondaEnvironment(before, deps, after)
+}
+
+// removeFromCondaEnvironment returns the given contents of
+// environment.yml without the given packages in its dependencies.
+func removeFromCondaEnvironment(contents string, pkgs map[api.PkgName]bool) string {
+	before, deps, after, found := splitCondaEnvironment(contents)
+	if !found {
+		return contents
+	}
+	removed := map[api.PkgName]bool{}
+	for name := range pkgs {
+		removed[condaNormalizePackageName(name)] = true
+	}
+	kept := []condaDependencyLine{}
+	for _, dep := range deps {
+		if dep.name == "" || !removed[condaNormalizePackageName(dep.name)] {
+			kept = append(kept, dep)
+		}
+	}
+	return joinCondaEnvironment(before, kept, after)
+}
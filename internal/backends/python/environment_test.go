@@ -0,0 +1,122 @@
+package python
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/replit/upm/internal/api"
+)
+
+const testEnvironment = `name: analysis
+channels:
+  - conda-forge
+  - defaults
+dependencies:
+  - python=3.11
+  # Numerics
+  - numpy>=1.26  # the array library
+  - conda-forge::pandas
+  - pip
+  - pip:
+    - requests
+
+variables:
+  MPLBACKEND: Agg
+`
+
+func TestListCondaEnvironment(t *testing.T) {
+	require.Equal(t, map[api.PkgName]api.PkgSpec{
+		"python": "=3.11",
+		"numpy":  ">=1.26",
+		"pandas": "",
+		"pip":    "",
+	}, listCondaEnvironment(testEnvironment))
+}
+
+func TestAddToCondaEnvironment(t *testing.T) {
+	require.Equal(t, `name: analysis
+channels:
+  - conda-forge
+  - defaults
+dependencies:
+  - python=3.11
+  # Numerics
+  - numpy>=1.26  # the array library
+  - conda-forge::pandas>=2
+  - pip
+  - pip:
+    - requests
+  - scipy
+  - xarray=2024.1
+
+variables:
+  MPLBACKEND: Agg
+`, addToCondaEnvironment(testEnvironment, map[api.PkgName]api.PkgSpec{
+		"Pandas": ">=2",
+		"xarray": "2024.1",
+		"scipy":  "",
+	}, "analysis"))
+
+	require.Equal(t, `name: demo
+channels:
+  - conda-forge
+dependencies:
+  - numpy
+`, addToCondaEnvironment("", map[api.PkgName]api.PkgSpec{"numpy": ""}, "demo"))
+
+	require.Equal(t, `name: demo
+dependencies:
+  - numpy
+`, addToCondaEnvironment("name: demo\ndependencies: []\n", map[api.PkgName]api.PkgSpec{"numpy": ""}, "demo"))
+}
+
+func TestRemoveFromCondaEnvironment(t *testing.T) {
+	require.Equal(t, `name: analysis
+channels:
+  - conda-forge
+  - defaults
+dependencies:
+  - python=3.11
+  # Numerics
+  - pip
+  - pip:
+    - requests
+
+variables:
+  MPLBACKEND: Agg
+`, removeFromCondaEnvironment(testEnvironment, map[api.PkgName]bool{
+		"numpy":  true,
+		"pandas": true,
+	}))
+}
+
+func TestListCondaLock(t *testing.T) {
+	require.Equal(t, map[api.PkgName]api.PkgVersion{
+		"numpy":    "1.26.4",
+		"appnope":  "0.1.4",
+		"requests": "2.31.0",
+	}, listCondaLock(`version: 1
+metadata:
+  platforms:
+  - osx-arm64
+  - linux-64
+package:
+- name: numpy
+  version: 1.26.3
+  manager: conda
+  platform: osx-arm64
+- name: numpy
+  version: 1.26.4
+  manager: conda
+  platform: linux-64
+- name: appnope
+  version: 0.1.4
+  manager: conda
+  platform: osx-arm64
+- name: requests
+  version: 2.31.0
+  manager: pip
+  platform: linux-64
+`, "linux-64"))
+}
@@ -0,0 +1,58 @@
+package python
+
+import (
+	"context"
+	"os"
+	"strings"
+
+	"github.com/replit/upm/internal/backends/python/pyindex"
+	"github.com/replit/upm/internal/util"
+)
+
+// poetrySource is a source repository in pyproject.toml.
+type poetrySource struct {
+	Name string `toml:"name"`
+	URL  string `toml:"url"`
+}
+
+// poetrySourceName is the name that the configured index is given
+// when UPM adds it to pyproject.toml.
+const poetrySourceName = "upm"
+
+// usePoetrySource makes Poetry resolve packages from the configured
+// index, if it isn't PyPI, by adding it to pyproject.toml as a source
+// repository unless it is there already. The credentials are passed
+// through the environment rather than written anywhere. The
+// pyproject.toml file must exist.
+func usePoetrySource(ctx context.Context, poetry string) {
+	index := pyindex.Get()
+	if index.IsPyPI() {
+		return
+	}
+
+	name := ""
+	if cfg, err := readPyproject(); err == nil {
+		for _, source := range cfg.Tool.Poetry.Source {
+			if strings.TrimSuffix(source.URL, "/") == index.URL {
+				name = source.Name
+			}
+		}
+	}
+	if name == "" {
+		name = poetrySourceName
+		requirePoetry(ctx, poetry, "1.2", "a private package index")
+		util.RunCmd(ctx, []string{poetry, "source", "add", name, index.URL})
+	}
+
+	if index.Username != "" || index.Password != "" {
+		env := "POETRY_HTTP_BASIC_" + strings.ToUpper(strings.NewReplacer("-", "_", ".", "_").Replace(name))
+		os.Setenv(env+"_USERNAME", index.Username)
+		os.Setenv(env+"_PASSWORD", index.Password)
+	}
+}
+
+// indexRegistry implements Registry for the Python backends, giving
+// the simple index that packages are resolved from.
+func indexRegistry() string {
+	return pyindex.Get().URL + "/"
+}
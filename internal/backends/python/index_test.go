@@ -0,0 +1,203 @@
+package python
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/replit/upm/internal/api"
+	"github.com/replit/upm/internal/backends/python/pyindex"
+	"github.com/replit/upm/internal/config"
+	"github.com/replit/upm/internal/util"
+)
+
+func TestPrivateIndex(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		username, password, ok := r.BasicAuth()
+		if !ok || username != "__token__" || password != "s3cret" {
+			w.WriteHeader(401)
+			return
+		}
+		switch r.URL.Path {
+		case "/simple/":
+			w.Write([]byte(`<html><body>
+<a href="/simple/corp-auth/">corp-auth</a>
+<a href="/simple/corp_utils/">corp_utils</a>
+</body></html>`))
+		case "/pypi/corp-auth/json":
+			w.Write([]byte(`{
+  "info": {
+    "name": "corp-auth", "version": "1.2.0", "summary": "Auth",
+    "keywords": "auth, sso", "requires_dist": ["requests>=2"],
+    "project_urls": {"Source": "https://git.example.com/corp-auth"}
+  },
+  "urls": [
+    {"size": 1200, "upload_time_iso_8601": "2024-05-01T10:00:03.123456Z"},
+    {"size": 3400, "upload_time_iso_8601": "2024-05-01T10:00:01.654321Z"}
+  ]
+}`))
+		default:
+			w.WriteHeader(404)
+		}
+	}))
+	defer server.Close()
+
+	os.Setenv("UPM_PYPI_URL", server.URL+"/simple/")
+	os.Setenv("UPM_PYPI_TOKEN", "s3cret")
+	defer os.Unsetenv("UPM_PYPI_URL")
+	defer os.Unsetenv("UPM_PYPI_TOKEN")
+
+	index := pyindex.Get()
+	require.False(t, index.IsPyPI())
+	require.Equal(t, server.URL+"/pypi", index.APIURL)
+	require.Equal(t, []api.PkgName{"corp-auth", "corp_utils"}, index.ListProjects())
+
+	require.Equal(t, api.PkgInfo{
+		Name:            "corp-auth",
+		Version:         "1.2.0",
+		Description:     "Auth",
+		SourceCodeURL:   "https://git.example.com/corp-auth",
+		Dependencies:    []string{"requests>=2"},
+		DependencyCount: 1,
+		Extras:          []string{},
+		ReleaseDate:     "2024-05-01T10:00:01Z",
+		Size:            3400,
+		Keywords:        []string{"auth", "sso"},
+	}, info(context.Background(), "corp-auth"))
+	require.Equal(t, api.PkgInfo{}, info(context.Background(), "corp-utils"))
+
+	results := search(context.Background(), "corp")
+	require.Len(t, results, 1)
+	require.Equal(t, "corp-auth", results[0].Name)
+}
+
+func TestInfoCache(t *testing.T) {
+	requests := 0
+	down := false
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if down {
+			w.WriteHeader(503)
+			return
+		}
+		w.Write([]byte(`{"info": {"name": "Flask", "version": "3.0.0"}, "urls": []}`))
+	}))
+	defer server.Close()
+
+	os.Setenv("UPM_PYPI_URL", server.URL+"/simple")
+	defer os.Unsetenv("UPM_PYPI_URL")
+	defer func() { config.CacheTTL = 0 }()
+
+	config.CacheTTL = time.Hour
+	require.Equal(t, "3.0.0", info(context.Background(), "Flask").Version)
+	require.Equal(t, "3.0.0", info(context.Background(), "flask").Version)
+	require.Equal(t, 1, requests)
+
+	// Stale information is still used if the index is down.
+	config.CacheTTL = 0
+	down = true
+	require.Equal(t, "3.0.0", info(context.Background(), "flask").Version)
+	require.Equal(t, 2, requests)
+}
+
+func TestSearchLimits(t *testing.T) {
+	var mutex sync.Mutex
+	inFlight, maxInFlight := 0, 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/simple/" {
+			projects := []string{`{"name": "corp-hang"}`}
+			for i := 0; i < 20; i++ {
+				projects = append(projects, fmt.Sprintf(`{"name": "corp-%d"}`, i))
+			}
+			fmt.Fprintf(w, `{"projects": [%s]}`, strings.Join(projects, ", "))
+			return
+		}
+
+		mutex.Lock()
+		inFlight++
+		if inFlight > maxInFlight {
+			maxInFlight = inFlight
+		}
+		mutex.Unlock()
+		defer func() {
+			mutex.Lock()
+			inFlight--
+			mutex.Unlock()
+		}()
+
+		name := strings.Split(r.URL.Path, "/")[2]
+		if name == "corp-hang" {
+			<-r.Context().Done()
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+		fmt.Fprintf(w, `{"info": {"name": %q, "version": "1.0"}, "urls": []}`, name)
+	}))
+	defer server.Close()
+
+	os.Setenv("UPM_PYPI_URL", server.URL+"/simple")
+	defer os.Unsetenv("UPM_PYPI_URL")
+
+	config.Jobs = 3
+	defer func() { config.Jobs = 0 }()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	results := searchContext(ctx, "corp")
+	require.Len(t, results, 20)
+	require.LessOrEqual(t, maxInFlight, 3)
+}
+
+// roundTripFunc is an http.RoundTripper that is a function.
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+func TestSearchFallback(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/simple/":
+			w.Write([]byte(`{"projects": [{"name": "brandnew-http"}, {"name": "Brandnew_Cli"}, {"name": "flask"}]}`))
+		case "/pypi/brandnew-http/json", "/pypi/Brandnew_Cli/json":
+			name := strings.Split(r.URL.Path, "/")[2]
+			fmt.Fprintf(w, `{"info": {"name": %q, "version": "0.1.0"}, "urls": []}`, name)
+		default:
+			w.WriteHeader(404)
+		}
+	}))
+	defer server.Close()
+
+	// Send the requests meant for PyPI to the server instead.
+	client := util.HTTPClient
+	defer func() { util.HTTPClient = client }()
+	target := strings.TrimPrefix(server.URL, "http://")
+	util.HTTPClient = &http.Client{Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		req.URL.Scheme = "http"
+		req.URL.Host = target
+		return http.DefaultTransport.RoundTrip(req)
+	})}
+	require.True(t, pyindex.Get().IsPyPI())
+
+	// A package that isn't in the module map is found by its
+	// exact name.
+	results := search(context.Background(), "brandnew-http")
+	require.Len(t, results, 1)
+	require.Equal(t, "brandnew-http", results[0].Name)
+	require.Empty(t, search(context.Background(), "brandnew"))
+
+	config.RemoteSearch = true
+	defer func() { config.RemoteSearch = false }()
+	results = search(context.Background(), "brandnew")
+	require.Len(t, results, 2)
+	require.Equal(t, "Brandnew_Cli", results[0].Name)
+	require.Equal(t, "brandnew-http", results[1].Name)
+}
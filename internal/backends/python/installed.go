@@ -0,0 +1,198 @@
+package python
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/csv"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+
+	"github.com/replit/upm/internal/api"
+	"github.com/replit/upm/internal/util"
+)
+
+// implicitPackages are the packages that a virtualenv comes with,
+// which Poetry leaves out of the lockfile unless something depends on
+// them.
+var implicitPackages = map[api.PkgName]bool{
+	"pip":        true,
+	"setuptools": true,
+	"wheel":      true,
+	"distribute": true,
+}
+
+// pyprojectName returns the name of the project in pyproject.toml, or
+// the empty string if there is none.
+func pyprojectName() string {
+	if !util.Exists("pyproject.toml") {
+		return ""
+	}
+	var cfg pyprojectTOML
+	if _, err := toml.DecodeFile("pyproject.toml", &cfg); err != nil {
+		util.Die("%s", err.Error())
+	}
+	if cfg.Tool.Poetry.Name != "" {
+		return cfg.Tool.Poetry.Name
+	}
+	return cfg.Project.Name
+}
+
+// sitePackagesDirs returns the site-packages directories of the
+// virtualenv in the given directory, or of __pypackages__, or, if it
+// is empty, those of the Python installation that python runs.
+func sitePackagesDirs(python string, venv string) []string {
+	var dirs []string
+	if venv == pypackagesDir {
+		return []string{pypackagesLibDir(context.Background(), python)}
+	} else if venv == "" {
+		output := util.GetCmdOutput(context.Background(), []string{python, "-c",
+			"import sysconfig; p = sysconfig.get_paths(); print(p['purelib']); print(p['platlib'])"})
+		dirs = strings.Fields(string(output))
+	} else {
+		for _, pattern := range []string{
+			filepath.Join(venv, "lib", "python*", "site-packages"),
+			filepath.Join(venv, "lib64", "python*", "site-packages"),
+			// On Windows.
+			filepath.Join(venv, "Lib", "site-packages"),
+		} {
+			matches, _ := filepath.Glob(pattern)
+			dirs = append(dirs, matches...)
+		}
+	}
+
+	// lib64 is usually a symlink to lib.
+	seen := map[string]bool{}
+	unique := []string{}
+	for _, dir := range dirs {
+		resolved, err := filepath.EvalSymlinks(dir)
+		if err != nil {
+			resolved = dir
+		}
+		if !seen[resolved] {
+			seen[resolved] = true
+			unique = append(unique, dir)
+		}
+	}
+	return unique
+}
+
+// listInstalled implements ListInstalled for the packages installed
+// in the given site-packages directories, which need not exist. The
+// files of each package are checked against the hashes in the RECORD
+// of its .dist-info; a package installed the old way, with an
+// .egg-info, has no hashes to check. The project with the given name
+// is installed by the package manager itself, so it is implicit.
+func listInstalled(dirs []string, projectName string) map[api.PkgName]api.InstalledPkg {
+	pkgs := map[api.PkgName]api.InstalledPkg{}
+	project := normalizePackageName(api.PkgName(projectName))
+	for _, dir := range dirs {
+		entries, err := ioutil.ReadDir(dir)
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			util.Die("%s", err)
+		}
+
+		for _, entry := range entries {
+			path := filepath.Join(dir, entry.Name())
+			var metadata string
+			var record string
+			switch {
+			case strings.HasSuffix(entry.Name(), ".dist-info"):
+				metadata = filepath.Join(path, "METADATA")
+				record = filepath.Join(path, "RECORD")
+			case strings.HasSuffix(entry.Name(), ".egg-info") && entry.IsDir():
+				metadata = filepath.Join(path, "PKG-INFO")
+			case strings.HasSuffix(entry.Name(), ".egg-info"):
+				metadata = path
+			default:
+				continue
+			}
+
+			name, version := readMetadata(metadata)
+			if name == "" {
+				continue
+			}
+			pkg := api.InstalledPkg{Version: api.PkgVersion(version)}
+			if record != "" {
+				pkg.Modified = checkRecord(dir, record)
+			}
+			norm := normalizePackageName(api.PkgName(name))
+			pkg.Implicit = implicitPackages[norm] || projectName != "" && norm == project
+			pkgs[api.PkgName(name)] = pkg
+		}
+	}
+	return pkgs
+}
+
+// readMetadata returns the name and version in the given core metadata
+// file (METADATA or PKG-INFO), or empty strings if it can't be read.
+func readMetadata(path string) (string, string) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", ""
+	}
+	defer file.Close()
+
+	name, version := "", ""
+	scanner := bufio.NewScanner(file)
+	// The headers end at the first blank line, before the
+	// description.
+	for scanner.Scan() && scanner.Text() != "" {
+		field := strings.SplitN(scanner.Text(), ":", 2)
+		if len(field) != 2 {
+			continue
+		}
+		switch strings.ToLower(field[0]) {
+		case "name":
+			name = strings.TrimSpace(field[1])
+		case "version":
+			version = strings.TrimSpace(field[1])
+		}
+	}
+	return name, version
+}
+
+// checkRecord returns the files listed in the given RECORD of a
+// package installed in dir that are missing or don't match their
+// hashes, sorted. Files without a sha256 hash, such as compiled
+// bytecode, aren't checked.
+func checkRecord(dir string, record string) []string {
+	file, err := os.Open(record)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		util.Die("%s", err)
+	}
+	defer file.Close()
+
+	var modified []string
+	reader := csv.NewReader(file)
+	reader.FieldsPerRecord = -1
+	for {
+		row, err := reader.Read()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			util.Die("%s: %s", record, err)
+		}
+		if len(row) < 2 || !strings.HasPrefix(row[1], "sha256=") {
+			continue
+		}
+		contents, err := ioutil.ReadFile(filepath.Join(dir, filepath.FromSlash(row[0])))
+		sum := sha256.Sum256(contents)
+		if err != nil || base64.RawURLEncoding.EncodeToString(sum[:]) != strings.TrimPrefix(row[1], "sha256=") {
+			modified = append(modified, row[0])
+		}
+	}
+	sort.Strings(modified)
+	return modified
+}
@@ -0,0 +1,51 @@
+package python
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/replit/upm/internal/api"
+)
+
+func writeFile(t *testing.T, path string, contents string) {
+	require.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+	require.NoError(t, ioutil.WriteFile(path, []byte(contents), 0644))
+}
+
+func recordLine(path string, contents string) string {
+	sum := sha256.Sum256([]byte(contents))
+	return path + ",sha256=" + base64.RawURLEncoding.EncodeToString(sum[:]) + ",1\n"
+}
+
+func TestListInstalled(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "flask/__init__.py"), "changed")
+	writeFile(t, filepath.Join(dir, "flask/app.py"), "app")
+	writeFile(t, filepath.Join(dir, "Flask-2.3.0.dist-info/METADATA"),
+		"Metadata-Version: 2.1\nName: Flask\nVersion: 2.3.0\n\nName: not a header\n")
+	writeFile(t, filepath.Join(dir, "Flask-2.3.0.dist-info/RECORD"),
+		recordLine("flask/__init__.py", "original")+
+			recordLine("flask/app.py", "app")+
+			recordLine("flask/gone.py", "")+
+			"flask/__pycache__/app.cpython-311.pyc,,\n"+
+			"Flask-2.3.0.dist-info/RECORD,,\n")
+	writeFile(t, filepath.Join(dir, "pip-23.0.dist-info/METADATA"), "Name: pip\nVersion: 23.0\n")
+	writeFile(t, filepath.Join(dir, "my_app-0.1.0.dist-info/METADATA"), "Name: my-app\nVersion: 0.1.0\n")
+	writeFile(t, filepath.Join(dir, "six-1.16.0.egg-info/PKG-INFO"), "Name: six\nVersion: 1.16.0\n")
+
+	require.Equal(t, map[api.PkgName]api.InstalledPkg{
+		"Flask": {
+			Version:  "2.3.0",
+			Modified: []string{"flask/__init__.py", "flask/gone.py"},
+		},
+		"pip":    {Version: "23.0", Implicit: true},
+		"my-app": {Version: "0.1.0", Implicit: true},
+		"six":    {Version: "1.16.0"},
+	}, listInstalled([]string{dir, filepath.Join(dir, "missing")}, "my_app"))
+}
@@ -0,0 +1,130 @@
+package python
+
+import (
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/replit/upm/internal/api"
+	"github.com/replit/upm/internal/backends/python/pyindex"
+	"github.com/replit/upm/internal/backends/python/pypimap"
+)
+
+// The tables of the module map, from the data in pypi_map.gen.go, or
+// the map that 'upm index update' downloaded, if there is one that is
+// newer. Each is only decoded as far as it is used, since most
+// commands need none of them.
+var (
+	// moduleToPypiPackage holds all known modules and their
+	// corresponding best matching package, with how likely it is
+	// to be right, as pypimap.EncodeGuess writes them. This helps
+	// us guess which packages should be installed for the given
+	// imports.
+	moduleToPypiPackage = pypimap.NewTableFunc(func() string {
+		if published := updatedMap(); published != nil {
+			return string(published.ModuleToPackage)
+		}
+		return moduleToPypiPackageData
+	})
+
+	// pypiPackageToModules holds every known python package and
+	// the modules it provides, comma-separated. This helps prevent
+	// us from installing packages for modules which are already
+	// provided by installed packages. The list of modules is
+	// limited to those which could potentially be guessed.
+	pypiPackageToModules = pypimap.NewTableFunc(func() string {
+		if published := updatedMap(); published != nil {
+			return string(published.PackageToModules)
+		}
+		return pypiPackageToModulesData
+	})
+
+	// pypiPackageToDownloads holds every known python package and
+	// the number of times it has been downloaded. This is used for
+	// ordering the python search results.
+	pypiPackageToDownloads = pypimap.NewTableFunc(func() string {
+		if published := updatedMap(); published != nil {
+			return string(published.PackageToDownloads)
+		}
+		return pypiPackageToDownloadsData
+	})
+)
+
+var (
+	updatedMapOnce   sync.Once
+	loadedUpdatedMap *pypimap.Published
+)
+
+// updatedMap returns the map that 'upm index update' downloaded, or nil
+// if there is none, or if its download counts are older than those of
+// the map built into UPM, as after upgrading UPM, in which case the
+// built-in one is used. It is read once.
+func updatedMap() *pypimap.Published {
+	updatedMapOnce.Do(func() {
+		published := pypimap.ReadUpdatedMap()
+		if published != nil && published.DownloadsDate < pypiDownloadsDate {
+			published = nil
+		}
+		loadedUpdatedMap = published
+	})
+	return loadedUpdatedMap
+}
+
+// downloadsDate returns the date on which the download counts in
+// pypiPackageToDownloads were taken, as YYYY-MM-DD, or "" if that
+// isn't known.
+func downloadsDate() string {
+	if published := updatedMap(); published != nil {
+		return published.DownloadsDate
+	}
+	return pypiDownloadsDate
+}
+
+// MapSnapshot describes the module map that the Python backends use,
+// for 'upm version --verbose': whether it is the one built into UPM or
+// one that 'upm index update' downloaded, and when its snapshot of PyPI
+// was taken.
+func MapSnapshot() string {
+	date := downloadsDate()
+	if date == "" {
+		date = "an unknown date"
+	}
+	if published := updatedMap(); published != nil {
+		return fmt.Sprintf("downloaded to %s, made %s, downloads as of %s",
+			pypimap.UpdatedMapFile(), published.Generated, date)
+	}
+	return "built in, downloads as of " + date
+}
+
+// MapStatus implements 'upm index status' for the Python backends. The
+// list of projects of the index is only searched if the index isn't
+// PyPI, or with --remote.
+func MapStatus() api.MapStatus {
+	status := api.MapStatus{
+		Ecosystem:     "pypi",
+		Generated:     pypiMapGenerated,
+		DownloadsDate: downloadsDate(),
+		Imports:       moduleToPypiPackage.Len(),
+		CachesInfo:    true,
+	}
+	if published := updatedMap(); published != nil {
+		status.File = pypimap.UpdatedMapFile()
+		status.Generated = published.Generated
+	}
+	if index := pyindex.Get(); !index.IsPyPI() {
+		status.RemoteSearch = index.URL
+	}
+	return status
+}
+
+// updatedMapCacheKey returns what identifies the file that 'upm map
+// update' writes, so that guesses are made again when it changes,
+// without reading it.
+func updatedMapCacheKey() string {
+	file := pypimap.UpdatedMapFile()
+	info, err := os.Stat(file)
+	if err != nil {
+		return ""
+	}
+	return fmt.Sprintf("%s\x00%d\x00%d\x00", file, info.Size(), info.ModTime().UnixNano())
+}
@@ -0,0 +1,64 @@
+package python
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/replit/upm/internal/backends/python/pypimap"
+	"github.com/replit/upm/internal/config"
+)
+
+func TestMapTables(t *testing.T) {
+	value, ok := moduleToPypiPackage.Get("flask")
+	require.True(t, ok)
+	require.Equal(t, "Flask 0.95", value)
+	mods, _ := pypiPackageToModules.Get("Flask")
+	require.Contains(t, mods, "flask")
+	_, ok = pypiPackageToDownloads.Get("requests")
+	require.True(t, ok)
+}
+
+// The precision and recall of the guesses of the module map against
+// guess_corpus.txt that TestGuessAccuracy requires. They are a little
+// below what the map gets, so that a change to how it is generated
+// that makes the guesses worse fails, and should be raised when one
+// makes them better.
+const (
+	minGuessPrecision = 0.8
+	minGuessRecall    = 0.65
+)
+
+func TestGuessAccuracy(t *testing.T) {
+	accuracy := pypimap.Evaluate(pypimap.ReadCorpus("guess_corpus.txt"), func(mod string) (string, float64, bool) {
+		pkg, _, confidence, ok := resolveModule(mod)
+		return pkg, confidence, ok
+	}, config.DefaultMinConfidence)
+	var report strings.Builder
+	accuracy.Report(&report)
+	t.Log(report.String())
+	require.NoError(t, accuracy.Check(minGuessPrecision, minGuessRecall))
+}
+
+// The benchmarks below measure what a command pays for the module map
+// the first time it uses it. A command that doesn't pays nothing, and
+// nothing of the map is in the heap until then.
+
+// BenchmarkModuleMapGet measures guessing a package for one import,
+// which decodes one block of the table.
+func BenchmarkModuleMapGet(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		pypimap.NewTable(moduleToPypiPackageData).Get("flask")
+	}
+}
+
+// BenchmarkModuleMapEach measures searching, which decodes the whole
+// table of packages.
+func BenchmarkModuleMapEach(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		pypimap.NewTable(pypiPackageToModulesData).Each(func(string, string) {})
+	}
+}
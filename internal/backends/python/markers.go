@@ -0,0 +1,58 @@
+package python
+
+import (
+	"strings"
+
+	"github.com/replit/upm/internal/api"
+)
+
+// poetryMarkers returns the restrictions on when a Poetry spec applies,
+// to go after the version, as in a requirement: for example "; python
+// ^3.8; sys_platform == 'win32'" for a spec with "python" and
+// "markers" keys. The "python" and "platform" keys of Poetry aren't
+// PEP 508 markers, so they are written as the key followed by the
+// value, which can't be mistaken for a marker.
+func poetryMarkers(spec map[string]interface{}) string {
+	markers := ""
+	for _, key := range []string{"python", "platform", "markers"} {
+		value, ok := spec[key].(string)
+		if !ok || strings.TrimSpace(value) == "" {
+			continue
+		}
+		if key != "markers" {
+			value = key + " " + value
+		}
+		markers += "; " + strings.TrimSpace(value)
+	}
+	return markers
+}
+
+// splitSpecMarkers splits a spec such as "^1.0; python ^3.8;
+// sys_platform == 'win32'", as ListSpecfile returns it, into the rest
+// of the spec and the arguments that make 'poetry add' write the same
+// restrictions. A requirement from the PEP 621 [project] table may
+// have PEP 508 markers in the same place.
+func splitSpecMarkers(spec api.PkgSpec) (api.PkgSpec, []string) {
+	parts := strings.Split(string(spec), ";")
+	args := []string{}
+	markers := []string{}
+	for _, part := range parts[1:] {
+		part = strings.TrimSpace(part)
+		switch {
+		case part == "":
+			continue
+		case strings.HasPrefix(part, "python "):
+			args = append(args, "--python", strings.TrimSpace(strings.TrimPrefix(part, "python ")))
+		case strings.HasPrefix(part, "platform "):
+			args = append(args, "--platform", strings.TrimSpace(strings.TrimPrefix(part, "platform ")))
+		default:
+			markers = append(markers, part)
+		}
+	}
+	if len(markers) == 1 {
+		args = append(args, "--markers", markers[0])
+	} else if len(markers) > 1 {
+		args = append(args, "--markers", "("+strings.Join(markers, ") and (")+")")
+	}
+	return api.PkgSpec(strings.TrimSpace(parts[0])), args
+}
@@ -0,0 +1,227 @@
+package python
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/replit/upm/internal/api"
+	"github.com/replit/upm/internal/backends/python/pypimap"
+	"github.com/replit/upm/internal/project"
+	"github.com/replit/upm/internal/util"
+)
+
+// mapOverlayResource is the overlay that is built into UPM. It is
+// empty unless UPM was built with one, as by an organization that
+// distributes UPM with mappings for its internal packages.
+const mapOverlayResource = "/python/map-overlay.json"
+
+// mapOverlay adds to the module map generated from PyPI, or overrides
+// it. It is read from JSON files that map the names of packages to the
+// modules they provide, such as {"acme-auth": ["acme_auth"]}. Each
+// module is taken to be provided by the package that the last overlay
+// to mention it gives, rather than by the one that the generated map
+// would guess.
+type mapOverlay struct {
+	// Map from normalized package names to the names as given
+	// and the modules they provide.
+	packages map[api.PkgName]overlayPackage
+	// Map from modules to the names of the packages that provide
+	// them.
+	modules map[string]string
+	// The files the overlay was read from and their contents,
+	// which implements GuessCacheKey.
+	cacheKey *strings.Builder
+}
+
+// overlayPackage is one package in a mapOverlay.
+type overlayPackage struct {
+	name string
+	mods []string
+}
+
+var (
+	overlayOnce   sync.Once
+	loadedOverlay mapOverlay
+)
+
+// getMapOverlay returns the overlay of the module map: the built-in
+// one, then the files in map-overlays in the [python] table of
+// .upm/config.toml, then those in UPM_PYPI_MAP_OVERLAYS, which is a
+// list of files separated like PATH, each of which overrides those
+// before it. The files are read once.
+func getMapOverlay() mapOverlay {
+	overlayOnce.Do(func() {
+		loadedOverlay = mapOverlay{
+			packages: map[api.PkgName]overlayPackage{},
+			modules:  map[string]string{},
+			cacheKey: &strings.Builder{},
+		}
+		loadedOverlay.add(mapOverlayResource, util.GetResourceBytes(mapOverlayResource))
+
+		files := project.Read().Python.MapOverlays
+		if env := os.Getenv("UPM_PYPI_MAP_OVERLAYS"); env != "" {
+			files = append(files, filepath.SplitList(env)...)
+		}
+		for _, file := range files {
+			contents, err := ioutil.ReadFile(file)
+			if err != nil {
+				util.Die("module map overlay: %s", err)
+			}
+			loadedOverlay.add(file, contents)
+		}
+	})
+	return loadedOverlay
+}
+
+// add merges the overlay in the given file, whose name is only used
+// in errors, over what is there already.
+func (overlay mapOverlay) add(file string, contents []byte) {
+	overlay.cacheKey.WriteString(file + "\x00")
+	overlay.cacheKey.Write(contents)
+	overlay.cacheKey.WriteString("\x00")
+
+	var pkgs map[string][]string
+	if err := json.Unmarshal(contents, &pkgs); err != nil {
+		util.Die("module map overlay %s: %s", file, err)
+	}
+
+	// If two packages in the same file claim a module, the one
+	// with more downloads wins, or else the first by name.
+	names := []string{}
+	for name := range pkgs {
+		names = append(names, name)
+	}
+	sortByDownloads(names)
+	claimed := map[string]bool{}
+	for _, name := range names {
+		norm := normalizePackageName(api.PkgName(name))
+		if old, ok := overlay.packages[norm]; ok {
+			for _, mod := range old.mods {
+				if overlay.modules[mod] == old.name {
+					delete(overlay.modules, mod)
+				}
+			}
+		}
+		overlay.packages[norm] = overlayPackage{name: name, mods: pkgs[name]}
+		for _, mod := range pkgs[name] {
+			if !claimed[mod] {
+				overlay.modules[mod] = name
+				claimed[mod] = true
+			}
+		}
+	}
+}
+
+// mapOverlayCacheKey implements GuessCacheKey for the Python
+// backends, so that packages are guessed again when an overlay or
+// project.OverridesFile changes, or 'upm index update' downloads a new
+// map.
+func mapOverlayCacheKey() string {
+	return getMapOverlay().cacheKey.String() + updatedMapCacheKey() +
+		fmt.Sprint(project.Overrides("python"))
+}
+
+// overridePackage returns the package that project.OverridesFile says
+// provides the given module, along with the module that it was found
+// for: the longest prefix of the module that is there, as for
+// resolveModule.
+func overridePackage(mod string) (string, string, bool) {
+	overrides := project.Overrides("python")
+	for prefix := mod; ; {
+		if pkg, ok := overrides[prefix]; ok {
+			return pkg, prefix, true
+		}
+		dot := strings.LastIndexByte(prefix, '.')
+		if dot < 0 {
+			return "", "", false
+		}
+		prefix = prefix[:dot]
+	}
+}
+
+// packageModules returns the modules that the given package provides,
+// according to the overlay or else the generated map.
+func packageModules(name api.PkgName) ([]string, bool) {
+	if pkg, ok := getMapOverlay().packages[normalizePackageName(name)]; ok {
+		return pkg.mods, true
+	}
+	mods, ok := pypiPackageToModules.Get(string(name))
+	if !ok {
+		return nil, false
+	}
+	return strings.Split(mods, ","), true
+}
+
+// modulePackage returns the package that most likely provides the
+// given module, according to the overlay or else the generated map,
+// along with how likely it is to be right, from 0 to 1. The overlay is
+// taken to be certain.
+func modulePackage(mod string) (string, float64, bool) {
+	if pkg, ok := getMapOverlay().modules[mod]; ok {
+		return pkg, 1, true
+	}
+	value, ok := moduleToPypiPackage.Get(mod)
+	if !ok {
+		return "", 0, false
+	}
+	pkg, confidence := pypimap.DecodeGuess(value)
+	return pkg, confidence, true
+}
+
+// resolveModule returns the package that most likely provides the
+// given module, which may be dotted, as in google.cloud.storage.blob,
+// and how likely it is to be right, along with the module that it was
+// found for: the longest prefix of the module that is in the overlay
+// or the generated map. The packages of a namespace such as
+// google.cloud are in the map by their dotted names, so shorter
+// prefixes are only tried if the longer ones aren't known.
+func resolveModule(mod string) (string, string, float64, bool) {
+	for prefix := mod; ; {
+		if pkg, confidence, ok := modulePackage(prefix); ok {
+			return pkg, prefix, confidence, true
+		}
+		dot := strings.LastIndexByte(prefix, '.')
+		if dot < 0 {
+			return "", "", 0, false
+		}
+		prefix = prefix[:dot]
+	}
+}
+
+// moduleMatchesPackage returns true if the given module, which may be
+// dotted, is named like the given package, as google.cloud.storage is
+// like google-cloud-storage.
+func moduleMatchesPackage(mod string, pkg string) bool {
+	replacer := strings.NewReplacer(".", "-", "_", "-")
+	return strings.ToLower(replacer.Replace(mod)) == strings.ToLower(replacer.Replace(pkg))
+}
+
+// explainModulePackage says why modulePackage returned the given
+// package for the given module, for --explain.
+func explainModulePackage(mod string, pkg string) string {
+	if _, ok := getMapOverlay().modules[mod]; ok {
+		return "a module map overlay says so"
+	}
+	return explainGeneratedGuess(mod, pkg)
+}
+
+// knownPackages returns the name of every package in the overlay or
+// the generated map.
+func knownPackages() []string {
+	overlay := getMapOverlay()
+	names := []string{}
+	for _, pkg := range overlay.packages {
+		names = append(names, pkg.name)
+	}
+	pypiPackageToModules.Each(func(name string, mods string) {
+		if _, ok := overlay.packages[normalizePackageName(api.PkgName(name))]; !ok {
+			names = append(names, name)
+		}
+	})
+	return names
+}
@@ -0,0 +1,80 @@
+package python
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMapOverlay(t *testing.T) {
+	dir := t.TempDir()
+	first := filepath.Join(dir, "first.json")
+	second := filepath.Join(dir, "second.json")
+	require.NoError(t, ioutil.WriteFile(first, []byte(`{
+  "acme-auth": ["acme_auth", "acme_common"],
+  "Acme_Old": ["acme_legacy"]
+}`), 0644))
+	// A later overlay replaces what an earlier one said about the
+	// same package, and can claim the modules of the generated map.
+	require.NoError(t, ioutil.WriteFile(second, []byte(`{
+  "ACME_Auth": ["acme_auth"],
+  "acme-yaml": ["yaml"],
+  "upmtestcorp-cloud-storage": ["upmtestcorp.cloud.storage"]
+}`), 0644))
+
+	os.Setenv("UPM_PYPI_MAP_OVERLAYS", strings.Join([]string{first, second}, string(os.PathListSeparator)))
+	defer os.Unsetenv("UPM_PYPI_MAP_OVERLAYS")
+	overlayOnce = sync.Once{}
+	defer func() { overlayOnce = sync.Once{} }()
+
+	mods, ok := packageModules("acme_auth")
+	require.True(t, ok)
+	require.Equal(t, []string{"acme_auth"}, mods)
+
+	pkg, confidence, ok := modulePackage("acme_auth")
+	require.True(t, ok)
+	require.Equal(t, "ACME_Auth", pkg)
+	require.Equal(t, 1.0, confidence)
+	_, _, ok = modulePackage("acme_common")
+	require.False(t, ok)
+	pkg, _, _ = modulePackage("acme_legacy")
+	require.Equal(t, "Acme_Old", pkg)
+	pkg, _, _ = modulePackage("yaml")
+	require.Equal(t, "acme-yaml", pkg)
+
+	// The generated map is still used for everything else.
+	pkg, confidence, _ = modulePackage("flask")
+	require.Equal(t, "Flask", pkg)
+	require.Equal(t, 0.95, confidence)
+	require.Contains(t, knownPackages(), "acme-yaml")
+
+	// Dotted imports are resolved by their longest known prefix.
+	pkg, mod, _, ok := resolveModule("upmtestcorp.cloud.storage.blob")
+	require.True(t, ok)
+	require.Equal(t, "upmtestcorp-cloud-storage", pkg)
+	require.Equal(t, "upmtestcorp.cloud.storage", mod)
+	require.True(t, moduleMatchesPackage(mod, pkg))
+	pkg, mod, _, _ = resolveModule("flask.json.provider")
+	require.Equal(t, "Flask", pkg)
+	require.Equal(t, "flask", mod)
+	_, _, _, ok = resolveModule("upmtestcorp.cloud")
+	require.False(t, ok)
+
+	require.Equal(t, "a module map overlay says so", explainModulePackage("yaml", "acme-yaml"))
+	require.Equal(t, "its name matches", explainModulePackage("flask", "Flask"))
+}
+
+func TestDownloads(t *testing.T) {
+	pkg, ok := downloads("Requests")
+	require.True(t, ok)
+	require.Greater(t, pkg.Count, 0)
+	require.Equal(t, downloadsDate(), pkg.Date)
+
+	_, ok = downloads("no-such-package-for-upm")
+	require.False(t, ok)
+}
@@ -0,0 +1,173 @@
+package python
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/replit/upm/internal/api"
+	"github.com/replit/upm/internal/util"
+)
+
+// getPdm returns either "pdm" or the value of UPM_PDM.
+func getPdm() string {
+	if pdm := os.Getenv("UPM_PDM"); pdm != "" {
+		return pdm
+	}
+	return "pdm"
+}
+
+// pdmPyproject returns a minimal pyproject.toml for a new project with
+// the given name. It isn't a distribution, so PDM doesn't try to build
+// and install the project itself.
+func pdmPyproject(projectName string) string {
+	if projectName == "" {
+		projectName = "project"
+	}
+	return fmt.Sprintf(`[project]
+name = %q
+version = "0.1.0"
+dependencies = []
+
+[tool.pdm]
+distribution = false
+`, projectName)
+}
+
+// pdmMakeBackend returns a language backend for Python 3 that uses the
+// given PDM executable, with a pyproject.toml that follows PEP 621 as
+// the specfile and pdm.lock as the lockfile.
+func pdmMakeBackend(pdm string, python string) api.LanguageBackend {
+	// listSpecfile lists the packages in the specfile for guess,
+	// which doesn't guess them again.
+	listSpecfile := func() (map[api.PkgName]api.PkgSpec, error) {
+		cfg, err := readPep621Pyproject()
+		if err != nil {
+			return nil, err
+		}
+		return listPep621Pyproject(cfg), nil
+	}
+
+	// PDM uses an active virtualenv if there is one, and
+	// otherwise __pypackages__ in PEP 582 mode or .venv in
+	// the project.
+	getPackageDir := func() string {
+		if venv := os.Getenv("VIRTUAL_ENV"); venv != "" {
+			return venv
+		}
+		if util.Exists(pypackagesDir) {
+			return pypackagesDir
+		}
+		return ".venv"
+	}
+
+	getExecEnv := func() api.ExecEnv {
+		return pythonExecEnv(python, getPackageDir())
+	}
+
+	return api.LanguageBackend{
+		Name:             "python-python3-pdm",
+		Specfile:         "pyproject.toml",
+		Lockfile:         "pdm.lock",
+		LockfileComment:  "#",
+		OSVEcosystem:     "PyPI",
+		Registry:         indexRegistry,
+		FilenamePatterns: []string{"*.py", "*.ipynb"},
+		OwnsSpecfile: func() bool {
+			cfg, err := readPep621Pyproject()
+			return err == nil && cfg.Tool.Pdm != nil
+		},
+		Quirks: api.QuirksAddRemoveAlsoLocks |
+			api.QuirksAddRemoveAlsoInstalls,
+		Executables:          []string{pdm},
+		NormalizePackageName: normalizePackageName,
+		GetPackageDir:        getPackageDir,
+		GetExecEnv:           getExecEnv,
+		Search:               search,
+		Info:                 info,
+		Downloads:            downloads,
+		Add: func(ctx context.Context, pkgs map[api.PkgName]api.PkgSpec, projectName string) {
+			if !util.Exists("pyproject.toml") {
+				util.ProgressMsg("write pyproject.toml")
+				util.TryWriteAtomic("pyproject.toml", []byte(pdmPyproject(projectName)))
+			}
+			cmd := []string{pdm, "add"}
+			for name, spec := range pkgs {
+				cmd = append(cmd, formatRequirement(name, spec))
+			}
+			util.RunCmd(ctx, cmd)
+		},
+		InitSpecfile: func(ctx context.Context, projectName string) {
+			util.ProgressMsg("write pyproject.toml")
+			util.TryWriteAtomic("pyproject.toml", []byte(pdmPyproject(projectName)))
+		},
+		Remove: func(ctx context.Context, pkgs map[api.PkgName]bool) {
+			cmd := []string{pdm, "remove"}
+			for name := range pkgs {
+				cmd = append(cmd, string(name))
+			}
+			util.RunCmd(ctx, cmd)
+		},
+		Lock: func(ctx context.Context) {
+			util.RunCmd(ctx, []string{pdm, "lock"})
+		},
+		Upgrade: func(ctx context.Context, pkgs map[api.PkgName]bool) {
+			cmd := []string{pdm, "update", "--no-sync"}
+			for name := range pkgs {
+				cmd = append(cmd, string(name))
+			}
+			util.RunCmd(ctx, cmd)
+		},
+		// Unlike 'pdm install', this never updates the lockfile.
+		Install: func(ctx context.Context) {
+			util.RunCmd(ctx, []string{pdm, "sync"})
+		},
+		ListSpecfile: func() map[api.PkgName]api.PkgSpec {
+			cfg, err := readPep621Pyproject()
+			if err != nil {
+				util.Die("%s", err.Error())
+			}
+			return listPep621Pyproject(cfg)
+		},
+		SpecSource:      specSource,
+		LegacySpecfiles: setuptoolsSpecfiles,
+		ListLegacySpecfile: func() map[api.PkgName]api.PkgSpec {
+			return listSetuptools(python)
+		},
+		CheckRuntime: func() (string, []api.PkgName) {
+			return checkPython("pyproject.toml", listSpecfile)
+		},
+		UpgradeRuntime: upgradePython,
+		Diagnose: func() []api.Problem {
+			return diagnoseVirtualenv(".venv")
+		},
+		ListLockfile: func() map[api.PkgName]api.PkgVersion {
+			return listPackageLock(readTextFile("pdm.lock"))
+		},
+		ListLockfileDependencies: func() map[api.PkgName][]api.PkgName {
+			return listPackageLockDependencies("pdm.lock")
+		},
+		ExportLockfile: func(ctx context.Context, groups []string, file string) {
+			util.RunCmd(ctx, exportCmd([]string{pdm, "export", "--format", "requirements",
+				"--output", file}, "--prod", groups))
+		},
+		ListInstalled: func() map[api.PkgName]api.InstalledPkg {
+			return listInstalled(sitePackagesDirs(python, getPackageDir()), pyprojectName())
+		},
+		ListScripts: func() map[string]string {
+			return listPep621Scripts(true)
+		},
+		RunScript:     runScript(pdm),
+		GuessRegexps:  pythonGuessRegexps,
+		GuessCacheKey: mapOverlayCacheKey,
+		Guess: func(ctx context.Context) (map[api.PkgName]bool, bool) {
+			return guess(ctx, python, listSpecfile)
+		},
+		GuessDetails: func(ctx context.Context) (map[api.PkgName]api.GuessedPkg, bool) {
+			return guessDetails(ctx, python, listSpecfile)
+		},
+	}
+}
+
+// UPM backend for Python 3 that uses PDM.
+var Python3PdmBackend = pdmMakeBackend(getPdm(), getPython3())
@@ -0,0 +1,154 @@
+package python
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+
+	"github.com/replit/upm/internal/api"
+	"github.com/replit/upm/internal/util"
+)
+
+// pep621Project represents the [project] table of a pyproject.toml
+// file that follows PEP 621.
+type pep621Project struct {
+	Name         string   `toml:"name"`
+	Dependencies []string `toml:"dependencies"`
+	// Map from the name of each extra to the requirements that
+	// it adds.
+	OptionalDependencies map[string][]string `toml:"optional-dependencies"`
+	// Map from the name of each console script to the object it
+	// runs, such as "demo.cli:main".
+	Scripts map[string]string `toml:"scripts"`
+}
+
+// requirements returns every requirement in the table, including
+// those of the extras.
+func (p pep621Project) requirements() []string {
+	reqs := append([]string{}, p.Dependencies...)
+	for _, extra := range p.OptionalDependencies {
+		reqs = append(reqs, extra...)
+	}
+	return reqs
+}
+
+// pep621Pyproject represents the parts of a pyproject.toml file that
+// follows PEP 621 that the uv and PDM backends read.
+type pep621Pyproject struct {
+	Project pep621Project `toml:"project"`
+	// Each group is a list of requirements, although it may also
+	// include other groups by means of tables.
+	DependencyGroups map[string][]interface{} `toml:"dependency-groups"`
+	Tool             struct {
+		// PDM kept development dependencies in its own table
+		// before dependency groups were standardized. The
+		// table is nil if there is no [tool.pdm] at all.
+		Pdm *struct {
+			DevDependencies map[string][]string `toml:"dev-dependencies"`
+			// PDM's own scripts, each of which is a
+			// command or a table such as {call = ...}.
+			Scripts map[string]interface{} `toml:"scripts"`
+		} `toml:"pdm"`
+	} `toml:"tool"`
+}
+
+// packageLockRegexp matches the lines of a uv.lock or pdm.lock file
+// that UPM reads: the start of a package, and its name and version.
+var packageLockRegexp = regexp.MustCompile(`^(?:(\[\[package\]\])|(name|version) = "([^"]*)")\s*$`)
+
+// readPep621Pyproject reads pyproject.toml.
+func readPep621Pyproject() (*pep621Pyproject, error) {
+	var cfg pep621Pyproject
+	if _, err := toml.DecodeFile("pyproject.toml", &cfg); err != nil {
+		return nil, err
+	}
+	return &cfg, nil
+}
+
+// listPep621Pyproject returns the packages required by the given
+// pyproject.toml, including those of extras and those in dependency
+// groups (such as the development dependencies that 'uv add --dev'
+// adds).
+func listPep621Pyproject(cfg *pep621Pyproject) map[api.PkgName]api.PkgSpec {
+	lines := cfg.Project.requirements()
+	for _, group := range cfg.DependencyGroups {
+		for _, req := range group {
+			if str, ok := req.(string); ok {
+				lines = append(lines, str)
+			}
+		}
+	}
+	if cfg.Tool.Pdm != nil {
+		for _, group := range cfg.Tool.Pdm.DevDependencies {
+			lines = append(lines, group...)
+		}
+	}
+	return listRequirements(strings.Join(lines, "\n"))
+}
+
+// listPackageLock returns the packages in the given contents of a
+// uv.lock or pdm.lock file. Both list each package as a [[package]]
+// table with its name and version first.
+func listPackageLock(contents string) map[api.PkgName]api.PkgVersion {
+	pkgs := map[api.PkgName]api.PkgVersion{}
+	inPackage := false
+	var name string
+	for _, line := range strings.Split(contents, "\n") {
+		line = strings.TrimRight(line, "\r")
+		if strings.HasPrefix(line, "[") {
+			inPackage = false
+		}
+		m := packageLockRegexp.FindStringSubmatch(line)
+		switch {
+		case m == nil || (m[1] == "" && !inPackage):
+			continue
+		case m[1] != "":
+			inPackage = true
+			name = ""
+		case m[2] == "name":
+			name = m[3]
+		case m[2] == "version" && name != "":
+			pkgs[api.PkgName(name)] = api.PkgVersion(m[3])
+		}
+	}
+	return pkgs
+}
+
+// packageLockDependencies represents the dependencies of the packages
+// in a uv.lock or pdm.lock file. uv lists each dependency as a table
+// with its name, and PDM as a requirement.
+type packageLockDependencies struct {
+	Package []struct {
+		Name         string        `toml:"name"`
+		Dependencies []interface{} `toml:"dependencies"`
+	} `toml:"package"`
+}
+
+// listPackageLockDependencies returns the packages that each package
+// in the given uv.lock or pdm.lock file depends on, as for
+// ListLockfileDependencies.
+func listPackageLockDependencies(filename string) map[api.PkgName][]api.PkgName {
+	var cfg packageLockDependencies
+	if _, err := toml.Decode(readTextFile(filename), &cfg); err != nil {
+		util.Die("%s: %s", filename, err)
+	}
+	deps := map[api.PkgName][]api.PkgName{}
+	for _, pkg := range cfg.Package {
+		names := []api.PkgName{}
+		for _, dep := range pkg.Dependencies {
+			switch dep := dep.(type) {
+			case map[string]interface{}:
+				if name, ok := dep["name"].(string); ok {
+					names = append(names, api.PkgName(name))
+				}
+			case string:
+				for name := range listRequirements(dep) {
+					names = append(names, name)
+				}
+			}
+		}
+		deps[api.PkgName(pkg.Name)] = names
+	}
+	return deps
+}
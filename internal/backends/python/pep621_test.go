@@ -0,0 +1,180 @@
+package python
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/BurntSushi/toml"
+	"github.com/stretchr/testify/require"
+
+	"github.com/replit/upm/internal/api"
+)
+
+func TestListPep621Pyproject(t *testing.T) {
+	var cfg pep621Pyproject
+	_, err := toml.Decode(`
+[project]
+name = "demo"
+dependencies = [
+    "flask>=3.0",
+    "uvicorn[standard]==0.23.2",
+]
+
+[dependency-groups]
+dev = ["pytest>=8"]
+
+[tool.pdm.dev-dependencies]
+lint = ["ruff"]
+`, &cfg)
+	require.NoError(t, err)
+	require.NotNil(t, cfg.Tool.Pdm)
+	require.Equal(t, map[api.PkgName]api.PkgSpec{
+		"flask":   ">=3.0",
+		"uvicorn": "[standard]==0.23.2",
+		"pytest":  ">=8",
+		"ruff":    "",
+	}, listPep621Pyproject(&cfg))
+}
+
+func TestListPackageLock(t *testing.T) {
+	require.Equal(t, map[api.PkgName]api.PkgVersion{
+		"demo":  "0.1.0",
+		"flask": "3.0.3",
+		"click": "8.1.7",
+	}, listPackageLock(`version = 1
+requires-python = ">=3.11"
+
+[[package]]
+name = "click"
+version = "8.1.7"
+source = { registry = "https://pypi.org/simple" }
+sdist = { url = "https://files.pythonhosted.org/click-8.1.7.tar.gz", hash = "sha256:ca9853ad" }
+
+[[package]]
+name = "demo"
+version = "0.1.0"
+source = { virtual = "." }
+dependencies = [
+    { name = "flask" },
+]
+
+[package.metadata]
+requires-dist = [{ name = "flask", specifier = ">=3.0" }]
+
+[[package]]
+name = "flask"
+version = "3.0.3"
+source = { registry = "https://pypi.org/simple" }
+`))
+}
+
+func TestListPackageLockDependencies(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "uv.lock")
+	require.NoError(t, ioutil.WriteFile(filename, []byte(`version = 1
+
+[[package]]
+name = "demo"
+version = "0.1.0"
+source = { virtual = "." }
+dependencies = [
+    { name = "flask" },
+]
+
+[[package]]
+name = "flask"
+version = "3.0.3"
+dependencies = [
+    { name = "click" },
+    { name = "werkzeug" },
+]
+`), 0644))
+	require.Equal(t, map[api.PkgName][]api.PkgName{
+		"demo":  {"flask"},
+		"flask": {"click", "werkzeug"},
+	}, listPackageLockDependencies(filename))
+
+	// PDM lists the dependencies as requirements.
+	filename = filepath.Join(t.TempDir(), "pdm.lock")
+	require.NoError(t, ioutil.WriteFile(filename, []byte(`[[package]]
+name = "flask"
+version = "3.0.3"
+dependencies = [
+    "Werkzeug>=3.0.0",
+    "importlib-metadata>=3.6.0; python_version < \"3.10\"",
+]
+
+[[package]]
+name = "werkzeug"
+version = "3.0.3"
+`), 0644))
+	require.Equal(t, map[api.PkgName][]api.PkgName{
+		"flask":    {"Werkzeug", "importlib-metadata"},
+		"werkzeug": {},
+	}, listPackageLockDependencies(filename))
+}
+
+func TestListSpecfileWithProjectTable(t *testing.T) {
+	dir := t.TempDir()
+	cwd, err := os.Getwd()
+	require.NoError(t, err)
+	require.NoError(t, os.Chdir(dir))
+	defer os.Chdir(cwd)
+
+	require.NoError(t, ioutil.WriteFile("pyproject.toml", []byte(`
+[project]
+name = "demo"
+dependencies = ["Flask>=3.0", "requests"]
+
+[project.optional-dependencies]
+speedups = ["orjson>=3"]
+
+[tool.poetry.dependencies]
+flask = { version = "^3.0.2" }
+`), 0666))
+
+	pkgs, err := listSpecfile()
+	require.NoError(t, err)
+	require.Equal(t, map[api.PkgName]api.PkgSpec{
+		"flask":    "^3.0.2",
+		"requests": "",
+		"orjson":   ">=3",
+	}, pkgs)
+}
+
+func TestListSpecfileGroups(t *testing.T) {
+	dir := t.TempDir()
+	cwd, err := os.Getwd()
+	require.NoError(t, err)
+	require.NoError(t, os.Chdir(dir))
+	defer os.Chdir(cwd)
+
+	require.NoError(t, ioutil.WriteFile("pyproject.toml", []byte(`
+[tool.poetry.dependencies]
+python = "^3.10"
+flask = "^3.0"
+
+[tool.poetry.dev-dependencies]
+black = "^24.1"
+
+[tool.poetry.group.test.dependencies]
+pytest = "^8.0"
+flask = "^3.0"
+`), 0666))
+
+	pkgs, err := listSpecfile()
+	require.NoError(t, err)
+	require.Equal(t, map[api.PkgName]api.PkgSpec{
+		"flask":  "^3.0",
+		"black":  "^24.1",
+		"pytest": "^8.0",
+	}, pkgs)
+
+	groups, err := listSpecfileGroups()
+	require.NoError(t, err)
+	require.Equal(t, map[api.PkgName]string{
+		"black":  "dev",
+		"pytest": "test",
+	}, groups)
+}
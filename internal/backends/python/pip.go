@@ -0,0 +1,156 @@
+package python
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+
+	"github.com/replit/upm/internal/api"
+	"github.com/replit/upm/internal/util"
+)
+
+// pipLockfile is the requirements file that pip-compile generates
+// from requirements.txt, with every package pinned.
+const pipLockfile = "requirements.lock"
+
+// getPython3 returns either "python3" or the value of UPM_PYTHON3.
+func getPython3() string {
+	if python := os.Getenv("UPM_PYTHON3"); python != "" {
+		return python
+	}
+	return "python3"
+}
+
+// readTextFile returns the contents of the given file, such as a
+// requirements file.
+func readTextFile(filename string) string {
+	contentsB, err := ioutil.ReadFile(filename)
+	if err != nil {
+		util.Die("%s: %s", filename, err)
+	}
+	return string(contentsB)
+}
+
+// pipMakeBackend returns a language backend for Python 3 that uses
+// pip, with requirements.txt as the specfile. The lockfile is
+// generated by pip-compile and installed by pip-sync, both from
+// pip-tools, which are run as modules of the given Python so that
+// they work on the environment it belongs to.
+func pipMakeBackend(python string) api.LanguageBackend {
+	// pipTools returns the command that runs the given pip-tools
+	// command.
+	pipTools := func(command string, args ...string) []string {
+		return append([]string{python, "-m", "piptools", command}, args...)
+	}
+
+	// listSpecfile lists the packages in the specfile for guess,
+	// which doesn't guess them again.
+	listSpecfile := func() (map[api.PkgName]api.PkgSpec, error) {
+		if !util.Exists("requirements.txt") {
+			return nil, os.ErrNotExist
+		}
+		return listRequirements(readTextFile("requirements.txt")), nil
+	}
+
+	return api.LanguageBackend{
+		Name:                 "python-python3-pip",
+		Specfile:             "requirements.txt",
+		Lockfile:             pipLockfile,
+		LockfileComment:      "#",
+		OSVEcosystem:         "PyPI",
+		Registry:             indexRegistry,
+		FilenamePatterns:     []string{"*.py", "*.ipynb"},
+		Executables:          []string{python},
+		NormalizePackageName: normalizePackageName,
+		GetPackageDir: func() string {
+			return os.Getenv("VIRTUAL_ENV")
+		},
+		GetExecEnv: func() api.ExecEnv {
+			return pythonExecEnv(python, os.Getenv("VIRTUAL_ENV"))
+		},
+		Search:    search,
+		Info:      info,
+		Downloads: downloads,
+		Add: func(ctx context.Context, pkgs map[api.PkgName]api.PkgSpec, projectName string) {
+			contents := ""
+			if util.Exists("requirements.txt") {
+				contents = readTextFile("requirements.txt")
+			}
+			contents = addToRequirements(contents, pkgs)
+			util.ProgressMsg("write requirements.txt")
+			util.TryWriteAtomic("requirements.txt", []byte(contents))
+		},
+		// requirements.txt has no name, so it starts out empty.
+		InitSpecfile: func(ctx context.Context, projectName string) {
+			util.ProgressMsg("write requirements.txt")
+			util.TryWriteAtomic("requirements.txt", []byte{})
+		},
+		Remove: func(ctx context.Context, pkgs map[api.PkgName]bool) {
+			contents := removeFromRequirements(readTextFile("requirements.txt"), pkgs)
+			util.ProgressMsg("write requirements.txt")
+			util.TryWriteAtomic("requirements.txt", []byte(contents))
+		},
+		// pip-compile keeps the versions already pinned in the
+		// lockfile where it can.
+		Lock: func(ctx context.Context) {
+			util.RunCmd(ctx, pipTools(
+				"compile", "--quiet", "--output-file", pipLockfile, "requirements.txt",
+			))
+		},
+		Upgrade: func(ctx context.Context, pkgs map[api.PkgName]bool) {
+			cmd := pipTools("compile", "--quiet", "--output-file", pipLockfile)
+			for name := range pkgs {
+				cmd = append(cmd, "--upgrade-package", string(name))
+			}
+			util.RunCmd(ctx, append(cmd, "requirements.txt"))
+		},
+		// pip-sync also uninstalls whatever isn't in the
+		// lockfile, so it only makes sense in a virtualenv.
+		Install: func(ctx context.Context) {
+			if os.Getenv("VIRTUAL_ENV") == "" {
+				util.Die("python-python3-pip installs packages into the active virtualenv, but none is active")
+			}
+			util.RunCmd(ctx, pipTools("sync", pipLockfile))
+		},
+		ListSpecfile: func() map[api.PkgName]api.PkgSpec {
+			return listRequirements(readTextFile("requirements.txt"))
+		},
+		SpecSource:      specSource,
+		LegacySpecfiles: setuptoolsSpecfiles,
+		ListLegacySpecfile: func() map[api.PkgName]api.PkgSpec {
+			return listSetuptools(python)
+		},
+		CheckRuntime: func() (string, []api.PkgName) {
+			return checkPython("requirements.txt", listSpecfile)
+		},
+		// requirements.txt can't constrain the version of
+		// Python, so only packages are ever objected to.
+		UpgradeRuntime: func() {},
+		Diagnose: func() []api.Problem {
+			if os.Getenv("VIRTUAL_ENV") == "" {
+				return []api.Problem{{
+					Summary: "no virtualenv is active, so 'upm install' won't run",
+					Fix:     "create one with 'python3 -m venv .venv' and activate it",
+				}}
+			}
+			return diagnoseVirtualenv("")
+		},
+		ListLockfile: func() map[api.PkgName]api.PkgVersion {
+			return listPinnedRequirements(readTextFile(pipLockfile))
+		},
+		ListInstalled: func() map[api.PkgName]api.InstalledPkg {
+			return listInstalled(sitePackagesDirs(python, os.Getenv("VIRTUAL_ENV")), pyprojectName())
+		},
+		GuessRegexps:  pythonGuessRegexps,
+		GuessCacheKey: mapOverlayCacheKey,
+		Guess: func(ctx context.Context) (map[api.PkgName]bool, bool) {
+			return guess(ctx, python, listSpecfile)
+		},
+		GuessDetails: func(ctx context.Context) (map[api.PkgName]api.GuessedPkg, bool) {
+			return guessDetails(ctx, python, listSpecfile)
+		},
+	}
+}
+
+// UPM backend for Python 3 that uses pip and pip-tools.
+var Python3PipBackend = pipMakeBackend(getPython3())
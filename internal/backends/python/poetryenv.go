@@ -0,0 +1,100 @@
+package python
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/replit/upm/internal/util"
+)
+
+// poetryConfig returns the value of the given setting of Poetry, such
+// as "virtualenvs.path", or the empty string if it has none. Poetry
+// before 1.0 only knows the setting by the name with "settings." in
+// front, and prints it as JSON.
+func poetryConfig(ctx context.Context, poetry string, key string) string {
+	if !poetryAtLeast(ctx, poetry, "1.0") {
+		key = "settings." + key
+	}
+	outputB, code := util.GetCmdOutputAndExitCode(ctx, []string{poetry, "config", key})
+	if code != 0 {
+		return ""
+	}
+	output := strings.TrimSpace(string(outputB))
+	var value interface{}
+	if err := json.Unmarshal([]byte(output), &value); err == nil {
+		switch value := value.(type) {
+		case string:
+			return value
+		case nil:
+			return ""
+		}
+	}
+	if output == "null" {
+		return ""
+	}
+	return output
+}
+
+// poetryEnvPath returns the path of the virtualenv that Poetry uses
+// for the project, if it exists already, by asking Poetry. Unlike
+// 'poetry run', 'poetry env info' doesn't create the virtualenv if it
+// doesn't exist, but it is only in Poetry 1.0 and later, so the empty
+// string is returned if Poetry is older or it fails for any reason.
+func poetryEnvPath(poetry string) string {
+	if !poetryAtLeast(context.Background(), poetry, "1.0") {
+		return ""
+	}
+	outputB, code := util.GetCmdOutputAndExitCode(context.Background(), []string{poetry, "env", "info", "--path"})
+	if code != 0 {
+		return ""
+	}
+	return strings.TrimSpace(string(outputB))
+}
+
+// poetryUsesInProjectVenv returns true if Poetry puts the virtualenv of
+// the project in .venv, inside the project. It does if
+// virtualenvs.in-project is true, or if it isn't set and .venv exists
+// already.
+func poetryUsesInProjectVenv(poetry string) bool {
+	inProject := os.Getenv("POETRY_VIRTUALENVS_IN_PROJECT")
+	if inProject == "" {
+		inProject = poetryConfig(context.Background(), poetry, "virtualenvs.in-project")
+	}
+	switch inProject {
+	case "true", "1":
+		return true
+	case "false", "0":
+		return false
+	}
+	return util.Exists(".venv")
+}
+
+// poetryEnvNameRegexp matches the characters that Poetry replaces with
+// underscores in the names of virtualenvs.
+var poetryEnvNameRegexp = regexp.MustCompile("[ $`!*@\"\\\\\r\n\t]")
+
+// poetryEnvName returns the name that Poetry 1.0 and later gives to the
+// virtualenv of the project with the given name in the given
+// directory, which is the sanitized name followed by a hash of the
+// directory, so that projects with the same name don't share one.
+// Older versions just use the name.
+func poetryEnvName(poetry string, name string, dir string) string {
+	if !poetryAtLeast(context.Background(), poetry, "1.0") {
+		return name
+	}
+	name = poetryEnvNameRegexp.ReplaceAllString(strings.ToLower(name), "_")
+	if len(name) > 42 {
+		name = name[:42]
+	}
+	if real, err := filepath.EvalSymlinks(dir); err == nil {
+		dir = real
+	}
+	hash := sha256.Sum256([]byte(dir))
+	return name + "-" + base64.URLEncoding.EncodeToString(hash[:])[:8]
+}
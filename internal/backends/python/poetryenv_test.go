@@ -0,0 +1,18 @@
+package python
+
+import (
+	"testing"
+
+	"github.com/hashicorp/go-version"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPoetryEnvName(t *testing.T) {
+	poetryVersions["poetry-1.8"] = version.Must(version.NewVersion("1.8.3"))
+	poetryVersions["poetry-0.12"] = version.Must(version.NewVersion("0.12.17"))
+
+	// Checked against EnvManager.generate_env_name in Poetry.
+	require.Equal(t, "demo_app-6WcazSRI", poetryEnvName("poetry-1.8", "Demo App", "/tmp"))
+	require.Equal(t, "my-project-l_POPrYF", poetryEnvName("poetry-1.8", "my-project", "/root/module"))
+	require.Equal(t, "my-project", poetryEnvName("poetry-0.12", "my-project", "/root/module"))
+}
@@ -0,0 +1,109 @@
+package python
+
+import (
+	"context"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/go-version"
+
+	"github.com/replit/upm/internal/api"
+	"github.com/replit/upm/internal/util"
+)
+
+// poetrySpecOperators are the operators with which a spec can be
+// raised by raisePoetrySpec, longest first.
+var poetrySpecOperators = []string{"~=", ">=", "==", "^", "~"}
+
+// raisePoetrySpec returns the given spec with its version replaced by
+// the given one, keeping its operator, or false if it isn't a single
+// version after one of poetrySpecOperators or none, like "^2.0",
+// ">=1.4.2", or "3.1". A range with an upper bound, or with markers,
+// can't be raised without changing what it means.
+func raisePoetrySpec(spec api.PkgSpec, to api.PkgVersion) (api.PkgSpec, bool) {
+	rest := strings.TrimSpace(string(spec))
+	op := ""
+	for _, candidate := range poetrySpecOperators {
+		if strings.HasPrefix(rest, candidate) {
+			op = candidate
+			break
+		}
+	}
+	rest = strings.TrimSpace(rest[len(op):])
+	if rest == "" || strings.ContainsAny(rest, " ,;<>=!*[@") {
+		return "", false
+	}
+	if _, err := version.NewVersion(rest); err != nil {
+		return "", false
+	}
+	return api.PkgSpec(op + string(to)), true
+}
+
+// poetryUpdate implements Update for the Poetry backend. Poetry has no
+// command that raises specs, so for the newest versions that the specs
+// allow, the packages are upgraded within them first, and then added
+// again at the versions that were locked, while for the latest
+// versions, they are added again at @latest.
+func poetryUpdate(ctx context.Context, poetry string, python string, pkgs map[api.PkgName]bool, latest bool) {
+	usePoetrySource(ctx, poetry)
+	if !latest {
+		util.RunCmd(ctx, poetryUpgradeCmd(ctx, poetry, pkgs))
+	}
+	specs, err := listSpecfile()
+	if err != nil {
+		util.Die("%s", err.Error())
+	}
+	groups, err := listSpecfileGroups()
+	if err != nil {
+		util.Die("%s", err.Error())
+	}
+	locked := map[api.PkgName]api.PkgVersion{}
+	if !latest {
+		for name, v := range listLockfile() {
+			locked[normalizePackageName(name)] = v
+		}
+	}
+
+	reqs := map[string][]string{}
+	for name, spec := range specs {
+		if len(pkgs) > 0 && !pkgs[name] {
+			continue
+		}
+		if _, ok := raisePoetrySpec(spec, ""); !ok {
+			continue
+		}
+		req := string(name) + "@latest"
+		if !latest {
+			to := locked[normalizePackageName(name)]
+			raised, _ := raisePoetrySpec(spec, to)
+			if to == "" || raised == spec {
+				continue
+			}
+			req = string(name) + "@" + string(raised)
+		}
+		reqs[groups[name]] = append(reqs[groups[name]], req)
+	}
+	if len(reqs) == 0 {
+		util.Log("no specs can be raised")
+		return
+	}
+
+	keys := []string{}
+	for group := range reqs {
+		keys = append(keys, group)
+	}
+	sort.Strings(keys)
+	for _, group := range keys {
+		sort.Strings(reqs[group])
+		args := []string{}
+		if group != "" {
+			args = poetryGroupArgs(ctx, poetry, group)
+		}
+		if usePypackages() {
+			pypackagesAdd(ctx, poetry, python, append(args, reqs[group]...))
+			continue
+		}
+		cmd := append([]string{poetry, "add"}, args...)
+		util.RunCmd(ctx, append(cmd, reqs[group]...))
+	}
+}
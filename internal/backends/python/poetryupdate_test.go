@@ -0,0 +1,28 @@
+package python
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/replit/upm/internal/api"
+)
+
+func TestRaisePoetrySpec(t *testing.T) {
+	for spec, raised := range map[api.PkgSpec]api.PkgSpec{
+		"^2.0":    "^2.3.1",
+		">=1.4.2": ">=2.3.1",
+		"~=2.0":   "~=2.3.1",
+		"== 2.0":  "==2.3.1",
+		"2.0.4":   "2.3.1",
+	} {
+		got, ok := raisePoetrySpec(spec, "2.3.1")
+		require.True(t, ok, spec)
+		require.Equal(t, raised, got)
+	}
+
+	for _, spec := range []api.PkgSpec{"*", ">=2,<3", "^2.0; python_version < '3.9'", "@ git+https://example.com/x.git", ""} {
+		_, ok := raisePoetrySpec(spec, "2.3.1")
+		require.False(t, ok, spec)
+	}
+}
@@ -0,0 +1,126 @@
+package python
+
+import (
+	"context"
+	"regexp"
+	"strings"
+
+	"github.com/hashicorp/go-version"
+
+	"github.com/replit/upm/internal/api"
+	"github.com/replit/upm/internal/util"
+)
+
+// poetryVersionRegexp matches the version in the output of 'poetry
+// --version', which is "Poetry 0.12.17" before 1.0, "Poetry version
+// 1.1.13" before 1.2, and "Poetry (version 1.8.3)" since.
+var poetryVersionRegexp = regexp.MustCompile(`\d+(\.\d+)+(\S*[^\s)])?`)
+
+// parsePoetryVersion returns the version in the given output of
+// 'poetry --version', or nil if there isn't one.
+func parsePoetryVersion(output string) *version.Version {
+	v, err := version.NewVersion(poetryVersionRegexp.FindString(output))
+	if err != nil {
+		return nil
+	}
+	return v
+}
+
+// poetryVersions is a map from each Poetry executable to its
+// version, or nil if it couldn't be told, so that it is only asked
+// once.
+var poetryVersions = map[string]*version.Version{}
+
+// getPoetryVersion returns the version of the given Poetry
+// executable, or nil if it can't be told.
+func getPoetryVersion(ctx context.Context, poetry string) *version.Version {
+	if v, ok := poetryVersions[poetry]; ok {
+		return v
+	}
+	var v *version.Version
+	outputB, code := util.GetCmdOutputAndExitCode(ctx, []string{poetry, "--version"})
+	if code == 0 {
+		v = parsePoetryVersion(string(outputB))
+	}
+	poetryVersions[poetry] = v
+	return v
+}
+
+// poetryAtLeast returns true if the given Poetry executable is the
+// given version or later. A Poetry whose version can't be told is
+// taken to be the latest, since that is the one it most likely is.
+func poetryAtLeast(ctx context.Context, poetry string, minimum string) bool {
+	v := getPoetryVersion(ctx, poetry)
+	if v == nil {
+		return true
+	}
+	return !v.LessThan(version.Must(version.NewVersion(minimum)))
+}
+
+// requirePoetry terminates the process if the given Poetry executable
+// is older than the given version, which the given feature needs.
+func requirePoetry(ctx context.Context, poetry string, minimum string, feature string) {
+	if !poetryAtLeast(ctx, poetry, minimum) {
+		util.Die("%s needs Poetry %s or later, but %s is %s",
+			feature, minimum, poetry, getPoetryVersion(ctx, poetry))
+	}
+}
+
+// poetryLockCmd returns the command that updates poetry.lock to match
+// pyproject.toml without upgrading anything. Poetry 1.1 added
+// --no-update for this, and Poetry 2.0 made it the default and
+// removed the option.
+func poetryLockCmd(ctx context.Context, poetry string) []string {
+	if poetryAtLeast(ctx, poetry, "1.1") && !poetryAtLeast(ctx, poetry, "2.0") {
+		return []string{poetry, "lock", "--no-update"}
+	}
+	return []string{poetry, "lock"}
+}
+
+// poetryUpgradeCmd returns the command that upgrades the given
+// packages (or all of them) in poetry.lock. Before 1.0, 'poetry
+// update' can't leave the virtualenv alone.
+func poetryUpgradeCmd(ctx context.Context, poetry string, pkgs map[api.PkgName]bool) []string {
+	cmd := []string{poetry, "update"}
+	if poetryAtLeast(ctx, poetry, "1.0") {
+		cmd = append(cmd, "--lock")
+	}
+	for name := range pkgs {
+		cmd = append(cmd, string(name))
+	}
+	return cmd
+}
+
+// poetryExportCmd returns the command that exports poetry.lock to the
+// given file in the format of requirements.txt, with the main
+// dependencies and those of the given groups. Poetry 1.0 added the
+// command, although Poetry 2.0 only has it with poetry-plugin-export,
+// and before 1.2, it only has the dev-dependencies.
+func poetryExportCmd(ctx context.Context, poetry string, groups []string, file string) []string {
+	requirePoetry(ctx, poetry, "1.0", "exporting the lockfile")
+	cmd := []string{poetry, "export", "--format", "requirements.txt", "--output", file}
+	switch {
+	case len(groups) == 0:
+	case poetryAtLeast(ctx, poetry, "1.2"):
+		cmd = append(cmd, "--with", strings.Join(groups, ","))
+	case len(groups) == 1 && groups[0] == "dev":
+		cmd = append(cmd, "--dev")
+	default:
+		requirePoetry(ctx, poetry, "1.2", "exporting dependency groups other than dev")
+	}
+	return cmd
+}
+
+// poetryGroupArgs returns the arguments to 'poetry add' that put the
+// packages in the given dependency group. Before 1.2, Poetry only has
+// the dev-dependencies.
+func poetryGroupArgs(ctx context.Context, poetry string, group string) []string {
+	if poetryAtLeast(ctx, poetry, "1.2") {
+		return []string{"--group", group}
+	}
+	if group == "dev" {
+		return []string{"--dev"}
+	}
+	requirePoetry(ctx, poetry, "1.2", "dependency groups other than dev")
+	return nil
+}
@@ -0,0 +1,56 @@
+package python
+
+import (
+	"context"
+	"testing"
+
+	"github.com/hashicorp/go-version"
+	"github.com/stretchr/testify/require"
+
+	"github.com/replit/upm/internal/api"
+)
+
+func TestParsePoetryVersion(t *testing.T) {
+	for output, expected := range map[string]string{
+		"Poetry 0.12.17\n":           "0.12.17",
+		"Poetry version 1.1.13\n":    "1.1.13",
+		"Poetry (version 1.8.3)\n":   "1.8.3",
+		"Poetry (version 2.0.0b1)\n": "2.0.0b1",
+	} {
+		v := parsePoetryVersion(output)
+		require.NotNil(t, v, output)
+		require.Equal(t, expected, v.Original())
+	}
+	require.Nil(t, parsePoetryVersion("command not found"))
+}
+
+func TestPoetryCommands(t *testing.T) {
+	for poetry, v := range map[string]string{
+		"poetry-0.12": "0.12.17",
+		"poetry-1.1":  "1.1.13",
+		"poetry-1.8":  "1.8.3",
+		"poetry-2.1":  "2.1.1",
+	} {
+		poetryVersions[poetry] = version.Must(version.NewVersion(v))
+	}
+	pkgs := map[api.PkgName]bool{"flask": true}
+
+	require.Equal(t, []string{"poetry-0.12", "lock"}, poetryLockCmd(context.Background(), "poetry-0.12"))
+	require.Equal(t, []string{"poetry-1.1", "lock", "--no-update"}, poetryLockCmd(context.Background(), "poetry-1.1"))
+	require.Equal(t, []string{"poetry-1.8", "lock", "--no-update"}, poetryLockCmd(context.Background(), "poetry-1.8"))
+	require.Equal(t, []string{"poetry-2.1", "lock"}, poetryLockCmd(context.Background(), "poetry-2.1"))
+
+	require.Equal(t, []string{"poetry-0.12", "update", "flask"}, poetryUpgradeCmd(context.Background(), "poetry-0.12", pkgs))
+	require.Equal(t, []string{"poetry-2.1", "update", "--lock", "flask"}, poetryUpgradeCmd(context.Background(), "poetry-2.1", pkgs))
+
+	require.Equal(t, []string{"--dev"}, poetryGroupArgs(context.Background(), "poetry-1.1", "dev"))
+	require.Equal(t, []string{"--group", "dev"}, poetryGroupArgs(context.Background(), "poetry-1.8", "dev"))
+	require.Equal(t, []string{"--group", "docs"}, poetryGroupArgs(context.Background(), "poetry-2.1", "docs"))
+
+	require.Equal(t, []string{"poetry-1.1", "export", "--format", "requirements.txt", "--output", "prod.txt", "--dev"},
+		poetryExportCmd(context.Background(), "poetry-1.1", []string{"dev"}, "prod.txt"))
+	require.Equal(t, []string{"poetry-2.1", "export", "--format", "requirements.txt", "--output", "prod.txt"},
+		poetryExportCmd(context.Background(), "poetry-2.1", nil, "prod.txt"))
+	require.Equal(t, []string{"poetry-2.1", "export", "--format", "requirements.txt", "--output", "all.txt", "--with", "dev,docs"},
+		poetryExportCmd(context.Background(), "poetry-2.1", []string{"dev", "docs"}, "all.txt"))
+}
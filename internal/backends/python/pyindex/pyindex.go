@@ -0,0 +1,187 @@
+// Package pyindex talks to a Python package index: PyPI, or a private
+// index configured for the project. It is separate from the Python
+// backends so that the generator of their module map can use it
+// without depending on the map.
+package pyindex
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/replit/upm/internal/api"
+	"github.com/replit/upm/internal/project"
+	"github.com/replit/upm/internal/util"
+)
+
+// PyPIURL is the simple index of PyPI, which is used unless another
+// index is configured.
+const PyPIURL = "https://pypi.org/simple"
+
+// Index is a package index that packages are looked up in.
+type Index struct {
+	// The simple index, as given to pip's --index-url.
+	URL string
+	// The base URL of the JSON API, under which each package is
+	// at <name>/json.
+	APIURL   string
+	Username string
+	Password string
+}
+
+// Get returns the package index configured by the UPM_PYPI_*
+// environment variables, or else by the [python.index] table of
+// .upm/config.toml, or else PyPI.
+func Get() Index {
+	cfg := project.Read().Python.Index
+	setting := func(env string, value string) string {
+		if fromEnv := os.Getenv(env); fromEnv != "" {
+			return fromEnv
+		}
+		return value
+	}
+
+	index := Index{
+		URL:      strings.TrimSuffix(setting("UPM_PYPI_URL", cfg.URL), "/"),
+		APIURL:   strings.TrimSuffix(setting("UPM_PYPI_API_URL", cfg.APIURL), "/"),
+		Username: setting("UPM_PYPI_USERNAME", cfg.Username),
+		Password: setting("UPM_PYPI_PASSWORD", cfg.Password),
+	}
+	if token := setting("UPM_PYPI_TOKEN", cfg.Token); token != "" {
+		if index.Username == "" {
+			index.Username = "__token__"
+		}
+		index.Password = token
+	}
+	if index.URL == "" {
+		index.URL = PyPIURL
+	}
+	if index.APIURL == "" {
+		// PyPI, Nexus, and pypiserver all serve the JSON API
+		// beside the simple index.
+		if !strings.HasSuffix(index.URL, "/simple") {
+			util.Die("can't tell where the JSON API of %s is; set UPM_PYPI_API_URL "+
+				"or api-url in [python.index] of .upm/config.toml", index.URL)
+		}
+		index.APIURL = strings.TrimSuffix(index.URL, "/simple") + "/pypi"
+	}
+	return index
+}
+
+// IsPyPI returns true if the index is PyPI itself.
+func (index Index) IsPyPI() bool {
+	return index.URL == PyPIURL
+}
+
+// InfoURL returns the URL of the JSON API for the given package.
+func (index Index) InfoURL(name api.PkgName) string {
+	return util.JoinURL(index.APIURL, string(name), "json")
+}
+
+// Fetch fetches the given URL from the index, with the credentials of
+// the index, and returns the response body, or nil if there is no such
+// resource. If there is an error, Fetch terminates the process.
+func (index Index) Fetch(url string, accept string) []byte {
+	body, err := index.TryFetch(url, accept)
+	if err != nil {
+		util.Die("%s", err)
+	}
+	return body
+}
+
+// TryFetch is like Fetch, but returns an error instead of terminating
+// the process, so that the caller can fall back to what it has cached
+// or go on to the next package. Bad credentials still terminate the
+// process, since nothing can be done without them. The credentials are
+// only sent to the hosts of the index itself, and not, say, to the
+// host that PyPI serves its files from.
+func (index Index) TryFetch(url string, accept string) ([]byte, error) {
+	return index.TryFetchContext(context.Background(), url, accept)
+}
+
+// TryFetchContext is like TryFetch, but gives up when the given
+// context is done.
+func (index Index) TryFetchContext(ctx context.Context, url string, accept string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		util.Die("%s: %s", url, err)
+	}
+	if accept != "" {
+		req.Header.Set("Accept", accept)
+	}
+	if (index.Username != "" || index.Password != "") && index.ownsHost(req.URL.Host) {
+		req.SetBasicAuth(index.Username, index.Password)
+	}
+
+	res, err := util.HTTPClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("HTTP Request failed with error: %s", err)
+	}
+	defer res.Body.Close()
+
+	switch res.StatusCode {
+	case 200:
+		break
+	case 404:
+		return nil, nil
+	case 401, 403:
+		util.Die("%s: HTTP status %d; check the credentials of the package index",
+			index.URL, res.StatusCode)
+	default:
+		return nil, fmt.Errorf("Received status code: %d", res.StatusCode)
+	}
+
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, fmt.Errorf("Res body read failed with error: %s", err)
+	}
+	return body, nil
+}
+
+// ownsHost returns true if the given host serves the simple index or
+// the JSON API of the index.
+func (index Index) ownsHost(host string) bool {
+	for _, base := range []string{index.URL, index.APIURL} {
+		if u, err := url.Parse(base); err == nil && u.Host == host {
+			return true
+		}
+	}
+	return false
+}
+
+// simpleProjectRegexp matches a link to a project in the HTML form of
+// a simple index.
+var simpleProjectRegexp = regexp.MustCompile(`<a[^>]*>\s*([^<\s]+)\s*</a>`)
+
+// ListProjects returns the name of every project in the index, using
+// the JSON form of the simple index (PEP 691) if the index supports it
+// and the HTML form (PEP 503) otherwise.
+func (index Index) ListProjects() []api.PkgName {
+	body := index.Fetch(index.URL+"/", "application/vnd.pypi.simple.v1+json, text/html;q=0.1")
+	if body == nil {
+		util.Die("%s: no such index", index.URL)
+	}
+
+	var output struct {
+		Projects []struct {
+			Name string `json:"name"`
+		} `json:"projects"`
+	}
+	names := []api.PkgName{}
+	if err := json.Unmarshal(body, &output); err == nil {
+		for _, project := range output.Projects {
+			names = append(names, api.PkgName(project.Name))
+		}
+		return names
+	}
+	for _, m := range simpleProjectRegexp.FindAllStringSubmatch(string(body), -1) {
+		names = append(names, api.PkgName(m[1]))
+	}
+	return names
+}
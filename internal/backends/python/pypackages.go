@@ -0,0 +1,90 @@
+package python
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/replit/upm/internal/api"
+	"github.com/replit/upm/internal/util"
+)
+
+// pypackagesDir is the name of the project-local package directory
+// described by PEP 582.
+const pypackagesDir = "__pypackages__"
+
+// usePypackages returns true if packages should be installed into
+// __pypackages__ instead of a virtualenv. This is the case if the
+// project already has such a directory (e.g. because it was set up
+// with pdm), or if UPM_PYTHON_PYPACKAGES is set to a non-empty value.
+func usePypackages() bool {
+	return os.Getenv("UPM_PYTHON_PYPACKAGES") != "" || util.Exists(pypackagesDir)
+}
+
+// getPythonVersion returns the major and minor version of the given
+// Python executable, e.g. "3.8".
+func getPythonVersion(ctx context.Context, python string) string {
+	return strings.TrimSpace(string(util.GetCmdOutput(ctx, []string{
+		python, "-c",
+		`import sys; print(".".join(map(str, sys.version_info[:2])))`,
+	})))
+}
+
+// pypackagesLibDir returns the directory inside __pypackages__ that
+// Python will import packages from, as laid out by PEP 582.
+func pypackagesLibDir(ctx context.Context, python string) string {
+	return filepath.Join(pypackagesDir, getPythonVersion(ctx, python), "lib")
+}
+
+// installPypackages installs every package in poetry.lock into
+// __pypackages__ using pip. Poetry has already resolved the full
+// dependency tree, so pip is told not to go looking for more. If
+// clean is true, the existing packages are deleted first, so that
+// ones which have been removed from the lockfile go away too (pip
+// has no way to uninstall from a --target directory).
+func installPypackages(ctx context.Context, python string, clean bool) {
+	lib := pypackagesLibDir(ctx, python)
+	if clean {
+		util.ProgressMsg("remove " + lib)
+		if err := os.RemoveAll(lib); err != nil {
+			util.Die("%s: %s", lib, err)
+		}
+	}
+
+	pkgs := listLockfile()
+	if len(pkgs) == 0 {
+		return
+	}
+
+	cmd := []string{
+		python, "-m", "pip", "install",
+		"--no-deps", "--upgrade", "--target", lib,
+	}
+	for name, version := range pkgs {
+		cmd = append(cmd, string(name)+"=="+string(version))
+	}
+	util.RunCmd(ctx, cmd)
+}
+
+// pypackagesAdd implements Add for the __pypackages__ install mode.
+// Poetry only updates pyproject.toml and poetry.lock, and then the
+// packages are installed with pip. Poetry can only leave the
+// virtualenv alone since 1.1.
+func pypackagesAdd(ctx context.Context, poetry string, python string, pkgs []string) {
+	requirePoetry(ctx, poetry, "1.1", "installing into "+pypackagesDir)
+	util.RunCmd(ctx, append([]string{poetry, "add", "--lock"}, pkgs...))
+	installPypackages(ctx, python, false)
+}
+
+// pypackagesRemove implements Remove for the __pypackages__ install
+// mode, analogously to pypackagesAdd.
+func pypackagesRemove(ctx context.Context, poetry string, python string, pkgs map[api.PkgName]bool) {
+	requirePoetry(ctx, poetry, "1.1", "installing into "+pypackagesDir)
+	cmd := []string{poetry, "remove", "--lock"}
+	for name := range pkgs {
+		cmd = append(cmd, string(name))
+	}
+	util.RunCmd(ctx, cmd)
+	installPypackages(ctx, python, true)
+}
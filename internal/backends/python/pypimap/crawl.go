@@ -0,0 +1,509 @@
+package pypimap
+
+import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/replit/upm/internal/api"
+	"github.com/replit/upm/internal/backends/python/pyindex"
+	"github.com/replit/upm/internal/util"
+)
+
+// progressInterval is how often Crawl says how far it has got.
+const progressInterval = 2 * time.Second
+
+// crawlResult is what a worker of Crawl fetched for a package: the
+// entry, if ok, and the cached entry of the package, if isCached.
+type crawlResult struct {
+	entry    Entry
+	ok       bool
+	cached   Entry
+	isCached bool
+}
+
+// progress says how far a crawl has got, every progressInterval and
+// once it is done, with an estimate of how long the rest will take at
+// the rate of the crawl so far.
+type progress struct {
+	// How many packages there are, and how many are done, counting
+	// those that were done before the crawl started.
+	total, done int
+	// How many were done before the crawl started, which don't
+	// count toward its rate.
+	skipped int
+	start   time.Time
+	last    time.Time
+}
+
+// newProgress returns the progress of a crawl of total packages that
+// starts at the given time with skipped of them already done.
+func newProgress(total int, skipped int, start time.Time) *progress {
+	return &progress{total: total, done: skipped, skipped: skipped, start: start, last: start}
+}
+
+// add counts one more package as done at the given time, and says how
+// far the crawl has got, with the given details, if it is time to.
+func (p *progress) add(details string, now time.Time) {
+	p.done++
+	if now.Sub(p.last) >= progressInterval || p.done == p.total {
+		util.Log(p.format(now) + " (" + details + ")")
+		p.last = now
+	}
+}
+
+// format returns how far the crawl has got at the given time, such as
+// "1200/5000 packages (24.0%), ETA 3m10s".
+func (p *progress) format(now time.Time) string {
+	percent := 100.0
+	if p.total > 0 {
+		percent = 100 * float64(p.done) / float64(p.total)
+	}
+	eta := "unknown"
+	if run := p.done - p.skipped; run > 0 {
+		left := time.Duration(float64(now.Sub(p.start)) / float64(run) * float64(p.total-p.done))
+		eta = left.Round(time.Second).String()
+	}
+	return fmt.Sprintf("%d/%d packages (%.1f%%), ETA %s", p.done, p.total, percent, eta)
+}
+
+// pypiFile is a file of a release, as listed by the JSON API.
+type pypiFile struct {
+	Filename    string `json:"filename"`
+	PackageType string `json:"packagetype"`
+	URL         string `json:"url"`
+	Yanked      bool   `json:"yanked"`
+}
+
+// pypiRelease is the part of the response of the JSON API for a
+// package that says what its latest release is.
+type pypiRelease struct {
+	Info struct {
+		Name    string `json:"name"`
+		Version string `json:"version"`
+	} `json:"info"`
+	URLs []pypiFile `json:"urls"`
+}
+
+// Crawl fetches the modules that every package in the given index
+// provides, from the wheel of its latest release, or from its sdist if
+// it has no wheel (see sdistModules), and writes an Entry for each to
+// the file out, one per line.
+//
+// The entries already in out are a cache: a package whose latest
+// release is the one its entry was read from isn't downloaded again,
+// so a crawl after the first only downloads the packages that have
+// changed. restart ignores the cache. The download counts of the
+// cached entries are kept, and those of new packages are left at zero,
+// since the index doesn't know them.
+//
+// The entries are written to out + ".partial" as soon as they are
+// fetched, and it replaces out when the crawl is done, so an
+// interrupted crawl leaves out as it was. Running it again with resume
+// picks up where it left off, without looking at the packages that are
+// already in the partial file again; without resume, they are looked
+// at again, but aren't downloaded again unless they have changed.
+// Packages with neither a wheel nor an sdist are written with no
+// modules, so that they aren't downloaded again either.
+//
+// Jobs packages are fetched at once, as paced by util.SetRateLimit,
+// so the entries are written in the order they are fetched in rather
+// than that of the index. With verbose, what was found for each
+// package is said as well as how far the crawl has got.
+func Crawl(index pyindex.Index, out string, resume bool, restart bool, verbose bool) {
+	if resume && restart {
+		util.Die("--resume cannot be combined with --restart")
+	}
+	partial := out + ".partial"
+	cache := map[api.PkgName]Entry{}
+	if !restart {
+		cache = readEntries(out, false)
+	}
+	done := map[api.PkgName]bool{}
+	flags := os.O_CREATE | os.O_WRONLY | os.O_APPEND
+	if resume {
+		for name := range readEntries(partial, true) {
+			done[name] = true
+		}
+	} else {
+		if !restart {
+			// What an interrupted crawl fetched is as good
+			// as what the last complete one did.
+			for name, entry := range readEntries(partial, false) {
+				cache[name] = entry
+			}
+		}
+		flags |= os.O_TRUNC
+	}
+	file, err := os.OpenFile(partial, flags, 0666)
+	if err != nil {
+		util.Die("%s", err)
+	}
+	defer file.Close()
+
+	util.ProgressMsg("list the packages in " + index.URL)
+	projects := index.ListProjects()
+	if len(done) > 0 {
+		util.Log(fmt.Sprintf("resuming: %d packages are already in %s", len(done), partial))
+	} else if resume {
+		util.Log(fmt.Sprintf("nothing to resume in %s, starting over", partial))
+	}
+
+	todo := []api.PkgName{}
+	for _, name := range projects {
+		if !done[normalize(name)] {
+			done[normalize(name)] = true
+			todo = append(todo, name)
+		}
+	}
+
+	// The packages are fetched by Jobs workers, whose requests
+	// are paced by util.HTTPClient, and written as they come in.
+	next := make(chan api.PkgName)
+	results := make(chan crawlResult)
+	var wg sync.WaitGroup
+	for i := 0; i < util.Jobs() && i < len(todo); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for name := range next {
+				cached, isCached := cache[normalize(name)]
+				entry, ok := fetchEntry(index, name, cached)
+				results <- crawlResult{entry, ok, cached, isCached}
+			}
+		}()
+	}
+	go func() {
+		for _, name := range todo {
+			next <- name
+		}
+		close(next)
+		wg.Wait()
+		close(results)
+	}()
+
+	encoder := json.NewEncoder(file)
+	p := newProgress(len(projects), len(projects)-len(todo), time.Now())
+	fetched, unchanged := 0, 0
+	for r := range results {
+		entry, ok := r.entry, r.ok
+		switch {
+		case ok && r.isCached && entry.Version != "" && entry.Version == r.cached.Version:
+			unchanged++
+			if verbose {
+				util.Log(fmt.Sprintf("%s %s: unchanged", entry.Pkg, entry.Version))
+			}
+		case ok:
+			fetched++
+			if verbose {
+				found := fmt.Sprintf("%d modules from the %s", len(entry.Mods), entry.Source)
+				if entry.Source == "" {
+					found = "no wheel or sdist"
+				}
+				util.Log(fmt.Sprintf("%s %s: %s", entry.Pkg, entry.Version, found))
+			}
+		case r.isCached:
+			// It couldn't be fetched this time, but it was
+			// before.
+			entry, ok = r.cached, true
+		}
+		if ok {
+			if err := encoder.Encode(entry); err != nil {
+				util.Die("%s: %s", partial, err)
+			}
+		}
+
+		p.add(fmt.Sprintf("%d fetched and %d unchanged this run", fetched, unchanged), time.Now())
+	}
+
+	if err := file.Close(); err != nil {
+		util.Die("%s", err)
+	}
+	if err := os.Rename(partial, out); err != nil {
+		util.Die("%s", err)
+	}
+}
+
+// readEntries returns the entries in the given file, left by an
+// earlier crawl, by the normalized names of their packages. The file
+// need not exist. A line that was cut off when a crawl was interrupted
+// is ignored, and with truncate, removed, so the file can be appended
+// to.
+func readEntries(filename string, truncate bool) map[api.PkgName]Entry {
+	entries := map[api.PkgName]Entry{}
+	file, err := os.Open(filename)
+	if os.IsNotExist(err) {
+		return entries
+	} else if err != nil {
+		util.Die("%s", err)
+	}
+	defer file.Close()
+
+	reader := bufio.NewReader(file)
+	var valid int64
+	for {
+		line, err := reader.ReadBytes('\n')
+		var entry Entry
+		if err != nil || json.Unmarshal(line, &entry) != nil {
+			if err != nil && err != io.EOF {
+				util.Die("%s: %s", filename, err)
+			}
+			break
+		}
+		entries[normalize(api.PkgName(entry.Pkg))] = entry
+		valid += int64(len(line))
+	}
+	if truncate {
+		if err := os.Truncate(filename, valid); err != nil {
+			util.Die("%s", err)
+		}
+	}
+	return entries
+}
+
+// normalize returns the normalized form of the given package name, as
+// in PEP 503.
+func normalize(name api.PkgName) api.PkgName {
+	return api.PkgName(strings.ToLower(strings.NewReplacer("_", "-", ".", "-").Replace(string(name))))
+}
+
+// fetchEntry fetches the modules of the latest release of the given
+// package, unless it is the release of the given cached entry, whose
+// modules are then used. The download count is the cached one, if
+// any. It returns false if the package can't be fetched, which is only
+// reported, so that one broken package doesn't stop the crawl.
+func fetchEntry(index pyindex.Index, name api.PkgName, cached Entry) (Entry, bool) {
+	body, err := index.TryFetch(index.InfoURL(name), "")
+	if err != nil {
+		util.Log(fmt.Sprintf("warning: %s: %s", name, err))
+		return Entry{}, false
+	}
+	if body == nil {
+		// The index lists projects that have no releases.
+		return Entry{}, false
+	}
+	var release pypiRelease
+	if err := json.Unmarshal(body, &release); err != nil {
+		util.Log(fmt.Sprintf("warning: %s: %s", name, err))
+		return Entry{}, false
+	}
+	if release.Info.Name != "" {
+		name = api.PkgName(release.Info.Name)
+	}
+
+	entry := Entry{
+		Pkg:       string(name),
+		Mods:      []string{},
+		Downloads: cached.Downloads,
+		Version:   release.Info.Version,
+	}
+	// An entry without modules or a source may be from before
+	// sdists were read, so it is only reused if the release has
+	// nothing to read anyway.
+	if entry.Version != "" && entry.Version == cached.Version &&
+		(len(cached.Mods) > 0 || cached.Source != "") {
+		entry.Mods = cached.Mods
+		entry.Source = cached.Source
+		return entry, true
+	}
+	// Many packages only publish an sdist, which is read if there
+	// is no wheel.
+	file := pickWheel(release.URLs)
+	entry.Source = "wheel"
+	modules := wheelModules
+	if file == nil {
+		file = pickSdist(release.URLs)
+		entry.Source = "sdist"
+		modules = func(contents []byte) ([]string, error) {
+			return sdistModules(file.Filename, contents)
+		}
+	}
+	if file == nil {
+		entry.Source = ""
+		return entry, true
+	}
+	contents, err := index.TryFetch(file.URL, "")
+	if err == nil && contents == nil {
+		err = fmt.Errorf("%s: not found", file.URL)
+	}
+	if err != nil {
+		util.Log(fmt.Sprintf("warning: %s: %s", name, err))
+		return Entry{}, false
+	}
+	mods, err := modules(contents)
+	if err != nil {
+		util.Log(fmt.Sprintf("warning: %s: %s: %s", name, file.Filename, err))
+		return entry, true
+	}
+	entry.Mods = mods
+	return entry, true
+}
+
+// pickWheel returns the wheel to read the modules of a release from,
+// or nil if it has none. A pure-Python wheel is best, since it is the
+// same everywhere and usually the smallest.
+func pickWheel(files []pypiFile) *pypiFile {
+	var best *pypiFile
+	for i := range files {
+		file := &files[i]
+		if file.PackageType != "bdist_wheel" || file.Yanked {
+			continue
+		}
+		if strings.HasSuffix(file.Filename, "-none-any.whl") {
+			return file
+		}
+		if best == nil {
+			best = file
+		}
+	}
+	return best
+}
+
+// wheelModules returns the sorted top-level modules in the given wheel:
+// those listed in its top_level.txt if it has one, or else those that
+// its files are installed as. A namespace package, which is a
+// directory without an __init__.py such as the google of
+// google-cloud-storage, is split among packages, so it is looked into,
+// and the packages in it are returned by their dotted names, such as
+// google.cloud.storage.
+func wheelModules(contents []byte) ([]string, error) {
+	r, err := zip.NewReader(bytes.NewReader(contents), int64(len(contents)))
+	if err != nil {
+		return nil, err
+	}
+
+	// The paths of the modules in the wheel, split at the slashes,
+	// and the directories that are regular packages.
+	var paths [][]string
+	packages := map[string]bool{}
+	var topLevel map[string]bool
+	for _, file := range r.File {
+		parts := strings.Split(file.Name, "/")
+		switch {
+		case strings.HasSuffix(parts[0], ".dist-info"):
+			if len(parts) == 2 && parts[1] == "top_level.txt" {
+				if topLevel, err = readTopLevel(file); err != nil {
+					return nil, err
+				}
+			}
+			continue
+		case strings.HasSuffix(parts[0], ".data"):
+			// Only these are installed with the modules.
+			if len(parts) < 3 || parts[1] != "purelib" && parts[1] != "platlib" {
+				continue
+			}
+			parts = parts[2:]
+		}
+
+		// A module is a .py file or an extension module, such as
+		// _cffi_backend.cpython-311-x86_64-linux-gnu.so.
+		base := parts[len(parts)-1]
+		if !strings.HasSuffix(base, ".py") && !strings.Contains(base, ".so") &&
+			!strings.HasSuffix(base, ".pyd") {
+			continue
+		}
+		if strings.SplitN(base, ".", 2)[0] == "__init__" {
+			packages[path.Join(parts[:len(parts)-1]...)] = true
+		}
+		paths = append(paths, parts)
+	}
+
+	return collectModules(paths, packages, topLevel), nil
+}
+
+// collectModules returns the sorted top-level modules that the files
+// with the given paths, split at the slashes, are installed as, where
+// packages holds the directories that are regular packages. If
+// topLevel isn't nil, only its modules are returned, and each of them
+// is even if no file provides it.
+func collectModules(paths [][]string, packages map[string]bool, topLevel map[string]bool) []string {
+	found := map[string]bool{}
+	for _, parts := range paths {
+		mod := moduleName(parts, packages)
+		if mod == "" {
+			continue
+		}
+		// With a top_level.txt, only its modules.
+		if topLevel != nil && !topLevel[strings.SplitN(mod, ".", 2)[0]] {
+			continue
+		}
+		found[mod] = true
+	}
+	// A module in top_level.txt may have no files that UPM
+	// recognizes, as for a package of data files.
+	for mod := range topLevel {
+		provided := false
+		for other := range found {
+			if strings.SplitN(other, ".", 2)[0] == mod {
+				provided = true
+				break
+			}
+		}
+		if !provided {
+			found[mod] = true
+		}
+	}
+
+	mods := []string{}
+	for mod := range found {
+		mods = append(mods, mod)
+	}
+	sort.Strings(mods)
+	return mods
+}
+
+// moduleName returns the name of the module that the file with the
+// given path, split at the slashes, is part of: the outermost regular
+// package that it is in, or the file itself if it is only in namespace
+// packages. packages holds the paths of the regular packages.
+func moduleName(parts []string, packages map[string]bool) string {
+	for i := 1; i < len(parts); i++ {
+		if packages[path.Join(parts[:i]...)] {
+			return strings.Join(parts[:i], ".")
+		}
+	}
+	// A single-file module, such as six.py.
+	name := strings.SplitN(parts[len(parts)-1], ".", 2)[0]
+	if name == "" || name == "__init__" {
+		return ""
+	}
+	return strings.Join(append(append([]string{}, parts[:len(parts)-1]...), name), ".")
+}
+
+// readTopLevel returns the modules listed in the given top_level.txt
+// of a wheel, one per line.
+func readTopLevel(file *zip.File) (map[string]bool, error) {
+	f, err := file.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	contents, err := ioutil.ReadAll(f)
+	if err != nil {
+		return nil, err
+	}
+	return parseTopLevel(contents), nil
+}
+
+// parseTopLevel returns the modules listed in the given contents of a
+// top_level.txt, one per line.
+func parseTopLevel(contents []byte) map[string]bool {
+	mods := map[string]bool{}
+	for _, line := range strings.Split(string(contents), "\n") {
+		// A nested module is written with slashes.
+		if mod := strings.SplitN(strings.TrimSpace(line), "/", 2)[0]; mod != "" {
+			mods[mod] = true
+		}
+	}
+	return mods
+}
@@ -0,0 +1,163 @@
+package pypimap
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/replit/upm/internal/backends/python/pyindex"
+)
+
+func makeWheel(t *testing.T, files map[string]string) []byte {
+	var buf bytes.Buffer
+	w := zip.NewWriter(&buf)
+	for name, contents := range files {
+		f, err := w.Create(name)
+		require.NoError(t, err)
+		_, err = f.Write([]byte(contents))
+		require.NoError(t, err)
+	}
+	require.NoError(t, w.Close())
+	return buf.Bytes()
+}
+
+func TestWheelModules(t *testing.T) {
+	mods, err := wheelModules(makeWheel(t, map[string]string{
+		"six.py":             "",
+		"acme/__init__.py":   "",
+		"acme/data/logo.png": "",
+		"_speedups.cpython-311-x86_64-linux-gnu.so": "",
+		"acme-1.0.dist-info/METADATA":               "",
+		"acme-1.0.data/purelib/extra/__init__.py":   "",
+		"acme-1.0.data/scripts/acme":                "",
+	}))
+	require.NoError(t, err)
+	require.Equal(t, []string{"_speedups", "acme", "extra", "six"}, mods)
+
+	// Namespace packages are looked into.
+	mods, err = wheelModules(makeWheel(t, map[string]string{
+		"google/protobuf/__init__.py":          "",
+		"protobuf-4.0.dist-info/top_level.txt": "google\ngoogle/protobuf\n",
+	}))
+	require.NoError(t, err)
+	require.Equal(t, []string{"google.protobuf"}, mods)
+
+	mods, err = wheelModules(makeWheel(t, map[string]string{
+		"google/cloud/storage/__init__.py":          "",
+		"google/cloud/storage/blob.py":              "",
+		"google/cloud/_storage_helpers.py":          "",
+		"google_cloud_storage-2.0.dist-info/RECORD": "",
+	}))
+	require.NoError(t, err)
+	require.Equal(t, []string{"google.cloud._storage_helpers", "google.cloud.storage"}, mods)
+}
+
+func TestCrawlResumes(t *testing.T) {
+	wheel := makeWheel(t, map[string]string{"acme/__init__.py": ""})
+	// The packages are fetched at once, in no particular order.
+	var mutex sync.Mutex
+	fetched := []string{}
+	sortedFetched := func() []string {
+		mutex.Lock()
+		defer mutex.Unlock()
+		sort.Strings(fetched)
+		return fetched
+	}
+	sortedLines := func(contents []byte) []string {
+		lines := strings.Split(strings.TrimSuffix(string(contents), "\n"), "\n")
+		sort.Strings(lines)
+		return lines
+	}
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		switch r.URL.Path {
+		case "/simple/":
+			fmt.Fprint(w, `{"projects": [{"name": "Acme_Utils"}, {"name": "old"}, {"name": "empty"}]}`)
+		case "/pypi/Acme_Utils/json":
+			fetched = append(fetched, "Acme_Utils")
+			fmt.Fprintf(w, `{"info": {"name": "acme-utils", "version": "1.0"}, "urls": [
+				{"filename": "acme_utils-1.0.tar.gz", "packagetype": "sdist", "url": "%[1]s/sdist"},
+				{"filename": "acme_utils-1.0-cp311-cp311-linux_x86_64.whl", "packagetype": "bdist_wheel", "url": "%[1]s/binary"},
+				{"filename": "acme_utils-1.0-py3-none-any.whl", "packagetype": "bdist_wheel", "url": "%[1]s/wheel"}]}`,
+				server.URL)
+		case "/pypi/old/json":
+			fetched = append(fetched, "old")
+			fmt.Fprint(w, `{"info": {"name": "old", "version": "2.0"}, "urls": []}`)
+		case "/pypi/empty/json":
+			fetched = append(fetched, "empty")
+			fmt.Fprint(w, `{"info": {"name": "empty", "version": "0.1"}, "urls": []}`)
+		case "/wheel":
+			fetched = append(fetched, "wheel")
+			w.Write(wheel)
+		default:
+			t.Errorf("unexpected request for %s", r.URL.Path)
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	out := filepath.Join(t.TempDir(), "pypi.json")
+	// An earlier crawl that was interrupted while writing.
+	require.NoError(t, ioutil.WriteFile(out+".partial",
+		[]byte(`{"p":"old","m":["old"],"d":0,"v":"2.0"}`+"\n"+`{"p":"emp`), 0666))
+
+	index := pyindex.Index{URL: server.URL + "/simple", APIURL: server.URL + "/pypi"}
+	Crawl(index, out, true, false, false)
+	require.Equal(t, []string{"Acme_Utils", "empty", "wheel"}, sortedFetched())
+
+	contents, err := ioutil.ReadFile(out)
+	require.NoError(t, err)
+	// The resumed entry comes first.
+	require.True(t, strings.HasPrefix(string(contents), `{"p":"old","m":["old"],"d":0,"v":"2.0"}`+"\n"))
+	require.Equal(t, []string{
+		`{"p":"acme-utils","m":["acme"],"d":0,"v":"1.0","s":"wheel"}`,
+		`{"p":"empty","m":[],"d":0,"v":"0.1"}`,
+		`{"p":"old","m":["old"],"d":0,"v":"2.0"}`,
+	}, sortedLines(contents))
+	require.NoFileExists(t, out+".partial")
+
+	// Nothing has changed, so no wheel is downloaded again, and the
+	// download counts are kept.
+	contents = []byte(strings.Replace(string(contents), `"m":["acme"],"d":0`, `"m":["acme"],"d":7`, 1))
+	require.NoError(t, ioutil.WriteFile(out, contents, 0666))
+	fetched = []string{}
+	Crawl(index, out, false, false, false)
+	require.Equal(t, []string{"Acme_Utils", "empty", "old"}, sortedFetched())
+	after, err := ioutil.ReadFile(out)
+	require.NoError(t, err)
+	require.Equal(t, []string{
+		`{"p":"acme-utils","m":["acme"],"d":7,"v":"1.0","s":"wheel"}`,
+		`{"p":"empty","m":[],"d":0,"v":"0.1"}`,
+		`{"p":"old","m":["old"],"d":0,"v":"2.0"}`,
+	}, sortedLines(after))
+
+	// Unless the cache is ignored.
+	fetched = []string{}
+	Crawl(index, out, false, true, false)
+	require.Equal(t, []string{"Acme_Utils", "empty", "old", "wheel"}, sortedFetched())
+}
+
+func TestProgress(t *testing.T) {
+	start := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	// 100 of 1000 were done by an earlier run.
+	p := newProgress(1000, 100, start)
+	require.Equal(t, "100/1000 packages (10.0%), ETA unknown", p.format(start))
+	for i := 0; i < 300; i++ {
+		p.add("", start.Add(time.Minute))
+	}
+	// 300 in a minute leaves 600 for two more.
+	require.Equal(t, "400/1000 packages (40.0%), ETA 2m0s", p.format(start.Add(time.Minute)))
+}
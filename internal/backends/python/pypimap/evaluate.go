@@ -0,0 +1,186 @@
+package pypimap
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/replit/upm/internal/api"
+	"github.com/replit/upm/internal/util"
+)
+
+// Pair is a module and the package that an import of it is known to
+// want, from a corpus that the guesses of the map are evaluated
+// against.
+type Pair struct {
+	Module string
+	// The package, or empty if nothing should be guessed for the
+	// module, as for one of the standard library.
+	Pkg string
+}
+
+// ReadCorpus reads the pairs in the given file. Each line is a module
+// and a package, separated by whitespace, with - for no package; blank
+// lines and those that start with # are skipped. If there is an error,
+// it terminates the process.
+func ReadCorpus(filename string) []Pair {
+	file, err := os.Open(filename)
+	if err != nil {
+		util.Die("%s", err)
+	}
+	defer file.Close()
+
+	pairs := []Pair{}
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		fields := strings.Fields(text)
+		if len(fields) != 2 {
+			util.Die("%s:%d: expected a module and a package", filename, line)
+		}
+		pair := Pair{Module: fields[0], Pkg: fields[1]}
+		if pair.Pkg == "-" {
+			pair.Pkg = ""
+		}
+		pairs = append(pairs, pair)
+	}
+	if err := scanner.Err(); err != nil {
+		util.Die("%s: %s", filename, err)
+	}
+	return pairs
+}
+
+// GuessFunc returns the package that is guessed for the given module,
+// and how likely it is to be right, or false if none is.
+type GuessFunc func(mod string) (string, float64, bool)
+
+// TableGuess returns the GuessFunc of the given table of guesses, made
+// as Generate makes moduleToPypiPackage, which looks up each module as
+// the Python backends do without an overlay: by the longest prefix of
+// a dotted module that is in the table.
+func TableGuess(table *Table) GuessFunc {
+	return func(mod string) (string, float64, bool) {
+		for prefix := mod; ; {
+			if value, ok := table.Get(prefix); ok {
+				pkg, confidence := DecodeGuess(value)
+				return pkg, confidence, true
+			}
+			dot := strings.LastIndexByte(prefix, '.')
+			if dot < 0 {
+				return "", 0, false
+			}
+			prefix = prefix[:dot]
+		}
+	}
+}
+
+// GuessTable returns the table of guesses that Generate would write
+// for the entries in the JSON file from, with the download counts from
+// the given stats, without writing anything.
+func GuessTable(from string, stats Stats) *Table {
+	guesses, _, _ := tables(from, stats)
+	return NewTable(string(EncodeTable(guesses)))
+}
+
+// Accuracy is how well the guesses for the modules of a corpus match
+// the packages that it says imports of them want.
+type Accuracy struct {
+	// How many modules of the corpus want a package.
+	Wanted int
+	// How many modules a package is guessed for.
+	Guessed int
+	// How many modules the wanted package is guessed for.
+	Correct int
+	// What went wrong for each other module, sorted.
+	Misses []string
+}
+
+// Precision returns the fraction of the guesses that are right, or 1
+// if nothing is guessed.
+func (a Accuracy) Precision() float64 {
+	if a.Guessed == 0 {
+		return 1
+	}
+	return float64(a.Correct) / float64(a.Guessed)
+}
+
+// Recall returns the fraction of the modules that want a package that
+// it is guessed for, or 1 if none do.
+func (a Accuracy) Recall() float64 {
+	if a.Wanted == 0 {
+		return 1
+	}
+	return float64(a.Correct) / float64(a.Wanted)
+}
+
+// Evaluate returns how well the given guesses match the given corpus.
+// A guess is only made with at least the given confidence, as with
+// --min-confidence. Packages match by their normalized names.
+func Evaluate(corpus []Pair, guess GuessFunc, minConfidence float64) Accuracy {
+	var a Accuracy
+	for _, pair := range corpus {
+		if pair.Pkg != "" {
+			a.Wanted++
+		}
+		pkg, confidence, ok := guess(pair.Module)
+		if ok && confidence < minConfidence {
+			switch {
+			case pair.Pkg == "":
+			case normalize(api.PkgName(pkg)) == normalize(api.PkgName(pair.Pkg)):
+				a.Misses = append(a.Misses, fmt.Sprintf(
+					"%s: %s is right, but too unlikely to be guessed (%.2f)", pair.Module, pkg, confidence,
+				))
+			default:
+				a.Misses = append(a.Misses, fmt.Sprintf(
+					"%s: nothing is guessed, instead of %s (%s is, but is too unlikely, at %.2f)",
+					pair.Module, pair.Pkg, pkg, confidence,
+				))
+			}
+			continue
+		}
+		switch {
+		case !ok && pair.Pkg != "":
+			a.Misses = append(a.Misses, fmt.Sprintf("%s: nothing is guessed, instead of %s", pair.Module, pair.Pkg))
+		case !ok:
+		case pair.Pkg == "":
+			a.Guessed++
+			a.Misses = append(a.Misses, fmt.Sprintf("%s: %s is guessed, instead of nothing", pair.Module, pkg))
+		case normalize(api.PkgName(pkg)) == normalize(api.PkgName(pair.Pkg)):
+			a.Guessed++
+			a.Correct++
+		default:
+			a.Guessed++
+			a.Misses = append(a.Misses, fmt.Sprintf("%s: %s is guessed, instead of %s", pair.Module, pkg, pair.Pkg))
+		}
+	}
+	sort.Strings(a.Misses)
+	return a
+}
+
+// Report writes the precision and recall of the accuracy to w, along
+// with each miss.
+func (a Accuracy) Report(w io.Writer) {
+	for _, miss := range a.Misses {
+		fmt.Fprintln(w, miss)
+	}
+	fmt.Fprintf(w, "precision: %.3f (%d of %d guesses are right)\n", a.Precision(), a.Correct, a.Guessed)
+	fmt.Fprintf(w, "recall: %.3f (%d of %d packages are guessed)\n", a.Recall(), a.Correct, a.Wanted)
+}
+
+// Check returns an error if the precision or recall of the accuracy is
+// below the given minimum.
+func (a Accuracy) Check(minPrecision float64, minRecall float64) error {
+	if a.Precision() < minPrecision {
+		return fmt.Errorf("precision %.3f is below %.3f", a.Precision(), minPrecision)
+	}
+	if a.Recall() < minRecall {
+		return fmt.Errorf("recall %.3f is below %.3f", a.Recall(), minRecall)
+	}
+	return nil
+}
@@ -0,0 +1,52 @@
+package pypimap
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestEvaluate(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "corpus.txt")
+	require.NoError(t, ioutil.WriteFile(filename, []byte(`# A comment.
+flask Flask
+google.cloud.storage google-cloud-storage
+yaml PyYAML
+jwt PyJWT
+PIL Pillow
+os -
+`), 0644))
+	corpus := ReadCorpus(filename)
+	require.Equal(t, Pair{Module: "os"}, corpus[5])
+
+	table := NewTable(string(EncodeTable([][2]string{
+		{"flask", EncodeGuess("flask", 0.95)},
+		{"google.cloud.storage", EncodeGuess("google-cloud-storage", 0.95)},
+		{"jwt", EncodeGuess("jwt", 0.95)},
+		{"os", EncodeGuess("os-sys", 0.6)},
+		{"yaml", EncodeGuess("PyYAML", 0.2)},
+	})))
+	accuracy := Evaluate(corpus, TableGuess(table), 0.5)
+	require.Equal(t, Accuracy{
+		Wanted:  5,
+		Guessed: 4,
+		Correct: 2,
+		Misses: []string{
+			"PIL: nothing is guessed, instead of Pillow",
+			"jwt: jwt is guessed, instead of PyJWT",
+			"os: os-sys is guessed, instead of nothing",
+			"yaml: PyYAML is right, but too unlikely to be guessed (0.20)",
+		},
+	}, accuracy)
+	require.Equal(t, 0.5, accuracy.Precision())
+	require.Equal(t, 0.4, accuracy.Recall())
+	require.NoError(t, accuracy.Check(0.5, 0.4))
+	require.EqualError(t, accuracy.Check(0.5, 0.6), "recall 0.400 is below 0.600")
+
+	// A dotted module is guessed by its longest known prefix.
+	pkg, _, ok := TableGuess(table)("google.cloud.storage.blob")
+	require.True(t, ok)
+	require.Equal(t, "google-cloud-storage", pkg)
+}
@@ -0,0 +1,508 @@
+// Package pypimap builds the map from Python modules to the PyPI
+// packages that provide them, which the Python backends use to guess
+// packages. It is built in two steps: Crawl fetches the modules of
+// every package in an index into a JSON file, one entry per line, and
+// Generate turns that file into Go source holding the data of the
+// Tables moduleToPypiPackage, pypiPackageToModules, and
+// pypiPackageToDownloads.
+package pypimap
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/replit/upm/internal/api"
+	"github.com/replit/upm/internal/util"
+)
+
+// Entry is one line of the JSON file that the map is generated from.
+type Entry struct {
+	// The name of the package.
+	Pkg string `json:"p"`
+	// The top-level modules that the package provides.
+	Mods []string `json:"m"`
+	// How many times the package has been downloaded.
+	Downloads int `json:"d"`
+	// The release that the modules were read from, so that a later
+	// crawl only has to read them again if there is a newer one.
+	// Entries from before versions were recorded have none.
+	Version string `json:"v,omitempty"`
+	// The kind of file that the modules were read from, "wheel"
+	// or "sdist", or nothing if the release has neither. Entries
+	// from before sdists were read have none either.
+	Source string `json:"s,omitempty"`
+}
+
+type downloadSort []*Entry
+
+func (dl downloadSort) Len() int {
+	return len(dl)
+}
+
+func (dl downloadSort) Less(i, j int) bool {
+	return dl[i].Downloads > dl[j].Downloads
+}
+
+func (dl downloadSort) Swap(i, j int) {
+	tmp := dl[i]
+	dl[i] = dl[j]
+	dl[j] = tmp
+}
+
+// pythonStdlibModules this build is built from
+// https://docs.python.org/3/py-modindex.htm as we never want to guess a
+// standard library module is provided by a remote package.
+var stdlibMods = map[string]bool{
+	"__future__":      true,
+	"__main__":        true,
+	"_dummy_thread":   true,
+	"_thread":         true,
+	"abc":             true,
+	"aifc":            true,
+	"argparse":        true,
+	"array":           true,
+	"ast":             true,
+	"asynchat":        true,
+	"asyncio":         true,
+	"asyncore":        true,
+	"atexit":          true,
+	"audioop":         true,
+	"base64":          true,
+	"bdb":             true,
+	"binascii":        true,
+	"binhex":          true,
+	"bisect":          true,
+	"builtins":        true,
+	"bz2":             true,
+	"calendar":        true,
+	"cgi":             true,
+	"cgitb":           true,
+	"chunk":           true,
+	"cmath":           true,
+	"cmd":             true,
+	"code":            true,
+	"codecs":          true,
+	"codeop":          true,
+	"collections":     true,
+	"colorsys":        true,
+	"compileall":      true,
+	"concurrent":      true,
+	"configparser":    true,
+	"contextlib":      true,
+	"contextvars":     true,
+	"copy":            true,
+	"copyreg":         true,
+	"cProfile":        true,
+	"crypt":           true,
+	"csv":             true,
+	"ctypes":          true,
+	"curses":          true,
+	"dataclasses":     true,
+	"datetime":        true,
+	"dbm":             true,
+	"decimal":         true,
+	"difflib":         true,
+	"dis":             true,
+	"distutils":       true,
+	"doctest":         true,
+	"dummy_threading": true,
+	"email":           true,
+	"encodings":       true,
+	"ensurepip":       true,
+	"enum":            true,
+	"errno":           true,
+	"faulthandler":    true,
+	"fcntl":           true,
+	"filecmp":         true,
+	"fileinput":       true,
+	"fnmatch":         true,
+	"formatter":       true,
+	"fractions":       true,
+	"ftplib":          true,
+	"functools":       true,
+	"gc":              true,
+	"getopt":          true,
+	"getpass":         true,
+	"gettext":         true,
+	"glob":            true,
+	"grp":             true,
+	"gzip":            true,
+	"hashlib":         true,
+	"heapq":           true,
+	"hmac":            true,
+	"html":            true,
+	"http":            true,
+	"imaplib":         true,
+	"imghdr":          true,
+	"imp":             true,
+	"importlib":       true,
+	"inspect":         true,
+	"io":              true,
+	"ipaddress":       true,
+	"itertools":       true,
+	"json":            true,
+	"keyword":         true,
+	"lib2to3":         true,
+	"linecache":       true,
+	"locale":          true,
+	"logging":         true,
+	"lzma":            true,
+	"mailbox":         true,
+	"mailcap":         true,
+	"marshal":         true,
+	"math":            true,
+	"mimetypes":       true,
+	"mmap":            true,
+	"modulefinder":    true,
+	"msilib":          true,
+	"msvcrt":          true,
+	"multiprocessing": true,
+	"netrc":           true,
+	"nis":             true,
+	"nntplib":         true,
+	"numbers":         true,
+	"operator":        true,
+	"optparse":        true,
+	"os":              true,
+	"ossaudiodev":     true,
+	"parser":          true,
+	"pathlib":         true,
+	"pdb":             true,
+	"pickle":          true,
+	"pickletools":     true,
+	"pipes":           true,
+	"pkgutil":         true,
+	"platform":        true,
+	"plistlib":        true,
+	"poplib":          true,
+	"posix":           true,
+	"pprint":          true,
+	"profile":         true,
+	"pstats":          true,
+	"pty":             true,
+	"pwd":             true,
+	"py_compile":      true,
+	"pyclbr":          true,
+	"pydoc":           true,
+	"queue":           true,
+	"quopri":          true,
+	"random":          true,
+	"re":              true,
+	"readline":        true,
+	"reprlib":         true,
+	"resource":        true,
+	"rlcompleter":     true,
+	"runpy":           true,
+	"sched":           true,
+	"secrets":         true,
+	"select":          true,
+	"selectors":       true,
+	"shelve":          true,
+	"shlex":           true,
+	"shutil":          true,
+	"signal":          true,
+	"site":            true,
+	"smtpd":           true,
+	"smtplib":         true,
+	"sndhdr":          true,
+	"socket":          true,
+	"socketserver":    true,
+	"spwd":            true,
+	"sqlite3":         true,
+	"ssl":             true,
+	"stat":            true,
+	"statistics":      true,
+	"string":          true,
+	"stringprep":      true,
+	"struct":          true,
+	"subprocess":      true,
+	"sunau":           true,
+	"symbol":          true,
+	"symtable":        true,
+	"sys":             true,
+	"sysconfig":       true,
+	"syslog":          true,
+	"tabnanny":        true,
+	"tarfile":         true,
+	"telnetlib":       true,
+	"tempfile":        true,
+	"termios":         true,
+	"test":            true,
+	"textwrap":        true,
+	"threading":       true,
+	"time":            true,
+	"timeit":          true,
+	"tkinter":         true,
+	"token":           true,
+	"tokenize":        true,
+	"trace":           true,
+	"traceback":       true,
+	"tracemalloc":     true,
+	"tty":             true,
+	"turtle":          true,
+	"turtledemo":      true,
+	"types":           true,
+	"typing":          true,
+	"unicodedata":     true,
+	"unittest":        true,
+	"urllib":          true,
+	"uu":              true,
+	"uuid":            true,
+	"venv":            true,
+	"warnings":        true,
+	"wave":            true,
+	"weakref":         true,
+	"webbrowser":      true,
+	"winreg":          true,
+	"winsound":        true,
+	"wsgiref":         true,
+	"xdrlib":          true,
+	"xml":             true,
+	"xmlrpc":          true,
+	"zipapp":          true,
+	"zipfile":         true,
+	"zipimport":       true,
+	"zlib":            true,
+}
+
+// The confidences that Generate gives the package that it guesses for
+// a module, from 0 to 1.
+const (
+	// A package whose name is the module's, whatever its
+	// downloads.
+	nameMatchConfidence = 0.95
+	// The only package that provides the module, with at least
+	// popularDownloads.
+	onlyProviderConfidence = 0.9
+	// The package with popularRatio times the downloads of the
+	// next that provides the module, per module that each
+	// provides; it grows to onlyProviderConfidence at
+	// 10*popularRatio times.
+	popularConfidence = 0.5
+	// Guesses that are less likely than this aren't kept at all.
+	minConfidence = 0.1
+)
+
+// popularDownloads and popularRatio are the thresholds of
+// popularConfidence. Below them, the confidence falls in proportion.
+const (
+	popularDownloads = 100
+	popularRatio     = 10
+)
+
+// popularityConfidence returns the confidence that the first of the
+// given packages, which provide the same module and are sorted by
+// their downloads, is the one that an import of it wants. Each package
+// is counted as if its downloads were split among its modules, since a
+// package that provides many modules is less likely to be wanted for
+// any one of them.
+func popularityConfidence(pkgs []*Entry) float64 {
+	perModule := func(pkg *Entry) float64 {
+		return float64(pkg.Downloads) / float64(len(pkg.Mods))
+	}
+	confidence := onlyProviderConfidence
+	if len(pkgs) > 1 {
+		ratio := math.Inf(1)
+		if next := perModule(pkgs[1]); next > 0 {
+			ratio = perModule(pkgs[0]) / next
+		}
+		if ratio < popularRatio {
+			confidence = popularConfidence * ratio / popularRatio
+		} else {
+			confidence = math.Min(onlyProviderConfidence, popularConfidence+
+				(onlyProviderConfidence-popularConfidence)*(ratio-popularRatio)/(9*popularRatio))
+		}
+	}
+	if pkgs[0].Downloads < popularDownloads {
+		confidence = math.Min(confidence, popularConfidence) * float64(pkgs[0].Downloads) / popularDownloads
+	}
+	return confidence
+}
+
+// EncodeGuess returns the value of moduleToPypiPackage for a module
+// that the given package is guessed for, with the given confidence:
+// the name of the package, a space, and the confidence.
+func EncodeGuess(pkg string, confidence float64) string {
+	return pkg + " " + strconv.FormatFloat(confidence, 'f', 2, 64)
+}
+
+// DecodeGuess returns the package and confidence in the given value
+// of moduleToPypiPackage, made by EncodeGuess.
+func DecodeGuess(value string) (string, float64) {
+	fields := strings.SplitN(value, " ", 2)
+	if len(fields) < 2 {
+		return fields[0], 0
+	}
+	confidence, err := strconv.ParseFloat(fields[1], 64)
+	if err != nil {
+		util.Panicf("DecodeGuess: %q: %s", value, err)
+	}
+	return fields[0], confidence
+}
+
+// Generate writes Go source in the given package to the file out,
+// holding the data of the tables made from the entries in the JSON
+// file from, with the download counts from the given stats, and the
+// date on which they were taken. Only the packages that provide
+// modules are given to stats, since the others are never guessed. If
+// there is an error, it terminates the process.
+func Generate(from string, pkg string, out string, stats Stats) {
+	guesses, packageMods, downloads := tables(from, stats)
+
+	outgo, err := os.Create(out)
+	if err != nil {
+		util.Die("%s", err)
+	}
+
+	fmt.Fprintf(outgo, "package %s\n", pkg)
+
+	fmt.Fprintf(outgo, `
+// pypiMapGenerated is the date on which the module map was generated,
+// as YYYY-MM-DD.
+const pypiMapGenerated = %q
+
+// pypiDownloadsDate is the date on which the download counts in
+// pypiPackageToDownloads were taken, as YYYY-MM-DD, or empty if that
+// isn't known.
+const pypiDownloadsDate = %q
+`, time.Now().UTC().Format("2006-01-02"), stats.Date())
+
+	fmt.Fprintf(outgo, `
+// The data of the tables of the module map, made by
+// pypimap.EncodeTable.
+const (
+	// Each known module, and its best matching package and how
+	// likely it is to be right, as pypimap.EncodeGuess writes them.
+	moduleToPypiPackageData = %q
+
+	// Each known package and the modules it provides that could be
+	// guessed, comma-separated.
+	pypiPackageToModulesData = %q
+
+	// Each known package and the number of times it has been
+	// downloaded.
+	pypiPackageToDownloadsData = %q
+)
+`, EncodeTable(guesses), EncodeTable(packageMods), EncodeTable(downloads))
+
+	err = outgo.Close()
+	if err != nil {
+		util.Die("%s", err)
+	}
+}
+
+// tables returns the pairs of keys and values of the tables of the
+// map that Generate writes: the guess for each module, the modules of
+// each package that could be guessed, and the downloads of each
+// package.
+func tables(from string, stats Stats) ([][2]string, [][2]string, [][2]string) {
+	injson, err := os.Open(from)
+	if err != nil {
+		util.Die("%s", err)
+	}
+	defer injson.Close()
+
+	dec := json.NewDecoder(injson)
+
+	pkgs := []*Entry{}
+	mods := map[string][]*Entry{}
+
+	for dec.More() {
+		var m Entry
+
+		err := dec.Decode(&m)
+		if err != nil {
+			util.Die("%s: %s", from, err)
+		}
+
+		pkgs = append(pkgs, &m)
+
+		for _, mod := range m.Mods {
+			if _, ok := mods[mod]; ok {
+				mods[mod] = append(mods[mod], &m)
+			} else {
+				mods[mod] = []*Entry{&m}
+			}
+		}
+	}
+
+	names := []api.PkgName{}
+	for _, entry := range pkgs {
+		if len(entry.Mods) > 0 {
+			names = append(names, api.PkgName(entry.Pkg))
+		}
+	}
+	if counts := stats.Downloads(names); counts != nil {
+		for _, entry := range pkgs {
+			entry.Downloads = counts[normalize(api.PkgName(entry.Pkg))]
+		}
+	}
+
+	for _, pklist := range mods {
+		sort.Stable(downloadSort(pklist))
+	}
+
+	guesses := [][2]string{}
+	guessable := map[string]bool{}
+	addMap := func(mod, pkg string, confidence float64) {
+		// Nor a module in a package of the standard library.
+		if stdlibMods[strings.SplitN(mod, ".", 2)[0]] {
+			return
+		}
+		if confidence < minConfidence {
+			return
+		}
+		guessable[mod] = true
+		guesses = append(guesses, [2]string{mod, EncodeGuess(pkg, confidence)})
+	}
+
+	modNames := []string{}
+	for mod := range mods {
+		modNames = append(modNames, mod)
+	}
+	sort.Strings(modNames)
+
+nextpkg:
+	for _, mod := range modNames {
+		pkgs := mods[mod]
+		if len(pkgs) == 0 {
+			continue nextpkg
+		}
+
+		// A dotted module, such as google.cloud.storage, is like
+		// a package named google-cloud-storage.
+		for _, candidate := range pkgs {
+			if normalize(api.PkgName(candidate.Pkg)) == normalize(api.PkgName(mod)) {
+				addMap(mod, candidate.Pkg, nameMatchConfidence)
+				continue nextpkg
+			}
+		}
+
+		addMap(mod, pkgs[0].Pkg, popularityConfidence(pkgs))
+	}
+
+	// The list of modules is limited to those which could
+	// potentially be guessed.
+	packageMods := [][2]string{}
+	downloads := [][2]string{}
+	for _, pkg := range pkgs {
+		guessableMods := []string{}
+		for _, mod := range pkg.Mods {
+			if guessable[mod] {
+				guessableMods = append(guessableMods, mod)
+			}
+		}
+		if len(guessableMods) > 0 {
+			packageMods = append(packageMods, [2]string{pkg.Pkg, strings.Join(guessableMods, ",")})
+		}
+		downloads = append(downloads, [2]string{pkg.Pkg, strconv.Itoa(pkg.Downloads)})
+	}
+
+	return guesses, packageMods, downloads
+}
@@ -0,0 +1,141 @@
+package pypimap
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/replit/upm/internal/util"
+)
+
+// PublishedVersion is the version of the format of Published that
+// this UPM reads and writes. A map in another format is refused,
+// rather than misread.
+const PublishedVersion = 1
+
+// DefaultPublishedURL is where 'upm index update' downloads the map from
+// unless it is told otherwise: the latest one published with a
+// release of UPM.
+const DefaultPublishedURL = "https://github.com/replit/upm/releases/latest/download/pypi_map.json"
+
+// Published is the module map as it is published for 'upm index update'
+// to download, so that the packages that UPM guesses can improve
+// between its releases. It holds the data of the same tables as the Go
+// source that Generate writes.
+type Published struct {
+	Version int `json:"version"`
+	// The date on which the map was made, as YYYY-MM-DD.
+	Generated string `json:"generated"`
+	// The date on which the download counts were taken, as
+	// YYYY-MM-DD, or empty if that isn't known.
+	DownloadsDate string `json:"downloadsDate"`
+
+	// The data of moduleToPypiPackage, pypiPackageToModules, and
+	// pypiPackageToDownloads, made by EncodeTable.
+	ModuleToPackage    []byte `json:"moduleToPackage"`
+	PackageToModules   []byte `json:"packageToModules"`
+	PackageToDownloads []byte `json:"packageToDownloads"`
+}
+
+// Publish writes the map made from the entries in the JSON file from,
+// with the download counts from the given stats, to the file out, as
+// Generate would write it in Go source. If there is an error, it
+// terminates the process.
+func Publish(from string, out string, stats Stats) {
+	guesses, packageMods, downloads := tables(from, stats)
+	contents, err := json.Marshal(Published{
+		Version:            PublishedVersion,
+		Generated:          time.Now().UTC().Format("2006-01-02"),
+		DownloadsDate:      stats.Date(),
+		ModuleToPackage:    EncodeTable(guesses),
+		PackageToModules:   EncodeTable(packageMods),
+		PackageToDownloads: EncodeTable(downloads),
+	})
+	if err != nil {
+		util.Panicf("couldn't marshal json")
+	}
+	if err := ioutil.WriteFile(out, contents, 0666); err != nil {
+		util.Die("%s", err)
+	}
+}
+
+// ParsePublished reads a map written by Publish. Only its format is
+// checked, not its tables, so that reading it doesn't decode them. Use
+// Check for a map that hasn't been checked before.
+func ParsePublished(contents []byte) (*Published, error) {
+	var published Published
+	if err := json.Unmarshal(contents, &published); err != nil {
+		return nil, err
+	}
+	if published.Version != PublishedVersion {
+		return nil, fmt.Errorf("it is in version %d of the format, but this UPM reads version %d",
+			published.Version, PublishedVersion)
+	}
+	return &published, nil
+}
+
+// PublishedCounts is how many entries the tables of a Published have.
+type PublishedCounts struct {
+	Modules  int
+	Packages int
+}
+
+// Check decodes all of the tables of the map and returns how many
+// modules and packages they have, or an error if any of them is
+// malformed.
+func (p *Published) Check() (PublishedCounts, error) {
+	var counts PublishedCounts
+	var err error
+	if counts.Modules, err = CheckTable(string(p.ModuleToPackage)); err != nil {
+		return counts, fmt.Errorf("modules: %s", err)
+	}
+	if _, err = CheckTable(string(p.PackageToModules)); err != nil {
+		return counts, fmt.Errorf("package modules: %s", err)
+	}
+	if counts.Packages, err = CheckTable(string(p.PackageToDownloads)); err != nil {
+		return counts, fmt.Errorf("package downloads: %s", err)
+	}
+	return counts, nil
+}
+
+// UpdatedMapFile returns the file that 'upm index update' writes the map
+// it downloads to, and that the Python backends read it from:
+// UPM_PYPI_MAP if it is set, or else pypi_map.json in the upm directory
+// of the user's cache directory. It returns "" if there is no cache
+// directory.
+func UpdatedMapFile() string {
+	if file := os.Getenv("UPM_PYPI_MAP"); file != "" {
+		return file
+	}
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "upm", "pypi_map.json")
+}
+
+// ReadUpdatedMap returns the map in UpdatedMapFile, or nil if there is
+// none. A map that can't be read is warned about and ignored, so that
+// the one built into UPM is used instead.
+func ReadUpdatedMap() *Published {
+	file := UpdatedMapFile()
+	if file == "" {
+		return nil
+	}
+	contents, err := ioutil.ReadFile(file)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err == nil {
+		var published *Published
+		if published, err = ParsePublished(contents); err == nil {
+			return published
+		}
+	}
+	util.Log(fmt.Sprintf("warning: ignoring the module map in %s: %s (run 'upm index update' to download it again)",
+		file, err))
+	return nil
+}
@@ -0,0 +1,59 @@
+package pypimap
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPublish(t *testing.T) {
+	dir := t.TempDir()
+	from := filepath.Join(dir, "pypi.json")
+	require.NoError(t, ioutil.WriteFile(from, []byte(strings.Join([]string{
+		`{"p":"acme","m":["acmelib"],"d":1000}`,
+		`{"p":"acme-fork","m":["acmelib"],"d":10}`,
+		"",
+	}, "\n")), 0666))
+
+	out := filepath.Join(dir, "pypi_map.json")
+	Publish(from, out, NewStats("entries", "", "2024-05-01"))
+	contents, err := ioutil.ReadFile(out)
+	require.NoError(t, err)
+	published, err := ParsePublished(contents)
+	require.NoError(t, err)
+	require.Equal(t, PublishedVersion, published.Version)
+	require.Equal(t, "2024-05-01", published.DownloadsDate)
+	require.NotEmpty(t, published.Generated)
+
+	counts, err := published.Check()
+	require.NoError(t, err)
+	require.Equal(t, PublishedCounts{Modules: 1, Packages: 2}, counts)
+	value, ok := NewTable(string(published.ModuleToPackage)).Get("acmelib")
+	require.True(t, ok)
+	pkg, _ := DecodeGuess(value)
+	require.Equal(t, "acme", pkg)
+
+	published.PackageToModules = published.PackageToModules[:len(published.PackageToModules)-1]
+	_, err = published.Check()
+	require.Error(t, err)
+
+	_, err = ParsePublished([]byte(`{"version": 2}`))
+	require.Error(t, err)
+	_, err = ParsePublished([]byte(`not json`))
+	require.Error(t, err)
+}
+
+func TestUpdatedMapFile(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "map.json")
+	t.Setenv("UPM_PYPI_MAP", file)
+	require.Equal(t, file, UpdatedMapFile())
+	require.Nil(t, ReadUpdatedMap())
+
+	require.NoError(t, ioutil.WriteFile(file, []byte(`{"version": 1, "generated": "2024-06-01"}`), 0666))
+	published := ReadUpdatedMap()
+	require.NotNil(t, published)
+	require.Equal(t, "2024-06-01", published.Generated)
+}
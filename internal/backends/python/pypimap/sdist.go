@@ -0,0 +1,319 @@
+package pypimap
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// pickSdist returns the sdist to read the modules of a release from,
+// for one without a wheel, or nil if it has none that can be read. A
+// .tar.gz is preferred, since that is what the standard says an sdist
+// is.
+func pickSdist(files []pypiFile) *pypiFile {
+	var best *pypiFile
+	for i := range files {
+		file := &files[i]
+		if file.PackageType != "sdist" || file.Yanked || !isSdistArchive(file.Filename) {
+			continue
+		}
+		if strings.HasSuffix(file.Filename, ".tar.gz") {
+			return file
+		}
+		if best == nil {
+			best = file
+		}
+	}
+	return best
+}
+
+// isSdistArchive returns true if the archive with the given filename
+// is in a format that sdistFiles can read.
+func isSdistArchive(filename string) bool {
+	for _, ext := range []string{".tar.gz", ".tgz", ".tar.bz2", ".zip"} {
+		if strings.HasSuffix(filename, ext) {
+			return true
+		}
+	}
+	return false
+}
+
+// isSdistMetadata returns true if the file with the given path in an
+// sdist is one that sdistModules reads: the build configuration, or
+// the top_level.txt that setuptools writes into the egg-info
+// directory, which may be in src.
+func isSdistMetadata(name string) bool {
+	switch name {
+	case "setup.py", "setup.cfg", "pyproject.toml":
+		return true
+	}
+	parts := strings.Split(name, "/")
+	return len(parts) <= 3 && parts[len(parts)-1] == "top_level.txt" &&
+		strings.HasSuffix(parts[len(parts)-2], ".egg-info")
+}
+
+// sdistFiles returns the paths of the files in the given sdist, which
+// has the given filename, without the directory that they are all in,
+// such as acme-1.0, along with the contents of those that
+// isSdistMetadata says sdistModules reads.
+func sdistFiles(filename string, contents []byte) ([]string, map[string][]byte, error) {
+	names := []string{}
+	metadata := map[string][]byte{}
+	// add adds the file with the given path in the archive, reading
+	// it with read if it is needed.
+	add := func(name string, read func() ([]byte, error)) error {
+		parts := strings.SplitN(strings.TrimPrefix(name, "./"), "/", 2)
+		if len(parts) < 2 || parts[1] == "" {
+			return nil
+		}
+		names = append(names, parts[1])
+		if !isSdistMetadata(parts[1]) {
+			return nil
+		}
+		data, err := read()
+		if err != nil {
+			return err
+		}
+		metadata[parts[1]] = data
+		return nil
+	}
+
+	if strings.HasSuffix(filename, ".zip") {
+		r, err := zip.NewReader(bytes.NewReader(contents), int64(len(contents)))
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, file := range r.File {
+			if file.FileInfo().IsDir() {
+				continue
+			}
+			file := file
+			err := add(file.Name, func() ([]byte, error) {
+				f, err := file.Open()
+				if err != nil {
+					return nil, err
+				}
+				defer f.Close()
+				return ioutil.ReadAll(f)
+			})
+			if err != nil {
+				return nil, nil, err
+			}
+		}
+		return names, metadata, nil
+	}
+
+	var r io.Reader = bytes.NewReader(contents)
+	switch {
+	case strings.HasSuffix(filename, ".tar.bz2"):
+		r = bzip2.NewReader(r)
+	case strings.HasSuffix(filename, ".tar.gz"), strings.HasSuffix(filename, ".tgz"):
+		gz, err := gzip.NewReader(r)
+		if err != nil {
+			return nil, nil, err
+		}
+		defer gz.Close()
+		r = gz
+	default:
+		return nil, nil, fmt.Errorf("%s: unknown kind of archive", filename)
+	}
+	tr := tar.NewReader(r)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, nil, err
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+		err = add(header.Name, func() ([]byte, error) {
+			return ioutil.ReadAll(tr)
+		})
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	return names, metadata, nil
+}
+
+// sdistIgnoredDirs are the directories at the top of an sdist whose
+// modules aren't installed, unless the build configuration says they
+// are.
+var sdistIgnoredDirs = map[string]bool{
+	"bench": true, "benchmarks": true, "build": true, "dist": true,
+	"doc": true, "docs": true, "example": true, "examples": true,
+	"scripts": true, "test": true, "testing": true, "tests": true,
+	"tools": true,
+}
+
+// sdistIgnoredFiles are the modules at the top of an sdist that are
+// only used to build or test it.
+var sdistIgnoredFiles = map[string]bool{
+	"conftest.py": true, "distribute_setup.py": true, "ez_setup.py": true,
+	"fabfile.py": true, "noxfile.py": true, "setup.py": true,
+	"tasks.py": true,
+}
+
+var (
+	// setupPyPackageDirRegexp matches a package_dir in setup.py
+	// that puts the packages in a directory, such as
+	// package_dir={"": "src"}.
+	setupPyPackageDirRegexp = regexp.MustCompile(`package_dir\s*=\s*\{\s*["']["']\s*:\s*["']([^"']+)["']`)
+	// setupCfgPackageDirRegexp matches the same in setup.cfg,
+	// where it is written package_dir = =src, perhaps over two
+	// lines.
+	setupCfgPackageDirRegexp = regexp.MustCompile(`(?m)^package_dir\s*=\s*=\s*(\S+)\s*$`)
+	// pyprojectPackageDirRegexp matches the same in the
+	// [tool.setuptools.package-dir] table of pyproject.toml.
+	pyprojectPackageDirRegexp = regexp.MustCompile(`(?m)^\s*""\s*=\s*["']([^"']+)["']`)
+	// setupPyListRegexp matches a literal list of packages or
+	// modules in setup.py, such as packages=["acme", "acme.io"].
+	setupPyListRegexp = regexp.MustCompile(`\b(?:packages|py_modules)\s*=\s*[\[(]([^\])]*)[\])]`)
+	// setupCfgListRegexp matches the same in the [options] of
+	// setup.cfg, where the names are separated with commas or put
+	// on lines of their own.
+	setupCfgListRegexp = regexp.MustCompile(`(?m)^(?:packages|py_modules)\s*=((?:[^\n]*)(?:\n[ \t]+[^\n]*)*)`)
+	// quotedNameRegexp matches a quoted name in a list.
+	quotedNameRegexp = regexp.MustCompile(`["']([A-Za-z_][A-Za-z0-9_.]*)["']`)
+	// pythonNameRegexp matches a name of a module.
+	pythonNameRegexp = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_.]*$`)
+)
+
+// sdistPackageDir returns the directory of the sdist that the given
+// build configuration, by filename, says the packages are in, such as
+// src, or "" if it doesn't say.
+func sdistPackageDir(metadata map[string][]byte) string {
+	if m := setupCfgPackageDirRegexp.FindSubmatch(
+		bytes.ReplaceAll(metadata["setup.cfg"], []byte("=\n"), []byte("= "))); m != nil {
+		return strings.Trim(string(m[1]), "/")
+	}
+	if m := setupPyPackageDirRegexp.FindSubmatch(metadata["setup.py"]); m != nil {
+		return strings.Trim(string(m[1]), "/")
+	}
+	if pyproject := string(metadata["pyproject.toml"]); strings.Contains(pyproject, "[tool.setuptools.package-dir]") {
+		table := strings.SplitN(pyproject, "[tool.setuptools.package-dir]", 2)[1]
+		table = strings.SplitN(table, "\n[", 2)[0]
+		if m := pyprojectPackageDirRegexp.FindStringSubmatch(table); m != nil {
+			return strings.Trim(m[1], "/")
+		}
+	}
+	return ""
+}
+
+// sdistDeclaredModules returns the top-level modules of the packages
+// and modules that the given build configuration lists literally, or
+// nil if it doesn't, as when setup.py calls find_packages().
+func sdistDeclaredModules(metadata map[string][]byte) map[string]bool {
+	var mods map[string]bool
+	add := func(name string) {
+		if mods == nil {
+			mods = map[string]bool{}
+		}
+		mods[strings.SplitN(name, ".", 2)[0]] = true
+	}
+	for _, m := range setupPyListRegexp.FindAllSubmatch(metadata["setup.py"], -1) {
+		for _, name := range quotedNameRegexp.FindAllSubmatch(m[1], -1) {
+			add(string(name[1]))
+		}
+	}
+	for _, m := range setupCfgListRegexp.FindAllSubmatch(metadata["setup.cfg"], -1) {
+		names := strings.FieldsFunc(string(m[1]), func(r rune) bool {
+			return r == ',' || r == ' ' || r == '\t' || r == '\n' || r == '\r'
+		})
+		// "packages = find:" is found by setuptools.
+		if len(names) == 1 && strings.HasPrefix(names[0], "find") {
+			continue
+		}
+		for _, name := range names {
+			if pythonNameRegexp.MatchString(name) {
+				add(name)
+			}
+		}
+	}
+	return mods
+}
+
+// sdistModules returns the sorted top-level modules that the given
+// sdist, which has the given filename, installs, in the same form as
+// wheelModules. Since an sdist has the source rather than what is
+// installed, they are found from, in order of preference:
+//
+//   - the top_level.txt in its egg-info directory, which setuptools
+//     writes when it builds the sdist;
+//   - the packages and modules that its setup.py or setup.cfg lists;
+//   - the modules in it, in the directory that the build configuration
+//     says the packages are in or src if there is one, leaving out
+//     directories such as tests and scripts such as setup.py that are
+//     usually not installed.
+func sdistModules(filename string, contents []byte) ([]string, error) {
+	names, metadata, err := sdistFiles(filename, contents)
+	if err != nil {
+		return nil, err
+	}
+
+	var topLevel map[string]bool
+	topLevelFile := ""
+	for name, data := range metadata {
+		if !strings.HasSuffix(name, "top_level.txt") {
+			continue
+		}
+		// The one at the top wins over one in src.
+		if topLevelFile == "" || len(name) < len(topLevelFile) {
+			topLevel = parseTopLevel(data)
+			topLevelFile = name
+		}
+	}
+	if topLevel == nil {
+		topLevel = sdistDeclaredModules(metadata)
+	}
+
+	dir := sdistPackageDir(metadata)
+	if dir == "" {
+		for _, name := range names {
+			if strings.HasPrefix(name, "src/") && strings.HasSuffix(name, ".py") {
+				dir = "src"
+				break
+			}
+		}
+	}
+
+	var paths [][]string
+	packages := map[string]bool{}
+	for _, name := range names {
+		if dir != "" {
+			if !strings.HasPrefix(name, dir+"/") {
+				continue
+			}
+			name = strings.TrimPrefix(name, dir+"/")
+		}
+		parts := strings.Split(name, "/")
+		base := parts[len(parts)-1]
+		// Extension modules are built from Cython, if at all.
+		if !strings.HasSuffix(base, ".py") && !strings.HasSuffix(base, ".pyx") {
+			continue
+		}
+		if strings.HasPrefix(parts[0], ".") || strings.HasSuffix(parts[0], ".egg-info") {
+			continue
+		}
+		if topLevel == nil && (len(parts) > 1 && sdistIgnoredDirs[parts[0]] ||
+			len(parts) == 1 && sdistIgnoredFiles[base]) {
+			continue
+		}
+		if strings.SplitN(base, ".", 2)[0] == "__init__" {
+			packages[path.Join(parts[:len(parts)-1]...)] = true
+		}
+		paths = append(paths, parts)
+	}
+
+	return collectModules(paths, packages, topLevel), nil
+}
@@ -0,0 +1,83 @@
+package pypimap
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func makeSdist(t *testing.T, files map[string]string) []byte {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	w := tar.NewWriter(gz)
+	for name, contents := range files {
+		require.NoError(t, w.WriteHeader(&tar.Header{
+			Name:     "acme-1.0/" + name,
+			Mode:     0644,
+			Size:     int64(len(contents)),
+			Typeflag: tar.TypeReg,
+		}))
+		_, err := w.Write([]byte(contents))
+		require.NoError(t, err)
+	}
+	require.NoError(t, w.Close())
+	require.NoError(t, gz.Close())
+	return buf.Bytes()
+}
+
+func TestSdistModules(t *testing.T) {
+	// The egg-info says what is installed.
+	mods, err := sdistModules("acme-1.0.tar.gz", makeSdist(t, map[string]string{
+		"setup.py":                    "setup(packages=find_packages())",
+		"acme/__init__.py":            "",
+		"acme_extra.py":               "",
+		"acme.egg-info/top_level.txt": "acme\n",
+	}))
+	require.NoError(t, err)
+	require.Equal(t, []string{"acme"}, mods)
+
+	// Otherwise, the packages that setup.py lists.
+	mods, err = sdistModules("acme-1.0.tar.gz", makeSdist(t, map[string]string{
+		"setup.py":         "setup(\n    packages=['acme', 'acme.io'],\n    py_modules=[\"acme_compat\"],\n)",
+		"acme/__init__.py": "",
+		"acme/io.py":       "",
+		"acme_compat.py":   "",
+		"helpers.py":       "",
+	}))
+	require.NoError(t, err)
+	require.Equal(t, []string{"acme", "acme_compat"}, mods)
+
+	// Otherwise, the modules in the package directory, or at the top,
+	// but not tests.
+	mods, err = sdistModules("acme-1.0.tar.gz", makeSdist(t, map[string]string{
+		"setup.cfg":              "[options]\npackage_dir =\n    =lib\npackages = find:\n",
+		"lib/acme/__init__.py":   "",
+		"lib/acme/_speedups.pyx": "",
+		"tests/test_acme.py":     "",
+	}))
+	require.NoError(t, err)
+	require.Equal(t, []string{"acme"}, mods)
+
+	mods, err = sdistModules("acme-1.0.tar.gz", makeSdist(t, map[string]string{
+		"setup.py":           "setup()",
+		"acme.py":            "",
+		"conftest.py":        "",
+		"tests/test_acme.py": "",
+		"docs/conf.py":       "",
+	}))
+	require.NoError(t, err)
+	require.Equal(t, []string{"acme"}, mods)
+}
+
+func TestPickSdist(t *testing.T) {
+	files := []pypiFile{
+		{Filename: "acme-1.0.tar.xz", PackageType: "sdist"},
+		{Filename: "acme-1.0.zip", PackageType: "sdist"},
+		{Filename: "acme-1.0.tar.gz", PackageType: "sdist", Yanked: true},
+	}
+	require.Equal(t, "acme-1.0.zip", pickSdist(files).Filename)
+	require.Nil(t, pickSdist(files[:1]))
+}
@@ -0,0 +1,260 @@
+package pypimap
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/replit/upm/internal/api"
+	"github.com/replit/upm/internal/util"
+)
+
+// Stats is a source of the download counts that Generate ranks the
+// packages that provide the same module by.
+type Stats interface {
+	// Downloads returns how many times each of the given packages
+	// has been downloaded, by normalized name, leaving out those
+	// it has no count for. It returns nil if the counts in the
+	// entries are to be used.
+	Downloads(pkgs []api.PkgName) map[api.PkgName]int
+	// Date returns the date on which the counts were taken, as
+	// YYYY-MM-DD, or "" if that isn't known.
+	Date() string
+}
+
+// StatsSources are the sources of download counts that NewStats takes.
+var StatsSources = []string{"entries", "pypistats", "bigquery"}
+
+// NewStats returns the Stats from the given source:
+//
+//   - "entries", the counts already in the entries, taken on the given
+//     date, which may be empty;
+//   - "pypistats", the downloads in the last month from the API of
+//     pypistats.org, taken today;
+//   - "bigquery", the counts in the given file, exported from a query
+//     of the PyPI downloads dataset on BigQuery as CSV or as JSON, one
+//     object per line. It must have a column for the project, named
+//     project, file_project, or package, and one for the count, named
+//     downloads, num_downloads, or count. The counts were taken on the
+//     given date, or if it is empty, when the file was last modified.
+//
+// If the source is unknown, it terminates the process.
+func NewStats(source string, file string, date string) Stats {
+	if file != "" && source != "bigquery" {
+		util.Die("a downloads file can only be given with the bigquery source")
+	}
+	switch source {
+	case "", "entries":
+		return entryStats{date: date}
+	case "pypistats":
+		return &pypistatsStats{url: pypistatsURL, date: time.Now().Format("2006-01-02")}
+	case "bigquery":
+		if file == "" {
+			util.Die("the bigquery source needs the file that the downloads were exported to")
+		}
+		if date == "" {
+			info, err := os.Stat(file)
+			if err != nil {
+				util.Die("%s", err)
+			}
+			date = info.ModTime().Format("2006-01-02")
+		}
+		return bigqueryStats{file: file, date: date}
+	default:
+		util.Die("unknown source of downloads %q (must be one of: %s)",
+			source, strings.Join(StatsSources, ", "))
+		return nil
+	}
+}
+
+// entryStats are the counts in the entries.
+type entryStats struct {
+	date string
+}
+
+func (s entryStats) Downloads(pkgs []api.PkgName) map[api.PkgName]int {
+	return nil
+}
+
+func (s entryStats) Date() string {
+	return s.date
+}
+
+// pypistatsURL is where the API of pypistats.org is.
+const pypistatsURL = "https://pypistats.org/api"
+
+// pypistatsStats are the counts from the API of pypistats.org at url.
+type pypistatsStats struct {
+	url  string
+	date string
+}
+
+// pypistatsRecent is the response of the API for the recent downloads
+// of a package.
+type pypistatsRecent struct {
+	Data struct {
+		LastMonth int `json:"last_month"`
+	} `json:"data"`
+}
+
+// Downloads looks the packages up util.Jobs at a time, since there is
+// a request for each. A package that can't be looked up is only
+// reported, and left out.
+func (s *pypistatsStats) Downloads(pkgs []api.PkgName) map[api.PkgName]int {
+	counts := map[api.PkgName]int{}
+	var mutex sync.Mutex
+	names := make(chan api.PkgName)
+	var wg sync.WaitGroup
+	for i := 0; i < util.Jobs(); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for name := range names {
+				count, err := s.fetch(name)
+				if err != nil {
+					util.Log(fmt.Sprintf("warning: %s: %s", name, err))
+					continue
+				}
+				mutex.Lock()
+				counts[normalize(name)] = count
+				mutex.Unlock()
+			}
+		}()
+	}
+	for _, name := range pkgs {
+		names <- name
+	}
+	close(names)
+	wg.Wait()
+	return counts
+}
+
+// fetch returns the downloads of the given package in the last month.
+func (s *pypistatsStats) fetch(name api.PkgName) (int, error) {
+	resp, err := util.HTTPClient.Get(util.JoinURL(s.url, "packages", string(normalize(name)), "recent"))
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == 404 {
+		return 0, nil
+	}
+	if resp.StatusCode != 200 {
+		return 0, fmt.Errorf("pypistats.org: %s", resp.Status)
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return 0, err
+	}
+	var recent pypistatsRecent
+	if err := json.Unmarshal(body, &recent); err != nil {
+		return 0, fmt.Errorf("pypistats.org: %s", err)
+	}
+	return recent.Data.LastMonth, nil
+}
+
+func (s *pypistatsStats) Date() string {
+	return s.date
+}
+
+// bigqueryStats are the counts in a file exported from BigQuery.
+type bigqueryStats struct {
+	file string
+	date string
+}
+
+// bigqueryProjectColumns and bigqueryCountColumns are the names that
+// the columns of a BigQuery export may have, since they are whatever
+// the query called them.
+var (
+	bigqueryProjectColumns = []string{"project", "file_project", "package"}
+	bigqueryCountColumns   = []string{"downloads", "num_downloads", "count"}
+)
+
+// Downloads reads the whole file, since it has the counts of every
+// package anyway. The counts of a package that is in it more than once
+// are added up, as for a query grouped by something else as well.
+func (s bigqueryStats) Downloads(pkgs []api.PkgName) map[api.PkgName]int {
+	file, err := os.Open(s.file)
+	if err != nil {
+		util.Die("%s", err)
+	}
+	defer file.Close()
+
+	counts := map[api.PkgName]int{}
+	add := func(row map[string]string) {
+		project := firstColumn(row, bigqueryProjectColumns)
+		count := firstColumn(row, bigqueryCountColumns)
+		if project == "" || count == "" {
+			util.Die("%s: every row needs a project (%s) and a count (%s)", s.file,
+				strings.Join(bigqueryProjectColumns, ", "),
+				strings.Join(bigqueryCountColumns, ", "))
+		}
+		n, err := strconv.Atoi(count)
+		if err != nil {
+			util.Die("%s: %s: invalid count %q", s.file, project, count)
+		}
+		counts[normalize(api.PkgName(project))] += n
+	}
+
+	if strings.HasSuffix(s.file, ".csv") {
+		r := csv.NewReader(file)
+		header, err := r.Read()
+		if err != nil {
+			util.Die("%s: %s", s.file, err)
+		}
+		for {
+			record, err := r.Read()
+			if err == io.EOF {
+				break
+			} else if err != nil {
+				util.Die("%s: %s", s.file, err)
+			}
+			row := map[string]string{}
+			for i, column := range header {
+				row[column] = record[i]
+			}
+			add(row)
+		}
+		return counts
+	}
+
+	dec := json.NewDecoder(file)
+	dec.UseNumber()
+	for dec.More() {
+		// BigQuery exports integers as strings in JSON, but
+		// others may be numbers.
+		var object map[string]interface{}
+		if err := dec.Decode(&object); err != nil {
+			util.Die("%s: %s", s.file, err)
+		}
+		row := map[string]string{}
+		for column, value := range object {
+			row[column] = fmt.Sprint(value)
+		}
+		add(row)
+	}
+	return counts
+}
+
+func (s bigqueryStats) Date() string {
+	return s.date
+}
+
+// firstColumn returns the value in the row of the first of the given
+// columns that it has, or "" if it has none of them.
+func firstColumn(row map[string]string, columns []string) string {
+	for _, column := range columns {
+		if value, ok := row[column]; ok {
+			return value
+		}
+	}
+	return ""
+}
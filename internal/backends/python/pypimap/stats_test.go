@@ -0,0 +1,78 @@
+package pypimap
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/replit/upm/internal/api"
+)
+
+func TestBigqueryStats(t *testing.T) {
+	dir := t.TempDir()
+	csvFile := filepath.Join(dir, "downloads.csv")
+	require.NoError(t, ioutil.WriteFile(csvFile, []byte(
+		"file_project,country_code,num_downloads\nAcme_Utils,US,10\nacme-utils,DE,5\nsix,US,100\n"), 0666))
+	stats := NewStats("bigquery", csvFile, "2024-05-01")
+	require.Equal(t, "2024-05-01", stats.Date())
+	require.Equal(t, map[api.PkgName]int{"acme-utils": 15, "six": 100}, stats.Downloads(nil))
+
+	jsonFile := filepath.Join(dir, "downloads.json")
+	require.NoError(t, ioutil.WriteFile(jsonFile, []byte(
+		`{"project":"six","downloads":"100"}`+"\n"+`{"project":"acme","downloads":7}`+"\n"), 0666))
+	stats = NewStats("bigquery", jsonFile, "")
+	require.NotEmpty(t, stats.Date())
+	require.Equal(t, map[api.PkgName]int{"six": 100, "acme": 7}, stats.Downloads(nil))
+}
+
+func TestPypistatsStats(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/packages/acme-utils/recent":
+			fmt.Fprint(w, `{"data": {"last_day": 1, "last_month": 30, "last_week": 7}, "package": "acme-utils"}`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	stats := &pypistatsStats{url: server.URL + "/api"}
+	require.Equal(t, map[api.PkgName]int{"acme-utils": 30, "gone": 0},
+		stats.Downloads([]api.PkgName{"Acme_Utils", "gone"}))
+}
+
+func TestGenerateStats(t *testing.T) {
+	dir := t.TempDir()
+	from := filepath.Join(dir, "pypi.json")
+	require.NoError(t, ioutil.WriteFile(from, []byte(strings.Join([]string{
+		`{"p":"acme","m":["acmelib"],"d":1000}`,
+		`{"p":"acme-fork","m":["acmelib"],"d":10}`,
+		"",
+	}, "\n")), 0666))
+	counts := filepath.Join(dir, "downloads.csv")
+	require.NoError(t, ioutil.WriteFile(counts, []byte("project,downloads\nacme,10\nacme-fork,1000\n"), 0666))
+
+	out := filepath.Join(dir, "map.go")
+	Generate(from, "python", out, NewStats("bigquery", counts, "2024-05-01"))
+	source, err := ioutil.ReadFile(out)
+	require.NoError(t, err)
+	require.Contains(t, string(source), `const pypiDownloadsDate = "2024-05-01"`)
+
+	data := func(name string) string {
+		line := strings.SplitN(strings.SplitN(string(source), name+" = ", 2)[1], "\n", 2)[0]
+		var value string
+		_, err := fmt.Sscanf(line, "%q", &value)
+		require.NoError(t, err)
+		return value
+	}
+	value, ok := NewTable(data("moduleToPypiPackageData")).Get("acmelib")
+	require.True(t, ok)
+	pkg, _ := DecodeGuess(value)
+	require.Equal(t, "acme-fork", pkg)
+}
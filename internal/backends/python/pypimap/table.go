@@ -0,0 +1,244 @@
+package pypimap
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/replit/upm/internal/util"
+)
+
+// tableBlockSize is how many entries of a Table are compressed
+// together. Looking a key up decodes this many entries, so a smaller
+// block is quicker to look up, but compresses less well.
+const tableBlockSize = 512
+
+// Table is a map from strings to strings, which is how the generated
+// source holds each map of the module map. Storing them as strings,
+// rather than as map literals, keeps them out of the heap until they
+// are used and makes the binary much smaller, since the compiler turns
+// a map literal into code that adds each entry.
+//
+// The entries are sorted by key and compressed in blocks, after an
+// index of the first key of each block, so that looking a key up only
+// decodes the block that it would be in. Each block is decoded at most
+// once, and kept.
+type Table struct {
+	data string
+	// If not nil, what data is got from when the table is first
+	// used.
+	load func() string
+
+	indexOnce sync.Once
+	// The first key of each block.
+	firstKeys []string
+	// The offset in data at which each block starts, and then the
+	// offset at which the last one ends.
+	offsets []int
+
+	mutex sync.Mutex
+	// The entries of each block that has been decoded, or nil.
+	blocks [][][2]string
+}
+
+// EncodeTable returns the data of a Table holding the given pairs of
+// keys and values. Neither may contain a newline, and the keys must be
+// distinct.
+func EncodeTable(pairs [][2]string) []byte {
+	pairs = append([][2]string{}, pairs...)
+	sort.Slice(pairs, func(i, j int) bool {
+		return pairs[i][0] < pairs[j][0]
+	})
+
+	var index, blocks bytes.Buffer
+	putUvarint := func(x int) {
+		var buf [binary.MaxVarintLen64]byte
+		index.Write(buf[:binary.PutUvarint(buf[:], uint64(x))])
+	}
+	putUvarint((len(pairs) + tableBlockSize - 1) / tableBlockSize)
+	for start := 0; start < len(pairs); start += tableBlockSize {
+		end := start + tableBlockSize
+		if end > len(pairs) {
+			end = len(pairs)
+		}
+		var block bytes.Buffer
+		w, err := flate.NewWriter(&block, flate.BestCompression)
+		if err != nil {
+			util.Panicf("EncodeTable: %s", err)
+		}
+		for i, pair := range pairs[start:end] {
+			if strings.ContainsRune(pair[0]+pair[1], '\n') {
+				util.Panicf("EncodeTable: %q: %q contains a newline", pair[0], pair[1])
+			}
+			if start+i > 0 && pair[0] == pairs[start+i-1][0] {
+				util.Panicf("EncodeTable: duplicate key %q", pair[0])
+			}
+			w.Write([]byte(pair[0] + "\n" + pair[1] + "\n"))
+		}
+		if err := w.Close(); err != nil {
+			util.Panicf("EncodeTable: %s", err)
+		}
+
+		putUvarint(len(pairs[start][0]))
+		index.WriteString(pairs[start][0])
+		putUvarint(block.Len())
+		blocks.Write(block.Bytes())
+	}
+	return append(index.Bytes(), blocks.Bytes()...)
+}
+
+// NewTable returns the Table with the given data, made by EncodeTable.
+// Nothing is decoded until the table is used.
+func NewTable(data string) *Table {
+	return &Table{data: data}
+}
+
+// NewTableFunc is like NewTable, but the data is got from the given
+// function when the table is first used, so that it can come from a
+// file without the file being read by commands that don't use it.
+func NewTableFunc(load func() string) *Table {
+	return &Table{load: load}
+}
+
+// CheckTable decodes all of the given data of a Table and returns how
+// many keys it has, or an error if it is malformed. The methods of a
+// Table panic if its data is malformed, which is a bug for the tables
+// built into UPM, but not for one that was downloaded, which should be
+// checked first.
+func CheckTable(data string) (keys int, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%v", r)
+		}
+	}()
+	NewTable(data).Each(func(key string, value string) {
+		keys++
+	})
+	return keys, nil
+}
+
+// readIndex decodes the index of the table. Since the tables are built
+// into UPM, a table that can't be decoded is a bug, so this and the
+// other methods panic if it is malformed.
+func (t *Table) readIndex() {
+	t.indexOnce.Do(func() {
+		if t.load != nil {
+			t.data = t.load()
+		}
+		r := strings.NewReader(t.data)
+		getUvarint := func() int {
+			x, err := binary.ReadUvarint(r)
+			if err != nil {
+				util.Panicf("Table: malformed index: %s", err)
+			}
+			return int(x)
+		}
+		n := getUvarint()
+		t.firstKeys = make([]string, n)
+		lengths := make([]int, n)
+		for i := 0; i < n; i++ {
+			keyLen := getUvarint()
+			start := len(t.data) - r.Len()
+			if start+keyLen > len(t.data) {
+				util.Panicf("Table: malformed index")
+			}
+			t.firstKeys[i] = t.data[start : start+keyLen]
+			r.Seek(int64(keyLen), io.SeekCurrent)
+			lengths[i] = getUvarint()
+		}
+		offset := len(t.data) - r.Len()
+		t.offsets = append(t.offsets, offset)
+		for _, length := range lengths {
+			offset += length
+			t.offsets = append(t.offsets, offset)
+		}
+		if offset != len(t.data) {
+			util.Panicf("Table: malformed index")
+		}
+		t.blocks = make([][][2]string, n)
+	})
+}
+
+// block returns the entries of the block with the given index, in
+// order, decoding it if it hasn't been yet. The caller must hold the
+// mutex.
+func (t *Table) block(i int) [][2]string {
+	if t.blocks[i] != nil {
+		return t.blocks[i]
+	}
+	r := flate.NewReader(strings.NewReader(t.data[t.offsets[i]:t.offsets[i+1]]))
+	contents, err := ioutil.ReadAll(r)
+	if err != nil {
+		util.Panicf("Table: block %d: %s", i, err)
+	}
+	// The keys and values share the string of the whole block.
+	lines := strings.Split(strings.TrimSuffix(string(contents), "\n"), "\n")
+	if len(lines)%2 != 0 {
+		util.Panicf("Table: block %d: a key has no value", i)
+	}
+	entries := make([][2]string, len(lines)/2)
+	for j := range entries {
+		entries[j] = [2]string{lines[2*j], lines[2*j+1]}
+	}
+	t.blocks[i] = entries
+	return entries
+}
+
+// Get returns the value of the given key, and whether it is in the
+// table.
+func (t *Table) Get(key string) (string, bool) {
+	t.readIndex()
+	// The block that the key would be in is the last one whose
+	// first key isn't after it.
+	i := sort.SearchStrings(t.firstKeys, key)
+	if i == len(t.firstKeys) || t.firstKeys[i] != key {
+		i--
+	}
+	if i < 0 {
+		return "", false
+	}
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	entries := t.block(i)
+	j := sort.Search(len(entries), func(j int) bool {
+		return entries[j][0] >= key
+	})
+	if j < len(entries) && entries[j][0] == key {
+		return entries[j][1], true
+	}
+	return "", false
+}
+
+// Each calls f with every key and value in the table, in order of the
+// keys, decoding the whole table.
+func (t *Table) Each(f func(key string, value string)) {
+	t.readIndex()
+	for i := range t.firstKeys {
+		t.mutex.Lock()
+		entries := t.block(i)
+		t.mutex.Unlock()
+		for _, entry := range entries {
+			f(entry[0], entry[1])
+		}
+	}
+}
+
+// Len returns how many keys the table has. Since EncodeTable fills
+// every block but the last, only that one is decoded.
+func (t *Table) Len() int {
+	t.readIndex()
+	n := len(t.firstKeys)
+	if n == 0 {
+		return 0
+	}
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	return (n-1)*tableBlockSize + len(t.block(n-1))
+}
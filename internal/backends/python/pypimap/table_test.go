@@ -0,0 +1,62 @@
+package pypimap
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTable(t *testing.T) {
+	pairs := [][2]string{{"empty", ""}}
+	for i := 0; i < 3*tableBlockSize; i++ {
+		pairs = append(pairs, [2]string{fmt.Sprintf("mod%05d", i), fmt.Sprintf("pkg-%d", i)})
+	}
+	table := NewTable(string(EncodeTable(pairs)))
+	require.Len(t, table.firstKeys, 0)
+
+	value, ok := table.Get("mod01000")
+	require.True(t, ok)
+	require.Equal(t, "pkg-1000", value)
+	// Only the block holding the key was decoded.
+	decoded := 0
+	for _, block := range table.blocks {
+		if block != nil {
+			decoded++
+		}
+	}
+	require.Equal(t, 1, decoded)
+
+	value, ok = table.Get("empty")
+	require.True(t, ok)
+	require.Equal(t, "", value)
+	for _, key := range []string{"", "a", "mod", "mod01000x", "zzz"} {
+		_, ok := table.Get(key)
+		require.False(t, ok, key)
+	}
+
+	keys := []string{}
+	table.Each(func(key string, value string) {
+		keys = append(keys, key)
+	})
+	require.Len(t, keys, len(pairs))
+	require.Equal(t, "empty", keys[0])
+	require.Equal(t, "mod00000", keys[1])
+
+	_, ok = NewTable(string(EncodeTable(nil))).Get("mod")
+	require.False(t, ok)
+}
+
+func TestTableLen(t *testing.T) {
+	pairs := [][2]string{}
+	for i := 0; i < 2*tableBlockSize+7; i++ {
+		pairs = append(pairs, [2]string{fmt.Sprintf("mod%05d", i), "pkg"})
+	}
+	table := NewTable(string(EncodeTable(pairs)))
+	require.Equal(t, len(pairs), table.Len())
+	// Only the last block was decoded.
+	require.Nil(t, table.blocks[0])
+	require.NotNil(t, table.blocks[2])
+
+	require.Equal(t, 0, NewTable(string(EncodeTable(nil))).Len())
+}
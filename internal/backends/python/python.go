@@ -1,24 +1,31 @@
-// Package python provides backends for Python 2 and 3 using Poetry.
+// Package python provides backends for Python 2 and 3 using Poetry,
+// and for Python 3 using pip, conda, uv, or PDM.
 package python
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
-	"net/http"
 	"os"
 	"path/filepath"
+	"regexp"
 	"sort"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/BurntSushi/toml"
 	"github.com/replit/upm/internal/api"
+	"github.com/replit/upm/internal/backends/python/pyindex"
+	"github.com/replit/upm/internal/config"
+	"github.com/replit/upm/internal/project"
+	"github.com/replit/upm/internal/store"
 	"github.com/replit/upm/internal/util"
 )
 
 // this generates a mapping of pypi packages <-> modules
-// moduleToPypiPackage pypiPackageToModules are provided
+// the data of moduleToPypiPackage pypiPackageToModules are provided
 //go:generate go run ./gen_pypi_map -from pypi_packages.json -pkg python -out pypi_map.gen.go
 
 // pypiEntry represents one element of the response we get from
@@ -33,35 +40,60 @@ type pypiEntry struct {
 // that matches the format of the REST API
 type pypiEntryInfoResponse struct {
 	Info pypiEntryInfo `json:"info"`
+	// The files of the latest release.
+	URLs []pypiReleaseFile `json:"urls"`
+}
+
+// pypiReleaseFile represents a file of a release on PyPI, such as a
+// wheel.
+type pypiReleaseFile struct {
+	Size       int64  `json:"size"`
+	UploadTime string `json:"upload_time_iso_8601"`
 }
 
 // pypiEntryInfo represents the response we get from the
 // PyPI API on doing a single-package lookup.
 type pypiEntryInfo struct {
-	Author        string   `json:"author"`
-	AuthorEmail   string   `json:"author_email"`
-	HomePage      string   `json:"home_page"`
-	License       string   `json:"license"`
-	Name          string   `json:"name"`
-	ProjectURL    string   `json:"project_url"`
-	PackageURL    string   `json:"package_url"`
-	BugTrackerURL string   `json:"bugtrack_url"`
-	DocsURL       string   `json:"docs_url"`
-	RequiresDist  []string `json:"requires_dist"`
-	Summary       string   `json:"summary"`
-	Version       string   `json:"version"`
+	Author        string `json:"author"`
+	AuthorEmail   string `json:"author_email"`
+	HomePage      string `json:"home_page"`
+	License       string `json:"license"`
+	Name          string `json:"name"`
+	ProjectURL    string `json:"project_url"`
+	PackageURL    string `json:"package_url"`
+	BugTrackerURL string `json:"bugtrack_url"`
+	DocsURL       string `json:"docs_url"`
+	Keywords      string `json:"keywords"`
+	// Map from labels such as "Source" to URLs.
+	ProjectURLs   map[string]string `json:"project_urls"`
+	RequiresDist  []string          `json:"requires_dist"`
+	ProvidesExtra []string          `json:"provides_extra"`
+	Summary       string            `json:"summary"`
+	Version       string            `json:"version"`
 }
 
 // pyprojectTOML represents the relevant parts of a pyproject.toml
 // file.
 type pyprojectTOML struct {
-	Tool struct {
+	// Poetry 2 reads the dependencies from here if there are
+	// any, and only uses [tool.poetry] to add to them.
+	Project pep621Project `toml:"project"`
+	Tool    struct {
 		Poetry struct {
 			Name string `json:"name"`
 			// interface{} because they can be either
 			// strings or maps (why?? good lord).
 			Dependencies    map[string]interface{} `json:"dependencies"`
-			DevDependencies map[string]interface{} `json:"dev-dependencies"`
+			DevDependencies map[string]interface{} `toml:"dev-dependencies"`
+			// Dependency groups, which replaced
+			// dev-dependencies in Poetry 1.2.
+			Group map[string]struct {
+				Dependencies map[string]interface{} `toml:"dependencies"`
+			} `toml:"group"`
+			Source []poetrySource `toml:"source"`
+			// Each script is an object to run, or a table
+			// such as {callable = ...}.
+			Scripts map[string]interface{} `toml:"scripts"`
 		} `json:"poetry"`
 	} `json:"tool"`
 }
@@ -79,238 +111,650 @@ type poetryLock struct {
 // a module using a #upm pragma
 type modulePragmas struct {
 	Package string `json:"package"`
+	// The spec to add the package with, as in
+	// "# upm version=^10".
+	Version string `json:"version"`
+	// True if the package is only needed for development.
+	Dev bool `json:"dev"`
+	// True if the module shouldn't be guessed at all, as for
+	// one that the project provides some other way.
+	Ignore bool `json:"ignore"`
+	// The files that import the module.
+	Files []string `json:"files"`
+}
+
+// extrasRegexp matches the extras of a requirement, such as
+// "[security,socks]", at the end of a package name or the start of a
+// spec.
+var extrasRegexp = regexp.MustCompile(`\[\s*([A-Za-z0-9._-]+(?:\s*,\s*[A-Za-z0-9._-]+)*)?\s*\]`)
+
+// splitNameExtras splits a package name such as "requests[security]"
+// into the name itself and its extras.
+func splitNameExtras(name api.PkgName) (api.PkgName, []string) {
+	idx := strings.Index(string(name), "[")
+	if idx < 0 || !strings.HasSuffix(string(name), "]") {
+		return name, nil
+	}
+	m := extrasRegexp.FindStringSubmatch(string(name)[idx:])
+	if m == nil || len(m[0]) != len(name)-idx {
+		return name, nil
+	}
+	return name[:idx], parseExtras(m[1])
+}
+
+// splitSpecExtras splits a spec such as "[security]>=2.31", as in a
+// requirement, into its extras and the rest of the spec.
+func splitSpecExtras(spec api.PkgSpec) ([]string, api.PkgSpec) {
+	trimmed := strings.TrimSpace(string(spec))
+	m := extrasRegexp.FindStringSubmatchIndex(trimmed)
+	if m == nil || m[0] != 0 {
+		return nil, spec
+	}
+	return parseExtras(trimmed[m[2]:m[3]]), api.PkgSpec(strings.TrimSpace(trimmed[m[1]:]))
+}
+
+// parseExtras returns the extras in the given comma-separated list.
+func parseExtras(list string) []string {
+	extras := []string{}
+	for _, extra := range strings.Split(list, ",") {
+		if extra = strings.TrimSpace(extra); extra != "" {
+			extras = append(extras, extra)
+		}
+	}
+	return extras
+}
+
+// formatExtras returns the given extras in brackets, sorted and without
+// duplicates, or the empty string if there are none.
+func formatExtras(extras []string) string {
+	if len(extras) == 0 {
+		return ""
+	}
+	seen := map[string]bool{}
+	unique := []string{}
+	for _, extra := range extras {
+		if !seen[extra] {
+			seen[extra] = true
+			unique = append(unique, extra)
+		}
+	}
+	sort.Strings(unique)
+	return "[" + strings.Join(unique, ",") + "]"
+}
+
+// formatPoetryRequirement returns the argument to 'poetry add' for the
+// given package, which may have extras in its name or at the start of
+// its spec (as ListSpecfile returns them): for example
+// "requests[security] ^2.31". Any markers at the end of the spec are
+// left out; see splitSpecMarkers.
+func formatPoetryRequirement(name api.PkgName, spec api.PkgSpec) string {
+	name, nameExtras := splitNameExtras(name)
+	spec, _ = splitSpecMarkers(spec)
+	specExtras, spec := splitSpecExtras(spec)
+	extras := formatExtras(append(nameExtras, specExtras...))
+
+	// Poetry takes a direct reference or path in place of the
+	// name, with the extras after it, and reads the name from the
+	// package.
+	if strings.HasPrefix(string(spec), "@") {
+		return strings.TrimSpace(string(spec[1:])) + extras
+	}
+	if isPathSpec(spec) {
+		return string(spec) + extras
+	}
+
+	req := string(name) + extras
+
+	// NB: this doesn't work if spec has spaces in it, because of
+	// a bug in Poetry that can't be worked around. It looks like
+	// that bug might be fixed in the 1.0 release though :/
+	if spec != "" {
+		req += " " + string(spec)
+	}
+	return req
 }
 
 // normalizeSpec returns the version string from a Poetry spec, or the
 // empty string. The Poetry spec may be either a string or a
-// map[string]interface{} with a "version" key that is a string. If
-// neither, then the empty string is returned.
+// map[string]interface{} with a "version" key that is a string, or
+// else a "git", "url", or (for local packages) "path" key, for which
+// the direct reference or path is returned instead: for example "@
+// git+https://github.com/pallets/flask.git@2.3.0", as in a requirement.
+// If none of them, then the empty string is returned. If the map has
+// extras, they come first, as in a requirement: for example
+// "[security]^2.31", and any markers come last; see poetryMarkers.
 func normalizeSpec(spec interface{}) string {
 	switch spec := spec.(type) {
 	case string:
 		return spec
 	case map[string]interface{}:
-		switch spec := spec["version"].(type) {
+		extras := []string{}
+		if list, ok := spec["extras"].([]interface{}); ok {
+			for _, extra := range list {
+				if extra, ok := extra.(string); ok {
+					extras = append(extras, extra)
+				}
+			}
+		}
+		markers := poetryMarkers(spec)
+		switch version := spec["version"].(type) {
+		case string:
+			return formatExtras(extras) + version + markers
+		}
+		if ref := poetryDirectReference(spec); ref != "" {
+			// PEP 508 needs a space between a URL and
+			// the markers.
+			return formatDirectSpec(extras, ref) + strings.Replace(markers, ";", " ;", 1)
+		}
+		// Path dependencies (e.g. from 'poetry add
+		// --editable') have no version, so report where they
+		// come from instead.
+		switch path := spec["path"].(type) {
 		case string:
-			return spec
+			return formatExtras(extras) + path + markers
 		}
 	}
 	return ""
 }
 
 // normalizePackageName implements NormalizePackageName for the Python
-// backends.
+// backends. Any extras are dropped, since "requests[security]" is the
+// same package as "requests".
 func normalizePackageName(name api.PkgName) api.PkgName {
+	name, _ = splitNameExtras(name)
 	nameStr := string(name)
 	nameStr = strings.ToLower(nameStr)
 	nameStr = strings.Replace(nameStr, "_", "-", -1)
 	return api.PkgName(nameStr)
 }
 
-// pythonMakeBackend returns a language backend for a given version of
-// Python. name is either "python2" or "python3", and python is the
-// name of an executable (either a full path or just a name like
-// "python3") to use when invoking Python. (This is used to implement
-// UPM_POETRY)
-func pythonMakeBackend(name string, poetry string) api.LanguageBackend {
-	info_func := func(name api.PkgName) api.PkgInfo {
-		res, err := http.Get(fmt.Sprintf("https://pypi.org/pypi/%s/json", string(name)))
+// info implements Info for the Python backends, using the JSON API of
+// PyPI or of the configured index. What is found is cached in the
+// store for --cache-ttl, and used for longer if the index can't be
+// reached.
+func info(ctx context.Context, name api.PkgName) api.PkgInfo {
+	pkgInfo, err := fetchInfo(ctx, name)
+	if err != nil {
+		util.Abort(ctx)
+	}
+	return pkgInfo
+}
 
-		if err != nil {
-			util.Die("HTTP Request failed with error: %s", err)
-		}
+// fetchInfo is like info, but gives up when the given context is done,
+// returning its error.
+func fetchInfo(ctx context.Context, name api.PkgName) (api.PkgInfo, error) {
+	index := pyindex.Get()
+	// The cache is keyed by the normalized name, so that it is
+	// shared however the name is spelled.
+	key := index.InfoURL(normalizePackageName(name))
+	cached, ok := store.GetPkgInfo(key)
+	if ok && cached.Fresh() {
+		store.CountPkgInfoLookup("pypi", true)
+		return cached.Info, nil
+	}
+	store.CountPkgInfoLookup("pypi", false)
 
-		defer res.Body.Close()
+	body, err := index.TryFetchContext(ctx, index.InfoURL(name), "")
+	if err != nil && ctx.Err() != nil {
+		return api.PkgInfo{}, ctx.Err()
+	} else if err != nil && ok {
+		util.Log(fmt.Sprintf("warning: %s; using the information about %s from %s",
+			err, name, cached.Fetched.Local().Format("2006-01-02 15:04")))
+		return cached.Info, nil
+	} else if err != nil {
+		util.Die("%s", err)
+	}
+	pkgInfo := parsePypiInfo(body)
+	store.PutPkgInfo(key, pkgInfo)
+	return pkgInfo, nil
+}
 
-		if res.StatusCode == 404 {
-			return api.PkgInfo{}
-		}
+// parsePypiInfo returns the information about a package in the given
+// response from the JSON API of PyPI, which is nil if there is no such
+// package.
+func parsePypiInfo(body []byte) api.PkgInfo {
+	if body == nil {
+		return api.PkgInfo{}
+	}
 
-		if res.StatusCode != 200 {
-			util.Die("Received status code: %d", res.StatusCode)
-		}
+	var output pypiEntryInfoResponse
+	if err := json.Unmarshal(body, &output); err != nil {
+		util.Die("PyPI response: %s", err)
+	}
 
-		body, err := ioutil.ReadAll(res.Body)
-		if err != nil {
-			util.Die("Res body read failed with error: %s", err)
-		}
+	pkgInfo := api.PkgInfo{
+		Name:             output.Info.Name,
+		Description:      output.Info.Summary,
+		Version:          output.Info.Version,
+		HomepageURL:      output.Info.HomePage,
+		DocumentationURL: output.Info.DocsURL,
+		BugTrackerURL:    output.Info.BugTrackerURL,
+		Author: util.AuthorInfo{
+			Name:  output.Info.Author,
+			Email: output.Info.AuthorEmail,
+		}.String(),
+		License: output.Info.License,
+	}
 
-		var output pypiEntryInfoResponse
-		if err := json.Unmarshal(body, &output); err != nil {
-			util.Die("PyPI response: %s", err)
+	deps := []string{}
+	for _, line := range output.Info.RequiresDist {
+		if strings.Contains(line, "extra ==") {
+			continue
 		}
 
-		info := api.PkgInfo{
-			Name:             output.Info.Name,
-			Description:      output.Info.Summary,
-			Version:          output.Info.Version,
-			HomepageURL:      output.Info.HomePage,
-			DocumentationURL: output.Info.DocsURL,
-			BugTrackerURL:    output.Info.BugTrackerURL,
-			Author: util.AuthorInfo{
-				Name:  output.Info.Author,
-				Email: output.Info.AuthorEmail,
-			}.String(),
-			License: output.Info.License,
+		deps = append(deps, strings.Fields(line)[0])
+	}
+	pkgInfo.Dependencies = deps
+	pkgInfo.DependencyCount = len(deps)
+	pkgInfo.Extras = pypiExtras(output.Info)
+	pkgInfo.SourceCodeURL = pypiSourceURL(output.Info.ProjectURLs)
+	pkgInfo.Keywords = pypiKeywords(output.Info.Keywords)
+	for _, file := range output.URLs {
+		if file.Size > pkgInfo.Size {
+			pkgInfo.Size = file.Size
+		}
+		// The release is as old as its first file.
+		if date := util.FormatTimestamp(file.UploadTime); date != "" &&
+			(pkgInfo.ReleaseDate == "" || date < pkgInfo.ReleaseDate) {
+			pkgInfo.ReleaseDate = date
 		}
+	}
 
-		deps := []string{}
-		for _, line := range output.Info.RequiresDist {
-			if strings.Contains(line, "extra ==") {
-				continue
+	return pkgInfo
+}
+
+// pypiSourceURL returns the URL of the source code among the given
+// project URLs of a package on PyPI, which are labelled however the
+// author likes.
+func pypiSourceURL(projectURLs map[string]string) string {
+	for _, label := range []string{"source", "source code", "repository", "code", "github"} {
+		for key, url := range projectURLs {
+			if strings.EqualFold(strings.TrimSpace(key), label) {
+				return url
 			}
+		}
+	}
+	return ""
+}
 
-			deps = append(deps, strings.Fields(line)[0])
+// pypiKeywords splits the keywords of a package on PyPI, which are
+// separated by commas or, in older packages, by spaces.
+func pypiKeywords(keywords string) []string {
+	var fields []string
+	if strings.Contains(keywords, ",") {
+		fields = strings.Split(keywords, ",")
+	} else {
+		fields = strings.Fields(keywords)
+	}
+	result := []string{}
+	for _, field := range fields {
+		if field = strings.TrimSpace(field); field != "" {
+			result = append(result, field)
 		}
-		info.Dependencies = deps
+	}
+	return result
+}
 
-		return info
+// pypiExtraMarkerRegexp matches the environment marker that makes a
+// requirement of a package on PyPI part of one of its extras.
+var pypiExtraMarkerRegexp = regexp.MustCompile(`extra\s*==\s*['"]([^'"]+)['"]`)
+
+// pypiExtras returns the sorted extras that the given package
+// provides. Not every package declares them, so the extras that its
+// requirements are for count too.
+func pypiExtras(info pypiEntryInfo) []string {
+	seen := map[string]bool{}
+	for _, extra := range info.ProvidesExtra {
+		seen[extra] = true
+	}
+	for _, line := range info.RequiresDist {
+		for _, m := range pypiExtraMarkerRegexp.FindAllStringSubmatch(line, -1) {
+			seen[m[1]] = true
+		}
+	}
+	extras := []string{}
+	for extra := range seen {
+		extras = append(extras, extra)
 	}
+	sort.Strings(extras)
+	return extras
+}
 
-	return api.LanguageBackend{
-		Name:             "python-" + name + "-poetry",
-		Specfile:         "pyproject.toml",
-		Lockfile:         "poetry.lock",
-		FilenamePatterns: []string{"*.py"},
-		Quirks: api.QuirksAddRemoveAlsoLocks |
-			api.QuirksAddRemoveAlsoInstalls,
-		NormalizePackageName: normalizePackageName,
-		GetPackageDir: func() string {
-			// Check if we're already inside an activated
-			// virtualenv. If so, just use it.
-			if venv := os.Getenv("VIRTUAL_ENV"); venv != "" {
-				return venv
-			}
+// searchTimeout is how long the lookups that search makes may take
+// altogether, after which the packages that have been looked up are
+// returned. They are made util.Jobs at a time.
+const searchTimeout = 30 * time.Second
+
+// projectNameRegexp matches the valid names of projects, as in PEP
+// 508.
+var projectNameRegexp = regexp.MustCompile(`^(?i)[a-z0-9]([a-z0-9._-]*[a-z0-9])?$`)
+
+// search implements Search for the Python backends. Packages are
+// found by name in the module map, or in the list of projects of the
+// configured index if it isn't PyPI, and then looked up there. Since
+// the module map is only as new as UPM, a package named exactly as
+// the query is always looked up too, and with --remote, the list of
+// projects of PyPI is searched as well.
+func search(ctx context.Context, query string) []api.PkgInfo {
+	timeout, cancel := context.WithTimeout(ctx, searchTimeout)
+	defer cancel()
+	pkgs := searchContext(timeout, query)
+	util.Abort(ctx)
+	return pkgs
+}
 
-			// Ideally Poetry would provide some way of
-			// actually checking where the virtualenv will
-			// go. But it doesn't. So we have to
-			// reimplement the logic ourselves, which is
-			// totally fragile and disgusting. (No, we
-			// can't use 'poetry run which python' because
-			// that will *create* a virtualenv if one
-			// doesn't exist, and there's no workaround
-			// for that without mutating the global config
-			// file.)
-			//
-			// Note, we don't yet support Poetry's
-			// settings.virtualenvs.in-project. That would
-			// be a pretty easy fix, though. (Why is this
-			// so complicated??)
-
-			outputB := util.GetCmdOutput([]string{
-				poetry, "config", "settings.virtualenvs.path",
-			})
-			var path string
-			if err := json.Unmarshal(outputB, &path); err != nil {
-				util.Die("parsing output from Poetry: %s", err)
+// searchContext is like search, but stops looking packages up when the
+// given context is done, returning those it has looked up so far.
+func searchContext(ctx context.Context, query string) []api.PkgInfo {
+	normQuery := normalizePackageName(api.PkgName(query))
+	var packages []string
+	seen := map[api.PkgName]bool{}
+	addPackage := func(p string) {
+		if norm := normalizePackageName(api.PkgName(p)); !seen[norm] {
+			seen[norm] = true
+			packages = append(packages, p)
+		}
+	}
+	searchProjects := func(index pyindex.Index) {
+		for _, p := range index.ListProjects() {
+			if strings.Contains(string(normalizePackageName(p)), string(normQuery)) {
+				addPackage(string(p))
 			}
+		}
+	}
 
-			base := ""
-			if util.Exists("pyproject.toml") {
-				var cfg pyprojectTOML
-				if _, err := toml.DecodeFile("pyproject.toml", &cfg); err != nil {
-					util.Die("%s", err.Error())
-				}
-				base = cfg.Tool.Poetry.Name
+	// Do a search on the module map
+	if index := pyindex.Get(); index.IsPyPI() {
+		if projectNameRegexp.MatchString(query) {
+			addPackage(query)
+		}
+		for _, p := range knownPackages() {
+			if strings.Contains(p, query) {
+				addPackage(p)
 			}
+		}
+		if config.RemoteSearch {
+			searchProjects(index)
+		}
+	} else {
+		searchProjects(index)
+	}
 
-			if base == "" {
-				cwd, err := os.Getwd()
-				if err != nil {
-					util.Die("%s", err)
+	// Lookup the package info for each result, a few at a time
+	names := make(chan api.PkgName)
+	packageQueries := make(chan api.PkgInfo, len(packages))
+	var barrier sync.WaitGroup
+	for i := 0; i < util.Jobs() && i < len(packages); i++ {
+		barrier.Add(1)
+		go func() {
+			defer barrier.Done()
+			for name := range names {
+				if pkg, err := fetchInfo(ctx, name); err == nil {
+					packageQueries <- pkg
 				}
-				base = strings.ToLower(filepath.Base(cwd))
 			}
+		}()
+	}
+feed:
+	for _, p := range packages {
+		select {
+		case names <- api.PkgName(p):
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(names)
+	barrier.Wait()
+	close(packageQueries)
+	if ctx.Err() != nil {
+		util.Log(fmt.Sprintf("warning: %s; %d of %d packages weren't looked up",
+			ctx.Err(), len(packages)-len(packageQueries), len(packages)))
+	}
 
-			version := strings.TrimSpace(string(util.GetCmdOutput([]string{
-				poetry, "-c",
-				`import sys; print(".".join(map(str, sys.version_info[:2])))`,
-			})))
+	results := []api.PkgInfo{}
+	for pkg := range packageQueries {
+		// The index may list projects that have no releases.
+		if pkg.Name != "" {
+			results = append(results, pkg)
+		}
+	}
 
-			return filepath.Join(path, base+"-py"+version)
-		},
-		Search: func(query string) []api.PkgInfo {
-			// Do a search on pypiPackageToModules
-			var packages []string
-			for p, _ := range pypiPackageToModules() {
-				if strings.Contains(p, query) {
-					packages = append(packages, p)
-				}
-			}
+	// The results come back in no particular order, so packages
+	// with the same number of downloads are sorted by name. A
+	// package named exactly as the query comes first, since it may
+	// be too new to have a download count.
+	sort.Slice(results, func(i, j int) bool {
+		exactI := normalizePackageName(api.PkgName(results[i].Name)) == normQuery
+		exactJ := normalizePackageName(api.PkgName(results[j].Name)) == normQuery
+		if exactI != exactJ {
+			return exactI
+		}
+		downloadsI := downloadCount(results[i].Name)
+		downloadsJ := downloadCount(results[j].Name)
+		if downloadsI != downloadsJ {
+			return downloadsI > downloadsJ
+		}
+		return results[i].Name < results[j].Name
+	})
 
-			// Lookup the package info for each result
-			var barrier sync.WaitGroup
-			packageQueries := make(chan api.PkgInfo, len(packages))
-			for _, p := range packages {
-				barrier.Add(1)
-				go func(name api.PkgName) {
-					packageQueries <- info_func(name)
-					barrier.Done()
-				}(api.PkgName(p))
-			}
-			barrier.Wait()
-			close(packageQueries)
+	return results
+}
+
+// pythonGuessRegexps is the GuessRegexps value for the Python
+// backends.
+var pythonGuessRegexps = util.Regexps([]string{
+	// The (?:.|\\\n) subexpression allows us to
+	// match match multiple lines if
+	// backslash-escapes are used on the newlines.
+	`from (?:.|\\\n) import`,
+	`import ((?:.|\\\n)*) as`,
+	`import ((?:.|\\\n)*)`,
+})
+
+// pythonMakeBackend returns a language backend for a given version of
+// Python. name is either "python2" or "python3", and poetry is the
+// name of the Poetry executable (either a full path or just a name
+// like "poetry"). (This is used to implement UPM_POETRY) Any version
+// from 0.12 to 2.x will do.
+func pythonMakeBackend(name string, poetry string) api.LanguageBackend {
+	python := getPython3()
+
+	getPackageDir := func() string {
+		// PEP 582 mode doesn't use a virtualenv at
+		// all.
+		if usePypackages() {
+			return pypackagesDir
+		}
 
-			results := []api.PkgInfo{}
-			for pkg := range packageQueries {
-				results = append(results, pkg)
+		// Check if we're already inside an activated
+		// virtualenv. If so, just use it.
+		if venv := os.Getenv("VIRTUAL_ENV"); venv != "" {
+			return venv
+		}
+
+		// This is also how isolatePackageDir tells Poetry to
+		// put the virtualenv in the project.
+		if poetryUsesInProjectVenv(poetry) {
+			return ".venv"
+		}
+
+		// If the virtualenv exists already, Poetry can tell
+		// us where it is.
+		if path := poetryEnvPath(poetry); path != "" {
+			return path
+		}
+
+		// Otherwise, we have to work out where Poetry will
+		// create it. (No, we can't use 'poetry run which
+		// python' because that will *create* a virtualenv
+		// if one doesn't exist.)
+		path := poetryConfig(context.Background(), poetry, "virtualenvs.path")
+		if path == "" {
+			util.Die("Poetry has no virtualenvs.path setting")
+		}
+
+		cwd, err := os.Getwd()
+		if err != nil {
+			util.Die("%s", err)
+		}
+
+		base := pyprojectName()
+		if base == "" {
+			base = filepath.Base(cwd)
+		}
+
+		version := getPythonVersion(context.Background(), python)
+
+		return filepath.Join(path, poetryEnvName(poetry, base, cwd)+"-py"+version)
+	}
+
+	getExecEnv := func() api.ExecEnv {
+		return pythonExecEnv(python, getPackageDir())
+	}
+
+	// getInstalledPackageDir returns the directory of the (first)
+	// module provided by the given package, inside site-packages.
+	getInstalledPackageDir := func(name api.PkgName) string {
+		mod := strings.Replace(string(normalizePackageName(name)), "-", "_", -1)
+		if mods, ok := packageModules(normalizePackageName(name)); ok && len(mods) > 0 {
+			mod = mods[0]
+		}
+
+		if usePypackages() {
+			return filepath.Join(pypackagesLibDir(context.Background(), python), mod)
+		}
+		return filepath.Join(
+			getPackageDir(), "lib", "python"+getPythonVersion(context.Background(), python),
+			"site-packages", mod,
+		)
+	}
+
+	// isolatePackageDir makes Poetry use a virtualenv in the
+	// project, rather than the activated one or one in its cache.
+	isolatePackageDir := func() func() {
+		venv, hadVenv := os.LookupEnv("VIRTUAL_ENV")
+		inProject, hadInProject := os.LookupEnv("POETRY_VIRTUALENVS_IN_PROJECT")
+		os.Unsetenv("VIRTUAL_ENV")
+		os.Setenv("POETRY_VIRTUALENVS_IN_PROJECT", "true")
+		return func() {
+			if hadVenv {
+				os.Setenv("VIRTUAL_ENV", venv)
 			}
+			if hadInProject {
+				os.Setenv("POETRY_VIRTUALENVS_IN_PROJECT", inProject)
+			} else {
+				os.Unsetenv("POETRY_VIRTUALENVS_IN_PROJECT")
+			}
+		}
+	}
 
-			sort.Slice(results, func(i, j int) bool {
-				return pypiPackageToDownloads()[results[i].Name] > pypiPackageToDownloads()[results[j].Name]
-			})
+	// add implements Add, with the given extra arguments to
+	// 'poetry add'.
+	add := func(ctx context.Context, pkgs map[api.PkgName]api.PkgSpec, projectName string, args []string) {
+		initSpecfile(ctx, poetry, projectName)
+		usePoetrySource(ctx, poetry)
 
-			return results
-		},
-		Info: info_func,
-		Add: func(pkgs map[api.PkgName]api.PkgSpec, projectName string) {
-			// Initalize the specfile if it doesnt exist
-			if !util.Exists("pyproject.toml") {
-				cmd := []string{poetry, "init", "--no-interaction"}
-
-				if projectName != "" {
-					cmd = append(cmd, "--name", projectName)
-				}
+		// 'poetry add' applies its markers to every package,
+		// so packages with different ones are added
+		// separately.
+		specs := map[string][]string{}
+		markerArgs := map[string][]string{}
+		for name, spec := range pkgs {
+			_, markers := splitSpecMarkers(spec)
+			key := strings.Join(markers, "\x00")
+			specs[key] = append(specs[key], formatPoetryRequirement(name, spec))
+			markerArgs[key] = markers
+		}
+		keys := []string{}
+		for key := range specs {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
 
-				util.RunCmd(cmd)
+		for _, key := range keys {
+			sort.Strings(specs[key])
+			args := append(append([]string{}, args...), markerArgs[key]...)
+			if usePypackages() {
+				pypackagesAdd(ctx, poetry, python, append(args, specs[key]...))
+				continue
 			}
+			cmd := append([]string{poetry, "add"}, args...)
+			util.RunCmd(ctx, append(cmd, specs[key]...))
+		}
+	}
 
-			cmd := []string{poetry, "add"}
-			for name, spec := range pkgs {
-				name := string(name)
-				spec := string(spec)
-
-				// NB: this doesn't work if spec has
-				// spaces in it, because of a bug in
-				// Poetry that can't be worked around.
-				// It looks like that bug might be
-				// fixed in the 1.0 release though :/
-				if spec != "" {
-					cmd = append(cmd, name+" "+spec)
-				} else {
-					cmd = append(cmd, name)
-				}
+	return api.LanguageBackend{
+		Name:             "python-" + name + "-poetry",
+		Specfile:         "pyproject.toml",
+		Lockfile:         "poetry.lock",
+		LockfileComment:  "#",
+		OSVEcosystem:     "PyPI",
+		Registry:         indexRegistry,
+		FilenamePatterns: []string{"*.py", "*.ipynb"},
+		Quirks: api.QuirksAddRemoveAlsoLocks |
+			api.QuirksAddRemoveAlsoInstalls,
+		Executables:            []string{poetry},
+		NormalizePackageName:   normalizePackageName,
+		GetPackageDir:          getPackageDir,
+		GetExecEnv:             getExecEnv,
+		GetInstalledPackageDir: getInstalledPackageDir,
+		IsolatePackageDir:      isolatePackageDir,
+		Search:                 search,
+		Info:                   info,
+		Downloads:              downloads,
+		Add: func(ctx context.Context, pkgs map[api.PkgName]api.PkgSpec, projectName string) {
+			add(ctx, pkgs, projectName, nil)
+		},
+		AddToGroup: func(ctx context.Context, pkgs map[api.PkgName]api.PkgSpec, projectName string, group string) {
+			add(ctx, pkgs, projectName, poetryGroupArgs(ctx, poetry, group))
+		},
+		InitSpecfile: func(ctx context.Context, projectName string) {
+			initSpecfile(ctx, poetry, projectName)
+		},
+		AddEditable: func(ctx context.Context, path string, projectName string) {
+			if usePypackages() {
+				util.Die("editable installs are not supported with %s", pypackagesDir)
 			}
-			util.RunCmd(cmd)
+			requirePoetry(ctx, poetry, "1.2", "editable installs")
+			initSpecfile(ctx, poetry, projectName)
+			util.RunCmd(ctx, []string{poetry, "add", "--editable", path})
 		},
-		Remove: func(pkgs map[api.PkgName]bool) {
+		Remove: func(ctx context.Context, pkgs map[api.PkgName]bool) {
+			if usePypackages() {
+				pypackagesRemove(ctx, poetry, python, pkgs)
+				return
+			}
+
 			cmd := []string{poetry, "remove"}
 			for name, _ := range pkgs {
 				cmd = append(cmd, string(name))
 			}
-			util.RunCmd(cmd)
+			util.RunCmd(ctx, cmd)
+		},
+		Lock: func(ctx context.Context) {
+			usePoetrySource(ctx, poetry)
+			util.RunCmd(ctx, poetryLockCmd(ctx, poetry))
+		},
+		Upgrade: func(ctx context.Context, pkgs map[api.PkgName]bool) {
+			usePoetrySource(ctx, poetry)
+			util.RunCmd(ctx, poetryUpgradeCmd(ctx, poetry, pkgs))
 		},
-		Lock: func() {
-			util.RunCmd([]string{poetry, "lock", "--no-update"})
+		Update: func(ctx context.Context, pkgs map[api.PkgName]bool, latest bool) {
+			poetryUpdate(ctx, poetry, python, pkgs, latest)
 		},
-		Install: func() {
+		Install: func(ctx context.Context) {
+			usePoetrySource(ctx, poetry)
+			if usePypackages() {
+				installPypackages(ctx, python, false)
+				return
+			}
+
 			// Unfortunately, this doesn't necessarily uninstall
 			// packages that have been removed from the lockfile,
 			// which happens for example if 'poetry remove' is
 			// interrupted. See
 			// <https://github.com/sdispater/poetry/issues/648>.
-			util.RunCmd([]string{poetry, "-m", "poetry", "install"})
+			util.RunCmd(ctx, []string{poetry, "install"})
 		},
 		ListSpecfile: func() map[api.PkgName]api.PkgSpec {
 			pkgs, err := listSpecfile()
@@ -320,73 +764,253 @@ func pythonMakeBackend(name string, poetry string) api.LanguageBackend {
 
 			return pkgs
 		},
-		ListLockfile: func() map[api.PkgName]api.PkgVersion {
-			var cfg poetryLock
-			if _, err := toml.DecodeFile("poetry.lock", &cfg); err != nil {
+		ListSpecfileGroups: func() map[api.PkgName]string {
+			groups, err := listSpecfileGroups()
+			if err != nil {
 				util.Die("%s", err.Error())
 			}
-			pkgs := map[api.PkgName]api.PkgVersion{}
-			for _, pkgObj := range cfg.Package {
-				name := api.PkgName(pkgObj.Name)
-				version := api.PkgVersion(pkgObj.Version)
-				pkgs[name] = version
-			}
-			return pkgs
+
+			return groups
+		},
+		SpecSource:      specSource,
+		LegacySpecfiles: setuptoolsSpecfiles,
+		ListLegacySpecfile: func() map[api.PkgName]api.PkgSpec {
+			return listSetuptools(getPython3())
+		},
+		CheckRuntime: func() (string, []api.PkgName) {
+			return checkPython("pyproject.toml", listSpecfile)
+		},
+		UpgradeRuntime: upgradePython,
+		Diagnose: func() []api.Problem {
+			return diagnoseVirtualenv(".venv")
+		},
+		ListLockfile:             listLockfile,
+		ListLockfileDependencies: listLockfileDependencies,
+		ExportLockfile: func(ctx context.Context, groups []string, file string) {
+			usePoetrySource(ctx, poetry)
+			util.RunCmd(ctx, poetryExportCmd(ctx, poetry, groups, file))
+		},
+		ListInstalled: func() map[api.PkgName]api.InstalledPkg {
+			return listInstalled(sitePackagesDirs(python, getPackageDir()), pyprojectName())
+		},
+		ListScripts:   listPoetryScripts,
+		RunScript:     runScript(poetry),
+		GuessRegexps:  pythonGuessRegexps,
+		GuessCacheKey: mapOverlayCacheKey,
+		Guess: func(ctx context.Context) (map[api.PkgName]bool, bool) {
+			return guess(ctx, python, listSpecfile)
+		},
+		GuessDetails: func(ctx context.Context) (map[api.PkgName]api.GuessedPkg, bool) {
+			return guessDetails(ctx, python, listSpecfile)
 		},
-		GuessRegexps: util.Regexps([]string{
-			// The (?:.|\\\n) subexpression allows us to
-			// match match multiple lines if
-			// backslash-escapes are used on the newlines.
-			`from (?:.|\\\n) import`,
-			`import ((?:.|\\\n)*) as`,
-			`import ((?:.|\\\n)*)`,
-		}),
-		Guess: func() (map[api.PkgName]bool, bool) { return guess(poetry) },
 	}
 }
 
-func listSpecfile() (map[api.PkgName]api.PkgSpec, error) {
+// initSpecfile initializes pyproject.toml if it doesn't exist yet.
+func initSpecfile(ctx context.Context, poetry string, projectName string) {
+	if util.Exists("pyproject.toml") {
+		return
+	}
+
+	cmd := []string{poetry, "init", "--no-interaction"}
+
+	if projectName != "" {
+		cmd = append(cmd, "--name", projectName)
+	}
+
+	util.RunCmd(ctx, cmd)
+}
+
+// poetryDependencyGroups returns the tables of dependencies in the
+// given pyproject.toml by the name of their group, with the empty name
+// for the main dependencies. The dev-dependencies of Poetry before 1.2
+// count as the "dev" group.
+func poetryDependencyGroups(cfg *pyprojectTOML) map[string]map[string]interface{} {
+	groups := map[string]map[string]interface{}{
+		"": cfg.Tool.Poetry.Dependencies,
+	}
+	for name, group := range cfg.Tool.Poetry.Group {
+		groups[name] = group.Dependencies
+	}
+	if len(cfg.Tool.Poetry.DevDependencies) > 0 {
+		dev := map[string]interface{}{}
+		for name, spec := range groups["dev"] {
+			dev[name] = spec
+		}
+		for name, spec := range cfg.Tool.Poetry.DevDependencies {
+			dev[name] = spec
+		}
+		groups["dev"] = dev
+	}
+	return groups
+}
+
+// sortedGroupNames returns the names of the given groups, with the
+// main dependencies last, so that they take precedence over a group
+// that has the same package.
+func sortedGroupNames(groups map[string]map[string]interface{}) []string {
+	names := []string{}
+	for name := range groups {
+		if name != "" {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return append(names, "")
+}
+
+// readPyproject reads pyproject.toml.
+func readPyproject() (*pyprojectTOML, error) {
 	var cfg pyprojectTOML
 	if _, err := toml.DecodeFile("pyproject.toml", &cfg); err != nil {
 		return nil, err
 	}
-	pkgs := map[api.PkgName]api.PkgSpec{}
-	for nameStr, spec := range cfg.Tool.Poetry.Dependencies {
-		if nameStr == "python" {
-			continue
+	return &cfg, nil
+}
+
+func listSpecfile() (map[api.PkgName]api.PkgSpec, error) {
+	cfg, err := readPyproject()
+	if err != nil {
+		return nil, err
+	}
+	pkgs := listRequirements(strings.Join(cfg.Project.requirements(), "\n"))
+	// A package may be listed in both places, under names that
+	// only differ once normalized, in which case [tool.poetry]
+	// has the more specific spec.
+	addPoetryPkg := func(name api.PkgName, spec api.PkgSpec) {
+		for other := range pkgs {
+			if normalizePackageName(other) == normalizePackageName(name) {
+				delete(pkgs, other)
+			}
 		}
+		pkgs[name] = spec
+	}
+	groups := poetryDependencyGroups(cfg)
+	for _, group := range sortedGroupNames(groups) {
+		for nameStr, spec := range groups[group] {
+			if nameStr == "python" {
+				continue
+			}
 
-		specStr := normalizeSpec(spec)
-		if specStr == "" {
-			continue
+			specStr := normalizeSpec(spec)
+			if specStr == "" {
+				continue
+			}
+			addPoetryPkg(api.PkgName(nameStr), api.PkgSpec(specStr))
 		}
-		pkgs[api.PkgName(nameStr)] = api.PkgSpec(specStr)
 	}
-	for nameStr, spec := range cfg.Tool.Poetry.DevDependencies {
-		if nameStr == "python" {
+
+	return pkgs, nil
+}
+
+// listSpecfileGroups returns the dependency group of each package in
+// pyproject.toml that is only in a group, rather than among the main
+// dependencies.
+func listSpecfileGroups() (map[api.PkgName]string, error) {
+	cfg, err := readPyproject()
+	if err != nil {
+		return nil, err
+	}
+	main := map[api.PkgName]bool{}
+	for name := range listRequirements(strings.Join(cfg.Project.requirements(), "\n")) {
+		main[normalizePackageName(name)] = true
+	}
+	groups := poetryDependencyGroups(cfg)
+	for name := range groups[""] {
+		main[normalizePackageName(api.PkgName(name))] = true
+	}
+
+	pkgs := map[api.PkgName]string{}
+	for _, group := range sortedGroupNames(groups) {
+		if group == "" {
 			continue
 		}
+		for name := range groups[group] {
+			if !main[normalizePackageName(api.PkgName(name))] {
+				pkgs[api.PkgName(name)] = group
+			}
+		}
+	}
+	return pkgs, nil
+}
 
-		specStr := normalizeSpec(spec)
-		if specStr == "" {
-			continue
+func listLockfile() map[api.PkgName]api.PkgVersion {
+	var cfg poetryLock
+	if _, err := toml.DecodeFile("poetry.lock", &cfg); err != nil {
+		util.Die("%s", err.Error())
+	}
+	pkgs := map[api.PkgName]api.PkgVersion{}
+	for _, pkgObj := range cfg.Package {
+		name := api.PkgName(pkgObj.Name)
+		version := api.PkgVersion(pkgObj.Version)
+		pkgs[name] = version
+	}
+	return pkgs
+}
+
+// listLockfileDependencies implements ListLockfileDependencies for the
+// Poetry backend.
+func listLockfileDependencies() map[api.PkgName][]api.PkgName {
+	var cfg struct {
+		Package []struct {
+			Name         string                 `toml:"name"`
+			Dependencies map[string]interface{} `toml:"dependencies"`
+		} `toml:"package"`
+	}
+	if _, err := toml.DecodeFile("poetry.lock", &cfg); err != nil {
+		util.Die("%s", err.Error())
+	}
+	deps := map[api.PkgName][]api.PkgName{}
+	for _, pkg := range cfg.Package {
+		names := []api.PkgName{}
+		for name := range pkg.Dependencies {
+			names = append(names, api.PkgName(name))
 		}
-		pkgs[api.PkgName(nameStr)] = api.PkgSpec(specStr)
+		sort.Slice(names, func(i, j int) bool { return names[i] < names[j] })
+		deps[api.PkgName(pkg.Name)] = names
 	}
+	return deps
+}
 
-	return pkgs, nil
+// guess implements Guess for the Python backends, running the given
+// Python. Modules provided by the packages that listSpecfile returns
+// are not guessed again.
+func guess(ctx context.Context, python string, listSpecfile func() (map[api.PkgName]api.PkgSpec, error)) (map[api.PkgName]bool, bool) {
+	details, success := guessDetails(ctx, python, listSpecfile)
+	pkgs := map[api.PkgName]bool{}
+	for name := range details {
+		pkgs[name] = true
+	}
+	return pkgs, success
 }
 
-func guess(python string) (map[api.PkgName]bool, bool) {
-	tempdir := util.TempDir()
-	defer os.RemoveAll(tempdir)
+// guessDetails implements GuessDetails for the Python backends, like
+// guess. A package named by project.OverridesFile, which comes before
+// anything else, a pragma, or an overlay is certain;
+// otherwise, the confidence is the one that the map of PyPI gives it,
+// which is higher for a package whose name is the module's than for
+// one that was only picked for being the most popular to provide it.
+func guessDetails(ctx context.Context, python string, listSpecfile func() (map[api.PkgName]api.PkgSpec, error)) (map[api.PkgName]api.GuessedPkg, bool) {
+	tempdir := util.TempDir("upm")
+	defer util.RemoveTemp(tempdir)
 
 	util.WriteResource("/python/pipreqs.py", tempdir)
 	script := util.WriteResource("/python/bare-imports.py", tempdir)
 
-	outputB := util.GetCmdOutput([]string{
-		python, script, strings.Join(util.IgnoredPaths, " "),
-	})
+	cmd := []string{python, script, strings.Join(util.IgnoredPaths, " ")}
+	if util.ScanOnly != nil {
+		files := []string{}
+		for file := range util.ScanOnly {
+			files = append(files, file+"\n")
+		}
+		sort.Strings(files)
+		only := filepath.Join(tempdir, "scan-only.txt")
+		if err := ioutil.WriteFile(only, []byte(strings.Join(files, "")), 0666); err != nil {
+			util.Die("%s", err)
+		}
+		cmd = append(cmd, only)
+	}
+	outputB := util.GetCmdOutput(ctx, cmd)
 
 	var output struct {
 		Imports map[string]modulePragmas `json:"imports"`
@@ -400,39 +1024,116 @@ func guess(python string) (map[api.PkgName]bool, bool) {
 	availMods := map[string]bool{}
 
 	if knownPkgs, err := listSpecfile(); err == nil {
+		known := map[api.PkgName]bool{}
 		for pkgName := range knownPkgs {
-			mods, ok := pypiPackageToModules()[string(pkgName)]
+			known[normalizePackageName(pkgName)] = true
+			mods, ok := packageModules(pkgName)
 			if ok {
-				for _, mod := range strings.Split(mods, ",") {
+				for _, mod := range mods {
 					availMods[mod] = true
 				}
 			}
 		}
+		for mod, pkg := range project.Overrides("python") {
+			if known[normalizePackageName(api.PkgName(pkg))] {
+				availMods[mod] = true
+			}
+		}
 	}
 
-	pkgs := map[api.PkgName]bool{}
+	pkgs := map[api.PkgName]api.GuessedPkg{}
+	addPkg := func(name api.PkgName, pragmas modulePragmas, confidence float64) {
+		name = normalizePackageName(name)
+		pkg := pkgs[name]
+		pkg.Files = append(pkg.Files, pragmas.Files...)
+		if confidence > pkg.Confidence {
+			pkg.Confidence = confidence
+		}
+		if pragmas.Version != "" {
+			pkg.Spec = api.PkgSpec(pragmas.Version)
+		}
+		pkg.Dev = pkg.Dev || pragmas.Dev
+		pkgs[name] = pkg
+	}
+
+	// With --explain, say why each package is guessed, in a
+	// consistent order.
+	//
+	// The imports of a module and of its submodules, as of flask
+	// and flask.Flask, are explained once.
+	explained := map[string]bool{}
+	explain := func(modname string, pkg string, why string) {
+		if config.Explain && !explained[pkg+" "+modname] {
+			explained[pkg+" "+modname] = true
+			util.Log(fmt.Sprintf("guessing %s for the import of %s: %s", pkg, modname, why))
+		}
+	}
+	modnames := []string{}
+	for modname := range output.Imports {
+		modnames = append(modnames, modname)
+	}
+	sort.Strings(modnames)
 
-	for modname, pragmas := range output.Imports {
-		// provided by an existing package or perhaps by the system
-		if availMods[modname] {
+	// Dotted imports, as of google.cloud.storage, are provided
+	// if any prefix of them is.
+	isAvailable := func(modname string) bool {
+		for prefix := modname; ; {
+			if availMods[prefix] {
+				return true
+			}
+			dot := strings.LastIndexByte(prefix, '.')
+			if dot < 0 {
+				return false
+			}
+			prefix = prefix[:dot]
+		}
+	}
+
+	for _, modname := range modnames {
+		pragmas := output.Imports[modname]
+		// provided by an existing package or perhaps by the
+		// system, or explicitly not wanted
+		if isAvailable(modname) || pragmas.Ignore {
 			continue
 		}
 
-		// If this module has a package pragma, use that
-		if pragmas.Package != "" {
-			name := api.PkgName(pragmas.Package)
-			pkgs[normalizePackageName(name)] = true
+		// The overrides of the project come first, then a
+		// package pragma of this module
+		if pkg, mod, ok := overridePackage(modname); ok {
+			addPkg(api.PkgName(pkg), pragmas, 1)
+			explain(mod, pkg, project.OverridesFile+" says so")
+
+		} else if pragmas.Package != "" {
+			addPkg(api.PkgName(pragmas.Package), pragmas, 1)
+			explain(modname, pragmas.Package, "a #upm pragma says so")
 
 		} else {
 			// Otherwise, try and look it up in Pypi
-			pkg, ok := moduleToPypiPackage()[modname]
+			pkg, mod, confidence, ok := resolveModule(modname)
 			if ok {
-				name := api.PkgName(pkg)
-				pkgs[normalizePackageName(name)] = true
+				addPkg(api.PkgName(pkg), pragmas, confidence)
+				if config.Explain && confidence < config.MinConfidence {
+					util.Log(fmt.Sprintf("not guessing %s for the import of %s: %s (confidence %.2f, below --min-confidence %.2f)",
+						pkg, mod, explainModulePackage(mod, pkg), confidence, config.MinConfidence))
+				} else if config.Explain {
+					explain(mod, pkg, fmt.Sprintf("%s (confidence %.2f)",
+						explainModulePackage(mod, pkg), confidence))
+				}
 			}
 		}
 	}
 
+	for name, pkg := range pkgs {
+		sort.Strings(pkg.Files)
+		files := []string{}
+		for i, file := range pkg.Files {
+			if i == 0 || file != pkg.Files[i-1] {
+				files = append(files, file)
+			}
+		}
+		pkg.Files = files
+		pkgs[name] = pkg
+	}
 	return pkgs, output.Success
 }
 
@@ -0,0 +1,132 @@
+package python
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/replit/upm/internal/api"
+)
+
+func TestNormalizePackageNameWithExtras(t *testing.T) {
+	require.Equal(t, api.PkgName("requests"), normalizePackageName("Requests[security]"))
+	require.Equal(t, api.PkgName("zope-interface"), normalizePackageName("zope_interface"))
+	require.Equal(t, api.PkgName("odd[name"), normalizePackageName("odd[name"))
+}
+
+func TestFormatPoetryRequirement(t *testing.T) {
+	for expected, pkg := range map[string][2]string{
+		"requests":                       {"requests", ""},
+		"requests ^2.31":                 {"requests", "^2.31"},
+		"requests[security]":             {"requests[security]", ""},
+		"requests[security,socks] ^2.31": {"requests[socks]", "[security] ^2.31"},
+		"uvicorn[standard] >=0.29":       {"uvicorn", "[standard]>=0.29"},
+	} {
+		actual := formatPoetryRequirement(api.PkgName(pkg[0]), api.PkgSpec(pkg[1]))
+		require.Equal(t, expected, actual, pkg)
+	}
+}
+
+func TestNormalizeSpecWithExtras(t *testing.T) {
+	require.Equal(t, "[security,socks]^2.31", normalizeSpec(map[string]interface{}{
+		"version": "^2.31",
+		"extras":  []interface{}{"socks", "security"},
+	}))
+	require.Equal(t, "^2.31", normalizeSpec(map[string]interface{}{
+		"version": "^2.31",
+	}))
+}
+
+func TestPypiExtras(t *testing.T) {
+	require.Equal(t, []string{"security", "socks", "use-chardet-on-py3"}, pypiExtras(pypiEntryInfo{
+		ProvidesExtra: []string{"security", "socks"},
+		RequiresDist: []string{
+			"charset-normalizer<4,>=2",
+			`PySocks!=1.5.7,>=1.5.6; extra == "socks"`,
+			`chardet<6,>=3.0.2; extra == 'use-chardet-on-py3'`,
+		},
+	}))
+}
+
+func TestNormalizeSpecWithSources(t *testing.T) {
+	require.Equal(t, "@ git+https://github.com/pallets/flask.git@2.3.0", normalizeSpec(map[string]interface{}{
+		"git": "https://github.com/pallets/flask.git",
+		"tag": "2.3.0",
+	}))
+	require.Equal(t, "[dotenv] @ git+ssh://git@github.com:pallets/flask.git#subdirectory=src", normalizeSpec(map[string]interface{}{
+		"git":          "git@github.com:pallets/flask.git",
+		"subdirectory": "src",
+		"extras":       []interface{}{"dotenv"},
+	}))
+	require.Equal(t, "@ https://example.com/flask-2.3.0.tar.gz", normalizeSpec(map[string]interface{}{
+		"url": "https://example.com/flask-2.3.0.tar.gz",
+	}))
+	require.Equal(t, "../flask", normalizeSpec(map[string]interface{}{
+		"path":    "../flask",
+		"develop": true,
+	}))
+}
+
+func TestFormatPoetryRequirementWithSources(t *testing.T) {
+	require.Equal(t, "git+https://github.com/pallets/flask.git@2.3.0[dotenv]",
+		formatPoetryRequirement("flask", "[dotenv] @ git+https://github.com/pallets/flask.git@2.3.0"))
+	require.Equal(t, "https://example.com/flask-2.3.0.tar.gz",
+		formatPoetryRequirement("flask", "@ https://example.com/flask-2.3.0.tar.gz"))
+	require.Equal(t, "../flask[dotenv]", formatPoetryRequirement("flask[dotenv]", "../flask"))
+}
+
+func TestSpecSource(t *testing.T) {
+	for spec, expected := range map[api.PkgSpec]string{
+		"^2.31":                          "",
+		"[security]>=2.31":               "",
+		">=2.31; python_version < '3.8'": "",
+		"@ git+https://github.com/pallets/flask.git": "git",
+		"[dotenv] @ hg+https://example.com/flask":    "hg",
+		"@ https://example.com/flask-2.3.0.tar.gz":   "url",
+		"@ file:///home/me/flask":                    "path",
+		"../flask":                                   "path",
+		"./flask":                                    "path",
+		"libs/flask":                                 "path",
+	} {
+		require.Equal(t, expected, specSource(spec), spec)
+	}
+}
+
+func TestNormalizeSpecWithMarkers(t *testing.T) {
+	require.Equal(t, "^1.0; python ^3.8; sys_platform == 'win32'", normalizeSpec(map[string]interface{}{
+		"version": "^1.0",
+		"python":  "^3.8",
+		"markers": "sys_platform == 'win32'",
+	}))
+	require.Equal(t, "[socks]^2.31; platform linux", normalizeSpec(map[string]interface{}{
+		"version":  "^2.31",
+		"extras":   []interface{}{"socks"},
+		"platform": "linux",
+	}))
+	require.Equal(t, "@ git+https://github.com/pallets/flask.git ; python >=3.8", normalizeSpec(map[string]interface{}{
+		"git":    "https://github.com/pallets/flask.git",
+		"python": ">=3.8",
+	}))
+}
+
+func TestSplitSpecMarkers(t *testing.T) {
+	spec, args := splitSpecMarkers("^1.0; python ^3.8; sys_platform == 'win32'")
+	require.Equal(t, api.PkgSpec("^1.0"), spec)
+	require.Equal(t, []string{"--python", "^3.8", "--markers", "sys_platform == 'win32'"}, args)
+
+	spec, args = splitSpecMarkers(">=1.0; python_version < '3.8'; platform_machine == 'x86_64'")
+	require.Equal(t, api.PkgSpec(">=1.0"), spec)
+	require.Equal(t, []string{"--markers", "(python_version < '3.8') and (platform_machine == 'x86_64')"}, args)
+
+	spec, args = splitSpecMarkers("^2.31")
+	require.Equal(t, api.PkgSpec("^2.31"), spec)
+	require.Empty(t, args)
+
+	require.Equal(t, "requests[socks] ^2.31", formatPoetryRequirement("requests", "[socks]^2.31; platform linux"))
+}
+
+func TestExportCmd(t *testing.T) {
+	require.Equal(t, []string{"uv", "export", "--no-dev"}, exportCmd([]string{"uv", "export"}, "--no-dev", nil))
+	require.Equal(t, []string{"pdm", "export", "--group", "docs"},
+		exportCmd([]string{"pdm", "export"}, "--prod", []string{"dev", "docs"}))
+}
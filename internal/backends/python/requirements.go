@@ -0,0 +1,279 @@
+package python
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/replit/upm/internal/api"
+)
+
+// requirementRegexp matches a requirement in a requirements file
+// (once comments and line continuations are dealt with), capturing
+// the name of the package and everything after it: extras, version
+// specifiers, a direct URL, and environment markers. Lines that give
+// a bare URL or path instead don't match.
+var requirementRegexp = regexp.MustCompile(`^([A-Za-z0-9](?:[A-Za-z0-9._-]*[A-Za-z0-9])?)\s*([\[(<>=!~;@].*)?$`)
+
+// requirementCommentRegexp matches the start of a comment that follows
+// a requirement. It must come after whitespace, so that the fragment
+// of a URL is not mistaken for one.
+var requirementCommentRegexp = regexp.MustCompile(`\s#`)
+
+// requirementLine is a logical line of a requirements file, which may
+// be continued over several physical lines with backslashes.
+type requirementLine struct {
+	// The physical lines, including their newlines.
+	text string
+	// The package that is required, or the empty string if the
+	// line is blank, a comment, or an option such as -r or
+	// --index-url.
+	name api.PkgName
+	// Everything after the name, without any comment.
+	spec api.PkgSpec
+}
+
+// parseRequirements splits the given contents of a requirements file
+// into logical lines.
+func parseRequirements(contents string) []requirementLine {
+	lines := []requirementLine{}
+	physical := strings.SplitAfter(contents, "\n")
+	for i := 0; i < len(physical); i++ {
+		text := physical[i]
+		for strings.HasSuffix(strings.TrimRight(text, "\r\n"), "\\") && i+1 < len(physical) {
+			i++
+			text += physical[i]
+		}
+		if text == "" {
+			continue
+		}
+
+		logical := strings.Replace(text, "\\\r\n", " ", -1)
+		logical = strings.Replace(logical, "\\\n", " ", -1)
+		if strings.HasPrefix(logical, "#") {
+			logical = ""
+		} else if idx := requirementCommentRegexp.FindStringIndex(logical); idx != nil {
+			logical = logical[:idx[0]]
+		}
+		logical = strings.TrimSpace(logical)
+
+		line := requirementLine{text: text}
+		if m := requirementRegexp.FindStringSubmatch(logical); m != nil {
+			line.name = api.PkgName(m[1])
+			line.spec = api.PkgSpec(strings.TrimSpace(m[2]))
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+// listRequirements returns the packages required by the given
+// contents of a requirements file.
+func listRequirements(contents string) map[api.PkgName]api.PkgSpec {
+	pkgs := map[api.PkgName]api.PkgSpec{}
+	for _, line := range parseRequirements(contents) {
+		if line.name != "" {
+			pkgs[line.name] = line.spec
+		}
+	}
+	return pkgs
+}
+
+// listPinnedRequirements returns the packages in the given contents
+// of a requirements file generated by pip-compile, with the versions
+// they are pinned to. A package installed from a URL has that as its
+// version.
+func listPinnedRequirements(contents string) map[api.PkgName]api.PkgVersion {
+	pkgs := map[api.PkgName]api.PkgVersion{}
+	for name, spec := range listRequirements(contents) {
+		version := string(spec)
+		// Drop any environment markers.
+		version = strings.TrimSpace(strings.SplitN(version, ";", 2)[0])
+		// The options of a requirement, such as --hash, are
+		// not part of the version either.
+		version = strings.TrimSpace(strings.SplitN(version, " --", 2)[0])
+		if strings.HasPrefix(version, "[") {
+			if end := strings.IndexByte(version, ']'); end != -1 {
+				version = strings.TrimSpace(version[end+1:])
+			}
+		}
+		if strings.HasPrefix(version, "==") {
+			version = strings.TrimSpace(strings.TrimLeft(version, "="))
+		} else {
+			version = strings.TrimSpace(strings.TrimPrefix(version, "@"))
+		}
+		pkgs[name] = api.PkgVersion(version)
+	}
+	return pkgs
+}
+
+// formatRequirement returns the line of a requirements file that
+// requires the given package. A spec that is just a version, such as
+// "3.0.0", pins the package to it.
+func formatRequirement(name api.PkgName, spec api.PkgSpec) string {
+	spec = pep440Spec(spec)
+	switch {
+	case spec == "":
+		return string(name)
+	case '0' <= spec[0] && spec[0] <= '9':
+		return string(name) + "==" + string(spec)
+	case strings.HasPrefix(string(spec), "@") || strings.HasPrefix(string(spec), ";"):
+		return string(name) + " " + string(spec)
+	default:
+		return string(name) + string(spec)
+	}
+}
+
+// releaseRegexp matches the release numbers at the start of a
+// version, such as "1.2" in "1.2.3b1".
+var releaseRegexp = regexp.MustCompile(`^\d+(\.\d+)*`)
+
+// pep440Constraint returns the given Poetry version constraint, such as
+// "^1.2" or "~1.2.3", in the syntax of PEP 440, or false if it doesn't
+// need rewriting.
+func pep440Constraint(constraint string) (string, bool) {
+	op := ""
+	switch {
+	case constraint == "*":
+		return "", true
+	case strings.HasPrefix(constraint, "^"):
+		op = "^"
+	case strings.HasPrefix(constraint, "~") && !strings.HasPrefix(constraint, "~="):
+		op = "~"
+	case releaseRegexp.MatchString(constraint) && strings.HasSuffix(constraint, ".*"):
+		return "==" + constraint, true
+	default:
+		return constraint, false
+	}
+	version := strings.TrimSpace(constraint[1:])
+	release := releaseRegexp.FindString(version)
+	if release == "" {
+		return constraint, false
+	}
+	parts := strings.Split(release, ".")
+	// The caret allows changes that leave the first nonzero part
+	// alone, and the tilde changes to the last part given, or the
+	// patch level if more is given.
+	bump := len(parts) - 1
+	if op == "^" {
+		for i, part := range parts {
+			if strings.TrimLeft(part, "0") != "" {
+				bump = i
+				break
+			}
+		}
+	} else if bump > 1 {
+		bump = 1
+	}
+	upper := []string{}
+	for i, part := range parts {
+		switch {
+		case i < bump:
+			upper = append(upper, part)
+		case i == bump:
+			n, _ := strconv.Atoi(part)
+			upper = append(upper, strconv.Itoa(n+1))
+		default:
+			upper = append(upper, "0")
+		}
+	}
+	return fmt.Sprintf(">=%s,<%s", version, strings.Join(upper, ".")), true
+}
+
+// pep440Spec returns the given spec with any version constraints in the
+// syntax of Poetry rewritten in that of PEP 440, which pip, uv, and PDM
+// read, so that packages can be moved from Poetry to them. Constraints
+// joined with "||" can't be rewritten, and are left as they are.
+func pep440Spec(spec api.PkgSpec) api.PkgSpec {
+	rest := string(spec)
+	extras := ""
+	if strings.HasPrefix(rest, "[") {
+		end := strings.IndexByte(rest, ']')
+		if end == -1 {
+			return spec
+		}
+		extras, rest = rest[:end+1], rest[end+1:]
+	}
+	markers := ""
+	if i := strings.IndexByte(rest, ';'); i != -1 {
+		rest, markers = rest[:i], rest[i:]
+	}
+	if strings.Contains(rest, "||") || strings.HasPrefix(strings.TrimSpace(rest), "@") {
+		return spec
+	}
+	clauses := []string{}
+	rewritten := false
+	for _, clause := range strings.Split(rest, ",") {
+		clause, ok := pep440Constraint(strings.TrimSpace(clause))
+		rewritten = rewritten || ok
+		if clause != "" {
+			clauses = append(clauses, clause)
+		}
+	}
+	if !rewritten {
+		return spec
+	}
+	return api.PkgSpec(extras + strings.Join(clauses, ",") + markers)
+}
+
+// lineEnding returns "\r\n" if the given file contents use Windows
+// line endings, and "\n" otherwise.
+func lineEnding(contents string) string {
+	if strings.Contains(contents, "\r\n") {
+		return "\r\n"
+	}
+	return "\n"
+}
+
+// addToRequirements returns the given contents of a requirements file
+// with the given packages added. A package that is there already has
+// its line replaced; the others are added at the end.
+func addToRequirements(contents string, pkgs map[api.PkgName]api.PkgSpec) string {
+	newline := lineEnding(contents)
+	added := map[api.PkgName]bool{}
+	var out strings.Builder
+	for _, line := range parseRequirements(contents) {
+		if line.name != "" {
+			for name, spec := range pkgs {
+				if normalizePackageName(name) == normalizePackageName(line.name) {
+					line.text = formatRequirement(name, spec) + newline
+					added[name] = true
+				}
+			}
+		}
+		out.WriteString(line.text)
+	}
+
+	names := []string{}
+	for name := range pkgs {
+		if !added[name] {
+			names = append(names, string(name))
+		}
+	}
+	sort.Strings(names)
+	if len(names) > 0 && out.Len() > 0 && !strings.HasSuffix(out.String(), "\n") {
+		out.WriteString(newline)
+	}
+	for _, name := range names {
+		out.WriteString(formatRequirement(api.PkgName(name), pkgs[api.PkgName(name)]) + newline)
+	}
+	return out.String()
+}
+
+// removeFromRequirements returns the given contents of a requirements
+// file without the lines that require any of the given packages.
+func removeFromRequirements(contents string, pkgs map[api.PkgName]bool) string {
+	removed := map[api.PkgName]bool{}
+	for name := range pkgs {
+		removed[normalizePackageName(name)] = true
+	}
+	var out strings.Builder
+	for _, line := range parseRequirements(contents) {
+		if line.name == "" || !removed[normalizePackageName(line.name)] {
+			out.WriteString(line.text)
+		}
+	}
+	return out.String()
+}
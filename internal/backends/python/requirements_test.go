@@ -0,0 +1,107 @@
+package python
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/replit/upm/internal/api"
+)
+
+const testRequirements = `# Web
+--index-url https://pypi.org/simple
+Flask>=3.0  # the framework
+uvicorn[standard] ==0.23.2 ; python_version >= "3.8"
+mylib @ git+https://git.example.com/mylib.git#egg=mylib
+-e ./vendor/thing
+https://example.com/wheels/other-1.0-py3-none-any.whl
+requests \
+    >=2.31
+`
+
+func TestListRequirements(t *testing.T) {
+	require.Equal(t, map[api.PkgName]api.PkgSpec{
+		"Flask":    ">=3.0",
+		"uvicorn":  `[standard] ==0.23.2 ; python_version >= "3.8"`,
+		"mylib":    "@ git+https://git.example.com/mylib.git#egg=mylib",
+		"requests": ">=2.31",
+	}, listRequirements(testRequirements))
+}
+
+func TestListPinnedRequirements(t *testing.T) {
+	require.Equal(t, map[api.PkgName]api.PkgVersion{
+		"click": "8.1.7",
+		"flask": "3.0.0",
+		"mylib": "git+https://git.example.com/mylib.git",
+	}, listPinnedRequirements(`#
+# This file is autogenerated by pip-compile with Python 3.11
+#
+click==8.1.7 \
+    --hash=sha256:ae74fb96c20a0277a1d615f1e4d73c8414f5a98db8b799a7931d1582f3390c28
+    # via flask
+flask==3.0.0
+    # via -r requirements.txt
+mylib @ git+https://git.example.com/mylib.git
+`))
+}
+
+func TestAddToRequirements(t *testing.T) {
+	require.Equal(t, `# Web
+--index-url https://pypi.org/simple
+flask==3.0.2
+uvicorn[standard] ==0.23.2 ; python_version >= "3.8"
+mylib @ git+https://git.example.com/mylib.git#egg=mylib
+-e ./vendor/thing
+https://example.com/wheels/other-1.0-py3-none-any.whl
+requests \
+    >=2.31
+attrs
+numpy>=1.26
+`, addToRequirements(testRequirements, map[api.PkgName]api.PkgSpec{
+		"flask": "3.0.2",
+		"numpy": ">=1.26",
+		"attrs": "",
+	}))
+
+	require.Equal(t, "six\r\nattrs\r\n", addToRequirements("six\r\n", map[api.PkgName]api.PkgSpec{"attrs": ""}))
+	require.Equal(t, "six\nattrs\n", addToRequirements("six", map[api.PkgName]api.PkgSpec{"attrs": ""}))
+	require.Equal(t, "attrs\n", addToRequirements("", map[api.PkgName]api.PkgSpec{"attrs": ""}))
+}
+
+func TestRemoveFromRequirements(t *testing.T) {
+	require.Equal(t, `# Web
+--index-url https://pypi.org/simple
+mylib @ git+https://git.example.com/mylib.git#egg=mylib
+-e ./vendor/thing
+https://example.com/wheels/other-1.0-py3-none-any.whl
+`, removeFromRequirements(testRequirements, map[api.PkgName]bool{
+		"flask":    true,
+		"Uvicorn":  true,
+		"requests": true,
+	}))
+}
+
+func TestPep440Spec(t *testing.T) {
+	for spec, expected := range map[api.PkgSpec]api.PkgSpec{
+		"^1.2.3":                          ">=1.2.3,<2.0.0",
+		"^0.2.3":                          ">=0.2.3,<0.3.0",
+		"^0.0.3":                          ">=0.0.3,<0.0.4",
+		"^0":                              ">=0,<1",
+		"~1.2.3":                          ">=1.2.3,<1.3.0",
+		"~1":                              ">=1,<2",
+		"1.2.*":                           "==1.2.*",
+		"*":                               "",
+		">=2,^2.1":                        ">=2,>=2.1,<3.0",
+		"~=1.4":                           "~=1.4",
+		">=3.0":                           ">=3.0",
+		"3.0.0":                           "3.0.0",
+		"^1 || ^2":                        "^1 || ^2",
+		"[socks]^2":                       "[socks]>=2,<3",
+		`^1.0; python_version >= "3.8"`:   `>=1.0,<2.0; python_version >= "3.8"`,
+		"@ git+https://example.com/x.git": "@ git+https://example.com/x.git",
+	} {
+		require.Equal(t, expected, pep440Spec(spec), "%s", spec)
+	}
+	require.Equal(t, "flask>=3.0,<4.0", formatRequirement("flask", "^3.0"))
+	require.Equal(t, "flask", formatRequirement("flask", "*"))
+}
@@ -0,0 +1,323 @@
+package python
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"github.com/hashicorp/go-version"
+
+	"github.com/replit/upm/internal/api"
+	"github.com/replit/upm/internal/util"
+)
+
+// oldestSupportedPython is the oldest minor version of Python 3 that
+// the backends still support, the older ones being long past their end
+// of life. A project that only allows older versions is refused, and
+// 'upm migrate --python3' requires this one or later instead.
+const oldestSupportedPython = 9
+
+// newestPython is the newest minor version of Python 3 that a
+// constraint is checked against. It only needs to be far enough ahead
+// that a constraint such as "<3.14" is still seen to allow something.
+const newestPython = 30
+
+// pythonConstraintTokenRegexp matches one comparison in a constraint on
+// the version of Python, in either Poetry or PEP 440 syntax, such as
+// "^3.8", "~=2.7", ">= 3.6", or "2.7.*".
+var pythonConstraintTokenRegexp = regexp.MustCompile(`(\^|~=|~|===|==|!=|<=|>=|<|>|=)?\s*([0-9]+(?:\.[0-9]+)*)(\.\*)?`)
+
+// pythonMarkerRegexp matches a comparison of the version of Python in
+// PEP 508 environment markers, such as python_version < "3".
+var pythonMarkerRegexp = regexp.MustCompile(`python(?:_full)?_version\s*(~=|===|==|!=|<=|>=|<|>)\s*["']([0-9.*]+)["']`)
+
+// markerOrRegexp matches the "or" that combines alternatives in PEP
+// 508 environment markers.
+var markerOrRegexp = regexp.MustCompile(`(?i)\bor\b`)
+
+// pythonConstraintAllows returns true if the given constraint on the
+// version of Python, in either Poetry or PEP 440 syntax, allows the
+// given version. Alternatives may be separated with "||", and the
+// comparisons of each with commas or spaces.
+func pythonConstraintAllows(constraint string, v *version.Version) (bool, error) {
+	for _, alternative := range strings.Split(constraint, "||") {
+		alternative = strings.TrimSpace(alternative)
+		if alternative == "" || alternative == "*" {
+			return true, nil
+		}
+		rest := pythonConstraintTokenRegexp.ReplaceAllString(alternative, "")
+		if strings.Trim(rest, ", ") != "" {
+			return false, fmt.Errorf("invalid constraint %q", constraint)
+		}
+		allowed := true
+		for _, m := range pythonConstraintTokenRegexp.FindAllStringSubmatch(alternative, -1) {
+			ok, err := pythonComparisonAllows(m[1], m[2], m[3] != "", v)
+			if err != nil {
+				return false, err
+			}
+			allowed = allowed && ok
+		}
+		if allowed {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// pythonComparisonAllows returns true if the given version satisfies
+// the comparison with the given operator and version, which ends in
+// ".*" if wildcard is true.
+func pythonComparisonAllows(op string, bound string, wildcard bool, v *version.Version) (bool, error) {
+	segments := []int{}
+	for _, s := range strings.Split(bound, ".") {
+		n, err := strconv.Atoi(s)
+		if err != nil {
+			return false, err
+		}
+		segments = append(segments, n)
+	}
+	b, err := version.NewVersion(bound)
+	if err != nil {
+		return false, err
+	}
+
+	// hasPrefix returns true if v starts with the segments.
+	hasPrefix := func() bool {
+		vs := v.Segments()
+		for i, s := range segments {
+			if i >= len(vs) || vs[i] != s {
+				return false
+			}
+		}
+		return true
+	}
+	// below returns true if v is below the version whose segments
+	// are those of the bound up to the given one, with that one
+	// increased, as "^3.8" is below 4 and "~3.8" below 3.9.
+	below := func(i int) bool {
+		upper := append([]int{}, segments[:i+1]...)
+		upper[i]++
+		strs := []string{}
+		for _, s := range upper {
+			strs = append(strs, strconv.Itoa(s))
+		}
+		return v.LessThan(version.Must(version.NewVersion(strings.Join(strs, "."))))
+	}
+
+	switch {
+	case wildcard && (op == "" || op == "=" || op == "=="):
+		return hasPrefix(), nil
+	case wildcard && op == "!=":
+		return !hasPrefix(), nil
+	case op == "" || op == "=" || op == "==" || op == "===":
+		return v.Equal(b), nil
+	case op == "!=":
+		return !v.Equal(b), nil
+	case op == "<":
+		return v.LessThan(b), nil
+	case op == "<=":
+		return !v.GreaterThan(b), nil
+	case op == ">":
+		return v.GreaterThan(b), nil
+	case op == ">=":
+		return !v.LessThan(b), nil
+	case op == "^":
+		i := 0
+		for i < len(segments)-1 && segments[i] == 0 {
+			i++
+		}
+		return !v.LessThan(b) && below(i), nil
+	case op == "~":
+		i := 0
+		if len(segments) > 1 {
+			i = 1
+		}
+		return !v.LessThan(b) && below(i), nil
+	case op == "~=":
+		if len(segments) < 2 {
+			return false, fmt.Errorf("~=%s needs at least two segments", bound)
+		}
+		return !v.LessThan(b) && below(len(segments)-2), nil
+	}
+	return false, fmt.Errorf("invalid operator %q", op)
+}
+
+// allowsSupportedPython returns true if the given constraint on the
+// version of Python allows one that is still supported, or if it
+// can't be understood, since it is then up to the package manager.
+func allowsSupportedPython(constraint string) bool {
+	for minor := oldestSupportedPython; minor <= newestPython; minor++ {
+		// A constraint may also exclude the first release of a
+		// minor version, as ">3.9" does, but not the later ones.
+		for _, patch := range []int{0, 99} {
+			v := version.Must(version.NewVersion(fmt.Sprintf("3.%d.%d", minor, patch)))
+			allowed, err := pythonConstraintAllows(constraint, v)
+			if err != nil || allowed {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// onlyForObsoletePython returns true if the given spec, as ListSpecfile
+// returns it, restricts the package to versions of Python that are no
+// longer supported, with either the "python" key of Poetry or PEP 508
+// markers, as for a backport such as "futures; python_version < '3'".
+// Markers that combine alternatives with "or" are left alone, since
+// any of them could allow a supported version.
+func onlyForObsoletePython(spec api.PkgSpec) bool {
+	parts := strings.Split(string(spec), ";")
+	for _, part := range parts[1:] {
+		part = strings.TrimSpace(part)
+		if strings.HasPrefix(part, "python ") {
+			if !allowsSupportedPython(strings.TrimPrefix(part, "python ")) {
+				return true
+			}
+			continue
+		}
+		if markerOrRegexp.MatchString(part) {
+			continue
+		}
+		comparisons := []string{}
+		for _, m := range pythonMarkerRegexp.FindAllStringSubmatch(part, -1) {
+			comparisons = append(comparisons, m[1]+m[2])
+		}
+		if len(comparisons) > 0 && !allowsSupportedPython(strings.Join(comparisons, ",")) {
+			return true
+		}
+	}
+	return false
+}
+
+// pythonConstraintLine is the line of pyproject.toml that constrains
+// the version of Python, in either the [project] table of PEP 621 or
+// the dependencies of Poetry.
+type pythonConstraintLine struct {
+	// The table that the line is in.
+	table string
+	// The key of the line in that table.
+	key string
+	// What the constraint is rewritten to by 'upm migrate
+	// --python3', in the syntax of the table.
+	supported string
+}
+
+// pythonConstraintLines are the lines of pyproject.toml that may
+// constrain the version of Python.
+var pythonConstraintLines = []pythonConstraintLine{
+	{"project", "requires-python", fmt.Sprintf(">=3.%d", oldestSupportedPython)},
+	{"tool.poetry.dependencies", "python", fmt.Sprintf("^3.%d", oldestSupportedPython)},
+}
+
+// pyprojectPythonConstraints returns the constraints on the version of
+// Python in pyproject.toml, by key.
+func pyprojectPythonConstraints() map[string]string {
+	var cfg struct {
+		Project struct {
+			RequiresPython string `toml:"requires-python"`
+		} `toml:"project"`
+		Tool struct {
+			Poetry struct {
+				Dependencies map[string]interface{} `toml:"dependencies"`
+			} `toml:"poetry"`
+		} `toml:"tool"`
+	}
+	if _, err := toml.DecodeFile("pyproject.toml", &cfg); err != nil {
+		util.Die("%s", err.Error())
+	}
+	constraints := map[string]string{}
+	if cfg.Project.RequiresPython != "" {
+		constraints["requires-python"] = cfg.Project.RequiresPython
+	}
+	if python, ok := cfg.Tool.Poetry.Dependencies["python"].(string); ok && python != "" {
+		constraints["python"] = python
+	}
+	return constraints
+}
+
+// checkPython implements CheckRuntime for the Python backends. The
+// constraints on the version of Python are only read from the
+// specfile if it is pyproject.toml, since requirements.txt has none.
+func checkPython(specfile string, listSpecfile func() (map[api.PkgName]api.PkgSpec, error)) (string, []api.PkgName) {
+	if !util.Exists(specfile) {
+		return "", nil
+	}
+	problems := []string{}
+	if specfile == "pyproject.toml" {
+		constraints := pyprojectPythonConstraints()
+		for _, line := range pythonConstraintLines {
+			constraint, ok := constraints[line.key]
+			if ok && !allowsSupportedPython(constraint) {
+				problems = append(problems, fmt.Sprintf(
+					"%s requires Python %s (in %s), which only allows versions older than 3.%d",
+					specfile, constraint, line.key, oldestSupportedPython))
+			}
+		}
+	}
+	specs, err := listSpecfile()
+	if err != nil {
+		util.Die("%s", err.Error())
+	}
+	pkgs := []api.PkgName{}
+	for name, spec := range specs {
+		if onlyForObsoletePython(spec) {
+			pkgs = append(pkgs, name)
+		}
+	}
+	sort.Slice(pkgs, func(i, j int) bool { return pkgs[i] < pkgs[j] })
+	return strings.Join(problems, "; "), pkgs
+}
+
+// upgradePython implements UpgradeRuntime for the Python backends
+// that use pyproject.toml, rewriting each constraint on the version of
+// Python that only allows unsupported versions in place, so that the
+// rest of the file is left as it was.
+func upgradePython() {
+	contents := readTextFile("pyproject.toml")
+	constraints := pyprojectPythonConstraints()
+
+	changed := false
+	for _, line := range pythonConstraintLines {
+		constraint, ok := constraints[line.key]
+		if !ok || allowsSupportedPython(constraint) {
+			continue
+		}
+		keyRegexp := regexp.MustCompile(`(?m)^(\s*` + regexp.QuoteMeta(line.key) + `\s*=\s*)(["'])[^"'\r\n]*(["'])`)
+		start, end := tomlTableBounds(contents, line.table)
+		if start < 0 || !keyRegexp.MatchString(contents[start:end]) {
+			// It is written some other way, such as with a
+			// dotted key or over several lines.
+			util.Die("can't rewrite %s = %q in pyproject.toml; change it to %q by hand",
+				line.key, constraint, line.supported)
+		}
+		table := keyRegexp.ReplaceAllString(contents[start:end], "${1}${2}"+line.supported+"${3}")
+		contents = contents[:start] + table + contents[end:]
+		changed = true
+	}
+	if !changed {
+		return
+	}
+	util.ProgressMsg("write pyproject.toml")
+	util.TryWriteAtomic("pyproject.toml", []byte(contents))
+}
+
+// tomlTableBounds returns the offsets in the given TOML document of
+// the start and end of the body of the table with the given name, or
+// -1 and -1 if there is no such table.
+func tomlTableBounds(contents string, table string) (int, int) {
+	header := regexp.MustCompile(`(?m)^\s*\[\s*` + regexp.QuoteMeta(table) + `\s*\]\s*$`)
+	loc := header.FindStringIndex(contents)
+	if loc == nil {
+		return -1, -1
+	}
+	start := loc[1]
+	next := regexp.MustCompile(`(?m)^\s*\[`).FindStringIndex(contents[start:])
+	if next == nil {
+		return start, len(contents)
+	}
+	return start, start + next[0]
+}
@@ -0,0 +1,92 @@
+package python
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/replit/upm/internal/api"
+)
+
+func TestAllowsSupportedPython(t *testing.T) {
+	for constraint, allowed := range map[string]bool{
+		"":                 true,
+		"*":                true,
+		"^3.8":             true,
+		">=3.6":            true,
+		"~3.9":             true,
+		">=3.6,<3.9":       false,
+		">= 3.6, < 3.13":   true,
+		"~2.7":             false,
+		"^2.7":             false,
+		"2.7":              false,
+		"2.7.*":            false,
+		"3.*":              true,
+		"~=2.7":            false,
+		"~=3.7":            true,
+		"~=3.7.1":          false,
+		"~2.7 || ^3.10":    true,
+		">=2.7 <3":         false,
+		">2.7, !=3.0.*":    true,
+		"not a constraint": true,
+	} {
+		require.Equal(t, allowed, allowsSupportedPython(constraint), constraint)
+	}
+}
+
+func TestOnlyForObsoletePython(t *testing.T) {
+	for spec, obsolete := range map[api.PkgSpec]bool{
+		"":                             false,
+		"^1.0":                         false,
+		"^3.2; python ~2.7":            true,
+		"^3.2; python ^3.8":            false,
+		`>=3.2 ; python_version < "3"`: true,
+		`; python_version == "2.7" and sys_platform == "linux"`: true,
+		`; python_full_version < '3.0.0'`:                       true,
+		`; python_version >= "3.8"`:                             false,
+		`; python_version < "3" or sys_platform == "win32"`:     false,
+		`; sys_platform == "win32"`:                             false,
+	} {
+		require.Equal(t, obsolete, onlyForObsoletePython(spec), string(spec))
+	}
+}
+
+func TestCheckAndUpgradePython(t *testing.T) {
+	dir := t.TempDir()
+	cwd, err := os.Getwd()
+	require.NoError(t, err)
+	require.NoError(t, os.Chdir(dir))
+	defer os.Chdir(cwd)
+
+	require.NoError(t, ioutil.WriteFile("pyproject.toml", []byte(`[project]
+name = "demo"
+requires-python = ">=2.7,<3"
+dependencies = ["six", "futures; python_version < '3'"]
+
+[tool.poetry.dependencies]
+python = "~2.7"  # the old server
+enum34 = { version = "^1.1", python = "<3.4" }
+`), 0666))
+
+	problem, pkgs := checkPython("pyproject.toml", listSpecfile)
+	require.Contains(t, problem, ">=2.7,<3 (in requires-python)")
+	require.Contains(t, problem, "~2.7 (in python)")
+	require.Equal(t, []api.PkgName{"enum34", "futures"}, pkgs)
+
+	upgradePython()
+	require.Equal(t, `[project]
+name = "demo"
+requires-python = ">=3.9"
+dependencies = ["six", "futures; python_version < '3'"]
+
+[tool.poetry.dependencies]
+python = "^3.9"  # the old server
+enum34 = { version = "^1.1", python = "<3.4" }
+`, readTextFile("pyproject.toml"))
+
+	problem, pkgs = checkPython("pyproject.toml", listSpecfile)
+	require.Empty(t, problem)
+	require.Equal(t, []api.PkgName{"enum34", "futures"}, pkgs)
+}
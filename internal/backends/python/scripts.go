@@ -0,0 +1,95 @@
+package python
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/kballard/go-shellquote"
+
+	"github.com/replit/upm/internal/util"
+)
+
+// scriptCommand returns what a script in pyproject.toml runs, given
+// either as is or, as Poetry and PDM also allow, as a table, or the
+// empty string if it can't be told.
+func scriptCommand(script interface{}) string {
+	switch script := script.(type) {
+	case string:
+		return script
+	case map[string]interface{}:
+		for _, key := range []string{"cmd", "shell", "call", "callable", "reference", "composite"} {
+			switch value := script[key].(type) {
+			case string:
+				return value
+			case []interface{}:
+				parts := []string{}
+				for _, part := range value {
+					parts = append(parts, fmt.Sprint(part))
+				}
+				// A composite script runs each of its
+				// parts in turn, until one fails.
+				if key == "composite" {
+					return strings.Join(parts, " && ")
+				}
+				return shellquote.Join(parts...)
+			}
+		}
+	}
+	return ""
+}
+
+// listPoetryScripts implements ListScripts for Poetry, returning the
+// console scripts in [project.scripts] and [tool.poetry.scripts].
+func listPoetryScripts() map[string]string {
+	scripts := map[string]string{}
+	if !util.Exists("pyproject.toml") {
+		return scripts
+	}
+	cfg, err := readPyproject()
+	if err != nil {
+		util.Die("pyproject.toml: %s", err)
+	}
+	for name, script := range cfg.Project.Scripts {
+		scripts[name] = script
+	}
+	for name, script := range cfg.Tool.Poetry.Scripts {
+		scripts[name] = scriptCommand(script)
+	}
+	return scripts
+}
+
+// listPep621Scripts implements ListScripts for uv and PDM, returning
+// the console scripts in [project.scripts], and with pdm, the scripts
+// in [tool.pdm.scripts], which PDM runs in preference to them.
+func listPep621Scripts(pdm bool) map[string]string {
+	scripts := map[string]string{}
+	if !util.Exists("pyproject.toml") {
+		return scripts
+	}
+	cfg, err := readPep621Pyproject()
+	if err != nil {
+		util.Die("pyproject.toml: %s", err)
+	}
+	for name, script := range cfg.Project.Scripts {
+		scripts[name] = script
+	}
+	if pdm && cfg.Tool.Pdm != nil {
+		for name, script := range cfg.Tool.Pdm.Scripts {
+			// PDM keeps its settings for every script
+			// under _.
+			if name != "_" {
+				scripts[name] = scriptCommand(script)
+			}
+		}
+	}
+	return scripts
+}
+
+// runScript returns an implementation of RunScript that runs scripts
+// with the run command of the given tool, such as 'poetry run'.
+func runScript(tool string) func(ctx context.Context, name string, args []string) {
+	return func(ctx context.Context, name string, args []string) {
+		util.RunInteractiveCmd(ctx, append([]string{tool, "run", name}, args...))
+	}
+}
@@ -0,0 +1,48 @@
+package python
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestListScripts(t *testing.T) {
+	dir := t.TempDir()
+	cwd, err := os.Getwd()
+	require.NoError(t, err)
+	require.NoError(t, os.Chdir(dir))
+	defer os.Chdir(cwd)
+
+	require.NoError(t, ioutil.WriteFile("pyproject.toml", []byte(`
+[project]
+name = "demo"
+
+[project.scripts]
+demo = "demo.cli:main"
+
+[tool.poetry.scripts]
+serve = { callable = "demo.server:run" }
+
+[tool.pdm.scripts]
+_ = { env_file = ".env" }
+test = "pytest -x"
+lint = { cmd = ["flake8", "src dir"] }
+check = { composite = ["lint", "test"] }
+`), 0666))
+
+	require.Equal(t, map[string]string{
+		"demo":  "demo.cli:main",
+		"serve": "demo.server:run",
+	}, listPoetryScripts())
+	require.Equal(t, map[string]string{
+		"demo": "demo.cli:main",
+	}, listPep621Scripts(false))
+	require.Equal(t, map[string]string{
+		"demo":  "demo.cli:main",
+		"test":  "pytest -x",
+		"lint":  "flake8 'src dir'",
+		"check": "lint && test",
+	}, listPep621Scripts(true))
+}
@@ -0,0 +1,140 @@
+package python
+
+import (
+	"context"
+	"encoding/json"
+	"path/filepath"
+	"strings"
+
+	"github.com/replit/upm/internal/api"
+	"github.com/replit/upm/internal/util"
+)
+
+// setuptoolsSpecfiles are the files in which a project built with
+// setuptools declares its dependencies. Package managers such as
+// Poetry don't read them, so 'upm migrate' moves the dependencies to
+// the specfile.
+var setuptoolsSpecfiles = []string{"setup.cfg", "setup.py"}
+
+// parseSetupCfg returns the options in the given contents of a
+// setup.cfg file, by section and then by key, as configparser reads
+// them: a line that is indented continues the value of the option
+// above it.
+func parseSetupCfg(contents string) map[string]map[string]string {
+	sections := map[string]map[string]string{}
+	var section map[string]string
+	key := ""
+	for _, line := range strings.Split(contents, "\n") {
+		line = strings.TrimRight(line, " \t\r")
+		trimmed := strings.TrimSpace(line)
+		switch {
+		case trimmed == "" || strings.HasPrefix(trimmed, "#") || strings.HasPrefix(trimmed, ";"):
+			continue
+		case line != trimmed && section != nil && key != "":
+			section[key] += "\n" + trimmed
+		case strings.HasPrefix(trimmed, "[") && strings.HasSuffix(trimmed, "]"):
+			name := strings.TrimSpace(trimmed[1 : len(trimmed)-1])
+			if sections[name] == nil {
+				sections[name] = map[string]string{}
+			}
+			section = sections[name]
+			key = ""
+		case section != nil:
+			idx := strings.IndexAny(trimmed, "=:")
+			if idx < 0 {
+				continue
+			}
+			key = strings.ToLower(strings.TrimSpace(trimmed[:idx]))
+			section[key] = strings.TrimSpace(trimmed[idx+1:])
+		}
+	}
+	return sections
+}
+
+// setupCfgRequirements returns the requirements in the given value of
+// an option of setup.cfg, one per line, reading them from the files
+// given by a "file:" directive in the given directory if there is one.
+func setupCfgRequirements(value string, dir string) []string {
+	if strings.HasPrefix(value, "file:") {
+		contents := []string{}
+		for _, name := range strings.Split(strings.TrimPrefix(value, "file:"), ",") {
+			contents = append(contents, readTextFile(filepath.Join(dir, strings.TrimSpace(name))))
+		}
+		value = strings.Join(contents, "\n")
+	}
+	reqs := []string{}
+	for _, line := range parseRequirements(value) {
+		if line.name != "" {
+			reqs = append(reqs, strings.TrimSpace(strings.TrimRight(line.text, "\\\r\n")))
+		}
+	}
+	return reqs
+}
+
+// readSetupCfg returns the name, dependencies, and extras declared in
+// the given contents of a setup.cfg file, which is in the given
+// directory.
+func readSetupCfg(contents string, dir string) pep621Project {
+	sections := parseSetupCfg(contents)
+	project := pep621Project{
+		Name:                 sections["metadata"]["name"],
+		Dependencies:         setupCfgRequirements(sections["options"]["install_requires"], dir),
+		OptionalDependencies: map[string][]string{},
+	}
+	for extra, value := range sections["options.extras_require"] {
+		project.OptionalDependencies[extra] = setupCfgRequirements(value, dir)
+	}
+	return project
+}
+
+// readSetupPy returns the name, dependencies, and extras declared in
+// setup.py, by running it under the given Python with setup() replaced.
+func readSetupPy(python string) pep621Project {
+	tempdir := util.TempDir("upm")
+	defer util.RemoveTemp(tempdir)
+
+	script := util.WriteResource("/python/setup-requires.py", tempdir)
+	outputB := util.GetCmdOutput(context.Background(), []string{python, script, "setup.py"})
+
+	var output struct {
+		Name            string              `json:"name"`
+		InstallRequires []string            `json:"install_requires"`
+		ExtrasRequire   map[string][]string `json:"extras_require"`
+	}
+	if err := json.Unmarshal(outputB, &output); err != nil {
+		util.Die("setup.py: %s", err)
+	}
+	return pep621Project{
+		Name:                 output.Name,
+		Dependencies:         output.InstallRequires,
+		OptionalDependencies: output.ExtrasRequire,
+	}
+}
+
+// readSetuptools returns what the project declares in setup.cfg and
+// setup.py, running the latter under the given Python only if the
+// former doesn't declare any dependencies.
+func readSetuptools(python string) pep621Project {
+	var project pep621Project
+	if util.Exists("setup.cfg") {
+		project = readSetupCfg(readTextFile("setup.cfg"), ".")
+	}
+	if len(project.Dependencies) == 0 && util.Exists("setup.py") {
+		fromPy := readSetupPy(python)
+		if project.Name == "" {
+			project.Name = fromPy.Name
+		}
+		project.Dependencies = fromPy.Dependencies
+		if len(project.OptionalDependencies) == 0 {
+			project.OptionalDependencies = fromPy.OptionalDependencies
+		}
+	}
+	return project
+}
+
+// listSetuptools returns the packages that the project requires in
+// setup.cfg or setup.py, not counting those of extras, which Add
+// would make into requirements of the project itself.
+func listSetuptools(python string) map[api.PkgName]api.PkgSpec {
+	return listRequirements(strings.Join(readSetuptools(python).Dependencies, "\n"))
+}
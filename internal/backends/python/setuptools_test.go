@@ -0,0 +1,48 @@
+package python
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadSetupCfg(t *testing.T) {
+	project := readSetupCfg(`
+[metadata]
+name = demo
+
+[options]
+packages = find:
+install_requires =
+    requests[security]>=2.31  # for the client
+    ; a comment
+    flask
+
+[options.extras_require]
+test = pytest>=8
+`, ".")
+
+	require.Equal(t, "demo", project.Name)
+	require.Equal(t, []string{"requests[security]>=2.31  # for the client", "flask"}, project.Dependencies)
+	require.Equal(t, map[string][]string{"test": {"pytest>=8"}}, project.OptionalDependencies)
+	require.Equal(t, "[security]>=2.31", string(listRequirements(project.Dependencies[0])["requests"]))
+}
+
+func TestReadSetupCfgFileDirective(t *testing.T) {
+	dir, err := ioutil.TempDir("", "upm")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	err = ioutil.WriteFile(filepath.Join(dir, "requirements.in"), []byte("# pinned elsewhere\nnumpy>=1.26\n"), 0666)
+	require.NoError(t, err)
+
+	project := readSetupCfg(`
+[options]
+install_requires = file: requirements.in
+`, dir)
+
+	require.Equal(t, []string{"numpy>=1.26"}, project.Dependencies)
+}
@@ -0,0 +1,84 @@
+package python
+
+import (
+	"strings"
+
+	"github.com/replit/upm/internal/api"
+)
+
+// poetryDirectReference returns the PEP 508 direct reference for a
+// Poetry spec with a "git" or "url" key, such as
+// "git+https://github.com/pallets/flask.git@2.3.0", or the empty
+// string if it has neither.
+func poetryDirectReference(spec map[string]interface{}) string {
+	key := func(key string) string {
+		value, _ := spec[key].(string)
+		return value
+	}
+
+	ref := ""
+	if git := key("git"); git != "" {
+		switch {
+		case strings.HasPrefix(git, "git+"):
+			ref = git
+		case strings.Contains(git, "://"):
+			ref = "git+" + git
+		default:
+			// An scp-style address such as
+			// git@github.com:pallets/flask.git.
+			ref = "git+ssh://" + git
+		}
+		for _, rev := range []string{"rev", "tag", "branch"} {
+			if rev := key(rev); rev != "" {
+				ref += "@" + rev
+				break
+			}
+		}
+	} else if url := key("url"); url != "" {
+		ref = url
+	} else {
+		return ""
+	}
+	if subdirectory := key("subdirectory"); subdirectory != "" {
+		ref += "#subdirectory=" + subdirectory
+	}
+	return ref
+}
+
+// formatDirectSpec returns the spec of a package installed from the
+// given direct reference, as it is written in a requirement after the
+// name: for example "[dotenv] @ git+https://github.com/pallets/flask.git".
+func formatDirectSpec(extras []string, ref string) string {
+	return strings.TrimPrefix(formatExtras(extras)+" @ "+ref, " ")
+}
+
+// isPathSpec returns true if the given spec, without extras, is the
+// path of a local package rather than a version constraint, which
+// never has a slash in it.
+func isPathSpec(spec api.PkgSpec) bool {
+	return strings.HasPrefix(string(spec), ".") ||
+		strings.ContainsAny(string(spec), `/\`) && !strings.HasPrefix(string(spec), "@")
+}
+
+// specSource implements SpecSource for the Python backends.
+func specSource(spec api.PkgSpec) string {
+	spec, _ = splitSpecMarkers(spec)
+	_, spec = splitSpecExtras(spec)
+	spec = api.PkgSpec(strings.TrimSpace(string(spec)))
+	if !strings.HasPrefix(string(spec), "@") {
+		if isPathSpec(spec) {
+			return "path"
+		}
+		return ""
+	}
+
+	scheme := strings.SplitN(strings.TrimSpace(string(spec[1:])), ":", 2)[0]
+	switch {
+	case scheme == "file":
+		return "path"
+	case strings.Contains(scheme, "+"):
+		// git+https, hg+ssh, and so on.
+		return strings.SplitN(scheme, "+", 2)[0]
+	}
+	return "url"
+}
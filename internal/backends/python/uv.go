@@ -0,0 +1,169 @@
+package python
+
+import (
+	"context"
+	"os"
+
+	"github.com/replit/upm/internal/api"
+	"github.com/replit/upm/internal/util"
+)
+
+// exportCmd returns the given command that exports the lockfile of uv
+// or PDM, with the main dependencies and those of the given groups:
+// the dev group is left out with noDev, the option that does that, and
+// the others are selected with --group.
+func exportCmd(cmd []string, noDev string, groups []string) []string {
+	dev := false
+	for _, group := range groups {
+		if group == "dev" {
+			dev = true
+			continue
+		}
+		cmd = append(cmd, "--group", group)
+	}
+	if !dev {
+		cmd = append(cmd, noDev)
+	}
+	return cmd
+}
+
+// getUv returns either "uv" or the value of UPM_UV.
+func getUv() string {
+	if uv := os.Getenv("UPM_UV"); uv != "" {
+		return uv
+	}
+	return "uv"
+}
+
+// uvMakeBackend returns a language backend for Python 3 that uses the
+// given uv executable, with a pyproject.toml that follows PEP 621 as
+// the specfile and uv.lock as the lockfile.
+func uvMakeBackend(uv string, python string) api.LanguageBackend {
+	// listSpecfile lists the packages in the specfile for guess,
+	// which doesn't guess them again.
+	listSpecfile := func() (map[api.PkgName]api.PkgSpec, error) {
+		cfg, err := readPep621Pyproject()
+		if err != nil {
+			return nil, err
+		}
+		return listPep621Pyproject(cfg), nil
+	}
+
+	// uv always uses the virtualenv in the project, unless
+	// told otherwise.
+	getPackageDir := func() string {
+		if env := os.Getenv("UV_PROJECT_ENVIRONMENT"); env != "" {
+			return env
+		}
+		return ".venv"
+	}
+
+	getExecEnv := func() api.ExecEnv {
+		return pythonExecEnv(python, getPackageDir())
+	}
+
+	return api.LanguageBackend{
+		Name:             "python-python3-uv",
+		Specfile:         "pyproject.toml",
+		Lockfile:         "uv.lock",
+		LockfileComment:  "#",
+		OSVEcosystem:     "PyPI",
+		Registry:         indexRegistry,
+		FilenamePatterns: []string{"*.py", "*.ipynb"},
+		Quirks: api.QuirksAddRemoveAlsoLocks |
+			api.QuirksAddRemoveAlsoInstalls,
+		Executables:          []string{uv},
+		NormalizePackageName: normalizePackageName,
+		GetPackageDir:        getPackageDir,
+		GetExecEnv:           getExecEnv,
+		Search:               search,
+		Info:                 info,
+		Downloads:            downloads,
+		Add: func(ctx context.Context, pkgs map[api.PkgName]api.PkgSpec, projectName string) {
+			if !util.Exists("pyproject.toml") {
+				cmd := []string{uv, "init", "--bare"}
+				if projectName != "" {
+					cmd = append(cmd, "--name", projectName)
+				}
+				util.RunCmd(ctx, cmd)
+			}
+			cmd := []string{uv, "add"}
+			for name, spec := range pkgs {
+				cmd = append(cmd, formatRequirement(name, spec))
+			}
+			util.RunCmd(ctx, cmd)
+		},
+		InitSpecfile: func(ctx context.Context, projectName string) {
+			util.RunCmd(ctx, []string{uv, "init", "--bare", "--name", projectName})
+		},
+		Remove: func(ctx context.Context, pkgs map[api.PkgName]bool) {
+			cmd := []string{uv, "remove"}
+			for name := range pkgs {
+				cmd = append(cmd, string(name))
+			}
+			util.RunCmd(ctx, cmd)
+		},
+		Lock: func(ctx context.Context) {
+			util.RunCmd(ctx, []string{uv, "lock"})
+		},
+		Upgrade: func(ctx context.Context, pkgs map[api.PkgName]bool) {
+			cmd := []string{uv, "lock"}
+			for name := range pkgs {
+				cmd = append(cmd, "--upgrade-package", string(name))
+			}
+			util.RunCmd(ctx, cmd)
+		},
+		Install: func(ctx context.Context) {
+			util.RunCmd(ctx, []string{uv, "sync", "--frozen"})
+		},
+		ListSpecfile: func() map[api.PkgName]api.PkgSpec {
+			cfg, err := readPep621Pyproject()
+			if err != nil {
+				util.Die("%s", err.Error())
+			}
+			return listPep621Pyproject(cfg)
+		},
+		SpecSource:      specSource,
+		LegacySpecfiles: setuptoolsSpecfiles,
+		ListLegacySpecfile: func() map[api.PkgName]api.PkgSpec {
+			return listSetuptools(python)
+		},
+		CheckRuntime: func() (string, []api.PkgName) {
+			return checkPython("pyproject.toml", listSpecfile)
+		},
+		UpgradeRuntime: upgradePython,
+		// uv ignores an activated virtualenv that isn't the
+		// project's, so python doesn't see what it installs.
+		Diagnose: func() []api.Problem {
+			return diagnoseVirtualenv(getPackageDir())
+		},
+		ListLockfile: func() map[api.PkgName]api.PkgVersion {
+			return listPackageLock(readTextFile("uv.lock"))
+		},
+		ListLockfileDependencies: func() map[api.PkgName][]api.PkgName {
+			return listPackageLockDependencies("uv.lock")
+		},
+		ExportLockfile: func(ctx context.Context, groups []string, file string) {
+			util.RunCmd(ctx, exportCmd([]string{uv, "export", "--frozen", "--format", "requirements-txt",
+				"--output-file", file}, "--no-dev", groups))
+		},
+		ListInstalled: func() map[api.PkgName]api.InstalledPkg {
+			return listInstalled(sitePackagesDirs(python, getPackageDir()), pyprojectName())
+		},
+		ListScripts: func() map[string]string {
+			return listPep621Scripts(false)
+		},
+		RunScript:     runScript(uv),
+		GuessRegexps:  pythonGuessRegexps,
+		GuessCacheKey: mapOverlayCacheKey,
+		Guess: func(ctx context.Context) (map[api.PkgName]bool, bool) {
+			return guess(ctx, python, listSpecfile)
+		},
+		GuessDetails: func(ctx context.Context) (map[api.PkgName]api.GuessedPkg, bool) {
+			return guessDetails(ctx, python, listSpecfile)
+		},
+	}
+}
+
+// UPM backend for Python 3 that uses uv.
+var Python3UvBackend = uvMakeBackend(getUv(), getPython3())
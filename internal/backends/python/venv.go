@@ -0,0 +1,81 @@
+package python
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/replit/upm/internal/api"
+	"github.com/replit/upm/internal/util"
+)
+
+// diagnoseVirtualenv implements Diagnose for the Python backends. It
+// reports an activated virtualenv that doesn't exist, or one that
+// isn't projectEnv, the virtualenv in the project, if that exists;
+// then either the packages aren't installed where the project looks
+// for them, or python doesn't find the ones that were installed.
+// projectEnv may be empty if the backend has none.
+func diagnoseVirtualenv(projectEnv string) []api.Problem {
+	venv := os.Getenv("VIRTUAL_ENV")
+	if venv == "" {
+		return nil
+	}
+	info, err := os.Stat(venv)
+	if err != nil || !info.IsDir() {
+		return []api.Problem{{
+			Summary: fmt.Sprintf("VIRTUAL_ENV is %s, which does not exist", venv),
+			Fix:     "run 'deactivate', or create it again with 'python3 -m venv " + venv + "'",
+		}}
+	}
+	if projectEnv == "" {
+		return nil
+	}
+	if projectInfo, err := os.Stat(projectEnv); err != nil || os.SameFile(info, projectInfo) {
+		return nil
+	}
+	return []api.Problem{{
+		Summary: fmt.Sprintf("the activated virtualenv %s is not the project's %s", venv, projectEnv),
+		Fix:     fmt.Sprintf("run 'deactivate', or activate %s instead", projectEnv),
+	}}
+}
+
+// pythonExecEnv implements GetExecEnv for the Python backends, given
+// the package dir: a virtualenv, which is activated, or
+// __pypackages__, whose packages are put on PYTHONPATH. An empty dir
+// means that packages are installed wherever python does, so nothing
+// needs to change.
+func pythonExecEnv(python string, dir string) api.ExecEnv {
+	if dir == "" {
+		return api.ExecEnv{}
+	}
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		util.Die("%s", err)
+	}
+	if dir != pypackagesDir {
+		return api.ExecEnv{
+			Path: []string{filepath.Join(abs, "bin")},
+			Vars: map[string]string{"VIRTUAL_ENV": abs},
+		}
+	}
+
+	lib, err := filepath.Abs(pypackagesLibDir(context.Background(), python))
+	if err != nil {
+		util.Die("%s", err)
+	}
+	pythonPath := []string{lib}
+	if existing := os.Getenv("PYTHONPATH"); existing != "" {
+		pythonPath = append(pythonPath, existing)
+	}
+	return api.ExecEnv{
+		// pip puts the scripts of the packages it installs
+		// into lib with --target in lib/bin, while PDM puts
+		// them beside lib.
+		Path: []string{filepath.Join(lib, "bin"), filepath.Join(filepath.Dir(lib), "bin")},
+		Vars: map[string]string{
+			"PYTHONPATH": strings.Join(pythonPath, string(os.PathListSeparator)),
+		},
+	}
+}
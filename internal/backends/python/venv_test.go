@@ -0,0 +1,57 @@
+package python
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/replit/upm/internal/api"
+)
+
+func TestDiagnoseVirtualenv(t *testing.T) {
+	dir := t.TempDir()
+	project := filepath.Join(dir, ".venv")
+	other := filepath.Join(dir, "other")
+	require.NoError(t, os.Mkdir(project, 0755))
+	require.NoError(t, os.Mkdir(other, 0755))
+
+	venv, hadVenv := os.LookupEnv("VIRTUAL_ENV")
+	defer func() {
+		if hadVenv {
+			os.Setenv("VIRTUAL_ENV", venv)
+		} else {
+			os.Unsetenv("VIRTUAL_ENV")
+		}
+	}()
+
+	os.Unsetenv("VIRTUAL_ENV")
+	require.Empty(t, diagnoseVirtualenv(project))
+
+	os.Setenv("VIRTUAL_ENV", project)
+	require.Empty(t, diagnoseVirtualenv(project))
+
+	os.Setenv("VIRTUAL_ENV", other)
+	problems := diagnoseVirtualenv(project)
+	require.Len(t, problems, 1)
+	require.Contains(t, problems[0].Summary, "is not the project's")
+	require.Empty(t, diagnoseVirtualenv(""))
+	require.Empty(t, diagnoseVirtualenv(filepath.Join(dir, "missing")))
+
+	os.Setenv("VIRTUAL_ENV", filepath.Join(dir, "gone"))
+	problems = diagnoseVirtualenv(project)
+	require.Len(t, problems, 1)
+	require.Contains(t, problems[0].Summary, "does not exist")
+}
+
+func TestPythonExecEnv(t *testing.T) {
+	require.Equal(t, api.ExecEnv{}, pythonExecEnv("python3", ""))
+
+	dir := t.TempDir()
+	venv := filepath.Join(dir, ".venv")
+	require.Equal(t, api.ExecEnv{
+		Path: []string{filepath.Join(venv, "bin")},
+		Vars: map[string]string{"VIRTUAL_ENV": venv},
+	}, pythonExecEnv("python3", venv))
+}
@@ -0,0 +1,214 @@
+package racket
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/replit/upm/internal/api"
+)
+
+// infoDeps is the deps list found in an info.rkt file.
+type infoDeps struct {
+	// The list itself, either '(...) or (list ...).
+	list sexp
+	// The elements of the list that are dependencies, which
+	// excludes the list symbol of (list ...).
+	elems []sexp
+}
+
+// findInfoDeps returns the deps list defined in the given info.rkt
+// contents, and false if there is none.
+func findInfoDeps(contents string) (infoDeps, bool) {
+	for _, expr := range readAll(contents) {
+		if expr.head() != "define" || len(expr.list) != 3 {
+			continue
+		}
+		if name := expr.list[1]; name.isList || name.str || name.atom != "deps" {
+			continue
+		}
+		list := expr.list[2]
+		if !list.isList {
+			continue
+		}
+		elems := list.list
+		if !list.quoted {
+			if list.head() != "list" {
+				continue
+			}
+			elems = elems[1:]
+		}
+		return infoDeps{list: list, elems: elems}, true
+	}
+	return infoDeps{}, false
+}
+
+// parseInfoDep returns the name and version of a dependency in the
+// deps list of info.rkt, which is either "name" or a list such as
+// ("name" #:version "1.0"). ok is false if the element is neither.
+func parseInfoDep(elem sexp) (name api.PkgName, spec api.PkgSpec, ok bool) {
+	if elem.str {
+		return api.PkgName(elem.atom), "", true
+	}
+	if !elem.isList || len(elem.list) == 0 || !elem.list[0].str {
+		return "", "", false
+	}
+	name = api.PkgName(elem.list[0].atom)
+	for i := 1; i+1 < len(elem.list); i++ {
+		if elem.list[i].atom == "#:version" && elem.list[i+1].str {
+			spec = api.PkgSpec(elem.list[i+1].atom)
+		}
+	}
+	return name, spec, true
+}
+
+// listInfoDeps returns the dependencies in the given info.rkt
+// contents.
+func listInfoDeps(contents string) map[api.PkgName]api.PkgSpec {
+	pkgs := map[api.PkgName]api.PkgSpec{}
+	deps, ok := findInfoDeps(contents)
+	if !ok {
+		return pkgs
+	}
+	for _, elem := range deps.elems {
+		if name, spec, ok := parseInfoDep(elem); ok {
+			pkgs[name] = spec
+		}
+	}
+	return pkgs
+}
+
+// formatInfoDep returns the deps list element for the given package.
+func formatInfoDep(name api.PkgName, spec api.PkgSpec) string {
+	if spec == "" {
+		return strconv.Quote(string(name))
+	}
+	return fmt.Sprintf("(%s #:version %s)", strconv.Quote(string(name)), strconv.Quote(string(spec)))
+}
+
+// lineIndent returns the indentation of the line that contains the
+// given offset, or the empty string if something else comes before
+// the offset on that line.
+func lineIndent(contents string, offset int) string {
+	start := strings.LastIndexByte(contents[:offset], '\n') + 1
+	indent := contents[start:offset]
+	if strings.TrimLeft(indent, " \t") != "" {
+		return ""
+	}
+	return indent
+}
+
+// addToInfoDeps returns the given info.rkt contents with the given
+// packages added to the end of the deps list, which is defined at the
+// end of the file if necessary. If the list puts each dependency on
+// its own line, so do the new ones.
+func addToInfoDeps(contents string, pkgs map[api.PkgName]api.PkgSpec) string {
+	names := []string{}
+	for name := range pkgs {
+		names = append(names, string(name))
+	}
+	sort.Strings(names)
+	added := []string{}
+	for _, name := range names {
+		added = append(added, formatInfoDep(api.PkgName(name), pkgs[api.PkgName(name)]))
+	}
+
+	deps, ok := findInfoDeps(contents)
+	if !ok {
+		if contents != "" && !strings.HasSuffix(contents, "\n") {
+			contents += "\n"
+		}
+		return contents + fmt.Sprintf("(define deps '(%s))\n", strings.Join(added, " "))
+	}
+
+	sep := " "
+	if n := len(deps.elems); n > 0 {
+		if indent := lineIndent(contents, deps.elems[n-1].start); indent != "" {
+			sep = "\n" + indent
+		}
+	}
+	insert := deps.list.end - 1
+	prefix := sep
+	if len(deps.elems) == 0 {
+		prefix = ""
+		if deps.list.head() == "list" {
+			prefix = " "
+		}
+	} else {
+		// Insert just after the last element, which works the
+		// same whether or not the list is closed on a line of
+		// its own.
+		insert = deps.elems[len(deps.elems)-1].end
+	}
+	return contents[:insert] + prefix + strings.Join(added, sep) + contents[insert:]
+}
+
+// removeFromInfoDeps returns the given info.rkt contents with the
+// given packages deleted from the deps list, along with the space
+// that separated them from the previous element (or, for those at the
+// start of the list, the next one) and any comment that follows them
+// on the same line.
+func removeFromInfoDeps(contents string, pkgs map[api.PkgName]bool) string {
+	deps, ok := findInfoDeps(contents)
+	if !ok {
+		return contents
+	}
+	elems := deps.elems
+	removed := func(i int) bool {
+		name, _, ok := parseInfoDep(elems[i])
+		return ok && pkgs[name]
+	}
+
+	// The spans to delete, which never overlap, in order.
+	type span struct{ start, end int }
+	spans := []span{}
+	prefix := 0
+	for prefix < len(elems) && removed(prefix) {
+		prefix++
+	}
+	switch {
+	case prefix == len(elems) && prefix > 0:
+		start := skipWhitespaceBackward(contents, elems[0].start)
+		end := skipWhitespace(contents, elems[prefix-1].end)
+		spans = append(spans, span{start, end})
+	case prefix > 0:
+		spans = append(spans, span{elems[0].start, elems[prefix].start})
+	}
+	for i := prefix; i < len(elems); i++ {
+		if removed(i) {
+			end := elems[i].end
+			end += len(trailingCommentRegexp.FindString(contents[end:]))
+			spans = append(spans, span{elems[i-1].end, end})
+		}
+	}
+
+	// Delete from the end so that the offsets of earlier spans
+	// stay the same.
+	for i := len(spans) - 1; i >= 0; i-- {
+		contents = contents[:spans[i].start] + contents[spans[i].end:]
+	}
+	return contents
+}
+
+// trailingCommentRegexp matches a comment at the end of a line.
+var trailingCommentRegexp = regexp.MustCompile(`^[ \t]*;[^\n]*`)
+
+// skipWhitespace returns the offset of the first character in src at
+// or after i that is not whitespace.
+func skipWhitespace(src string, i int) int {
+	for i < len(src) && strings.IndexByte(" \t\r\n", src[i]) != -1 {
+		i++
+	}
+	return i
+}
+
+// skipWhitespaceBackward returns the offset just after the last
+// character in src before i that is not whitespace.
+func skipWhitespaceBackward(src string, i int) int {
+	for i > 0 && strings.IndexByte(" \t\r\n", src[i-1]) != -1 {
+		i--
+	}
+	return i
+}
@@ -0,0 +1,296 @@
+// Package racket provides a backend for Racket using raco.
+package racket
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/replit/upm/internal/api"
+	"github.com/replit/upm/internal/util"
+)
+
+// racketPatterns is the FilenamePatterns value for RacoBackend.
+var racketPatterns = []string{"*.rkt"}
+
+// racketCatalogURL is the listing of every package in the main Racket
+// package catalog, which is what raco uses by default.
+const racketCatalogURL = "https://pkgs.racket-lang.org/pkgs-all.json.gz"
+
+// racketLockfile is where Install records the packages raco has
+// installed, since raco has no lockfile of its own.
+const racketLockfile = "racket-packages.txt"
+
+// catalogPackage represents a package in the Racket package catalog.
+type catalogPackage struct {
+	Name        string   `json:"name"`
+	Description string   `json:"description"`
+	Author      string   `json:"author"`
+	Source      string   `json:"source"`
+	Checksum    string   `json:"checksum"`
+	Tags        []string `json:"tags"`
+
+	// Each element is either the name of a package or a list
+	// such as ["name", "#:version", "1.0"].
+	Dependencies []json.RawMessage `json:"dependencies"`
+}
+
+// getCatalog downloads the Racket package catalog, keyed by package
+// name.
+func getCatalog(ctx context.Context) map[string]catalogPackage {
+	resp, err := util.HTTPGet(ctx, racketCatalogURL)
+	if err != nil {
+		util.Die("Racket package catalog: %s", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		util.Die("Racket package catalog: HTTP status %d", resp.StatusCode)
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		util.Die("Racket package catalog: %s", err)
+	}
+	// The listing is served as a gzip file, which net/http only
+	// decompresses for us if it is sent with a Content-Encoding.
+	if bytes.HasPrefix(body, []byte{0x1f, 0x8b}) {
+		r, err := gzip.NewReader(bytes.NewReader(body))
+		if err != nil {
+			util.Die("Racket package catalog: %s", err)
+		}
+		body, err = ioutil.ReadAll(r)
+		if err != nil {
+			util.Die("Racket package catalog: %s", err)
+		}
+	}
+
+	var catalog map[string]catalogPackage
+	if err := json.Unmarshal(body, &catalog); err != nil {
+		util.Die("Racket package catalog: %s", err)
+	}
+	return catalog
+}
+
+// search implements Search for raco. Packages whose name, description,
+// or tags contain the query are returned, with an exact match on the
+// name first.
+func search(ctx context.Context, query string) []api.PkgInfo {
+	query = strings.ToLower(query)
+	matches := []catalogPackage{}
+	for _, pkg := range getCatalog(ctx) {
+		if strings.Contains(strings.ToLower(pkg.Name), query) ||
+			strings.Contains(strings.ToLower(pkg.Description), query) {
+			matches = append(matches, pkg)
+			continue
+		}
+		for _, tag := range pkg.Tags {
+			if strings.ToLower(tag) == query {
+				matches = append(matches, pkg)
+				break
+			}
+		}
+	}
+	sort.Slice(matches, func(i, j int) bool {
+		if (matches[i].Name == query) != (matches[j].Name == query) {
+			return matches[i].Name == query
+		}
+		return matches[i].Name < matches[j].Name
+	})
+
+	results := []api.PkgInfo{}
+	for _, pkg := range matches {
+		results = append(results, api.PkgInfo{
+			Name:        pkg.Name,
+			Description: pkg.Description,
+		})
+	}
+	return results
+}
+
+// info implements Info for raco. The catalog does not record versions,
+// so the checksum of the package source is given instead.
+func info(ctx context.Context, name api.PkgName) api.PkgInfo {
+	pkg, ok := getCatalog(ctx)[string(name)]
+	if !ok {
+		return api.PkgInfo{}
+	}
+
+	deps := []string{}
+	for _, raw := range pkg.Dependencies {
+		var dep string
+		if json.Unmarshal(raw, &dep) != nil {
+			var list []interface{}
+			if json.Unmarshal(raw, &list) != nil || len(list) == 0 {
+				continue
+			}
+			if dep, ok = list[0].(string); !ok {
+				continue
+			}
+		}
+		deps = append(deps, dep)
+	}
+	sort.Strings(deps)
+
+	sourceURL := ""
+	if strings.HasPrefix(pkg.Source, "http://") || strings.HasPrefix(pkg.Source, "https://") {
+		sourceURL = pkg.Source
+	}
+
+	return api.PkgInfo{
+		Name:          pkg.Name,
+		Description:   pkg.Description,
+		Version:       pkg.Checksum,
+		HomepageURL:   util.JoinURL("https://pkgs.racket-lang.org/package", pkg.Name),
+		SourceCodeURL: sourceURL,
+		Author:        pkg.Author,
+		Dependencies:  deps,
+	}
+}
+
+// readInfo returns the contents of info.rkt.
+func readInfo() string {
+	contentsB, err := ioutil.ReadFile("info.rkt")
+	if err != nil {
+		util.Die("info.rkt: %s", err)
+	}
+	return string(contentsB)
+}
+
+// add implements Add for raco. If there is no info.rkt yet, one is
+// created that defines a collection named after the project.
+func add(ctx context.Context, pkgs map[api.PkgName]api.PkgSpec, projectName string) {
+	contents := ""
+	if util.Exists("info.rkt") {
+		contents = readInfo()
+	} else {
+		if projectName == "" {
+			cwd, err := os.Getwd()
+			if err != nil {
+				util.Die("%s", err)
+			}
+			projectName = filepath.Base(cwd)
+		}
+		contents = fmt.Sprintf("#lang info\n(define collection %q)\n", projectName)
+	}
+
+	contents = addToInfoDeps(contents, pkgs)
+	util.ProgressMsg("write info.rkt")
+	util.TryWriteAtomic("info.rkt", []byte(contents))
+}
+
+// remove implements Remove for raco.
+func remove(ctx context.Context, pkgs map[api.PkgName]bool) {
+	contents := removeFromInfoDeps(readInfo(), pkgs)
+	util.ProgressMsg("write info.rkt")
+	util.TryWriteAtomic("info.rkt", []byte(contents))
+}
+
+// install implements Install for raco. Packages are installed in user
+// scope along with their dependencies, and those that are installed
+// already are left alone.
+func install(ctx context.Context) {
+	names := []string{}
+	for name := range listInfoDeps(readInfo()) {
+		names = append(names, string(name))
+	}
+	if len(names) > 0 {
+		sort.Strings(names)
+		cmd := []string{
+			"raco", "pkg", "install", "--auto", "--skip-installed",
+			"--batch", "--scope", "user",
+		}
+		util.RunCmd(ctx, append(cmd, names...))
+	}
+
+	outputB := util.GetCmdOutput(ctx, []string{
+		"racket", "-e", util.GetResource("/racket/list-installed.rkt"),
+	})
+	util.ProgressMsg("write " + racketLockfile)
+	util.TryWriteAtomic(racketLockfile, outputB)
+}
+
+// listLockfile implements ListLockfile for raco.
+func listLockfile() map[api.PkgName]api.PkgVersion {
+	contentsB, err := ioutil.ReadFile(racketLockfile)
+	if err != nil {
+		util.Die("%s: %s", racketLockfile, err)
+	}
+	pkgs := map[api.PkgName]api.PkgVersion{}
+	for _, line := range strings.Split(string(contentsB), "\n") {
+		fields := strings.SplitN(line, "=", 2)
+		if len(fields) == 2 {
+			pkgs[api.PkgName(fields[0])] = api.PkgVersion(fields[1])
+		}
+	}
+	return pkgs
+}
+
+// guess implements Guess for raco. Modules from the base package and
+// from the collection defined by the project's own info.rkt are
+// skipped.
+func guess(ctx context.Context) (map[api.PkgName]bool, bool) {
+	own := ""
+	if util.Exists("info.rkt") {
+		for _, expr := range readAll(readInfo()) {
+			if expr.head() == "define" && len(expr.list) == 3 &&
+				expr.list[1].atom == "collection" && expr.list[2].str {
+				own = expr.list[2].atom
+			}
+		}
+	}
+
+	modules := []string{}
+	for _, match := range util.SearchRecursive(ctx, requireRegexp, racketPatterns) {
+		modules = append(modules, requiredModules(match[1])...)
+	}
+	for _, match := range util.SearchRecursive(ctx, langRegexp, racketPatterns) {
+		modules = append(modules, match[1])
+	}
+
+	pkgs := map[api.PkgName]bool{}
+	for _, module := range modules {
+		if strings.SplitN(module, "/", 2)[0] == own {
+			continue
+		}
+		if pkg := modulePackage(module); pkg != "" {
+			pkgs[pkg] = true
+		}
+	}
+	return pkgs, true
+}
+
+// RacoBackend is a UPM backend for Racket that uses raco.
+var RacoBackend = api.LanguageBackend{
+	Name:             "racket-raco",
+	Specfile:         "info.rkt",
+	Lockfile:         racketLockfile,
+	FilenamePatterns: racketPatterns,
+	Quirks:           api.QuirksNotReproducible,
+	Executables:      []string{"raco", "racket"},
+	GetPackageDir: func() string {
+		outputB := util.GetCmdOutput(context.Background(), []string{
+			"racket", "-l", "racket/base", "-l", "setup/dirs",
+			"-e", "(display (find-user-pkgs-dir))",
+		})
+		return string(outputB)
+	},
+	Search:  search,
+	Info:    info,
+	Add:     add,
+	Remove:  remove,
+	Install: install,
+	ListSpecfile: func() map[api.PkgName]api.PkgSpec {
+		return listInfoDeps(readInfo())
+	},
+	ListLockfile: listLockfile,
+	GuessRegexps: []*regexp.Regexp{requireRegexp, langRegexp},
+	Guess:        guess,
+}
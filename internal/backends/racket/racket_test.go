@@ -0,0 +1,111 @@
+package racket
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/replit/upm/internal/api"
+)
+
+const testInfo = `#lang info
+(define collection "demo")
+;; (define deps '("commented-out"))
+(define deps '("base"
+               ("gregor-lib" #:version "1.2") ; dates
+               "threading-lib"))
+(define build-deps '("rackunit-lib"))
+`
+
+func TestListInfoDeps(t *testing.T) {
+	require.Equal(t, map[api.PkgName]api.PkgSpec{
+		"base":          "",
+		"gregor-lib":    "1.2",
+		"threading-lib": "",
+	}, listInfoDeps(testInfo))
+
+	require.Equal(t, map[api.PkgName]api.PkgSpec{
+		"base": "",
+		"json": "",
+	}, listInfoDeps("#lang info\n(define deps (list \"base\" \"json\"))\n"))
+
+	require.Equal(t, map[api.PkgName]api.PkgSpec{}, listInfoDeps("#lang info\n"))
+}
+
+func TestAddToInfoDeps(t *testing.T) {
+	require.Equal(t, `#lang info
+(define collection "demo")
+;; (define deps '("commented-out"))
+(define deps '("base"
+               ("gregor-lib" #:version "1.2") ; dates
+               "threading-lib"
+               "a-lib"
+               ("b-lib" #:version "0.3")))
+(define build-deps '("rackunit-lib"))
+`, addToInfoDeps(testInfo, map[api.PkgName]api.PkgSpec{"b-lib": "0.3", "a-lib": ""}))
+
+	require.Equal(t,
+		"#lang info\n(define deps '(\"base\" \"json\"))\n",
+		addToInfoDeps("#lang info\n(define deps '(\"base\"))\n", map[api.PkgName]api.PkgSpec{"json": ""}),
+	)
+	require.Equal(t,
+		"#lang info\n(define deps (list \"json\"))\n",
+		addToInfoDeps("#lang info\n(define deps (list))\n", map[api.PkgName]api.PkgSpec{"json": ""}),
+	)
+	require.Equal(t,
+		"#lang info\n(define collection \"demo\")\n(define deps '(\"json\"))\n",
+		addToInfoDeps("#lang info\n(define collection \"demo\")", map[api.PkgName]api.PkgSpec{"json": ""}),
+	)
+}
+
+func TestRemoveFromInfoDeps(t *testing.T) {
+	require.Equal(t, `#lang info
+(define collection "demo")
+;; (define deps '("commented-out"))
+(define deps '("base"
+               "threading-lib"))
+(define build-deps '("rackunit-lib"))
+`, removeFromInfoDeps(testInfo, map[api.PkgName]bool{"gregor-lib": true, "rackunit-lib": true}))
+
+	require.Equal(t, `#lang info
+(define collection "demo")
+;; (define deps '("commented-out"))
+(define deps '("threading-lib"))
+(define build-deps '("rackunit-lib"))
+`, removeFromInfoDeps(testInfo, map[api.PkgName]bool{"base": true, "gregor-lib": true}))
+
+	require.Equal(t,
+		"#lang info\n(define deps '())\n",
+		removeFromInfoDeps("#lang info\n(define deps '( \"a\" \"b\" ))\n", map[api.PkgName]bool{"a": true, "b": true}),
+	)
+	require.Equal(t,
+		"#lang info\n(define deps (list))\n",
+		removeFromInfoDeps("#lang info\n(define deps (list \"a\"))\n", map[api.PkgName]bool{"a": true}),
+	)
+}
+
+func TestRequiredModules(t *testing.T) {
+	match := requireRegexp.FindStringSubmatch(`#lang racket/base
+(require racket/list
+         "util.rkt"
+         (only-in gregor date today)
+         (prefix-in h: (lib "html-parsing/main.rkt"))
+         (for-syntax syntax/parse (only-in threading ~>))
+         (submod "." test))
+`)
+	require.NotNil(t, match)
+	require.Equal(t, []string{
+		"racket/list",
+		"gregor",
+		"html-parsing/main.rkt",
+		"syntax/parse",
+		"threading",
+	}, requiredModules(match[1]))
+}
+
+func TestModulePackage(t *testing.T) {
+	require.Equal(t, api.PkgName(""), modulePackage("racket/list"))
+	require.Equal(t, api.PkgName("gui-lib"), modulePackage("racket/gui/base"))
+	require.Equal(t, api.PkgName("rackunit-lib"), modulePackage("rackunit"))
+	require.Equal(t, api.PkgName("gregor"), modulePackage("gregor/period"))
+}
@@ -0,0 +1,153 @@
+package racket
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/replit/upm/internal/api"
+)
+
+// requireRegexp matches a require form, capturing its contents. Go
+// regexps can't match balanced parentheses, so this only allows two
+// levels of nesting, which is enough for forms such as (require
+// (for-syntax (only-in racket/list first))).
+var requireRegexp = regexp.MustCompile(
+	`[(\[]\s*require\s+((?:[^()\[\]]|[(\[](?:[^()\[\]]|[(\[][^()\[\]]*[)\]])*[)\]])*)[)\]]`,
+)
+
+// langRegexp matches a #lang line, capturing the language, which is a
+// module like any other.
+var langRegexp = regexp.MustCompile(`(?m)^#lang[ \t]+([^\s]+)`)
+
+// requiredModules returns the module paths named by the given require
+// specs, which are the contents of a require form. Relative paths
+// (which are strings) and the other kinds of module path that refer
+// to files are skipped, since they are part of the project.
+func requiredModules(specs string) []string {
+	modules := []string{}
+	var visit func(spec sexp)
+	visit = func(spec sexp) {
+		if !spec.isList {
+			if !spec.str && spec.atom != "" && !strings.HasPrefix(spec.atom, "#") {
+				modules = append(modules, spec.atom)
+			}
+			return
+		}
+		args := spec.list
+		if len(args) > 0 {
+			args = args[1:]
+		}
+		switch spec.head() {
+		case "only-in", "except-in", "rename-in", "prefix-all-except":
+			// (only-in module id ...)
+			if len(args) > 0 {
+				visit(args[0])
+			}
+		case "prefix-in":
+			// (prefix-in prefix module)
+			if len(args) > 1 {
+				visit(args[1])
+			}
+		case "for-syntax", "for-template", "for-label", "for-space", "combine-in":
+			for _, arg := range args {
+				visit(arg)
+			}
+		case "for-meta":
+			// (for-meta level spec ...)
+			for i := 1; i < len(args); i++ {
+				visit(args[i])
+			}
+		case "lib":
+			// (lib "collection/module")
+			if len(args) > 0 && args[0].str {
+				modules = append(modules, args[0].atom)
+			}
+		case "submod":
+			// (submod module submodule ...)
+			if len(args) > 0 {
+				visit(args[0])
+			}
+		}
+	}
+	i := 0
+	for {
+		spec, ok := readSexp(specs, i)
+		if !ok {
+			return modules
+		}
+		visit(spec)
+		i = spec.end
+	}
+}
+
+// baseCollections are collections provided by the base package, which
+// every Racket installation has.
+var baseCollections = map[string]bool{
+	"at-exp":   true,
+	"compiler": true,
+	"dynext":   true,
+	"ffi":      true,
+	"file":     true,
+	"info":     true,
+	"json":     true,
+	"launcher": true,
+	"net":      true,
+	"openssl":  true,
+	"pkg":      true,
+	"planet":   true,
+	"raco":     true,
+	"racket":   true,
+	"reader":   true,
+	"s-exp":    true,
+	"setup":    true,
+	"syntax":   true,
+	"version":  true,
+	"xml":      true,
+}
+
+// collectionPackages maps collections (or, for collections that are
+// split across packages, module path prefixes) to the packages that
+// provide them, where they aren't named after the collection.
+var collectionPackages = map[string]api.PkgName{
+	"2htdp":        "htdp-lib",
+	"data":         "data-lib",
+	"db":           "db-lib",
+	"framework":    "gui-lib",
+	"htdp":         "htdp-lib",
+	"math":         "math-lib",
+	"mrlib":        "gui-lib",
+	"parser-tools": "parser-tools-lib",
+	"pict":         "pict-lib",
+	"plot":         "plot-lib",
+	"racket/draw":  "draw-lib",
+	"racket/gui":   "gui-lib",
+	"racket/snip":  "snip-lib",
+	"rackunit":     "rackunit-lib",
+	"redex":        "redex-lib",
+	"scribble":     "scribble-lib",
+	"slideshow":    "slideshow-lib",
+	"typed":        "typed-racket-lib",
+	"typed-racket": "typed-racket-lib",
+	"web-server":   "web-server-lib",
+}
+
+// modulePackage returns the package that probably provides the given
+// module path, or the empty string if it is provided by the base
+// package. Apart from the collections in collectionPackages, packages
+// are assumed to be named after the collection they provide.
+func modulePackage(module string) api.PkgName {
+	parts := strings.Split(module, "/")
+	if len(parts) > 1 {
+		if pkg, ok := collectionPackages[parts[0]+"/"+parts[1]]; ok {
+			return pkg
+		}
+	}
+	collection := parts[0]
+	if pkg, ok := collectionPackages[collection]; ok {
+		return pkg
+	}
+	if baseCollections[collection] {
+		return ""
+	}
+	return api.PkgName(collection)
+}
@@ -0,0 +1,171 @@
+package racket
+
+import (
+	"strings"
+)
+
+// sexp is an S-expression read from Racket source, along with where it
+// was found, so that parts of a file can be rewritten while leaving
+// the rest exactly as it was.
+type sexp struct {
+	// For atoms: the text of the atom, which for strings is
+	// their contents without quotes or escapes.
+	atom string
+	// True if the atom is a string.
+	str bool
+
+	// For lists (including vectors): the elements.
+	list   []sexp
+	isList bool
+
+	// True if the expression was quoted with '.
+	quoted bool
+
+	// The offsets of the start (including any quote) and end of
+	// the expression in the source.
+	start int
+	end   int
+}
+
+// head returns the first element of a list if it is a symbol, or the
+// empty string otherwise.
+func (s sexp) head() string {
+	if !s.isList || len(s.list) == 0 || s.list[0].isList || s.list[0].str {
+		return ""
+	}
+	return s.list[0].atom
+}
+
+// isDelimiter returns true if the given byte ends a symbol.
+func isDelimiter(c byte) bool {
+	return strings.IndexByte(" \t\r\n()[]{}\";'`,", c) != -1
+}
+
+// skipSpace returns the offset of the next expression in src at or
+// after i, skipping whitespace, line comments, and #| block comments
+// |#.
+func skipSpace(src string, i int) int {
+	for i < len(src) {
+		switch {
+		case strings.IndexByte(" \t\r\n", src[i]) != -1:
+			i++
+		case src[i] == ';':
+			for i < len(src) && src[i] != '\n' {
+				i++
+			}
+		case strings.HasPrefix(src[i:], "#|"):
+			end := strings.Index(src[i+2:], "|#")
+			if end == -1 {
+				return len(src)
+			}
+			i += 2 + end + 2
+		default:
+			return i
+		}
+	}
+	return i
+}
+
+// readSexp reads the expression at offset i of src (after any
+// whitespace) and returns it. ok is false if there is no expression
+// there, either because the end of a list or of the source was
+// reached or because the source is malformed.
+func readSexp(src string, i int) (expr sexp, ok bool) {
+	i = skipSpace(src, i)
+	if i >= len(src) {
+		return sexp{}, false
+	}
+	start := i
+
+	quoted := false
+	if src[i] == '\'' {
+		quoted = true
+		i++
+	} else if strings.HasPrefix(src[i:], "#(") {
+		// Treat vectors as lists.
+		i++
+	}
+	if i >= len(src) {
+		return sexp{}, false
+	}
+
+	switch c := src[i]; {
+	case c == '(' || c == '[' || c == '{':
+		close := map[byte]byte{'(': ')', '[': ']', '{': '}'}[c]
+		elems := []sexp{}
+		i++
+		for {
+			i = skipSpace(src, i)
+			if i >= len(src) {
+				return sexp{}, false
+			}
+			if src[i] == close {
+				i++
+				break
+			}
+			elem, ok := readSexp(src, i)
+			if !ok {
+				return sexp{}, false
+			}
+			elems = append(elems, elem)
+			i = elem.end
+		}
+		return sexp{list: elems, isList: true, quoted: quoted, start: start, end: i}, true
+	case c == ')' || c == ']' || c == '}':
+		return sexp{}, false
+	case c == '"':
+		var b strings.Builder
+		i++
+		for i < len(src) && src[i] != '"' {
+			if src[i] == '\\' && i+1 < len(src) {
+				i++
+				switch src[i] {
+				case 'n':
+					b.WriteByte('\n')
+				case 't':
+					b.WriteByte('\t')
+				default:
+					b.WriteByte(src[i])
+				}
+			} else {
+				b.WriteByte(src[i])
+			}
+			i++
+		}
+		if i >= len(src) {
+			return sexp{}, false
+		}
+		return sexp{atom: b.String(), str: true, quoted: quoted, start: start, end: i + 1}, true
+	default:
+		j := i
+		for j < len(src) && !isDelimiter(src[j]) {
+			j++
+		}
+		if j == i {
+			return sexp{}, false
+		}
+		return sexp{atom: src[i:j], quoted: quoted, start: start, end: j}, true
+	}
+}
+
+// readAll reads every top-level expression in the given Racket
+// source, after the #lang line if there is one. Reading stops at the
+// first malformed expression.
+func readAll(src string) []sexp {
+	i := 0
+	if strings.HasPrefix(src, "#lang") {
+		i = strings.IndexByte(src, '\n')
+		if i == -1 {
+			return []sexp{}
+		}
+	}
+	exprs := []sexp{}
+	for {
+		expr, ok := readSexp(src, i)
+		if !ok {
+			return exprs
+		}
+		exprs = append(exprs, expr)
+		i = expr.end
+	}
+}
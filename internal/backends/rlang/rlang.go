@@ -1,6 +1,7 @@
 package rlang
 
 import (
+	"context"
 	"os"
 	"path"
 	"regexp"
@@ -19,7 +20,7 @@ func getRPkgDir() string {
 		return rLibsUser
 	}
 
-	libPath := string(util.GetCmdOutput([]string{
+	libPath := string(util.GetCmdOutput(context.Background(), []string{
 		"R",
 		"-s",
 		"-e",
@@ -45,12 +46,12 @@ func createRPkgDir() {
 	}
 }
 
-func installRPkg(name string) bool {
+func installRPkg(ctx context.Context, name string) bool {
 	name = normalizePkgName(name)
 
 	ifNotInstalled := "if(length(find.package('" + name + "', quiet=T)) == 0) "
 
-	return util.GetExitCode([]string{
+	return util.GetExitCode(ctx, []string{
 		"R",
 		"-q",
 		"-e",
@@ -79,12 +80,14 @@ var RlangBackend = api.LanguageBackend{
 	Name:             "rlang",
 	Specfile:         "Rconfig.json",
 	Lockfile:         "Rconfig.lock.json",
+	OSVEcosystem:     "CRAN",
 	FilenamePatterns: []string{"*.r", "*.R"},
 	Quirks:           api.QuirksNone,
+	Executables:      []string{"R"},
 	GetPackageDir:    getRPkgDir,
-	Search: func(query string) []api.PkgInfo {
+	Search: func(ctx context.Context, query string) []api.PkgInfo {
 		pkgs := []api.PkgInfo{}
-		for _, hit := range SearchPackages(query) {
+		for _, hit := range SearchPackages(ctx, query) {
 			pkg := api.PkgInfo{
 				Name:             hit.Source.Package,
 				Description:      hit.Source.Title,
@@ -102,8 +105,8 @@ var RlangBackend = api.LanguageBackend{
 		}
 		return pkgs
 	},
-	Info: func(name api.PkgName) api.PkgInfo {
-		if pkg := SearchPackage(string(name)); pkg != nil {
+	Info: func(ctx context.Context, name api.PkgName) api.PkgInfo {
+		if pkg := SearchPackage(ctx, string(name)); pkg != nil {
 			hit := *pkg
 			return api.PkgInfo{
 				Name:             hit.Source.Package,
@@ -121,7 +124,7 @@ var RlangBackend = api.LanguageBackend{
 
 		return api.PkgInfo{}
 	},
-	Add: func(packages map[api.PkgName]api.PkgSpec, projectName string) {
+	Add: func(ctx context.Context, packages map[api.PkgName]api.PkgSpec, projectName string) {
 		for name, info := range packages {
 			RAdd(RPackage{
 				Name:    string(name),
@@ -129,11 +132,11 @@ var RlangBackend = api.LanguageBackend{
 			})
 		}
 	},
-	Remove: func(packages map[api.PkgName]bool) {
+	Remove: func(ctx context.Context, packages map[api.PkgName]bool) {
 		for name := range packages {
 			RRemove(RPackage{Name: string(name)})
 
-			_ = util.GetExitCode([]string{
+			_ = util.GetExitCode(ctx, []string{
 				"R",
 				"-q",
 				"-e",
@@ -141,12 +144,14 @@ var RlangBackend = api.LanguageBackend{
 			}, false, true)
 		}
 	},
-	Lock: RLock,
-	Install: func() {
+	Lock: func(ctx context.Context) {
+		RLock()
+	},
+	Install: func(ctx context.Context) {
 		createRPkgDir()
 
 		for _, pkg := range RGetSpecFile().Packages {
-			if !installRPkg(pkg.Name) {
+			if !installRPkg(ctx, pkg.Name) {
 				RRemove(pkg)
 				RLock()
 			}
@@ -167,7 +172,7 @@ var RlangBackend = api.LanguageBackend{
 		return pkgs
 	},
 	//GuessRegexps: []*regexp.Regexp {regexp.MustCompile(`\brequire[ \t]*\(\s*([a-zA-Z_]\w*)\s*`)},
-	Guess: func() (map[api.PkgName]bool, bool) {
+	Guess: func(ctx context.Context) (map[api.PkgName]bool, bool) {
 		util.NotImplemented()
 
 		return nil, false
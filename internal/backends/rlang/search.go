@@ -1,12 +1,14 @@
 package rlang
 
 import (
+	"context"
 	"encoding/json"
-	"net/http"
 	"net/url"
 	"sort"
 	"strconv"
 	"strings"
+
+	"github.com/replit/upm/internal/util"
 )
 
 // CranHitSource represents the JSON we get about the information for a single package from a package search
@@ -71,11 +73,11 @@ type CranResponse struct {
 	Hits     CranHits   `json:"hits"`
 }
 
-func searchPackages(name string, size int) CranResponse {
+func searchPackages(ctx context.Context, name string, size int) CranResponse {
 	// TODO: figure out how to deal with other mirrors
 	searchURL := "http://search.r-pkg.org/package/_search?q=" + url.QueryEscape(name) + "&size=" + strconv.Itoa(size)
 
-	if req, err := http.Get(searchURL); err == nil {
+	if req, err := util.HTTPGet(ctx, searchURL); err == nil {
 		var res CranResponse
 
 		decoder := json.NewDecoder(req.Body)
@@ -91,9 +93,9 @@ func searchPackages(name string, size int) CranResponse {
 }
 
 // SearchPackages searches for the top (<= 50) package results
-func SearchPackages(name string) []CranHit {
-	res := searchPackages(name+"*", 0) // needed in order to get the total amount of matching packages
-	res = searchPackages(name+"*", res.Hits.Total)
+func SearchPackages(ctx context.Context, name string) []CranHit {
+	res := searchPackages(ctx, name+"*", 0) // needed in order to get the total amount of matching packages
+	res = searchPackages(ctx, name+"*", res.Hits.Total)
 
 	hits := []CranHit{}
 
@@ -115,9 +117,9 @@ func SearchPackages(name string) []CranHit {
 }
 
 // SearchPackage searches for the first package result
-func SearchPackage(name string) *CranHit {
-	res := searchPackages(name+"*", 0) // needed in order to get the total amount of matching packages
-	res = searchPackages(name+"*", res.Hits.Total)
+func SearchPackage(ctx context.Context, name string) *CranHit {
+	res := searchPackages(ctx, name+"*", 0) // needed in order to get the total amount of matching packages
+	res = searchPackages(ctx, name+"*", res.Hits.Total)
 
 	for _, hit := range res.Hits.Hits {
 		if hit.ID == name {
@@ -0,0 +1,305 @@
+// Package gemmap builds the map from the paths that Ruby code
+// requires, such as nokogiri or active_support/core_ext, to the gems
+// that provide them, for guessing the gems of a Ruby project, much as
+// pypimap does for Python. What a gem provides is read from the files
+// in the lib directory of its latest release, since its name often
+// isn't what is required: activesupport is required as active_support,
+// and rack-test as rack/test.
+package gemmap
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/replit/upm/internal/util"
+)
+
+// progressInterval is how often Crawl says how far it has got.
+const progressInterval = 2 * time.Second
+
+// DefaultRegistry is the URL of rubygems.org, which Crawl fetches the
+// gems from.
+const DefaultRegistry = "https://rubygems.org"
+
+// gemInfo is the part of the information about the latest release of
+// a gem, from /api/v1/gems/NAME.json, that Crawl uses.
+type gemInfo struct {
+	Name      string `json:"name"`
+	Version   string `json:"version"`
+	Downloads int    `json:"downloads"`
+	GemURI    string `json:"gem_uri"`
+}
+
+// Crawl fetches the latest release of every gem in the given registry,
+// and writes an Entry for each to the file out, one per line, with the
+// paths that its files can be required by.
+//
+// The entries are written to out + ".partial" as they are fetched, and
+// it replaces out when the crawl is done, so an interrupted crawl
+// leaves out as it was. Running it again with resume picks up where it
+// left off, without fetching the gems that are already in the partial
+// file again.
+func Crawl(registry string, out string, resume bool) {
+	partial := out + ".partial"
+	done := map[string]bool{}
+	flags := os.O_CREATE | os.O_WRONLY | os.O_APPEND
+	if resume {
+		for name := range readEntries(partial, true) {
+			done[name] = true
+		}
+	} else {
+		flags |= os.O_TRUNC
+	}
+	file, err := os.OpenFile(partial, flags, 0666)
+	if err != nil {
+		util.Die("%s", err)
+	}
+	defer file.Close()
+
+	util.ProgressMsg("list the gems in " + registry)
+	names := listGems(registry)
+	if len(done) > 0 {
+		util.Log(fmt.Sprintf("resuming: %d gems are already in %s", len(done), partial))
+	} else if resume {
+		util.Log(fmt.Sprintf("nothing to resume in %s, starting over", partial))
+	}
+
+	todo := []string{}
+	for _, name := range names {
+		if !done[name] {
+			todo = append(todo, name)
+		}
+	}
+
+	encoder := json.NewEncoder(file)
+	lastProgress := time.Now()
+	fetched := 0
+	for i, name := range todo {
+		if entry, ok := fetchEntry(registry, name); ok {
+			if err := encoder.Encode(entry); err != nil {
+				util.Die("%s: %s", partial, err)
+			}
+			fetched++
+		}
+
+		if time.Since(lastProgress) >= progressInterval || i == len(todo)-1 {
+			util.Log(fmt.Sprintf("%d of %d gems (%d fetched this run)",
+				len(names)-len(todo)+i+1, len(names), fetched))
+			lastProgress = time.Now()
+		}
+	}
+
+	if err := file.Close(); err != nil {
+		util.Die("%s", err)
+	}
+	if err := os.Rename(partial, out); err != nil {
+		util.Die("%s", err)
+	}
+}
+
+// readEntries returns the entries in the given file, left by an
+// earlier crawl, by the names of their gems. The file need not exist.
+// A line that was cut off when a crawl was interrupted is ignored, and
+// with truncate, removed, so the file can be appended to.
+func readEntries(filename string, truncate bool) map[string]Entry {
+	entries := map[string]Entry{}
+	file, err := os.Open(filename)
+	if os.IsNotExist(err) {
+		return entries
+	} else if err != nil {
+		util.Die("%s", err)
+	}
+	defer file.Close()
+
+	reader := bufio.NewReader(file)
+	var valid int64
+	for {
+		line, err := reader.ReadBytes('\n')
+		var entry Entry
+		if err != nil || json.Unmarshal(line, &entry) != nil {
+			if err != nil && err != io.EOF {
+				util.Die("%s: %s", filename, err)
+			}
+			break
+		}
+		entries[entry.Pkg] = entry
+		valid += int64(len(line))
+	}
+	if truncate {
+		if err := os.Truncate(filename, valid); err != nil {
+			util.Die("%s", err)
+		}
+	}
+	return entries
+}
+
+// tryFetch fetches the given URL and returns the response body, or nil
+// if there is no such resource.
+func tryFetch(url string) ([]byte, error) {
+	res, err := util.HTTPClient.Get(url)
+	if err != nil {
+		return nil, fmt.Errorf("HTTP Request failed with error: %s", err)
+	}
+	defer res.Body.Close()
+
+	switch res.StatusCode {
+	case 200:
+		break
+	case 404:
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("Received status code: %d", res.StatusCode)
+	}
+
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, fmt.Errorf("Res body read failed with error: %s", err)
+	}
+	return body, nil
+}
+
+// listGems returns the names of every gem in the registry, which lists
+// them one per line. If there is an error, it terminates the process,
+// since there is nothing to crawl without the list.
+func listGems(registry string) []string {
+	body, err := tryFetch(util.JoinURL(registry, "names"))
+	if err == nil && body == nil {
+		err = fmt.Errorf("not found")
+	}
+	if err != nil {
+		util.Die("%s: %s", registry, err)
+	}
+	names := []string{}
+	for _, line := range strings.Split(string(body), "\n") {
+		// The list starts with a line of dashes.
+		if line = strings.TrimSpace(line); line != "" && line != "---" {
+			names = append(names, line)
+		}
+	}
+	return names
+}
+
+// fetchEntry fetches the latest release of the given gem and reads
+// what it can be required by. It returns false if the gem has no
+// releases or can't be fetched, which is only reported, so that one
+// broken gem doesn't stop the crawl.
+func fetchEntry(registry string, name string) (Entry, bool) {
+	body, err := tryFetch(util.JoinURL(registry, "api", "v1", "gems", name+".json"))
+	if err != nil {
+		util.Log(fmt.Sprintf("warning: %s: %s", name, err))
+		return Entry{}, false
+	}
+	if body == nil {
+		// Every release of the gem was yanked.
+		return Entry{}, false
+	}
+	var info gemInfo
+	if err := json.Unmarshal(body, &info); err != nil {
+		util.Log(fmt.Sprintf("warning: %s: %s", name, err))
+		return Entry{}, false
+	}
+	if info.Name == "" {
+		info.Name = name
+	}
+	if info.GemURI == "" {
+		info.GemURI = util.JoinURL(registry, "gems", info.Name+"-"+info.Version+".gem")
+	}
+
+	contents, err := tryFetch(info.GemURI)
+	if err == nil && contents == nil {
+		err = fmt.Errorf("not found")
+	}
+	var requires []string
+	if err == nil {
+		requires, err = gemRequires(contents)
+	}
+	if err != nil {
+		util.Log(fmt.Sprintf("warning: %s: %s", info.GemURI, err))
+		return Entry{}, false
+	}
+	return Entry{
+		Pkg:       info.Name,
+		Requires:  requires,
+		Downloads: info.Downloads,
+		Version:   info.Version,
+	}, true
+}
+
+// gemRequires returns the paths that the files in the given .gem file
+// can be required by, as collectRequires does. A .gem file is a tar
+// archive that holds the files of the gem in data.tar.gz.
+func gemRequires(contents []byte) ([]string, error) {
+	archive := tar.NewReader(bytes.NewReader(contents))
+	for {
+		header, err := archive.Next()
+		if err == io.EOF {
+			return nil, fmt.Errorf("no data.tar.gz in the gem")
+		} else if err != nil {
+			return nil, err
+		}
+		if header.Name != "data.tar.gz" {
+			continue
+		}
+
+		data, err := gzip.NewReader(archive)
+		if err != nil {
+			return nil, err
+		}
+		files := []string{}
+		dataArchive := tar.NewReader(data)
+		for {
+			header, err := dataArchive.Next()
+			if err == io.EOF {
+				return collectRequires(files), nil
+			} else if err != nil {
+				return nil, err
+			}
+			if header.Typeflag == tar.TypeReg {
+				files = append(files, path.Clean(header.Name))
+			}
+		}
+	}
+}
+
+// collectRequires returns the paths that the given files of a gem can
+// be required by, sorted: that of each Ruby file in lib that isn't in
+// a directory with a file of its own to require it by. So lib/rack.rb
+// gives rack, but lib/rack/test.rb in the same gem gives nothing more,
+// while in net-ssh, which has no lib/net.rb, lib/net/ssh.rb gives
+// net/ssh. The rest are required by way of these, or as their paths
+// under them, which Guess tries these for.
+func collectRequires(files []string) []string {
+	all := map[string]bool{}
+	for _, file := range files {
+		if strings.HasPrefix(file, "lib/") && strings.HasSuffix(file, ".rb") {
+			all[strings.TrimSuffix(strings.TrimPrefix(file, "lib/"), ".rb")] = true
+		}
+	}
+
+	requires := []string{}
+	for require := range all {
+		covered := false
+		for dir := path.Dir(require); dir != "."; dir = path.Dir(dir) {
+			if all[dir] {
+				covered = true
+				break
+			}
+		}
+		if !covered {
+			requires = append(requires, require)
+		}
+	}
+	sort.Strings(requires)
+	return requires
+}
@@ -0,0 +1,94 @@
+package gemmap
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// makeTar returns a tar archive of the given files, with the given
+// contents.
+func makeTar(t *testing.T, files map[string][]byte, names ...string) []byte {
+	var buf bytes.Buffer
+	archive := tar.NewWriter(&buf)
+	for _, name := range names {
+		require.NoError(t, archive.WriteHeader(&tar.Header{
+			Name: name, Mode: 0644, Size: int64(len(files[name])), Typeflag: tar.TypeReg,
+		}))
+		_, err := archive.Write(files[name])
+		require.NoError(t, err)
+	}
+	require.NoError(t, archive.Close())
+	return buf.Bytes()
+}
+
+// makeGem returns a .gem file that holds the given files.
+func makeGem(t *testing.T, names ...string) []byte {
+	var data bytes.Buffer
+	gz := gzip.NewWriter(&data)
+	_, err := gz.Write(makeTar(t, map[string][]byte{}, names...))
+	require.NoError(t, err)
+	require.NoError(t, gz.Close())
+	return makeTar(t, map[string][]byte{
+		"metadata.gz": {},
+		"data.tar.gz": data.Bytes(),
+	}, "metadata.gz", "data.tar.gz")
+}
+
+func TestCollectRequires(t *testing.T) {
+	require.Equal(t, []string{"active_support"}, collectRequires([]string{
+		"lib/active_support.rb",
+		"lib/active_support/core_ext.rb",
+		"lib/active_support/core_ext/string.rb",
+		"README.md",
+		"test/helper.rb",
+	}))
+	// Without lib/net.rb, net is only a namespace.
+	require.Equal(t, []string{"net/scp", "net/ssh"}, collectRequires([]string{
+		"lib/net/ssh.rb",
+		"lib/net/ssh/version.rb",
+		"lib/net/scp.rb",
+		"lib/net/ssh/data/keys.txt",
+	}))
+}
+
+func TestCrawl(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/names":
+			fmt.Fprint(w, "---\nactivesupport\ngone\nrack-test\n")
+		case "/api/v1/gems/activesupport.json":
+			fmt.Fprint(w, `{"name": "activesupport", "version": "7.1.3", "downloads": 500000}`)
+		case "/api/v1/gems/rack-test.json":
+			fmt.Fprintf(w, `{"name": "rack-test", "version": "2.1.0", "downloads": 3000, "gem_uri": "http://%s/downloads/rack-test-2.1.0.gem"}`, r.Host)
+		case "/gems/activesupport-7.1.3.gem":
+			w.Write(makeGem(t, "lib/active_support.rb", "lib/active_support/all.rb"))
+		case "/downloads/rack-test-2.1.0.gem":
+			w.Write(makeGem(t, "lib/rack/test.rb", "lib/rack/test/utils.rb"))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	out := filepath.Join(t.TempDir(), "gems.json")
+	Crawl(server.URL, out, false)
+
+	contents, err := ioutil.ReadFile(out)
+	require.NoError(t, err)
+	require.Equal(t, strings.Join([]string{
+		`{"p":"activesupport","r":["active_support"],"d":500000,"v":"7.1.3"}`,
+		`{"p":"rack-test","r":["rack/test"],"d":3000,"v":"2.1.0"}`,
+		"",
+	}, "\n"), string(contents))
+	require.NoFileExists(t, out+".partial")
+}
@@ -0,0 +1,255 @@
+package gemmap
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/replit/upm/internal/backends/python/pypimap"
+	"github.com/replit/upm/internal/util"
+)
+
+// Entry is what Crawl found out about a gem, as one line of the JSON
+// file that Generate reads.
+type Entry struct {
+	// The name of the gem, such as activesupport.
+	Pkg string `json:"p"`
+	// The paths that the gem can be required by, as
+	// collectRequires returns them.
+	Requires []string `json:"r"`
+	// How many times the gem has been downloaded.
+	Downloads int `json:"d"`
+	// The version of the latest release of the gem, that the
+	// paths were read from, or empty if that isn't known.
+	Version string `json:"v,omitempty"`
+}
+
+// stdlibRequires are the libraries that come with Ruby, either built
+// in or as default gems, which are never guessed to need a gem, even
+// though some of them are on rubygems.org as well: yaml, for example,
+// is provided by psych, which Ruby already has.
+var stdlibRequires = map[string]bool{
+	"English":         true,
+	"abbrev":          true,
+	"base64":          true,
+	"benchmark":       true,
+	"bigdecimal":      true,
+	"bundler":         true,
+	"cgi":             true,
+	"continuation":    true,
+	"coverage":        true,
+	"date":            true,
+	"delegate":        true,
+	"did_you_mean":    true,
+	"digest":          true,
+	"drb":             true,
+	"erb":             true,
+	"error_highlight": true,
+	"etc":             true,
+	"expect":          true,
+	"fcntl":           true,
+	"fiber":           true,
+	"fiddle":          true,
+	"fileutils":       true,
+	"find":            true,
+	"forwardable":     true,
+	"getoptlong":      true,
+	"io/console":      true,
+	"io/nonblock":     true,
+	"io/wait":         true,
+	"ipaddr":          true,
+	"irb":             true,
+	"json":            true,
+	"logger":          true,
+	"monitor":         true,
+	"mutex_m":         true,
+	"net/http":        true,
+	"net/https":       true,
+	"net/protocol":    true,
+	"nkf":             true,
+	"objspace":        true,
+	"observer":        true,
+	"open-uri":        true,
+	"open3":           true,
+	"openssl":         true,
+	"optparse":        true,
+	"ostruct":         true,
+	"pathname":        true,
+	"pp":              true,
+	"prettyprint":     true,
+	"pstore":          true,
+	"psych":           true,
+	"pty":             true,
+	"racc":            true,
+	"rbconfig":        true,
+	"rdoc":            true,
+	"readline":        true,
+	"reline":          true,
+	"resolv":          true,
+	"ripper":          true,
+	"rubygems":        true,
+	"securerandom":    true,
+	"set":             true,
+	"shellwords":      true,
+	"singleton":       true,
+	"socket":          true,
+	"stringio":        true,
+	"strscan":         true,
+	"syslog":          true,
+	"tempfile":        true,
+	"thread":          true,
+	"time":            true,
+	"timeout":         true,
+	"tmpdir":          true,
+	"tsort":           true,
+	"un":              true,
+	"uri":             true,
+	"weakref":         true,
+	"win32ole":        true,
+	"yaml":            true,
+	"zlib":            true,
+}
+
+// IsStdlib returns true if the given path is required from the
+// libraries that come with Ruby, rather than from a gem.
+func IsStdlib(require string) bool {
+	return stdlibRequires[require]
+}
+
+// The confidences that Generate gives the gem that it guesses for a
+// path, from 0 to 1.
+const (
+	// A gem whose name is the path's, but for underscores,
+	// dashes, and slashes, as with active_support and
+	// activesupport, whatever its downloads.
+	nameMatchConfidence = 0.95
+	// The only gem that provides the path, or one with
+	// popularRatio times the downloads of the next.
+	onlyProviderConfidence = 0.9
+	// The most downloaded of gems that provide the path.
+	popularConfidence = 0.5
+	// Guesses that are less likely than this aren't kept at all.
+	minConfidence = 0.1
+)
+
+// popularDownloads and popularRatio are the thresholds of the
+// confidences. A gem with fewer downloads than popularDownloads is
+// guessed with a confidence that falls in proportion.
+const (
+	popularDownloads = 1000
+	popularRatio     = 10
+)
+
+// normalize returns the given path or name of a gem, lowercased and
+// without underscores, dashes, or slashes, so that a path and the gem
+// that is named after it match.
+func normalize(name string) string {
+	return strings.NewReplacer("_", "", "-", "", "/", "").Replace(strings.ToLower(name))
+}
+
+// guess returns the gem that the given path is guessed to be required
+// from and how likely that is, of the given gems that provide it.
+// Without counted, their downloads aren't known, and are taken to be
+// popular.
+func guess(require string, gems []Entry, counted bool) (string, float64) {
+	sort.Slice(gems, func(i, j int) bool {
+		if gems[i].Downloads != gems[j].Downloads {
+			return gems[i].Downloads > gems[j].Downloads
+		}
+		return gems[i].Pkg < gems[j].Pkg
+	})
+	for _, gem := range gems {
+		if normalize(gem.Pkg) == normalize(require) {
+			return gem.Pkg, nameMatchConfidence
+		}
+	}
+
+	confidence := popularConfidence
+	if len(gems) == 1 || gems[0].Downloads >= popularRatio*gems[1].Downloads {
+		confidence = onlyProviderConfidence
+	}
+	if counted && gems[0].Downloads < popularDownloads {
+		confidence = confidence * float64(gems[0].Downloads) / popularDownloads
+	}
+	return gems[0].Pkg, confidence
+}
+
+// table returns the pairs of keys and values of the table of the map
+// of the given entries: the guess for each path that they can be
+// required by, other than those of the standard library.
+func table(entries map[string]Entry, counted bool) [][2]string {
+	providers := map[string][]Entry{}
+	for _, entry := range entries {
+		for _, require := range entry.Requires {
+			if !IsStdlib(require) {
+				providers[require] = append(providers[require], entry)
+			}
+		}
+	}
+
+	guesses := [][2]string{}
+	for require, gems := range providers {
+		gem, confidence := guess(require, gems, counted)
+		if confidence >= minConfidence {
+			guesses = append(guesses, [2]string{require, pypimap.EncodeGuess(gem, confidence)})
+		}
+	}
+	return guesses
+}
+
+// Generate reads the entries in the JSON file from, as Crawl writes
+// them, and writes the Go source of the table of the map, in the given
+// Go package, to the file out, encoded by pypimap.EncodeTable.
+//
+// A map whose entries have no downloads at all, such as one written by
+// hand, is taken to hold only popular gems.
+func Generate(from string, pkg string, out string) {
+	file, err := os.Open(from)
+	if err != nil {
+		util.Die("%s", err)
+	}
+	defer file.Close()
+
+	entries := map[string]Entry{}
+	// Whether any entry has downloads.
+	counted := false
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(nil, 1<<20)
+	for scanner.Scan() {
+		var entry Entry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			util.Die("%s: %s", from, err)
+		}
+		entries[entry.Pkg] = entry
+		counted = counted || entry.Downloads > 0
+	}
+	if err := scanner.Err(); err != nil {
+		util.Die("%s: %s", from, err)
+	}
+
+	outgo, err := os.Create(out)
+	if err != nil {
+		util.Die("%s", err)
+	}
+	fmt.Fprintf(outgo, "package %s\n", pkg)
+	fmt.Fprintf(outgo, `
+// gemMapGenerated is the date on which the map of gems was generated,
+// as YYYY-MM-DD.
+const gemMapGenerated = %q
+`, time.Now().UTC().Format("2006-01-02"))
+	fmt.Fprintf(outgo, `
+// Each known path that Ruby code requires, and the gem that it
+// requires and how likely it is to be wanted, as pypimap.EncodeGuess
+// writes them, made by pypimap.EncodeTable.
+const requireToGemData = %q
+`, pypimap.EncodeTable(table(entries, counted)))
+
+	err = outgo.Close()
+	if err != nil {
+		util.Die("%s", err)
+	}
+}
@@ -0,0 +1,33 @@
+package gemmap
+
+import (
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTable(t *testing.T) {
+	entries := map[string]Entry{
+		"activesupport":  {Pkg: "activesupport", Requires: []string{"active_support"}, Downloads: 50000},
+		"rack-test":      {Pkg: "rack-test", Requires: []string{"rack/test"}, Downloads: 30000},
+		"rack-test-fork": {Pkg: "rack-test-fork", Requires: []string{"rack/test"}, Downloads: 40000},
+		"ruby-openai":    {Pkg: "ruby-openai", Requires: []string{"openai"}, Downloads: 20000},
+		"openai-lite":    {Pkg: "openai-lite", Requires: []string{"openai"}, Downloads: 10000},
+		"obscure":        {Pkg: "obscure-gem", Requires: []string{"obscurity"}, Downloads: 500},
+		"forgotten":      {Pkg: "forgotten", Requires: []string{"forgotten_lib"}, Downloads: 10},
+		// The standard library is never guessed.
+		"psych": {Pkg: "psych", Requires: []string{"psych", "yaml"}, Downloads: 90000},
+	}
+	guesses := table(entries, true)
+	sort.Slice(guesses, func(i, j int) bool { return guesses[i][0] < guesses[j][0] })
+	require.Equal(t, [][2]string{
+		{"active_support", "activesupport 0.95"},
+		{"obscurity", "obscure-gem 0.45"},
+		{"openai", "ruby-openai 0.50"},
+		{"rack/test", "rack-test 0.95"},
+	}, guesses)
+
+	// Without downloads, everything is popular.
+	require.Contains(t, table(entries, false), [2]string{"forgotten_lib", "forgotten 0.90"})
+}
@@ -0,0 +1,23 @@
+// This command generates go source holding a mapping of:
+// required paths -> the gem they require and how likely it is wanted
+//
+// this is provided as the data of the table requireToGem, as gen_pypi_map
+// does for Python. It is what go generate runs; 'upm admin gen-map
+// --ecosystem rubygems' does the same, and can also fetch the JSON file
+// that the map is generated from.
+package main
+
+import (
+	"flag"
+
+	"github.com/replit/upm/internal/backends/ruby/gemmap"
+)
+
+func main() {
+	from := flag.String("from", "", "a json file to generate the map from")
+	pkg := flag.String("pkg", "", "the pkg name for the output source")
+	out := flag.String("out", "", "the destination file for the generated code")
+	flag.Parse()
+
+	gemmap.Generate(*from, *pkg, *out)
+}
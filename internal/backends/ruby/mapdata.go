@@ -0,0 +1,23 @@
+package ruby
+
+import (
+	"github.com/replit/upm/internal/api"
+	"github.com/replit/upm/internal/backends/python/pypimap"
+)
+
+// requireToGem holds the gem that provides each known path that Ruby
+// code requires, with how likely it is that a require of the path
+// wants the gem, as pypimap.EncodeGuess writes them, from the data in
+// gem_map.gen.go. It is only decoded as far as it is used, as for
+// Python.
+var requireToGem = pypimap.NewTable(requireToGemData)
+
+// MapStatus implements 'upm index status' for ruby-bundler.
+func MapStatus() api.MapStatus {
+	return api.MapStatus{
+		Ecosystem:    "rubygems",
+		Generated:    gemMapGenerated,
+		Imports:      requireToGem.Len(),
+		RemoteSearch: rubygemsSearchURL,
+	}
+}
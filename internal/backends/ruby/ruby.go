@@ -1,15 +1,24 @@
 // Package ruby provides a backend for Ruby using Bundler.
 package ruby
 
+//go:generate go run ./gen_gem_map -from gem_packages.json -pkg ruby -out gem_map.gen.go
+
 import (
+	"context"
 	"encoding/json"
+	"fmt"
 	"io/ioutil"
-	"net/http"
 	"net/url"
-	"os"
+	"path"
+	"regexp"
+	"sort"
 	"strings"
 
 	"github.com/replit/upm/internal/api"
+	"github.com/replit/upm/internal/backends/python/pypimap"
+	"github.com/replit/upm/internal/backends/ruby/gemmap"
+	"github.com/replit/upm/internal/config"
+	"github.com/replit/upm/internal/project"
 	"github.com/replit/upm/internal/util"
 )
 
@@ -29,6 +38,33 @@ type rubygemsInfo struct {
 	Name             string   `json:"name"`
 	SourceCodeURI    string   `json:"source_code_uri"`
 	Version          string   `json:"version"`
+	VersionCreatedAt string   `json:"version_created_at"`
+}
+
+// toPkgInfo converts the information about a gem from RubyGems.
+func (s rubygemsInfo) toPkgInfo() api.PkgInfo {
+	deps := []string{}
+	for _, group := range s.Dependencies {
+		for _, dep := range group {
+			deps = append(deps, dep.Name)
+		}
+	}
+	return api.PkgInfo{
+		Name:             s.Name,
+		Description:      s.Info,
+		Version:          s.Version,
+		HomepageURL:      s.HomepageURI,
+		DocumentationURL: s.DocumentationURI,
+		SourceCodeURL:    s.SourceCodeURI,
+		BugTrackerURL:    s.BugTrackerURI,
+		Author:           s.Authors,
+		License:          strings.Join(s.Licenses, ", "),
+		Dependencies:     deps,
+		// Development dependencies aren't installed along
+		// with the gem.
+		DependencyCount: len(s.Dependencies["runtime"]),
+		ReleaseDate:     util.FormatTimestamp(s.VersionCreatedAt),
+	}
 }
 
 // getPath returns the appropriate --path for 'bundle install'. This
@@ -36,13 +72,13 @@ type rubygemsInfo struct {
 // instead be the empty string, indicating that no --path argument
 // should be passed. (This is for the case where the user has
 // explicitly configured a different path.)
-func getPath() string {
-	tempdir := util.TempDir()
-	defer os.RemoveAll(tempdir)
+func getPath(ctx context.Context) string {
+	tempdir := util.TempDir("upm")
+	defer util.RemoveTemp(tempdir)
 
 	// The --parseable option is completely undocumented outside
 	// of the source code, thanks Bundler.
-	outputB := util.GetCmdOutput([]string{
+	outputB := util.GetCmdOutput(ctx, []string{
 		"bundle", "config", "--parseable", "path"})
 
 	if len(outputB) == 0 {
@@ -60,15 +96,177 @@ func getPath() string {
 	}
 }
 
+// lookupRequire returns the gem that the given path is guessed to be
+// required from, and how likely that is, from the map of rubygems.org.
+// A path that isn't in the map is looked up by the directories it is
+// in, since active_support/core_ext is required from the same gem as
+// active_support. It returns false for a path of the standard library,
+// or of no known gem.
+func lookupRequire(require string) (string, float64, bool) {
+	for p := require; p != "." && p != "/"; p = path.Dir(p) {
+		if gemmap.IsStdlib(p) {
+			return "", 0, false
+		}
+		if value, ok := requireToGem.Get(p); ok {
+			gem, confidence := pypimap.DecodeGuess(value)
+			return gem, confidence, true
+		}
+	}
+	return "", 0, false
+}
+
+// overrideRequire returns the gem that project.OverridesFile says the
+// given path is required from, looked up by the directories it is in
+// too, as by lookupRequire, which it comes before, along with the path
+// that it was found for.
+func overrideRequire(require string) (string, string, bool) {
+	overrides := project.Overrides("ruby")
+	for p := require; p != "." && p != "/"; p = path.Dir(p) {
+		if gem, ok := overrides[p]; ok {
+			return gem, p, true
+		}
+	}
+	return "", "", false
+}
+
+// rubyGuessCacheKey implements GuessCacheKey for ruby-bundler, since
+// project.OverridesFile can change the gems that requires need.
+func rubyGuessCacheKey() string {
+	return fmt.Sprint(project.Overrides("ruby"))
+}
+
+// rubyGuess implements Guess for ruby-bundler.
+func rubyGuess(ctx context.Context) (map[api.PkgName]bool, bool) {
+	details, success := rubyGuessDetails(ctx)
+	pkgs := map[api.PkgName]bool{}
+	for name := range details {
+		pkgs[name] = true
+	}
+	return pkgs, success
+}
+
+// rubyGuessDetails implements GuessDetails for ruby-bundler. The paths
+// that the code requires are listed by Ruby, and each is guessed to
+// need the gem that the map of rubygems.org has for it (see
+// lookupRequire), with its confidence there. Paths that aren't in the
+// map aren't guessed, since they are as likely to be files of the
+// project on the load path. A gem that project.OverridesFile names for
+// a path is guessed instead, and is certain.
+func rubyGuessDetails(ctx context.Context) (map[api.PkgName]api.GuessedPkg, bool) {
+	outputB := util.GetCmdOutput(ctx, []string{
+		"ruby", "-e", util.GetResource("/ruby/list-requires.rb"),
+	})
+	requires := map[string][]string{}
+	if err := json.Unmarshal(outputB, &requires); err != nil {
+		util.Die("ruby: %s", err)
+	}
+
+	paths := []string{}
+	for require := range requires {
+		paths = append(paths, require)
+	}
+	sort.Strings(paths)
+	pkgs := map[api.PkgName]api.GuessedPkg{}
+	for _, require := range paths {
+		var gem string
+		var confidence float64
+		if override, p, ok := overrideRequire(require); ok {
+			gem, confidence = override, 1
+			if config.Explain {
+				util.Log(fmt.Sprintf("guessing %s for the require of %s: %s says so",
+					gem, p, project.OverridesFile))
+			}
+		} else if gem, confidence, ok = lookupRequire(require); ok {
+			if config.Explain {
+				util.Log(fmt.Sprintf("guessing %s for the require of %s: it provides it (confidence %.2f)",
+					gem, require, confidence))
+			}
+		} else {
+			continue
+		}
+		pkg := pkgs[api.PkgName(gem)]
+		pkg.Files = append(pkg.Files, requires[require]...)
+		if confidence > pkg.Confidence {
+			pkg.Confidence = confidence
+		}
+		pkgs[api.PkgName(gem)] = pkg
+	}
+
+	for name, pkg := range pkgs {
+		sort.Strings(pkg.Files)
+		files := []string{}
+		for i, file := range pkg.Files {
+			if i == 0 || file != pkg.Files[i-1] {
+				files = append(files, file)
+			}
+		}
+		pkg.Files = files
+		pkgs[name] = pkg
+	}
+	return pkgs, true
+}
+
+// rakefiles are the names that Rake looks for its Rakefile under.
+var rakefiles = []string{"Rakefile", "rakefile", "Rakefile.rb", "rakefile.rb"}
+
+// rakeTaskRegexp matches a line of 'rake --all --tasks', capturing the
+// name of the task, without its arguments, and its description.
+var rakeTaskRegexp = regexp.MustCompile(`^rake (\S+?)(?:\[[^\]]*\])?(?:\s+# (.*))?$`)
+
+// parseRakeTasks returns the tasks that 'rake --all --tasks' lists in
+// the given output, mapped to their descriptions.
+func parseRakeTasks(output string) map[string]string {
+	tasks := map[string]string{}
+	for _, line := range strings.Split(output, "\n") {
+		if match := rakeTaskRegexp.FindStringSubmatch(strings.TrimSpace(line)); match != nil {
+			tasks[match[1]] = match[2]
+		}
+	}
+	return tasks
+}
+
+// listRakeTasks implements ListScripts, returning the tasks of the
+// Rakefile.
+func listRakeTasks() map[string]string {
+	for _, rakefile := range rakefiles {
+		if util.Exists(rakefile) {
+			return parseRakeTasks(string(util.GetCmdOutput(context.Background(), []string{
+				"bundle", "exec", "rake", "--all", "--tasks",
+			})))
+		}
+	}
+	return map[string]string{}
+}
+
+// runRakeTask implements RunScript, giving the arguments to the task
+// as Rake takes them, as in 'rake release[origin]'.
+func runRakeTask(ctx context.Context, name string, args []string) {
+	if len(args) > 0 {
+		name += "[" + strings.Join(args, ",") + "]"
+	}
+	util.RunInteractiveCmd(ctx, []string{"bundle", "exec", "rake", name})
+}
+
+// rubygemsSearchURL is the search API of rubygems.org.
+const rubygemsSearchURL = "https://rubygems.org/api/v1/search.json"
+
+// rubygemsRegistry implements Registry, giving RubyGems.org.
+func rubygemsRegistry() string {
+	return "https://rubygems.org/"
+}
+
 // RubyBackend is a UPM language backend for Ruby using Bundler.
 var RubyBackend = api.LanguageBackend{
 	Name:             "ruby-bundler",
 	Specfile:         "Gemfile",
 	Lockfile:         "Gemfile.lock",
+	OSVEcosystem:     "RubyGems",
+	Registry:         rubygemsRegistry,
 	FilenamePatterns: []string{"*.rb"},
 	Quirks:           api.QuirksAddRemoveAlsoLocks,
+	Executables:      []string{"bundle", "ruby"},
 	GetPackageDir: func() string {
-		path := string(util.GetCmdOutput([]string{
+		path := string(util.GetCmdOutput(context.Background(), []string{
 			"bundle", "config", "--parseable", "path"}))
 		path = strings.TrimSuffix(path, "\n")
 		path = strings.TrimPrefix(path, "path=")
@@ -78,11 +276,24 @@ var RubyBackend = api.LanguageBackend{
 			return path
 		}
 	},
-	Search: func(query string) []api.PkgInfo {
-		endpoint := "https://rubygems.org/api/v1/search.json"
+	// bundle exec takes care of the environment itself, even
+	// when the gems are installed outside the project.
+	GetExecEnv: func() api.ExecEnv {
+		return api.ExecEnv{Prefix: []string{"bundle", "exec"}}
+	},
+	GetInstalledPackageDir: func(name api.PkgName) string {
+		outputB, code := util.GetCmdOutputAndExitCode(context.Background(), []string{
+			"bundle", "info", "--path", string(name)})
+		if code != 0 {
+			// Not installed.
+			return ""
+		}
+		return strings.TrimSpace(string(outputB))
+	},
+	Search: func(ctx context.Context, query string) []api.PkgInfo {
 		queryParams := "?query=" + url.QueryEscape(query)
 
-		resp, err := http.Get(endpoint + queryParams)
+		resp, err := util.HTTPGet(ctx, rubygemsSearchURL+queryParams)
 		if err != nil {
 			util.Die("RubyGems: %s", err)
 		}
@@ -100,32 +311,14 @@ var RubyBackend = api.LanguageBackend{
 
 		results := []api.PkgInfo{}
 		for _, s := range outputStructs {
-			deps := []string{}
-			for _, group := range s.Dependencies {
-				for _, dep := range group {
-					deps = append(deps, dep.Name)
-				}
-			}
-			results = append(results, api.PkgInfo{
-				Name:             s.Name,
-				Description:      s.Info,
-				Version:          s.Version,
-				HomepageURL:      s.HomepageURI,
-				DocumentationURL: s.DocumentationURI,
-				SourceCodeURL:    s.SourceCodeURI,
-				BugTrackerURL:    s.BugTrackerURI,
-				Author:           s.Authors,
-				License:          strings.Join(s.Licenses, ", "),
-				Dependencies:     deps,
-			})
+			results = append(results, s.toPkgInfo())
 		}
 		return results
 	},
-	Info: func(name api.PkgName) api.PkgInfo {
-		endpoint := "https://rubygems.org/api/v1/gems/"
-		path := url.QueryEscape(string(name)) + ".json"
-
-		resp, err := http.Get(endpoint + path)
+	Info: func(ctx context.Context, name api.PkgName) api.PkgInfo {
+		resp, err := util.HTTPGet(ctx, util.JoinURL(
+			"https://rubygems.org/api/v1/gems", string(name)+".json",
+		))
 		if err != nil {
 			util.Die("RubyGems: %s", err)
 		}
@@ -149,28 +342,11 @@ var RubyBackend = api.LanguageBackend{
 		if err := json.Unmarshal(body, &s); err != nil {
 			util.Die("RubyGems response: %s", err)
 		}
-		deps := []string{}
-		for _, group := range s.Dependencies {
-			for _, dep := range group {
-				deps = append(deps, dep.Name)
-			}
-		}
-		return api.PkgInfo{
-			Name:             s.Name,
-			Description:      s.Info,
-			Version:          s.Version,
-			HomepageURL:      s.HomepageURI,
-			DocumentationURL: s.DocumentationURI,
-			SourceCodeURL:    s.SourceCodeURI,
-			BugTrackerURL:    s.BugTrackerURI,
-			Author:           s.Authors,
-			License:          strings.Join(s.Licenses, ", "),
-			Dependencies:     deps,
-		}
+		return s.toPkgInfo()
 	},
-	Add: func(pkgs map[api.PkgName]api.PkgSpec, projectName string) {
+	Add: func(ctx context.Context, pkgs map[api.PkgName]api.PkgSpec, projectName string) {
 		if !util.Exists("Gemfile") {
-			util.RunCmd([]string{"bundle", "init"})
+			util.RunCmd(ctx, []string{"bundle", "init"})
 		}
 		args := []string{}
 		for name, spec := range pkgs {
@@ -182,37 +358,47 @@ var RubyBackend = api.LanguageBackend{
 			// We need to --skip-install here and run that
 			// separately, because there's no way to get
 			// Bundler to --clean when installing via add.
-			util.RunCmd(append([]string{
+			util.RunCmd(ctx, append([]string{
 				"bundle", "add", "--skip-install"}, args...))
 		}
 		for name, spec := range pkgs {
 			if spec != "" {
 				nameArg := string(name)
 				versionArg := "--version=" + string(spec)
-				util.RunCmd([]string{"bundle", "add", nameArg, versionArg})
+				util.RunCmd(ctx, []string{"bundle", "add", nameArg, versionArg})
 			}
 		}
 	},
-	Remove: func(pkgs map[api.PkgName]bool) {
+	InitSpecfile: func(ctx context.Context, projectName string) {
+		util.RunCmd(ctx, []string{"bundle", "init"})
+	},
+	Remove: func(ctx context.Context, pkgs map[api.PkgName]bool) {
 		cmd := []string{"bundle", "remove", "--skip-install"}
 		for name, _ := range pkgs {
 			cmd = append(cmd, string(name))
 		}
-		util.RunCmd(cmd)
+		util.RunCmd(ctx, cmd)
+	},
+	Lock: func(ctx context.Context) {
+		util.RunCmd(ctx, []string{"bundle", "lock"})
 	},
-	Lock: func() {
-		util.RunCmd([]string{"bundle", "lock"})
+	Upgrade: func(ctx context.Context, pkgs map[api.PkgName]bool) {
+		cmd := []string{"bundle", "lock", "--update"}
+		for name := range pkgs {
+			cmd = append(cmd, string(name))
+		}
+		util.RunCmd(ctx, cmd)
 	},
-	Install: func() {
+	Install: func(ctx context.Context) {
 		// We need --clean to handle uninstalls.
 		args := []string{"bundle", "install", "--clean"}
-		if path := getPath(); path != "" {
+		if path := getPath(ctx); path != "" {
 			args = append(args, "--path", path)
 		}
-		util.RunCmd(args)
+		util.RunCmd(ctx, args)
 	},
 	ListSpecfile: func() map[api.PkgName]api.PkgSpec {
-		outputB := util.GetCmdOutput([]string{
+		outputB := util.GetCmdOutput(context.Background(), []string{
 			"ruby", "-e", util.GetResource("/ruby/list-specfile.rb"),
 		})
 		results := map[api.PkgName]api.PkgSpec{}
@@ -222,7 +408,7 @@ var RubyBackend = api.LanguageBackend{
 		return results
 	},
 	ListLockfile: func() map[api.PkgName]api.PkgVersion {
-		outputB := util.GetCmdOutput([]string{
+		outputB := util.GetCmdOutput(context.Background(), []string{
 			"ruby", "-e", util.GetResource("/ruby/list-lockfile.rb"),
 		})
 		results := map[api.PkgName]api.PkgVersion{}
@@ -231,17 +417,22 @@ var RubyBackend = api.LanguageBackend{
 		}
 		return results
 	},
-	GuessRegexps: util.Regexps([]string{
-		`require\s*['"]([^'"]+)['"]`,
-	}),
-	Guess: func() (map[api.PkgName]bool, bool) {
-		guessedGems := util.GetCmdOutput([]string{
-			"ruby", "-e", util.GetResource("/ruby/guess-gems.rb"),
+	ListLockfileDependencies: func() map[api.PkgName][]api.PkgName {
+		outputB := util.GetCmdOutput(context.Background(), []string{
+			"ruby", "-e", util.GetResource("/ruby/list-lockfile-dependencies.rb"),
 		})
-		results := map[api.PkgName]bool{}
-		if err := json.Unmarshal(guessedGems, &results); err != nil {
+		results := map[api.PkgName][]api.PkgName{}
+		if err := json.Unmarshal(outputB, &results); err != nil {
 			util.Die("ruby: %s", err)
 		}
-		return results, true
+		return results
 	},
+	ListScripts: listRakeTasks,
+	RunScript:   runRakeTask,
+	GuessRegexps: util.Regexps([]string{
+		`require\s*['"]([^'"]+)['"]`,
+	}),
+	GuessCacheKey: rubyGuessCacheKey,
+	Guess:         rubyGuess,
+	GuessDetails:  rubyGuessDetails,
 }
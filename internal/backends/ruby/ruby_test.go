@@ -0,0 +1,38 @@
+package ruby
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLookupRequire(t *testing.T) {
+	for req, gem := range map[string]string{
+		"active_support":          "activesupport",
+		"active_support/core_ext": "activesupport",
+		"rack/test":               "rack-test",
+		"sinatra/base":            "sinatra",
+		"net/ssh":                 "net-ssh",
+	} {
+		found, confidence, ok := lookupRequire(req)
+		require.True(t, ok, req)
+		require.Equal(t, gem, found, req)
+		require.Greater(t, confidence, 0.5, req)
+	}
+
+	for _, req := range []string{"yaml", "json", "net/http", "my_helper"} {
+		_, _, ok := lookupRequire(req)
+		require.False(t, ok, req)
+	}
+}
+
+func TestParseRakeTasks(t *testing.T) {
+	require.Equal(t, map[string]string{
+		"build":   "Build demo-1.0.0.gem into the pkg directory",
+		"release": "Create a tag and push demo-1.0.0.gem",
+		"spec":    "",
+	}, parseRakeTasks(`rake build                # Build demo-1.0.0.gem into the pkg directory
+rake release[remote]      # Create a tag and push demo-1.0.0.gem
+rake spec
+`))
+}
@@ -2,10 +2,11 @@
 package rust
 
 import (
+	"context"
 	"encoding/json"
 	"io/ioutil"
-	"net/http"
 	"net/url"
+	"strings"
 
 	"github.com/BurntSushi/toml"
 	"github.com/replit/upm/internal/api"
@@ -23,6 +24,9 @@ type cargoLock struct {
 type cargoPackage struct {
 	Name    string `toml:"name"`
 	Version string `toml:"version"`
+	// Each dependency is the name of a package, followed by its
+	// version and source if another package has the same name.
+	Dependencies []string `toml:"dependencies"`
 }
 
 type crateSearchResults struct {
@@ -35,19 +39,22 @@ type crateInfoResult struct {
 }
 
 type crate struct {
-	Name          string `json:"name"`
-	Description   string `json:"description"`
-	Homepage      string `json:"homepage"`
-	Documentation string `json:"documentation"`
-	Repository    string `json:"repository"`
-	NewestVersion string `json:"newest_version"`
-	Versions      []int  `json:"versions"`
+	Name          string   `json:"name"`
+	Description   string   `json:"description"`
+	Homepage      string   `json:"homepage"`
+	Documentation string   `json:"documentation"`
+	Repository    string   `json:"repository"`
+	NewestVersion string   `json:"newest_version"`
+	Versions      []int    `json:"versions"`
+	Keywords      []string `json:"keywords"`
 }
 
 type version struct {
 	Num         string `json:"num"`
 	PublishedBy user   `json:"published_by"`
 	License     string `json:"license"`
+	CreatedAt   string `json:"created_at"`
+	CrateSize   int64  `json:"crate_size"`
 }
 
 type user struct {
@@ -57,11 +64,15 @@ type user struct {
 func (c *crateInfoResult) toPkgInfo() api.PkgInfo {
 	var author string
 	var license string
+	var releaseDate string
+	var size int64
 
 	for _, version := range c.Versions {
 		if version.Num == c.Crate.NewestVersion {
 			author = version.PublishedBy.Name
 			license = version.License
+			releaseDate = util.FormatTimestamp(version.CreatedAt)
+			size = version.CrateSize
 			break
 		}
 	}
@@ -75,14 +86,17 @@ func (c *crateInfoResult) toPkgInfo() api.PkgInfo {
 		SourceCodeURL:    c.Crate.Repository,
 		Author:           author,
 		License:          license,
+		ReleaseDate:      releaseDate,
+		Size:             size,
+		Keywords:         c.Crate.Keywords,
 	}
 }
 
-func search(query string) []api.PkgInfo {
+func search(ctx context.Context, query string) []api.PkgInfo {
 	endpoint := "https://crates.io/api/v1/crates"
 	path := "?q=" + url.QueryEscape(query)
 
-	resp, err := http.Get(endpoint + path)
+	resp, err := util.HTTPGet(ctx, endpoint+path)
 	if err != nil {
 		util.Die("crates.io: %s", err)
 	}
@@ -109,11 +123,11 @@ func search(query string) []api.PkgInfo {
 	return pkgs
 }
 
-func info(name api.PkgName) api.PkgInfo {
+func info(ctx context.Context, name api.PkgName) api.PkgInfo {
 	endpoint := "https://crates.io/api/v1/crates"
-	path := "/" + url.PathEscape(string(name))
+	path := "/" + util.EscapePathSegment(string(name))
 
-	resp, err := http.Get(endpoint + path)
+	resp, err := util.HTTPGet(ctx, endpoint+path)
 	if err != nil {
 		util.Die("crates.io: %s", err)
 	}
@@ -212,20 +226,59 @@ func listLockfileWithContents(contents []byte) map[api.PkgName]api.PkgVersion {
 	return packages
 }
 
+func listLockfileDependencies() map[api.PkgName][]api.PkgName {
+	contents, err := ioutil.ReadFile("Cargo.lock")
+	if err != nil {
+		util.Die("Cargo.lock: %s", err)
+	}
+
+	return listLockfileDependenciesWithContents(contents)
+}
+
+func listLockfileDependenciesWithContents(contents []byte) map[api.PkgName][]api.PkgName {
+	var lockfile cargoLock
+	err := toml.Unmarshal(contents, &lockfile)
+	if err != nil {
+		util.Die("Cargo.lock: %s", err)
+	}
+
+	deps := make(map[api.PkgName][]api.PkgName)
+	for _, pkg := range lockfile.Packages {
+		names := []api.PkgName{}
+		for _, dep := range pkg.Dependencies {
+			if fields := strings.Fields(dep); len(fields) > 0 {
+				names = append(names, api.PkgName(fields[0]))
+			}
+		}
+		deps[api.PkgName(pkg.Name)] = names
+	}
+
+	return deps
+}
+
+// cratesRegistry implements Registry, giving crates.io.
+func cratesRegistry() string {
+	return "https://crates.io/"
+}
+
 // RustBackend is a UPM backend for Rust that uses Cargo.
 var RustBackend = api.LanguageBackend{
 	Name:             "rust",
 	Specfile:         "Cargo.toml",
 	Lockfile:         "Cargo.lock",
+	LockfileComment:  "#",
+	OSVEcosystem:     "crates.io",
+	Registry:         cratesRegistry,
 	FilenamePatterns: []string{"*.rs"},
+	Executables:      []string{"cargo"},
 	GetPackageDir: func() string {
 		return "target"
 	},
 	Search: search,
 	Info:   info,
-	Add: func(pkgs map[api.PkgName]api.PkgSpec, projectName string) {
+	Add: func(ctx context.Context, pkgs map[api.PkgName]api.PkgSpec, projectName string) {
 		if !util.Exists("Cargo.toml") {
-			util.RunCmd([]string{"cargo", "init", "."})
+			util.RunCmd(ctx, []string{"cargo", "init", "."})
 		}
 		cmd := []string{"cargo", "add"}
 		for name, spec := range pkgs {
@@ -235,24 +288,43 @@ var RustBackend = api.LanguageBackend{
 			}
 			cmd = append(cmd, arg)
 		}
-		util.RunCmd(cmd)
+		util.RunCmd(ctx, cmd)
 	},
-	Remove: func(pkgs map[api.PkgName]bool) {
+	InitSpecfile: func(ctx context.Context, projectName string) {
+		util.RunCmd(ctx, []string{"cargo", "init", ".", "--name", projectName})
+	},
+	AddEditable: func(ctx context.Context, path string, projectName string) {
+		if !util.Exists("Cargo.toml") {
+			util.RunCmd(ctx, []string{"cargo", "init", "."})
+		}
+		// Path dependencies are always built from source, so
+		// they are editable by nature.
+		util.RunCmd(ctx, []string{"cargo", "add", "--path", path})
+	},
+	Remove: func(ctx context.Context, pkgs map[api.PkgName]bool) {
 		cmd := []string{"cargo", "rm"}
 		for name := range pkgs {
 			cmd = append(cmd, string(name))
 		}
-		util.RunCmd(cmd)
+		util.RunCmd(ctx, cmd)
 	},
-	Lock: func() {
+	Lock: func(ctx context.Context) {
 		// Lock file is updated at build time
 	},
-	Install: func() {
+	Upgrade: func(ctx context.Context, pkgs map[api.PkgName]bool) {
+		cmd := []string{"cargo", "update"}
+		for name := range pkgs {
+			cmd = append(cmd, "--package", string(name))
+		}
+		util.RunCmd(ctx, cmd)
+	},
+	Install: func(ctx context.Context) {
 		// Dependencies are installed at build time
 	},
-	ListSpecfile: listSpecfile,
-	ListLockfile: listLockfile,
-	Guess: func() (map[api.PkgName]bool, bool) {
+	ListSpecfile:             listSpecfile,
+	ListLockfile:             listLockfile,
+	ListLockfileDependencies: listLockfileDependencies,
+	Guess: func(ctx context.Context) (map[api.PkgName]bool, bool) {
 		util.NotImplemented()
 		return nil, false
 	},
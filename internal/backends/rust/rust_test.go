@@ -1,6 +1,7 @@
 package rust
 
 import (
+	"context"
 	"io/ioutil"
 	"testing"
 
@@ -10,13 +11,13 @@ import (
 )
 
 func TestCrateInfo(t *testing.T) {
-	info := RustBackend.Info(api.PkgName("serde"))
+	info := RustBackend.Info(context.Background(), api.PkgName("serde"))
 	// We don't want to check too many fields since they can be changed externally and break this test.
 	require.Equal(t, "serde", info.Name)
 }
 
 func TestCrateSearch(t *testing.T) {
-	results := RustBackend.Search("serde")
+	results := RustBackend.Search(context.Background(), "serde")
 	// We don't want to check the results as they may change externally and break this test.
 	require.NotEmpty(t, results)
 }
@@ -151,3 +152,14 @@ func TestListLockfile(t *testing.T) {
 
 	require.Equal(t, expectedPkgs, pkgs)
 }
+
+func TestListLockfileDependencies(t *testing.T) {
+	contents, err := ioutil.ReadFile("testdata/Cargo.lock")
+	require.NoError(t, err)
+
+	deps := listLockfileDependenciesWithContents(contents)
+
+	require.Equal(t, []api.PkgName{"getrandom", "once_cell", "version_check"}, deps["ahash"])
+	require.Equal(t, []api.PkgName{"sqlx-core", "sqlx-macros"}, deps["sqlx"])
+	require.Len(t, deps, len(listLockfileWithContents(contents)))
+}
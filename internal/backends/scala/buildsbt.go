@@ -0,0 +1,187 @@
+package scala
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/replit/upm/internal/api"
+)
+
+// moduleIDRegexp matches an sbt ModuleID expression such as
+// "org.typelevel" %% "cats-core" % "2.9.0" % Test. The capture groups
+// are the organization, the cross-version operator (%, %%, or %%%),
+// the module name, and the revision.
+var moduleIDRegexp = regexp.MustCompile(
+	`"([^"]+)"\s*(%%%|%%|%)\s*"([^"]+)"\s*%\s*"([^"]+)"(?:\s*%\s*(?:"[^"]*"|[A-Za-z]+))?`,
+)
+
+// scalaVersionRegexp matches the scalaVersion setting in build.sbt.
+var scalaVersionRegexp = regexp.MustCompile(`scalaVersion\s*:=\s*"([^"]+)"`)
+
+// crossVersionSeparators maps sbt cross-version operators to the
+// separators used between organization and module name in UPM package
+// names. This is the same convention that Coursier and Mill use, so
+// e.g. "org.typelevel" %% "cats-core" is org.typelevel::cats-core.
+var crossVersionSeparators = map[string]string{
+	"%":   ":",
+	"%%":  "::",
+	"%%%": ":::",
+}
+
+// moduleID is a dependency parsed from a UPM package name.
+type moduleID struct {
+	org      string
+	operator string
+	name     string
+}
+
+// parsePkgName splits a package name like org.typelevel::cats-core into
+// a moduleID. It returns false if the name is not of that form.
+func parsePkgName(name api.PkgName) (moduleID, bool) {
+	for _, op := range []string{"%%%", "%%", "%"} {
+		sep := crossVersionSeparators[op]
+		parts := strings.Split(string(name), sep)
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			continue
+		}
+		if strings.HasPrefix(parts[1], ":") || strings.HasSuffix(parts[0], ":") {
+			continue
+		}
+		return moduleID{org: parts[0], operator: op, name: parts[1]}, true
+	}
+	return moduleID{}, false
+}
+
+// pkgName returns the UPM package name for the moduleID.
+func (m moduleID) pkgName() api.PkgName {
+	return api.PkgName(m.org + crossVersionSeparators[m.operator] + m.name)
+}
+
+// artifact returns the name of the artifact that is published to Maven
+// repositories for the moduleID, given the Scala binary version (e.g.
+// "2.13" or "3"). Scala.js artifacts assume Scala.js 1.x.
+func (m moduleID) artifact(scalaBinaryVersion string) string {
+	switch m.operator {
+	case "%%":
+		return m.name + "_" + scalaBinaryVersion
+	case "%%%":
+		return m.name + "_sjs1_" + scalaBinaryVersion
+	}
+	return m.name
+}
+
+// scalaBinaryVersion returns the Scala binary version (e.g. "2.13" for
+// 2.13.12, or "3" for 3.3.1) configured in the given build.sbt
+// contents. If there is none, sbt's default of 2.12 is assumed.
+func scalaBinaryVersion(contents string) string {
+	match := scalaVersionRegexp.FindStringSubmatch(contents)
+	if match == nil {
+		return "2.12"
+	}
+	parts := strings.Split(match[1], ".")
+	if parts[0] == "3" || len(parts) < 2 {
+		return parts[0]
+	}
+	return parts[0] + "." + parts[1]
+}
+
+// listSpecfileWithContents returns the library dependencies declared
+// in the given build.sbt contents.
+func listSpecfileWithContents(contents string) map[api.PkgName]api.PkgSpec {
+	pkgs := map[api.PkgName]api.PkgSpec{}
+	for _, match := range moduleIDRegexp.FindAllStringSubmatch(contents, -1) {
+		m := moduleID{org: match[1], operator: match[2], name: match[3]}
+		pkgs[m.pkgName()] = api.PkgSpec(match[4])
+	}
+	return pkgs
+}
+
+// addToSpecfileContents returns the build.sbt contents with a
+// libraryDependencies setting appended for each of the given modules.
+// The versions must already be resolved.
+func addToSpecfileContents(contents string, mods map[moduleID]string) string {
+	// Ensure newline before the stuff we add, for readability.
+	if len(contents) > 0 && !strings.HasSuffix(contents, "\n") {
+		contents += "\n"
+	}
+
+	for m, version := range mods {
+		contents += fmt.Sprintf(
+			"libraryDependencies += %q %s %q %% %q\n",
+			m.org, m.operator, m.name, version,
+		)
+	}
+	return contents
+}
+
+// removeFromSpecfileContents returns the build.sbt contents with the
+// given packages removed, whether they are declared in a
+// libraryDependencies += setting of their own or as part of a
+// libraryDependencies ++= Seq(...).
+func removeFromSpecfileContents(contents string, pkgs map[api.PkgName]bool) string {
+	for name := range pkgs {
+		m, ok := parsePkgName(name)
+		if !ok {
+			continue
+		}
+		dep := fmt.Sprintf(
+			`"%s"\s*%s\s*"%s"\s*%%\s*"[^"]+"(?:\s*%%\s*(?:"[^"]*"|[A-Za-z]+))?`,
+			regexp.QuoteMeta(m.org), m.operator, regexp.QuoteMeta(m.name),
+		)
+		contents = regexp.MustCompile(
+			`(?m)^[ \t]*libraryDependencies\s*\+=\s*`+dep+`[ \t]*\n?`,
+		).ReplaceAllLiteralString(contents, "")
+		contents = regexp.MustCompile(
+			dep+`[ \t]*,?[ \t]*(?:\n[ \t]*)?`,
+		).ReplaceAllLiteralString(contents, "")
+	}
+	return contents
+}
+
+// parseClasspathEntry returns the package name and version for a jar
+// on the classpath exported by sbt, which is normally inside the
+// Coursier cache and so follows the Maven repository layout
+// (.../org/typelevel/cats-core_2.13/2.9.0/cats-core_2.13-2.9.0.jar).
+// Artifacts cross-built for the given Scala binary version are named
+// as they would be in build.sbt, e.g. org.typelevel::cats-core. It
+// returns false if the path is not laid out that way.
+func parseClasspathEntry(path string, scalaBinaryVersion string) (api.PkgName, api.PkgVersion, bool) {
+	path = filepath.ToSlash(path)
+	if !strings.HasSuffix(path, ".jar") {
+		return "", "", false
+	}
+	dirs := strings.Split(path, "/")
+	if len(dirs) < 4 {
+		return "", "", false
+	}
+	file := dirs[len(dirs)-1]
+	version := dirs[len(dirs)-2]
+	artifact := dirs[len(dirs)-3]
+	if !strings.HasPrefix(file, artifact+"-"+version) {
+		return "", "", false
+	}
+
+	// The organization is everything between the root of the
+	// repository and the artifact. We can only find the root for
+	// Maven Central, which is where nearly everything comes from
+	// anyway.
+	org := ""
+	for i, dir := range dirs[:len(dirs)-3] {
+		if dir == "maven2" {
+			org = strings.Join(dirs[i+1:len(dirs)-3], ".")
+		}
+	}
+	if org == "" {
+		return api.PkgName(artifact), api.PkgVersion(version), true
+	}
+
+	m := moduleID{org: org, operator: "%", name: artifact}
+	if name := strings.TrimSuffix(artifact, "_sjs1_"+scalaBinaryVersion); name != artifact {
+		m = moduleID{org: org, operator: "%%%", name: name}
+	} else if name := strings.TrimSuffix(artifact, "_"+scalaBinaryVersion); name != artifact {
+		m = moduleID{org: org, operator: "%%", name: name}
+	}
+	return m.pkgName(), api.PkgVersion(version), true
+}
@@ -0,0 +1,231 @@
+// Package scala provides a backend for Scala using sbt.
+package scala
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/replit/upm/internal/api"
+	"github.com/replit/upm/internal/backends/java"
+	"github.com/replit/upm/internal/util"
+)
+
+// scalaPatterns is the FilenamePatterns value for ScalaSbtBackend.
+var scalaPatterns = []string{"*.scala", "*.sbt"}
+
+// resolvedFile is the lockfile for ScalaSbtBackend. sbt has no
+// lockfile of its own, so after installing we record the artifacts
+// that it resolved, one "name version" pair per line.
+const resolvedFile = "sbt-packages.txt"
+
+// crossVersionSuffixRegexp matches the suffix that sbt appends to the
+// artifacts of cross-built Scala libraries, e.g. _2.13 or _sjs1_3.
+var crossVersionSuffixRegexp = regexp.MustCompile(`^(.+?)(_sjs1)?_(2\.1[0-3]|3)$`)
+
+// readSpecfile returns the contents of build.sbt, or the empty string
+// if it does not exist yet.
+func readSpecfile() string {
+	contentsB, err := ioutil.ReadFile("build.sbt")
+	if os.IsNotExist(err) {
+		return ""
+	} else if err != nil {
+		util.Die("build.sbt: %s", err)
+	}
+	return string(contentsB)
+}
+
+// searchDocToModuleID converts a Maven Central search result into the
+// moduleID that would be used to depend on it from sbt, and also
+// returns the Scala binary version it was built for, if any.
+func searchDocToModuleID(doc java.SearchDoc) (moduleID, string) {
+	match := crossVersionSuffixRegexp.FindStringSubmatch(doc.Artifact)
+	switch {
+	case match == nil:
+		return moduleID{org: doc.Group, operator: "%", name: doc.Artifact}, ""
+	case match[2] != "":
+		return moduleID{org: doc.Group, operator: "%%%", name: match[1]}, match[3]
+	default:
+		return moduleID{org: doc.Group, operator: "%%", name: match[1]}, match[3]
+	}
+}
+
+// search implements Search for sbt using Maven Central. Artifacts
+// that are cross-built for several Scala versions are collapsed into
+// a single result, preferring the one for the project's Scala
+// version.
+func search(ctx context.Context, query string) []api.PkgInfo {
+	docs, err := java.Search(ctx, query)
+	if err != nil {
+		util.Die("error searching maven %s", err)
+	}
+	binVersion := scalaBinaryVersion(readSpecfile())
+
+	order := []api.PkgName{}
+	results := map[api.PkgName]api.PkgInfo{}
+	for _, doc := range docs {
+		m, docBinVersion := searchDocToModuleID(doc)
+		name := m.pkgName()
+		if _, ok := results[name]; ok && docBinVersion != binVersion {
+			continue
+		} else if !ok {
+			order = append(order, name)
+		}
+		results[name] = api.PkgInfo{
+			Name:    string(name),
+			Version: doc.Version,
+		}
+	}
+
+	pkgInfos := []api.PkgInfo{}
+	for _, name := range order {
+		pkgInfos = append(pkgInfos, results[name])
+	}
+	return pkgInfos
+}
+
+// lookupArtifact returns the latest version of the given module that
+// is published to Maven Central for the given Scala binary version,
+// or the empty string if there is none.
+func lookupArtifact(ctx context.Context, m moduleID, binVersion string) string {
+	doc, err := java.Info(ctx, m.org+":"+m.artifact(binVersion))
+	if err != nil {
+		util.Die("error searching maven %s", err)
+	}
+	return doc.CurrentVersion
+}
+
+// info implements Info for sbt using Maven Central.
+func info(ctx context.Context, name api.PkgName) api.PkgInfo {
+	m, ok := parsePkgName(name)
+	if !ok {
+		return api.PkgInfo{}
+	}
+
+	version := lookupArtifact(ctx, m, scalaBinaryVersion(readSpecfile()))
+	if version == "" {
+		return api.PkgInfo{}
+	}
+
+	return api.PkgInfo{
+		Name:    string(m.pkgName()),
+		Version: version,
+	}
+}
+
+// add implements Add for sbt. Packages without a spec get the latest
+// version that is published for the project's Scala version.
+func add(ctx context.Context, pkgs map[api.PkgName]api.PkgSpec, projectName string) {
+	contents := readSpecfile()
+	binVersion := scalaBinaryVersion(contents)
+
+	mods := map[moduleID]string{}
+	for name, spec := range pkgs {
+		m, ok := parsePkgName(name)
+		if !ok {
+			util.Die(
+				"package name %s does not match organization:name or organization::name pattern",
+				name,
+			)
+		}
+
+		version := string(spec)
+		if version == "" {
+			version = lookupArtifact(ctx, m, binVersion)
+			if version == "" {
+				util.Die("did not find a package %s for Scala %s", m.artifact(binVersion), binVersion)
+			}
+		}
+		mods[m] = version
+	}
+
+	contents = addToSpecfileContents(contents, mods)
+	util.ProgressMsg("write build.sbt")
+	util.TryWriteAtomic("build.sbt", []byte(contents))
+}
+
+// remove implements Remove for sbt.
+func remove(ctx context.Context, pkgs map[api.PkgName]bool) {
+	contents := removeFromSpecfileContents(readSpecfile(), pkgs)
+	util.ProgressMsg("write build.sbt")
+	util.TryWriteAtomic("build.sbt", []byte(contents))
+}
+
+// install implements Install for sbt. Exporting the managed classpath
+// makes sbt resolve and download every dependency (without compiling
+// the project), and tells us which artifacts it picked, which are
+// then written to the lockfile.
+func install(ctx context.Context) {
+	outputB := util.GetCmdOutput(ctx, []string{
+		"sbt", "-batch", "-error", "export Runtime / managedClasspath",
+	})
+	binVersion := scalaBinaryVersion(readSpecfile())
+
+	pkgs := map[api.PkgName]api.PkgVersion{}
+	for _, line := range strings.Split(string(outputB), "\n") {
+		for _, path := range strings.Split(strings.TrimSpace(line), string(os.PathListSeparator)) {
+			if name, version, ok := parseClasspathEntry(path, binVersion); ok {
+				pkgs[name] = version
+			}
+		}
+	}
+
+	lines := []string{}
+	for name, version := range pkgs {
+		lines = append(lines, fmt.Sprintf("%s %s\n", name, version))
+	}
+	sort.Strings(lines)
+
+	util.ProgressMsg("write " + resolvedFile)
+	util.TryWriteAtomic(resolvedFile, []byte(strings.Join(lines, "")))
+}
+
+// listLockfileWithContents returns the artifacts recorded in the
+// given lockfile contents.
+func listLockfileWithContents(contents string) map[api.PkgName]api.PkgVersion {
+	pkgs := map[api.PkgName]api.PkgVersion{}
+	for _, line := range strings.Split(contents, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			continue
+		}
+		pkgs[api.PkgName(fields[0])] = api.PkgVersion(fields[1])
+	}
+	return pkgs
+}
+
+// ScalaSbtBackend is a UPM backend for Scala that uses sbt.
+var ScalaSbtBackend = api.LanguageBackend{
+	Name:             "scala-sbt",
+	Specfile:         "build.sbt",
+	Lockfile:         resolvedFile,
+	FilenamePatterns: scalaPatterns,
+	Quirks:           api.QuirksNotReproducible,
+	Executables:      []string{"sbt"},
+	GetPackageDir: func() string {
+		return "target"
+	},
+	Search:  search,
+	Info:    info,
+	Add:     add,
+	Remove:  remove,
+	Install: install,
+	ListSpecfile: func() map[api.PkgName]api.PkgSpec {
+		return listSpecfileWithContents(readSpecfile())
+	},
+	ListLockfile: func() map[api.PkgName]api.PkgVersion {
+		contentsB, err := ioutil.ReadFile(resolvedFile)
+		if err != nil {
+			util.Die("%s: %s", resolvedFile, err)
+		}
+		return listLockfileWithContents(string(contentsB))
+	},
+	Guess: func(ctx context.Context) (map[api.PkgName]bool, bool) {
+		util.NotImplemented()
+		return nil, false
+	},
+}
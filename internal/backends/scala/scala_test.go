@@ -0,0 +1,93 @@
+package scala
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/replit/upm/internal/api"
+)
+
+const testBuildSbt = `name := "hello"
+
+scalaVersion := "2.13.12"
+
+libraryDependencies += "org.typelevel" %% "cats-core" % "2.9.0"
+
+libraryDependencies ++= Seq(
+  "com.lihaoyi" %% "os-lib" % "0.9.1",
+  "org.postgresql" % "postgresql" % "42.6.0",
+  "org.scalameta" %% "munit" % "0.7.29" % Test
+)
+`
+
+func TestListSpecfile(t *testing.T) {
+	require.Equal(t, map[api.PkgName]api.PkgSpec{
+		"org.typelevel::cats-core":  "2.9.0",
+		"com.lihaoyi::os-lib":       "0.9.1",
+		"org.postgresql:postgresql": "42.6.0",
+		"org.scalameta::munit":      "0.7.29",
+	}, listSpecfileWithContents(testBuildSbt))
+}
+
+func TestParsePkgName(t *testing.T) {
+	m, ok := parsePkgName("org.typelevel::cats-core")
+	require.True(t, ok)
+	require.Equal(t, moduleID{org: "org.typelevel", operator: "%%", name: "cats-core"}, m)
+	require.Equal(t, "cats-core_2.13", m.artifact("2.13"))
+
+	m, ok = parsePkgName("org.postgresql:postgresql")
+	require.True(t, ok)
+	require.Equal(t, "postgresql", m.artifact("2.13"))
+
+	m, ok = parsePkgName("com.raquo:::laminar")
+	require.True(t, ok)
+	require.Equal(t, "laminar_sjs1_3", m.artifact("3"))
+
+	_, ok = parsePkgName("cats-core")
+	require.False(t, ok)
+}
+
+func TestScalaBinaryVersion(t *testing.T) {
+	require.Equal(t, "2.13", scalaBinaryVersion(testBuildSbt))
+	require.Equal(t, "3", scalaBinaryVersion(`scalaVersion := "3.3.1"`))
+	require.Equal(t, "2.12", scalaBinaryVersion(""))
+}
+
+func TestAddAndRemove(t *testing.T) {
+	contents := addToSpecfileContents(testBuildSbt, map[moduleID]string{
+		{org: "io.circe", operator: "%%", name: "circe-core"}: "0.14.6",
+	})
+	require.Contains(t, contents, `libraryDependencies += "io.circe" %% "circe-core" % "0.14.6"`)
+
+	contents = removeFromSpecfileContents(contents, map[api.PkgName]bool{
+		"org.typelevel::cats-core":  true,
+		"org.postgresql:postgresql": true,
+	})
+	require.Equal(t, map[api.PkgName]api.PkgSpec{
+		"com.lihaoyi::os-lib":  "0.9.1",
+		"org.scalameta::munit": "0.7.29",
+		"io.circe::circe-core": "0.14.6",
+	}, listSpecfileWithContents(contents))
+	require.Contains(t, contents, `scalaVersion := "2.13.12"`)
+}
+
+func TestParseClasspathEntry(t *testing.T) {
+	name, version, ok := parseClasspathEntry(
+		"/home/runner/.cache/coursier/v1/https/repo1.maven.org/maven2/org/typelevel/cats-core_2.13/2.9.0/cats-core_2.13-2.9.0.jar",
+		"2.13",
+	)
+	require.True(t, ok)
+	require.Equal(t, api.PkgName("org.typelevel::cats-core"), name)
+	require.Equal(t, api.PkgVersion("2.9.0"), version)
+
+	name, _, ok = parseClasspathEntry(
+		"/home/runner/.cache/coursier/v1/https/repo1.maven.org/maven2/org/scala-lang/scala-library/2.13.12/scala-library-2.13.12.jar",
+		"2.13",
+	)
+	require.True(t, ok)
+	require.Equal(t, api.PkgName("org.scala-lang:scala-library"), name)
+
+	_, _, ok = parseClasspathEntry("/home/runner/project/target/scala-2.13/classes", "2.13")
+	require.False(t, ok)
+}
@@ -0,0 +1,389 @@
+// Package swift provides a backend for Swift and Objective-C using
+// CocoaPods.
+package swift
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/replit/upm/internal/api"
+	"github.com/replit/upm/internal/util"
+)
+
+// swiftPatterns is the FilenamePatterns value for CocoaPodsBackend.
+var swiftPatterns = []string{"*.swift", "*.m", "*.mm"}
+
+// trunkURL is the base URL of the CocoaPods trunk API.
+const trunkURL = "https://trunk.cocoapods.org/api/v1/pods/"
+
+// cdnURL is the base URL of the CocoaPods CDN, which serves the index
+// of every pod in trunk.
+const cdnURL = "https://cdn.cocoapods.org/"
+
+// podspec represents the relevant parts of a podspec in JSON format,
+// as returned by trunk.
+type podspec struct {
+	Name          string `json:"name"`
+	Version       string `json:"version"`
+	Summary       string `json:"summary"`
+	Homepage      string `json:"homepage"`
+	Documentation string `json:"documentation_url"`
+	// Either a string or an object with a type field.
+	License interface{} `json:"license"`
+	// Either a string, a list of strings, or an object mapping
+	// names to email addresses.
+	Authors interface{} `json:"authors"`
+	Source  struct {
+		Git string `json:"git"`
+	} `json:"source"`
+	Dependencies map[string]interface{} `json:"dependencies"`
+	Subspecs     []struct {
+		Dependencies map[string]interface{} `json:"dependencies"`
+	} `json:"subspecs"`
+}
+
+// swiftImportRegexp matches import statements in Swift, including
+// @testable imports and imports of a single declaration.
+var swiftImportRegexp = regexp.MustCompile(
+	`(?m)^[ \t]*(?:@testable[ \t]+)?import[ \t]+(?:(?:typealias|struct|class|enum|protocol|let|var|func)[ \t]+)?([A-Za-z_][A-Za-z0-9_]*)`,
+)
+
+// objcImportRegexp matches #import <Module/Header.h>, #include
+// <Module/Header.h>, and @import Module; in Objective-C. Imports of
+// headers in quotes are from the project itself, so they are skipped.
+var objcImportRegexp = regexp.MustCompile(
+	`(?m)^[ \t]*(?:#(?:import|include)[ \t]*<([A-Za-z_][A-Za-z0-9_]*)/|@import[ \t]+([A-Za-z_][A-Za-z0-9_]*))`,
+)
+
+// appleModules are modules provided by Apple's SDKs and the Swift
+// standard library, which must never be guessed as pods.
+var appleModules = map[string]bool{
+	"ARKit":                   true,
+	"AVFoundation":            true,
+	"AVKit":                   true,
+	"Accelerate":              true,
+	"AppKit":                  true,
+	"AppTrackingTransparency": true,
+	"AudioToolbox":            true,
+	"AuthenticationServices":  true,
+	"BackgroundTasks":         true,
+	"CloudKit":                true,
+	"Cocoa":                   true,
+	"Combine":                 true,
+	"Contacts":                true,
+	"ContactsUI":              true,
+	"CoreBluetooth":           true,
+	"CoreData":                true,
+	"CoreFoundation":          true,
+	"CoreGraphics":            true,
+	"CoreImage":               true,
+	"CoreLocation":            true,
+	"CoreML":                  true,
+	"CoreMedia":               true,
+	"CoreMotion":              true,
+	"CoreServices":            true,
+	"CoreText":                true,
+	"CoreVideo":               true,
+	"CryptoKit":               true,
+	"Darwin":                  true,
+	"Dispatch":                true,
+	"EventKit":                true,
+	"Foundation":              true,
+	"GameKit":                 true,
+	"HealthKit":               true,
+	"ImageIO":                 true,
+	"JavaScriptCore":          true,
+	"LocalAuthentication":     true,
+	"MapKit":                  true,
+	"MessageUI":               true,
+	"Metal":                   true,
+	"MetalKit":                true,
+	"MobileCoreServices":      true,
+	"Network":                 true,
+	"OSLog":                   true,
+	"ObjectiveC":              true,
+	"Observation":             true,
+	"PassKit":                 true,
+	"Photos":                  true,
+	"PhotosUI":                true,
+	"QuartzCore":              true,
+	"SafariServices":          true,
+	"SceneKit":                true,
+	"Security":                true,
+	"SpriteKit":               true,
+	"StoreKit":                true,
+	"Swift":                   true,
+	"SwiftData":               true,
+	"SwiftUI":                 true,
+	"SystemConfiguration":     true,
+	"UIKit":                   true,
+	"UniformTypeIdentifiers":  true,
+	"UserNotifications":       true,
+	"Vision":                  true,
+	"WebKit":                  true,
+	"WidgetKit":               true,
+	"XCTest":                  true,
+	"os":                      true,
+}
+
+// moduleToPod maps commonly used modules to the pods that provide
+// them, for the cases where the names differ.
+var moduleToPod = map[string]string{
+	"GoogleMobileAds": "Google-Mobile-Ads-SDK",
+	"Lottie":          "lottie-ios",
+	"Reachability":    "ReachabilitySwift",
+}
+
+// trunkGet fetches the given path from trunk and returns the response
+// body, or nil if there is no such pod.
+func trunkGet(ctx context.Context, path string) []byte {
+	return podsGet(ctx, "CocoaPods trunk", trunkURL+path)
+}
+
+// podsGet fetches the given URL and returns the response body, or nil
+// if there is no such resource. The service name is used in errors.
+func podsGet(ctx context.Context, service string, endpoint string) []byte {
+	resp, err := util.HTTPGet(ctx, endpoint)
+	if err != nil {
+		util.Die("%s: %s", service, err)
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case 200:
+		break
+	case 404:
+		return nil
+	default:
+		util.Die("%s: HTTP status %d", service, resp.StatusCode)
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		util.Die("%s: %s", service, err)
+	}
+	return body
+}
+
+// search implements Search for CocoaPods. Trunk has no search API, so
+// this looks for the query in the names of all the pods on the CDN,
+// with exact matches first.
+func search(ctx context.Context, query string) []api.PkgInfo {
+	body := podsGet(ctx, "CocoaPods CDN", cdnURL+"all_pods.txt")
+	if body == nil {
+		util.Die("CocoaPods CDN: no all_pods.txt")
+	}
+
+	query = strings.ToLower(strings.TrimSpace(query))
+	names := []string{}
+	for _, name := range strings.Split(string(body), "\n") {
+		name = strings.TrimSpace(name)
+		if name != "" && strings.Contains(strings.ToLower(name), query) {
+			names = append(names, name)
+		}
+	}
+	sort.Slice(names, func(i, j int) bool {
+		exactI := strings.ToLower(names[i]) == query
+		exactJ := strings.ToLower(names[j]) == query
+		if exactI != exactJ {
+			return exactI
+		}
+		return names[i] < names[j]
+	})
+
+	results := []api.PkgInfo{}
+	for _, name := range names {
+		results = append(results, api.PkgInfo{Name: name})
+	}
+	return results
+}
+
+// joinAuthors returns the authors field of a podspec as a single
+// string.
+func joinAuthors(authors interface{}) string {
+	switch authors := authors.(type) {
+	case string:
+		return authors
+	case []interface{}:
+		names := []string{}
+		for _, name := range authors {
+			if name, ok := name.(string); ok {
+				names = append(names, name)
+			}
+		}
+		return strings.Join(names, ", ")
+	case map[string]interface{}:
+		names := []string{}
+		for name, email := range authors {
+			if email, ok := email.(string); ok && email != "" {
+				name += " <" + email + ">"
+			}
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return strings.Join(names, ", ")
+	}
+	return ""
+}
+
+// licenseType returns the license field of a podspec as a string.
+func licenseType(license interface{}) string {
+	switch license := license.(type) {
+	case string:
+		return license
+	case map[string]interface{}:
+		if t, ok := license["type"].(string); ok {
+			return t
+		}
+	}
+	return ""
+}
+
+// info implements Info for CocoaPods, using the latest podspec from
+// trunk.
+func info(ctx context.Context, name api.PkgName) api.PkgInfo {
+	body := trunkGet(ctx, util.EscapePathSegment(string(name))+"/specs/latest")
+	if body == nil {
+		return api.PkgInfo{}
+	}
+	var spec podspec
+	if err := json.Unmarshal(body, &spec); err != nil {
+		util.Die("CocoaPods trunk response: %s", err)
+	}
+
+	// Dependencies of subspecs count too, except on other subspecs
+	// of the same pod.
+	seen := map[string]bool{}
+	deps := []string{}
+	addDeps := func(m map[string]interface{}) {
+		for dep := range m {
+			dep = strings.SplitN(dep, "/", 2)[0]
+			if dep != spec.Name && !seen[dep] {
+				seen[dep] = true
+				deps = append(deps, dep)
+			}
+		}
+	}
+	addDeps(spec.Dependencies)
+	for _, subspec := range spec.Subspecs {
+		addDeps(subspec.Dependencies)
+	}
+	sort.Strings(deps)
+
+	return api.PkgInfo{
+		Name:             spec.Name,
+		Description:      spec.Summary,
+		Version:          spec.Version,
+		HomepageURL:      spec.Homepage,
+		DocumentationURL: spec.Documentation,
+		SourceCodeURL:    spec.Source.Git,
+		License:          licenseType(spec.License),
+		Author:           joinAuthors(spec.Authors),
+		Dependencies:     deps,
+	}
+}
+
+// readPodfile returns the contents of the Podfile.
+func readPodfile() string {
+	contentsB, err := ioutil.ReadFile("Podfile")
+	if err != nil {
+		util.Die("Podfile: %s", err)
+	}
+	return string(contentsB)
+}
+
+// add implements Add for CocoaPods. If there is no Podfile yet, 'pod
+// init' creates one with a target for the Xcode project.
+func add(ctx context.Context, pkgs map[api.PkgName]api.PkgSpec, projectName string) {
+	if !util.Exists("Podfile") {
+		util.RunCmd(ctx, []string{"pod", "init"})
+	}
+	contents := addToPodfile(readPodfile(), pkgs)
+	util.ProgressMsg("write Podfile")
+	util.TryWriteAtomic("Podfile", []byte(contents))
+}
+
+// remove implements Remove for CocoaPods.
+func remove(ctx context.Context, pkgs map[api.PkgName]bool) {
+	contents := removeFromPodfile(readPodfile(), pkgs)
+	util.ProgressMsg("write Podfile")
+	util.TryWriteAtomic("Podfile", []byte(contents))
+}
+
+// guess implements Guess for CocoaPods. Modules that are part of
+// Apple's SDKs, or that are the name of a directory in the project
+// (which is usually a target of its own), are skipped.
+func guess(ctx context.Context) (map[api.PkgName]bool, bool) {
+	pkgs := map[api.PkgName]bool{}
+	addModule := func(mod string) {
+		if mod == "" || appleModules[mod] {
+			return
+		}
+		if info, err := os.Stat(mod); err == nil && info.IsDir() {
+			return
+		}
+		if pod, ok := moduleToPod[mod]; ok {
+			mod = pod
+		}
+		pkgs[api.PkgName(mod)] = true
+	}
+	for _, match := range util.SearchRecursive(ctx, swiftImportRegexp, []string{"*.swift"}) {
+		addModule(match[1])
+	}
+	for _, match := range util.SearchRecursive(ctx, objcImportRegexp, []string{"*.m", "*.mm", "*.h"}) {
+		addModule(match[1] + match[2])
+	}
+	return pkgs, true
+}
+
+// CocoaPodsBackend is a UPM backend for Swift and Objective-C that
+// uses CocoaPods.
+var CocoaPodsBackend = api.LanguageBackend{
+	Name:             "swift-cocoapods",
+	Specfile:         "Podfile",
+	Lockfile:         "Podfile.lock",
+	FilenamePatterns: swiftPatterns,
+	Quirks:           api.QuirksLockAlsoInstalls,
+	Executables:      []string{"pod"},
+	GetPackageDir: func() string {
+		return "Pods"
+	},
+	Search: search,
+	Info:   info,
+	Add:    add,
+	Remove: remove,
+	// 'pod install' only resolves the pods that aren't in
+	// Podfile.lock yet, and installs everything.
+	Lock: func(ctx context.Context) {
+		util.RunCmd(ctx, []string{"pod", "install"})
+	},
+	Upgrade: func(ctx context.Context, pkgs map[api.PkgName]bool) {
+		cmd := []string{"pod", "update"}
+		for name := range pkgs {
+			cmd = append(cmd, string(name))
+		}
+		util.RunCmd(ctx, cmd)
+	},
+	// With --deployment, 'pod install' fails rather than changing
+	// Podfile.lock.
+	Install: func(ctx context.Context) {
+		util.RunCmd(ctx, []string{"pod", "install", "--deployment"})
+	},
+	ListSpecfile: func() map[api.PkgName]api.PkgSpec {
+		return listPodfile(readPodfile())
+	},
+	ListLockfile: func() map[api.PkgName]api.PkgVersion {
+		contentsB, err := ioutil.ReadFile("Podfile.lock")
+		if err != nil {
+			util.Die("Podfile.lock: %s", err)
+		}
+		return listPodLockfile(contentsB)
+	},
+	GuessRegexps: []*regexp.Regexp{swiftImportRegexp, objcImportRegexp},
+	Guess:        guess,
+}
@@ -0,0 +1,243 @@
+package swift
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+
+	"github.com/replit/upm/internal/api"
+	"github.com/replit/upm/internal/util"
+)
+
+// podLineRegexp matches a pod declaration in a Podfile. The capture
+// groups are the indentation, the name (in double or single quotes),
+// and the rest of the line, which holds the requirements and options.
+var podLineRegexp = regexp.MustCompile(`^([ \t]*)pod[ \t]+(?:"([^"]+)"|'([^']+)')(.*)$`)
+
+// podRequirementRegexp matches the next argument of a pod declaration
+// if it is a version requirement, which is a string (as opposed to an
+// option such as :git => '...').
+var podRequirementRegexp = regexp.MustCompile(`^[ \t]*,[ \t]*(?:"([^"]*)"|'([^']*)')`)
+
+// podTargetRegexp matches the start of a target block.
+var podTargetRegexp = regexp.MustCompile(`^[ \t]*(?:abstract_)?target\b`)
+
+// podOpenerRegexp matches a line that starts a Ruby block which is
+// closed by a line starting with end.
+var podOpenerRegexp = regexp.MustCompile(
+	`(?:\bdo(?:[ \t]*\|[^|]*\|)?[ \t]*(?:#.*)?$)|^[ \t]*(?:if|unless|def|case|begin|while|until)\b`,
+)
+
+// podCloserRegexp matches a line that closes a Ruby block.
+var podCloserRegexp = regexp.MustCompile(`^[ \t]*end\b`)
+
+// podDeclaration is a pod declaration found in a Podfile.
+type podDeclaration struct {
+	line   int
+	indent string
+	name   api.PkgName
+	spec   api.PkgSpec
+}
+
+// parsePodLine returns the pod declared on the given line of a
+// Podfile, and false if there is none. Multiple requirements, such as
+// '>= 1.0', '< 2.0', are joined into a single spec.
+func parsePodLine(line string) (podDeclaration, bool) {
+	m := podLineRegexp.FindStringSubmatch(line)
+	if m == nil {
+		return podDeclaration{}, false
+	}
+	reqs := []string{}
+	rest := m[4]
+	for {
+		r := podRequirementRegexp.FindStringSubmatch(rest)
+		if r == nil {
+			break
+		}
+		reqs = append(reqs, r[1]+r[2])
+		rest = rest[len(r[0]):]
+	}
+	return podDeclaration{
+		indent: m[1],
+		name:   api.PkgName(m[2] + m[3]),
+		spec:   api.PkgSpec(strings.Join(reqs, ", ")),
+	}, true
+}
+
+// findPodDeclarations returns every pod declared in the given lines
+// of a Podfile, in any target.
+func findPodDeclarations(lines []string) []podDeclaration {
+	decls := []podDeclaration{}
+	for i, line := range lines {
+		if decl, ok := parsePodLine(line); ok {
+			decl.line = i
+			decls = append(decls, decl)
+		}
+	}
+	return decls
+}
+
+// listPodfile returns the pods declared in the given Podfile contents.
+// A pod that appears in several targets is only listed once.
+func listPodfile(contents string) map[api.PkgName]api.PkgSpec {
+	pkgs := map[api.PkgName]api.PkgSpec{}
+	for _, decl := range findPodDeclarations(strings.Split(contents, "\n")) {
+		if _, ok := pkgs[decl.name]; !ok {
+			pkgs[decl.name] = decl.spec
+		}
+	}
+	return pkgs
+}
+
+// formatPodLine returns a pod declaration for the given pod.
+func formatPodLine(name api.PkgName, spec api.PkgSpec, indent string) string {
+	line := fmt.Sprintf("%spod '%s'", indent, name)
+	for _, req := range strings.Split(string(spec), ",") {
+		if req = strings.TrimSpace(req); req != "" {
+			line += fmt.Sprintf(", '%s'", req)
+		}
+	}
+	return line
+}
+
+// podLineDepths returns how deeply each of the given lines of a
+// Podfile is nested in Ruby blocks. The lines that open and close a
+// block are at the same depth as the block itself.
+func podLineDepths(lines []string) []int {
+	depths := []int{}
+	depth := 0
+	for _, line := range lines {
+		if podCloserRegexp.MatchString(line) && depth > 0 {
+			depth--
+			depths = append(depths, depth)
+		} else if podOpenerRegexp.MatchString(line) {
+			depths = append(depths, depth)
+			depth++
+		} else {
+			depths = append(depths, depth)
+		}
+	}
+	return depths
+}
+
+// findFirstTarget returns the indices of the lines that open and close
+// the first top-level target block in the given lines of a Podfile, or
+// -1 and -1 if there is none.
+func findFirstTarget(lines []string, depths []int) (int, int) {
+	for i, line := range lines {
+		if depths[i] != 0 || !podTargetRegexp.MatchString(line) || !podOpenerRegexp.MatchString(line) {
+			continue
+		}
+		for j := i + 1; j < len(lines); j++ {
+			if depths[j] == 0 {
+				return i, j
+			}
+		}
+		return -1, -1
+	}
+	return -1, -1
+}
+
+// addToPodfile returns the given Podfile contents with the given pods
+// added to the first target, after the pods it declares already (but
+// not those in nested targets). If there are no targets, they are
+// added at the top level instead.
+func addToPodfile(contents string, pkgs map[api.PkgName]api.PkgSpec) string {
+	lines := strings.Split(contents, "\n")
+	depths := podLineDepths(lines)
+	start, end := findFirstTarget(lines, depths)
+
+	var insert, depth int
+	var indent string
+	if start == -1 {
+		insert = len(lines)
+		if insert > 0 && lines[insert-1] == "" {
+			insert--
+		}
+	} else {
+		insert = start + 1
+		indent = regexp.MustCompile(`^[ \t]*`).FindString(lines[start]) + "  "
+		depth = 1
+	}
+
+	for _, decl := range findPodDeclarations(lines) {
+		if depths[decl.line] != depth || (start != -1 && (decl.line < start || decl.line > end)) {
+			continue
+		}
+		indent = decl.indent
+		insert = decl.line + 1
+	}
+
+	names := []string{}
+	for name := range pkgs {
+		names = append(names, string(name))
+	}
+	sort.Strings(names)
+	added := []string{}
+	for _, name := range names {
+		added = append(added, formatPodLine(api.PkgName(name), pkgs[api.PkgName(name)], indent))
+	}
+
+	result := append([]string{}, lines[:insert]...)
+	result = append(result, added...)
+	result = append(result, lines[insert:]...)
+	return strings.Join(result, "\n")
+}
+
+// removeFromPodfile returns the given Podfile contents with the given
+// pods deleted from every target.
+func removeFromPodfile(contents string, pkgs map[api.PkgName]bool) string {
+	kept := []string{}
+	for _, line := range strings.Split(contents, "\n") {
+		if decl, ok := parsePodLine(line); ok && pkgs[decl.name] {
+			continue
+		}
+		kept = append(kept, line)
+	}
+	return strings.Join(kept, "\n")
+}
+
+// podLockfile represents the relevant parts of Podfile.lock.
+type podLockfile struct {
+	// Each element is either a string such as "Alamofire
+	// (5.6.4)", or a map from such a string to the dependencies
+	// of that pod.
+	Pods []interface{} `yaml:"PODS"`
+}
+
+// podVersionRegexp matches an entry of PODS in Podfile.lock, capturing
+// the name and version.
+var podVersionRegexp = regexp.MustCompile(`^(\S+) \(([^)]+)\)$`)
+
+// listPodLockfile returns the pods recorded in the given Podfile.lock
+// contents, including indirect dependencies and subspecs.
+func listPodLockfile(contents []byte) map[api.PkgName]api.PkgVersion {
+	var lockfile podLockfile
+	if err := yaml.Unmarshal(contents, &lockfile); err != nil {
+		util.Die("Podfile.lock: %s", err)
+	}
+
+	pkgs := map[api.PkgName]api.PkgVersion{}
+	add := func(entry interface{}) {
+		s, ok := entry.(string)
+		if !ok {
+			return
+		}
+		if m := podVersionRegexp.FindStringSubmatch(s); m != nil {
+			pkgs[api.PkgName(m[1])] = api.PkgVersion(m[2])
+		}
+	}
+	for _, entry := range lockfile.Pods {
+		if deps, ok := entry.(map[interface{}]interface{}); ok {
+			for key := range deps {
+				add(key)
+			}
+		} else {
+			add(entry)
+		}
+	}
+	return pkgs
+}
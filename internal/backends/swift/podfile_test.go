@@ -0,0 +1,124 @@
+package swift
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/replit/upm/internal/api"
+)
+
+const testPodfile = `platform :ios, '13.0'
+
+target 'MyApp' do
+  use_frameworks!
+
+  pod 'Alamofire', '~> 5.6'
+  pod "SnapKit"
+  pod 'Firebase/Analytics', '>= 10.0', '< 11.0'
+  pod 'MyLib', :path => '../MyLib'
+
+  target 'MyAppTests' do
+    inherit! :search_paths
+    pod 'Quick'
+  end
+end
+
+post_install do |installer|
+  installer.pods_project.targets.each do |target|
+  end
+end
+`
+
+const testPodfileLock = `PODS:
+  - Alamofire (5.6.4)
+  - Firebase/Analytics (10.3.0):
+    - Firebase/Core
+  - Firebase/Core (10.3.0)
+  - "GoogleUtilities/Environment (7.11.0)":
+    - PromisesObjC (< 3.0, >= 1.2)
+  - PromisesObjC (2.1.1)
+
+DEPENDENCIES:
+  - Alamofire (~> 5.6)
+  - Firebase/Analytics (< 11.0, >= 10.0)
+
+SPEC REPOS:
+  trunk:
+    - Alamofire
+
+COCOAPODS: 1.12.0
+`
+
+func TestListPodfile(t *testing.T) {
+	require.Equal(t, map[api.PkgName]api.PkgSpec{
+		"Alamofire":          "~> 5.6",
+		"SnapKit":            "",
+		"Firebase/Analytics": ">= 10.0, < 11.0",
+		"MyLib":              "",
+		"Quick":              "",
+	}, listPodfile(testPodfile))
+}
+
+func TestAddToPodfile(t *testing.T) {
+	contents := addToPodfile(testPodfile, map[api.PkgName]api.PkgSpec{
+		"Kingfisher": "~> 7.0",
+		"RxSwift":    ">= 6.0, < 7.0",
+	})
+	require.Contains(t, contents, "  pod 'MyLib', :path => '../MyLib'\n"+
+		"  pod 'Kingfisher', '~> 7.0'\n"+
+		"  pod 'RxSwift', '>= 6.0', '< 7.0'\n"+
+		"\n  target 'MyAppTests' do\n")
+	require.Equal(t, api.PkgSpec(">= 6.0, < 7.0"), listPodfile(contents)["RxSwift"])
+}
+
+func TestAddToPodfileWithoutPods(t *testing.T) {
+	contents := addToPodfile("target 'MyApp' do\n  use_frameworks!\nend\n", map[api.PkgName]api.PkgSpec{
+		"SnapKit": "",
+	})
+	require.Equal(t, "target 'MyApp' do\n  pod 'SnapKit'\n  use_frameworks!\nend\n", contents)
+
+	contents = addToPodfile("platform :ios, '13.0'\n", map[api.PkgName]api.PkgSpec{
+		"SnapKit": "",
+	})
+	require.Equal(t, "platform :ios, '13.0'\npod 'SnapKit'\n", contents)
+}
+
+func TestRemoveFromPodfile(t *testing.T) {
+	contents := removeFromPodfile(testPodfile, map[api.PkgName]bool{
+		"Alamofire": true,
+		"Quick":     true,
+	})
+	require.Equal(t, map[api.PkgName]api.PkgSpec{
+		"SnapKit":            "",
+		"Firebase/Analytics": ">= 10.0, < 11.0",
+		"MyLib":              "",
+	}, listPodfile(contents))
+	require.Contains(t, contents, "    inherit! :search_paths\n  end\n")
+}
+
+func TestListPodLockfile(t *testing.T) {
+	require.Equal(t, map[api.PkgName]api.PkgVersion{
+		"Alamofire":                   "5.6.4",
+		"Firebase/Analytics":          "10.3.0",
+		"Firebase/Core":               "10.3.0",
+		"GoogleUtilities/Environment": "7.11.0",
+		"PromisesObjC":                "2.1.1",
+	}, listPodLockfile([]byte(testPodfileLock)))
+}
+
+func TestImportRegexps(t *testing.T) {
+	swift := "import UIKit\n@testable import MyApp\nimport struct Alamofire.URLEncoding\n// import Nope\n"
+	modules := []string{}
+	for _, m := range swiftImportRegexp.FindAllStringSubmatch(swift, -1) {
+		modules = append(modules, m[1])
+	}
+	require.Equal(t, []string{"UIKit", "MyApp", "Alamofire"}, modules)
+
+	objc := "#import <AFNetworking/AFNetworking.h>\n#import \"Local.h\"\n@import SDWebImage;\n"
+	modules = []string{}
+	for _, m := range objcImportRegexp.FindAllStringSubmatch(objc, -1) {
+		modules = append(modules, m[1]+m[2])
+	}
+	require.Equal(t, []string{"AFNetworking", "SDWebImage"}, modules)
+}
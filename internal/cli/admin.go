@@ -0,0 +1,90 @@
+package cli
+
+import (
+	"strings"
+	"time"
+
+	"github.com/replit/upm/internal/backends/nodejs/npmmap"
+	"github.com/replit/upm/internal/backends/python/pyindex"
+	"github.com/replit/upm/internal/backends/python/pypimap"
+	"github.com/replit/upm/internal/backends/ruby/gemmap"
+	"github.com/replit/upm/internal/util"
+)
+
+// genMapDefaults are the files and Go package that 'upm admin gen-map'
+// writes for each ecosystem, unless it is told otherwise: those that
+// go generate reads and writes.
+var genMapDefaults = map[string]struct{ out, goPackage string }{
+	"pypi":     {"pypi_packages.json", "python"},
+	"npm":      {"npm_packages.json", "nodejs"},
+	"rubygems": {"gem_packages.json", "ruby"},
+}
+
+// genMapEcosystems are the ecosystems that 'upm admin gen-map' can
+// build a module map for.
+var genMapEcosystems = []string{"pypi", "npm", "rubygems"}
+
+// runGenMap implements 'upm admin gen-map'. For PyPI, it crawls the
+// package index configured for the project into the JSON file out, as
+// pypimap.Crawl does with resume, restart, and verbose, and then, if
+// goFile is given, generates the Go source of the map from it, with
+// the download counts from the given source, as pypimap.NewStats
+// takes. For npm, it does the same with npmmap, which counts the
+// downloads itself and has nothing to restart, and for RubyGems, with
+// gemmap. Every crawl sends at most rateLimit requests a second to any
+// one host, if it isn't zero.
+func runGenMap(ecosystem string, out string, goFile string, goPackage string,
+	resume bool, restart bool, statsSource string, statsFile string, rateLimit float64, verbose bool) {
+
+	if rateLimit < 0 {
+		util.Die("--rate-limit can't be negative")
+	}
+	util.SetRateLimit(rateLimit)
+
+	switch ecosystem {
+	case "pypi":
+		// The source is checked before the crawl, which takes
+		// long enough that failing after it would be a shame.
+		// The counts in the entries are as of this crawl, or
+		// the one it resumed, which can't have been long ago.
+		stats := pypimap.NewStats(statsSource, statsFile, time.Now().Format("2006-01-02"))
+		pypimap.Crawl(pyindex.Get(), out, resume, restart, verbose)
+		if goFile != "" {
+			util.ProgressMsg("write " + goFile)
+			pypimap.Generate(out, goPackage, goFile, stats)
+		}
+	case "npm":
+		if restart {
+			util.Die("--restart only applies to --ecosystem pypi, since the npm crawl keeps no cache")
+		}
+		if verbose {
+			util.Die("--verbose only applies to --ecosystem pypi")
+		}
+		if statsSource != "entries" || statsFile != "" {
+			util.Die("--stats and --stats-file only apply to --ecosystem pypi, since the npm crawl counts the downloads itself")
+		}
+		npmmap.Crawl(npmmap.DefaultRegistry, out, resume)
+		if goFile != "" {
+			util.ProgressMsg("write " + goFile)
+			npmmap.Generate(out, goPackage, goFile)
+		}
+	case "rubygems":
+		if restart {
+			util.Die("--restart only applies to --ecosystem pypi, since the rubygems crawl keeps no cache")
+		}
+		if verbose {
+			util.Die("--verbose only applies to --ecosystem pypi")
+		}
+		if statsSource != "entries" || statsFile != "" {
+			util.Die("--stats and --stats-file only apply to --ecosystem pypi, since rubygems.org counts the downloads itself")
+		}
+		gemmap.Crawl(gemmap.DefaultRegistry, out, resume)
+		if goFile != "" {
+			util.ProgressMsg("write " + goFile)
+			gemmap.Generate(out, goPackage, goFile)
+		}
+	default:
+		util.Die("unknown ecosystem %q (must be one of: %s)",
+			ecosystem, strings.Join(genMapEcosystems, ", "))
+	}
+}
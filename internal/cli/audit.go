@@ -0,0 +1,184 @@
+package cli
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/replit/upm/internal/api"
+	"github.com/replit/upm/internal/backends"
+	"github.com/replit/upm/internal/osv"
+	"github.com/replit/upm/internal/project"
+	"github.com/replit/upm/internal/report"
+	"github.com/replit/upm/internal/store"
+	"github.com/replit/upm/internal/table"
+	"github.com/replit/upm/internal/util"
+)
+
+// auditFinding is a known vulnerability of a package in the lockfile,
+// as 'upm audit' reports it.
+type auditFinding struct {
+	Name    api.PkgName    `json:"name"`
+	Version api.PkgVersion `json:"version"`
+	osv.Vuln
+}
+
+// parseFailOn returns the least severe vulnerability that makes 'upm
+// audit' fail, given with --fail-on or in the project config, or false
+// if none does. If it is not valid, it terminates the process.
+func parseFailOn(failOn string) (osv.Severity, bool) {
+	if failOn == "" {
+		failOn = project.Read().Audit.FailOn
+	}
+	if failOn == "" {
+		return osv.SeverityLow, true
+	}
+	if failOn == "none" {
+		return osv.SeverityUnknown, false
+	}
+	severity, ok := osv.ParseSeverity(failOn)
+	if !ok {
+		util.Die(`invalid severity %#v (must be "low", "moderate", "high", "critical", or "none")`, failOn)
+	}
+	return severity, true
+}
+
+// collectAudit looks up the packages in the lockfile of the given
+// backend in OSV.dev, and returns their known vulnerabilities, sorted
+// by normalized name, and then from the most severe. Editable and
+// linked packages are left out, since they are installed from a
+// directory rather than the release that OSV.dev knows by their
+// version.
+func collectAudit(ctx context.Context, b api.LanguageBackend) []auditFinding {
+	if b.OSVEcosystem == "" {
+		util.Die("%s packages can't be audited, since OSV.dev doesn't know their ecosystem", b.Name)
+	}
+	if !util.Exists(b.Lockfile) {
+		util.Die("%s does not exist", b.Lockfile)
+	}
+	s := silenceSubroutines()
+	locked := b.ListLockfile()
+	s.restore()
+
+	local := map[api.PkgName]bool{}
+	for name := range store.GetEditable(b) {
+		local[b.NormalizePackageName(name)] = true
+	}
+	for name := range store.GetLinks(b) {
+		local[name] = true
+	}
+	names := []api.PkgName{}
+	for name := range locked {
+		if !local[b.NormalizePackageName(name)] {
+			names = append(names, name)
+		}
+	}
+	sortPkgNames(b, names)
+	pkgs := []osv.Package{}
+	for _, name := range names {
+		pkgs = append(pkgs, osv.Package{
+			Ecosystem: b.OSVEcosystem,
+			Name:      string(name),
+			Version:   string(locked[name]),
+		})
+	}
+
+	util.ProgressMsg(fmt.Sprintf("look up %d packages in %s", len(pkgs), osv.URL()))
+	vulns, err := osv.Query(ctx, osv.URL(), pkgs)
+	if err != nil {
+		util.Die("%s", err)
+	}
+	findings := []auditFinding{}
+	for i, name := range names {
+		for _, vuln := range vulns[i] {
+			findings = append(findings, auditFinding{Name: name, Version: locked[name], Vuln: vuln})
+		}
+	}
+	return findings
+}
+
+// auditReport returns the report of the given findings for a sink.
+func auditReport(b api.LanguageBackend, findings []auditFinding) report.Report {
+	lines := []string{}
+	for _, finding := range findings {
+		lines = append(lines, fmt.Sprintf("%s %s: %s (%s)",
+			finding.Name, finding.Version, finding.ID, finding.Severity))
+	}
+	title := "1 known vulnerability"
+	if len(findings) != 1 {
+		title = fmt.Sprintf("%d known vulnerabilities", len(findings))
+	}
+	return report.Report{
+		Command:  "audit",
+		Language: b.Name,
+		Title:    title,
+		Lines:    lines,
+		Items:    findings,
+	}
+}
+
+// runAudit implements 'upm audit'. If there are findings, they are
+// also posted to the given sink, if any. It fails if any vulnerability
+// is at least as severe as failOn, or of unknown severity, since that
+// may be just as severe.
+func runAudit(ctx context.Context, language string, failOn string, sink *report.Sink, outputFormat outputFormat) {
+	b := backends.GetBackend(language)
+	threshold, failing := parseFailOn(failOn)
+	findings := collectAudit(ctx, b)
+
+	switch outputFormat {
+	case outputFormatTable:
+		if len(findings) == 0 {
+			util.Log("no known vulnerabilities found")
+			break
+		}
+		t := table.New("name", "version", "id", "severity", "fixed in", "summary")
+		for _, finding := range findings {
+			fixed := strings.Join(finding.Fixed, ", ")
+			if fixed == "" {
+				fixed = "(no fix)"
+			}
+			t.AddRow(string(finding.Name), string(finding.Version), finding.ID,
+				finding.Severity.String(), fixed, finding.Summary)
+		}
+		t.Print()
+
+	case outputFormatJSON:
+		outputB, err := json.Marshal(findings)
+		if err != nil {
+			panic("couldn't marshal json")
+		}
+		fmt.Println(string(outputB))
+
+	default:
+		util.Panicf("unknown output format %d", outputFormat)
+	}
+	if sink != nil && len(findings) > 0 {
+		sink.Post(auditReport(b, findings))
+	}
+
+	if !failing {
+		return
+	}
+	counts := map[osv.Severity]int{}
+	for _, finding := range findings {
+		if finding.Severity >= threshold || finding.Severity == osv.SeverityUnknown {
+			counts[finding.Severity]++
+		}
+	}
+	if len(counts) == 0 {
+		return
+	}
+	severities := []osv.Severity{}
+	for severity := range counts {
+		severities = append(severities, severity)
+	}
+	sort.Slice(severities, func(i, j int) bool { return severities[i] > severities[j] })
+	parts := []string{}
+	for _, severity := range severities {
+		parts = append(parts, fmt.Sprintf("%d %s", counts[severity], severity))
+	}
+	util.Die("found vulnerabilities at or above %s (%s)", threshold, strings.Join(parts, ", "))
+}
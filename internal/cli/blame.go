@@ -0,0 +1,184 @@
+package cli
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/replit/upm/internal/api"
+	"github.com/replit/upm/internal/backends"
+	"github.com/replit/upm/internal/config"
+	"github.com/replit/upm/internal/table"
+	"github.com/replit/upm/internal/util"
+)
+
+// blameEntry is a commit that added, changed, or removed a package in
+// the specfile.
+type blameEntry struct {
+	// The full hash of the commit, or the empty string for changes
+	// that haven't been committed yet.
+	Commit  string `json:"commit"`
+	Date    string `json:"date"`
+	Author  string `json:"author"`
+	Subject string `json:"subject"`
+	// "added", "changed", or "removed".
+	Change string `json:"change"`
+	// The spec of the package after the commit, which is empty if
+	// it was removed.
+	Spec api.PkgSpec `json:"spec"`
+}
+
+// listSpecfileContents parses the given contents of the specfile of a
+// backend, by writing them to a temporary directory and calling
+// ListSpecfile there.
+func listSpecfileContents(b api.LanguageBackend, contents []byte) map[api.PkgName]api.PkgSpec {
+	cwd, err := os.Getwd()
+	if err != nil {
+		util.Die("%s", err)
+	}
+	tmp := util.TempDir("upm-blame-")
+	defer util.RemoveTemp(tmp)
+
+	filename := filepath.Join(tmp, b.Specfile)
+	if err := ioutil.WriteFile(filename, contents, 0666); err != nil {
+		util.Die("%s: %s", filename, err)
+	}
+	if err := os.Chdir(tmp); err != nil {
+		util.Die("%s: %s", tmp, err)
+	}
+	defer func() {
+		if err := os.Chdir(cwd); err != nil {
+			util.Die("%s: %s", cwd, err)
+		}
+	}()
+	return b.ListSpecfile()
+}
+
+// findPkg returns the spec of the package with the given normalized
+// name, and true if it is among the given packages.
+func findPkg(b api.LanguageBackend, pkgs map[api.PkgName]api.PkgSpec, name api.PkgName) (api.PkgSpec, bool) {
+	for pkg, spec := range pkgs {
+		if b.NormalizePackageName(pkg) == name {
+			return spec, true
+		}
+	}
+	return "", false
+}
+
+// blameChange compares the spec of a package before and after a
+// commit, returning "added", "changed", "removed", or the empty string
+// if the commit didn't affect it.
+func blameChange(oldSpec api.PkgSpec, oldOK bool, newSpec api.PkgSpec, newOK bool) string {
+	switch {
+	case !oldOK && newOK:
+		return "added"
+	case oldOK && !newOK:
+		return "removed"
+	case oldOK && newOK && oldSpec != newSpec:
+		return "changed"
+	default:
+		return ""
+	}
+}
+
+// runBlame implements 'upm blame'.
+func runBlame(ctx context.Context, language string, pkg string, outputFormat outputFormat) {
+	b := backends.GetBackend(language)
+	name := b.NormalizePackageName(api.PkgName(pkg))
+
+	output, code := util.GetCmdOutputAndExitCode(ctx, []string{
+		"git", "rev-parse", "--is-inside-work-tree",
+	})
+	if code != 0 || strings.TrimSpace(string(output)) != "true" {
+		util.Die("upm blame can only be used in a git repository")
+	}
+
+	// Each line is the hash, date, author, and subject of a commit
+	// that touched the specfile, oldest first.
+	log := util.GetCmdOutput(ctx, []string{
+		"git", "log", "--reverse", "--date=short",
+		"--format=%H%x00%ad%x00%an%x00%s", "--", b.Specfile,
+	})
+
+	entries := []blameEntry{}
+	var oldSpec api.PkgSpec
+	oldOK := false
+
+	// Reading the specfile at every commit would otherwise print a
+	// command for each of them.
+	quiet := config.Quiet
+	config.Quiet = true
+	for _, line := range strings.Split(strings.TrimSpace(string(log)), "\n") {
+		fields := strings.SplitN(line, "\x00", 4)
+		if len(fields) != 4 {
+			continue
+		}
+		contents, code := util.GetCmdOutputAndExitCode(ctx, []string{
+			"git", "show", fields[0] + ":./" + b.Specfile,
+		})
+		newSpec, newOK := api.PkgSpec(""), false
+		// A commit that deleted the specfile removed every
+		// package.
+		if code == 0 {
+			newSpec, newOK = findPkg(b, listSpecfileContents(b, contents), name)
+		}
+		if change := blameChange(oldSpec, oldOK, newSpec, newOK); change != "" {
+			entries = append(entries, blameEntry{
+				Commit:  fields[0],
+				Date:    fields[1],
+				Author:  fields[2],
+				Subject: fields[3],
+				Change:  change,
+				Spec:    newSpec,
+			})
+		}
+		oldSpec, oldOK = newSpec, newOK
+	}
+	config.Quiet = quiet
+
+	newSpec, newOK := api.PkgSpec(""), false
+	if util.Exists(b.Specfile) {
+		newSpec, newOK = findPkg(b, b.ListSpecfile(), name)
+	}
+	if change := blameChange(oldSpec, oldOK, newSpec, newOK); change != "" {
+		entries = append(entries, blameEntry{
+			Subject: "(not committed yet)",
+			Change:  change,
+			Spec:    newSpec,
+		})
+	}
+
+	switch outputFormat {
+	case outputFormatTable:
+		if len(entries) == 0 {
+			util.Log(pkg, "has never been in", b.Specfile)
+			return
+		}
+		t := table.New("commit", "date", "author", "change", "spec", "subject")
+		for _, entry := range entries {
+			commit := entry.Commit
+			if len(commit) > 10 {
+				commit = commit[:10]
+			}
+			t.AddRow(
+				commit, entry.Date, entry.Author, entry.Change,
+				string(entry.Spec), entry.Subject,
+			)
+		}
+		t.Print()
+
+	case outputFormatJSON:
+		outputB, err := json.Marshal(entries)
+		if err != nil {
+			panic("couldn't marshal json")
+		}
+		fmt.Println(string(outputB))
+
+	default:
+		util.Panicf("unknown output format %d", outputFormat)
+	}
+}
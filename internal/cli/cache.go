@@ -0,0 +1,132 @@
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/replit/upm/internal/backends"
+	"github.com/replit/upm/internal/config"
+	"github.com/replit/upm/internal/store"
+	"github.com/replit/upm/internal/table"
+	"github.com/replit/upm/internal/util"
+)
+
+// cacheStats is what 'upm cache stats' reports.
+type cacheStats struct {
+	// The store, and its size in bytes, or 0 if it doesn't exist.
+	Store  string             `json:"store"`
+	Size   int64              `json:"size"`
+	Caches []store.CacheStats `json:"caches"`
+}
+
+// backendMatcher returns a function that says whether the backend of
+// the given name matches the given --lang argument value, which every
+// backend does if it is empty.
+func backendMatcher(language string) func(string) bool {
+	return func(name string) bool {
+		return language == "" || backends.NameMatchesLanguage(name, language)
+	}
+}
+
+// parseCacheKinds returns the kinds of caches named by the given
+// arguments of 'upm cache clear', or all of them if there are none. If
+// any isn't one, it terminates the process.
+func parseCacheKinds(args []string) map[string]bool {
+	kinds := map[string]bool{}
+	if len(args) == 0 {
+		for _, kind := range store.CacheKinds {
+			kinds[kind] = true
+		}
+		return kinds
+	}
+	for _, arg := range args {
+		known := false
+		for _, kind := range store.CacheKinds {
+			if arg == kind {
+				known = true
+			}
+		}
+		if !known {
+			util.Die(`unknown cache %#v (must be "info", "licenses", "guess", or "listing")`, arg)
+		}
+		kinds[arg] = true
+	}
+	return kinds
+}
+
+// runCacheDir implements 'upm cache dir', printing the absolute path
+// of the directory of the store.
+func runCacheDir() {
+	dir, err := filepath.Abs(filepath.Dir(store.Location()))
+	if err != nil {
+		util.Die("%s", err)
+	}
+	fmt.Println(dir)
+}
+
+// runCacheStats implements 'upm cache stats', for the backends that
+// the given --lang argument value matches, or all of them.
+func runCacheStats(language string, outputFormat outputFormat) {
+	stats := cacheStats{
+		Store:  store.Location(),
+		Caches: store.Stats(backendMatcher(language)),
+	}
+	if info, err := os.Stat(stats.Store); err == nil {
+		stats.Size = info.Size()
+	}
+
+	switch outputFormat {
+	case outputFormatTable:
+		fmt.Printf("%s: %d bytes\n\n", stats.Store, stats.Size)
+		t := table.New("cache", "backend", "entries", "fresh")
+		for _, cache := range stats.Caches {
+			backend := cache.Backend
+			if backend == "" {
+				backend = "(shared)"
+			}
+			fresh := "-"
+			if cache.Fresh != nil {
+				fresh = fmt.Sprint(*cache.Fresh)
+			}
+			t.AddRow(cache.Kind, backend, fmt.Sprint(cache.Entries), fresh)
+		}
+		t.Print()
+
+	case outputFormatJSON:
+		outputB, err := json.Marshal(stats)
+		if err != nil {
+			panic("couldn't marshal json")
+		}
+		fmt.Println(string(outputB))
+
+	default:
+		util.Panicf("unknown output format %d", outputFormat)
+	}
+}
+
+// runCacheClear implements 'upm cache clear', emptying the caches of
+// the given kinds, or all of them, for the backends that the given
+// --lang argument value matches, or all of them. What the store keeps
+// other than caches, such as the packages that 'upm link' swapped
+// out, is left alone.
+func runCacheClear(language string, args []string) {
+	kinds := parseCacheKinds(args)
+	names := []string{}
+	for _, kind := range store.CacheKinds {
+		if kinds[kind] {
+			names = append(names, kind)
+		}
+	}
+	if config.DryRun {
+		fmt.Printf("1. clear the %s caches in %s\n", strings.Join(names, ", "), store.Location())
+		return
+	}
+	util.RefuseIfReadOnly("clear the caches")
+
+	cleared := store.Clear(kinds, backendMatcher(language))
+	store.Write()
+	util.Log(fmt.Sprintf("cleared %d cache entries", cleared))
+}
@@ -2,11 +2,16 @@
 package cli
 
 import (
+	"context"
 	"fmt"
 	"os"
+	"strconv"
+	"time"
 
 	"github.com/replit/upm/internal/backends"
 	"github.com/replit/upm/internal/config"
+	"github.com/replit/upm/internal/policy"
+	"github.com/replit/upm/internal/report"
 	"github.com/replit/upm/internal/util"
 	"github.com/spf13/cobra"
 )
@@ -35,10 +40,68 @@ func getVersion() string {
 	return "upm " + version
 }
 
+// defaultCacheTTL is how long package information is used from the
+// store unless UPM_CACHE_TTL or --cache-ttl says otherwise.
+const defaultCacheTTL = 24 * time.Hour
+
+// getCacheTTL returns the default of --cache-ttl, which is
+// UPM_CACHE_TTL if it is set.
+func getCacheTTL() time.Duration {
+	value := os.Getenv("UPM_CACHE_TTL")
+	if value == "" {
+		return defaultCacheTTL
+	}
+	ttl, err := time.ParseDuration(value)
+	if err != nil {
+		util.Die("UPM_CACHE_TTL: %s", err)
+	}
+	return ttl
+}
+
+// getJobs returns the default of --jobs, which is UPM_JOBS if it is
+// set.
+func getJobs() int {
+	value := os.Getenv("UPM_JOBS")
+	if value == "" {
+		return util.DefaultJobs
+	}
+	jobs, err := strconv.Atoi(value)
+	if err != nil || jobs < 1 {
+		util.Die("UPM_JOBS: must be a positive number, not %q", value)
+	}
+	return jobs
+}
+
+// refuseInReadOnlyMode is the PreRun of every command that modifies
+// the project, so that it fails up front in read-only mode rather than
+// partway through. A dry run is allowed, since it changes nothing.
+func refuseInReadOnlyMode(cmd *cobra.Command, args []string) {
+	if config.ReadOnly && !config.DryRun {
+		util.Die("upm %s modifies the project, so it can't be used in read-only mode", cmd.Name())
+	}
+}
+
+// addReportFlags adds the flags of a command whose report can be
+// posted to a webhook.
+func addReportFlags(cmd *cobra.Command, webhook *string, webhookFormat *string) {
+	cmd.Flags().StringVar(
+		webhook, "webhook", os.Getenv("UPM_WEBHOOK"),
+		"also post the report to this URL",
+	)
+	cmd.Flags().StringVar(
+		webhookFormat, "webhook-format", os.Getenv("UPM_WEBHOOK_FORMAT"),
+		`payload to post ("json" or "slack")`,
+	)
+}
+
 // DoCLI reads the command-line arguments and runs the appropriate
 // code, then exits the process (or returns to indicate normal exit).
 func DoCLI() {
-	backends.SetupAll()
+	util.SetRecording(os.Getenv("UPM_RECORD"), os.Getenv("UPM_REPLAY"))
+
+	// The context of the command that is run, which is cancelled
+	// if UPM is interrupted (see util.HandleSignals).
+	var ctx context.Context
 
 	var language string
 	var formatStr string
@@ -51,12 +114,63 @@ func DoCLI() {
 	var ignoredPaths []string
 	var upgrade bool
 	var name string
+	var editable bool
+	var dev bool
+	var group string
+	var groups []string
+	var commit bool
+	var commitChanges bool
+	var branch string
+	var canary bool
+	var latest bool
+	var lockOnly bool
+	var check bool
+	var forceHook bool
+	var policyFile string
+	var policyKey string
+	var show int
+	var depth int
+	var failOn string
+	var interval time.Duration
+	var webhook string
+	var webhookFormat string
+	var outFile string
+	var templateFile string
+	var unusedTransitives bool
+	var interactive bool
+	var ecosystem string
+	var goFile string
+	var goPackage string
+	var resume bool
+	var restart bool
+	var statsSource string
+	var statsFile string
+	var withDownloads bool
+	var python3 bool
+	var templateFrom string
+	var rateLimit float64
+	var verbose bool
+	var quick bool
+	var mapURL string
 
 	cobra.EnableCommandSorting = false
 
 	rootCmd := &cobra.Command{
 		Use:     "upm",
 		Version: getVersion(),
+		PersistentPreRun: func(cmd *cobra.Command, args []string) {
+			if config.Jobs < 1 {
+				util.Die("--jobs must be at least 1")
+			}
+			if config.MinConfidence < 0 || config.MinConfidence > 1 {
+				util.Die("--min-confidence must be between 0 and 1")
+			}
+			if policyFile != "" {
+				policy.Load(policyFile, policyKey)
+			} else if policyKey != "" {
+				util.Die("a policy key was given without a policy (use --policy)")
+			}
+		},
 	}
 	rootCmd.SetVersionTemplate(`{{.Version}}` + "\n")
 	// Not sorting the root command options because none of the
@@ -69,6 +183,49 @@ func DoCLI() {
 	rootCmd.PersistentFlags().BoolVarP(
 		&config.Quiet, "quiet", "q", false, "don't show what commands are being run",
 	)
+	rootCmd.PersistentFlags().StringVar(
+		&config.Profile, "profile", "", "compose a profile from .upm/config.toml onto the specfile",
+	)
+	rootCmd.PersistentFlags().BoolVar(
+		&config.ReadOnly, "read-only", os.Getenv("UPM_READ_ONLY") != "",
+		"refuse to modify the project (for analysis)",
+	)
+	rootCmd.PersistentFlags().BoolVar(
+		&config.DryRun, "dry-run", false,
+		"print the steps that would change the project instead of running them",
+	)
+	rootCmd.PersistentFlags().BoolVar(
+		&config.Explain, "explain", false,
+		"say why each step that changes the project is needed",
+	)
+	rootCmd.PersistentFlags().BoolVar(
+		&config.ExitCode, "exit-code", false,
+		fmt.Sprintf("exit with status %d if add, remove, or install has nothing to do", nothingToDoStatus),
+	)
+	rootCmd.PersistentFlags().BoolVar(
+		&config.Provenance, "provenance", os.Getenv("UPM_PROVENANCE") != "",
+		"note in the lockfile which version of UPM produced it, when, and where",
+	)
+	rootCmd.PersistentFlags().BoolVar(
+		&config.NoCache, "no-cache", false,
+		"fetch package information from the index rather than the store",
+	)
+	rootCmd.PersistentFlags().DurationVar(
+		&config.CacheTTL, "cache-ttl", getCacheTTL(),
+		"how long to use package information from the store",
+	)
+	rootCmd.PersistentFlags().IntVar(
+		&config.Jobs, "jobs", getJobs(),
+		"how many requests, subprocesses, and file reads to run at once",
+	)
+	rootCmd.PersistentFlags().StringVar(
+		&policyFile, "policy", os.Getenv("UPM_POLICY"),
+		"only run the programs allowed by the given policy file",
+	)
+	rootCmd.PersistentFlags().StringVar(
+		&policyKey, "policy-key", os.Getenv("UPM_POLICY_KEY"),
+		"require the policy to be signed by this Ed25519 public key (base64)",
+	)
 	rootCmd.PersistentFlags().StringSliceVar(
 		&ignoredPackages, "ignored-packages", []string{},
 		"packages to ignore when guessing or adding (comma-separated)",
@@ -84,15 +241,58 @@ func DoCLI() {
 		"version", "v", false, "display command version",
 	)
 
+	cmdVersion := &cobra.Command{
+		Use:   "version",
+		Short: "Print the version of UPM",
+		Long: "Print the version of UPM, and with --verbose, the versions of the " +
+			"tools that its backend for the project runs, the date of the module " +
+			"map, and the version of the store schema",
+		Args: cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			runVersion(ctx, language, verbose)
+		},
+	}
+	cmdVersion.Flags().BoolVar(
+		&verbose, "verbose", false, "also report the versions of the backend's tools and data",
+	)
+	rootCmd.AddCommand(cmdVersion)
+
+	cmdDoctor := &cobra.Command{
+		Use:   "doctor",
+		Short: "Check the environment for problems",
+		Long: "Check that the programs the backend for the project runs are " +
+			"installed, that its registry can be reached, that the store can be " +
+			"written, that the lockfile is up to date, and for problems with the " +
+			"environment such as the wrong virtualenv, and say how to fix them",
+		Args: cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			outputFormat := parseOutputFormat(formatStr)
+			runDoctor(ctx, language, outputFormat)
+		},
+	}
+	cmdDoctor.Flags().SortFlags = false
+	cmdDoctor.Flags().StringVarP(
+		&formatStr, "format", "f", "table", `output format ("table" or "json")`,
+	)
+	rootCmd.AddCommand(cmdDoctor)
+
 	cmdWhichLanguage := &cobra.Command{
 		Use:   "which-language",
 		Short: "Query language autodetection",
 		Long:  "Ask which language your project is autodetected as",
 		Args:  cobra.NoArgs,
 		Run: func(cmd *cobra.Command, args []string) {
+			if quick {
+				runWhichLanguageQuick(language)
+				return
+			}
 			runWhichLanguage(language)
 		},
 	}
+	cmdWhichLanguage.Flags().BoolVar(
+		&quick, "quick", false,
+		"answer from the backend whose files were last listed, saying if they have changed since",
+	)
 	rootCmd.AddCommand(cmdWhichLanguage)
 
 	cmdListLanguages := &cobra.Command{
@@ -105,6 +305,22 @@ func DoCLI() {
 	}
 	rootCmd.AddCommand(cmdListLanguages)
 
+	cmdLanguages := &cobra.Command{
+		Use:   "languages",
+		Short: "Report the languages in each directory of the repository",
+		Long: "List the files and manifests of each language in each directory, " +
+			"and suggest where a manifest is missing for files that no project covers",
+		Args: cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			outputFormat := parseOutputFormat(formatStr)
+			runLanguages(ctx, outputFormat)
+		},
+	}
+	cmdLanguages.Flags().StringVarP(
+		&formatStr, "format", "f", "table", `output format ("table" or "json")`,
+	)
+	rootCmd.AddCommand(cmdLanguages)
+
 	cmdSearch := &cobra.Command{
 		Use:   "search QUERY...",
 		Short: "Search for packages online",
@@ -112,10 +328,14 @@ func DoCLI() {
 		Run: func(cmd *cobra.Command, args []string) {
 			queries := args
 			outputFormat := parseOutputFormat(formatStr)
-			runSearch(language, queries, outputFormat)
+			runSearch(ctx, language, queries, outputFormat)
 		},
 	}
 	cmdSearch.Flags().SortFlags = false
+	cmdSearch.Flags().BoolVar(
+		&config.RemoteSearch, "remote", false,
+		"also search the live registry, for packages newer than upm",
+	)
 	cmdSearch.Flags().StringVarP(
 		&formatStr, "format", "f", "table", `output format ("table" or "json")`,
 	)
@@ -130,22 +350,45 @@ func DoCLI() {
 		Run: func(cmd *cobra.Command, args []string) {
 			pkg := args[0]
 			outputFormat := parseOutputFormat(formatStr)
-			runInfo(language, pkg, outputFormat)
+			runInfo(ctx, language, pkg, withDownloads, outputFormat)
 		},
 	}
 	cmdInfo.Flags().SortFlags = false
+	cmdInfo.Flags().BoolVar(
+		&withDownloads, "downloads", false,
+		"also show how many times the package has been downloaded",
+	)
 	cmdInfo.Flags().StringVarP(
 		&formatStr, "format", "f", "table", `output format ("table" or "json")`,
 	)
 	rootCmd.AddCommand(cmdInfo)
 
 	cmdAdd := &cobra.Command{
-		Use:   `add "PACKAGE[ SPEC]"...`,
-		Short: "Add packages to the specfile",
+		Use:    `add "PACKAGE[ SPEC]"...`,
+		Short:  "Add packages to the specfile",
+		PreRun: refuseInReadOnlyMode,
 		Run: func(cmd *cobra.Command, args []string) {
+			if dev {
+				if group != "" && group != "dev" {
+					util.Die("--dev cannot be combined with --group")
+				}
+				group = "dev"
+			}
+			if editable {
+				if guess {
+					util.Die("--editable cannot be combined with --guess")
+				}
+				if group != "" {
+					util.Die("--editable cannot be combined with --dev or --group")
+				}
+				runAddEditable(ctx, language, args, forceLock, forceInstall, name,
+					isCommitRequested(cmd, commitChanges), branch)
+				return
+			}
 			pkgSpecStrs := args
-			runAdd(language, pkgSpecStrs, upgrade, guess, forceGuess,
-				ignoredPackages, forceLock, forceInstall, name)
+			runAdd(ctx, language, pkgSpecStrs, upgrade, guess, forceGuess,
+				ignoredPackages, forceLock, forceInstall, name, group,
+				isCommitRequested(cmd, commitChanges), branch)
 		},
 	}
 	cmdAdd.Flags().SortFlags = false
@@ -164,18 +407,43 @@ func DoCLI() {
 	cmdAdd.Flags().BoolVar(
 		&forceGuess, "force-guess", false, "bypass cache when guessing dependencies",
 	)
+	cmdAdd.Flags().BoolVar(
+		&config.ChangedOnly, "changed-only", false,
+		"when guessing, only scan the files that changed since the last guess made this way",
+	)
+	cmdAdd.Flags().Float64Var(
+		&config.MinConfidence, "min-confidence", config.DefaultMinConfidence,
+		"only guess packages at least this likely to be right (0 to 1)",
+	)
 	cmdAdd.Flags().StringVarP(
 		&name, "name", "n", "", "specify project name",
 	)
+	cmdAdd.Flags().BoolVarP(
+		&editable, "editable", "e", false, "add local directories as editable packages",
+	)
+	cmdAdd.Flags().BoolVarP(
+		&dev, "dev", "D", false, "add the packages as development dependencies",
+	)
+	cmdAdd.Flags().StringVar(
+		&group, "group", "", "add the packages to this dependency group",
+	)
+	cmdAdd.Flags().BoolVar(
+		&commitChanges, "commit", false, "commit the changed specfile and lockfile with git",
+	)
+	cmdAdd.Flags().StringVar(
+		&branch, "branch", "", "make the commit on a new branch with this name",
+	)
 	rootCmd.AddCommand(cmdAdd)
 
 	cmdRemove := &cobra.Command{
-		Use:   "remove PACKAGE...",
-		Short: "Remove packages from the specfile",
-		Args:  cobra.MinimumNArgs(1),
+		Use:    "remove PACKAGE...",
+		Short:  "Remove packages from the specfile",
+		Args:   cobra.MinimumNArgs(1),
+		PreRun: refuseInReadOnlyMode,
 		Run: func(cmd *cobra.Command, args []string) {
 			pkgs := args
-			runRemove(language, pkgs, upgrade, forceLock, forceInstall)
+			runRemove(ctx, language, pkgs, upgrade, forceLock, forceInstall,
+				unusedTransitives, isCommitRequested(cmd, commitChanges), branch)
 		},
 	}
 	cmdRemove.Flags().SortFlags = false
@@ -188,21 +456,90 @@ func DoCLI() {
 	cmdRemove.Flags().BoolVarP(
 		&forceInstall, "force-install", "F", false, "reinstall packages even if up to date",
 	)
+	cmdRemove.Flags().BoolVar(
+		&unusedTransitives, "unused-transitives", false,
+		"list the dependencies that were removed from the lockfile along with the packages",
+	)
+	cmdRemove.Flags().BoolVar(
+		&commitChanges, "commit", false, "commit the changed specfile and lockfile with git",
+	)
+	cmdRemove.Flags().StringVar(
+		&branch, "branch", "", "make the commit on a new branch with this name",
+	)
 	rootCmd.AddCommand(cmdRemove)
 
-	updateAliases := []string{"update", "upgrade"}
+	cmdLink := &cobra.Command{
+		Use:   "link PATH [PACKAGE]",
+		Short: "Replace a package with a local checkout",
+		Long: "Replace a package in the specfile with an editable install " +
+			"from a local checkout, until 'upm unlink' is run",
+		Args:   cobra.RangeArgs(1, 2),
+		PreRun: refuseInReadOnlyMode,
+		Run: func(cmd *cobra.Command, args []string) {
+			path := args[0]
+			pkg := ""
+			if len(args) >= 2 {
+				pkg = args[1]
+			}
+			runLink(ctx, language, path, pkg, forceLock, forceInstall)
+		},
+	}
+	cmdLink.Flags().SortFlags = false
+	cmdLink.Flags().BoolVarP(
+		&forceLock, "force-lock", "f", false, "rewrite lockfile even if up to date",
+	)
+	cmdLink.Flags().BoolVarP(
+		&forceInstall, "force-install", "F", false, "reinstall packages even if up to date",
+	)
+	rootCmd.AddCommand(cmdLink)
+
+	cmdUnlink := &cobra.Command{
+		Use:    "unlink PACKAGE",
+		Short:  "Restore a package that was replaced with 'upm link'",
+		Args:   cobra.ExactArgs(1),
+		PreRun: refuseInReadOnlyMode,
+		Run: func(cmd *cobra.Command, args []string) {
+			runUnlink(ctx, language, args[0], forceLock, forceInstall)
+		},
+	}
+	cmdUnlink.Flags().SortFlags = false
+	cmdUnlink.Flags().BoolVarP(
+		&forceLock, "force-lock", "f", false, "rewrite lockfile even if up to date",
+	)
+	cmdUnlink.Flags().BoolVarP(
+		&forceInstall, "force-install", "F", false, "reinstall packages even if up to date",
+	)
+	rootCmd.AddCommand(cmdUnlink)
+
+	upgradeAliases := []string{"upgrade"}
 	cmdLock := &cobra.Command{
-		Aliases: updateAliases,
-		Use:     "lock",
+		Aliases: upgradeAliases,
+		Use:     "lock [PACKAGE...]",
 		Short:   "Generate the lockfile from the specfile",
-		Args:    cobra.NoArgs,
+		Long: "Generate the lockfile from the specfile. When upgrading, " +
+			"only the given packages are upgraded, if there are any",
+		// Checking the lockfile doesn't change anything.
+		PreRun: func(cmd *cobra.Command, args []string) {
+			if !check {
+				refuseInReadOnlyMode(cmd, args)
+			}
+		},
 		Run: func(cmd *cobra.Command, args []string) {
-			for _, updateAlias := range updateAliases {
-				if cmd.CalledAs() == updateAlias {
+			if check {
+				if len(args) > 0 {
+					util.Die("packages can't be given with --check")
+				}
+				runCheckLock(language)
+				return
+			}
+			for _, upgradeAlias := range upgradeAliases {
+				if cmd.CalledAs() == upgradeAlias {
 					upgrade = true
 				}
 			}
-			runLock(language, upgrade, forceLock, forceInstall)
+			pkgs := args
+			runLock(ctx, language, upgrade, pkgs, canary, forceLock, forceInstall,
+				isCommitRequested(cmd, commitChanges), branch)
 		},
 	}
 	cmdLock.Flags().SortFlags = false
@@ -215,14 +552,84 @@ func DoCLI() {
 	cmdLock.Flags().BoolVarP(
 		&forceInstall, "force-install", "F", false, "reinstall packages even if up to date",
 	)
+	cmdLock.Flags().BoolVar(
+		&canary, "canary", false, "try the upgrade in a copy of the project first",
+	)
+	cmdLock.Flags().BoolVar(
+		&check, "check", false, "fail if the lockfile is out of date, without changing anything",
+	)
+	cmdLock.Flags().BoolVar(
+		&commitChanges, "commit", false, "commit the changed specfile and lockfile with git",
+	)
+	cmdLock.Flags().StringVar(
+		&branch, "branch", "", "make the commit on a new branch with this name",
+	)
 	rootCmd.AddCommand(cmdLock)
 
+	cmdUpdate := &cobra.Command{
+		Use:   "update [PACKAGE...]",
+		Short: "Raise the specs in the specfile to newer versions",
+		Long: "Raise the specs of the given packages in the specfile, or of every " +
+			"package, to the newest versions that they allow, or with --latest, to " +
+			"the latest versions, and lock and install them. With --lock-only, " +
+			"upgrade within the specs instead, as 'upm upgrade' does",
+		PreRun: refuseInReadOnlyMode,
+		Run: func(cmd *cobra.Command, args []string) {
+			runUpdate(ctx, language, args, latest, lockOnly, forceLock, forceInstall,
+				isCommitRequested(cmd, commitChanges), branch)
+		},
+	}
+	cmdUpdate.Flags().SortFlags = false
+	cmdUpdate.Flags().BoolVar(
+		&latest, "latest", false, "raise the specs to the latest versions, even new major ones",
+	)
+	cmdUpdate.Flags().BoolVar(
+		&lockOnly, "lock-only", false, "leave the specs alone and upgrade within them",
+	)
+	cmdUpdate.Flags().BoolVarP(
+		&forceLock, "force-lock", "f", false, "rewrite lockfile even if up to date",
+	)
+	cmdUpdate.Flags().BoolVarP(
+		&forceInstall, "force-install", "F", false, "reinstall packages even if up to date",
+	)
+	cmdUpdate.Flags().BoolVar(
+		&commitChanges, "commit", false, "commit the changed specfile and lockfile with git",
+	)
+	cmdUpdate.Flags().StringVar(
+		&branch, "branch", "", "make the commit on a new branch with this name",
+	)
+	rootCmd.AddCommand(cmdUpdate)
+
+	cmdExport := &cobra.Command{
+		Use:   "export",
+		Short: "Export the lockfile as a requirements.txt",
+		Long: "Write the packages in the lockfile that the main dependencies " +
+			"need, and those that the given dependency groups need as well, " +
+			"pinned to their locked versions, for deploy targets that can't " +
+			"read the lockfile. The exports listed in .upm/config.toml are " +
+			"written again whenever a command changes the lockfile",
+		Args:   cobra.NoArgs,
+		PreRun: refuseInReadOnlyMode,
+		Run: func(cmd *cobra.Command, args []string) {
+			runExport(ctx, language, groups, outFile)
+		},
+	}
+	cmdExport.Flags().SortFlags = false
+	cmdExport.Flags().StringSliceVarP(
+		&groups, "group", "g", nil, "also export the packages of this dependency group (may be repeated)",
+	)
+	cmdExport.Flags().StringVarP(
+		&outFile, "out", "o", "", "write the export to this file rather than to standard output",
+	)
+	rootCmd.AddCommand(cmdExport)
+
 	cmdInstall := &cobra.Command{
-		Use:   "install",
-		Short: "Install packages from the lockfile",
-		Args:  cobra.NoArgs,
+		Use:    "install",
+		Short:  "Install packages from the lockfile",
+		Args:   cobra.NoArgs,
+		PreRun: refuseInReadOnlyMode,
 		Run: func(cmd *cobra.Command, args []string) {
-			runInstall(language, forceInstall)
+			runInstall(ctx, language, forceInstall)
 		},
 	}
 	cmdInstall.Flags().SortFlags = false
@@ -231,6 +638,136 @@ func DoCLI() {
 	)
 	rootCmd.AddCommand(cmdInstall)
 
+	cmdInit := &cobra.Command{
+		Use:   "init [--from TEMPLATE] [DIR]",
+		Short: "Start a project from a template, with its packages installed",
+		Long: "Copy the template, a local directory or a git repository, into DIR " +
+			"(by default named after it) without its history, detect the language " +
+			"of its project, and lock and install its packages. Without --from, " +
+			"create the specfile of a new project in DIR or the current directory " +
+			"instead, named after the directory, as the package manager of --lang " +
+			"does non-interactively",
+		Args:   cobra.MaximumNArgs(1),
+		PreRun: refuseInReadOnlyMode,
+		Run: func(cmd *cobra.Command, args []string) {
+			dir := ""
+			if len(args) > 0 {
+				dir = args[0]
+			}
+			runInit(ctx, language, templateFrom, dir, name)
+		},
+	}
+	cmdInit.Flags().SortFlags = false
+	cmdInit.Flags().StringVar(
+		&templateFrom, "from", "", "the directory or git URL of the template",
+	)
+	cmdInit.Flags().StringVarP(
+		&name, "name", "n", "", "specify project name, without --from",
+	)
+	rootCmd.AddCommand(cmdInit)
+
+	cmdVerify := &cobra.Command{
+		Use:   "verify",
+		Short: "Check that the installed packages match the lockfile",
+		Long: "List the packages that are installed differently from the lockfile: " +
+			"missing, at another version, installed without UPM (for example " +
+			"with pip directly), or with files changed since they were " +
+			"installed, and fail if there are any",
+		Args: cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			outputFormat := parseOutputFormat(formatStr)
+			runVerify(language, outputFormat)
+		},
+	}
+	cmdVerify.Flags().SortFlags = false
+	cmdVerify.Flags().StringVarP(
+		&formatStr, "format", "f", "table", `output format ("table" or "json")`,
+	)
+	rootCmd.AddCommand(cmdVerify)
+
+	cmdMigrate := &cobra.Command{
+		Use:   "migrate",
+		Short: "Move dependencies from setup.py or setup.cfg to the specfile",
+		Long: "Move dependencies from setup.py or setup.cfg to the specfile, or with " +
+			"--python3, require a supported version of Python and remove the packages " +
+			"that are only required on Python 2, then lock again",
+		Args:   cobra.NoArgs,
+		PreRun: refuseInReadOnlyMode,
+		Run: func(cmd *cobra.Command, args []string) {
+			runMigrate(ctx, language, python3, forceLock, forceInstall, name,
+				isCommitRequested(cmd, commitChanges), branch)
+		},
+	}
+	cmdMigrate.Flags().SortFlags = false
+	cmdMigrate.Flags().BoolVar(
+		&python3, "python3", false, "migrate from Python 2 or an unsupported Python 3 instead",
+	)
+	cmdMigrate.Flags().BoolVarP(
+		&forceLock, "force-lock", "f", false, "rewrite lockfile even if up to date",
+	)
+	cmdMigrate.Flags().BoolVarP(
+		&forceInstall, "force-install", "F", false, "reinstall packages even if up to date",
+	)
+	cmdMigrate.Flags().StringVarP(
+		&name, "name", "n", "", "specify project name",
+	)
+	cmdMigrate.Flags().BoolVar(
+		&commitChanges, "commit", false, "commit the changed specfile and lockfile with git",
+	)
+	cmdMigrate.Flags().StringVar(
+		&branch, "branch", "", "make the commit on a new branch with this name",
+	)
+	rootCmd.AddCommand(cmdMigrate)
+
+	cmdConvert := &cobra.Command{
+		Use:   "convert BACKEND",
+		Short: "Move dependencies to another package manager for the language",
+		Long: "Move the dependencies in the specfile to that of the given backend, " +
+			"such as python-python3-poetry or nodejs-yarn, keeping their version " +
+			"constraints and dependency groups where it supports them, then delete " +
+			"the old lockfile and lock with the new package manager",
+		Args:   cobra.ExactArgs(1),
+		PreRun: refuseInReadOnlyMode,
+		Run: func(cmd *cobra.Command, args []string) {
+			runConvert(ctx, language, args[0], forceLock, forceInstall, name,
+				isCommitRequested(cmd, commitChanges), branch)
+		},
+	}
+	cmdConvert.Flags().SortFlags = false
+	cmdConvert.Flags().BoolVarP(
+		&forceLock, "force-lock", "f", false, "rewrite lockfile even if up to date",
+	)
+	cmdConvert.Flags().BoolVarP(
+		&forceInstall, "force-install", "F", false, "reinstall packages even if up to date",
+	)
+	cmdConvert.Flags().StringVarP(
+		&name, "name", "n", "", "specify project name",
+	)
+	cmdConvert.Flags().BoolVar(
+		&commitChanges, "commit", false, "commit the changed specfile and lockfile with git",
+	)
+	cmdConvert.Flags().StringVar(
+		&branch, "branch", "", "make the commit on a new branch with this name",
+	)
+	rootCmd.AddCommand(cmdConvert)
+
+	cmdPatch := &cobra.Command{
+		Use:   "patch PACKAGE",
+		Short: "Make local changes to an installed package",
+		Long: "Save changes to an installed package as a patch in " +
+			".upm/patches, which is applied again after every install",
+		Args:   cobra.ExactArgs(1),
+		PreRun: refuseInReadOnlyMode,
+		Run: func(cmd *cobra.Command, args []string) {
+			runPatch(ctx, language, args[0], commit)
+		},
+	}
+	cmdPatch.Flags().SortFlags = false
+	cmdPatch.Flags().BoolVarP(
+		&commit, "commit", "c", false, "write the patch for changes made so far",
+	)
+	rootCmd.AddCommand(cmdPatch)
+
 	cmdList := &cobra.Command{
 		Use:   "list",
 		Short: "List packages from the specfile (or lockfile)",
@@ -238,6 +775,10 @@ func DoCLI() {
 		Args:  cobra.NoArgs,
 		Run: func(cmd *cobra.Command, args []string) {
 			outputFormat := parseOutputFormat(formatStr)
+			if quick {
+				runListQuick(language, all, outputFormat)
+				return
+			}
 			runList(language, all, outputFormat)
 		},
 	}
@@ -248,15 +789,26 @@ func DoCLI() {
 	cmdList.Flags().StringVarP(
 		&formatStr, "format", "f", "table", `output format ("table" or "json")`,
 	)
+	cmdList.Flags().BoolVar(
+		&quick, "quick", false,
+		"answer from what was cached when the file was last listed, saying if it has changed since",
+	)
 	rootCmd.AddCommand(cmdList)
 
 	cmdGuess := &cobra.Command{
 		Use:   "guess",
 		Short: "Guess what packages are needed by your project",
 		Args:  cobra.NoArgs,
+		PreRun: func(cmd *cobra.Command, args []string) {
+			if interactive {
+				refuseInReadOnlyMode(cmd, args)
+			}
+		},
 		Run: func(cmd *cobra.Command, args []string) {
 			util.AddIngoredPaths(ignoredPaths)
-			runGuess(language, all, forceGuess, ignoredPackages)
+			outputFormat := parseOutputFormat(formatStr)
+			runGuess(ctx, language, all, forceGuess, ignoredPackages, check,
+				interactive, outputFormat)
 		},
 	}
 	cmdGuess.Flags().SortFlags = false
@@ -266,8 +818,472 @@ func DoCLI() {
 	cmdGuess.Flags().BoolVarP(
 		&forceGuess, "force", "f", false, "bypass cache",
 	)
+	cmdGuess.Flags().BoolVar(
+		&config.ChangedOnly, "changed-only", false,
+		"only scan the files that changed since the last guess made this way",
+	)
+	cmdGuess.Flags().Float64Var(
+		&config.MinConfidence, "min-confidence", config.DefaultMinConfidence,
+		"only guess packages at least this likely to be right (0 to 1)",
+	)
+	cmdGuess.Flags().BoolVar(
+		&check, "check", false, "fail if any packages are missing from the specfile",
+	)
+	cmdGuess.Flags().BoolVarP(
+		&interactive, "interactive", "i", false,
+		"choose which of the guessed packages to add",
+	)
+	cmdGuess.Flags().StringVar(
+		&formatStr, "format", "table", `output format ("table" or "json")`,
+	)
 	rootCmd.AddCommand(cmdGuess)
 
+	cmdInstallGitHooks := &cobra.Command{
+		Use:   "install-git-hooks",
+		Short: "Check the lockfile and imports before every git commit",
+		Long: "Install a git pre-commit hook that runs 'upm lock --check' " +
+			"and 'upm guess --check --changed-only'",
+		Args:   cobra.NoArgs,
+		PreRun: refuseInReadOnlyMode,
+		Run: func(cmd *cobra.Command, args []string) {
+			runInstallGitHooks(ctx, language, ignoredPackages, forceHook)
+		},
+	}
+	cmdInstallGitHooks.Flags().SortFlags = false
+	cmdInstallGitHooks.Flags().BoolVarP(
+		&forceHook, "force", "f", false, "replace a pre-commit hook that UPM didn't install",
+	)
+	rootCmd.AddCommand(cmdInstallGitHooks)
+
+	cmdWatchReleases := &cobra.Command{
+		Use:   "watch-releases",
+		Short: "Report new releases of the packages in the specfile",
+		Long: "List the packages in the specfile that have had new releases " +
+			"since the last time this was run",
+		Args: cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			outputFormat := parseOutputFormat(formatStr)
+			runWatchReleases(ctx, language, interval,
+				report.GetSink(webhook, webhookFormat), outputFormat)
+		},
+	}
+	cmdWatchReleases.Flags().SortFlags = false
+	cmdWatchReleases.Flags().DurationVar(
+		&interval, "interval", 0, "keep checking, this often (e.g. 6h)",
+	)
+	addReportFlags(cmdWatchReleases, &webhook, &webhookFormat)
+	cmdWatchReleases.Flags().StringVarP(
+		&formatStr, "format", "f", "table", `output format ("table" or "json")`,
+	)
+	rootCmd.AddCommand(cmdWatchReleases)
+
+	cmdOutdated := &cobra.Command{
+		Use:   "outdated",
+		Short: "List the packages that have newer versions",
+		Long: "List the packages in the specfile whose locked versions are older than " +
+			"the latest ones in the registry, with the newest versions their specs allow",
+		Args: cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			outputFormat := parseOutputFormat(formatStr)
+			runOutdated(ctx, language, report.GetSink(webhook, webhookFormat), outputFormat)
+		},
+	}
+	cmdOutdated.Flags().SortFlags = false
+	addReportFlags(cmdOutdated, &webhook, &webhookFormat)
+	cmdOutdated.Flags().StringVarP(
+		&formatStr, "format", "f", "table", `output format ("table" or "json")`,
+	)
+	rootCmd.AddCommand(cmdOutdated)
+
+	cmdWhy := &cobra.Command{
+		Use:   "why PACKAGE",
+		Short: "Explain why a package is in the lockfile",
+		Long: "Print the chains of dependencies in the lockfile that lead from " +
+			"the packages in the specfile to a package, the shortest one from each",
+		Args: cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			outputFormat := parseOutputFormat(formatStr)
+			runWhy(language, args[0], outputFormat)
+		},
+	}
+	cmdWhy.Flags().SortFlags = false
+	cmdWhy.Flags().StringVarP(
+		&formatStr, "format", "f", "table", `output format ("table" or "json")`,
+	)
+	rootCmd.AddCommand(cmdWhy)
+
+	cmdTree := &cobra.Command{
+		Use:   "tree",
+		Short: "Print the dependency tree of the lockfile",
+		Long: "Print the packages in the specfile with the packages in the lockfile " +
+			"that they depend on, indented below them",
+		Args: cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			outputFormat := parseOutputFormat(formatStr)
+			runTree(language, depth, outputFormat)
+		},
+	}
+	cmdTree.Flags().SortFlags = false
+	cmdTree.Flags().IntVar(
+		&depth, "depth", 0, "only list dependencies this many levels deep (0 for all)",
+	)
+	cmdTree.Flags().StringVarP(
+		&formatStr, "format", "f", "table", `output format ("table" or "json")`,
+	)
+	rootCmd.AddCommand(cmdTree)
+
+	cmdAudit := &cobra.Command{
+		Use:   "audit",
+		Short: "Check the lockfile for known vulnerabilities",
+		Long: "Look up the packages in the lockfile in the vulnerability database " +
+			"of OSV.dev, and list the vulnerabilities they have, with their " +
+			"severity and the versions that fix them",
+		Args: cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			outputFormat := parseOutputFormat(formatStr)
+			runAudit(ctx, language, failOn, report.GetSink(webhook, webhookFormat), outputFormat)
+		},
+	}
+	cmdAudit.Flags().SortFlags = false
+	cmdAudit.Flags().StringVar(
+		&failOn, "fail-on", "",
+		`fail if a vulnerability is this severe or more ("low", "moderate", "high", "critical", or "none")`,
+	)
+	addReportFlags(cmdAudit, &webhook, &webhookFormat)
+	cmdAudit.Flags().StringVarP(
+		&formatStr, "format", "f", "table", `output format ("table" or "json")`,
+	)
+	rootCmd.AddCommand(cmdAudit)
+
+	cmdLicenses := &cobra.Command{
+		Use:   "licenses",
+		Short: "List the licenses of the packages in the lockfile",
+		Long: "Look up the license of each package in the lockfile, and list " +
+			"the packages grouped by license",
+		Args: cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			runLicenses(ctx, language, formatStr)
+		},
+	}
+	cmdLicenses.Flags().SortFlags = false
+	cmdLicenses.Flags().StringVarP(
+		&formatStr, "format", "f", "table",
+		`output format ("table", "json", or "csv")`,
+	)
+	rootCmd.AddCommand(cmdLicenses)
+
+	cmdHistory := &cobra.Command{
+		Use:   "history",
+		Short: "List changes made to the specfile and lockfile",
+		Long:  "List the changes UPM has made to the specfile and lockfile, or show one of them as a diff",
+		Args:  cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			outputFormat := parseOutputFormat(formatStr)
+			runHistory(show, outputFormat)
+		},
+	}
+	cmdHistory.Flags().SortFlags = false
+	cmdHistory.Flags().IntVar(
+		&show, "show", 0, "print the diff of the change with this number",
+	)
+	cmdHistory.Flags().StringVarP(
+		&formatStr, "format", "f", "table", `output format ("table" or "json")`,
+	)
+	rootCmd.AddCommand(cmdHistory)
+
+	cmdBlame := &cobra.Command{
+		Use:   "blame PACKAGE",
+		Short: "Show which commits added or changed a package",
+		Long: "List the git commits that added, changed, or removed a package " +
+			"in the specfile, with the spec it had after each one",
+		Args: cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			outputFormat := parseOutputFormat(formatStr)
+			runBlame(ctx, language, args[0], outputFormat)
+		},
+	}
+	cmdBlame.Flags().SortFlags = false
+	cmdBlame.Flags().StringVarP(
+		&formatStr, "format", "f", "table", `output format ("table" or "json")`,
+	)
+	rootCmd.AddCommand(cmdBlame)
+
+	cmdReport := &cobra.Command{
+		Use:   "report",
+		Short: "Summarize the packages in the specfile",
+		Long: "List each package in the specfile with its locked version, " +
+			"license, and description, for example as a DEPENDENCIES.md " +
+			"file that is kept in version control",
+		Args: cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			runReport(ctx, language, formatStr, outFile, templateFile, check)
+		},
+	}
+	cmdReport.Flags().SortFlags = false
+	cmdReport.Flags().StringVarP(
+		&formatStr, "format", "f", "table",
+		`output format ("table", "json", or "markdown")`,
+	)
+	cmdReport.Flags().StringVarP(
+		&outFile, "out", "o", "", "write the report to this file",
+	)
+	cmdReport.Flags().StringVar(
+		&templateFile, "template", "",
+		"render markdown with this Go text/template instead",
+	)
+	cmdReport.Flags().BoolVar(
+		&check, "check", false,
+		"fail if the file given by --out is out of date, instead of writing it",
+	)
+	rootCmd.AddCommand(cmdReport)
+
+	cmdSchema := &cobra.Command{
+		Use:   "schema [COMMAND]",
+		Short: "Print the JSON schema of the output of a command",
+		Long: "Print the JSON schema that the output of a command with " +
+			"--format json follows, or list the commands that have one " +
+			"(list-all is 'upm list --all')",
+		Args: cobra.MaximumNArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			name := ""
+			if len(args) > 0 {
+				name = args[0]
+			}
+			runSchema(name)
+		},
+	}
+	rootCmd.AddCommand(cmdSchema)
+
+	cmdPassthru := &cobra.Command{
+		Use:   "passthru -- COMMAND [ARG...]",
+		Short: "Run a package manager command through UPM",
+		Long: "Run a command of pip, poetry, uv, pdm, npm, yarn, or bundle " +
+			"as the equivalent UPM command, such as 'upm add' for " +
+			"'poetry add', so that the specfile, lockfile, and store stay " +
+			"in sync. Commands that UPM has no equivalent of are run as is",
+		Args: cobra.MinimumNArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			runPassthru(ctx, args, ignoredPackages)
+		},
+	}
+	// The flags after the command are its own.
+	cmdPassthru.Flags().SetInterspersed(false)
+	rootCmd.AddCommand(cmdPassthru)
+
+	cmdRun := &cobra.Command{
+		Use:   "run [SCRIPT [ARG...]]",
+		Short: "Run a script that the project defines",
+		Long: "Run one of the scripts that the project defines for its package " +
+			"manager, such as those in package.json, pyproject.toml, or the " +
+			"Rakefile, with the given arguments, or list them if none is given",
+		// A script may do anything, but listing them is read-only.
+		PreRun: func(cmd *cobra.Command, args []string) {
+			if len(args) > 0 {
+				refuseInReadOnlyMode(cmd, args)
+			}
+		},
+		Run: func(cmd *cobra.Command, args []string) {
+			outputFormat := parseOutputFormat(formatStr)
+			runRun(ctx, language, args, outputFormat)
+		},
+	}
+	// The flags after the script are its own.
+	cmdRun.Flags().SetInterspersed(false)
+	cmdRun.Flags().StringVarP(
+		&formatStr, "format", "f", "table", `output format when listing scripts ("table" or "json")`,
+	)
+	rootCmd.AddCommand(cmdRun)
+
+	cmdExec := &cobra.Command{
+		Use:   "exec -- COMMAND [ARG...]",
+		Short: "Run a command in the environment of the project",
+		Long: "Run a command with the packages of the project available to it, " +
+			"as if its environment were activated: with the virtualenv or " +
+			"node_modules/.bin on $PATH, or with bundle exec",
+		Args:   cobra.MinimumNArgs(1),
+		PreRun: refuseInReadOnlyMode,
+		Run: func(cmd *cobra.Command, args []string) {
+			runExec(ctx, language, args)
+		},
+	}
+	// The flags after the command are its own.
+	cmdExec.Flags().SetInterspersed(false)
+	rootCmd.AddCommand(cmdExec)
+
+	cmdAlias := &cobra.Command{
+		Use:   "alias SHELL",
+		Short: "Print shell functions that run package managers through UPM",
+		Long: "Print the definitions of functions for bash, zsh, sh, or " +
+			"fish that run pip, npm, poetry, and the other package managers " +
+			"that 'upm passthru' knows through it, for example with " +
+			"eval \"$(upm alias bash)\"",
+		Args: cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			runAlias(args[0])
+		},
+	}
+	rootCmd.AddCommand(cmdAlias)
+
+	cmdMap := &cobra.Command{
+		Use:     "index",
+		Aliases: []string{"map"},
+		Short:   "Manage the maps from imports to the packages that provide them",
+		Args:    cobra.NoArgs,
+	}
+	rootCmd.AddCommand(cmdMap)
+
+	cmdMapStatus := &cobra.Command{
+		Use:   "status",
+		Short: "Report how fresh the map of each ecosystem is",
+		Long: "Report, for each ecosystem, where its map comes from and when it was " +
+			"generated, how many imports it knows, how often package information " +
+			"was found in the cache, and which service 'upm search' queries",
+		Args: cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			outputFormat := parseOutputFormat(formatStr)
+			runMapStatus(outputFormat)
+		},
+	}
+	cmdMapStatus.Flags().SortFlags = false
+	cmdMapStatus.Flags().StringVarP(
+		&formatStr, "format", "f", "table", `output format ("table" or "json")`,
+	)
+	cmdMap.AddCommand(cmdMapStatus)
+
+	cmdMapUpdate := &cobra.Command{
+		Use:   "update",
+		Short: "Download the latest published module map",
+		Long: "Download the latest module map for guessing Python packages " +
+			"from imports, which is used instead of the one UPM was built " +
+			"with until a newer UPM comes with a newer one",
+		Args: cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			runMapUpdate(ctx, mapURL)
+		},
+	}
+	cmdMapUpdate.Flags().SortFlags = false
+	cmdMapUpdate.Flags().StringVar(
+		&mapURL, "url", defaultMapURL(), "download the map from this URL",
+	)
+	cmdMap.AddCommand(cmdMapUpdate)
+
+	cmdCache := &cobra.Command{
+		Use:   "cache",
+		Short: "Inspect and clear the caches in the store",
+		Args:  cobra.NoArgs,
+	}
+	rootCmd.AddCommand(cmdCache)
+
+	cmdCacheDir := &cobra.Command{
+		Use:   "dir",
+		Short: "Print the directory of the store",
+		Args:  cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			runCacheDir()
+		},
+	}
+	cmdCache.AddCommand(cmdCacheDir)
+
+	cmdCacheStats := &cobra.Command{
+		Use:   "stats",
+		Short: "Report how much each cache holds",
+		Long: "Report how many entries each cache in the store holds for each " +
+			"backend, and how many of those that expire are still fresh",
+		Args: cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			outputFormat := parseOutputFormat(formatStr)
+			runCacheStats(language, outputFormat)
+		},
+	}
+	cmdCacheStats.Flags().SortFlags = false
+	cmdCacheStats.Flags().StringVarP(
+		&formatStr, "format", "f", "table", `output format ("table" or "json")`,
+	)
+	cmdCache.AddCommand(cmdCacheStats)
+
+	cmdCacheClear := &cobra.Command{
+		Use:   "clear [CACHE...]",
+		Short: "Clear the caches",
+		Long: "Clear the given caches (info, licenses, guess, or listing), or all " +
+			"of them, for the backends that --lang matches, or all of them. The " +
+			"info cache is shared, so it is cleared whatever --lang says",
+		Run: func(cmd *cobra.Command, args []string) {
+			runCacheClear(language, args)
+		},
+	}
+	cmdCache.AddCommand(cmdCacheClear)
+
+	cmdAdmin := &cobra.Command{
+		Use:   "admin",
+		Short: "Maintain the data that UPM is built with",
+		Args:  cobra.NoArgs,
+	}
+	rootCmd.AddCommand(cmdAdmin)
+
+	cmdGenMap := &cobra.Command{
+		Use:   "gen-map",
+		Short: "Build the map from modules to the packages that provide them",
+		Long: "Fetch the modules that every package in the package index " +
+			"provides, for guessing packages from imports, and optionally " +
+			"generate the Go source of the map from them. The package index " +
+			"is the one configured for the project, so that a private " +
+			"index gets its own map. Only the packages with new releases " +
+			"since the last run are downloaded again. For npm, which packages " +
+			"the specifiers of imports name, how often they are downloaded, " +
+			"and which need their type declarations from DefinitelyTyped " +
+			"are fetched from the public registry instead, and for rubygems, " +
+			"the paths that the files of each gem can be required by",
+		Args: cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			if defaults, ok := genMapDefaults[ecosystem]; ok {
+				if !cmd.Flags().Changed("out") {
+					outFile = defaults.out
+				}
+				if !cmd.Flags().Changed("go-package") {
+					goPackage = defaults.goPackage
+				}
+			}
+			runGenMap(ecosystem, outFile, goFile, goPackage, resume, restart, statsSource, statsFile,
+				rateLimit, verbose)
+		},
+	}
+	cmdGenMap.Flags().SortFlags = false
+	cmdGenMap.Flags().StringVar(
+		&ecosystem, "ecosystem", "pypi", `package index to crawl ("pypi", "npm", or "rubygems")`,
+	)
+	cmdGenMap.Flags().StringVarP(
+		&outFile, "out", "o", "",
+		"write what was found about each package to this file, one JSON object per line "+
+			"(default pypi_packages.json, npm_packages.json for npm, or gem_packages.json for rubygems)",
+	)
+	cmdGenMap.Flags().StringVar(
+		&goFile, "go", "", "also generate the Go source of the map in this file",
+	)
+	cmdGenMap.Flags().StringVar(
+		&goPackage, "go-package", "", "Go package of the file given by --go (default python, nodejs for npm, or ruby for rubygems)",
+	)
+	cmdGenMap.Flags().BoolVar(
+		&resume, "resume", false, "pick up where an interrupted run left off",
+	)
+	cmdGenMap.Flags().BoolVar(
+		&restart, "restart", false, "download every package again, ignoring the last run",
+	)
+	cmdGenMap.Flags().StringVar(
+		&statsSource, "stats", "entries",
+		`where the download counts that rank packages come from ("entries", "pypistats", or "bigquery")`,
+	)
+	cmdGenMap.Flags().StringVar(
+		&statsFile, "stats-file", "", "file of download counts exported from BigQuery, for --stats bigquery",
+	)
+	cmdGenMap.Flags().Float64Var(
+		&rateLimit, "rate-limit", 0,
+		"send at most this many requests a second to any one host (default no limit; see --jobs)",
+	)
+	cmdGenMap.Flags().BoolVar(
+		&verbose, "verbose", false, "say what was found for each package (pypi only)",
+	)
+	cmdAdmin.AddCommand(cmdGenMap)
+
 	cmdShowSpecfile := &cobra.Command{
 		Use:   "show-specfile",
 		Short: "Print the filename of the specfile",
@@ -283,9 +1299,18 @@ func DoCLI() {
 		Short: "Print the filename of the lockfile",
 		Args:  cobra.NoArgs,
 		Run: func(cmd *cobra.Command, args []string) {
+			if all {
+				runShowLockfileAll(language)
+				return
+			}
 			runShowLockfile(language)
 		},
 	}
+	cmdShowLockfile.Flags().SortFlags = false
+	cmdShowLockfile.Flags().BoolVarP(
+		&all, "all", "a", false,
+		"list every flavor and export of the lockfile, and which one is authoritative",
+	)
 	rootCmd.AddCommand(cmdShowLockfile)
 
 	cmdShowPackageDir := &cobra.Command{
@@ -320,5 +1345,12 @@ func DoCLI() {
 	}
 
 	util.ChdirToUPM()
-	rootCmd.Execute()
+	// After changing directory, so that specfiles named after
+	// the project are found in it.
+	backends.SetupAll()
+	ctx = util.HandleSignals()
+	defer util.CleanupTemp()
+	if err := util.Cancellable(func() { rootCmd.Execute() }); err != nil {
+		util.Die("%s", err)
+	}
 }
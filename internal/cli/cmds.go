@@ -1,16 +1,25 @@
 package cli
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"os"
+	"path/filepath"
 	"reflect"
 	"sort"
+	"strconv"
 	"strings"
 
+	"github.com/kballard/go-shellquote"
+	"golang.org/x/crypto/ssh/terminal"
+
 	"github.com/replit/upm/internal/api"
 	"github.com/replit/upm/internal/backends"
 	"github.com/replit/upm/internal/config"
+	"github.com/replit/upm/internal/guess"
+	"github.com/replit/upm/internal/history"
+	"github.com/replit/upm/internal/patches"
 	"github.com/replit/upm/internal/store"
 	"github.com/replit/upm/internal/table"
 	"github.com/replit/upm/internal/util"
@@ -51,8 +60,49 @@ func runListLanguages() {
 	}
 }
 
+// runLanguages implements 'upm languages'.
+func runLanguages(ctx context.Context, outputFormat outputFormat) {
+	dirs, suggestions := backends.ScanLanguages(ctx)
+	switch outputFormat {
+	case outputFormatTable:
+		if len(dirs) == 0 {
+			util.Log("no files of any language found")
+			return
+		}
+		t := table.New("directory", "language", "files", "manifests", "project")
+		for _, dir := range dirs {
+			project := dir.Project
+			if project == "" {
+				project = "-"
+			}
+			t.AddRow(dir.Dir, dir.Language, strconv.Itoa(dir.Files),
+				strings.Join(dir.Manifests, ", "), project)
+		}
+		t.Print()
+		if len(suggestions) > 0 {
+			fmt.Println()
+		}
+		for _, suggestion := range suggestions {
+			fmt.Println(suggestion)
+		}
+
+	case outputFormatJSON:
+		outputB, err := json.Marshal(struct {
+			Directories []backends.DirLanguage `json:"directories"`
+			Suggestions []backends.Suggestion  `json:"suggestions"`
+		}{dirs, suggestions})
+		if err != nil {
+			panic("couldn't marshal json")
+		}
+		fmt.Println(string(outputB))
+
+	default:
+		util.Panicf("unknown output format %d", outputFormat)
+	}
+}
+
 // runSearch implements 'upm search'.
-func runSearch(language string, args []string, outputFormat outputFormat) {
+func runSearch(ctx context.Context, language string, args []string, outputFormat outputFormat) {
 	query := strings.Join(args, " ")
 	b := backends.GetBackend(language)
 
@@ -60,13 +110,17 @@ func runSearch(language string, args []string, outputFormat outputFormat) {
 	if strings.TrimSpace(query) == "" {
 		results = []api.PkgInfo{}
 	} else {
-		results = b.Search(query)
+		results = b.Search(ctx, query)
 	}
+	store.Write()
 
 	// Output a reasonable number of results.
 	if len(results) > 20 {
 		results = results[:20]
 	}
+	for i := range results {
+		sortPkgInfo(&results[i])
+	}
 
 	switch outputFormat {
 	case outputFormatTable:
@@ -92,13 +146,27 @@ type infoLine struct {
 	Value string
 }
 
-// runInfo implements 'upm info'.
-func runInfo(language string, pkg string, outputFormat outputFormat) {
+// runInfo implements 'upm info'. With withDownloads, the download
+// count of the package is shown too.
+func runInfo(ctx context.Context, language string, pkg string, withDownloads bool, outputFormat outputFormat) {
 	b := backends.GetBackend(language)
-	info := b.Info(api.PkgName(pkg))
+	if withDownloads && b.Downloads == nil {
+		util.Die("%s does not support download counts", b.Name)
+	}
+	info := b.Info(ctx, api.PkgName(pkg))
+	store.Write()
 	if info.Name == "" {
 		util.Die("no such package: %s", pkg)
 	}
+	if withDownloads {
+		if downloads, ok := b.Downloads(api.PkgName(info.Name)); ok {
+			info.Downloads = downloads.Count
+			info.DownloadsDate = downloads.Date
+		} else {
+			util.Log("no download count for " + info.Name)
+		}
+	}
+	sortPkgInfo(&info)
 
 	switch outputFormat {
 	case outputFormatTable:
@@ -111,6 +179,10 @@ func runInfo(language string, pkg string, outputFormat outputFormat) {
 			switch infoV.Field(i).Kind() {
 			case reflect.String:
 				value = infoV.Field(i).String()
+			case reflect.Int, reflect.Int64:
+				if n := infoV.Field(i).Int(); n != 0 {
+					value = strconv.FormatInt(n, 10)
+				}
 			case reflect.Slice:
 				parts := []string{}
 				length := infoV.Field(i).Len()
@@ -156,51 +228,12 @@ func runInfo(language string, pkg string, outputFormat outputFormat) {
 	}
 }
 
-// deleteLockfile deletes the project's lockfile, if one exists.
-func deleteLockfile(b api.LanguageBackend) {
-	if util.Exists(b.Lockfile) {
-		util.ProgressMsg("delete " + b.Lockfile)
-		os.Remove(b.Lockfile)
-	}
-}
-
-// maybeLock either runs lock or not, depending on the backend, store,
-// and command-line options. It returns true if it actually ran lock.
-func maybeLock(b api.LanguageBackend, forceLock bool) bool {
-	if b.QuirksIsNotReproducible() {
-		return false
-	}
-
-	if !util.Exists(b.Specfile) {
-		return false
-	}
-
-	if forceLock || !util.Exists(b.Lockfile) || store.HasSpecfileChanged(b) {
-		b.Lock()
-		return true
-	}
-
-	return false
-}
-
-// maybeInstall either runs install or not, depending on the backend,
-// store, and command-line options.
-func maybeInstall(b api.LanguageBackend, forceInstall bool) {
-	if b.QuirksIsReproducible() {
-		if !util.Exists(b.Lockfile) {
-			return
-		}
-		if forceInstall || store.HasLockfileChanged(b) {
-			b.Install()
-		}
-	} else {
-		if !util.Exists(b.Specfile) {
-			return
-		}
-		if forceInstall || store.HasSpecfileChanged(b) {
-			b.Install()
-		}
-	}
+// maybeInstall installs packages now if it is needed, according to
+// the backend, store, and command-line options.
+func maybeInstall(ctx context.Context, b api.LanguageBackend, forceInstall bool) {
+	p := newPlan(b)
+	p.install(ctx, forceInstall)
+	p.run(ctx)
 }
 
 // pkgNameAndSpec is a tuple of a PkgName and a PkgSpec. It's used to
@@ -208,15 +241,25 @@ func maybeInstall(b api.LanguageBackend, forceInstall bool) {
 type pkgNameAndSpec struct {
 	name api.PkgName
 	spec api.PkgSpec
+	// True if the package was guessed and a pragma says that it
+	// is only needed for development.
+	dev bool
 }
 
 // runAdd implements 'upm add'.
-func runAdd(
+func runAdd(ctx context.Context,
 	language string, args []string, upgrade bool,
-	guess bool, forceGuess bool, ignoredPackages []string,
-	forceLock bool, forceInstall bool, name string) {
+	addGuessed bool, forceGuess bool, ignoredPackages []string,
+	forceLock bool, forceInstall bool, name string, group string,
+	commit bool, branch string) {
 
 	b := backends.GetBackend(language)
+	if group != "" && b.AddToGroup == nil {
+		util.Die("%s does not support dependency groups", b.Name)
+	}
+	checkNotLegacy(b)
+	checkRuntime(b)
+	c := startCommit(ctx, commit, branch)
 
 	// Map from normalized package names to the corresponding
 	// original package names and specs.
@@ -235,8 +278,16 @@ func runAdd(
 		}
 	}
 
-	if guess {
-		guessed := store.GuessWithCache(b, forceGuess)
+	// Adding a package by hand means it is wanted after all, if it
+	// was declined when it was guessed.
+	explicit := map[api.PkgName]bool{}
+	for _, nameAndSpec := range normPkgs {
+		explicit[nameAndSpec.name] = true
+	}
+	store.ClearDeclined(b, explicit)
+
+	if addGuessed {
+		guessed := guess.Details(ctx, b, forceGuess)
 
 		// Map from normalized package names to original
 		// names.
@@ -248,12 +299,16 @@ func runAdd(
 		for _, pkg := range ignoredPackages {
 			delete(guessedNorm, b.NormalizePackageName(api.PkgName(pkg)))
 		}
+		for norm := range store.GetDeclined(b) {
+			delete(guessedNorm, norm)
+		}
 
-		for name, _ := range guessed {
-			if _, ok := normPkgs[b.NormalizePackageName(name)]; !ok {
-				normPkgs[b.NormalizePackageName(name)] = pkgNameAndSpec{
+		for norm, name := range guessedNorm {
+			if _, ok := normPkgs[norm]; !ok {
+				normPkgs[norm] = pkgNameAndSpec{
 					name: name,
-					spec: "",
+					spec: guessed[name].Spec,
+					dev:  guessed[name].Dev,
 				}
 			}
 		}
@@ -261,46 +316,231 @@ func runAdd(
 
 	if util.Exists(b.Specfile) {
 		s := silenceSubroutines()
-		for name, _ := range b.ListSpecfile() {
-			delete(normPkgs, b.NormalizePackageName(name))
-		}
+		specfilePkgs := b.ListSpecfile()
 		s.restore()
+		for name := range specfilePkgs {
+			norm := b.NormalizePackageName(name)
+			if nameAndSpec, ok := normPkgs[norm]; ok && explicit[nameAndSpec.name] {
+				util.Log(fmt.Sprintf("%s is already in %s", nameAndSpec.name, b.Specfile))
+			}
+			delete(normPkgs, norm)
+		}
 	}
 
+	p := newPlan(b)
 	if upgrade {
-		deleteLockfile(b)
+		p.deleteLockfile("--upgrade was given")
 	}
 
-	if len(normPkgs) >= 1 {
-		pkgs := map[api.PkgName]api.PkgSpec{}
-		for _, nameAndSpec := range normPkgs {
+	// Guessed packages that a pragma marks as dev go to the dev
+	// group, unless every package is going to a group anyway.
+	pkgs := map[api.PkgName]api.PkgSpec{}
+	devPkgs := map[api.PkgName]api.PkgSpec{}
+	for _, nameAndSpec := range normPkgs {
+		if nameAndSpec.dev && group == "" && b.AddToGroup != nil {
+			devPkgs[nameAndSpec.name] = nameAndSpec.spec
+		} else {
+			if nameAndSpec.dev && group == "" {
+				util.Log(fmt.Sprintf(
+					"warning: %s is only needed for development, but %s "+
+						"does not support dependency groups, so it is added "+
+						"as a regular dependency", nameAndSpec.name, b.Name,
+				))
+			}
 			pkgs[nameAndSpec.name] = nameAndSpec.spec
 		}
+	}
+	if len(pkgs) >= 1 && group != "" {
+		p.change("add "+formatPkgs(pkgs)+" to group "+group, func() {
+			b.AddToGroup(ctx, pkgs, name, group)
+		})
+	} else if len(pkgs) >= 1 {
+		p.change("add "+formatPkgs(pkgs), func() {
+			b.Add(ctx, pkgs, name)
+		})
+	}
+	if len(devPkgs) >= 1 {
+		p.change("add "+formatPkgs(devPkgs)+" to group dev", func() {
+			b.AddToGroup(ctx, devPkgs, name, "dev")
+		})
+	}
+	added := len(pkgs)+len(devPkgs) >= 1
+	p.lockAndInstallAfterChange(ctx, added, forceLock, forceInstall)
+
+	h := p.execute(ctx)
+	allPkgs := map[api.PkgName]api.PkgSpec{}
+	for _, pkgs := range []map[api.PkgName]api.PkgSpec{pkgs, devPkgs} {
+		for pkg, spec := range pkgs {
+			allPkgs[pkg] = spec
+		}
+	}
+	c.finish(ctx, h, commitMessage("add", allPkgs))
+	p.finishIfEmpty("")
+}
 
-		b.Add(pkgs, name)
+// listSpecfileNormalized returns a map from the normalized names of
+// the packages in the specfile to their original names. The specfile
+// need not exist.
+func listSpecfileNormalized(b api.LanguageBackend) map[api.PkgName]api.PkgName {
+	pkgs := map[api.PkgName]api.PkgName{}
+	if !util.Exists(b.Specfile) {
+		return pkgs
+	}
+	s := silenceSubroutines()
+	for name := range b.ListSpecfile() {
+		pkgs[b.NormalizePackageName(name)] = name
 	}
+	s.restore()
+	return pkgs
+}
 
-	if len(normPkgs) == 0 || b.QuirksDoesAddRemoveNotAlsoLock() {
-		didLock := maybeLock(b, forceLock)
+// addEditable adds the package in the given local directory as an
+// editable dependency, records it in the store, and returns the names
+// of the packages that were added to the specfile as a result.
+func addEditable(ctx context.Context, b api.LanguageBackend, path string, projectName string) []api.PkgName {
+	// The package manager decides what the package is called, so
+	// find out by seeing what shows up in the specfile.
+	before := listSpecfileNormalized(b)
+	b.AddEditable(ctx, path, projectName)
+	added := []api.PkgName{}
+	for norm, pkg := range listSpecfileNormalized(b) {
+		if _, ok := before[norm]; !ok {
+			store.AddEditable(b, pkg, path)
+			added = append(added, pkg)
+		}
+	}
+	return added
+}
 
-		if !(didLock && b.QuirksDoesLockAlsoInstall()) {
-			maybeInstall(b, forceInstall)
+// runAddEditable implements 'upm add --editable'.
+func runAddEditable(ctx context.Context, language string, paths []string, forceLock bool, forceInstall bool,
+	name string, commit bool, branch string) {
+
+	b := backends.GetBackend(language)
+	if b.AddEditable == nil {
+		util.Die("%s does not support editable installs", b.Name)
+	}
+	c := startCommit(ctx, commit, branch)
+
+	for _, path := range paths {
+		if !util.Exists(path) {
+			util.Die("%s: no such file or directory", path)
 		}
-	} else if len(normPkgs) == 0 || b.QuirksDoesAddRemoveNotAlsoInstall() {
-		maybeInstall(b, forceInstall)
 	}
 
-	store.UpdateFileHashes(b)
-	store.Write()
+	p := newPlan(b)
+	added := map[api.PkgName]api.PkgSpec{}
+	for _, path := range paths {
+		path := path
+		p.change("add --editable "+path, func() {
+			for _, pkg := range addEditable(ctx, b, path, name) {
+				added[pkg] = ""
+			}
+		})
+	}
+	p.lockAndInstallAfterChange(ctx, len(paths) >= 1, forceLock, forceInstall)
+
+	h := p.execute(ctx)
+	c.finish(ctx, h, commitMessage("add", added))
+}
+
+// runLink implements 'upm link'. The package defaults to the one
+// named after the last component of the path.
+func runLink(ctx context.Context, language string, path string, pkg string, forceLock bool, forceInstall bool) {
+	b := backends.GetBackend(language)
+	if b.AddEditable == nil {
+		util.Die("%s does not support editable installs", b.Name)
+	}
+	if !util.Exists(b.Specfile) {
+		util.Die("no specfile")
+	}
+	if !util.Exists(path) {
+		util.Die("%s: no such file or directory", path)
+	}
+
+	if pkg == "" {
+		abs, err := filepath.Abs(path)
+		if err != nil {
+			util.Die("%s: %s", path, err)
+		}
+		pkg = filepath.Base(abs)
+	}
+
+	norm := b.NormalizePackageName(api.PkgName(pkg))
+	if _, ok := store.GetLinks(b)[norm]; ok {
+		util.Die("%s is already linked; run 'upm unlink %s' first", pkg, pkg)
+	}
+
+	s := silenceSubroutines()
+	var name api.PkgName
+	var spec api.PkgSpec
+	found := false
+	for specName, specSpec := range b.ListSpecfile() {
+		if b.NormalizePackageName(specName) == norm {
+			name, spec, found = specName, specSpec, true
+		}
+	}
+	s.restore()
+	if !found {
+		util.Die("%s is not in the specfile", pkg)
+	}
+
+	p := newPlan(b)
+	p.change("remove "+string(name), func() {
+		b.Remove(ctx, map[api.PkgName]bool{name: true})
+		store.ClearEditable(b, map[api.PkgName]bool{name: true})
+	})
+	p.change("add --editable "+path, func() {
+		for _, added := range addEditable(ctx, b, path, "") {
+			store.AddLink(b, added, store.Link{
+				Path:         path,
+				OriginalName: name,
+				OriginalSpec: spec,
+			})
+		}
+	})
+	p.lockAndInstallAfterChange(ctx, true, forceLock, forceInstall)
+
+	p.execute(ctx)
+}
+
+// runUnlink implements 'upm unlink'.
+func runUnlink(ctx context.Context, language string, pkg string, forceLock bool, forceInstall bool) {
+	b := backends.GetBackend(language)
+
+	norm := b.NormalizePackageName(api.PkgName(pkg))
+	link, ok := store.GetLinks(b)[norm]
+	if !ok {
+		util.Die("%s is not linked", pkg)
+	}
+
+	p := newPlan(b)
+	if name, ok := listSpecfileNormalized(b)[norm]; ok {
+		p.change("remove "+string(name), func() {
+			b.Remove(ctx, map[api.PkgName]bool{name: true})
+		})
+	}
+	original := map[api.PkgName]api.PkgSpec{link.OriginalName: link.OriginalSpec}
+	p.change("add "+formatPkgs(original), func() {
+		store.ClearEditable(b, map[api.PkgName]bool{api.PkgName(pkg): true})
+		store.ClearLink(b, api.PkgName(pkg))
+		b.Add(ctx, original, "")
+	})
+	p.lockAndInstallAfterChange(ctx, true, forceLock, forceInstall)
+
+	p.execute(ctx)
 }
 
 // runRemove implements 'upm remove'.
-func runRemove(language string, args []string, upgrade bool,
-	forceLock bool, forceInstall bool) {
+func runRemove(ctx context.Context, language string, args []string, upgrade bool,
+	forceLock bool, forceInstall bool, unusedTransitives bool,
+	commit bool, branch string) {
 
 	b := backends.GetBackend(language)
+	c := startCommit(ctx, commit, branch)
 
 	if !util.Exists(b.Specfile) {
+		newPlan(b).finishIfEmpty("there is no " + b.Specfile)
 		return
 	}
 
@@ -322,68 +562,264 @@ func runRemove(language string, args []string, upgrade bool,
 		norm := b.NormalizePackageName(name)
 		if _, ok := normSpecfilePkgs[norm]; ok {
 			normPkgs[norm] = name
+		} else {
+			util.Log(fmt.Sprintf("%s isn't in %s", name, b.Specfile))
 		}
 	}
 
+	p := newPlan(b)
 	if upgrade {
-		deleteLockfile(b)
+		p.deleteLockfile("--upgrade was given")
 	}
 
+	removed := map[api.PkgName]api.PkgSpec{}
 	if len(normPkgs) >= 1 {
 		pkgs := map[api.PkgName]bool{}
 		for _, name := range normPkgs {
 			pkgs[name] = true
+			removed[name] = ""
 		}
-		b.Remove(pkgs)
+		p.change("remove "+formatNames(pkgs), func() {
+			b.Remove(ctx, pkgs)
+			store.ClearEditable(b, pkgs)
+			for name := range pkgs {
+				store.ClearLink(b, name)
+			}
+		})
 	}
+	p.lockAndInstallAfterChange(ctx, len(normPkgs) >= 1, forceLock, forceInstall)
+
+	before := listLockedPkgs(b)
+	h := p.execute(ctx)
+	if h != nil {
+		names := map[api.PkgName]bool{}
+		for name := range removed {
+			names[name] = true
+		}
+		checkRemoval(b, names, before, listLockedPkgs(b), unusedTransitives)
+	}
+	c.finish(ctx, h, commitMessage("remove", removed))
+	p.finishIfEmpty("")
+}
 
-	if len(normPkgs) == 0 || b.QuirksDoesAddRemoveNotAlsoLock() {
-		didLock := maybeLock(b, forceLock)
-
-		if !(didLock && b.QuirksDoesLockAlsoInstall()) {
-			maybeInstall(b, forceInstall)
+// planLock returns the plan of 'upm lock'. If upgrade is true, then
+// the given packages, or all of them if there are none, are upgraded
+// to the latest versions allowed by the specfile.
+func planLock(ctx context.Context, b api.LanguageBackend, upgrade bool, pkgs []string, forceLock bool, forceInstall bool) *plan {
+	p := newPlan(b)
+	if upgrade && len(pkgs) >= 1 {
+		names := map[api.PkgName]bool{}
+		for _, pkg := range pkgs {
+			names[api.PkgName(pkg)] = true
 		}
-	} else if len(normPkgs) == 0 || b.QuirksDoesAddRemoveNotAlsoInstall() {
-		maybeInstall(b, forceInstall)
+		p.upgrade(ctx, names, forceInstall)
+		return p
 	}
 
-	store.UpdateFileHashes(b)
-	store.Write()
+	if upgrade {
+		p.deleteLockfile("every package is being upgraded")
+	}
+	p.lockAndInstall(ctx, forceLock, forceInstall)
+	return p
 }
 
-// runLock implements 'upm lock'.
-func runLock(language string, upgrade bool, forceLock bool, forceInstall bool) {
+// runLock implements 'upm lock' (and so 'upm upgrade'). With canary,
+// the lock is done in a clone of the project first; see runCanary.
+func runLock(ctx context.Context, language string, upgrade bool, pkgs []string, canary bool,
+	forceLock bool, forceInstall bool, commit bool, branch string) {
+
 	b := backends.GetBackend(language)
 
+	if len(pkgs) >= 1 && !upgrade {
+		util.Die("packages can only be given when upgrading")
+	}
+	if len(pkgs) >= 1 && b.Upgrade == nil {
+		util.Die("%s can only upgrade every package at once", b.Name)
+	}
+	if canary && !upgrade {
+		util.Die("--canary can only be used when upgrading")
+	}
+	checkRuntime(b)
+	c := startCommit(ctx, commit, branch)
+
+	p := planLock(ctx, b, upgrade, pkgs, forceLock, forceInstall)
+	var h *history.Recording
+	if canary {
+		h = runCanary(ctx, p, forceInstall)
+	} else {
+		h = p.execute(ctx)
+	}
+
+	operation := "lock"
+	upgraded := map[api.PkgName]api.PkgSpec{}
 	if upgrade {
-		deleteLockfile(b)
+		operation = "upgrade"
+		for _, pkg := range pkgs {
+			upgraded[api.PkgName(pkg)] = ""
+		}
 	}
+	c.finish(ctx, h, commitMessage(operation, upgraded))
+}
 
-	didLock := maybeLock(b, forceLock)
+// runUpdate implements 'upm update'. With lockOnly, it is 'upm
+// upgrade', which leaves the specs alone.
+func runUpdate(ctx context.Context, language string, pkgs []string, latest bool, lockOnly bool,
+	forceLock bool, forceInstall bool, commit bool, branch string) {
 
-	if !(didLock && b.QuirksDoesLockAlsoInstall()) {
-		maybeInstall(b, forceInstall)
+	if lockOnly {
+		if latest {
+			util.Die("--latest can't be used with --lock-only, since the specs limit the versions")
+		}
+		runLock(ctx, language, true, pkgs, false, forceLock, forceInstall, commit, branch)
+		return
 	}
 
-	store.UpdateFileHashes(b)
-	store.Write()
+	b := backends.GetBackend(language)
+	if b.Update == nil {
+		util.Die("%s can't raise the specs in %s (use --lock-only to upgrade within them)",
+			b.Name, b.Specfile)
+	}
+	if !util.Exists(b.Specfile) {
+		newPlan(b).finishIfEmpty("there is no " + b.Specfile)
+		return
+	}
+	normalized := listSpecfileNormalized(b)
+	names := map[api.PkgName]bool{}
+	for _, pkg := range pkgs {
+		name, ok := normalized[b.NormalizePackageName(api.PkgName(pkg))]
+		if !ok {
+			util.Die("%s isn't in %s", pkg, b.Specfile)
+		}
+		names[name] = true
+	}
+	checkRuntime(b)
+	c := startCommit(ctx, commit, branch)
+
+	summary := "raise the specs of every package"
+	if len(names) > 0 {
+		summary = "raise the specs of " + formatNames(names)
+	}
+	if latest {
+		summary += " to the latest versions"
+	}
+	p := newPlan(b)
+	p.change(summary, func() {
+		b.Update(ctx, names, latest)
+	})
+	p.lockAndInstallAfterChange(ctx, true, forceLock, forceInstall)
+	h := p.execute(ctx)
+
+	updated := map[api.PkgName]api.PkgSpec{}
+	for name := range names {
+		updated[name] = ""
+	}
+	c.finish(ctx, h, commitMessage("update", updated))
+}
+
+// runCheckLock implements 'upm lock --check'. It changes nothing, but
+// exits with an error if the lockfile is missing, doesn't have every
+// package from the specfile, or hasn't been generated again since the
+// specfile was last changed.
+func runCheckLock(language string) {
+	b := backends.GetBackend(language)
+	if !util.Exists(b.Specfile) {
+		return
+	}
+	specfilePkgs := b.ListSpecfile()
+	if len(specfilePkgs) == 0 {
+		return
+	}
+	if !util.Exists(b.Lockfile) {
+		util.Die("%s is missing (run 'upm lock')", b.Lockfile)
+	}
+
+	locked := map[api.PkgName]bool{}
+	for name := range b.ListLockfile() {
+		locked[b.NormalizePackageName(name)] = true
+	}
+	missing := []string{}
+	for name := range specfilePkgs {
+		if !locked[b.NormalizePackageName(name)] {
+			missing = append(missing, string(name))
+		}
+	}
+	sort.Strings(missing)
+	if len(missing) > 0 {
+		util.Die("%s is out of date: %s not locked (run 'upm lock')",
+			b.Lockfile, strings.Join(missing, ", "))
+	}
+
+	if store.IsLockfileStale(b) {
+		util.Die("%s has changed since %s was generated (run 'upm lock')",
+			b.Specfile, b.Lockfile)
+	}
 }
 
 // runInstall implements 'upm install'.
-func runInstall(language string, force bool) {
+func runInstall(ctx context.Context, language string, force bool) {
 	b := backends.GetBackend(language)
 
-	maybeInstall(b, force)
+	p := newPlan(b)
+	if store.HasProfileChanged(b) {
+		// The lockfile was generated for a different profile.
+		p.lockAndInstall(ctx, false, force)
+	} else {
+		p.install(ctx, force)
+	}
 
-	store.UpdateFileHashes(b)
-	store.Write()
+	p.execute(ctx)
+	p.finishIfEmpty("the installed packages are up to date")
+}
+
+// runPatch implements 'upm patch'. Without commit, it saves a copy of
+// the package and then, if there is an editor configured, opens the
+// package in it and commits the patch once the editor exits.
+func runPatch(ctx context.Context, language string, pkg string, commit bool) {
+	b := backends.GetBackend(language)
+	name := api.PkgName(pkg)
+
+	if !commit {
+		dir := patches.Begin(ctx, b, name)
+
+		editor := os.Getenv("VISUAL")
+		if editor == "" {
+			editor = os.Getenv("EDITOR")
+		}
+		if editor == "" {
+			util.Log(fmt.Sprintf(
+				"edit the files in %s, then run 'upm patch --commit %s'", dir, pkg,
+			))
+			return
+		}
+
+		cmd, err := shellquote.Split(editor)
+		if err != nil || len(cmd) == 0 {
+			util.Die("invalid editor %q", editor)
+		}
+		cmd = append(cmd, dir)
+		util.RefuseIfNotAllowed(cmd[0])
+		util.ProgressMsg(strings.Join(cmd, " "))
+		command := util.Command(ctx, cmd)
+		command.Stdin = os.Stdin
+		command.Stdout = os.Stdout
+		command.Stderr = os.Stderr
+		if err := util.RunCommand(ctx, command); err != nil {
+			util.Die("%s: %s", editor, err)
+		}
+	}
+
+	patches.Commit(ctx, b, name)
 }
 
 // listSpecfileJSONEntry represents one entry in the JSON list emitted
 // by 'upm list'.
 type listSpecfileJSONEntry struct {
-	Name string `json:"name"`
-	Spec string `json:"spec"`
+	Name     string `json:"name"`
+	Spec     string `json:"spec"`
+	Group    string `json:"group,omitempty"`
+	Source   string `json:"source,omitempty"`
+	Editable bool   `json:"editable,omitempty"`
+	Linked   bool   `json:"linked,omitempty"`
 }
 
 // listLockfileJSONEntry represents one entry in the JSON list emitted
@@ -398,9 +834,34 @@ func runList(language string, all bool, outputFormat outputFormat) {
 	b := backends.GetBackend(language)
 	if !all {
 		var results map[api.PkgName]api.PkgSpec = nil
+		groups := map[api.PkgName]string{}
 		fileExists := util.Exists(b.Specfile)
 		if fileExists {
 			results = b.ListSpecfile()
+			if b.ListSpecfileGroups != nil {
+				groups = b.ListSpecfileGroups()
+			}
+		} else if legacy := legacySpecfile(b); legacy != "" {
+			util.Log(fmt.Sprintf(
+				"listing %s; run 'upm migrate' to move its dependencies to %s",
+				legacy, b.Specfile,
+			))
+			results = b.ListLegacySpecfile()
+			fileExists = true
+		}
+		if util.Exists(b.Specfile) {
+			cacheSpecfileListing(b, results, groups)
+			store.Write()
+		}
+		editable := store.GetEditable(b)
+		links := store.GetLinks(b)
+		sources := map[api.PkgName]string{}
+		if b.SpecSource != nil {
+			for name, spec := range results {
+				if source := b.SpecSource(spec); source != "" {
+					sources[name] = source
+				}
+			}
 		}
 		switch outputFormat {
 		case outputFormatTable:
@@ -412,19 +873,50 @@ func runList(language string, all bool, outputFormat outputFormat) {
 				util.Log("no packages in specfile")
 				return
 			}
-			t := table.New("name", "spec")
-			for name, spec := range results {
-				t.AddRow(string(name), string(spec))
+			headers := []string{"name", "spec"}
+			if len(groups) > 0 {
+				headers = append(headers, "group")
+			}
+			if len(sources) > 0 {
+				headers = append(headers, "source")
+			}
+			if len(editable) > 0 {
+				headers = append(headers, "editable")
+			}
+			t := table.New(headers...)
+			for _, name := range sortedSpecNames(b, results) {
+				row := []string{string(name), string(results[name])}
+				if len(groups) > 0 {
+					row = append(row, groups[name])
+				}
+				if len(sources) > 0 {
+					row = append(row, sources[name])
+				}
+				if len(editable) > 0 {
+					column := ""
+					if _, ok := links[b.NormalizePackageName(name)]; ok {
+						column = "linked"
+					} else if _, ok := editable[name]; ok {
+						column = "yes"
+					}
+					row = append(row, column)
+				}
+				t.AddRow(row...)
 			}
-			t.SortBy("name")
 			t.Print()
 
 		case outputFormatJSON:
 			j := []listSpecfileJSONEntry{}
-			for name, spec := range results {
+			for _, name := range sortedSpecNames(b, results) {
+				_, isEditable := editable[name]
+				_, isLinked := links[b.NormalizePackageName(name)]
 				j = append(j, listSpecfileJSONEntry{
-					Name: string(name),
-					Spec: string(spec),
+					Name:     string(name),
+					Spec:     string(results[name]),
+					Group:    groups[name],
+					Source:   sources[name],
+					Editable: isEditable,
+					Linked:   isLinked,
 				})
 			}
 			outputB, err := json.Marshal(j)
@@ -441,6 +933,8 @@ func runList(language string, all bool, outputFormat outputFormat) {
 		fileExists := util.Exists(b.Lockfile)
 		if fileExists {
 			results = b.ListLockfile()
+			cacheLockfileListing(b, results)
+			store.Write()
 		}
 		switch outputFormat {
 		case outputFormatTable:
@@ -453,18 +947,17 @@ func runList(language string, all bool, outputFormat outputFormat) {
 				return
 			}
 			t := table.New("name", "version")
-			for name, version := range results {
-				t.AddRow(string(name), string(version))
+			for _, name := range sortedVersionNames(b, results) {
+				t.AddRow(string(name), string(results[name]))
 			}
-			t.SortBy("name")
 			t.Print()
 
 		case outputFormatJSON:
 			j := []listLockfileJSONEntry{}
-			for name, version := range results {
+			for _, name := range sortedVersionNames(b, results) {
 				j = append(j, listLockfileJSONEntry{
 					Name:    string(name),
-					Version: string(version),
+					Version: string(results[name]),
 				})
 			}
 			outputB, err := json.Marshal(j)
@@ -480,12 +973,36 @@ func runList(language string, all bool, outputFormat outputFormat) {
 }
 
 // runGuess implements 'upm guess'.
-func runGuess(
+func runGuess(ctx context.Context,
 	language string, all bool,
-	forceGuess bool, ignoredPackages []string) {
+	forceGuess bool, ignoredPackages []string, check bool,
+	interactive bool, outputFormat outputFormat) {
 
+	if check && all {
+		util.Die("--check can't be used with --all")
+	}
+	if interactive && check {
+		util.Die("--interactive can't be used with --check")
+	}
+	if interactive && outputFormat != outputFormatTable {
+		util.Die("--interactive can't be used with --format")
+	}
+	if interactive && !terminal.IsTerminal(int(os.Stdin.Fd())) {
+		util.Die("--interactive needs a terminal to ask which packages to add")
+	}
 	b := backends.GetBackend(language)
-	pkgs := store.GuessWithCache(b, forceGuess)
+
+	var details map[api.PkgName]api.GuessedPkg
+	var pkgs map[api.PkgName]bool
+	if interactive {
+		details = guess.Details(ctx, b, forceGuess)
+		pkgs = map[api.PkgName]bool{}
+		for name := range details {
+			pkgs[name] = true
+		}
+	} else {
+		pkgs = guess.Guess(ctx, b, forceGuess)
+	}
 
 	// Map from normalized to original names.
 	normPkgs := map[api.PkgName]api.PkgName{}
@@ -504,18 +1021,89 @@ func runGuess(
 	for _, pkg := range ignoredPackages {
 		delete(normPkgs, b.NormalizePackageName(api.PkgName(pkg)))
 	}
+	if !all {
+		for norm := range store.GetDeclined(b) {
+			delete(normPkgs, norm)
+		}
+	}
 
-	lines := []string{}
+	names := []api.PkgName{}
 	for _, pkg := range normPkgs {
-		lines = append(lines, string(pkg))
+		names = append(names, pkg)
 	}
-	sort.Strings(lines)
+	sortPkgNames(b, names)
 
-	for _, line := range lines {
-		fmt.Println(line)
+	if interactive {
+		store.Write()
+		chooseGuesses(ctx, b, names, details, ignoredPackages)
+		return
+	}
+
+	lines := []string{}
+	for _, name := range names {
+		lines = append(lines, string(name))
+	}
+
+	switch outputFormat {
+	case outputFormatTable:
+		for _, line := range lines {
+			fmt.Println(line)
+		}
+
+	case outputFormatJSON:
+		outputB, err := json.Marshal(lines)
+		if err != nil {
+			panic("couldn't marshal json")
+		}
+		fmt.Println(string(outputB))
 	}
 
 	store.Write()
+
+	if check && len(lines) > 0 {
+		util.Die("the packages above are imported but not in %s (run 'upm add --guess')",
+			b.Specfile)
+	}
+}
+
+// runHistory implements 'upm history'. With show, it prints the diff
+// of that change instead of listing them all.
+func runHistory(show int, outputFormat outputFormat) {
+	if show != 0 {
+		fmt.Print(history.Show(show))
+		return
+	}
+
+	entries := history.List()
+	switch outputFormat {
+	case outputFormatTable:
+		if len(entries) == 0 {
+			util.Log("no changes recorded")
+			return
+		}
+		t := table.New("number", "date", "user", "command", "files")
+		for _, entry := range entries {
+			command := entry.Command
+			if entry.Failed {
+				command += " (failed)"
+			}
+			t.AddRow(
+				strconv.Itoa(entry.Number), entry.Date, entry.User,
+				command, strings.Join(entry.Files, ", "),
+			)
+		}
+		t.Print()
+
+	case outputFormatJSON:
+		outputB, err := json.Marshal(entries)
+		if err != nil {
+			panic("couldn't marshal json")
+		}
+		fmt.Println(string(outputB))
+
+	default:
+		util.Panicf("unknown output format %d", outputFormat)
+	}
 }
 
 // runShowSpecfile implements 'upm show-specfile'.
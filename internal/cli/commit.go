@@ -0,0 +1,99 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/replit/upm/internal/api"
+	"github.com/replit/upm/internal/history"
+	"github.com/replit/upm/internal/project"
+	"github.com/replit/upm/internal/util"
+)
+
+// gitCommit is a commit of the specfile and lockfile to make once a
+// command has changed them successfully. A nil *gitCommit means that
+// no commit should be made.
+type gitCommit struct {
+	// The new branch to make the commit on, or the empty string
+	// to make it on the current one.
+	branch string
+}
+
+// isCommitRequested returns true if changes made by the given command
+// should be committed, according to the value of its --commit flag
+// or, if that wasn't given, the project config.
+func isCommitRequested(cmd *cobra.Command, commit bool) bool {
+	if cmd.Flags().Changed("commit") {
+		return commit
+	}
+	return project.Read().Git.Commit
+}
+
+// startCommit checks that a commit can be made, if one is requested,
+// so that the command fails before changing anything otherwise. A
+// branch implies a commit.
+func startCommit(ctx context.Context, commit bool, branch string) *gitCommit {
+	if !commit && branch == "" {
+		return nil
+	}
+
+	output, code := util.GetCmdOutputAndExitCode(ctx, []string{
+		"git", "rev-parse", "--is-inside-work-tree",
+	})
+	if code != 0 || strings.TrimSpace(string(output)) != "true" {
+		util.Die("--commit can only be used in a git repository")
+	}
+	if branch != "" {
+		_, code := util.GetCmdOutputAndExitCode(ctx, []string{
+			"git", "rev-parse", "--verify", "--quiet", "refs/heads/" + branch,
+		})
+		if code == 0 {
+			util.Die("branch %s already exists", branch)
+		}
+	}
+	return &gitCommit{branch: branch}
+}
+
+// finish commits the files that changed while the given recording was
+// made, with the given message, after switching to the new branch if
+// there is one. Nothing else that is staged is included in the
+// commit. There is no recording, and so no commit, after a dry run.
+func (c *gitCommit) finish(ctx context.Context, h *history.Recording, message string) {
+	if c == nil || h == nil {
+		return
+	}
+	files := h.Changed()
+	if len(files) == 0 {
+		util.Log("nothing changed, so there is nothing to commit")
+		return
+	}
+
+	if c.branch != "" {
+		util.RunCmd(ctx, []string{"git", "checkout", "--quiet", "-b", c.branch})
+	}
+	util.RunCmd(ctx, append([]string{"git", "add", "--"}, files...))
+	util.RunCmd(ctx, append([]string{"git", "commit", "--quiet", "-m", message, "--"}, files...))
+}
+
+// commitMessage returns the message for a commit made after the given
+// operation, such as "add", on the given packages: for example
+// "upm: add flask ^3.0, requests".
+func commitMessage(operation string, pkgs map[api.PkgName]api.PkgSpec) string {
+	entries := []string{}
+	for name, spec := range pkgs {
+		entry := string(name)
+		if spec != "" {
+			entry += " " + string(spec)
+		}
+		entries = append(entries, entry)
+	}
+	sort.Strings(entries)
+	if len(entries) == 0 {
+		return "upm: " + operation
+	}
+	return fmt.Sprintf("upm: %s %s", operation, strings.Join(entries, ", "))
+}
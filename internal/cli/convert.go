@@ -0,0 +1,112 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/replit/upm/internal/api"
+	"github.com/replit/upm/internal/backends"
+	"github.com/replit/upm/internal/util"
+)
+
+// runConvert implements 'upm convert', moving the dependencies of the
+// project from the backend that it uses to the one that the given
+// --lang argument value names, which must be for the same language.
+// The packages are added to the specfile of the new backend with their
+// specs, and to the same dependency groups if it supports them, unless
+// it lists them already, as when both read package.json. Then the
+// lockfile of the old backend is deleted and the new one locks, so
+// that the project is autodetected as using it from then on. The old
+// specfile is left alone, since other tools may read it.
+func runConvert(ctx context.Context, language string, to string, forceLock bool, forceInstall bool,
+	name string, commit bool, branch string) {
+
+	from := backends.GetBackend(language)
+	b := backends.GetBackend(to)
+	if b.Name == from.Name {
+		util.Die("this project already uses %s", b.Name)
+	}
+	if backends.BackendLanguage(b) != backends.BackendLanguage(from) {
+		util.Die("%s and %s are for different languages", from.Name, b.Name)
+	}
+	if !util.Exists(from.Specfile) {
+		util.Die("%s does not exist", from.Specfile)
+	}
+
+	s := silenceSubroutines()
+	pkgs := from.ListSpecfile()
+	groups := map[api.PkgName]string{}
+	if from.ListSpecfileGroups != nil {
+		groups = from.ListSpecfileGroups()
+	}
+	listed := map[api.PkgName]bool{}
+	if util.Exists(b.Specfile) {
+		for pkg := range b.ListSpecfile() {
+			listed[b.NormalizePackageName(pkg)] = true
+		}
+	}
+	s.restore()
+
+	mainPkgs := map[api.PkgName]api.PkgSpec{}
+	groupPkgs := map[string]map[api.PkgName]api.PkgSpec{}
+	for pkg, spec := range pkgs {
+		if listed[b.NormalizePackageName(pkg)] {
+			continue
+		}
+		group := groups[pkg]
+		switch {
+		case group != "" && b.AddToGroup != nil:
+			if groupPkgs[group] == nil {
+				groupPkgs[group] = map[api.PkgName]api.PkgSpec{}
+			}
+			groupPkgs[group][pkg] = spec
+		case group != "":
+			util.Log(fmt.Sprintf(
+				"warning: %s is in the %s group, but %s does not support "+
+					"dependency groups, so it is added as a regular dependency",
+				pkg, group, b.Name,
+			))
+			fallthrough
+		default:
+			mainPkgs[pkg] = spec
+		}
+	}
+	groupNames := []string{}
+	for group := range groupPkgs {
+		groupNames = append(groupNames, group)
+	}
+	sort.Strings(groupNames)
+	c := startCommit(ctx, commit, branch)
+
+	p := newPlan(b)
+	if from.Lockfile != b.Lockfile && util.Exists(from.Lockfile) {
+		p.deleteFile(from.Lockfile, "the project no longer uses "+from.Name)
+	}
+	if len(mainPkgs) >= 1 {
+		p.change("add "+formatPkgs(mainPkgs)+" from "+from.Specfile, func() {
+			b.Add(ctx, mainPkgs, name)
+		})
+	}
+	for _, group := range groupNames {
+		group := group
+		p.change("add "+formatPkgs(groupPkgs[group])+" from "+from.Specfile+" to group "+group, func() {
+			b.AddToGroup(ctx, groupPkgs[group], name, group)
+		})
+	}
+	p.lockAndInstallAfterChange(ctx, len(mainPkgs)+len(groupNames) >= 1, forceLock, forceInstall)
+
+	h := p.execute(ctx)
+	c.finish(ctx, h, commitMessage(fmt.Sprintf("convert from %s to %s", from.Name, b.Name), nil))
+	p.finishIfEmpty("")
+	if h == nil {
+		return
+	}
+	if from.Specfile != b.Specfile && util.Exists(from.Specfile) {
+		util.Log(fmt.Sprintf("%s is left as it was; delete it once nothing else reads it", from.Specfile))
+	}
+	if detected := backends.RelevantBackends(""); len(detected) == 0 || detected[0].Name != b.Name {
+		util.Log(fmt.Sprintf("warning: this project is still autodetected as using another backend, "+
+			"so give --lang %s to use %s", b.Name, b.Name))
+	}
+}
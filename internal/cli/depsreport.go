@@ -0,0 +1,194 @@
+package cli
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"text/template"
+
+	"github.com/replit/upm/internal/api"
+	"github.com/replit/upm/internal/backends"
+	"github.com/replit/upm/internal/store"
+	"github.com/replit/upm/internal/table"
+	"github.com/replit/upm/internal/util"
+)
+
+// reportPackage is a direct dependency as 'upm report' describes it.
+type reportPackage struct {
+	Name api.PkgName `json:"name"`
+	Spec api.PkgSpec `json:"spec,omitempty"`
+	// The locked version, if there is a lockfile that has the
+	// package.
+	Version     api.PkgVersion `json:"version,omitempty"`
+	License     string         `json:"license,omitempty"`
+	Description string         `json:"description,omitempty"`
+	HomepageURL string         `json:"homepageURL,omitempty"`
+}
+
+// reportData is what the template of 'upm report' is executed on.
+type reportData struct {
+	Language string
+	Packages []reportPackage
+}
+
+// defaultReportTemplate renders DEPENDENCIES.md. Nothing in it
+// depends on when it is rendered, so that 'upm report --check' can
+// tell whether the file is current.
+const defaultReportTemplate = `# Dependencies
+
+This file is generated by ` + "`upm report --format markdown`" + ` from the
+{{.Language}} specfile. Don't edit it by hand.
+
+{{if .Packages -}}
+| Name | Version | License | Description |
+|------|---------|---------|-------------|
+{{range .Packages -}}
+| {{link .Name .HomepageURL}} | {{cell (version .)}} | {{cell .License}} | {{cell .Description}} |
+{{end -}}
+{{else -}}
+There are no dependencies.
+{{end -}}
+`
+
+// reportTemplateFuncs are the functions available to the template of
+// 'upm report', in addition to the built-in ones.
+var reportTemplateFuncs = template.FuncMap{
+	// cell escapes a string for a Markdown table cell.
+	"cell": func(s string) string {
+		s = strings.Join(strings.Fields(s), " ")
+		return strings.Replace(s, "|", `\|`, -1)
+	},
+	// link returns a Markdown link to the given URL, or just the
+	// text if the URL is empty.
+	"link": func(text api.PkgName, url string) string {
+		if url == "" {
+			return string(text)
+		}
+		return fmt.Sprintf("[%s](%s)", text, url)
+	},
+	// version returns the locked version of a package, or its
+	// spec if it isn't locked.
+	"version": func(pkg reportPackage) string {
+		if pkg.Version != "" {
+			return string(pkg.Version)
+		}
+		return string(pkg.Spec)
+	},
+}
+
+// collectReport looks up each package in the specfile in the registry,
+// returning them sorted by name.
+func collectReport(ctx context.Context, b api.LanguageBackend) []reportPackage {
+	pkgs := []reportPackage{}
+	if !util.Exists(b.Specfile) {
+		return pkgs
+	}
+	locked := map[api.PkgName]api.PkgVersion{}
+	if util.Exists(b.Lockfile) {
+		for name, version := range b.ListLockfile() {
+			locked[b.NormalizePackageName(name)] = version
+		}
+	}
+	for name, spec := range b.ListSpecfile() {
+		info := b.Info(ctx, name)
+		pkgs = append(pkgs, reportPackage{
+			Name:        name,
+			Spec:        spec,
+			Version:     locked[b.NormalizePackageName(name)],
+			License:     info.License,
+			Description: info.Description,
+			HomepageURL: info.HomepageURL,
+		})
+	}
+	sort.Slice(pkgs, func(i, j int) bool {
+		return pkgNameLess(b, pkgs[i].Name, pkgs[j].Name)
+	})
+	return pkgs
+}
+
+// renderReport returns the given packages in the given format:
+// "markdown", "table", or "json". A Markdown report uses the given
+// template file, or the default one if it is empty.
+func renderReport(b api.LanguageBackend, pkgs []reportPackage, format string, templateFile string) string {
+	switch format {
+	case "markdown":
+		text := defaultReportTemplate
+		if templateFile != "" {
+			textB, err := ioutil.ReadFile(templateFile)
+			if err != nil {
+				util.Die("%s: %s", templateFile, err)
+			}
+			text = string(textB)
+		}
+		tmpl, err := template.New("report").Funcs(reportTemplateFuncs).Parse(text)
+		if err != nil {
+			util.Die("report template: %s", err)
+		}
+		var out bytes.Buffer
+		if err := tmpl.Execute(&out, reportData{Language: b.Name, Packages: pkgs}); err != nil {
+			util.Die("report template: %s", err)
+		}
+		return out.String()
+
+	case "table":
+		if len(pkgs) == 0 {
+			return ""
+		}
+		t := table.New("name", "version", "license", "description")
+		for _, pkg := range pkgs {
+			version := string(pkg.Version)
+			if version == "" {
+				version = string(pkg.Spec)
+			}
+			t.AddRow(string(pkg.Name), version, pkg.License, pkg.Description)
+		}
+		return t.String()
+
+	case "json":
+		outputB, err := json.Marshal(pkgs)
+		if err != nil {
+			panic("couldn't marshal json")
+		}
+		return string(outputB) + "\n"
+
+	default:
+		util.Die(`Error: invalid format %#v (must be "table", "json", or "markdown")`, format)
+		return ""
+	}
+}
+
+// runReport implements 'upm report'. With an output file, the report
+// is written there instead of to stdout, or with check, compared
+// against what is there.
+func runReport(ctx context.Context, language string, format string, out string, templateFile string, check bool) {
+	if check && out == "" {
+		util.Die("--check needs the file to check (use --out)")
+	}
+	b := backends.GetBackend(language)
+	items := collectReport(ctx, b)
+	store.Write()
+	rendered := renderReport(b, items, format, templateFile)
+
+	switch {
+	case check:
+		existing, err := ioutil.ReadFile(out)
+		if err != nil && !os.IsNotExist(err) {
+			util.Die("%s: %s", out, err)
+		}
+		if string(existing) != rendered {
+			util.Die("%s is out of date (run 'upm report --format %s --out %s')", out, format, out)
+		}
+	case out != "":
+		util.ProgressMsg("write " + out)
+		util.TryWriteAtomic(out, []byte(rendered))
+	case rendered == "":
+		util.Log("no packages in specfile")
+	default:
+		fmt.Print(rendered)
+	}
+}
@@ -0,0 +1,197 @@
+package cli
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+
+	"github.com/replit/upm/internal/api"
+	"github.com/replit/upm/internal/backends"
+	"github.com/replit/upm/internal/config"
+	"github.com/replit/upm/internal/store"
+	"github.com/replit/upm/internal/table"
+	"github.com/replit/upm/internal/util"
+)
+
+// doctorCheck is the result of one of the checks that 'upm doctor'
+// makes.
+type doctorCheck struct {
+	// The backend that the check is for, or empty for one that is
+	// made once, such as of the store.
+	Backend string `json:"backend,omitempty"`
+	// What was checked: "store", the name of a program,
+	// "registry", "lockfile", or "environment".
+	Check  string `json:"check"`
+	OK     bool   `json:"ok"`
+	Detail string `json:"detail"`
+	// What to do about a problem.
+	Fix string `json:"fix,omitempty"`
+}
+
+// checkStore checks that the store can be written.
+func checkStore() doctorCheck {
+	if config.ReadOnly {
+		return doctorCheck{Check: "store", OK: true, Detail: "not written, since --read-only is given"}
+	}
+	filename, err := store.CheckWritable()
+	if err != nil {
+		return doctorCheck{
+			Check: "store", Detail: fmt.Sprintf("%s can't be written: %s", filename, err),
+			Fix: "make its directory writable, or point UPM_STORE somewhere that is",
+		}
+	}
+	return doctorCheck{Check: "store", OK: true, Detail: filename + " is writable"}
+}
+
+// checkProgram checks that the given program can be run.
+func checkProgram(ctx context.Context, program string) doctorCheck {
+	if _, err := exec.LookPath(program); err != nil {
+		return doctorCheck{
+			Check: program, Detail: "not found",
+			Fix: fmt.Sprintf("install %s, or add the directory it is in to $PATH", program),
+		}
+	}
+	if !util.IsCommandAllowed(program) {
+		return doctorCheck{
+			Check: program, Detail: "not allowed by the command policy",
+			Fix: fmt.Sprintf("allow %s in the command policy", program),
+		}
+	}
+	return doctorCheck{Check: program, OK: true, Detail: toolVersion(ctx, program)}
+}
+
+// checkRegistry checks that the registry at the given URL answers.
+func checkRegistry(ctx context.Context, url string) doctorCheck {
+	resp, err := util.HTTPGet(ctx, url)
+	if err != nil {
+		return doctorCheck{
+			Check: "registry", Detail: err.Error(),
+			Fix: "check the network connection and any proxy settings",
+		}
+	}
+	resp.Body.Close()
+	switch {
+	case resp.StatusCode == 401 || resp.StatusCode == 403:
+		return doctorCheck{
+			Check: "registry", Detail: fmt.Sprintf("%s: %s", url, resp.Status),
+			Fix: "check the credentials configured for it",
+		}
+	case resp.StatusCode >= 400:
+		return doctorCheck{
+			Check: "registry", Detail: fmt.Sprintf("%s: %s", url, resp.Status),
+			Fix: "check that the registry is configured correctly, or try again later",
+		}
+	}
+	return doctorCheck{Check: "registry", OK: true, Detail: url + " is reachable"}
+}
+
+// checkLockfile checks that the lockfile of the given backend exists
+// and is up to date with the specfile, if there is one.
+func checkLockfile(b api.LanguageBackend) (doctorCheck, bool) {
+	if !util.Exists(b.Specfile) {
+		return doctorCheck{}, false
+	}
+	fix := "run 'upm lock'"
+	if b.QuirksIsNotReproducible() {
+		fix = "run 'upm install'"
+	}
+	if !util.Exists(b.Lockfile) {
+		return doctorCheck{
+			Check: "lockfile", Detail: fmt.Sprintf("%s has no %s", b.Specfile, b.Lockfile), Fix: fix,
+		}, true
+	}
+	if store.IsLockfileStale(b) {
+		return doctorCheck{
+			Check:  "lockfile",
+			Detail: fmt.Sprintf("%s changed since %s was last generated", b.Specfile, b.Lockfile),
+			Fix:    fix,
+		}, true
+	}
+	detail := b.Lockfile + " is up to date"
+	if p, ok := readProvenance(b); ok && p.platform != currentPlatform() {
+		detail += fmt.Sprintf(" (locked on %s, so packages for some platforms may be locked differently here)", p.platform)
+	}
+	return doctorCheck{Check: "lockfile", OK: true, Detail: detail}, true
+}
+
+// collectDoctor makes the checks of 'upm doctor' for the backends that
+// the given --lang argument value matches, or the one autodetected for
+// the project, in order.
+func collectDoctor(ctx context.Context, language string) []doctorCheck {
+	checks := []doctorCheck{checkStore()}
+	for _, b := range backends.RelevantBackends(language) {
+		bChecks := []doctorCheck{}
+		for _, program := range b.Executables {
+			bChecks = append(bChecks, checkProgram(ctx, program))
+		}
+		if b.Registry != nil {
+			bChecks = append(bChecks, checkRegistry(ctx, b.Registry()))
+		}
+		if check, ok := checkLockfile(b); ok {
+			bChecks = append(bChecks, check)
+		}
+		if b.Diagnose != nil {
+			problems := b.Diagnose()
+			for _, problem := range problems {
+				bChecks = append(bChecks, doctorCheck{
+					Check: "environment", Detail: problem.Summary, Fix: problem.Fix,
+				})
+			}
+			if len(problems) == 0 {
+				bChecks = append(bChecks, doctorCheck{
+					Check: "environment", OK: true, Detail: "no problems found",
+				})
+			}
+		}
+		for _, check := range bChecks {
+			check.Backend = b.Name
+			checks = append(checks, check)
+		}
+	}
+	return checks
+}
+
+// runDoctor implements 'upm doctor'. It fails if any check finds a
+// problem.
+func runDoctor(ctx context.Context, language string, outputFormat outputFormat) {
+	checks := collectDoctor(ctx, language)
+
+	switch outputFormat {
+	case outputFormatTable:
+		t := table.New("backend", "check", "status", "detail", "fix")
+		for _, check := range checks {
+			status := "ok"
+			if !check.OK {
+				status = "problem"
+			}
+			t.AddRow(check.Backend, check.Check, status, check.Detail, check.Fix)
+		}
+		t.Print()
+		if len(checks) == 1 {
+			util.Log("no language was autodetected, so no backend was checked (use --lang to pick one)")
+		}
+
+	case outputFormatJSON:
+		outputB, err := json.Marshal(checks)
+		if err != nil {
+			panic("couldn't marshal json")
+		}
+		fmt.Println(string(outputB))
+
+	default:
+		util.Panicf("unknown output format %d", outputFormat)
+	}
+
+	problems := 0
+	for _, check := range checks {
+		if !check.OK {
+			problems++
+		}
+	}
+	if problems == 1 {
+		util.Die("found 1 problem")
+	} else if problems > 1 {
+		util.Die("found %d problems", problems)
+	}
+}
@@ -0,0 +1,147 @@
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"github.com/replit/upm/internal/api"
+	"github.com/replit/upm/internal/backends"
+	"github.com/replit/upm/internal/table"
+	"github.com/replit/upm/internal/util"
+)
+
+// The kinds of drift that 'upm verify' reports.
+const (
+	// The package is in the lockfile but isn't installed.
+	driftMissing = "missing"
+	// A different version is installed from the one locked.
+	driftVersion = "version"
+	// The package is installed but isn't in the lockfile, such as
+	// one installed with pip directly.
+	driftUntracked = "untracked"
+	// Files of the package were changed after it was installed.
+	driftModified = "modified"
+)
+
+// driftPackage is a package that isn't installed as the lockfile says,
+// as 'upm verify' reports it.
+type driftPackage struct {
+	Name api.PkgName `json:"name"`
+	// One of the drift constants.
+	Problem   string         `json:"problem"`
+	Locked    api.PkgVersion `json:"locked,omitempty"`
+	Installed api.PkgVersion `json:"installed,omitempty"`
+	// The files that were changed, for driftModified.
+	Files []string `json:"files,omitempty"`
+}
+
+// describe returns what is wrong with the package, for the table of
+// 'upm verify'.
+func (pkg driftPackage) describe() string {
+	switch pkg.Problem {
+	case driftMissing:
+		return "not installed"
+	case driftVersion:
+		return "different version installed"
+	case driftUntracked:
+		return "not in lockfile"
+	case driftModified:
+		if len(pkg.Files) == 1 {
+			return "modified " + pkg.Files[0]
+		}
+		return fmt.Sprintf("modified %s and %d more files", pkg.Files[0], len(pkg.Files)-1)
+	}
+	return pkg.Problem
+}
+
+// findDrift compares the packages in the lockfile with the installed
+// ones, returning those that differ, sorted by name. A package that
+// is installed at the wrong version is only reported as such, even if
+// its files were changed too.
+func findDrift(b api.LanguageBackend, locked map[api.PkgName]api.PkgVersion,
+	installed map[api.PkgName]api.InstalledPkg) []driftPackage {
+
+	normInstalled := map[api.PkgName]api.PkgName{}
+	for name := range installed {
+		normInstalled[b.NormalizePackageName(name)] = name
+	}
+
+	drift := []driftPackage{}
+	normLocked := map[api.PkgName]bool{}
+	for name, version := range locked {
+		norm := b.NormalizePackageName(name)
+		normLocked[norm] = true
+		installedName, ok := normInstalled[norm]
+		pkg := installed[installedName]
+		switch {
+		case !ok:
+			drift = append(drift, driftPackage{
+				Name: name, Problem: driftMissing, Locked: version,
+			})
+		case pkg.Version != version:
+			drift = append(drift, driftPackage{
+				Name: name, Problem: driftVersion,
+				Locked: version, Installed: pkg.Version,
+			})
+		case len(pkg.Modified) > 0:
+			drift = append(drift, driftPackage{
+				Name: name, Problem: driftModified,
+				Locked: version, Installed: pkg.Version, Files: pkg.Modified,
+			})
+		}
+	}
+	for norm, name := range normInstalled {
+		if pkg := installed[name]; !normLocked[norm] && !pkg.Implicit {
+			drift = append(drift, driftPackage{
+				Name: name, Problem: driftUntracked, Installed: pkg.Version,
+			})
+		}
+	}
+
+	sort.Slice(drift, func(i, j int) bool {
+		return pkgNameLess(b, drift[i].Name, drift[j].Name)
+	})
+	return drift
+}
+
+// runVerify implements 'upm verify'. It fails if any package isn't
+// installed as the lockfile says.
+func runVerify(language string, outputFormat outputFormat) {
+	b := backends.GetBackend(language)
+	if b.ListInstalled == nil {
+		util.Die("%s does not support verifying installed packages", b.Name)
+	}
+	if !util.Exists(b.Lockfile) {
+		util.Die("no lockfile (run 'upm lock')")
+	}
+	drift := findDrift(b, b.ListLockfile(), b.ListInstalled())
+
+	switch outputFormat {
+	case outputFormatTable:
+		if len(drift) == 0 {
+			util.Log("installed packages match " + b.Lockfile)
+			return
+		}
+		t := table.New("name", "locked", "installed", "problem")
+		for _, pkg := range drift {
+			t.AddRow(string(pkg.Name), string(pkg.Locked), string(pkg.Installed), pkg.describe())
+		}
+		t.Print()
+
+	case outputFormatJSON:
+		outputB, err := json.Marshal(drift)
+		if err != nil {
+			panic("couldn't marshal json")
+		}
+		fmt.Println(string(outputB))
+
+	default:
+		util.Panicf("unknown output format %d", outputFormat)
+	}
+
+	if len(drift) > 0 {
+		explainProvenance(b)
+		util.Die("installed packages don't match %s (run 'upm install')", b.Lockfile)
+	}
+}
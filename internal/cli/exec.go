@@ -0,0 +1,58 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/kballard/go-shellquote"
+
+	"github.com/replit/upm/internal/backends"
+	"github.com/replit/upm/internal/config"
+	"github.com/replit/upm/internal/util"
+)
+
+// runExec implements 'upm exec', running the given command in the
+// environment of the project and exiting with its exit code.
+func runExec(ctx context.Context, language string, args []string) {
+	b := backends.GetBackend(language)
+	if b.GetExecEnv == nil {
+		util.Die("%s does not support running commands in its environment", b.Name)
+	}
+	env := b.GetExecEnv()
+	cmd := append(append([]string{}, env.Prefix...), args...)
+
+	names := []string{}
+	for name := range env.Vars {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	path := os.Getenv("PATH")
+	if len(env.Path) > 0 {
+		path = strings.Join(append(append([]string{}, env.Path...), path), string(os.PathListSeparator))
+	}
+
+	if config.DryRun {
+		words := []string{}
+		for _, name := range names {
+			words = append(words, shellquote.Join(name+"="+env.Vars[name]))
+		}
+		if len(env.Path) > 0 {
+			dirs := strings.Join(env.Path, string(os.PathListSeparator))
+			words = append(words, "PATH="+shellquote.Join(dirs)+string(os.PathListSeparator)+"$PATH")
+		}
+		words = append(words, shellquote.Join(cmd...))
+		fmt.Printf("1. run %s\n", strings.Join(words, " "))
+		return
+	}
+
+	// The command is looked up on the new $PATH, so that it can be
+	// one that the packages provide.
+	for _, name := range names {
+		os.Setenv(name, env.Vars[name])
+	}
+	os.Setenv("PATH", path)
+	util.RunInteractiveCmd(ctx, cmd)
+}
@@ -0,0 +1,108 @@
+package cli
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/replit/upm/internal/api"
+	"github.com/replit/upm/internal/backends"
+	"github.com/replit/upm/internal/project"
+	"github.com/replit/upm/internal/util"
+)
+
+// exportsOf returns the exports in the project config that are of the
+// lockfile of the given backend: those that name it, and those that
+// name no backend, if it can export its lockfile.
+func exportsOf(b api.LanguageBackend) []project.Export {
+	exports := []project.Export{}
+	for _, e := range project.Read().Exports {
+		if e.File == "" {
+			util.Die(".upm/config.toml: an export has no file")
+		}
+		if e.Language == b.Name || e.Language == "" && b.ExportLockfile != nil {
+			exports = append(exports, e)
+		}
+	}
+	return exports
+}
+
+// exportLockfile writes the packages in the lockfile of the given
+// backend that the main dependencies and the given groups need to the
+// given file, as b.ExportLockfile does. The file is replaced at once,
+// so that a deploy that reads it never sees half of it.
+func exportLockfile(ctx context.Context, b api.LanguageBackend, groups []string, file string) {
+	if b.ExportLockfile == nil {
+		util.Die("%s can't export its lockfile", b.Name)
+	}
+	if !util.Exists(b.Lockfile) {
+		util.Die("%s doesn't exist, so there is nothing to export", b.Lockfile)
+	}
+	what := "the main dependencies"
+	if len(groups) > 0 {
+		what += " and " + strings.Join(groups, ", ")
+	}
+	util.ProgressMsg("export " + what + " to " + file)
+
+	tempdir := util.TempDir("upm")
+	defer util.RemoveTemp(tempdir)
+	temp := filepath.Join(tempdir, filepath.Base(file))
+	b.ExportLockfile(ctx, groups, temp)
+	contents, err := ioutil.ReadFile(temp)
+	if err != nil {
+		util.Die("%s: %s", file, err)
+	}
+	util.TryWriteAtomic(file, contents)
+}
+
+// export adds a step that exports the lockfile to each of the files
+// in the project config, if the lockfile will have changed since it
+// was last exported or the file doesn't exist.
+func (p *plan) export(ctx context.Context) {
+	if !p.lockfileExists {
+		return
+	}
+	for _, e := range exportsOf(p.b) {
+		var reason string
+		switch {
+		case !util.Exists(e.File):
+			reason = "there is no " + e.File
+		case p.lockfileChanged:
+			reason = p.b.Lockfile + " has changed since it was exported"
+		default:
+			continue
+		}
+		e := e
+		p.exports = append(p.exports, step{
+			summary: "export " + e.File,
+			reason:  reason,
+			changes: []string{e.File},
+			run: func() {
+				exportLockfile(ctx, p.b, e.Groups, e.File)
+			},
+		})
+	}
+}
+
+// runExport implements 'upm export'. The lockfile is exported with the
+// given groups to the given file, or to standard output if it is
+// empty.
+func runExport(ctx context.Context, language string, groups []string, out string) {
+	b := backends.GetBackend(language)
+	if out != "" {
+		exportLockfile(ctx, b, groups, out)
+		return
+	}
+
+	tempdir := util.TempDir("upm")
+	defer util.RemoveTemp(tempdir)
+	file := filepath.Join(tempdir, "export.txt")
+	exportLockfile(ctx, b, groups, file)
+	contents, err := ioutil.ReadFile(file)
+	if err != nil {
+		util.Die("%s", err)
+	}
+	os.Stdout.Write(contents)
+}
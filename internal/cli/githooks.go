@@ -0,0 +1,81 @@
+package cli
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/kballard/go-shellquote"
+
+	"github.com/replit/upm/internal/util"
+)
+
+// gitHookMarker is the line that identifies a git hook as one written
+// by 'upm install-git-hooks', so that it can be replaced safely.
+const gitHookMarker = "# Installed by 'upm install-git-hooks'."
+
+// gitHookScript returns the pre-commit hook that checks the project in
+// the given directory, relative to the top of the work tree, passing
+// the given arguments on to UPM. Git runs hooks from the top of the
+// work tree, so the hook has to change to the project directory
+// itself. Since it runs on every commit, the guess only scans the
+// files that changed since the last one.
+func gitHookScript(prefix string, args []string) string {
+	var script strings.Builder
+	script.WriteString("#!/bin/sh\n")
+	script.WriteString(gitHookMarker + "\n")
+	script.WriteString("# Refuse to commit if the lockfile has drifted from the specfile,\n")
+	script.WriteString("# or if the code imports packages that are not in the specfile.\n")
+	if prefix != "" {
+		script.WriteString("cd " + shellquote.Join(prefix) + " || exit 1\n")
+	}
+	lock := shellquote.Join(append([]string{"upm", "lock", "--check"}, args...)...)
+	guess := shellquote.Join(append([]string{"upm", "guess", "--check", "--changed-only"}, args...)...)
+	script.WriteString(lock + " && " + guess + "\n")
+	return script.String()
+}
+
+// runInstallGitHooks implements 'upm install-git-hooks'.
+func runInstallGitHooks(ctx context.Context, language string, ignoredPackages []string, force bool) {
+	output, code := util.GetCmdOutputAndExitCode(ctx, []string{
+		"git", "rev-parse", "--is-inside-work-tree",
+	})
+	if code != 0 || strings.TrimSpace(string(output)) != "true" {
+		util.Die("git hooks can only be installed in a git repository")
+	}
+	// This respects core.hooksPath, and works in linked work
+	// trees.
+	filename := strings.TrimSpace(string(util.GetCmdOutput(ctx, []string{
+		"git", "rev-parse", "--git-path", "hooks/pre-commit",
+	})))
+	prefix := strings.TrimSpace(string(util.GetCmdOutput(ctx, []string{
+		"git", "rev-parse", "--show-prefix",
+	})))
+
+	if contents, err := ioutil.ReadFile(filename); err == nil {
+		if !force && !strings.Contains(string(contents), gitHookMarker) {
+			util.Die("%s already exists (use --force to replace it)", filename)
+		}
+	} else if !os.IsNotExist(err) {
+		util.Die("%s: %s", filename, err)
+	}
+
+	args := []string{}
+	if language != "" {
+		args = append(args, "--lang", language)
+	}
+	if len(ignoredPackages) > 0 {
+		args = append(args, "--ignored-packages", strings.Join(ignoredPackages, ","))
+	}
+
+	if err := os.MkdirAll(filepath.Dir(filename), 0777); err != nil {
+		util.Die("%s: %s", filepath.Dir(filename), err)
+	}
+	util.ProgressMsg("write " + filename)
+	util.TryWriteAtomic(filename, []byte(gitHookScript(prefix, args)))
+	if err := os.Chmod(filename, 0755); err != nil {
+		util.Die("%s: %s", filename, err)
+	}
+}
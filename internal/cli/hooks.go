@@ -0,0 +1,30 @@
+package cli
+
+import (
+	"context"
+
+	"github.com/replit/upm/internal/api"
+	"github.com/replit/upm/internal/patches"
+)
+
+// postInstallHooks are run, in order, every time packages may have
+// been installed by a backend, whether by Install itself or as a side
+// effect of Add, Remove, or Lock (according to the quirks of the
+// backend).
+var postInstallHooks = []func(ctx context.Context, b api.LanguageBackend){
+	// Installing may have overwritten patched files.
+	patches.Apply,
+	// Let the verification command know there is something to
+	// verify.
+	func(ctx context.Context, b api.LanguageBackend) {
+		packagesInstalled = true
+	},
+}
+
+// runPostInstallHooks runs each of the postInstallHooks for the given
+// backend, with ctx.
+func runPostInstallHooks(ctx context.Context, b api.LanguageBackend) {
+	for _, hook := range postInstallHooks {
+		hook(ctx, b)
+	}
+}
@@ -0,0 +1,103 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/replit/upm/internal/api"
+	"github.com/replit/upm/internal/backends"
+	"github.com/replit/upm/internal/config"
+	"github.com/replit/upm/internal/util"
+)
+
+// invalidNameRegexp matches the runs of characters that can't be in
+// the name of a project, for one package manager or another.
+var invalidNameRegexp = regexp.MustCompile(`[^a-z0-9_-]+`)
+
+// defaultProjectName returns the name of the given directory, in a
+// form that every package manager takes as the name of a project:
+// lowercase, with other characters than letters, digits, "_", and "-"
+// replaced by "-".
+func defaultProjectName(dir string) string {
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		util.Die("%s", err)
+	}
+	name := invalidNameRegexp.ReplaceAllString(strings.ToLower(filepath.Base(abs)), "-")
+	name = strings.Trim(name, "-_")
+	if name == "" {
+		return "project"
+	}
+	return name
+}
+
+// runInitSpecfile implements 'upm init' without --from, creating the
+// specfile of a new project, with no packages, in dir or else the
+// current directory, as the package manager does non-interactively.
+// The project is named after the directory unless a name is given. If
+// dir doesn't exist yet, it is created, and since there is nothing in
+// it to autodetect a language from, --lang is needed.
+func runInitSpecfile(ctx context.Context, language string, dir string, name string) {
+	newDir := dir != "" && !util.Exists(dir)
+	var b api.LanguageBackend
+	if newDir {
+		if language == "" {
+			util.Die("%s does not exist, so upm init needs the language of the project in it (use --lang)", dir)
+		}
+		b = backends.GetNewBackend(language)
+	} else {
+		if dir != "" {
+			if err := os.Chdir(dir); err != nil {
+				util.Die("%s", err)
+			}
+		}
+		if language == "" && len(backends.RelevantBackends("")) == 0 {
+			util.Die("could not autodetect a language for the project (use --lang to pick one)")
+		}
+		b = backends.GetBackend(language)
+	}
+	specfile := b.Specfile
+	if dir != "" {
+		specfile = filepath.Join(dir, b.Specfile)
+	}
+	if !newDir && util.Exists(b.Specfile) {
+		util.Die("%s already exists", specfile)
+	}
+	if b.InitSpecfile == nil {
+		util.Die("%s can only start a project from a template (use --from)", b.Name)
+	}
+	if name == "" {
+		name = defaultProjectName(".")
+		if newDir {
+			name = defaultProjectName(dir)
+		}
+	}
+
+	if config.DryRun {
+		step := 1
+		if newDir {
+			fmt.Printf("%d. create %s\n", step, dir)
+			step++
+		}
+		fmt.Printf("%d. create %s for %s project %s\n", step, specfile, b.Name, name)
+		return
+	}
+
+	if newDir {
+		if err := os.MkdirAll(dir, 0777); err != nil {
+			util.Die("%s", err)
+		}
+		if err := os.Chdir(dir); err != nil {
+			util.Die("%s", err)
+		}
+	}
+	b.InitSpecfile(ctx, name)
+	if !util.Exists(b.Specfile) {
+		util.Die("%s did not create %s", b.Name, specfile)
+	}
+	util.Log(fmt.Sprintf("%s is a %s project; use 'upm add' to add packages to it", name, b.Name))
+}
@@ -0,0 +1,157 @@
+package cli
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/replit/upm/internal/api"
+	"github.com/replit/upm/internal/config"
+	"github.com/replit/upm/internal/store"
+	"github.com/replit/upm/internal/util"
+)
+
+// maxGuessFiles is how many of the files that import a guessed
+// package are shown in 'upm guess --interactive' before the rest are
+// counted instead.
+const maxGuessFiles = 3
+
+// formatGuess returns the line of the checklist of 'upm guess
+// --interactive' for the given package, which is numbered n.
+func formatGuess(n int, name api.PkgName, details map[api.PkgName]api.GuessedPkg) string {
+	line := fmt.Sprintf("%3d. %s", n, name)
+	guessed, ok := details[name]
+	if !ok {
+		return line
+	}
+	if guessed.Spec != "" {
+		line += " " + string(guessed.Spec)
+	}
+	why := []string{}
+	if guessed.Confidence > 0 {
+		why = append(why, fmt.Sprintf("%.0f%%", guessed.Confidence*100))
+	}
+	if guessed.Dev {
+		why = append(why, "dev")
+	}
+	if len(guessed.Files) > 0 {
+		files := guessed.Files
+		more := ""
+		if len(files) > maxGuessFiles {
+			more = fmt.Sprintf(" and %d more", len(files)-maxGuessFiles)
+			files = files[:maxGuessFiles]
+		}
+		why = append(why, "imported in "+strings.Join(files, ", ")+more)
+	}
+	if len(guessed.Sources) > 0 {
+		why = append(why, "suggested by "+strings.Join(guessed.Sources, ", "))
+	}
+	if len(why) == 0 {
+		return line
+	}
+	return line + " (" + strings.Join(why, ", ") + ")"
+}
+
+// parseSelection parses an answer to the prompt of 'upm guess
+// --interactive', such as "1 3-4", "all", or "none", and returns the
+// numbers that were chosen, from 1 to n. An empty answer chooses all
+// of them.
+func parseSelection(answer string, n int) (map[int]bool, error) {
+	chosen := map[int]bool{}
+	fields := strings.FieldsFunc(answer, func(r rune) bool {
+		return r == ',' || unicode.IsSpace(r)
+	})
+	if len(fields) == 0 || len(fields) == 1 && strings.ToLower(fields[0]) == "all" {
+		for i := 1; i <= n; i++ {
+			chosen[i] = true
+		}
+		return chosen, nil
+	}
+	if len(fields) == 1 && strings.ToLower(fields[0]) == "none" {
+		return chosen, nil
+	}
+	for _, field := range fields {
+		bounds := strings.SplitN(field, "-", 2)
+		first, err := strconv.Atoi(bounds[0])
+		if err != nil {
+			return nil, fmt.Errorf("%q is not a number or a range of numbers", field)
+		}
+		last := first
+		if len(bounds) == 2 {
+			last, err = strconv.Atoi(bounds[1])
+			if err != nil {
+				return nil, fmt.Errorf("%q is not a number or a range of numbers", field)
+			}
+		}
+		if first < 1 || last > n || first > last {
+			return nil, fmt.Errorf("%q is not between 1 and %d", field, n)
+		}
+		for i := first; i <= last; i++ {
+			chosen[i] = true
+		}
+	}
+	return chosen, nil
+}
+
+// chooseGuesses implements the checklist of 'upm guess --interactive'.
+// It asks which of the given guessed packages to add, adds those, and
+// records the others as declined in the store, so that they aren't
+// guessed again.
+func chooseGuesses(ctx context.Context, b api.LanguageBackend, names []api.PkgName,
+	details map[api.PkgName]api.GuessedPkg, ignoredPackages []string) {
+
+	if len(names) == 0 {
+		util.Log("no packages to add")
+		return
+	}
+
+	fmt.Fprintln(os.Stderr, "Guessed packages:")
+	for i, name := range names {
+		fmt.Fprintln(os.Stderr, formatGuess(i+1, name, details))
+	}
+
+	reader := bufio.NewReader(os.Stdin)
+	var chosen map[int]bool
+	for {
+		fmt.Fprint(os.Stderr, `Packages to add (e.g. "1 3-4", "all", or "none") [all]: `)
+		answer, err := reader.ReadString('\n')
+		if err != nil && (err != io.EOF || answer == "") {
+			fmt.Fprintln(os.Stderr)
+			util.Die("no answer given, so nothing was added")
+		}
+		chosen, err = parseSelection(answer, len(names))
+		if err == nil {
+			break
+		}
+		fmt.Fprintln(os.Stderr, err)
+	}
+
+	accepted := 0
+	declined := map[api.PkgName]bool{}
+	for i, name := range names {
+		if chosen[i+1] {
+			accepted++
+		} else {
+			declined[name] = true
+			ignoredPackages = append(ignoredPackages, string(name))
+		}
+	}
+
+	if len(declined) > 0 && !config.DryRun {
+		store.AddDeclined(b, declined)
+		store.Write()
+	}
+	if accepted == 0 {
+		util.Log("nothing to add")
+		return
+	}
+	// Add the rest as 'upm add --guess' would, so that they get
+	// the specs and groups that pragmas give them.
+	runAdd(ctx, b.Name, nil, false, true, false, ignoredPackages,
+		false, false, "", "", false, "")
+}
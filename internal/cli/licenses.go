@@ -0,0 +1,148 @@
+package cli
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/replit/upm/internal/api"
+	"github.com/replit/upm/internal/backends"
+	"github.com/replit/upm/internal/store"
+	"github.com/replit/upm/internal/util"
+)
+
+// licensedPackage is a package in the lockfile as 'upm licenses'
+// reports it.
+type licensedPackage struct {
+	Name    api.PkgName    `json:"name"`
+	Version api.PkgVersion `json:"version"`
+	// The license as the registry gives it, or empty if it gives
+	// none.
+	License string `json:"license"`
+}
+
+// unknownLicense is what the report says for a package whose registry
+// gives no license.
+const unknownLicense = "(unknown)"
+
+// collectLicenses returns the license of every package in the lockfile
+// of the given backend, sorted by normalized name. The licenses are
+// looked up with Info, and cached in the store for --cache-ttl for
+// each version of each package.
+func collectLicenses(ctx context.Context, b api.LanguageBackend) []licensedPackage {
+	if !util.Exists(b.Lockfile) {
+		util.Die("%s does not exist", b.Lockfile)
+	}
+	s := silenceSubroutines()
+	locked := b.ListLockfile()
+	s.restore()
+
+	names := []api.PkgName{}
+	for name := range locked {
+		names = append(names, name)
+	}
+	sortPkgNames(b, names)
+
+	cache := store.GetLicenses(b)
+	found := map[string]store.CachedLicense{}
+	pkgs := []licensedPackage{}
+	for _, name := range names {
+		key := fmt.Sprintf("%s@%s", b.NormalizePackageName(name), locked[name])
+		cached, ok := cache[key]
+		if !ok || !cached.Fresh() {
+			cached = store.CachedLicense{
+				Fetched: time.Now().UTC(),
+				License: b.Info(ctx, name).License,
+			}
+		}
+		found[key] = cached
+		pkgs = append(pkgs, licensedPackage{
+			Name:    name,
+			Version: locked[name],
+			License: cached.License,
+		})
+	}
+	store.SetLicenses(b, found)
+	return pkgs
+}
+
+// groupLicenses returns the licenses of the given packages, from the
+// one the most packages have, and the packages that have each. The
+// packages of unknown license come last.
+func groupLicenses(pkgs []licensedPackage) ([]string, map[string][]licensedPackage) {
+	groups := map[string][]licensedPackage{}
+	licenses := []string{}
+	for _, pkg := range pkgs {
+		license := pkg.License
+		if license == "" {
+			license = unknownLicense
+		}
+		if _, ok := groups[license]; !ok {
+			licenses = append(licenses, license)
+		}
+		groups[license] = append(groups[license], pkg)
+	}
+	sort.Slice(licenses, func(i, j int) bool {
+		li, lj := licenses[i], licenses[j]
+		if (li == unknownLicense) != (lj == unknownLicense) {
+			return lj == unknownLicense
+		}
+		if len(groups[li]) != len(groups[lj]) {
+			return len(groups[li]) > len(groups[lj])
+		}
+		return li < lj
+	})
+	return licenses, groups
+}
+
+// runLicenses implements 'upm licenses', in the given format: "table",
+// which groups the packages by license, or "json" or "csv", which list
+// them by name.
+func runLicenses(ctx context.Context, language string, format string) {
+	if format != "table" && format != "json" && format != "csv" {
+		util.Die(`Error: invalid format %#v (must be "table", "json", or "csv")`, format)
+	}
+	b := backends.GetBackend(language)
+	pkgs := collectLicenses(ctx, b)
+	store.Write()
+
+	switch format {
+	case "table":
+		if len(pkgs) == 0 {
+			util.Log(fmt.Sprintf("%s lists no packages", b.Lockfile))
+			return
+		}
+		licenses, groups := groupLicenses(pkgs)
+		for i, license := range licenses {
+			if i > 0 {
+				fmt.Println()
+			}
+			fmt.Printf("%s (%d)\n", license, len(groups[license]))
+			for _, pkg := range groups[license] {
+				fmt.Printf("  %s %s\n", pkg.Name, pkg.Version)
+			}
+		}
+
+	case "json":
+		outputB, err := json.Marshal(pkgs)
+		if err != nil {
+			panic("couldn't marshal json")
+		}
+		fmt.Println(string(outputB))
+
+	case "csv":
+		w := csv.NewWriter(os.Stdout)
+		w.Write([]string{"name", "version", "license"})
+		for _, pkg := range pkgs {
+			w.Write([]string{string(pkg.Name), string(pkg.Version), pkg.License})
+		}
+		w.Flush()
+		if err := w.Error(); err != nil {
+			util.Die("%s", err)
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package cli
+
+import (
+	"fmt"
+	"io/ioutil"
+
+	"github.com/replit/upm/internal/api"
+	"github.com/replit/upm/internal/policy"
+	"github.com/replit/upm/internal/util"
+)
+
+// limitCheck tracks a change to a project's packages that should be
+// checked against the dependency limits of the policy once it is done.
+// A nil *limitCheck means that the policy sets no limits.
+type limitCheck struct {
+	b api.LanguageBackend
+	// The lockfile before the change, or nil if there was none.
+	lockfile []byte
+}
+
+// startLimitCheck starts checking a change to the given backend's
+// dependencies against the dependency limits of the policy, if it sets
+// any. It should be called right before the change is made.
+func startLimitCheck(b api.LanguageBackend) *limitCheck {
+	if !policy.DependencyLimits.Any() {
+		return nil
+	}
+
+	l := &limitCheck{b: b}
+	if contents, err := ioutil.ReadFile(b.Lockfile); err == nil {
+		l.lockfile = contents
+	}
+	return l
+}
+
+// limitsRollBack returns true if a change that exceeds the dependency
+// limits of the policy has to be rolled back, rather than only warned
+// about, so that a snapshot has to be taken before it is made.
+func limitsRollBack() bool {
+	limits := policy.DependencyLimits
+	return limits.Any() && !limits.Warn()
+}
+
+// exceeded checks the dependencies of the current directory against
+// the dependency limits of the policy, if the lockfile has changed
+// since startLimitCheck, saying which limits they exceed. It returns
+// true if they exceed any and the policy doesn't only warn.
+func (l *limitCheck) exceeded() bool {
+	if l == nil || !util.Exists(l.b.Lockfile) {
+		return false
+	}
+	contents, err := ioutil.ReadFile(l.b.Lockfile)
+	if err != nil {
+		util.Die("%s: %s", l.b.Lockfile, err)
+	}
+	if l.lockfile != nil && string(contents) == string(l.lockfile) {
+		return false
+	}
+
+	limits := policy.DependencyLimits
+	messages := limits.Check(countDependencies(l.b, limits))
+	prefix := "error: "
+	if limits.Warn() {
+		prefix = "warning: "
+	}
+	for _, message := range messages {
+		util.Log(prefix + message)
+	}
+	return len(messages) > 0 && !limits.Warn()
+}
+
+// countDependencies returns how many direct dependencies the given
+// backend's specfile lists, how many packages its lockfile lists, and
+// how deep the dependencies go, for the given limits. The depth is -1
+// if the limits don't set one, or if the backend can't list the
+// dependencies of the packages in its lockfile, which is said.
+func countDependencies(b api.LanguageBackend, limits policy.Limits) (int, int, int) {
+	s := silenceSubroutines()
+	direct := []string{}
+	if util.Exists(b.Specfile) {
+		for name := range b.ListSpecfile() {
+			direct = append(direct, string(b.NormalizePackageName(name)))
+		}
+	}
+	locked := len(b.ListLockfile())
+	var graph map[api.PkgName][]api.PkgName
+	if limits.MaxDepth > 0 && b.ListLockfileDependencies != nil {
+		graph = b.ListLockfileDependencies()
+	}
+	s.restore()
+
+	depth := -1
+	switch {
+	case limits.MaxDepth == 0:
+		break
+	case graph == nil:
+		util.Log(fmt.Sprintf("warning: %s can't list the dependencies of its packages, "+
+			"so their depth isn't checked", b.Name))
+	default:
+		deps := map[string][]string{}
+		for pkg, on := range graph {
+			names := []string{}
+			for _, name := range on {
+				names = append(names, string(b.NormalizePackageName(name)))
+			}
+			deps[string(b.NormalizePackageName(pkg))] = names
+		}
+		depth = policy.Depth(direct, deps)
+	}
+	return len(direct), locked, depth
+}
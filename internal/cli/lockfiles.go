@@ -0,0 +1,56 @@
+package cli
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/replit/upm/internal/api"
+	"github.com/replit/upm/internal/backends"
+	"github.com/replit/upm/internal/util"
+)
+
+// requirementsFile is the file that the lockfiles of the Python
+// backends are usually exported to, which goes stale unless it is
+// listed in the exports of the project config.
+const requirementsFile = "requirements.txt"
+
+// warnIgnoredLockfiles warns about the flavors of the lockfile of the
+// given backend that exist but that its package manager ignores, since
+// locking won't update them.
+func warnIgnoredLockfiles(b api.LanguageBackend) {
+	for _, ignored := range backends.IgnoredLockfiles(b) {
+		util.Log(fmt.Sprintf("warning: %s is ignored, since %s takes precedence, so it won't be updated (delete it if it isn't needed)",
+			ignored, b.Lockfile))
+	}
+}
+
+// runShowLockfileAll implements 'upm show-lockfile --all'. It lists
+// each file that the lockfile of the backend is, or could be, kept in,
+// and whether UPM takes it to be authoritative, ignores it, or keeps
+// it in sync with the one that is.
+func runShowLockfileAll(language string) {
+	b := backends.GetBackend(language)
+	if util.Exists(b.Lockfile) {
+		fmt.Printf("%s: authoritative\n", b.Lockfile)
+	} else {
+		fmt.Printf("%s: authoritative (doesn't exist yet)\n", b.Lockfile)
+	}
+	for _, ignored := range backends.IgnoredLockfiles(b) {
+		fmt.Printf("%s: ignored, since %s takes precedence\n", ignored, b.Lockfile)
+	}
+
+	exported := map[string]bool{}
+	for _, e := range exportsOf(b) {
+		exported[e.File] = true
+		what := "the main dependencies"
+		if len(e.Groups) > 0 {
+			what += " and " + strings.Join(e.Groups, ", ")
+		}
+		fmt.Printf("%s: exported from %s (%s), and kept in sync with it\n", e.File, b.Lockfile, what)
+	}
+	if b.ExportLockfile != nil && b.Specfile != requirementsFile &&
+		!exported[requirementsFile] && util.Exists(requirementsFile) {
+		fmt.Printf("%s: not kept in sync with %s (list it in the exports of .upm/config.toml to have it exported)\n",
+			requirementsFile, b.Lockfile)
+	}
+}
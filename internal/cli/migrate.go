@@ -0,0 +1,145 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/replit/upm/internal/api"
+	"github.com/replit/upm/internal/backends"
+	"github.com/replit/upm/internal/util"
+)
+
+// legacySpecfile returns the first of the legacy specfiles of the
+// given backend that exists, or the empty string if there is none or
+// the backend doesn't have any.
+func legacySpecfile(b api.LanguageBackend) string {
+	if b.ListLegacySpecfile == nil {
+		return ""
+	}
+	for _, filename := range b.LegacySpecfiles {
+		if util.Exists(filename) {
+			return filename
+		}
+	}
+	return ""
+}
+
+// checkNotLegacy terminates the process if the project declares its
+// dependencies in a legacy specfile rather than in the specfile, since
+// adding packages would then create a specfile that leaves the others
+// out.
+func checkNotLegacy(b api.LanguageBackend) {
+	if util.Exists(b.Specfile) {
+		return
+	}
+	if legacy := legacySpecfile(b); legacy != "" {
+		util.Die("this project declares its dependencies in %s, which %s doesn't read; "+
+			"run 'upm migrate' to move them to %s first", legacy, b.Name, b.Specfile)
+	}
+}
+
+// checkRuntime terminates the process if the specfile only allows
+// versions of the language that are no longer supported, or has
+// packages that are only required on them, since locking would then at
+// best keep a project going that can't be run anywhere worth running
+// it, and at worst fail with a conflict that doesn't say why.
+func checkRuntime(b api.LanguageBackend) {
+	if b.CheckRuntime == nil {
+		return
+	}
+	problem, pkgs := b.CheckRuntime()
+	problems := []string{}
+	if problem != "" {
+		problems = append(problems, problem)
+	}
+	if len(pkgs) > 0 {
+		names := []string{}
+		for _, name := range pkgs {
+			names = append(names, string(name))
+		}
+		problems = append(problems, fmt.Sprintf(
+			"%s has packages that are only required on versions of Python that are no longer supported: %s",
+			b.Specfile, strings.Join(names, ", ")))
+	}
+	if len(problems) > 0 {
+		util.Die("%s; run 'upm migrate --python3' to fix this",
+			strings.Join(problems, "; "))
+	}
+}
+
+// runMigrate implements 'upm migrate'. With python3, it migrates the
+// project to a supported version of the language instead; see
+// runMigrateRuntime.
+func runMigrate(ctx context.Context, language string, python3 bool, forceLock bool, forceInstall bool,
+	name string, commit bool, branch string) {
+
+	b := backends.GetBackend(language)
+	if python3 {
+		runMigrateRuntime(ctx, b, forceLock, forceInstall, commit, branch)
+		return
+	}
+	if b.ListLegacySpecfile == nil {
+		util.Die("%s does not support migration", b.Name)
+	}
+	if util.Exists(b.Specfile) {
+		util.Die("%s already exists", b.Specfile)
+	}
+	legacy := legacySpecfile(b)
+	if legacy == "" {
+		util.Die("no %s to migrate from", strings.Join(b.LegacySpecfiles, " or "))
+	}
+
+	pkgs := b.ListLegacySpecfile()
+	if len(pkgs) == 0 {
+		util.Die("%s does not declare any dependencies", legacy)
+	}
+	c := startCommit(ctx, commit, branch)
+
+	p := newPlan(b)
+	p.change("add "+formatPkgs(pkgs)+" from "+legacy, func() {
+		b.Add(ctx, pkgs, name)
+	})
+	p.lockAndInstallAfterChange(ctx, true, forceLock, forceInstall)
+
+	h := p.execute(ctx)
+	c.finish(ctx, h, commitMessage("migrate", pkgs))
+}
+
+// runMigrateRuntime implements 'upm migrate --python3', rewriting the
+// constraints on the version of the language that checkRuntime objects
+// to and removing the packages that are only required on unsupported
+// versions, then locking again.
+func runMigrateRuntime(ctx context.Context, b api.LanguageBackend, forceLock bool, forceInstall bool,
+	commit bool, branch string) {
+
+	if b.CheckRuntime == nil || b.UpgradeRuntime == nil {
+		util.Die("%s does not support --python3", b.Name)
+	}
+	if !util.Exists(b.Specfile) {
+		util.Die("%s does not exist", b.Specfile)
+	}
+	problem, pkgs := b.CheckRuntime()
+	if problem == "" && len(pkgs) == 0 {
+		util.Die("%s already only requires supported versions of Python", b.Specfile)
+	}
+	c := startCommit(ctx, commit, branch)
+
+	p := newPlan(b)
+	if problem != "" {
+		p.edit("require a supported version of Python in "+b.Specfile, b.UpgradeRuntime)
+	}
+	if len(pkgs) > 0 {
+		names := map[api.PkgName]bool{}
+		for _, name := range pkgs {
+			names[name] = true
+		}
+		p.change("remove "+formatNames(names)+", only required on unsupported versions", func() {
+			b.Remove(ctx, names)
+		})
+	}
+	p.lockAndInstallAfterChange(ctx, len(pkgs) > 0, forceLock, forceInstall)
+
+	h := p.execute(ctx)
+	c.finish(ctx, h, commitMessage("migrate to Python 3", nil))
+}
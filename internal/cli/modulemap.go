@@ -0,0 +1,155 @@
+package cli
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/replit/upm/internal/api"
+	"github.com/replit/upm/internal/backends/nodejs"
+	"github.com/replit/upm/internal/backends/python"
+	"github.com/replit/upm/internal/backends/python/pypimap"
+	"github.com/replit/upm/internal/backends/ruby"
+	"github.com/replit/upm/internal/config"
+	"github.com/replit/upm/internal/store"
+	"github.com/replit/upm/internal/table"
+	"github.com/replit/upm/internal/util"
+)
+
+// defaultMapURL returns where 'upm index update' downloads the module
+// map from by default: UPM_PYPI_MAP_URL, or else where it is published
+// with each release of UPM.
+func defaultMapURL() string {
+	if url := os.Getenv("UPM_PYPI_MAP_URL"); url != "" {
+		return url
+	}
+	return pypimap.DefaultPublishedURL
+}
+
+// runMapUpdate implements 'upm index update'. It downloads the module
+// map published at the given URL, checks all of it, and only then puts
+// it where the Python backends read it, so that a failed or corrupt
+// download leaves the last map in place.
+func runMapUpdate(ctx context.Context, url string) {
+	file := pypimap.UpdatedMapFile()
+	if file == "" {
+		util.Die("there is no cache directory to put the module map in (set UPM_PYPI_MAP)")
+	}
+
+	if config.DryRun {
+		fmt.Printf("1. download %s to %s\n", url, file)
+		return
+	}
+	util.RefuseIfReadOnly("write " + file)
+
+	util.ProgressMsg("download " + url)
+	resp, err := util.HTTPGet(ctx, url)
+	if err != nil {
+		util.Die("%s", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		util.Die("%s: %s", url, resp.Status)
+	}
+	contents, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		util.Abort(ctx)
+		util.Die("%s: %s", url, err)
+	}
+
+	published, err := pypimap.ParsePublished(contents)
+	if err != nil {
+		util.Die("%s isn't a module map UPM can use: %s", url, err)
+	}
+	counts, err := published.Check()
+	if err != nil {
+		util.Die("%s isn't a module map UPM can use: %s", url, err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(file), 0777); err != nil {
+		util.Die("%s", err)
+	}
+	partial := file + ".partial"
+	if err := ioutil.WriteFile(partial, contents, 0666); err != nil {
+		util.Die("%s", err)
+	}
+	if err := os.Rename(partial, file); err != nil {
+		util.Die("%s", err)
+	}
+
+	util.Log(fmt.Sprintf("updated the module map in %s: %d modules and %d packages, generated %s",
+		file, counts.Modules, counts.Packages, published.Generated))
+}
+
+// indexStatus is the map of an ecosystem as 'upm index status' reports
+// it.
+type indexStatus struct {
+	api.MapStatus
+	// How the lookups of information about packages were answered,
+	// or nil if it isn't cached.
+	Cache *store.PkgInfoLookups `json:"cache,omitempty"`
+}
+
+// collectIndexStatus returns the status of the map of each ecosystem,
+// in the order of genMapEcosystems.
+func collectIndexStatus() []indexStatus {
+	statuses := []indexStatus{}
+	for _, status := range []api.MapStatus{
+		python.MapStatus(), nodejs.MapStatus(), ruby.MapStatus(),
+	} {
+		row := indexStatus{MapStatus: status}
+		if status.CachesInfo {
+			lookups := store.GetPkgInfoLookups(status.Ecosystem)
+			row.Cache = &lookups
+		}
+		statuses = append(statuses, row)
+	}
+	return statuses
+}
+
+// runMapStatus implements 'upm index status'.
+func runMapStatus(outputFormat outputFormat) {
+	statuses := collectIndexStatus()
+
+	switch outputFormat {
+	case outputFormatTable:
+		t := table.New("ecosystem", "map", "generated", "imports", "cache hits", "search")
+		for _, status := range statuses {
+			source := "built in"
+			if status.File != "" {
+				source = status.File
+			}
+			generated := status.Generated
+			if generated == "" {
+				generated = "unknown"
+			}
+			hits := "not cached"
+			if lookups := status.Cache; lookups != nil {
+				hits = "no lookups"
+				if total := lookups.Hits + lookups.Misses; total > 0 {
+					hits = fmt.Sprintf("%d%% of %d", 100*lookups.Hits/total, total)
+				}
+			}
+			search := status.RemoteSearch
+			if search == "" {
+				search = "the map"
+			}
+			t.AddRow(status.Ecosystem, source, generated,
+				fmt.Sprint(status.Imports), hits, search)
+		}
+		t.Print()
+
+	case outputFormatJSON:
+		outputB, err := json.Marshal(statuses)
+		if err != nil {
+			panic("couldn't marshal json")
+		}
+		fmt.Println(string(outputB))
+
+	default:
+		util.Panicf("unknown output format %d", outputFormat)
+	}
+}
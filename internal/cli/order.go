@@ -0,0 +1,61 @@
+package cli
+
+import (
+	"sort"
+
+	"github.com/replit/upm/internal/api"
+)
+
+// pkgNameLess returns true if a package with the first name is printed
+// before one with the second. Packages are ordered by normalized name,
+// and then by name for those whose names only differ once normalized.
+// Names are compared byte by byte, so the order is the same whatever
+// the locale, and the same from one run to the next, which tools that
+// diff the output rely on.
+func pkgNameLess(b api.LanguageBackend, name1 api.PkgName, name2 api.PkgName) bool {
+	norm1 := b.NormalizePackageName(name1)
+	norm2 := b.NormalizePackageName(name2)
+	if norm1 != norm2 {
+		return norm1 < norm2
+	}
+	return name1 < name2
+}
+
+// sortPkgNames sorts the given package names as described for
+// pkgNameLess.
+func sortPkgNames(b api.LanguageBackend, names []api.PkgName) {
+	sort.Slice(names, func(i, j int) bool {
+		return pkgNameLess(b, names[i], names[j])
+	})
+}
+
+// sortedSpecNames returns the names of the given packages from a
+// specfile, sorted as described for pkgNameLess.
+func sortedSpecNames(b api.LanguageBackend, pkgs map[api.PkgName]api.PkgSpec) []api.PkgName {
+	names := []api.PkgName{}
+	for name := range pkgs {
+		names = append(names, name)
+	}
+	sortPkgNames(b, names)
+	return names
+}
+
+// sortedVersionNames returns the names of the given packages from a
+// lockfile, sorted as described for pkgNameLess.
+func sortedVersionNames(b api.LanguageBackend, pkgs map[api.PkgName]api.PkgVersion) []api.PkgName {
+	names := []api.PkgName{}
+	for name := range pkgs {
+		names = append(names, name)
+	}
+	sortPkgNames(b, names)
+	return names
+}
+
+// sortPkgInfo sorts the lists in the given package info, since many
+// backends build them from maps, so that it prints the same every
+// time.
+func sortPkgInfo(info *api.PkgInfo) {
+	sort.Strings(info.Dependencies)
+	sort.Strings(info.Extras)
+	sort.Strings(info.Keywords)
+}
@@ -0,0 +1,158 @@
+package cli
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	goversion "github.com/hashicorp/go-version"
+	"github.com/replit/upm/internal/api"
+	"github.com/replit/upm/internal/backends"
+	"github.com/replit/upm/internal/report"
+	"github.com/replit/upm/internal/store"
+	"github.com/replit/upm/internal/table"
+	"github.com/replit/upm/internal/util"
+)
+
+// outdatedPackage is a package in the specfile whose locked version
+// isn't the latest one, as 'upm outdated' lists it.
+type outdatedPackage struct {
+	Name api.PkgName `json:"name"`
+	// The locked version, or empty if the package isn't locked.
+	Current api.PkgVersion `json:"current"`
+	// The newest version that the spec allows, or empty if the
+	// backend can't tell.
+	Wanted api.PkgVersion `json:"wanted,omitempty"`
+	Latest api.PkgVersion `json:"latest"`
+	Spec   api.PkgSpec    `json:"spec"`
+}
+
+// isOlder returns whether the current version is older than the
+// latest one. Versions that can't be compared are older if they differ
+// at all.
+func isOlder(current api.PkgVersion, latest api.PkgVersion) bool {
+	currentV, err := goversion.NewVersion(string(current))
+	if err != nil {
+		return current != latest
+	}
+	latestV, err := goversion.NewVersion(string(latest))
+	if err != nil {
+		return current != latest
+	}
+	return currentV.LessThan(latestV)
+}
+
+// collectOutdated looks up the latest version of every package in the
+// specfile, and returns those whose locked version is older, sorted by
+// name. A package that isn't in the registry, such as a local one, is
+// left out, as are editable and linked packages, which are installed
+// from a directory whatever the registry has.
+func collectOutdated(ctx context.Context, b api.LanguageBackend) []outdatedPackage {
+	pkgs := []outdatedPackage{}
+	if !util.Exists(b.Specfile) {
+		return pkgs
+	}
+	locked := map[api.PkgName]api.PkgVersion{}
+	if util.Exists(b.Lockfile) {
+		for name, v := range b.ListLockfile() {
+			locked[b.NormalizePackageName(name)] = v
+		}
+	}
+	local := map[api.PkgName]bool{}
+	for name := range store.GetEditable(b) {
+		local[b.NormalizePackageName(name)] = true
+	}
+	for name := range store.GetLinks(b) {
+		local[name] = true
+	}
+	for name, spec := range b.ListSpecfile() {
+		if local[b.NormalizePackageName(name)] {
+			continue
+		}
+		latest := api.PkgVersion(b.Info(ctx, name).Version)
+		if latest == "" {
+			continue
+		}
+		current := locked[b.NormalizePackageName(name)]
+		if current != "" && !isOlder(current, latest) {
+			continue
+		}
+		pkg := outdatedPackage{
+			Name:    name,
+			Current: current,
+			Latest:  latest,
+			Spec:    spec,
+		}
+		if b.WantedVersion != nil {
+			pkg.Wanted = b.WantedVersion(ctx, name, spec)
+		}
+		pkgs = append(pkgs, pkg)
+	}
+	sort.Slice(pkgs, func(i, j int) bool {
+		return pkgNameLess(b, pkgs[i].Name, pkgs[j].Name)
+	})
+	return pkgs
+}
+
+// outdatedReport returns the report of the given outdated packages
+// for a sink.
+func outdatedReport(b api.LanguageBackend, pkgs []outdatedPackage) report.Report {
+	lines := []string{}
+	for _, pkg := range pkgs {
+		current := string(pkg.Current)
+		if current == "" {
+			current = "not locked"
+		}
+		lines = append(lines, fmt.Sprintf("%s %s (is %s)", pkg.Name, pkg.Latest, current))
+	}
+	title := "1 outdated package"
+	if len(pkgs) != 1 {
+		title = fmt.Sprintf("%d outdated packages", len(pkgs))
+	}
+	return report.Report{
+		Command:  "outdated",
+		Language: b.Name,
+		Title:    title,
+		Lines:    lines,
+		Items:    pkgs,
+	}
+}
+
+// runOutdated implements 'upm outdated'. If there are outdated
+// packages, they are also posted to the given sink, if any.
+func runOutdated(ctx context.Context, language string, sink *report.Sink, outputFormat outputFormat) {
+	b := backends.GetBackend(language)
+	pkgs := collectOutdated(ctx, b)
+	store.Write()
+	if sink != nil && len(pkgs) > 0 {
+		sink.Post(outdatedReport(b, pkgs))
+	}
+
+	switch outputFormat {
+	case outputFormatTable:
+		if len(pkgs) == 0 {
+			util.Log("all packages are up to date")
+			return
+		}
+		t := table.New("name", "current", "wanted", "latest")
+		for _, pkg := range pkgs {
+			current := string(pkg.Current)
+			if current == "" {
+				current = "(not locked)"
+			}
+			t.AddRow(string(pkg.Name), current, string(pkg.Wanted), string(pkg.Latest))
+		}
+		t.Print()
+
+	case outputFormatJSON:
+		outputB, err := json.Marshal(pkgs)
+		if err != nil {
+			panic("couldn't marshal json")
+		}
+		fmt.Println(string(outputB))
+
+	default:
+		util.Panicf("unknown output format %d", outputFormat)
+	}
+}
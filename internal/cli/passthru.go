@@ -0,0 +1,277 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/kballard/go-shellquote"
+
+	"github.com/replit/upm/internal/backends"
+	"github.com/replit/upm/internal/config"
+	"github.com/replit/upm/internal/util"
+)
+
+// nativeTool describes a package manager whose common commands 'upm
+// passthru' knows the equivalents of.
+type nativeTool struct {
+	// The --lang of the backend that uses the tool.
+	language string
+	// Map from the subcommands of the tool to the equivalent
+	// commands of UPM: "add", "remove", "install", or "lock". The
+	// empty subcommand is the tool run with no arguments.
+	subcommands map[string]string
+	// Map from the flags that may be given with those subcommands
+	// to what they mean: "dev" for a development dependency, or ""
+	// if they can be ignored. A command with any other flag is
+	// passed through.
+	flags map[string]string
+	// Whether packages are given as npm does, as NAME@SPEC, rather
+	// than as a Python requirement such as NAME==SPEC.
+	npmSpecs bool
+}
+
+// nativeTools holds the tools that 'upm passthru' knows, by the name
+// they are run with.
+var nativeTools = map[string]nativeTool{
+	"pip": {
+		language:    "python3-pip",
+		subcommands: map[string]string{"install": "add", "uninstall": "remove"},
+		flags:       map[string]string{"-y": "", "--yes": ""},
+	},
+	"poetry": {
+		language: "python3-poetry",
+		subcommands: map[string]string{
+			"add": "add", "remove": "remove", "install": "install", "lock": "lock",
+		},
+		flags: map[string]string{"-D": "dev", "--dev": "dev", "--group=dev": "dev"},
+	},
+	"uv": {
+		language: "python3-uv",
+		subcommands: map[string]string{
+			"add": "add", "remove": "remove", "sync": "install", "lock": "lock",
+		},
+		flags: map[string]string{"--dev": "dev"},
+	},
+	"pdm": {
+		language: "python3-pdm",
+		subcommands: map[string]string{
+			"add": "add", "remove": "remove", "install": "install", "sync": "install", "lock": "lock",
+		},
+		flags: map[string]string{"-d": "dev", "--dev": "dev"},
+	},
+	"npm": {
+		language: "nodejs-npm",
+		subcommands: map[string]string{
+			"install": "add", "i": "add", "add": "add", "ci": "install",
+			"uninstall": "remove", "remove": "remove", "rm": "remove", "un": "remove", "r": "remove",
+		},
+		flags: map[string]string{
+			"-D": "dev", "--save-dev": "dev", "-S": "", "--save": "", "-P": "", "--save-prod": "",
+		},
+		npmSpecs: true,
+	},
+	"yarn": {
+		language: "nodejs-yarn",
+		subcommands: map[string]string{
+			"": "install", "install": "install", "add": "add", "remove": "remove",
+		},
+		flags:    map[string]string{"-D": "dev", "--dev": "dev"},
+		npmSpecs: true,
+	},
+	"bundle": {
+		language: "ruby-bundler",
+		subcommands: map[string]string{
+			"add": "add", "remove": "remove", "install": "install", "lock": "lock",
+		},
+		flags: map[string]string{},
+	},
+}
+
+// pip3 is pip by another name.
+func init() {
+	nativeTools["pip3"] = nativeTools["pip"]
+}
+
+// nativeEquivalent is the command of UPM that does the same as an
+// invocation of a package manager.
+type nativeEquivalent struct {
+	language string
+	// "add", "remove", "install", or "lock".
+	command string
+	// The packages, as for 'upm add' or 'upm remove'.
+	pkgs []string
+	dev  bool
+}
+
+// String returns the equivalent as it would be typed.
+func (e nativeEquivalent) String() string {
+	cmd := []string{"upm", "-l", e.language, e.command}
+	if e.dev {
+		cmd = append(cmd, "--dev")
+	}
+	return shellquote.Join(append(cmd, e.pkgs...)...)
+}
+
+// translateNative returns the command of UPM that does the same as the
+// given invocation of a package manager, if there is one that it is
+// sure of.
+func translateNative(cmd []string) (nativeEquivalent, bool) {
+	tool, ok := nativeTools[cmd[0]]
+	if !ok {
+		return nativeEquivalent{}, false
+	}
+	subcommand := ""
+	if len(cmd) > 1 {
+		subcommand = cmd[1]
+	}
+	command, ok := tool.subcommands[subcommand]
+	if !ok {
+		return nativeEquivalent{}, false
+	}
+
+	e := nativeEquivalent{language: tool.language, command: command}
+	args := []string{}
+	if len(cmd) > 2 {
+		args = cmd[2:]
+	}
+	for _, arg := range args {
+		if !strings.HasPrefix(arg, "-") {
+			pkg, ok := translateNativePackage(arg, tool.npmSpecs)
+			if !ok {
+				return nativeEquivalent{}, false
+			}
+			e.pkgs = append(e.pkgs, pkg)
+			continue
+		}
+		meaning, ok := tool.flags[arg]
+		if !ok {
+			return nativeEquivalent{}, false
+		}
+		if meaning == "dev" {
+			e.dev = true
+		}
+	}
+
+	switch e.command {
+	case "add":
+		// Without packages, 'npm install' installs what is
+		// locked, and 'pip install' does nothing.
+		if len(e.pkgs) == 0 {
+			if !tool.npmSpecs {
+				return nativeEquivalent{}, false
+			}
+			e.command = "install"
+			if e.dev {
+				return nativeEquivalent{}, false
+			}
+		}
+	case "remove":
+		if len(e.pkgs) == 0 || e.dev {
+			return nativeEquivalent{}, false
+		}
+		for i, pkg := range e.pkgs {
+			// A version means nothing to upm remove.
+			e.pkgs[i] = strings.SplitN(pkg, " ", 2)[0]
+		}
+	case "install", "lock":
+		if len(e.pkgs) > 0 || e.dev {
+			return nativeEquivalent{}, false
+		}
+	}
+	return e, true
+}
+
+// translateNativePackage returns the given package, as given to a
+// package manager, in the form that 'upm add' takes: the name,
+// followed by the spec if there is one after a space. With npmSpecs,
+// it is given as NAME@SPEC, where the name may be scoped, as in
+// @types/node@20; otherwise, it is a Python requirement, as in
+// flask>=2. Packages that aren't in the registry, such as paths and
+// URLs, have no equivalent.
+func translateNativePackage(pkg string, npmSpecs bool) (string, bool) {
+	if strings.ContainsAny(pkg, "/:") && !(npmSpecs && strings.HasPrefix(pkg, "@")) {
+		return "", false
+	}
+	if npmSpecs {
+		at := strings.LastIndexByte(pkg, '@')
+		if at <= 0 {
+			return pkg, true
+		}
+		return pkg[:at] + " " + pkg[at+1:], true
+	}
+	if strings.ContainsAny(pkg, "@; ") {
+		return "", false
+	}
+	i := strings.IndexAny(pkg, "<>=!~")
+	if i < 0 {
+		return pkg, true
+	}
+	return pkg[:i] + " " + pkg[i:], true
+}
+
+// runPassthru implements 'upm passthru'. The given invocation of a
+// package manager is done with the equivalent command of UPM if there
+// is one, so that the specfile, lockfile, and store stay in sync, and
+// run as is otherwise, with the exit status of the package manager.
+func runPassthru(ctx context.Context, cmd []string, ignoredPackages []string) {
+	e, ok := translateNative(cmd)
+	// A backend without dependency groups can't add development
+	// dependencies, but the package manager itself can.
+	if ok && e.dev && backends.GetBackend(e.language).AddToGroup == nil {
+		ok = false
+	}
+	if ok {
+		if config.ReadOnly && !config.DryRun {
+			util.Die("upm %s modifies the project, so it can't be used in read-only mode", e.command)
+		}
+		util.Log("running " + e.String())
+		switch e.command {
+		case "add":
+			group := ""
+			if e.dev {
+				group = "dev"
+			}
+			runAdd(ctx, e.language, e.pkgs, false, false, false, ignoredPackages,
+				false, false, "", group, false, "")
+		case "remove":
+			runRemove(ctx, e.language, e.pkgs, false, false, false, false, false, "")
+		case "install":
+			runInstall(ctx, e.language, false)
+		case "lock":
+			runLock(ctx, e.language, false, nil, false, false, false, false, "")
+		}
+		return
+	}
+
+	// UPM can't tell what the command would change, so it is a step
+	// of its own.
+	if config.DryRun {
+		fmt.Printf("1. run %s\n", shellquote.Join(cmd...))
+		return
+	}
+	util.RunInteractiveCmd(ctx, cmd)
+}
+
+// runAlias implements 'upm alias', printing the definitions of shell
+// functions that run each package manager that 'upm passthru' knows
+// through it.
+func runAlias(shell string) {
+	tools := []string{}
+	for tool := range nativeTools {
+		tools = append(tools, tool)
+	}
+	sort.Strings(tools)
+
+	for _, tool := range tools {
+		switch shell {
+		case "bash", "zsh", "sh":
+			fmt.Printf("%s() { upm passthru -- %s \"$@\"; }\n", tool, tool)
+		case "fish":
+			fmt.Printf("function %s; upm passthru -- %s $argv; end\n", tool, tool)
+		default:
+			util.Die("unknown shell %q (use bash, zsh, sh, or fish)", shell)
+		}
+	}
+}
@@ -0,0 +1,458 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/replit/upm/internal/api"
+	"github.com/replit/upm/internal/config"
+	"github.com/replit/upm/internal/history"
+	"github.com/replit/upm/internal/snapshot"
+	"github.com/replit/upm/internal/store"
+	"github.com/replit/upm/internal/util"
+)
+
+// installedPackages stands for the installed packages in the list of
+// what a step changes, since finding out where they are can mean
+// running the package manager.
+const installedPackages = "installed packages"
+
+// step is one operation of a plan, such as adding packages or
+// locking.
+type step struct {
+	// What the step does, such as "add flask".
+	summary string
+
+	// Why the step is in the plan, for --explain.
+	reason string
+
+	// The files that the step may change.
+	changes []string
+
+	// True if the step installs packages, so that the post-install
+	// hooks have to be run after it.
+	installs bool
+
+	run func()
+}
+
+// plan is the sequence of steps that a command takes to change the
+// packages of a project, such as adding a package and then locking and
+// installing. The whole sequence is worked out before any of it is
+// run, so that it can be shown with --dry-run or --explain, and it is
+// run with a single snapshot and a single update of the store.
+//
+// While steps are added, the plan keeps track of what the project
+// will look like once the steps so far have been run, which is what
+// decides whether locking or installing is needed.
+type plan struct {
+	b     api.LanguageBackend
+	steps []step
+	// The steps that export the lockfile, which are run after the
+	// others, in the project itself even with --canary.
+	exports []step
+
+	specfileExists  bool
+	lockfileExists  bool
+	specfileChanged bool
+	lockfileChanged bool
+	profileChanged  bool
+
+	// True once the plan has been printed by a dry run.
+	described bool
+}
+
+// newPlan returns an empty plan for the given backend, starting from
+// the current state of the project.
+func newPlan(b api.LanguageBackend) *plan {
+	return &plan{
+		b:               b,
+		specfileExists:  util.Exists(b.Specfile),
+		lockfileExists:  util.Exists(b.Lockfile),
+		specfileChanged: store.HasSpecfileChanged(b),
+		lockfileChanged: store.HasLockfileChanged(b),
+		profileChanged:  store.HasProfileChanged(b),
+	}
+}
+
+// formatPkgs returns the given packages with their specs, sorted, for
+// the summary of a step: for example "flask ^3.0, requests".
+func formatPkgs(pkgs map[api.PkgName]api.PkgSpec) string {
+	entries := []string{}
+	for name, spec := range pkgs {
+		entry := string(name)
+		if spec != "" {
+			entry += " " + string(spec)
+		}
+		entries = append(entries, entry)
+	}
+	sort.Strings(entries)
+	return strings.Join(entries, ", ")
+}
+
+// formatNames returns the given package names, sorted, for the
+// summary of a step.
+func formatNames(pkgs map[api.PkgName]bool) string {
+	specs := map[api.PkgName]api.PkgSpec{}
+	for name := range pkgs {
+		specs[name] = ""
+	}
+	return formatPkgs(specs)
+}
+
+// deleteLockfile adds a step that deletes the lockfile, if there will
+// be one, so that it is generated again from scratch.
+func (p *plan) deleteLockfile(reason string) {
+	if !p.lockfileExists {
+		return
+	}
+	p.steps = append(p.steps, step{
+		summary: "delete " + p.b.Lockfile,
+		reason:  reason,
+		changes: []string{p.b.Lockfile},
+		run: func() {
+			util.ProgressMsg("delete " + p.b.Lockfile)
+			os.Remove(p.b.Lockfile)
+		},
+	})
+	p.lockfileExists = false
+	p.lockfileChanged = true
+}
+
+// deleteFile adds a step that deletes the given file, which belongs to
+// another backend, such as the lockfile of the package manager that a
+// project is moving away from.
+func (p *plan) deleteFile(filename string, reason string) {
+	p.steps = append(p.steps, step{
+		summary: "delete " + filename,
+		reason:  reason,
+		changes: []string{filename},
+		run: func() {
+			util.ProgressMsg("delete " + filename)
+			os.Remove(filename)
+		},
+	})
+}
+
+// change adds a step that changes the specfile by means of the
+// backend's Add or Remove (or another method with the same quirks),
+// which may also lock and install.
+func (p *plan) change(summary string, run func()) {
+	s := step{
+		summary: summary,
+		reason:  "requested",
+		changes: []string{p.b.Specfile},
+		run:     run,
+	}
+	if p.b.QuirksDoesAddRemoveAlsoLock() {
+		s.changes = append(s.changes, p.b.Lockfile)
+		p.lockfileExists = true
+		p.lockfileChanged = true
+	}
+	if p.b.QuirksDoesAddRemoveAlsoInstall() {
+		s.changes = append(s.changes, installedPackages)
+		s.installs = true
+	}
+	p.steps = append(p.steps, s)
+	p.specfileExists = true
+	p.specfileChanged = true
+}
+
+// edit adds a step that changes the specfile, with the given
+// function, in a way that only UPM does, rather than the package
+// manager, so that it doesn't also lock or install, unlike one added by
+// change.
+func (p *plan) edit(summary string, run func()) {
+	p.steps = append(p.steps, step{
+		summary: summary,
+		reason:  "requested",
+		changes: []string{p.b.Specfile},
+		run:     run,
+	})
+	p.specfileChanged = true
+}
+
+// lockReason returns why the lockfile has to be generated again, or
+// the empty string if it doesn't.
+func (p *plan) lockReason(forceLock bool) string {
+	switch {
+	case forceLock:
+		return "--force-lock was given"
+	case !p.lockfileExists:
+		return "there is no " + p.b.Lockfile
+	case p.specfileChanged:
+		return p.b.Specfile + " has changed since it was locked"
+	case p.profileChanged:
+		return fmt.Sprintf("%s was locked for a different profile", p.b.Lockfile)
+	}
+	return ""
+}
+
+// lock adds a step that locks, if the backend has a lockfile and it
+// needs to be generated again. It returns true if it added one.
+func (p *plan) lock(ctx context.Context, forceLock bool) bool {
+	if p.b.QuirksIsNotReproducible() || !p.specfileExists {
+		return false
+	}
+	reason := p.lockReason(forceLock)
+	if reason == "" {
+		return false
+	}
+
+	s := step{
+		summary: "lock",
+		reason:  reason,
+		changes: []string{p.b.Lockfile},
+		run: func() {
+			withProfile(ctx, p.b, p.b.Lock)
+		},
+	}
+	if p.b.QuirksDoesLockAlsoInstall() {
+		s.changes = append(s.changes, installedPackages)
+		s.installs = true
+	}
+	p.steps = append(p.steps, s)
+	p.lockfileExists = true
+	p.lockfileChanged = true
+	return true
+}
+
+// upgrade adds a step that upgrades the given packages to the latest
+// versions allowed by the specfile, and then installs them.
+func (p *plan) upgrade(ctx context.Context, pkgs map[api.PkgName]bool, forceInstall bool) {
+	if !p.specfileExists {
+		return
+	}
+	s := step{
+		summary: "upgrade " + formatNames(pkgs),
+		reason:  "requested",
+		changes: []string{p.b.Lockfile},
+		run: func() {
+			withProfile(ctx, p.b, func(ctx context.Context) {
+				p.b.Upgrade(ctx, pkgs)
+			})
+		},
+	}
+	if p.b.QuirksDoesLockAlsoInstall() {
+		s.changes = append(s.changes, installedPackages)
+		s.installs = true
+	}
+	p.steps = append(p.steps, s)
+	p.lockfileExists = true
+	p.lockfileChanged = true
+
+	if !p.b.QuirksDoesLockAlsoInstall() {
+		p.install(ctx, forceInstall)
+	}
+}
+
+// installReason returns why packages have to be installed, or the
+// empty string if they don't.
+func (p *plan) installReason(forceInstall bool) string {
+	if p.b.QuirksIsReproducible() {
+		switch {
+		case !p.lockfileExists:
+			return ""
+		case forceInstall:
+			return "--force-install was given"
+		case p.lockfileChanged:
+			return p.b.Lockfile + " has changed since it was installed"
+		}
+		return ""
+	}
+	switch {
+	case !p.specfileExists:
+		return ""
+	case forceInstall:
+		return "--force-install was given"
+	case p.specfileChanged:
+		return p.b.Specfile + " has changed since it was installed"
+	case p.profileChanged:
+		return "the packages were installed for a different profile"
+	}
+	return ""
+}
+
+// install adds a step that installs packages, if they have to be.
+func (p *plan) install(ctx context.Context, forceInstall bool) {
+	reason := p.installReason(forceInstall)
+	if reason == "" {
+		return
+	}
+	p.steps = append(p.steps, step{
+		summary:  "install",
+		reason:   reason,
+		changes:  []string{installedPackages},
+		installs: true,
+		run: func() {
+			withProfile(ctx, p.b, p.b.Install)
+		},
+	})
+}
+
+// lockAndInstall adds the steps that lock and install as needed.
+func (p *plan) lockAndInstall(ctx context.Context, forceLock bool, forceInstall bool) {
+	didLock := p.lock(ctx, forceLock)
+	if !(didLock && p.b.QuirksDoesLockAlsoInstall()) {
+		p.install(ctx, forceInstall)
+	}
+}
+
+// lockAndInstallAfterChange adds the steps that lock and install as
+// needed after the steps added by change, taking into account which
+// of them the backend does as part of those. changed is true if change
+// was called at all.
+func (p *plan) lockAndInstallAfterChange(ctx context.Context, changed bool, forceLock bool, forceInstall bool) {
+	// If the backend did lock, it was without the profile, so the
+	// lockfile has to be generated again.
+	profile := changed && config.Profile != "" && p.b.QuirksIsReproducible()
+
+	if !changed || p.b.QuirksDoesAddRemoveNotAlsoLock() || profile {
+		p.lockAndInstall(ctx, forceLock, forceInstall)
+	} else if p.b.QuirksDoesAddRemoveNotAlsoInstall() {
+		p.install(ctx, forceInstall)
+	}
+}
+
+// nothingToDoStatus is the status that add, remove, and install exit
+// with when they have nothing to do, with --exit-code: 1 is taken by
+// errors, and 2 by usage errors in many tools, so a script can tell
+// the three apart.
+const nothingToDoStatus = 3
+
+// empty returns true if the plan has no steps.
+func (p *plan) empty() bool {
+	return len(p.steps) == 0 && len(p.exports) == 0
+}
+
+// finishIfEmpty says that there was nothing to do, followed by the
+// given reason if it isn't empty, if the plan has no steps, whichever
+// backend it is for, unless a dry run has already said so. Then, with
+// --exit-code, it exits with nothingToDoStatus.
+func (p *plan) finishIfEmpty(reason string) {
+	if !p.empty() {
+		return
+	}
+	if !p.described {
+		msg := "nothing to do"
+		if reason != "" {
+			msg += ": " + reason
+		}
+		util.Log(msg)
+	}
+	if config.ExitCode {
+		util.CleanupTemp()
+		os.Exit(nothingToDoStatus)
+	}
+}
+
+// changesLockfile returns true if any step of the plan may change the
+// lockfile.
+func (p *plan) changesLockfile() bool {
+	for _, s := range p.steps {
+		for _, change := range s.changes {
+			if change == p.b.Lockfile {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// describe returns the steps of the plan, one per line, with what
+// each of them may change and, if explain is true, why it is needed.
+func (p *plan) describe(explain bool) string {
+	steps := append(append([]step{}, p.steps...), p.exports...)
+	if len(steps) == 0 {
+		return "nothing to do\n"
+	}
+	var out strings.Builder
+	for i, s := range steps {
+		fmt.Fprintf(&out, "%d. %s (changes %s)\n",
+			i+1, s.summary, strings.Join(s.changes, ", "))
+		if explain {
+			fmt.Fprintf(&out, "   because %s\n", s.reason)
+		}
+	}
+	return out.String()
+}
+
+// run runs the steps of the plan, and the post-install hooks after
+// each step that installs packages.
+func (p *plan) run(ctx context.Context) {
+	for _, s := range p.steps {
+		s.run()
+		if s.installs {
+			runPostInstallHooks(ctx, p.b)
+		}
+	}
+}
+
+// execute runs the plan, checking the change against the dependency
+// limits of the policy and then with the verification command, and
+// returns the recording of the change in the history, or nil if
+// nothing was run because of --dry-run. If either check fails, the
+// change is rolled back and the process is terminated.
+func (p *plan) execute(ctx context.Context) *history.Recording {
+	return p.executeWith(ctx, func() {
+		// Both checks roll back to the same snapshot, since
+		// only one can exist at a time.
+		v := startVerification()
+		var s *snapshot.Snapshot
+		if v != nil || limitsRollBack() {
+			s = snapshot.Take(ctx, p.b)
+		}
+		l := startLimitCheck(p.b)
+		p.run(ctx)
+
+		if l.exceeded() {
+			rollBack(ctx, p.b, s)
+			util.Die("rolled back changes to %s packages, which exceeded the dependency limits of the policy", p.b.Name)
+		}
+		if !v.passed(ctx) {
+			rollBack(ctx, p.b, s)
+			util.Die("rolled back changes to %s packages", p.b.Name)
+		}
+		if s != nil {
+			s.Discard()
+		}
+	})
+}
+
+// executeWith is like execute, but runs the plan by calling the given
+// function.
+func (p *plan) executeWith(ctx context.Context, run func()) *history.Recording {
+	p.export(ctx)
+	if config.DryRun {
+		fmt.Print(p.describe(config.Explain))
+		p.described = true
+		return nil
+	}
+	if config.Explain {
+		util.Log(strings.TrimSuffix(p.describe(true), "\n"))
+	}
+
+	exported := []string{}
+	for _, s := range p.exports {
+		exported = append(exported, s.changes...)
+	}
+	h := history.Start(p.b, exported...)
+	lockfile := readLockfile(p.b)
+	if p.changesLockfile() {
+		warnIgnoredLockfiles(p.b)
+	}
+	run()
+	markProvenance(p.b, lockfile)
+	for _, s := range p.exports {
+		s.run()
+	}
+	h.Finish()
+
+	store.UpdateFileHashes(p.b)
+	refreshListings(p.b)
+	store.Write()
+	return h
+}
@@ -0,0 +1,97 @@
+package cli
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/replit/upm/internal/api"
+	"github.com/replit/upm/internal/config"
+	"github.com/replit/upm/internal/project"
+	"github.com/replit/upm/internal/util"
+)
+
+// profileBaseDir is where the specfile is kept, as it was before the
+// profile was composed onto it, until it is put back. It lives in a
+// file rather than in memory so that it can still be put back if UPM
+// was interrupted.
+const profileBaseDir = ".upm/profile-base"
+
+// getProfile returns the packages of the profile given with --profile,
+// or nil if there is none.
+func getProfile() map[api.PkgName]api.PkgSpec {
+	if config.Profile == "" {
+		return nil
+	}
+	profile, ok := project.Read().Profiles[config.Profile]
+	if !ok {
+		util.Die("no profile named %q in .upm/config.toml", config.Profile)
+	}
+	pkgs := map[api.PkgName]api.PkgSpec{}
+	for name, spec := range profile.Packages {
+		pkgs[api.PkgName(name)] = api.PkgSpec(spec)
+	}
+	return pkgs
+}
+
+// restoreProfileBase puts back the specfile of the given backend as it
+// was before a profile was composed onto it, if it hasn't been put
+// back already.
+func restoreProfileBase(b api.LanguageBackend) {
+	base := filepath.Join(profileBaseDir, b.Name)
+	if !util.Exists(base) {
+		return
+	}
+	contents, err := ioutil.ReadFile(base)
+	if err != nil {
+		util.Die("%s: %s", base, err)
+	}
+	util.ProgressMsg("write " + b.Specfile)
+	util.TryWriteAtomic(b.Specfile, contents)
+	if err := os.Remove(base); err != nil {
+		util.Die("%s: %s", base, err)
+	}
+}
+
+// withProfile runs the given function, which should lock or install,
+// with ctx and with the packages of the current profile composed onto
+// the specfile of the given backend. Afterwards the specfile is put
+// back as it was, so the profile is only reflected in the lockfile and
+// the installed packages.
+func withProfile(ctx context.Context, b api.LanguageBackend, f func(context.Context)) {
+	restoreProfileBase(b)
+
+	pkgs := getProfile()
+	if len(pkgs) == 0 || !util.Exists(b.Specfile) {
+		f(ctx)
+		return
+	}
+
+	contents, err := ioutil.ReadFile(b.Specfile)
+	if err != nil {
+		util.Die("%s: %s", b.Specfile, err)
+	}
+	if err := os.MkdirAll(profileBaseDir, 0777); err != nil {
+		util.Die("%s: %s", profileBaseDir, err)
+	}
+	util.TryWriteAtomic(filepath.Join(profileBaseDir, b.Name), contents)
+
+	// Packages that are already in the specfile are overridden by
+	// removing them first, since Add leaves them alone otherwise.
+	existing := listSpecfileNormalized(b)
+	overridden := map[api.PkgName]bool{}
+	for name := range pkgs {
+		if orig, ok := existing[b.NormalizePackageName(name)]; ok {
+			overridden[orig] = true
+		}
+	}
+	if len(overridden) >= 1 {
+		b.Remove(ctx, overridden)
+	}
+	b.Add(ctx, pkgs, "")
+
+	f(ctx)
+
+	restoreProfileBase(b)
+}
@@ -0,0 +1,121 @@
+package cli
+
+import (
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/replit/upm/internal/api"
+	"github.com/replit/upm/internal/config"
+	"github.com/replit/upm/internal/util"
+)
+
+// provenanceRegexp matches the comment that markProvenance writes,
+// after the comment marker of the lockfile, capturing the version of
+// UPM, the platform, and the time.
+var provenanceRegexp = regexp.MustCompile(`^ Locked by upm \((.*)\) on (\S+) at (\S+)\s*$`)
+
+// provenance is what the comment that markProvenance writes says about
+// how the lockfile was produced.
+type provenance struct {
+	version  string
+	platform string
+	time     string
+}
+
+// currentPlatform returns the platform that UPM is running on, as
+// written in the comment, e.g. "linux/amd64".
+func currentPlatform() string {
+	return runtime.GOOS + "/" + runtime.GOARCH
+}
+
+// readLockfile returns the contents of the lockfile of the given
+// backend, or nil if it doesn't exist or nothing would be marked in it.
+func readLockfile(b api.LanguageBackend) []byte {
+	if !config.Provenance || b.LockfileComment == "" {
+		return nil
+	}
+	contents, err := ioutil.ReadFile(b.Lockfile)
+	if err != nil {
+		return nil
+	}
+	return contents
+}
+
+// markProvenance writes a comment at the top of the lockfile of the
+// given backend, with --provenance, saying which version of UPM
+// produced it, when, and on which platform, in place of the one that
+// an earlier lock wrote. before is the lockfile as readLockfile read
+// it before the plan ran; a lockfile that is still the same isn't
+// marked again, so that the comment only changes along with the rest.
+func markProvenance(b api.LanguageBackend, before []byte) {
+	if !config.Provenance || b.LockfileComment == "" || !util.Exists(b.Lockfile) {
+		return
+	}
+	contents, err := ioutil.ReadFile(b.Lockfile)
+	if err != nil {
+		util.Die("%s: %s", b.Lockfile, err)
+	}
+	if before != nil && string(contents) == string(before) {
+		return
+	}
+
+	lines := []string{fmt.Sprintf("%s Locked by upm (%s) on %s at %s",
+		b.LockfileComment, version, currentPlatform(),
+		time.Now().UTC().Format(time.RFC3339))}
+	for _, line := range strings.SplitAfter(string(contents), "\n") {
+		if _, ok := parseProvenance(b, line); !ok {
+			lines = append(lines, strings.TrimSuffix(line, "\n"))
+		}
+	}
+	util.TryWriteAtomic(b.Lockfile, []byte(strings.Join(lines, "\n")))
+}
+
+// parseProvenance returns what the given line of the lockfile of the
+// given backend says about how it was produced, if it is the comment
+// that markProvenance writes.
+func parseProvenance(b api.LanguageBackend, line string) (provenance, bool) {
+	if b.LockfileComment == "" || !strings.HasPrefix(line, b.LockfileComment) {
+		return provenance{}, false
+	}
+	m := provenanceRegexp.FindStringSubmatch(strings.TrimPrefix(line, b.LockfileComment))
+	if m == nil {
+		return provenance{}, false
+	}
+	return provenance{version: m[1], platform: m[2], time: m[3]}, true
+}
+
+// readProvenance returns how the lockfile of the given backend was
+// produced, if it was marked by markProvenance.
+func readProvenance(b api.LanguageBackend) (provenance, bool) {
+	if b.LockfileComment == "" {
+		return provenance{}, false
+	}
+	contents, err := ioutil.ReadFile(b.Lockfile)
+	if err != nil {
+		return provenance{}, false
+	}
+	for _, line := range strings.Split(string(contents), "\n") {
+		if p, ok := parseProvenance(b, line); ok {
+			return p, true
+		}
+	}
+	return provenance{}, false
+}
+
+// explainProvenance says where the lockfile of the given backend was
+// produced if that was on another platform, since packages that only
+// apply to some platforms may then be locked differently than they
+// would be here.
+func explainProvenance(b api.LanguageBackend) {
+	p, ok := readProvenance(b)
+	if !ok || p.platform == currentPlatform() {
+		return
+	}
+	util.Log(fmt.Sprintf("note: %s was locked by upm (%s) on %s at %s, not on %s, "+
+		"so packages for some platforms may be locked differently than they would be here",
+		b.Lockfile, p.version, p.platform, p.time, currentPlatform()))
+}
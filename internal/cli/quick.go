@@ -0,0 +1,203 @@
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/replit/upm/internal/api"
+	"github.com/replit/upm/internal/backends"
+	"github.com/replit/upm/internal/store"
+	"github.com/replit/upm/internal/table"
+	"github.com/replit/upm/internal/util"
+)
+
+// listQuickJSON is the JSON emitted by 'upm list --quick', which is
+// what the store has cached of the last listing of the file.
+type listQuickJSON struct {
+	Backend string `json:"backend"`
+	File    string `json:"file"`
+	Listed  string `json:"listed,omitempty"`
+	// Whether the file has changed since it was listed, or nothing
+	// was cached.
+	Stale bool `json:"stale"`
+	// Either []listSpecfileJSONEntry or, with --all,
+	// []listLockfileJSONEntry.
+	Packages interface{} `json:"packages"`
+}
+
+// cacheSpecfileListing records the given packages and groups that the
+// specfile of the given backend listed, for 'upm list --quick'.
+func cacheSpecfileListing(b api.LanguageBackend, pkgs map[api.PkgName]api.PkgSpec, groups map[api.PkgName]string) {
+	names := map[string]string{}
+	for name, spec := range pkgs {
+		names[string(name)] = string(spec)
+	}
+	grouped := map[string]string{}
+	for name, group := range groups {
+		grouped[string(name)] = group
+	}
+	store.CacheListing(b, false, names, grouped)
+}
+
+// cacheLockfileListing records the given packages that the lockfile
+// of the given backend listed, for 'upm list --all --quick'.
+func cacheLockfileListing(b api.LanguageBackend, pkgs map[api.PkgName]api.PkgVersion) {
+	names := map[string]string{}
+	for name, version := range pkgs {
+		names[string(name)] = string(version)
+	}
+	store.CacheListing(b, true, names, nil)
+}
+
+// refreshListings lists the specfile and lockfile of the given backend
+// again for 'upm list --quick', after they were changed, if it has
+// been used for the backend, so that it isn't left stale until 'upm
+// list' is run again.
+func refreshListings(b api.LanguageBackend) {
+	if !store.HasListing(b) {
+		return
+	}
+	s := silenceSubroutines()
+	defer s.restore()
+	if util.Exists(b.Specfile) {
+		groups := map[api.PkgName]string{}
+		if b.ListSpecfileGroups != nil {
+			groups = b.ListSpecfileGroups()
+		}
+		cacheSpecfileListing(b, b.ListSpecfile(), groups)
+	}
+	if util.Exists(b.Lockfile) {
+		cacheLockfileListing(b, b.ListLockfile())
+	}
+}
+
+// quickListing returns the listing that 'upm list --quick' and 'upm
+// which-language --quick' answer from: the first of the cached
+// listings of the specfile, or with all, the lockfile, of a backend
+// that matches the given language, or false if there is none.
+func quickListing(language string, all bool) (store.QuickListing, bool) {
+	for _, listing := range store.QuickListings(all) {
+		if language == "" || backends.NameMatchesLanguage(listing.Backend, language) {
+			return listing, true
+		}
+	}
+	return store.QuickListing{}, false
+}
+
+// runListQuick implements 'upm list --quick'. It answers from what the
+// store cached the last time the file was listed, only checking its
+// hash, and says if it has changed since, in which case the answer may
+// be out of date.
+func runListQuick(language string, all bool, outputFormat outputFormat) {
+	listing, ok := quickListing(language, all)
+	if !ok {
+		listing.Stale = true
+	}
+	// Only to sort the names, which doesn't look at the project.
+	var b api.LanguageBackend
+	if ok {
+		b = backends.GetBackend(listing.Backend)
+	}
+	names := []api.PkgName{}
+	for name := range listing.Packages {
+		names = append(names, api.PkgName(name))
+	}
+	if ok {
+		sortPkgNames(b, names)
+	}
+
+	switch outputFormat {
+	case outputFormatTable:
+		switch {
+		case !ok:
+			util.Log("nothing is cached yet (run 'upm list' first)")
+			return
+		case listing.Stale:
+			util.Log(fmt.Sprintf("warning: %s has changed since it was listed (run 'upm list' to list it again)",
+				listing.File))
+		}
+		if len(names) == 0 {
+			util.Log("no packages in " + listing.File)
+			return
+		}
+		headers := []string{"name", "spec"}
+		if all {
+			headers = []string{"name", "version"}
+		}
+		if len(listing.Groups) > 0 {
+			headers = append(headers, "group")
+		}
+		t := table.New(headers...)
+		for _, name := range names {
+			row := []string{string(name), listing.Packages[string(name)]}
+			if len(listing.Groups) > 0 {
+				row = append(row, listing.Groups[string(name)])
+			}
+			t.AddRow(row...)
+		}
+		t.Print()
+
+	case outputFormatJSON:
+		j := listQuickJSON{Backend: listing.Backend, File: listing.File, Stale: listing.Stale}
+		if ok {
+			j.Listed = listing.Listed.Format(time.RFC3339)
+		}
+		if all {
+			pkgs := []listLockfileJSONEntry{}
+			for _, name := range names {
+				pkgs = append(pkgs, listLockfileJSONEntry{
+					Name:    string(name),
+					Version: listing.Packages[string(name)],
+				})
+			}
+			j.Packages = pkgs
+		} else {
+			editable := store.GetEditable(b)
+			links := store.GetLinks(b)
+			pkgs := []listSpecfileJSONEntry{}
+			for _, name := range names {
+				_, isEditable := editable[name]
+				_, isLinked := links[b.NormalizePackageName(name)]
+				pkgs = append(pkgs, listSpecfileJSONEntry{
+					Name:     string(name),
+					Spec:     listing.Packages[string(name)],
+					Group:    listing.Groups[string(name)],
+					Editable: isEditable,
+					Linked:   isLinked,
+				})
+			}
+			j.Packages = pkgs
+		}
+		outputB, err := json.Marshal(j)
+		if err != nil {
+			panic("couldn't marshal json")
+		}
+		fmt.Println(string(outputB))
+
+	default:
+		util.Panicf("unknown output format %d", outputFormat)
+	}
+}
+
+// runWhichLanguageQuick implements 'upm which-language --quick'. It
+// names the backend whose specfile or lockfile was listed most
+// recently, preferring one whose file hasn't changed since, and says
+// if it has. If nothing was ever listed, it detects the language as
+// usual, which reads no more than a specfile.
+func runWhichLanguageQuick(language string) {
+	listing, ok := quickListing(language, false)
+	if locked, lockedOK := quickListing(language, true); lockedOK &&
+		(!ok || listing.Stale && !locked.Stale) {
+		listing, ok = locked, true
+	}
+	if !ok {
+		runWhichLanguage(language)
+		return
+	}
+	if listing.Stale {
+		util.Log(fmt.Sprintf("warning: %s has changed since it was listed, so this may be out of date",
+			listing.File))
+	}
+	fmt.Println(listing.Backend)
+}
@@ -0,0 +1,136 @@
+package cli
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/replit/upm/internal/api"
+	"github.com/replit/upm/internal/backends"
+	"github.com/replit/upm/internal/config"
+	"github.com/replit/upm/internal/report"
+	"github.com/replit/upm/internal/store"
+	"github.com/replit/upm/internal/table"
+	"github.com/replit/upm/internal/util"
+)
+
+// release is a version of a package in the specfile that was published
+// since the last run of 'upm watch-releases'.
+type release struct {
+	Name     api.PkgName    `json:"name"`
+	Previous api.PkgVersion `json:"previous"`
+	Version  api.PkgVersion `json:"version"`
+}
+
+// checkReleases looks up the latest version of every package in the
+// specfile, and returns those that have changed since they were last
+// seen, sorted by name. Packages that haven't been seen before are
+// recorded without being returned, so the first check only sets a
+// baseline.
+func checkReleases(ctx context.Context, b api.LanguageBackend) (releases []release, watched int) {
+	if !util.Exists(b.Specfile) {
+		return nil, 0
+	}
+	seen := store.GetReleases(b)
+	latest := map[api.PkgName]api.PkgVersion{}
+	releases = []release{}
+	for name := range b.ListSpecfile() {
+		version := api.PkgVersion(b.Info(ctx, name).Version)
+		if version == "" {
+			// The package isn't in the registry, for
+			// example because it is a local one.
+			continue
+		}
+		latest[name] = version
+		if previous, ok := seen[name]; ok && previous != version {
+			releases = append(releases, release{
+				Name:     name,
+				Previous: previous,
+				Version:  version,
+			})
+		}
+	}
+	store.SetReleases(b, latest)
+	sort.Slice(releases, func(i, j int) bool {
+		return pkgNameLess(b, releases[i].Name, releases[j].Name)
+	})
+	return releases, len(latest)
+}
+
+// releasesReport returns the report of the given releases for a
+// sink.
+func releasesReport(b api.LanguageBackend, releases []release) report.Report {
+	lines := []string{}
+	for _, r := range releases {
+		lines = append(lines, fmt.Sprintf("%s %s (was %s)", r.Name, r.Version, r.Previous))
+	}
+	title := "1 new release"
+	if len(releases) != 1 {
+		title = fmt.Sprintf("%d new releases", len(releases))
+	}
+	return report.Report{
+		Command:  "watch-releases",
+		Language: b.Name,
+		Title:    title,
+		Lines:    lines,
+		Items:    releases,
+	}
+}
+
+// printReleases prints the given releases in the given format.
+func printReleases(releases []release, outputFormat outputFormat) {
+	switch outputFormat {
+	case outputFormatTable:
+		if len(releases) == 0 {
+			util.Log("no new releases")
+			return
+		}
+		t := table.New("name", "previous", "version")
+		for _, r := range releases {
+			t.AddRow(string(r.Name), string(r.Previous), string(r.Version))
+		}
+		t.Print()
+
+	case outputFormatJSON:
+		outputB, err := json.Marshal(releases)
+		if err != nil {
+			panic("couldn't marshal json")
+		}
+		fmt.Println(string(outputB))
+
+	default:
+		util.Panicf("unknown output format %d", outputFormat)
+	}
+}
+
+// runWatchReleases implements 'upm watch-releases'. With a nonzero
+// interval, it checks again after every interval until it is killed.
+func runWatchReleases(ctx context.Context, language string, interval time.Duration, sink *report.Sink, outputFormat outputFormat) {
+	// A release made since the information about a package was
+	// cached would be missed.
+	config.NoCache = true
+	b := backends.GetBackend(language)
+	for {
+		firstRun := len(store.GetReleases(b)) == 0
+		releases, watched := checkReleases(ctx, b)
+		store.Write()
+
+		if firstRun {
+			util.Log(fmt.Sprintf(
+				"watching %d packages; new releases will be reported from now on", watched,
+			))
+		} else {
+			printReleases(releases, outputFormat)
+			if sink != nil && len(releases) > 0 {
+				sink.Post(releasesReport(b, releases))
+			}
+		}
+
+		if interval == 0 {
+			return
+		}
+		time.Sleep(interval)
+	}
+}
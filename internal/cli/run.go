@@ -0,0 +1,75 @@
+package cli
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"github.com/kballard/go-shellquote"
+
+	"github.com/replit/upm/internal/backends"
+	"github.com/replit/upm/internal/config"
+	"github.com/replit/upm/internal/table"
+	"github.com/replit/upm/internal/util"
+)
+
+// projectScript is a script that the project defines, as 'upm run'
+// lists it.
+type projectScript struct {
+	Name    string `json:"name"`
+	Command string `json:"command"`
+}
+
+// runRun implements 'upm run'. With no arguments, it lists the scripts
+// of the project, sorted by name; otherwise, it runs the script named
+// by the first one with the rest, exiting with its exit code.
+func runRun(ctx context.Context, language string, args []string, outputFormat outputFormat) {
+	b := backends.GetBackend(language)
+	if b.ListScripts == nil {
+		util.Die("%s does not support running scripts", b.Name)
+	}
+	scripts := b.ListScripts()
+
+	if len(args) == 0 {
+		list := []projectScript{}
+		for name, command := range scripts {
+			list = append(list, projectScript{Name: name, Command: command})
+		}
+		sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+
+		switch outputFormat {
+		case outputFormatTable:
+			if len(list) == 0 {
+				util.Log("the project defines no scripts")
+				return
+			}
+			t := table.New("name", "command")
+			for _, script := range list {
+				t.AddRow(script.Name, script.Command)
+			}
+			t.Print()
+
+		case outputFormatJSON:
+			outputB, err := json.Marshal(list)
+			if err != nil {
+				panic("couldn't marshal json")
+			}
+			fmt.Println(string(outputB))
+
+		default:
+			util.Panicf("unknown output format %d", outputFormat)
+		}
+		return
+	}
+
+	name := args[0]
+	if _, ok := scripts[name]; !ok {
+		util.Die("the project defines no script %#v (run 'upm run' to list them)", name)
+	}
+	if config.DryRun {
+		fmt.Printf("1. run script %s\n", shellquote.Join(args...))
+		return
+	}
+	b.RunScript(ctx, name, args[1:])
+}
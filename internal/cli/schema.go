@@ -0,0 +1,62 @@
+package cli
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/replit/upm/internal/util"
+)
+
+// schemaVersion is the version of the JSON schemas that describe the
+// JSON output of UPM. Within a version, output only changes in ways
+// that the schemas allow, such as by gaining optional fields; anything
+// else needs a new version, with new schemas beside the old ones.
+const schemaVersion = "v1"
+
+// schemaNames lists the outputs that have schemas, by the name of the
+// command that prints them. Each one is in resources/schemas.
+var schemaNames = []string{
+	"audit",
+	"blame",
+	"cache-stats",
+	"doctor",
+	"guess",
+	"history",
+	"index-status",
+	"info",
+	"languages",
+	"licenses",
+	"list",
+	"list-all",
+	"list-quick",
+	"outdated",
+	"report",
+	"run",
+	"search",
+	"tree",
+	"verify",
+	"watch-releases",
+	"why",
+}
+
+// runSchema implements 'upm schema'. With no name, it lists the
+// outputs that have schemas.
+func runSchema(name string) {
+	if name == "" {
+		for _, name := range schemaNames {
+			fmt.Println(name)
+		}
+		return
+	}
+
+	for _, known := range schemaNames {
+		if name == known {
+			fmt.Print(util.GetResource(
+				"/schemas/" + schemaVersion + "/" + name + ".json",
+			))
+			return
+		}
+	}
+	util.Die("no schema for %q (must be one of: %s)",
+		name, strings.Join(schemaNames, ", "))
+}
@@ -0,0 +1,83 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/replit/upm/internal/backends"
+	"github.com/replit/upm/internal/config"
+	"github.com/replit/upm/internal/util"
+)
+
+// templateDir returns the directory that a project started from the
+// given template goes in by default: the last element of its path or
+// URL, without .git, as git clone would name it.
+func templateDir(from string) string {
+	name := strings.TrimRight(from, "/")
+	if i := strings.LastIndexAny(name, "/:"); i >= 0 {
+		name = name[i+1:]
+	}
+	name = strings.TrimSuffix(name, ".git")
+	if name == "" || name == "." || name == ".." {
+		util.Die("can't tell what directory to put %s in (give one)", from)
+	}
+	return name
+}
+
+// copyTemplate puts the files of the given template into the directory
+// dir, which must not exist yet. A template that is a local directory
+// is copied, and anything else is cloned with git. Either way, the
+// history of the template isn't kept, since the project is a new one.
+func copyTemplate(ctx context.Context, from string, dir string) {
+	if info, err := os.Stat(from); err == nil && info.IsDir() {
+		src, _ := filepath.Abs(from)
+		dst, _ := filepath.Abs(dir)
+		if rel, err := filepath.Rel(src, dst); err == nil && !strings.HasPrefix(rel, "..") {
+			util.Die("can't copy %s into a directory inside it", from)
+		}
+		util.ProgressMsg(fmt.Sprintf("copy %s to %s", from, dir))
+		util.CopyTree(ctx, from, dir)
+	} else {
+		util.RunCmd(ctx, []string{"git", "clone", "--depth", "1", "--", from, dir})
+	}
+	if err := os.RemoveAll(filepath.Join(dir, ".git")); err != nil {
+		util.Die("%s", err)
+	}
+}
+
+// runInit implements 'upm init'. With --from, the template is put into
+// dir, or a directory named after it, and then the packages of its
+// project are locked and installed there, as by 'upm lock'. Without
+// it, only a specfile is created; see runInitSpecfile.
+func runInit(ctx context.Context, language string, from string, dir string, name string) {
+	if from == "" {
+		runInitSpecfile(ctx, language, dir, name)
+		return
+	}
+	if dir == "" {
+		dir = templateDir(from)
+	}
+	if util.Exists(dir) {
+		util.Die("%s already exists", dir)
+	}
+
+	if config.DryRun {
+		fmt.Printf("1. copy %s to %s\n", from, dir)
+		fmt.Printf("2. lock and install the packages of %s\n", dir)
+		return
+	}
+
+	copyTemplate(ctx, from, dir)
+	if err := os.Chdir(dir); err != nil {
+		util.Die("%s", err)
+	}
+
+	b := backends.GetBackend(language)
+	util.Log(fmt.Sprintf("%s is a %s project", dir, b.Name))
+	p := newPlan(b)
+	p.lockAndInstall(ctx, false, false)
+	p.execute(ctx)
+}
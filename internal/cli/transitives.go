@@ -0,0 +1,81 @@
+package cli
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/replit/upm/internal/api"
+	"github.com/replit/upm/internal/util"
+)
+
+// listLockedPkgs returns the packages in the lockfile, keyed by
+// normalized name, or nil if there is no lockfile.
+func listLockedPkgs(b api.LanguageBackend) map[api.PkgName]api.PkgVersion {
+	if !util.Exists(b.Lockfile) {
+		return nil
+	}
+	s := silenceSubroutines()
+	defer s.restore()
+	pkgs := map[api.PkgName]api.PkgVersion{}
+	for name, version := range b.ListLockfile() {
+		pkgs[b.NormalizePackageName(name)] = version
+	}
+	return pkgs
+}
+
+// checkRemoval compares the lockfile from before 'upm remove' with the
+// one after it, and warns about each of the removed packages that is
+// still in the lockfile, since it stays installed for as long as
+// another package depends on it, which people don't expect. If
+// showTransitives is true, it also lists the packages that left the
+// lockfile along with the removed ones, because nothing else needed
+// them. Nothing is checked unless there was a lockfile both times.
+func checkRemoval(b api.LanguageBackend, removed map[api.PkgName]bool,
+	before map[api.PkgName]api.PkgVersion, after map[api.PkgName]api.PkgVersion,
+	showTransitives bool) {
+
+	if before == nil || after == nil {
+		return
+	}
+
+	norms := map[api.PkgName]bool{}
+	kept := []api.PkgName{}
+	for name := range removed {
+		norm := b.NormalizePackageName(name)
+		norms[norm] = true
+		if _, ok := after[norm]; ok {
+			kept = append(kept, name)
+		}
+	}
+	sortPkgNames(b, kept)
+	for _, name := range kept {
+		util.Log(fmt.Sprintf(
+			"warning: %s was removed from %s but is still in %s, because "+
+				"another package depends on it, so it is still installed",
+			name, b.Specfile, b.Lockfile,
+		))
+	}
+
+	if !showTransitives {
+		return
+	}
+	gone := []api.PkgName{}
+	for norm := range before {
+		if _, ok := after[norm]; !ok && !norms[norm] {
+			gone = append(gone, norm)
+		}
+	}
+	if len(gone) == 0 {
+		util.Log("no other packages were removed from", b.Lockfile)
+		return
+	}
+	sortPkgNames(b, gone)
+	lines := []string{}
+	for _, name := range gone {
+		lines = append(lines, fmt.Sprintf("  %s %s", name, before[name]))
+	}
+	util.Log(fmt.Sprintf(
+		"also removed from %s, since nothing else needed them:\n%s",
+		b.Lockfile, strings.Join(lines, "\n"),
+	))
+}
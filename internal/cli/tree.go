@@ -0,0 +1,108 @@
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/replit/upm/internal/api"
+	"github.com/replit/upm/internal/backends"
+	"github.com/replit/upm/internal/util"
+)
+
+// treeNode is a package in the tree that 'upm tree' prints.
+type treeNode struct {
+	Name    api.PkgName    `json:"name"`
+	Version api.PkgVersion `json:"version"`
+	// True if the dependencies of the package are left out,
+	// because they are listed where it first appears in the tree.
+	Deduped      bool       `json:"deduped,omitempty"`
+	Dependencies []treeNode `json:"dependencies,omitempty"`
+}
+
+// buildTree returns the tree of the dependencies of the given package,
+// as the lockfile says they depend on each other, down to the given
+// depth below it, or all the way down if it is negative. A package
+// whose dependencies are in expanded already has them listed earlier
+// in the tree, or is an ancestor of itself, so it is deduped.
+func buildTree(locked map[api.PkgName]whyLink, deps map[api.PkgName][]api.PkgName,
+	pkg api.PkgName, depth int, expanded map[api.PkgName]bool) treeNode {
+
+	link := lockedLink(locked, pkg)
+	node := treeNode{Name: link.Name, Version: link.Version}
+	if len(deps[pkg]) == 0 || depth == 0 {
+		return node
+	}
+	if expanded[pkg] {
+		node.Deduped = true
+		return node
+	}
+	expanded[pkg] = true
+	for _, dep := range deps[pkg] {
+		node.Dependencies = append(node.Dependencies,
+			buildTree(locked, deps, dep, depth-1, expanded))
+	}
+	return node
+}
+
+// collectTree returns the trees of the dependencies of the packages in
+// the specfile, sorted by normalized name, down to the given depth
+// below them, or all the way down if it is zero.
+func collectTree(b api.LanguageBackend, depth int) []treeNode {
+	locked, direct, deps := lockfileGraph(b)
+	if depth == 0 {
+		depth = -1
+	}
+	expanded := map[api.PkgName]bool{}
+	trees := []treeNode{}
+	for _, root := range direct {
+		trees = append(trees, buildTree(locked, deps, root, depth, expanded))
+	}
+	return trees
+}
+
+// printTree prints the given tree, indenting each package by two
+// spaces for each level below the specfile.
+func printTree(node treeNode, level int) {
+	line := strings.Repeat("  ", level) + string(node.Name)
+	if node.Version != "" {
+		line += " " + string(node.Version)
+	}
+	if node.Deduped {
+		line += " (listed above)"
+	}
+	fmt.Println(line)
+	for _, dep := range node.Dependencies {
+		printTree(dep, level+1)
+	}
+}
+
+// runTree implements 'upm tree'.
+func runTree(language string, depth int, outputFormat outputFormat) {
+	b := backends.GetBackend(language)
+	if depth < 0 {
+		util.Die("--depth must be at least 0")
+	}
+	trees := collectTree(b, depth)
+
+	switch outputFormat {
+	case outputFormatTable:
+		if len(trees) == 0 {
+			util.Log(fmt.Sprintf("%s lists no packages", b.Specfile))
+			return
+		}
+		for _, tree := range trees {
+			printTree(tree, 0)
+		}
+
+	case outputFormatJSON:
+		outputB, err := json.Marshal(trees)
+		if err != nil {
+			panic("couldn't marshal json")
+		}
+		fmt.Println(string(outputB))
+
+	default:
+		util.Panicf("unknown output format %d", outputFormat)
+	}
+}
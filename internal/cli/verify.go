@@ -0,0 +1,130 @@
+package cli
+
+import (
+	"context"
+	"os"
+
+	"github.com/kballard/go-shellquote"
+
+	"github.com/replit/upm/internal/api"
+	"github.com/replit/upm/internal/history"
+	"github.com/replit/upm/internal/project"
+	"github.com/replit/upm/internal/snapshot"
+	"github.com/replit/upm/internal/util"
+)
+
+// packagesInstalled is set by a post-install hook, so that we know
+// whether there is anything to verify.
+var packagesInstalled = false
+
+// getVerificationCommand returns the project's verification command,
+// or nil if there is none.
+func getVerificationCommand() []string {
+	command := project.Read().Verify.Command
+	if command == "" {
+		return nil
+	}
+	cmd, err := shellquote.Split(command)
+	if err != nil || len(cmd) == 0 {
+		util.Die("invalid verification command %q", command)
+	}
+	return cmd
+}
+
+// runVerificationCommand runs the given verification command in the
+// current directory and returns true if it succeeded.
+func runVerificationCommand(ctx context.Context, cmd []string) bool {
+	util.RefuseIfNotAllowed(cmd[0])
+	util.ProgressMsg(shellquote.Join(cmd...))
+	command := util.Command(ctx, cmd)
+	command.Stdout = os.Stderr
+	command.Stderr = os.Stderr
+	if err := util.RunCommand(ctx, command); err != nil {
+		util.Log("verification failed:", err)
+		return false
+	}
+	return true
+}
+
+// verification tracks a change to a project's packages that should be
+// checked with the project's verification command once it is done. A
+// nil *verification means that there is no verification command.
+type verification struct {
+	cmd []string
+}
+
+// startVerification starts checking a change with the project's
+// verification command, if it has one configured. It should be called
+// right before the change is made, and a snapshot taken to roll the
+// change back to if it fails.
+func startVerification() *verification {
+	cmd := getVerificationCommand()
+	if cmd == nil {
+		return nil
+	}
+	return &verification{cmd: cmd}
+}
+
+// passed runs the verification command if any packages were installed
+// since startVerification, and returns false if it failed.
+func (v *verification) passed(ctx context.Context) bool {
+	return v == nil || !packagesInstalled || runVerificationCommand(ctx, v.cmd)
+}
+
+// rollBack restores the given snapshot of the given backend's
+// dependencies, which were changed since it was taken.
+func rollBack(ctx context.Context, b api.LanguageBackend, s *snapshot.Snapshot) {
+	s.Restore()
+	if !s.HasPackageDir() {
+		// The packages aren't in the snapshot, so put them
+		// back as they were by installing from the restored
+		// specfile and lockfile.
+		maybeInstall(ctx, b, true)
+	}
+}
+
+// runCanary runs the given plan in a clone of the project, with the
+// packages isolated from the project's own, and runs the verification
+// command there. Only if it succeeds is the change applied to the
+// project, by copying over the new specfile and lockfile and
+// installing from them. It returns the recording of the change, as
+// plan.execute does.
+func runCanary(ctx context.Context, p *plan, forceInstall bool) *history.Recording {
+	b := p.b
+	cmd := getVerificationCommand()
+	if cmd == nil {
+		util.Die("--canary needs a verification command in .upm/config.toml")
+	}
+
+	return p.executeWith(ctx, func() {
+		l := startLimitCheck(b)
+		cwd, err := os.Getwd()
+		if err != nil {
+			util.Die("%s", err)
+		}
+		clone := snapshot.NewClone(ctx, b)
+		if err := os.Chdir(clone.Dir); err != nil {
+			util.Die("%s: %s", clone.Dir, err)
+		}
+		restore := func() {}
+		if b.IsolatePackageDir != nil {
+			restore = b.IsolatePackageDir()
+		}
+
+		p.run(ctx)
+		ok := !l.exceeded() && runVerificationCommand(ctx, cmd)
+
+		restore()
+		if err := os.Chdir(cwd); err != nil {
+			util.Die("%s: %s", cwd, err)
+		}
+		if !ok {
+			clone.Discard()
+			util.Die("canary failed, so %s packages were left unchanged", b.Name)
+		}
+
+		clone.Apply(ctx)
+		clone.Discard()
+		maybeInstall(ctx, b, forceInstall)
+	})
+}
@@ -0,0 +1,79 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"strings"
+
+	"github.com/replit/upm/internal/backends"
+	"github.com/replit/upm/internal/backends/python"
+	"github.com/replit/upm/internal/store"
+	"github.com/replit/upm/internal/util"
+)
+
+// toolVersion returns the first line that the given program prints
+// when it is run with --version, or says why there is none.
+func toolVersion(ctx context.Context, program string) string {
+	if _, err := exec.LookPath(program); err != nil {
+		return "not found"
+	}
+	if !util.IsCommandAllowed(program) {
+		return "not allowed by the command policy"
+	}
+	outputB, code := util.GetCmdOutputAndExitCode(ctx, []string{program, "--version"})
+	if code != 0 {
+		return fmt.Sprintf("unknown (--version exited with status %d)", code)
+	}
+	for _, line := range strings.Split(string(outputB), "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			return line
+		}
+	}
+	return "unknown"
+}
+
+// runVersion implements 'upm version'. With verbose, it also reports
+// what most bug reports need: the versions of the programs that the
+// backends for the given language run, or of the one autodetected for
+// the project, which snapshot of PyPI the module map is from, and the
+// version of the store schema.
+func runVersion(ctx context.Context, language string, verbose bool) {
+	fmt.Println(getVersion())
+	if !verbose {
+		return
+	}
+
+	rows := []infoLine{
+		{Field: "Go", Value: fmt.Sprintf("%s %s/%s", runtime.Version(), runtime.GOOS, runtime.GOARCH)},
+		{Field: "Store schema", Value: strconv.Itoa(store.SchemaVersion())},
+		{Field: "PyPI module map", Value: python.MapSnapshot()},
+	}
+	bs := backends.RelevantBackends(language)
+	seen := map[string]bool{}
+	for _, b := range bs {
+		for _, program := range b.Executables {
+			if seen[program] {
+				continue
+			}
+			seen[program] = true
+			rows = append(rows, infoLine{Field: program, Value: toolVersion(ctx, program)})
+		}
+	}
+
+	width := 0
+	for _, row := range rows {
+		if len(row.Field) > width {
+			width = len(row.Field)
+		}
+	}
+	for _, row := range rows {
+		padding := strings.Repeat(" ", width-len(row.Field))
+		fmt.Println(row.Field + ":" + padding + "   " + row.Value)
+	}
+	if len(bs) == 0 {
+		util.Log("no language was autodetected, so no tools are listed (use --lang to pick one)")
+	}
+}
@@ -0,0 +1,153 @@
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/replit/upm/internal/api"
+	"github.com/replit/upm/internal/backends"
+	"github.com/replit/upm/internal/util"
+)
+
+// whyLink is a package in a chain that 'upm why' prints.
+type whyLink struct {
+	Name    api.PkgName    `json:"name"`
+	Version api.PkgVersion `json:"version"`
+}
+
+// shortestChain returns the shortest chain of packages from the given
+// one to the target in the given dependency graph, both ends included,
+// or nil if the target can't be reached from it. Of chains that are as
+// short, the one that comes first by name is returned.
+func shortestChain(deps map[api.PkgName][]api.PkgName, from api.PkgName, target api.PkgName) []api.PkgName {
+	prev := map[api.PkgName]api.PkgName{from: ""}
+	queue := []api.PkgName{from}
+	for len(queue) > 0 {
+		pkg := queue[0]
+		queue = queue[1:]
+		if pkg == target {
+			chain := []api.PkgName{}
+			for ; pkg != ""; pkg = prev[pkg] {
+				chain = append([]api.PkgName{pkg}, chain...)
+			}
+			return chain
+		}
+		for _, dep := range deps[pkg] {
+			if _, ok := prev[dep]; !ok {
+				prev[dep] = pkg
+				queue = append(queue, dep)
+			}
+		}
+	}
+	return nil
+}
+
+// lockfileGraph reads the packages in the lockfile of the given
+// backend and what each of them depends on, keyed by normalized name,
+// along with the normalized names of the direct dependencies in the
+// specfile, sorted. The dependencies of each package are sorted too.
+func lockfileGraph(b api.LanguageBackend) (map[api.PkgName]whyLink, []api.PkgName, map[api.PkgName][]api.PkgName) {
+	if b.ListLockfileDependencies == nil {
+		util.Die("%s can't list the dependencies of its packages", b.Name)
+	}
+	if !util.Exists(b.Lockfile) {
+		util.Die("%s does not exist", b.Lockfile)
+	}
+
+	s := silenceSubroutines()
+	locked := map[api.PkgName]whyLink{}
+	for pkg, version := range b.ListLockfile() {
+		locked[b.NormalizePackageName(pkg)] = whyLink{Name: pkg, Version: version}
+	}
+	direct := []api.PkgName{}
+	if util.Exists(b.Specfile) {
+		for pkg := range b.ListSpecfile() {
+			direct = append(direct, b.NormalizePackageName(pkg))
+		}
+	}
+	graph := b.ListLockfileDependencies()
+	s.restore()
+
+	deps := map[api.PkgName][]api.PkgName{}
+	for pkg, on := range graph {
+		norms := []api.PkgName{}
+		for _, dep := range on {
+			norms = append(norms, b.NormalizePackageName(dep))
+		}
+		sort.Slice(norms, func(i, j int) bool { return norms[i] < norms[j] })
+		deps[b.NormalizePackageName(pkg)] = norms
+	}
+	sort.Slice(direct, func(i, j int) bool { return direct[i] < direct[j] })
+	return locked, direct, deps
+}
+
+// lockedLink returns the given package as it is in the lockfile, or
+// with no version if it isn't in it.
+func lockedLink(locked map[api.PkgName]whyLink, pkg api.PkgName) whyLink {
+	if link, ok := locked[pkg]; ok {
+		return link
+	}
+	return whyLink{Name: pkg}
+}
+
+// collectWhy returns, for each direct dependency in the specfile that
+// the given package is locked for, the shortest chain of packages from
+// it to that package, as the lockfile says they depend on each other.
+// A package that is itself a direct dependency has a chain of its own.
+// The chains are sorted by their direct dependency.
+func collectWhy(b api.LanguageBackend, name api.PkgName) [][]whyLink {
+	locked, direct, deps := lockfileGraph(b)
+	target := b.NormalizePackageName(name)
+	if _, ok := locked[target]; !ok {
+		util.Die("package %s is not in %s", name, b.Lockfile)
+	}
+
+	chains := [][]whyLink{}
+	for _, root := range direct {
+		chain := []whyLink{}
+		for _, pkg := range shortestChain(deps, root, target) {
+			chain = append(chain, lockedLink(locked, pkg))
+		}
+		if len(chain) > 0 {
+			chains = append(chains, chain)
+		}
+	}
+	return chains
+}
+
+// runWhy implements 'upm why'.
+func runWhy(language string, name string, outputFormat outputFormat) {
+	b := backends.GetBackend(language)
+	chains := collectWhy(b, api.PkgName(name))
+
+	switch outputFormat {
+	case outputFormatTable:
+		if len(chains) == 0 {
+			util.Log(fmt.Sprintf("no package in %s depends on %s", b.Specfile, name))
+			return
+		}
+		for _, chain := range chains {
+			links := []string{}
+			for _, link := range chain {
+				if link.Version == "" {
+					links = append(links, string(link.Name))
+				} else {
+					links = append(links, fmt.Sprintf("%s %s", link.Name, link.Version))
+				}
+			}
+			fmt.Println(strings.Join(links, " > "))
+		}
+
+	case outputFormatJSON:
+		outputB, err := json.Marshal(chains)
+		if err != nil {
+			panic("couldn't marshal json")
+		}
+		fmt.Println(string(outputB))
+
+	default:
+		util.Panicf("unknown output format %d", outputFormat)
+	}
+}
@@ -3,5 +3,75 @@
 // language backend.
 package config
 
+import "time"
+
 // Quiet is true if --quiet was passed on the command line.
 var Quiet bool
+
+// Profile is the name of the profile given with --profile, or the
+// empty string if there is none.
+var Profile string
+
+// ReadOnly is true if --read-only was passed on the command line, or
+// UPM_READ_ONLY is set. Nothing in the project may be modified, and no
+// commands that could modify it may be run.
+var ReadOnly bool
+
+// AllowedCommands is the list of absolute paths of the programs that
+// may be run, from the command policy given with --policy, or nil if
+// there is no policy and any program may be run.
+var AllowedCommands []string
+
+// DryRun is true if --dry-run was passed on the command line. Commands
+// that change the packages of the project print what they would do
+// instead of doing it.
+var DryRun bool
+
+// Explain is true if --explain was passed on the command line.
+// Commands that change the packages of the project say why each step
+// is needed.
+var Explain bool
+
+// ExitCode is true if --exit-code was passed on the command line.
+// Commands that change the packages of the project then exit with a
+// status of their own when they have nothing to do, rather than with 0.
+var ExitCode bool
+
+// Provenance is true if --provenance was passed on the command line,
+// or UPM_PROVENANCE is set. Each lockfile that changes is then marked
+// with the version of UPM, the time, and the platform that produced
+// it, if its format allows comments.
+var Provenance bool
+
+// NoCache is true if --no-cache was passed on the command line.
+// Information about packages is then always fetched from the package
+// index, rather than from the store.
+var NoCache bool
+
+// CacheTTL is how long information about packages that was fetched
+// from a package index is used from the store before it is fetched
+// again, as given with --cache-ttl or UPM_CACHE_TTL.
+var CacheTTL time.Duration
+
+// RemoteSearch is true if --remote was passed to 'upm search'. Backends
+// that search a list of packages that UPM was built with then search
+// the live registry as well.
+var RemoteSearch bool
+
+// Jobs is how many operations, such as requests and subprocesses, may
+// run at once, as given with --jobs or UPM_JOBS, or zero for the
+// default.
+var Jobs int
+
+// DefaultMinConfidence is the default of MinConfidence.
+const DefaultMinConfidence = 0.5
+
+// MinConfidence is how likely a guessed package must be to be right,
+// from 0 to 1, for it to be guessed, as given with --min-confidence.
+// Packages whose likelihood isn't known are always guessed.
+var MinConfidence = DefaultMinConfidence
+
+// ChangedOnly is true if --changed-only was passed to 'upm guess' or
+// 'upm add --guess'. The guess then starts from the last one made that
+// way, and only scans the files that have changed since.
+var ChangedOnly bool
@@ -0,0 +1,213 @@
+package guess
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/kballard/go-shellquote"
+
+	"github.com/replit/upm/internal/api"
+	"github.com/replit/upm/internal/store"
+	"github.com/replit/upm/internal/util"
+)
+
+// gitLines runs git with the given arguments and returns the lines
+// that it prints, or false if it fails. What it prints to stderr is
+// dropped, since outside of a Git repository it only complains.
+func gitLines(ctx context.Context, args ...string) ([]string, bool) {
+	cmd := append([]string{"git"}, args...)
+	util.ProgressMsg(shellquote.Join(cmd...))
+	output, err := util.CommandOutput(ctx, util.Command(ctx, cmd))
+	if err != nil {
+		return nil, false
+	}
+	lines := []string{}
+	for _, line := range strings.Split(string(output), "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines, true
+}
+
+// gitState returns the commit that HEAD is at and the files of the
+// project that differ from it, including untracked ones, relative to
+// the project directory, or false if the project isn't in a Git
+// repository with a commit, or git can't be run.
+func gitState(ctx context.Context) (string, []string, bool) {
+	if _, err := exec.LookPath("git"); err != nil || !util.IsCommandAllowed("git") {
+		return "", nil, false
+	}
+	inside, ok := gitLines(ctx, "rev-parse", "--is-inside-work-tree")
+	if !ok || len(inside) != 1 || inside[0] != "true" {
+		return "", nil, false
+	}
+	head, ok := gitLines(ctx, "rev-parse", "--verify", "--quiet", "HEAD")
+	if !ok || len(head) != 1 {
+		return "", nil, false
+	}
+	modified, ok := gitLines(ctx, "diff", "--name-only", "--relative", "HEAD")
+	if !ok {
+		return "", nil, false
+	}
+	untracked, ok := gitLines(ctx, "ls-files", "--others", "--exclude-standard")
+	if !ok {
+		return "", nil, false
+	}
+	dirty := append(modified, untracked...)
+	sort.Strings(dirty)
+	return head[0], dirty, true
+}
+
+// modifiedSince returns the files of the project that were modified
+// after the given time, leaving out those in util.IgnoredPaths.
+func modifiedSince(t time.Time) map[string]bool {
+	files := map[string]bool{}
+	filepath.Walk(".", func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			util.Die("%s: %s", path, err)
+		}
+		for _, name := range util.IgnoredPaths {
+			if filepath.Base(path) == name {
+				return filepath.SkipDir
+			}
+		}
+		if info.Mode().IsRegular() && info.ModTime().After(t) {
+			files[filepath.ToSlash(path)] = true
+		}
+		return nil
+	})
+	return files
+}
+
+// changedSince returns the files of the project that may have changed
+// since the given guess, given what Git says about the project now, or
+// false if that can't be told, in which case the whole project has to
+// be scanned again. With Git, they are the files that differ from the
+// commit that HEAD is at now or was at then, or that differed then;
+// without it, they are those modified since. Either way, the files of
+// the guessed packages that are gone are included.
+func changedSince(ctx context.Context, prev *store.ChangedGuess, commit string, dirty []string, inGit bool) (map[string]bool, bool) {
+	if inGit != (prev.Commit != "") {
+		return nil, false
+	}
+	changed := map[string]bool{}
+	if inGit {
+		files := append(append([]string{}, dirty...), prev.Dirty...)
+		if commit != prev.Commit {
+			committed, ok := gitLines(ctx, "diff", "--name-only", "--relative", prev.Commit, commit)
+			if !ok {
+				return nil, false
+			}
+			files = append(files, committed...)
+		}
+		for _, file := range files {
+			changed[filepath.ToSlash(file)] = true
+		}
+	} else {
+		changed = modifiedSince(prev.Guessed)
+	}
+	for _, pkg := range prev.Packages {
+		for _, file := range pkg.Files {
+			if !util.Exists(file) {
+				changed[file] = true
+			}
+		}
+	}
+	return changed, true
+}
+
+// backendDetails returns what the given backend guesses, with
+// GuessDetails if it has it, and whether it succeeded.
+func backendDetails(ctx context.Context, b api.LanguageBackend) (map[api.PkgName]api.GuessedPkg, bool) {
+	if b.GuessDetails != nil {
+		return b.GuessDetails(ctx)
+	}
+	names, success := b.Guess(ctx)
+	pkgs := map[api.PkgName]api.GuessedPkg{}
+	for name := range names {
+		pkgs[name] = api.GuessedPkg{}
+	}
+	return pkgs, success
+}
+
+// changedDetails is what Details guesses from the backend with
+// config.ChangedOnly. It starts from what the last guess made that way
+// guessed, and has the backend scan only the files that have changed
+// since, as changedSince tells them, through util.ScanOnly. The
+// packages guessed from those files replace the ones that were; a
+// package whose files the backend doesn't say is kept. Without such a
+// guess, with forceGuess, or if the GuessCacheKey of the backend has
+// changed, the whole project is scanned.
+func changedDetails(ctx context.Context, b api.LanguageBackend, forceGuess bool) map[api.PkgName]api.GuessedPkg {
+	prev := store.GetChangedGuess(b)
+	cacheKey := ""
+	if b.GuessCacheKey != nil {
+		cacheKey = b.GuessCacheKey()
+	}
+	guessed := time.Now()
+	commit, dirty, inGit := gitState(ctx)
+
+	var changed map[string]bool
+	if prev != nil && !forceGuess && prev.CacheKey == cacheKey {
+		changed, _ = changedSince(ctx, prev, commit, dirty, inGit)
+	}
+	if changed != nil && len(changed) == 0 {
+		pkgs := map[api.PkgName]api.GuessedPkg{}
+		for name, pkg := range prev.Packages {
+			pkgs[api.PkgName(name)] = pkg
+		}
+		return pkgs
+	}
+
+	util.ScanOnly = changed
+	pkgs, success := backendDetails(ctx, b)
+	util.ScanOnly = nil
+
+	if changed != nil {
+		kept := map[api.PkgName]api.GuessedPkg{}
+		for name, pkg := range prev.Packages {
+			if len(pkg.Files) > 0 {
+				files := []string{}
+				for _, file := range pkg.Files {
+					if !changed[file] {
+						files = append(files, file)
+					}
+				}
+				if len(files) == 0 {
+					continue
+				}
+				pkg.Files = files
+			}
+			kept[api.PkgName(name)] = pkg
+		}
+		m := newMerger(b)
+		m.add(kept, "")
+		m.add(pkgs, "")
+		pkgs = m.pkgs
+		for name, pkg := range pkgs {
+			pkg.Files = sortedUnique(pkg.Files)
+			pkgs[name] = pkg
+		}
+	}
+
+	if success {
+		record := &store.ChangedGuess{
+			Guessed:  guessed,
+			Commit:   commit,
+			Dirty:    dirty,
+			CacheKey: cacheKey,
+			Packages: map[string]api.GuessedPkg{},
+		}
+		for name, pkg := range pkgs {
+			record.Packages[string(name)] = pkg
+		}
+		store.SetChangedGuess(b, record)
+	}
+	return pkgs
+}
@@ -0,0 +1,226 @@
+// Package guess merges the packages that a backend guesses from the
+// imports of a project with those suggested by other guess sources,
+// such as a database of a company's internal packages that no public
+// index knows about. A program that embeds UPM adds a source with
+// Register; a project adds one in the [guess] table of
+// .upm/config.toml, as a command that prints its suggestions.
+package guess
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"sort"
+
+	"github.com/kballard/go-shellquote"
+
+	"github.com/replit/upm/internal/api"
+	"github.com/replit/upm/internal/config"
+	"github.com/replit/upm/internal/project"
+	"github.com/replit/upm/internal/store"
+	"github.com/replit/upm/internal/util"
+)
+
+// Source suggests packages that are probably needed as dependencies
+// of the project of the given backend, mapped to why, in the same way
+// as the GuessDetails field of api.LanguageBackend. It returns nil if
+// it has nothing to suggest for that backend. Names should be in a
+// format suitable for the Add method of the backend.
+type Source func(b api.LanguageBackend) map[api.PkgName]api.GuessedPkg
+
+// namedSource is a Source that was registered under a name.
+type namedSource struct {
+	name   string
+	source Source
+}
+
+// sources are the guess sources added by Register, in order.
+var sources = []namedSource{}
+
+// Register adds a guess source, whose suggestions are merged with the
+// packages that every backend guesses from then on. The name says
+// where the suggestions came from, in 'upm guess --interactive'. It
+// panics if a source with the same name was registered already.
+func Register(name string, source Source) {
+	for _, s := range sources {
+		if s.name == name {
+			util.Panicf("guess source %q registered twice", name)
+		}
+	}
+	sources = append(sources, namedSource{name: name, source: source})
+}
+
+// Guess returns the packages that the given backend guesses, using
+// the cache as store.GuessWithCache does, along with those suggested
+// by every guess source. The suggestions aren't cached, since they
+// can change when the imports don't.
+func Guess(ctx context.Context, b api.LanguageBackend, forceGuess bool) map[api.PkgName]bool {
+	pkgs := map[api.PkgName]bool{}
+	for name := range Details(ctx, b, forceGuess) {
+		pkgs[name] = true
+	}
+	return pkgs
+}
+
+// Details is like Guess, but also says why each package was guessed,
+// and with what spec, as store.GuessDetailsWithCache does. Packages
+// that are less likely to be right than config.MinConfidence, even
+// after merging the suggestions, are left out. With
+// config.ChangedOnly, only the files that have changed since the last
+// guess made that way are scanned; see changedDetails.
+func Details(ctx context.Context, b api.LanguageBackend, forceGuess bool) map[api.PkgName]api.GuessedPkg {
+	m := newMerger(b)
+	if config.ChangedOnly {
+		m.add(changedDetails(ctx, b, forceGuess), "")
+	} else {
+		m.add(store.GuessDetailsWithCache(ctx, b, forceGuess), "")
+	}
+	for _, s := range sources {
+		m.add(s.source(b), s.name)
+	}
+	for _, source := range project.Read().Guess.Sources {
+		name := source.Name
+		if name == "" {
+			name = source.Command
+		}
+		m.add(runCommand(ctx, b, name, source.Command), name)
+	}
+	return m.result()
+}
+
+// merger merges the suggestions of several guess sources, which may
+// spell the name of the same package differently.
+type merger struct {
+	b api.LanguageBackend
+
+	// Map from normalized names to the name first suggested.
+	names map[api.PkgName]api.PkgName
+
+	pkgs map[api.PkgName]api.GuessedPkg
+}
+
+// newMerger returns a merger with nothing in it yet.
+func newMerger(b api.LanguageBackend) *merger {
+	return &merger{
+		b:     b,
+		names: map[api.PkgName]api.PkgName{},
+		pkgs:  map[api.PkgName]api.GuessedPkg{},
+	}
+}
+
+// add merges the suggestions of the guess source with the given name,
+// or of the backend itself if the name is empty. A package suggested
+// more than once is imported in all the files given for it, with the
+// highest confidence given and the first spec given. It is a dev
+// dependency if any source says so.
+func (m *merger) add(pkgs map[api.PkgName]api.GuessedPkg, source string) {
+	for name, guessed := range pkgs {
+		norm := m.b.NormalizePackageName(name)
+		if first, ok := m.names[norm]; ok {
+			name = first
+		} else {
+			m.names[norm] = name
+		}
+
+		pkg := m.pkgs[name]
+		pkg.Files = append(pkg.Files, guessed.Files...)
+		pkg.Sources = append(pkg.Sources, guessed.Sources...)
+		if source != "" {
+			pkg.Sources = append(pkg.Sources, source)
+		}
+		if guessed.Confidence > pkg.Confidence {
+			pkg.Confidence = guessed.Confidence
+		}
+		if pkg.Spec == "" {
+			pkg.Spec = guessed.Spec
+		}
+		pkg.Dev = pkg.Dev || guessed.Dev
+		m.pkgs[name] = pkg
+	}
+}
+
+// result returns the merged suggestions, with the files and sources of
+// each package sorted and without duplicates, leaving out those that
+// are less likely than config.MinConfidence.
+func (m *merger) result() map[api.PkgName]api.GuessedPkg {
+	for name, pkg := range m.pkgs {
+		if pkg.Confidence > 0 && pkg.Confidence < config.MinConfidence {
+			delete(m.pkgs, name)
+			continue
+		}
+		pkg.Files = sortedUnique(pkg.Files)
+		pkg.Sources = sortedUnique(pkg.Sources)
+		m.pkgs[name] = pkg
+	}
+	return m.pkgs
+}
+
+// sortedUnique sorts the given strings and removes duplicates, in
+// place. It returns nil for an empty list, as it was before anything
+// was appended to it.
+func sortedUnique(strs []string) []string {
+	if len(strs) == 0 {
+		return nil
+	}
+	sort.Strings(strs)
+	unique := strs[:1]
+	for _, s := range strs[1:] {
+		if s != unique[len(unique)-1] {
+			unique = append(unique, s)
+		}
+	}
+	return unique
+}
+
+// suggestion is one package printed by the command of a guess source.
+type suggestion struct {
+	Name       string   `json:"name"`
+	Files      []string `json:"files"`
+	Confidence float64  `json:"confidence"`
+	Spec       string   `json:"spec"`
+	Dev        bool     `json:"dev"`
+}
+
+// runCommand runs the command of a guess source from .upm/config.toml
+// and returns its suggestions. The command is run in the project
+// directory with UPM_LANGUAGE set to the name of the backend, and
+// prints a JSON array of objects, each with the "name" of a package
+// and optionally the "files" that need it, the "confidence" of the
+// suggestion from 0 to 1, the "spec" to add it with, and whether it is
+// a "dev" dependency. It prints [] if it has nothing for that
+// backend. If the command fails, the process is terminated, since the
+// guess would be missing packages that it was configured to find.
+func runCommand(ctx context.Context, b api.LanguageBackend, name string, command string) map[api.PkgName]api.GuessedPkg {
+	cmd, err := shellquote.Split(command)
+	if err != nil || len(cmd) == 0 {
+		util.Die("invalid command %q for guess source %s", command, name)
+	}
+	util.RefuseIfNotAllowed(cmd[0])
+	util.ProgressMsg(shellquote.Join(cmd...))
+
+	c := util.Command(ctx, cmd)
+	c.Env = append(os.Environ(), "UPM_LANGUAGE="+b.Name)
+	c.Stderr = os.Stderr
+	outputB, err := util.CommandOutput(ctx, c)
+	if err != nil {
+		util.Die("guess source %s failed: %s", name, err)
+	}
+
+	var suggestions []suggestion
+	if err := json.Unmarshal(outputB, &suggestions); err != nil {
+		util.Die("guess source %s: %s", name, err)
+	}
+	pkgs := map[api.PkgName]api.GuessedPkg{}
+	for _, s := range suggestions {
+		if s.Name == "" {
+			util.Die("guess source %s suggested a package with no name", name)
+		}
+		pkgs[api.PkgName(s.Name)] = api.GuessedPkg{
+			Files:      s.Files,
+			Confidence: s.Confidence,
+			Spec:       api.PkgSpec(s.Spec),
+			Dev:        s.Dev,
+		}
+	}
+	return pkgs
+}
@@ -0,0 +1,126 @@
+package guess
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/replit/upm/internal/api"
+	"github.com/replit/upm/internal/config"
+	"github.com/replit/upm/internal/util"
+)
+
+var testBackend = api.LanguageBackend{
+	Name: "test",
+	NormalizePackageName: func(name api.PkgName) api.PkgName {
+		return api.PkgName(strings.ToLower(string(name)))
+	},
+}
+
+func TestMergerCombinesSuggestions(t *testing.T) {
+	m := newMerger(testBackend)
+	m.add(map[api.PkgName]api.GuessedPkg{
+		"Flask":    {Files: []string{"b.py", "a.py"}, Confidence: 0.9},
+		"requests": {Files: []string{"a.py"}, Confidence: 0.5},
+	}, "")
+	m.add(map[api.PkgName]api.GuessedPkg{
+		"flask":      {Files: []string{"a.py"}, Confidence: 0.4},
+		"acme-utils": {Confidence: 1},
+	}, "acme")
+
+	require.Equal(t, map[api.PkgName]api.GuessedPkg{
+		"Flask": {
+			Files:      []string{"a.py", "b.py"},
+			Confidence: 0.9,
+			Sources:    []string{"acme"},
+		},
+		"requests": {
+			Files:      []string{"a.py"},
+			Confidence: 0.5,
+		},
+		"acme-utils": {
+			Confidence: 1,
+			Sources:    []string{"acme"},
+		},
+	}, m.result())
+}
+
+func TestMergerLeavesOutUnlikelyPackages(t *testing.T) {
+	defer func(old float64) { config.MinConfidence = old }(config.MinConfidence)
+	config.MinConfidence = 0.6
+	m := newMerger(testBackend)
+	m.add(map[api.PkgName]api.GuessedPkg{
+		"flask":    {Confidence: 0.9},
+		"requests": {Confidence: 0.5},
+		"yaml":     {Confidence: 0.3},
+		"unknown":  {},
+	}, "")
+	// Another source makes yaml more likely.
+	m.add(map[api.PkgName]api.GuessedPkg{"yaml": {Confidence: 0.7}}, "db")
+	require.Equal(t, map[api.PkgName]api.GuessedPkg{
+		"flask":   {Confidence: 0.9},
+		"yaml":    {Confidence: 0.7, Sources: []string{"db"}},
+		"unknown": {},
+	}, m.result())
+}
+
+func TestRunCommand(t *testing.T) {
+	pkgs := runCommand(context.Background(), testBackend, "echo", `sh -c 'echo "[{\"name\": \"acme-$UPM_LANGUAGE\", \"files\": [\"app.py\"], \"confidence\": 0.8}]"'`)
+	require.Equal(t, map[api.PkgName]api.GuessedPkg{
+		"acme-test": {Files: []string{"app.py"}, Confidence: 0.8},
+	}, pkgs)
+}
+
+func TestChangedDetailsScansOnlyChangedFiles(t *testing.T) {
+	require.NoError(t, os.Chdir(t.TempDir()))
+	require.NoError(t, ioutil.WriteFile("a.txt", []byte("flask"), 0666))
+	require.NoError(t, ioutil.WriteFile("b.txt", []byte("requests"), 0666))
+
+	// Each file imports the package that it names.
+	scanned := []string{}
+	b := testBackend
+	b.GuessDetails = func(ctx context.Context) (map[api.PkgName]api.GuessedPkg, bool) {
+		pkgs := map[api.PkgName]api.GuessedPkg{}
+		for _, file := range []string{"a.txt", "b.txt"} {
+			if !util.IsScanned(file) || !util.Exists(file) {
+				continue
+			}
+			scanned = append(scanned, file)
+			contents, err := ioutil.ReadFile(file)
+			require.NoError(t, err)
+			pkgs[api.PkgName(contents)] = api.GuessedPkg{Files: []string{file}}
+		}
+		return pkgs, true
+	}
+
+	require.Equal(t, map[api.PkgName]api.GuessedPkg{
+		"flask":    {Files: []string{"a.txt"}},
+		"requests": {Files: []string{"b.txt"}},
+	}, changedDetails(context.Background(), b, false))
+	require.Equal(t, []string{"a.txt", "b.txt"}, scanned)
+
+	scanned = []string{}
+	require.NoError(t, ioutil.WriteFile("b.txt", []byte("numpy"), 0666))
+	later := time.Now().Add(time.Minute)
+	require.NoError(t, os.Chtimes("b.txt", later, later))
+	require.Equal(t, map[api.PkgName]api.GuessedPkg{
+		"flask": {Files: []string{"a.txt"}},
+		"numpy": {Files: []string{"b.txt"}},
+	}, changedDetails(context.Background(), b, false))
+	require.Equal(t, []string{"b.txt"}, scanned)
+
+	// A deleted file takes its packages with it.
+	scanned = []string{}
+	earlier := time.Now().Add(-time.Minute)
+	require.NoError(t, os.Chtimes("b.txt", earlier, earlier))
+	require.NoError(t, os.Remove("a.txt"))
+	require.Equal(t, map[api.PkgName]api.GuessedPkg{
+		"numpy": {Files: []string{"b.txt"}},
+	}, changedDetails(context.Background(), b, false))
+	require.Equal(t, []string{}, scanned)
+}
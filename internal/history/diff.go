@@ -0,0 +1,201 @@
+package history
+
+import (
+	"fmt"
+	"strings"
+)
+
+// diffContext is the number of unchanged lines shown around each
+// change, as with diff -u.
+const diffContext = 3
+
+// maxEditDistance bounds the work done to find the smallest diff
+// between two files. Past it, the diff just replaces every line,
+// which is still correct, just not as readable; this only happens
+// when most of a large file changes, such as a lockfile that is
+// regenerated from scratch.
+const maxEditDistance = 2000
+
+// edit is one line of a diff: kept (' '), deleted ('-'), or inserted
+// ('+').
+type edit struct {
+	kind byte
+	line string
+}
+
+// splitLines splits file contents into lines, each including its
+// newline (apart from the last line of a file that doesn't end with
+// one).
+func splitLines(contents string) []string {
+	lines := strings.SplitAfter(contents, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// diffLines returns a shortest edit script turning a into b, using
+// Myers' algorithm.
+func diffLines(a []string, b []string) []edit {
+	n, m := len(a), len(b)
+	offset := n + m + 1
+	v := make([]int, 2*offset+1)
+	// trace[d] holds v[k] for k in [-d-1, d+1] as it was before
+	// round d, which is all that backtracking needs.
+	trace := [][]int{}
+
+	for d := 0; d <= n+m; d++ {
+		if d > maxEditDistance {
+			return replaceAll(a, b)
+		}
+		trace = append(trace, append([]int{}, v[offset-d-1:offset+d+2]...))
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+				x = v[offset+k+1]
+			} else {
+				x = v[offset+k-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+			v[offset+k] = x
+			if x >= n && y >= m {
+				return backtrack(trace, a, b)
+			}
+		}
+	}
+	return replaceAll(a, b)
+}
+
+// backtrack recovers the edit script from the trace recorded by
+// diffLines.
+func backtrack(trace [][]int, a []string, b []string) []edit {
+	reversed := []edit{}
+	x, y := len(a), len(b)
+	for d := len(trace) - 1; d >= 0; d-- {
+		// The saved window starts at k = -d-1.
+		get := func(k int) int { return trace[d][k+d+1] }
+		k := x - y
+		var prevK int
+		if k == -d || (k != d && get(k-1) < get(k+1)) {
+			prevK = k + 1
+		} else {
+			prevK = k - 1
+		}
+		prevX := get(prevK)
+		prevY := prevX - prevK
+		for x > prevX && y > prevY {
+			reversed = append(reversed, edit{' ', a[x-1]})
+			x--
+			y--
+		}
+		if d > 0 {
+			if x == prevX {
+				reversed = append(reversed, edit{'+', b[y-1]})
+			} else {
+				reversed = append(reversed, edit{'-', a[x-1]})
+			}
+		}
+		x, y = prevX, prevY
+	}
+
+	edits := make([]edit, len(reversed))
+	for i, e := range reversed {
+		edits[len(reversed)-1-i] = e
+	}
+	return edits
+}
+
+// replaceAll returns an edit script that deletes every line of a and
+// then inserts every line of b.
+func replaceAll(a []string, b []string) []edit {
+	edits := []edit{}
+	for _, line := range a {
+		edits = append(edits, edit{'-', line})
+	}
+	for _, line := range b {
+		edits = append(edits, edit{'+', line})
+	}
+	return edits
+}
+
+// hunkRange formats one side of a hunk header. As with diff -u, an
+// empty range is given by the line before it.
+func hunkRange(start int, count int) string {
+	if count == 0 {
+		return fmt.Sprintf("%d,0", start)
+	}
+	if count == 1 {
+		return fmt.Sprintf("%d", start+1)
+	}
+	return fmt.Sprintf("%d,%d", start+1, count)
+}
+
+// unifiedDiff returns a unified diff from before to after, or the
+// empty string if they are the same. The file names go in the header;
+// /dev/null stands for a file that doesn't exist.
+func unifiedDiff(fromName string, toName string, before string, after string) string {
+	if before == after {
+		return ""
+	}
+	edits := diffLines(splitLines(before), splitLines(after))
+
+	var out strings.Builder
+	fmt.Fprintf(&out, "--- %s\n+++ %s\n", fromName, toName)
+
+	// Position in a and b of each edit, before it is applied.
+	aPos := make([]int, len(edits)+1)
+	bPos := make([]int, len(edits)+1)
+	for i, e := range edits {
+		aPos[i+1], bPos[i+1] = aPos[i], bPos[i]
+		if e.kind != '+' {
+			aPos[i+1]++
+		}
+		if e.kind != '-' {
+			bPos[i+1]++
+		}
+	}
+
+	for i := 0; i < len(edits); {
+		if edits[i].kind == ' ' {
+			i++
+			continue
+		}
+		// A hunk starts with the context before the first
+		// change and runs until there are more than twice the
+		// context's worth of unchanged lines in a row.
+		start := i - diffContext
+		if start < 0 {
+			start = 0
+		}
+		end := i
+		for j := i; j < len(edits); j++ {
+			if edits[j].kind != ' ' {
+				end = j + 1
+			} else if j-end >= 2*diffContext {
+				break
+			}
+		}
+		stop := end + diffContext
+		if stop > len(edits) {
+			stop = len(edits)
+		}
+
+		fmt.Fprintf(&out, "@@ -%s +%s @@\n",
+			hunkRange(aPos[start], aPos[stop]-aPos[start]),
+			hunkRange(bPos[start], bPos[stop]-bPos[start]),
+		)
+		for _, e := range edits[start:stop] {
+			out.WriteByte(e.kind)
+			out.WriteString(e.line)
+			if !strings.HasSuffix(e.line, "\n") {
+				out.WriteString("\n\\ No newline at end of file\n")
+			}
+		}
+		i = stop
+	}
+	return out.String()
+}
@@ -0,0 +1,281 @@
+// Package history implements 'upm history', which keeps a unified
+// diff of every change UPM makes to the specfile and lockfile under
+// .upm/diffs, so that it is possible to find out what changed a
+// manifest and when.
+package history
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/user"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kballard/go-shellquote"
+
+	"github.com/replit/upm/internal/api"
+	"github.com/replit/upm/internal/util"
+)
+
+// diffsDir is the directory in which the diffs are kept.
+const diffsDir = ".upm/diffs"
+
+// maxEntries is the number of diffs that are kept. Once there are
+// more, the oldest are deleted.
+const maxEntries = 100
+
+// diffFileRegexp matches the name of a diff file, capturing its
+// number.
+var diffFileRegexp = regexp.MustCompile(`^([0-9]+)\.diff$`)
+
+// Header lines at the start of every diff file, which patch ignores.
+const (
+	commandPrefix = "# command: "
+	datePrefix    = "# date: "
+	userPrefix    = "# user: "
+	filesPrefix   = "# files: "
+	failedLine    = "# failed"
+)
+
+// Entry describes a recorded change.
+type Entry struct {
+	// The number of the entry, which increases with every change.
+	Number int `json:"number"`
+	// The command line that made the change.
+	Command string `json:"command"`
+	// When the change was made, in RFC 3339 format.
+	Date string `json:"date"`
+	// The name of the user who made the change, if known.
+	User string `json:"user"`
+	// The files that were changed.
+	Files []string `json:"files"`
+	// True if the command failed partway through making the
+	// change.
+	Failed bool `json:"failed"`
+}
+
+// Recording remembers the specfile and lockfile, and any other files
+// it was given, as they were when it was started, so that the change to them can be recorded.
+type Recording struct {
+	files    []string
+	before   map[string]*string
+	changed  []string
+	finished bool
+}
+
+// readFile returns the contents of the given file, or nil if it
+// doesn't exist.
+func readFile(filename string) *string {
+	contentsB, err := ioutil.ReadFile(filename)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		util.Die("%s: %s", filename, err)
+	}
+	contents := string(contentsB)
+	return &contents
+}
+
+// Start begins recording a change to the specfile and lockfile of the
+// given backend, and to the given other files that the change makes,
+// such as exports of the lockfile. It should be called before anything
+// is changed. If the process dies before Finish is called, whatever
+// change was made up to that point is recorded as failed.
+func Start(b api.LanguageBackend, files ...string) *Recording {
+	r := &Recording{
+		files:  append([]string{b.Specfile, b.Lockfile}, files...),
+		before: map[string]*string{},
+	}
+	for _, filename := range r.files {
+		if filename != "" {
+			r.before[filename] = readFile(filename)
+		}
+	}
+	util.OnDie(func() { r.record(true) })
+	return r
+}
+
+// diffName returns the name to use for a file in a diff header, given
+// its contents on that side of the diff.
+func diffName(prefix string, filename string, contents *string) string {
+	if contents == nil {
+		return "/dev/null"
+	}
+	return prefix + filepath.ToSlash(filename)
+}
+
+// Finish records the change made since Start, if there was one, as a
+// new diff file, and deletes the oldest diffs if there are too many.
+func (r *Recording) Finish() {
+	r.record(false)
+}
+
+// Changed returns the files that were changed between Start and
+// Finish, which must have been called already.
+func (r *Recording) Changed() []string {
+	return r.changed
+}
+
+// record implements Finish, marking the change as failed if the
+// process is dying. Only the first call does anything.
+func (r *Recording) record(failed bool) {
+	if r.finished {
+		return
+	}
+	r.finished = true
+
+	diff := ""
+	changed := []string{}
+	for _, filename := range r.files {
+		if filename == "" {
+			continue
+		}
+		before, after := r.before[filename], readFile(filename)
+		if before == nil && after == nil {
+			continue
+		}
+		beforeStr, afterStr := "", ""
+		if before != nil {
+			beforeStr = *before
+		}
+		if after != nil {
+			afterStr = *after
+		}
+		fileDiff := unifiedDiff(
+			diffName("a/", filename, before), diffName("b/", filename, after),
+			beforeStr, afterStr,
+		)
+		if fileDiff != "" {
+			diff += fileDiff
+			changed = append(changed, filepath.ToSlash(filename))
+		}
+	}
+	r.changed = changed
+	if diff == "" {
+		return
+	}
+
+	username := ""
+	if u, err := user.Current(); err == nil {
+		username = u.Username
+	}
+	command := shellquote.Join(append([]string{"upm"}, os.Args[1:]...)...)
+	command = strings.Replace(command, "\n", " ", -1)
+	header := commandPrefix + command + "\n" +
+		datePrefix + time.Now().UTC().Format(time.RFC3339) + "\n" +
+		userPrefix + username + "\n" +
+		filesPrefix + shellquote.Join(changed...) + "\n"
+	if failed {
+		header += failedLine + "\n"
+	}
+
+	if err := os.MkdirAll(diffsDir, 0777); err != nil {
+		util.Die("%s: %s", diffsDir, err)
+	}
+	numbers := listNumbers()
+	next := 1
+	if len(numbers) > 0 {
+		next = numbers[len(numbers)-1] + 1
+	}
+	filename := diffFile(next)
+	util.ProgressMsg("write " + filename)
+	util.TryWriteAtomic(filename, []byte(header+diff))
+
+	numbers = append(numbers, next)
+	for len(numbers) > maxEntries {
+		if err := os.Remove(diffFile(numbers[0])); err != nil {
+			util.Die("%s", err)
+		}
+		numbers = numbers[1:]
+	}
+}
+
+// diffFile returns the path of the diff file with the given number.
+func diffFile(number int) string {
+	return filepath.Join(diffsDir, fmt.Sprintf("%06d.diff", number))
+}
+
+// listNumbers returns the numbers of the diff files that exist, in
+// increasing order.
+func listNumbers() []int {
+	infos, err := ioutil.ReadDir(diffsDir)
+	if os.IsNotExist(err) {
+		return []int{}
+	} else if err != nil {
+		util.Die("%s: %s", diffsDir, err)
+	}
+	numbers := []int{}
+	for _, info := range infos {
+		if m := diffFileRegexp.FindStringSubmatch(info.Name()); m != nil {
+			number, _ := strconv.Atoi(m[1])
+			numbers = append(numbers, number)
+		}
+	}
+	sort.Ints(numbers)
+	return numbers
+}
+
+// readEntry reads the header of the diff file with the given number.
+func readEntry(number int) Entry {
+	filename := diffFile(number)
+	file, err := os.Open(filename)
+	if err != nil {
+		util.Die("%s", err)
+	}
+	defer file.Close()
+
+	entry := Entry{Number: number, Files: []string{}}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if !strings.HasPrefix(line, "# ") {
+			break
+		}
+		switch {
+		case line == failedLine:
+			entry.Failed = true
+		case strings.HasPrefix(line, commandPrefix):
+			entry.Command = strings.TrimPrefix(line, commandPrefix)
+		case strings.HasPrefix(line, datePrefix):
+			entry.Date = strings.TrimPrefix(line, datePrefix)
+		case strings.HasPrefix(line, userPrefix):
+			entry.User = strings.TrimPrefix(line, userPrefix)
+		case strings.HasPrefix(line, filesPrefix):
+			files, err := shellquote.Split(strings.TrimPrefix(line, filesPrefix))
+			if err != nil {
+				util.Die("%s: %s", filename, err)
+			}
+			entry.Files = files
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		util.Die("%s: %s", filename, err)
+	}
+	return entry
+}
+
+// List returns every recorded change that has been kept, oldest
+// first.
+func List() []Entry {
+	entries := []Entry{}
+	for _, number := range listNumbers() {
+		entries = append(entries, readEntry(number))
+	}
+	return entries
+}
+
+// Show returns the contents of the diff file with the given number,
+// terminating the process if there is none.
+func Show(number int) string {
+	contents := readFile(diffFile(number))
+	if contents == nil {
+		util.Die("no change numbered %d in %s (see 'upm history')", number, diffsDir)
+	}
+	return *contents
+}
@@ -0,0 +1,97 @@
+package history
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/replit/upm/internal/api"
+)
+
+func TestUnifiedDiff(t *testing.T) {
+	require.Equal(t, "", unifiedDiff("a/x", "b/x", "same\n", "same\n"))
+
+	before := "a\nb\nc\nd\ne\nf\ng\nh\ni\nj\nk\nl\n"
+	after := "a\nB\nc\nd\ne\nf\ng\nh\ni\nj\nk\nl\nm\n"
+	require.Equal(t, `--- a/x
++++ b/x
+@@ -1,5 +1,5 @@
+ a
+-b
++B
+ c
+ d
+ e
+@@ -10,3 +10,4 @@
+ j
+ k
+ l
++m
+`, unifiedDiff("a/x", "b/x", before, after))
+
+	require.Equal(t, `--- /dev/null
++++ b/x
+@@ -0,0 +1,2 @@
++one
++two
+\ No newline at end of file
+`, unifiedDiff("/dev/null", "b/x", "", "one\ntwo"))
+}
+
+func TestDiffLinesFallback(t *testing.T) {
+	a, b := []string{}, []string{}
+	for i := 0; i < maxEditDistance+1; i++ {
+		a = append(a, fmt.Sprintf("a%d\n", i))
+		b = append(b, fmt.Sprintf("b%d\n", i))
+	}
+	edits := diffLines(a, b)
+	require.Len(t, edits, len(a)+len(b))
+	require.Equal(t, edit{'-', "a0\n"}, edits[0])
+	require.Equal(t, edit{'+', "b0\n"}, edits[len(a)])
+}
+
+func TestRecording(t *testing.T) {
+	cwd, err := os.Getwd()
+	require.NoError(t, err)
+	require.NoError(t, os.Chdir(t.TempDir()))
+	defer os.Chdir(cwd)
+
+	b := api.LanguageBackend{Specfile: "spec.txt", Lockfile: "lock.txt"}
+	write := func(filename string, contents string) {
+		require.NoError(t, ioutil.WriteFile(filename, []byte(contents), 0666))
+	}
+
+	write("spec.txt", "one\n")
+	r := Start(b)
+	r.Finish()
+	require.Empty(t, List())
+	require.Empty(t, r.Changed())
+
+	for i := 0; i < maxEntries+2; i++ {
+		r := Start(b)
+		write("spec.txt", fmt.Sprintf("%d\n", i))
+		if i == 0 {
+			write("lock.txt", "locked\n")
+		}
+		r.Finish()
+		if i == 0 {
+			require.Equal(t, []string{"spec.txt", "lock.txt"}, r.Changed())
+		}
+	}
+
+	entries := List()
+	require.Len(t, entries, maxEntries)
+	require.Equal(t, 3, entries[0].Number)
+	require.Equal(t, []string{"spec.txt"}, entries[0].Files)
+	require.False(t, entries[0].Failed)
+
+	last := entries[len(entries)-1]
+	require.Equal(t, maxEntries+2, last.Number)
+	diff := Show(last.Number)
+	require.True(t, strings.HasPrefix(diff, "# command: upm"))
+	require.Contains(t, diff, fmt.Sprintf("-%d\n+%d\n", maxEntries, maxEntries+1))
+}
@@ -0,0 +1,299 @@
+// Package osv looks up the known vulnerabilities of packages in the
+// database of OSV.dev (https://osv.dev), which gathers the advisories
+// of GitHub, PyPI, RustSec, and others under one API, for 'upm audit'.
+package osv
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/replit/upm/internal/project"
+	"github.com/replit/upm/internal/util"
+)
+
+// DefaultURL is the API of OSV.dev, which is used unless another one is
+// configured.
+const DefaultURL = "https://api.osv.dev/v1"
+
+// batchSize is the most queries that OSV.dev answers in one batch.
+const batchSize = 1000
+
+// URL returns the API to look vulnerabilities up in: UPM_OSV_URL, or
+// else osv-url in the [audit] table of the project config, or else
+// DefaultURL. A mirror must answer the same requests as OSV.dev.
+func URL() string {
+	if url := os.Getenv("UPM_OSV_URL"); url != "" {
+		return url
+	}
+	if url := project.Read().Audit.OSVURL; url != "" {
+		return url
+	}
+	return DefaultURL
+}
+
+// Package is a package at the version to look vulnerabilities up for.
+type Package struct {
+	// The ecosystem of the package, as OSV names it, such as
+	// "PyPI" or "npm".
+	Ecosystem string
+	Name      string
+	Version   string
+}
+
+// Vuln is a known vulnerability of a package.
+type Vuln struct {
+	// The ID of the advisory in OSV, such as "GHSA-xxxx-xxxx-xxxx"
+	// or "PYSEC-2021-66", and the IDs of the same vulnerability in
+	// other databases, such as CVEs.
+	ID      string   `json:"id"`
+	Aliases []string `json:"aliases,omitempty"`
+
+	// A one-line summary, which may be empty.
+	Summary string `json:"summary"`
+
+	Severity Severity `json:"severity"`
+
+	// The versions of the package that fix the vulnerability, in
+	// the order in which the advisory gives them, or none if no
+	// fix has been released.
+	Fixed []string `json:"fixed"`
+}
+
+// batchQuery is a query in a request to the querybatch endpoint.
+type batchQuery struct {
+	Package struct {
+		Ecosystem string `json:"ecosystem"`
+		Name      string `json:"name"`
+	} `json:"package"`
+	Version   string `json:"version"`
+	PageToken string `json:"page_token,omitempty"`
+}
+
+// batchResponse is the response of the querybatch endpoint, which only
+// gives the IDs of the vulnerabilities, in the order of the queries.
+type batchResponse struct {
+	Results []struct {
+		Vulns []struct {
+			ID string `json:"id"`
+		} `json:"vulns"`
+		NextPageToken string `json:"next_page_token"`
+	} `json:"results"`
+}
+
+// severityEntry is a severity in an advisory, such as a CVSS vector.
+type severityEntry struct {
+	Type  string `json:"type"`
+	Score string `json:"score"`
+}
+
+// record is an advisory as the vulns endpoint returns it, as far as
+// 'upm audit' reads it (see https://ossf.github.io/osv-schema/).
+type record struct {
+	ID       string          `json:"id"`
+	Summary  string          `json:"summary"`
+	Aliases  []string        `json:"aliases"`
+	Severity []severityEntry `json:"severity"`
+	Affected []struct {
+		Package struct {
+			Ecosystem string `json:"ecosystem"`
+			Name      string `json:"name"`
+		} `json:"package"`
+		Severity []severityEntry `json:"severity"`
+		Ranges   []struct {
+			Events []map[string]string `json:"events"`
+		} `json:"ranges"`
+	} `json:"affected"`
+	DatabaseSpecific struct {
+		// The severity that GitHub gives, such as "HIGH".
+		Severity string `json:"severity"`
+	} `json:"database_specific"`
+}
+
+// post sends the given request body to the given endpoint of the API
+// and decodes the response into v.
+func post(ctx context.Context, url string, body interface{}, v interface{}) error {
+	bodyB, err := json.Marshal(body)
+	if err != nil {
+		util.Panicf("couldn't marshal json")
+	}
+	resp, err := util.HTTPPost(ctx, url, "application/json", bytes.NewReader(bodyB))
+	if err != nil {
+		return err
+	}
+	return decode(ctx, url, resp, v)
+}
+
+// get fetches the given endpoint of the API and decodes the response
+// into v.
+func get(ctx context.Context, url string, v interface{}) error {
+	resp, err := util.HTTPGet(ctx, url)
+	if err != nil {
+		return err
+	}
+	return decode(ctx, url, resp, v)
+}
+
+// decode decodes the given response from the given URL into v.
+func decode(ctx context.Context, url string, resp *http.Response, v interface{}) error {
+	defer resp.Body.Close()
+	contents, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		util.Abort(ctx)
+		return fmt.Errorf("%s: %s", url, err)
+	}
+	if resp.StatusCode != 200 {
+		return fmt.Errorf("%s: %s", url, resp.Status)
+	}
+	if err := json.Unmarshal(contents, v); err != nil {
+		return fmt.Errorf("%s: %s", url, err)
+	}
+	return nil
+}
+
+// queryIDs returns the IDs of the vulnerabilities of each of the given
+// packages, from the querybatch endpoint of the given API, following
+// the pages of the packages that have too many for one.
+func queryIDs(ctx context.Context, api string, pkgs []Package) ([][]string, error) {
+	ids := make([][]string, len(pkgs))
+	tokens := make([]string, len(pkgs))
+	pending := []int{}
+	for i := range pkgs {
+		pending = append(pending, i)
+	}
+	for len(pending) > 0 {
+		chunk := pending
+		if len(chunk) > batchSize {
+			chunk = chunk[:batchSize]
+		}
+		pending = pending[len(chunk):]
+
+		queries := []batchQuery{}
+		for _, i := range chunk {
+			var q batchQuery
+			q.Package.Ecosystem = pkgs[i].Ecosystem
+			q.Package.Name = pkgs[i].Name
+			q.Version = pkgs[i].Version
+			q.PageToken = tokens[i]
+			queries = append(queries, q)
+		}
+		var resp batchResponse
+		if err := post(ctx, api+"/querybatch", map[string]interface{}{"queries": queries}, &resp); err != nil {
+			return nil, err
+		}
+		if len(resp.Results) != len(chunk) {
+			return nil, fmt.Errorf("%s/querybatch: got %d results for %d queries",
+				api, len(resp.Results), len(chunk))
+		}
+		for j, result := range resp.Results {
+			i := chunk[j]
+			for _, vuln := range result.Vulns {
+				ids[i] = append(ids[i], vuln.ID)
+			}
+			tokens[i] = result.NextPageToken
+			if tokens[i] != "" {
+				pending = append(pending, i)
+			}
+		}
+	}
+	return ids, nil
+}
+
+// sameName returns whether the given names are of the same package in
+// the given ecosystem, where PyPI doesn't tell "-" from "_" or ".".
+func sameName(ecosystem string, name1 string, name2 string) bool {
+	normalize := func(name string) string {
+		name = strings.ToLower(name)
+		if ecosystem == "PyPI" {
+			name = strings.NewReplacer("_", "-", ".", "-").Replace(name)
+		}
+		return name
+	}
+	return normalize(name1) == normalize(name2)
+}
+
+// vuln returns the vulnerability of the given package that the given
+// advisory describes.
+func (r *record) vuln(pkg Package) Vuln {
+	v := Vuln{ID: r.ID, Aliases: r.Aliases, Summary: r.Summary, Fixed: []string{}}
+	severities := r.Severity
+	for _, affected := range r.Affected {
+		if affected.Package.Ecosystem != pkg.Ecosystem ||
+			!sameName(pkg.Ecosystem, affected.Package.Name, pkg.Name) {
+			continue
+		}
+		severities = append(severities, affected.Severity...)
+		for _, r := range affected.Ranges {
+			for _, event := range r.Events {
+				if fixed := event["fixed"]; fixed != "" && !contains(v.Fixed, fixed) {
+					v.Fixed = append(v.Fixed, fixed)
+				}
+			}
+		}
+	}
+	if severity, ok := ParseSeverity(r.DatabaseSpecific.Severity); ok {
+		v.Severity = severity
+	} else {
+		for _, entry := range severities {
+			if entry.Type != "CVSS_V3" {
+				continue
+			}
+			if score, ok := CVSS3Score(entry.Score); ok {
+				v.Severity = ScoreSeverity(score)
+				break
+			}
+		}
+	}
+	return v
+}
+
+// contains returns whether the given strings include the given one.
+func contains(strs []string, str string) bool {
+	for _, s := range strs {
+		if s == str {
+			return true
+		}
+	}
+	return false
+}
+
+// Query returns the known vulnerabilities of each of the given
+// packages, in the same order, from the given API (see URL). The
+// vulnerabilities of each package are sorted from the most severe, and
+// then by ID. If ctx is done, Query aborts (see util.Abort).
+func Query(ctx context.Context, api string, pkgs []Package) ([][]Vuln, error) {
+	api = strings.TrimSuffix(api, "/")
+	ids, err := queryIDs(ctx, api, pkgs)
+	if err != nil {
+		return nil, err
+	}
+
+	records := map[string]*record{}
+	vulns := make([][]Vuln, len(pkgs))
+	for i, pkg := range pkgs {
+		for _, id := range ids[i] {
+			r, ok := records[id]
+			if !ok {
+				r = &record{}
+				if err := get(ctx, util.JoinURL(api, "vulns", id), r); err != nil {
+					return nil, err
+				}
+				records[id] = r
+			}
+			vulns[i] = append(vulns[i], r.vuln(pkg))
+		}
+		sort.Slice(vulns[i], func(j, k int) bool {
+			if vulns[i][j].Severity != vulns[i][k].Severity {
+				return vulns[i][j].Severity > vulns[i][k].Severity
+			}
+			return vulns[i][j].ID < vulns[i][k].ID
+		})
+	}
+	return vulns, nil
+}
@@ -0,0 +1,105 @@
+package osv
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCVSS3Score(t *testing.T) {
+	for vector, want := range map[string]float64{
+		"CVSS:3.1/AV:N/AC:L/PR:N/UI:N/S:U/C:H/I:H/A:H": 9.8,
+		"CVSS:3.1/AV:N/AC:L/PR:L/UI:N/S:C/C:H/I:H/A:H": 9.9,
+		"CVSS:3.0/AV:N/AC:L/PR:N/UI:R/S:C/C:L/I:L/A:N": 6.1,
+		"CVSS:3.1/AV:L/AC:L/PR:L/UI:N/S:U/C:H/I:N/A:N": 5.5,
+		"CVSS:3.1/AV:N/AC:H/PR:N/UI:N/S:U/C:N/I:N/A:N": 0,
+	} {
+		score, ok := CVSS3Score(vector)
+		require.True(t, ok, vector)
+		require.Equal(t, want, score, vector)
+	}
+	for _, vector := range []string{
+		"",
+		"CVSS:2.0/AV:N/AC:L/Au:N/C:P/I:P/A:P",
+		"CVSS:3.1/AV:N/AC:L/PR:N/UI:N/S:U/C:H/I:H",
+		"CVSS:3.1/AV:X/AC:L/PR:N/UI:N/S:U/C:H/I:H/A:H",
+	} {
+		_, ok := CVSS3Score(vector)
+		require.False(t, ok, vector)
+	}
+}
+
+func TestParseSeverity(t *testing.T) {
+	severity, ok := ParseSeverity("MODERATE")
+	require.True(t, ok)
+	require.Equal(t, SeverityModerate, severity)
+	severity, ok = ParseSeverity("medium")
+	require.True(t, ok)
+	require.Equal(t, SeverityModerate, severity)
+	_, ok = ParseSeverity("unknown")
+	require.False(t, ok)
+	require.Equal(t, SeverityHigh, ScoreSeverity(7.5))
+}
+
+func TestQuery(t *testing.T) {
+	pages := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/querybatch":
+			var req struct {
+				Queries []batchQuery `json:"queries"`
+			}
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+			results := []interface{}{}
+			for _, q := range req.Queries {
+				switch {
+				case q.Package.Name == "jinja2" && q.PageToken == "":
+					results = append(results, map[string]interface{}{
+						"vulns":           []interface{}{map[string]string{"id": "PYSEC-1"}},
+						"next_page_token": "more",
+					})
+				case q.Package.Name == "jinja2":
+					pages++
+					results = append(results, map[string]interface{}{
+						"vulns": []interface{}{map[string]string{"id": "GHSA-2"}},
+					})
+				default:
+					results = append(results, map[string]interface{}{})
+				}
+			}
+			json.NewEncoder(w).Encode(map[string]interface{}{"results": results})
+		case "/vulns/PYSEC-1":
+			w.Write([]byte(`{"id": "PYSEC-1", "summary": "sandbox escape",
+				"severity": [{"type": "CVSS_V3", "score": "CVSS:3.1/AV:N/AC:L/PR:N/UI:N/S:U/C:H/I:H/A:H"}],
+				"affected": [{"package": {"ecosystem": "PyPI", "name": "Jinja2"},
+					"ranges": [{"type": "ECOSYSTEM", "events": [{"introduced": "0"}, {"fixed": "2.10.1"}]}]},
+					{"package": {"ecosystem": "PyPI", "name": "other"},
+					"ranges": [{"type": "ECOSYSTEM", "events": [{"introduced": "0"}, {"fixed": "9.9"}]}]}]}`))
+		case "/vulns/GHSA-2":
+			w.Write([]byte(`{"id": "GHSA-2", "aliases": ["CVE-2020-28493"],
+				"database_specific": {"severity": "MODERATE"},
+				"affected": [{"package": {"ecosystem": "PyPI", "name": "jinja2"},
+					"ranges": [{"type": "ECOSYSTEM", "events": [{"introduced": "0"}, {"fixed": "2.11.3"}]}]}]}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	vulns, err := Query(context.Background(), server.URL+"/", []Package{
+		{Ecosystem: "PyPI", Name: "flask", Version: "2.0.0"},
+		{Ecosystem: "PyPI", Name: "jinja2", Version: "2.10"},
+	})
+	require.NoError(t, err)
+	require.Equal(t, 1, pages)
+	require.Len(t, vulns, 2)
+	require.Empty(t, vulns[0])
+	require.Equal(t, []Vuln{
+		{ID: "PYSEC-1", Summary: "sandbox escape", Severity: SeverityCritical, Fixed: []string{"2.10.1"}},
+		{ID: "GHSA-2", Aliases: []string{"CVE-2020-28493"}, Severity: SeverityModerate, Fixed: []string{"2.11.3"}},
+	}, vulns[1])
+}
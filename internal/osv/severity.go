@@ -0,0 +1,157 @@
+package osv
+
+import (
+	"fmt"
+	"math"
+	"strings"
+)
+
+// Severity is how severe a vulnerability is, on the scale that GitHub
+// uses for its advisories, which is that of CVSS with "moderate" for
+// "medium".
+type Severity int
+
+// Constants of type Severity, from the least severe.
+const (
+	// SeverityUnknown is the severity of an advisory that gives
+	// none that UPM can read.
+	SeverityUnknown Severity = iota
+	SeverityLow
+	SeverityModerate
+	SeverityHigh
+	SeverityCritical
+)
+
+// severityNames are the names of the severities, in the order of their
+// constants.
+var severityNames = []string{"unknown", "low", "moderate", "high", "critical"}
+
+func (s Severity) String() string {
+	if int(s) < len(severityNames) {
+		return severityNames[s]
+	}
+	return fmt.Sprintf("Severity(%d)", int(s))
+}
+
+// MarshalJSON writes the severity as its name.
+func (s Severity) MarshalJSON() ([]byte, error) {
+	return []byte(`"` + s.String() + `"`), nil
+}
+
+// ParseSeverity returns the severity with the given name, in any case,
+// taking "medium" for "moderate", or false if there is none. The name
+// "unknown" isn't parsed, since it says nothing.
+func ParseSeverity(name string) (Severity, bool) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	if name == "medium" {
+		name = "moderate"
+	}
+	for i, known := range severityNames {
+		if i > 0 && name == known {
+			return Severity(i), true
+		}
+	}
+	return SeverityUnknown, false
+}
+
+// ScoreSeverity returns the severity of the given CVSS score, by the
+// ratings of CVSS v3. A score of 0 is rated low, since only a
+// vulnerability is ever scored.
+func ScoreSeverity(score float64) Severity {
+	switch {
+	case score >= 9:
+		return SeverityCritical
+	case score >= 7:
+		return SeverityHigh
+	case score >= 4:
+		return SeverityModerate
+	}
+	return SeverityLow
+}
+
+// cvss3Weights are the weights of the values of the base metrics of
+// CVSS v3, other than PR, which depends on the scope.
+var cvss3Weights = map[string]map[string]float64{
+	"AV": {"N": 0.85, "A": 0.62, "L": 0.55, "P": 0.2},
+	"AC": {"L": 0.77, "H": 0.44},
+	"UI": {"N": 0.85, "R": 0.62},
+	"C":  {"H": 0.56, "L": 0.22, "N": 0},
+	"I":  {"H": 0.56, "L": 0.22, "N": 0},
+	"A":  {"H": 0.56, "L": 0.22, "N": 0},
+}
+
+// cvss3Roundup rounds the given number up to one decimal place, as
+// CVSS v3.1 specifies, so that floating point errors don't round it up
+// too far.
+func cvss3Roundup(x float64) float64 {
+	i := int(math.Round(x * 100000))
+	if i%10000 == 0 {
+		return float64(i) / 100000
+	}
+	return float64(i/10000+1) / 10
+}
+
+// CVSS3Score returns the base score of the given CVSS v3 vector, such
+// as "CVSS:3.1/AV:N/AC:L/PR:N/UI:N/S:U/C:H/I:H/A:H", or false if it
+// isn't one.
+func CVSS3Score(vector string) (float64, bool) {
+	parts := strings.Split(vector, "/")
+	if len(parts) == 0 || !strings.HasPrefix(parts[0], "CVSS:3.") {
+		return 0, false
+	}
+	metrics := map[string]string{}
+	for _, part := range parts[1:] {
+		kv := strings.SplitN(part, ":", 2)
+		if len(kv) != 2 {
+			return 0, false
+		}
+		metrics[kv[0]] = kv[1]
+	}
+
+	weights := map[string]float64{}
+	for metric, values := range cvss3Weights {
+		weight, ok := values[metrics[metric]]
+		if !ok {
+			return 0, false
+		}
+		weights[metric] = weight
+	}
+	changed := false
+	switch metrics["S"] {
+	case "U":
+	case "C":
+		changed = true
+	default:
+		return 0, false
+	}
+	switch metrics["PR"] {
+	case "N":
+		weights["PR"] = 0.85
+	case "L":
+		weights["PR"] = 0.62
+		if changed {
+			weights["PR"] = 0.68
+		}
+	case "H":
+		weights["PR"] = 0.27
+		if changed {
+			weights["PR"] = 0.5
+		}
+	default:
+		return 0, false
+	}
+
+	iss := 1 - (1-weights["C"])*(1-weights["I"])*(1-weights["A"])
+	impact := 6.42 * iss
+	if changed {
+		impact = 7.52*(iss-0.029) - 3.25*math.Pow(iss-0.02, 15)
+	}
+	exploitability := 8.22 * weights["AV"] * weights["AC"] * weights["PR"] * weights["UI"]
+	if impact <= 0 {
+		return 0, true
+	}
+	if changed {
+		return cvss3Roundup(math.Min(1.08*(impact+exploitability), 10)), true
+	}
+	return cvss3Roundup(math.Min(impact+exploitability, 10)), true
+}
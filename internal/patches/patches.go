@@ -0,0 +1,217 @@
+// Package patches implements 'upm patch', which keeps local changes
+// to installed packages in patch files under .upm/patches and
+// re-applies them whenever packages are installed.
+package patches
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/replit/upm/internal/api"
+	"github.com/replit/upm/internal/util"
+)
+
+// patchesDir is the directory in which patch files are kept. It is
+// meant to be checked into version control.
+const patchesDir = ".upm/patches"
+
+// headerPrefix starts the first line of every patch file, which
+// records the name of the package that the patch is for. Both diff
+// and patch ignore it.
+const headerPrefix = "# upm patch for "
+
+// backendDir returns the directory holding the patch files for the
+// given backend.
+func backendDir(b api.LanguageBackend) string {
+	return filepath.Join(patchesDir, b.Name)
+}
+
+// safeName turns a package name into something that can be used as a
+// filename, e.g. @types/node becomes @types+node.
+func safeName(name api.PkgName) string {
+	return strings.NewReplacer("/", "+", `\`, "+", ":", "+").Replace(string(name))
+}
+
+// patchFile returns the path of the patch file for the given package.
+func patchFile(b api.LanguageBackend, name api.PkgName) string {
+	return filepath.Join(backendDir(b), safeName(name)+".patch")
+}
+
+// pristineDir returns the path of the unmodified copy of the given
+// package that is kept while it is being patched.
+func pristineDir(b api.LanguageBackend, name api.PkgName) string {
+	return filepath.Join(patchesDir, ".pristine", b.Name, safeName(name))
+}
+
+// getInstalledPackageDir returns the directory in which the given
+// package is installed, terminating the process if there is none.
+func getInstalledPackageDir(b api.LanguageBackend, name api.PkgName) string {
+	if b.GetInstalledPackageDir == nil {
+		util.Die("%s does not support patching packages", b.Name)
+	}
+	dir := b.GetInstalledPackageDir(name)
+	if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+		util.Die("%s is not installed (looked in %s)", name, dir)
+	}
+	return dir
+}
+
+// Begin prepares the given package for patching, by saving a copy of
+// it as it was installed. If the package has been patched before, the
+// existing patch is reversed in the copy, so that Commit produces a
+// patch containing both the old and new changes. It returns the
+// directory in which the package should be edited.
+func Begin(ctx context.Context, b api.LanguageBackend, name api.PkgName) string {
+	dir := getInstalledPackageDir(b, name)
+	pristine := pristineDir(b, name)
+
+	if err := os.RemoveAll(pristine); err != nil {
+		util.Die("%s: %s", pristine, err)
+	}
+	util.ProgressMsg("copy " + dir + " to " + pristine)
+	util.CopyTree(ctx, dir, pristine)
+
+	if util.Exists(patchFile(b, name)) {
+		runPatch(ctx, pristine, patchFile(b, name), "-R")
+	}
+
+	return dir
+}
+
+// Commit compares the given package against the copy saved by Begin
+// and writes the differences to its patch file. If there are no
+// differences, the patch file is deleted instead.
+func Commit(ctx context.Context, b api.LanguageBackend, name api.PkgName) {
+	dir := getInstalledPackageDir(b, name)
+	pristine := pristineDir(b, name)
+	if !util.Exists(pristine) {
+		util.Die("%s is not being patched; run 'upm patch %s' first", name, name)
+	}
+
+	outputB, code := util.GetCmdOutputAndExitCode(ctx, []string{
+		"diff", "-ruN", pristine, dir,
+	})
+	if code > 1 {
+		util.Die("diff failed with exit code %d", code)
+	}
+
+	filename := patchFile(b, name)
+	if code == 0 {
+		util.Log("no changes to " + string(name))
+		if util.Exists(filename) {
+			util.ProgressMsg("delete " + filename)
+			os.Remove(filename)
+		}
+	} else {
+		// Rewrite the paths in the diff so that they are
+		// relative to the package, as they would be in a
+		// patch from Git.
+		contents := headerPrefix + string(name) + "\n"
+		for _, line := range strings.SplitAfter(string(outputB), "\n") {
+			for _, prefix := range []string{"diff ", "--- ", "+++ "} {
+				if strings.HasPrefix(line, prefix) {
+					line = strings.Replace(line, pristine+"/", "a/", -1)
+					line = strings.Replace(line, dir+"/", "b/", -1)
+				}
+			}
+			contents += line
+		}
+
+		if err := os.MkdirAll(backendDir(b), 0777); err != nil {
+			util.Die("%s: %s", backendDir(b), err)
+		}
+		util.ProgressMsg("write " + filename)
+		util.TryWriteAtomic(filename, []byte(contents))
+	}
+
+	if err := os.RemoveAll(pristine); err != nil {
+		util.Die("%s: %s", pristine, err)
+	}
+}
+
+// Apply applies every patch for the given backend that is not already
+// applied. It is run after packages are installed, since installing
+// will often have replaced patched files with pristine ones.
+func Apply(ctx context.Context, b api.LanguageBackend) {
+	if b.GetInstalledPackageDir == nil {
+		return
+	}
+
+	filenames, err := filepath.Glob(filepath.Join(backendDir(b), "*.patch"))
+	if err != nil {
+		util.Panicf("patches.Apply: %s", err)
+	}
+	sort.Strings(filenames)
+
+	for _, filename := range filenames {
+		name := readHeader(filename)
+		if name == "" {
+			util.Die("%s: missing %q header", filename, strings.TrimSpace(headerPrefix))
+		}
+
+		dir := b.GetInstalledPackageDir(name)
+		if !util.Exists(dir) {
+			// Not installed (any more), so there's
+			// nothing to patch.
+			continue
+		}
+
+		// If the patch can be reversed, then it has already
+		// been applied.
+		if isApplied(ctx, dir, filename) {
+			continue
+		}
+		runPatch(ctx, dir, filename)
+	}
+}
+
+// readHeader returns the name of the package recorded in the first
+// line of the given patch file, or the empty string if there is none.
+func readHeader(filename string) api.PkgName {
+	file, err := os.Open(filename)
+	if err != nil {
+		util.Die("%s: %s", filename, err)
+	}
+	defer file.Close()
+
+	line, err := bufio.NewReader(file).ReadString('\n')
+	if err != nil && err != io.EOF {
+		util.Die("%s: %s", filename, err)
+	}
+	if !strings.HasPrefix(line, headerPrefix) {
+		return ""
+	}
+	return api.PkgName(strings.TrimSpace(strings.TrimPrefix(line, headerPrefix)))
+}
+
+// absPath returns the absolute version of the given path, since patch
+// resolves paths relative to the directory it is patching.
+func absPath(path string) string {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		util.Die("%s: %s", path, err)
+	}
+	return abs
+}
+
+// isApplied returns true if the given patch has already been applied
+// to the given directory.
+func isApplied(ctx context.Context, dir string, filename string) bool {
+	return util.GetExitCode(ctx, []string{
+		"patch", "-p1", "-R", "-s", "-f", "--dry-run",
+		"-d", dir, "-i", absPath(filename),
+	}, false, false) == 0
+}
+
+// runPatch applies the given patch to the given directory, with any
+// extra arguments for patch (such as -R), terminating the process if
+// it does not apply cleanly.
+func runPatch(ctx context.Context, dir string, filename string, args ...string) {
+	cmd := []string{"patch", "-p1", "-f", "-d", dir, "-i", absPath(filename)}
+	util.RunCmd(ctx, append(cmd, args...))
+}
@@ -0,0 +1,70 @@
+package patches
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/replit/upm/internal/api"
+	"github.com/replit/upm/internal/config"
+	"github.com/replit/upm/internal/util"
+)
+
+func TestPatchRoundTrip(t *testing.T) {
+	for _, tool := range []string{"diff", "patch"} {
+		if _, err := exec.LookPath(tool); err != nil {
+			t.Skipf("%s is not installed", tool)
+		}
+	}
+
+	cwd, err := os.Getwd()
+	require.NoError(t, err)
+	dir, err := ioutil.TempDir("", "upm-patches")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	require.NoError(t, os.Chdir(dir))
+	defer os.Chdir(cwd)
+
+	config.Quiet = true
+	defer func() { config.Quiet = false }()
+
+	pkgDir := filepath.Join("node_modules", "@scope", "left-pad")
+	index := filepath.Join(pkgDir, "index.js")
+	require.NoError(t, os.MkdirAll(pkgDir, 0777))
+	require.NoError(t, ioutil.WriteFile(index, []byte("module.exports = 1;\n"), 0666))
+
+	b := api.LanguageBackend{
+		Name: "test",
+		GetInstalledPackageDir: func(name api.PkgName) string {
+			return filepath.Join("node_modules", string(name))
+		},
+	}
+
+	require.Equal(t, pkgDir, Begin(context.Background(), b, "@scope/left-pad"))
+	require.NoError(t, ioutil.WriteFile(index, []byte("module.exports = 2;\n"), 0666))
+	Commit(context.Background(), b, "@scope/left-pad")
+
+	contents, err := ioutil.ReadFile(filepath.Join(".upm", "patches", "test", "@scope+left-pad.patch"))
+	require.NoError(t, err)
+	require.Contains(t, string(contents), "# upm patch for @scope/left-pad\n")
+	require.Contains(t, string(contents), "+++ b/index.js")
+	require.False(t, util.Exists(pristineDir(b, "@scope/left-pad")))
+
+	// Simulate a reinstall, which loses the change.
+	require.NoError(t, ioutil.WriteFile(index, []byte("module.exports = 1;\n"), 0666))
+	Apply(context.Background(), b)
+	contents, err = ioutil.ReadFile(index)
+	require.NoError(t, err)
+	require.Equal(t, "module.exports = 2;\n", string(contents))
+
+	// Applying again is a no-op.
+	Apply(context.Background(), b)
+	contents, err = ioutil.ReadFile(index)
+	require.NoError(t, err)
+	require.Equal(t, "module.exports = 2;\n", string(contents))
+}
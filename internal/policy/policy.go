@@ -0,0 +1,203 @@
+// Package policy implements command policies, which restrict the
+// programs that UPM may run, for hosts that run UPM on behalf of
+// others, and which may limit the dependencies of their projects. A
+// policy is a TOML file such as:
+//
+//	allowed-commands = ["/usr/bin/npm", "/usr/local/bin/poetry"]
+//
+//	[dependency-limits]
+//	max-direct = 30
+//	max-locked = 300
+//	max-depth = 8
+//	action = "warn"
+//
+// It may be signed with an Ed25519 key, in which case the signature
+// of the file (base64-encoded) is read from the same filename with
+// .sig appended, and the policy is rejected unless the signature is
+// valid for the public key it is loaded with.
+package policy
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+
+	"github.com/replit/upm/internal/config"
+	"github.com/replit/upm/internal/util"
+)
+
+// Policy represents the contents of a policy file.
+type Policy struct {
+	// Absolute paths of the programs that may be run. If the
+	// list is missing or empty, no programs may be run.
+	AllowedCommands []string `toml:"allowed-commands"`
+	// Limits on the dependencies of a project, checked whenever
+	// its lockfile changes.
+	DependencyLimits Limits `toml:"dependency-limits"`
+}
+
+// Limits are the limits on the dependencies of a project that a policy
+// sets. A limit of zero means that there is none.
+type Limits struct {
+	// How many packages the specfile may list.
+	MaxDirect int `toml:"max-direct"`
+	// How many packages the lockfile may list.
+	MaxLocked int `toml:"max-locked"`
+	// How long a chain of dependencies may be, counting a package
+	// in the specfile as 1, a package that it depends on as 2,
+	// and so on.
+	MaxDepth int `toml:"max-depth"`
+	// What to do when a limit is exceeded: "fail" (the default)
+	// rolls the change back and fails, and "warn" only says so.
+	Action string `toml:"action"`
+}
+
+// DependencyLimits are the limits of the policy given with --policy,
+// or none if there is no policy.
+var DependencyLimits Limits
+
+// Any returns true if any of the limits are set.
+func (l Limits) Any() bool {
+	return l.MaxDirect > 0 || l.MaxLocked > 0 || l.MaxDepth > 0
+}
+
+// Warn returns true if exceeding a limit should only be warned about.
+func (l Limits) Warn() bool {
+	return l.Action == "warn"
+}
+
+// Check returns a message for each limit that the given counts of a
+// project's dependencies exceed. A depth below zero isn't known, so it
+// isn't checked.
+func (l Limits) Check(direct int, locked int, depth int) []string {
+	messages := []string{}
+	check := func(what string, count int, limit int) {
+		if limit > 0 && count > limit {
+			messages = append(messages, fmt.Sprintf(
+				"%s is %d, more than the %d that the policy allows", what, count, limit,
+			))
+		}
+	}
+	check("the number of direct dependencies", direct, l.MaxDirect)
+	check("the number of locked packages", locked, l.MaxLocked)
+	if depth >= 0 {
+		check("the depth of the dependencies", depth, l.MaxDepth)
+	}
+	return messages
+}
+
+// Depth returns the length of the longest chain of dependencies that
+// starts from one of the given roots, where deps lists the packages
+// that each package depends on directly. A root counts as 1, and a
+// package that isn't in deps depends on nothing. A chain stops short
+// of going round a cycle.
+func Depth(roots []string, deps map[string][]string) int {
+	depths := map[string]int{}
+	visiting := map[string]bool{}
+	var depth func(pkg string) int
+	depth = func(pkg string) int {
+		if d, ok := depths[pkg]; ok {
+			return d
+		}
+		visiting[pkg] = true
+		d := 1
+		for _, dep := range deps[pkg] {
+			if !visiting[dep] && depth(dep)+1 > d {
+				d = depth(dep) + 1
+			}
+		}
+		visiting[pkg] = false
+		depths[pkg] = d
+		return d
+	}
+
+	max := 0
+	for _, root := range roots {
+		if d := depth(root); d > max {
+			max = d
+		}
+	}
+	return max
+}
+
+// Read reads the policy from the given file and checks that it is
+// valid. If publicKey is not empty, it is a base64-encoded Ed25519
+// public key, and the file must have a signature made with the
+// corresponding private key.
+func Read(filename string, publicKey string) (Policy, error) {
+	contents, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return Policy{}, err
+	}
+
+	if publicKey != "" {
+		if err := verify(filename, contents, publicKey); err != nil {
+			return Policy{}, err
+		}
+	}
+
+	var policy Policy
+	if _, err := toml.Decode(string(contents), &policy); err != nil {
+		return Policy{}, fmt.Errorf("%s: %s", filename, err)
+	}
+	for _, path := range policy.AllowedCommands {
+		if !filepath.IsAbs(path) {
+			return Policy{}, fmt.Errorf(
+				"%s: allowed command %q is not an absolute path", filename, path,
+			)
+		}
+	}
+	limits := policy.DependencyLimits
+	if limits.MaxDirect < 0 || limits.MaxLocked < 0 || limits.MaxDepth < 0 {
+		return Policy{}, fmt.Errorf("%s: dependency limits can't be negative", filename)
+	}
+	if limits.Action != "" && limits.Action != "fail" && limits.Action != "warn" {
+		return Policy{}, fmt.Errorf(
+			"%s: dependency limit action %q is not \"fail\" or \"warn\"", filename, limits.Action,
+		)
+	}
+	return policy, nil
+}
+
+// verify returns an error unless the given contents of a policy file
+// are signed by the given base64-encoded public key.
+func verify(filename string, contents []byte, publicKey string) error {
+	key, err := base64.StdEncoding.DecodeString(publicKey)
+	if err != nil || len(key) != ed25519.PublicKeySize {
+		return fmt.Errorf("invalid policy key: must be a base64-encoded Ed25519 public key")
+	}
+
+	sigFilename := filename + ".sig"
+	sigB, err := ioutil.ReadFile(sigFilename)
+	if err != nil {
+		return fmt.Errorf("policy is not signed: %s", err)
+	}
+	sig, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(sigB)))
+	if err != nil {
+		return fmt.Errorf("%s: %s", sigFilename, err)
+	}
+	if !ed25519.Verify(ed25519.PublicKey(key), contents, sig) {
+		return fmt.Errorf("%s: signature does not match the policy key", sigFilename)
+	}
+	return nil
+}
+
+// Load reads the policy from the given file, as for Read, and puts it
+// into effect for the rest of the process. If the policy can't be
+// read, it exits the process.
+func Load(filename string, publicKey string) {
+	policy, err := Read(filename, publicKey)
+	if err != nil {
+		util.Die("%s", err)
+	}
+	config.AllowedCommands = []string{}
+	for _, path := range policy.AllowedCommands {
+		config.AllowedCommands = append(config.AllowedCommands, filepath.Clean(path))
+	}
+	DependencyLimits = policy.DependencyLimits
+}
@@ -0,0 +1,108 @@
+package policy
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func writePolicy(t *testing.T, contents string) string {
+	filename := filepath.Join(t.TempDir(), "policy.toml")
+	require.NoError(t, ioutil.WriteFile(filename, []byte(contents), 0644))
+	return filename
+}
+
+func TestRead(t *testing.T) {
+	filename := writePolicy(t, `allowed-commands = ["/usr/bin/npm", "/usr/local/bin/poetry"]`)
+	policy, err := Read(filename, "")
+	require.NoError(t, err)
+	require.Equal(t, []string{"/usr/bin/npm", "/usr/local/bin/poetry"}, policy.AllowedCommands)
+
+	filename = writePolicy(t, `allowed-commands = ["npm"]`)
+	_, err = Read(filename, "")
+	require.EqualError(t, err, filename+`: allowed command "npm" is not an absolute path`)
+}
+
+func TestReadSigned(t *testing.T) {
+	public, private, err := ed25519.GenerateKey(nil)
+	require.NoError(t, err)
+	key := base64.StdEncoding.EncodeToString(public)
+
+	contents := `allowed-commands = ["/usr/bin/npm"]`
+	filename := writePolicy(t, contents)
+
+	_, err = Read(filename, key)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "policy is not signed")
+
+	sig := base64.StdEncoding.EncodeToString(ed25519.Sign(private, []byte(contents)))
+	require.NoError(t, ioutil.WriteFile(filename+".sig", []byte(sig+"\n"), 0644))
+	policy, err := Read(filename, key)
+	require.NoError(t, err)
+	require.Equal(t, []string{"/usr/bin/npm"}, policy.AllowedCommands)
+
+	// A policy that has been changed since it was signed is
+	// rejected.
+	require.NoError(t, ioutil.WriteFile(filename, []byte(`allowed-commands = ["/bin/sh"]`), 0644))
+	_, err = Read(filename, key)
+	require.EqualError(t, err, filename+".sig: signature does not match the policy key")
+
+	_, err = Read(filename, "not a key")
+	require.EqualError(t, err, "invalid policy key: must be a base64-encoded Ed25519 public key")
+}
+
+func TestReadDependencyLimits(t *testing.T) {
+	filename := writePolicy(t, `allowed-commands = ["/usr/bin/npm"]
+
+[dependency-limits]
+max-direct = 2
+max-depth = 3
+action = "warn"
+`)
+	policy, err := Read(filename, "")
+	require.NoError(t, err)
+	require.Equal(t, Limits{MaxDirect: 2, MaxDepth: 3, Action: "warn"}, policy.DependencyLimits)
+	require.True(t, policy.DependencyLimits.Any())
+	require.True(t, policy.DependencyLimits.Warn())
+
+	filename = writePolicy(t, "[dependency-limits]\naction = \"ignore\"\n")
+	_, err = Read(filename, "")
+	require.EqualError(t, err, filename+`: dependency limit action "ignore" is not "fail" or "warn"`)
+
+	filename = writePolicy(t, "[dependency-limits]\nmax-locked = -1\n")
+	_, err = Read(filename, "")
+	require.EqualError(t, err, filename+": dependency limits can't be negative")
+}
+
+func TestCheck(t *testing.T) {
+	limits := Limits{MaxDirect: 2, MaxLocked: 10, MaxDepth: 3}
+	require.Empty(t, limits.Check(2, 10, 3))
+	require.Equal(t, []string{
+		"the number of direct dependencies is 3, more than the 2 that the policy allows",
+		"the depth of the dependencies is 4, more than the 3 that the policy allows",
+	}, limits.Check(3, 10, 4))
+
+	// An unknown depth isn't checked.
+	require.Empty(t, limits.Check(1, 1, -1))
+	require.Empty(t, Limits{}.Check(100, 1000, 10))
+}
+
+func TestDepth(t *testing.T) {
+	deps := map[string][]string{
+		"flask":    {"click", "werkzeug", "jinja2"},
+		"jinja2":   {"markupsafe"},
+		"werkzeug": {"markupsafe"},
+		// A cycle, which isn't followed round.
+		"a": {"b"},
+		"b": {"a", "c"},
+	}
+	require.Equal(t, 0, Depth(nil, deps))
+	require.Equal(t, 1, Depth([]string{"requests"}, deps))
+	require.Equal(t, 3, Depth([]string{"click", "flask"}, deps))
+	require.Equal(t, 3, Depth([]string{"a"}, deps))
+	require.Equal(t, 2, Depth([]string{"b"}, deps))
+}
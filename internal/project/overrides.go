@@ -0,0 +1,49 @@
+package project
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+
+	"github.com/replit/upm/internal/util"
+)
+
+// OverridesFile is where a project maps the modules that it imports to
+// the packages that provide them, by language, such as:
+//
+//	{"python": {"acme_auth": "acme-auth-internal"}}
+//
+// The languages are the first part of the names of the backends, as
+// 'upm languages' shows them, such as python, nodejs, or ruby. The
+// backends that guess packages from a map take the packages given
+// here over both the map and the #upm pragmas in the code, for
+// internal packages and forks that no public map will ever know about.
+const OverridesFile = ".upm/overrides.json"
+
+// overrides caches OverridesFile once it has been read.
+var overrides map[string]map[string]string
+
+// Overrides returns the map from modules to packages that
+// OverridesFile gives for the given language, reading it from disk
+// the first time it is called. A missing file, or language, is the
+// same as an empty one. If there is an error, Overrides terminates the
+// process.
+func Overrides(language string) map[string]string {
+	if overrides == nil {
+		overrides = map[string]map[string]string{}
+		contents, err := ioutil.ReadFile(OverridesFile)
+		if err != nil && !os.IsNotExist(err) {
+			util.Die("%s", err)
+		}
+		if err == nil {
+			if err := json.Unmarshal(contents, &overrides); err != nil {
+				util.Die("%s: %s", OverridesFile, err)
+			}
+		}
+	}
+	mods := overrides[language]
+	if mods == nil {
+		return map[string]string{}
+	}
+	return mods
+}
@@ -0,0 +1,215 @@
+// Package project handles reading the .upm/config.toml file. Unlike
+// the store, this file is written by hand and is meant to be checked
+// into version control along with the rest of the project.
+package project
+
+import (
+	"os"
+	"reflect"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+
+	"github.com/replit/upm/internal/util"
+)
+
+// Config represents the contents of .upm/config.toml. Every setting
+// is optional. References to environment variables, such as
+// ${GIT_TOKEN}, are expanded in every string when the file is read
+// (see util.ExpandEnv), so that tokens and internal hostnames need not
+// be checked in.
+type Config struct {
+	Verify struct {
+		// Command is a shell-quoted command, such as
+		// "npm test -- --ci", that is run after packages are
+		// installed. If it fails, the change that caused the
+		// install is rolled back.
+		Command string `toml:"command"`
+	} `toml:"verify"`
+
+	Git struct {
+		// Commit is true if the specfile and lockfile should
+		// be committed whenever add, remove, or lock changes
+		// them, as if --commit were given.
+		Commit bool `toml:"commit"`
+	} `toml:"git"`
+
+	Report struct {
+		// Webhook is the URL that commands such as
+		// watch-releases post their reports to.
+		Webhook string `toml:"webhook"`
+
+		// Format is the payload to post: "json" (the default)
+		// or "slack".
+		Format string `toml:"format"`
+	} `toml:"report"`
+
+	Audit struct {
+		// FailOn is the least severe vulnerability that makes
+		// 'upm audit' fail: "low", "moderate", "high",
+		// "critical", or "none" for none at all. It defaults
+		// to "low".
+		FailOn string `toml:"fail-on"`
+
+		// OSVURL is the API to look vulnerabilities up in
+		// instead of that of OSV.dev, such as a mirror of it.
+		OSVURL string `toml:"osv-url"`
+	} `toml:"audit"`
+
+	Guess struct {
+		// Sources are commands that suggest packages for
+		// 'upm guess' and 'upm add --guess' on top of those
+		// found by scanning the imports (see the guess
+		// package for what they are run with and print).
+		Sources []GuessSource `toml:"sources"`
+	} `toml:"guess"`
+
+	Python struct {
+		// Index is the package index to use instead of PyPI,
+		// as for users behind a private mirror.
+		Index struct {
+			// URL is the simple index, e.g.
+			// "https://pypi.example.com/simple", as given
+			// to pip's --index-url.
+			URL string `toml:"url"`
+
+			// APIURL is where the JSON API of the index is
+			// found, e.g. "https://pypi.example.com/pypi".
+			// It is only needed if URL doesn't end in
+			// "/simple".
+			APIURL string `toml:"api-url"`
+
+			// Username and Password are sent with every
+			// request to the index using basic auth.
+			Username string `toml:"username"`
+			Password string `toml:"password"`
+
+			// Token is an API token, which is sent as the
+			// password for the username "__token__" if no
+			// username is given.
+			Token string `toml:"token"`
+		} `toml:"index"`
+
+		// MapOverlays are JSON files that add to or override
+		// the map from modules to the packages that provide
+		// them, which packages are guessed with, as for
+		// internal packages that PyPI doesn't have. Each maps
+		// package names to lists of modules.
+		MapOverlays []string `toml:"map-overlays"`
+	} `toml:"python"`
+
+	// Profiles maps the name of each profile, as given to
+	// --profile, to its settings.
+	Profiles map[string]Profile `toml:"profiles"`
+
+	// Exports are files that the lockfile is exported to, such as
+	// a requirements-prod.txt of only the main dependencies for a
+	// deploy target. They are written again whenever a command
+	// changes the lockfile.
+	Exports []Export `toml:"exports"`
+}
+
+// Export is a file that the lockfile is exported to, as 'upm export'
+// does.
+type Export struct {
+	// File is where the export is written, relative to the
+	// project directory.
+	File string `toml:"file"`
+	// Groups are the dependency groups whose packages are
+	// exported along with the main dependencies, such as
+	// ["dev"]. By default, only the main dependencies are.
+	Groups []string `toml:"groups"`
+	// Language is the backend whose lockfile is exported, such
+	// as "python3-poetry". By default, it is every backend of the
+	// project that can export its lockfile.
+	Language string `toml:"language"`
+}
+
+// Profile is a set of packages that is composed onto the specfile
+// whenever lock or install is run with --profile. Each one is added
+// to the specfile if it is not there already, or replaces the spec
+// that is there otherwise, so a profile can both add constraints and
+// pin packages to exact versions.
+type Profile struct {
+	// Packages maps package names to specs, which are written in
+	// the same syntax as 'upm add' (for example "==2.3.2" for
+	// Python or "4.17.21" for Node.js).
+	Packages map[string]string `toml:"packages"`
+}
+
+// GuessSource is a command that suggests packages to guess, such as a
+// lookup in a database of a company's internal packages.
+type GuessSource struct {
+	// Name says where the suggestions came from, for 'upm guess
+	// --interactive' and errors. It defaults to the command.
+	Name string `toml:"name"`
+
+	// Command is a shell-quoted command, such as
+	// "./scripts/internal-packages --json".
+	Command string `toml:"command"`
+}
+
+// cfg caches the project config once it has been read.
+var cfg *Config
+
+// getConfigLocation returns the file path of the project config.
+func getConfigLocation() string {
+	loc, ok := os.LookupEnv("UPM_CONFIG")
+	if ok {
+		return loc
+	} else {
+		return ".upm/config.toml"
+	}
+}
+
+// Read returns the project config, reading it from disk the first time
+// it is called. A missing file is the same as an empty one. If there
+// is an error, Read terminates the process.
+func Read() *Config {
+	if cfg != nil {
+		return cfg
+	}
+
+	cfg = &Config{}
+	filename := getConfigLocation()
+	if !util.Exists(filename) {
+		return cfg
+	}
+	if _, err := toml.DecodeFile(filename, cfg); err != nil {
+		util.Die("%s: %s", filename, err)
+	}
+	expandEnv(filename, reflect.ValueOf(cfg).Elem(), nil)
+	return cfg
+}
+
+// expandEnv expands references to environment variables in every
+// string found in the given value, recursively. The keys leading to
+// the value are used to say where an unset variable was referenced.
+func expandEnv(filename string, v reflect.Value, keys []string) {
+	switch v.Kind() {
+	case reflect.String:
+		expanded, err := util.ExpandEnv(v.String())
+		if err != nil {
+			util.Die("%s: %s: %s", filename, strings.Join(keys, "."), err)
+		}
+		v.SetString(expanded)
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			key := strings.SplitN(v.Type().Field(i).Tag.Get("toml"), ",", 2)[0]
+			expandEnv(filename, v.Field(i), append(keys, key))
+		}
+	case reflect.Slice:
+		for i := 0; i < v.Len(); i++ {
+			expandEnv(filename, v.Index(i), keys)
+		}
+	case reflect.Map:
+		// Map values can't be set in place, so each one is
+		// copied, expanded, and stored again.
+		for _, key := range v.MapKeys() {
+			value := reflect.New(v.Type().Elem()).Elem()
+			value.Set(v.MapIndex(key))
+			expandEnv(filename, value, append(keys, key.String()))
+			v.SetMapIndex(key, value)
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package project
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadExpandsEnv(t *testing.T) {
+	dir, err := ioutil.TempDir("", "upm-project")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	filename := filepath.Join(dir, "config.toml")
+	require.NoError(t, ioutil.WriteFile(filename, []byte(`
+[verify]
+command = "${UPM_TEST_CMD} --ci"
+
+[profiles.prod.packages]
+mylib = "git+https://${UPM_TEST_TOKEN}@git.example.com/mylib.git"
+`), 0666))
+
+	os.Setenv("UPM_CONFIG", filename)
+	os.Setenv("UPM_TEST_CMD", "npm test")
+	os.Setenv("UPM_TEST_TOKEN", "s3cret")
+	defer os.Unsetenv("UPM_CONFIG")
+	defer os.Unsetenv("UPM_TEST_CMD")
+	defer os.Unsetenv("UPM_TEST_TOKEN")
+
+	cfg = nil
+	config := Read()
+	require.Equal(t, "npm test --ci", config.Verify.Command)
+	require.Equal(t,
+		"git+https://s3cret@git.example.com/mylib.git",
+		config.Profiles["prod"].Packages["mylib"],
+	)
+}
+
+func TestOverrides(t *testing.T) {
+	require.NoError(t, os.Chdir(t.TempDir()))
+
+	overrides = nil
+	require.Equal(t, map[string]string{}, Overrides("python"))
+
+	require.NoError(t, os.Mkdir(".upm", 0777))
+	require.NoError(t, ioutil.WriteFile(OverridesFile, []byte(`{
+		"python": {"acme_auth": "acme-auth-internal", "yaml": "pyyaml-fork"},
+		"nodejs": {"@acme/ui": "@acme/ui-fork"}
+	}`), 0666))
+	overrides = nil
+	require.Equal(t, map[string]string{"acme_auth": "acme-auth-internal", "yaml": "pyyaml-fork"},
+		Overrides("python"))
+	require.Equal(t, map[string]string{"@acme/ui": "@acme/ui-fork"}, Overrides("nodejs"))
+	require.Equal(t, map[string]string{}, Overrides("ruby"))
+	overrides = nil
+}
@@ -0,0 +1,119 @@
+// Package report posts the results of commands such as 'upm
+// watch-releases' to a webhook, so that a team can be told about them
+// without having to write any glue.
+package report
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/replit/upm/internal/project"
+	"github.com/replit/upm/internal/util"
+)
+
+// Payload formats that a sink can be sent.
+const (
+	// FormatJSON posts the Report itself.
+	FormatJSON = "json"
+
+	// FormatSlack posts a message that Slack incoming webhooks
+	// (and compatible services, such as Mattermost) display.
+	FormatSlack = "slack"
+)
+
+// Report is the result of a command, to be posted to a sink.
+type Report struct {
+	// The command that made the report, such as
+	// "watch-releases".
+	Command string `json:"command"`
+
+	// The name of the language backend in use.
+	Language string `json:"language"`
+
+	// A one-line summary, such as "2 new releases".
+	Title string `json:"title"`
+
+	// A line of text for each item in the report, for formats
+	// meant to be read by people.
+	Lines []string `json:"-"`
+
+	// The items themselves, in the same form as the command
+	// prints them with --format json.
+	Items interface{} `json:"items"`
+}
+
+// Sink is a webhook that reports are posted to.
+type Sink struct {
+	URL    string
+	Format string
+}
+
+// GetSink returns the sink given on the command line or, for whichever
+// of its URL and format weren't given, in the [report] section of the
+// project config. It returns nil if no URL is configured at all. If
+// the format is not valid, it terminates the process.
+func GetSink(webhook string, format string) *Sink {
+	cfg := project.Read()
+	if webhook == "" {
+		webhook = cfg.Report.Webhook
+	}
+	if webhook == "" {
+		return nil
+	}
+	if format == "" {
+		format = cfg.Report.Format
+	}
+	if format == "" {
+		format = FormatJSON
+	}
+	if format != FormatJSON && format != FormatSlack {
+		util.Die(`invalid webhook format %#v (must be "json" or "slack")`, format)
+	}
+	return &Sink{URL: webhook, Format: format}
+}
+
+// slackMessage returns the payload of a Slack incoming webhook for the
+// given report.
+func slackMessage(r Report) map[string]string {
+	var text strings.Builder
+	text.WriteString("*" + r.Title + "*")
+	if r.Language != "" {
+		text.WriteString(" (" + r.Language + ")")
+	}
+	for _, line := range r.Lines {
+		text.WriteString("\n• " + line)
+	}
+	return map[string]string{"text": text.String()}
+}
+
+// Post sends the given report to the sink. If that fails, it
+// terminates the process.
+func (s *Sink) Post(r Report) {
+	var payload interface{} = r
+	if s.Format == FormatSlack {
+		payload = slackMessage(r)
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		util.Panicf("report.Post: %s", err)
+	}
+
+	client := &http.Client{Timeout: 30 * time.Second, Transport: util.HTTPClient.Transport}
+	resp, err := client.Post(s.URL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		// The URL of a webhook is often a secret, so leave it
+		// out of the error.
+		if urlErr, ok := err.(*url.Error); ok {
+			err = urlErr.Err
+		}
+		util.Die("webhook: %s", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		util.Die("webhook: HTTP status %d", resp.StatusCode)
+	}
+}
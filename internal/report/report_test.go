@@ -0,0 +1,43 @@
+package report
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPost(t *testing.T) {
+	var received map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = nil
+		body, err := ioutil.ReadAll(r.Body)
+		require.NoError(t, err)
+		require.NoError(t, json.Unmarshal(body, &received))
+	}))
+	defer server.Close()
+
+	r := Report{
+		Command:  "watch-releases",
+		Language: "nodejs-npm",
+		Title:    "2 new releases",
+		Lines:    []string{"lodash 4.17.21 (was 4.17.20)", "react 18.3.0 (was 18.2.0)"},
+		Items:    []string{"lodash", "react"},
+	}
+
+	(&Sink{URL: server.URL, Format: FormatJSON}).Post(r)
+	require.Equal(t, map[string]interface{}{
+		"command":  "watch-releases",
+		"language": "nodejs-npm",
+		"title":    "2 new releases",
+		"items":    []interface{}{"lodash", "react"},
+	}, received)
+
+	(&Sink{URL: server.URL, Format: FormatSlack}).Post(r)
+	require.Equal(t, map[string]interface{}{
+		"text": "*2 new releases* (nodejs-npm)\n• lodash 4.17.21 (was 4.17.20)\n• react 18.3.0 (was 18.2.0)",
+	}, received)
+}
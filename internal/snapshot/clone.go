@@ -0,0 +1,72 @@
+package snapshot
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/replit/upm/internal/api"
+	"github.com/replit/upm/internal/util"
+)
+
+// cloneIgnored are the entries in the project directory that are not
+// copied into a clone, since they can be large and nothing UPM runs
+// should need them.
+var cloneIgnored = map[string]bool{
+	".git": true,
+	".hg":  true,
+	".svn": true,
+}
+
+// Clone is a throwaway copy of a project, in which a change to its
+// dependencies can be tried out without affecting the project itself.
+type Clone struct {
+	b api.LanguageBackend
+
+	// Dir is the absolute path of the copy.
+	Dir string
+}
+
+// NewClone copies the project in the current directory, including any
+// packages installed inside it, to a new temporary directory.
+func NewClone(ctx context.Context, b api.LanguageBackend) *Clone {
+	dir := util.TempDir("upm-clone")
+
+	entries, err := ioutil.ReadDir(".")
+	if err != nil {
+		util.Die("%s", err)
+	}
+	util.ProgressMsg("copy project to " + dir)
+	for _, entry := range entries {
+		if cloneIgnored[entry.Name()] {
+			continue
+		}
+		copyPath(ctx, entry.Name(), filepath.Join(dir, entry.Name()))
+	}
+
+	return &Clone{b: b, Dir: dir}
+}
+
+// Apply copies the specfile and lockfile from the clone into the
+// project in the current directory. The packages themselves are not
+// copied, so they have to be installed afterwards.
+func (c *Clone) Apply(ctx context.Context) {
+	for _, path := range []string{c.b.Specfile, c.b.Lockfile} {
+		src := filepath.Join(c.Dir, path)
+		if util.Exists(src) {
+			util.ProgressMsg("copy " + src + " to " + path)
+			copyPath(ctx, src, path)
+		} else if util.Exists(path) {
+			util.ProgressMsg("delete " + path)
+			if err := os.Remove(path); err != nil {
+				util.Die("%s: %s", path, err)
+			}
+		}
+	}
+}
+
+// Discard deletes the clone.
+func (c *Clone) Discard() {
+	util.RemoveTemp(c.Dir)
+}
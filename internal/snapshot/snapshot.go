@@ -0,0 +1,137 @@
+// Package snapshot saves the state of a project's dependencies, so
+// that a change to them can be rolled back.
+package snapshot
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/replit/upm/internal/api"
+	"github.com/replit/upm/internal/util"
+)
+
+// snapshotDir is the directory in which the snapshot is kept. Only one
+// snapshot exists at a time, so any left behind by a previous run of
+// UPM that died is simply replaced.
+const snapshotDir = ".upm/snapshot"
+
+// Snapshot is a saved copy of the specfile, lockfile, and (if it is
+// inside the project) package directory of a backend.
+type Snapshot struct {
+	// paths maps each saved path, relative to the project, to
+	// true if it existed when the snapshot was taken.
+	paths map[string]bool
+
+	// packageDir is the package directory relative to the
+	// project, or the empty string if it was not saved.
+	packageDir string
+}
+
+// projectRelative returns the given path relative to the project
+// directory, or the empty string if it is outside the project.
+func projectRelative(path string) string {
+	cwd, err := os.Getwd()
+	if err != nil {
+		util.Die("%s", err)
+	}
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		util.Die("%s: %s", path, err)
+	}
+	rel, err := filepath.Rel(cwd, abs)
+	if err != nil || rel == "." || rel == ".." ||
+		strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return ""
+	}
+	return rel
+}
+
+// savedPath returns the location of the copy of the given path.
+func savedPath(path string) string {
+	return filepath.Join(snapshotDir, path)
+}
+
+// copyPath copies the file or directory src to dst, creating the
+// parent directory of dst if necessary.
+func copyPath(ctx context.Context, src string, dst string) {
+	if err := os.MkdirAll(filepath.Dir(dst), 0777); err != nil {
+		util.Die("%s: %s", dst, err)
+	}
+	info, err := os.Lstat(src)
+	if err != nil {
+		util.Die("%s: %s", src, err)
+	}
+	if info.IsDir() || info.Mode()&os.ModeSymlink != 0 {
+		util.CopyTree(ctx, src, dst)
+		return
+	}
+	contents, err := ioutil.ReadFile(src)
+	if err != nil {
+		util.Die("%s: %s", src, err)
+	}
+	if err := ioutil.WriteFile(dst, contents, info.Mode().Perm()); err != nil {
+		util.Die("%s: %s", dst, err)
+	}
+}
+
+// Take saves a snapshot of the given backend's dependencies. The
+// package directory is only saved if it is inside the project, since
+// otherwise it may be shared with other projects.
+func Take(ctx context.Context, b api.LanguageBackend) *Snapshot {
+	if err := os.RemoveAll(snapshotDir); err != nil {
+		util.Die("%s: %s", snapshotDir, err)
+	}
+
+	s := &Snapshot{paths: map[string]bool{}}
+	paths := []string{b.Specfile, b.Lockfile}
+	if dir := projectRelative(b.GetPackageDir()); dir != "" {
+		s.packageDir = dir
+		paths = append(paths, dir)
+	}
+
+	util.ProgressMsg("save snapshot to " + snapshotDir)
+	for _, path := range paths {
+		s.paths[path] = util.Exists(path)
+		if !s.paths[path] {
+			continue
+		}
+		copyPath(ctx, path, savedPath(path))
+	}
+
+	return s
+}
+
+// HasPackageDir returns true if the package directory was saved in
+// the snapshot. If it wasn't, then the packages have to be reinstalled
+// after restoring the snapshot.
+func (s *Snapshot) HasPackageDir() bool {
+	return s.packageDir != ""
+}
+
+// Restore puts every saved path back as it was when the snapshot was
+// taken, deleting any that did not exist then, and then discards the
+// snapshot.
+func (s *Snapshot) Restore() {
+	util.ProgressMsg("restore snapshot from " + snapshotDir)
+	for path, existed := range s.paths {
+		if err := os.RemoveAll(path); err != nil {
+			util.Die("%s: %s", path, err)
+		}
+		if existed {
+			if err := os.Rename(savedPath(path), path); err != nil {
+				util.Die("%s: %s", path, err)
+			}
+		}
+	}
+	s.Discard()
+}
+
+// Discard deletes the snapshot.
+func (s *Snapshot) Discard() {
+	if err := os.RemoveAll(snapshotDir); err != nil {
+		util.Die("%s: %s", snapshotDir, err)
+	}
+}
@@ -0,0 +1,112 @@
+package snapshot
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/replit/upm/internal/api"
+	"github.com/replit/upm/internal/config"
+	"github.com/replit/upm/internal/util"
+)
+
+func TestTakeAndRestore(t *testing.T) {
+	cwd, err := os.Getwd()
+	require.NoError(t, err)
+	dir, err := ioutil.TempDir("", "upm-snapshot")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	require.NoError(t, os.Chdir(dir))
+	defer os.Chdir(cwd)
+
+	config.Quiet = true
+	defer func() { config.Quiet = false }()
+
+	index := filepath.Join("node_modules", "left-pad", "index.js")
+	require.NoError(t, os.MkdirAll(filepath.Dir(index), 0777))
+	require.NoError(t, ioutil.WriteFile(index, []byte("v1\n"), 0666))
+	require.NoError(t, ioutil.WriteFile("package.json", []byte("{}\n"), 0666))
+
+	b := api.LanguageBackend{
+		Specfile: "package.json",
+		Lockfile: "package-lock.json",
+		GetPackageDir: func() string {
+			return "node_modules"
+		},
+	}
+
+	s := Take(context.Background(), b)
+	require.True(t, s.HasPackageDir())
+
+	require.NoError(t, ioutil.WriteFile(index, []byte("v2\n"), 0666))
+	require.NoError(t, ioutil.WriteFile("package.json", []byte(`{"a": 1}`), 0666))
+	require.NoError(t, ioutil.WriteFile("package-lock.json", []byte("{}\n"), 0666))
+	require.NoError(t, os.MkdirAll(filepath.Join("node_modules", "is-odd"), 0777))
+
+	s.Restore()
+
+	contents, err := ioutil.ReadFile(index)
+	require.NoError(t, err)
+	require.Equal(t, "v1\n", string(contents))
+	contents, err = ioutil.ReadFile("package.json")
+	require.NoError(t, err)
+	require.Equal(t, "{}\n", string(contents))
+	require.False(t, util.Exists("package-lock.json"))
+	require.False(t, util.Exists(filepath.Join("node_modules", "is-odd")))
+	require.False(t, util.Exists(snapshotDir))
+}
+
+func TestProjectRelative(t *testing.T) {
+	require.Equal(t, "node_modules", projectRelative("node_modules"))
+	require.Equal(t, "", projectRelative("."))
+	require.Equal(t, "", projectRelative(filepath.Join("..", "venv")))
+	require.Equal(t, "", projectRelative("/"))
+}
+
+func TestClone(t *testing.T) {
+	cwd, err := os.Getwd()
+	require.NoError(t, err)
+	dir, err := ioutil.TempDir("", "upm-snapshot")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	require.NoError(t, os.Chdir(dir))
+	defer os.Chdir(cwd)
+
+	config.Quiet = true
+	defer func() { config.Quiet = false }()
+
+	require.NoError(t, os.MkdirAll(filepath.Join(".git", "objects"), 0777))
+	require.NoError(t, ioutil.WriteFile("index.js", []byte("require('left-pad');\n"), 0666))
+	require.NoError(t, ioutil.WriteFile("package.json", []byte("{}\n"), 0666))
+	require.NoError(t, ioutil.WriteFile("package-lock.json", []byte("{}\n"), 0666))
+
+	b := api.LanguageBackend{
+		Specfile: "package.json",
+		Lockfile: "package-lock.json",
+	}
+
+	c := NewClone(context.Background(), b)
+	defer c.Discard()
+	require.True(t, util.Exists(filepath.Join(c.Dir, "index.js")))
+	require.False(t, util.Exists(filepath.Join(c.Dir, ".git")))
+
+	require.NoError(t, ioutil.WriteFile(filepath.Join(c.Dir, "package.json"), []byte(`{"a": 1}`), 0666))
+	require.NoError(t, os.Remove(filepath.Join(c.Dir, "package-lock.json")))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(c.Dir, "index.js"), []byte(""), 0666))
+	c.Apply(context.Background())
+
+	contents, err := ioutil.ReadFile("package.json")
+	require.NoError(t, err)
+	require.Equal(t, `{"a": 1}`, string(contents))
+	require.False(t, util.Exists("package-lock.json"))
+	contents, err = ioutil.ReadFile("index.js")
+	require.NoError(t, err)
+	require.Equal(t, "require('left-pad');\n", string(contents))
+
+	c.Discard()
+	require.False(t, util.Exists(c.Dir))
+}
@@ -4,12 +4,18 @@
 package store
 
 import (
+	"context"
 	"encoding/json"
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"reflect"
+	"sort"
+	"sync"
+	"time"
 
 	"github.com/replit/upm/internal/api"
+	"github.com/replit/upm/internal/config"
 	"github.com/replit/upm/internal/util"
 )
 
@@ -21,6 +27,12 @@ var st *store
 // field in the store struct.
 const currentVersion = 2
 
+// SchemaVersion returns the version of the store schema that this UPM
+// reads and writes.
+func SchemaVersion() int {
+	return currentVersion
+}
+
 // getStoreLocation returns the file path of the JSON store.
 func getStoreLocation() string {
 	loc, ok := os.LookupEnv("UPM_STORE")
@@ -77,8 +89,14 @@ func initLanguage(language string) {
 }
 
 // Write writes the current contents of the store from memory back to
-// disk. If there is an error, it terminates the process.
+// disk. If there is an error, it terminates the process. In read-only
+// mode, it does nothing: the store is only a cache, so commands that
+// just look at the project still work without it being updated.
 func Write() {
+	if config.ReadOnly {
+		return
+	}
+
 	filename := getStoreLocation()
 
 	filename, err := filepath.Abs(filename)
@@ -100,6 +118,25 @@ func Write() {
 	util.TryWriteAtomic(filename, content)
 }
 
+// CheckWritable returns the path of the store and why it can't be
+// written, or nil if it can, by creating a file beside it. The
+// directory of the store is created if it doesn't exist yet, as Write
+// would.
+func CheckWritable() (string, error) {
+	filename := getStoreLocation()
+	directory := filepath.Dir(filename)
+	if err := os.MkdirAll(directory, 0777); err != nil {
+		return filename, err
+	}
+	file, err := ioutil.TempFile(directory, ".store-*.json")
+	if err != nil {
+		return filename, err
+	}
+	file.Close()
+	os.Remove(file.Name())
+	return filename, nil
+}
+
 // HasSpecfileChanged returns false if the specfile exists and has not
 // changed since the last time UpdateFileHashes was called, or if it
 // doesn't exist and it didn't exist last time either. Otherwise, it
@@ -120,6 +157,16 @@ func HasLockfileChanged(b api.LanguageBackend) bool {
 	return hashFile(b.Lockfile) != st.Languages[b.Name].LockfileHash
 }
 
+// HasProfileChanged returns true if the profile given on the command
+// line is not the one that was in use the last time UpdateFileHashes
+// was called. The lockfile and installed packages then reflect a
+// different set of packages than the current one.
+func HasProfileChanged(b api.LanguageBackend) bool {
+	readMaybe()
+	initLanguage(b.Name)
+	return config.Profile != st.Languages[b.Name].Profile
+}
+
 // GuessWithCache returns b.Guess(), but re-uses a cached return value
 // if possible. The cache is used if the matches of b.GuessRegexps
 // against b.FilenamePatterns has not changed since the last time
@@ -128,7 +175,19 @@ func HasLockfileChanged(b api.LanguageBackend) bool {
 // backend does specify b.GuessRegexps, then the return value of this
 // function is cached.) If forceGuess is true, then write to but do
 // not read from the cache.
-func GuessWithCache(b api.LanguageBackend, forceGuess bool) map[api.PkgName]bool {
+func GuessWithCache(ctx context.Context, b api.LanguageBackend, forceGuess bool) map[api.PkgName]bool {
+	pkgs := map[api.PkgName]bool{}
+	for name := range GuessDetailsWithCache(ctx, b, forceGuess) {
+		pkgs[name] = true
+	}
+	return pkgs
+}
+
+// GuessDetailsWithCache is like GuessWithCache, but returns
+// b.GuessDetails() instead if the backend implements it, so that the
+// specs and groups given by pragmas are cached along with the names.
+// Otherwise, the packages have no details.
+func GuessDetailsWithCache(ctx context.Context, b api.LanguageBackend, forceGuess bool) map[api.PkgName]api.GuessedPkg {
 	readMaybe()
 	initLanguage(b.Name)
 	old := st.Languages[b.Name].GuessedImportsHash
@@ -136,22 +195,25 @@ func GuessWithCache(b api.LanguageBackend, forceGuess bool) map[api.PkgName]bool
 	// If no regexps, then we can't hash imports. Skip reading and
 	// writing the hash.
 	if len(b.GuessRegexps) > 0 {
-		new = hashImports(b)
+		new = hashImports(ctx, b)
 		st.Languages[b.Name].GuessedImportsHash = new
 	}
 	if forceGuess || new != old {
-		var pkgs map[api.PkgName]bool
+		pkgs := map[api.PkgName]api.GuessedPkg{}
 		success := true
-		if new != "" {
-			pkgs, success = b.Guess()
-		} else {
-			// If new is the empty string, that means
-			// (according to the interface of hashImports)
-			// that there were no regexp matches. In that
-			// case we shouldn't have any packages
-			// returned by the bare imports search. Might
-			// as well just skip the search, right?
-			pkgs = map[api.PkgName]bool{}
+		// If new is the empty string, that means (according
+		// to the interface of hashImports) that there were no
+		// regexp matches. In that case we shouldn't have any
+		// packages returned by the bare imports search. Might
+		// as well just skip the search, right?
+		if new != "" && b.GuessDetails != nil {
+			pkgs, success = b.GuessDetails(ctx)
+		} else if new != "" {
+			var names map[api.PkgName]bool
+			names, success = b.Guess(ctx)
+			for name := range names {
+				pkgs[name] = api.GuessedPkg{}
+			}
 		}
 		if !success {
 			// If bare imports search is not successful,
@@ -170,26 +232,534 @@ func GuessWithCache(b api.LanguageBackend, forceGuess bool) map[api.PkgName]bool
 		// error, then on import A again.
 		if len(b.GuessRegexps) > 0 && success {
 			guessed := []string{}
-			for name := range pkgs {
+			details := map[string]api.GuessedPkg{}
+			for name, pkg := range pkgs {
 				guessed = append(guessed, string(name))
+				if !reflect.DeepEqual(pkg, api.GuessedPkg{}) {
+					details[string(name)] = pkg
+				}
 			}
 			st.Languages[b.Name].GuessedImports = guessed
+			st.Languages[b.Name].GuessedDetails = details
 		}
 		return pkgs
 	} else {
-		pkgs := map[api.PkgName]bool{}
+		pkgs := map[api.PkgName]api.GuessedPkg{}
 		for _, name := range st.Languages[b.Name].GuessedImports {
-			pkgs[api.PkgName(name)] = true
+			pkgs[api.PkgName(name)] = st.Languages[b.Name].GuessedDetails[name]
 		}
 		return pkgs
 	}
 }
 
 // UpdateFileHashes caches the current states of the specfile and
-// lockfile. Neither file need exist.
+// lockfile, along with the profile in use. Neither file need exist.
 func UpdateFileHashes(b api.LanguageBackend) {
 	readMaybe()
 	initLanguage(b.Name)
 	st.Languages[b.Name].SpecfileHash = hashFile(b.Specfile)
 	st.Languages[b.Name].LockfileHash = hashFile(b.Lockfile)
+	st.Languages[b.Name].Profile = config.Profile
+}
+
+// IsLockfileStale returns true if the specfile has changed since the
+// file hashes were last cached, but the lockfile hasn't, which means
+// that the specfile was edited without the lockfile being generated
+// again. It returns false if nothing is cached for the language.
+func IsLockfileStale(b api.LanguageBackend) bool {
+	readMaybe()
+	cache := st.Languages[b.Name]
+	if cache == nil || cache.SpecfileHash == "" || cache.LockfileHash == "" {
+		return false
+	}
+	return hashFile(b.Specfile) != cache.SpecfileHash &&
+		hashFile(b.Lockfile) == cache.LockfileHash
+}
+
+// CacheListing records what the given backend's specfile, or with
+// lockfile, its lockfile, listed just now, for QuickListings. groups
+// may be nil.
+func CacheListing(b api.LanguageBackend, lockfile bool, pkgs map[string]string, groups map[string]string) {
+	readMaybe()
+	initLanguage(b.Name)
+	file := b.Specfile
+	if lockfile {
+		file = b.Lockfile
+	}
+	listing := &Listing{
+		File:     file,
+		Hash:     hashFile(file),
+		Listed:   time.Now().UTC(),
+		Packages: pkgs,
+		Groups:   groups,
+	}
+	if lockfile {
+		st.Languages[b.Name].LockfileListing = listing
+	} else {
+		st.Languages[b.Name].SpecfileListing = listing
+	}
+}
+
+// HasListing returns true if CacheListing has recorded anything for
+// the given backend, which is then worth keeping up to date.
+func HasListing(b api.LanguageBackend) bool {
+	readMaybe()
+	cache := st.Languages[b.Name]
+	return cache != nil && (cache.SpecfileListing != nil || cache.LockfileListing != nil)
+}
+
+// QuickListing is a listing recorded by CacheListing.
+type QuickListing struct {
+	Listing
+	// The name of the backend whose file was listed.
+	Backend string
+	// Whether the file has changed since it was listed, so that
+	// the listing may be out of date.
+	Stale bool
+}
+
+// QuickListings returns what CacheListing recorded of the specfile,
+// or with lockfile, the lockfile, of each backend, those that aren't
+// stale first and then the most recently listed first. Nothing more
+// than the hashes of the files is computed, so that it is quick enough
+// for an editor to call over and over: no backend is asked to read
+// anything.
+func QuickListings(lockfile bool) []QuickListing {
+	readMaybe()
+	listings := []QuickListing{}
+	for name, cache := range st.Languages {
+		listing := cache.SpecfileListing
+		if lockfile {
+			listing = cache.LockfileListing
+		}
+		if listing != nil {
+			listings = append(listings, QuickListing{
+				Listing: *listing,
+				Backend: name,
+				Stale:   hashFile(listing.File) != listing.Hash,
+			})
+		}
+	}
+	sort.Slice(listings, func(i, j int) bool {
+		if listings[i].Stale != listings[j].Stale {
+			return !listings[i].Stale
+		}
+		if !listings[i].Listed.Equal(listings[j].Listed) {
+			return listings[i].Listed.After(listings[j].Listed)
+		}
+		return listings[i].Backend < listings[j].Backend
+	})
+	return listings
+}
+
+// AddEditable records that the given package was added as an editable
+// dependency from the given local path.
+func AddEditable(b api.LanguageBackend, name api.PkgName, path string) {
+	readMaybe()
+	initLanguage(b.Name)
+	if st.Languages[b.Name].Editable == nil {
+		st.Languages[b.Name].Editable = map[string]string{}
+	}
+	st.Languages[b.Name].Editable[string(name)] = path
+}
+
+// ClearEditable forgets that the given packages were added as
+// editable dependencies, if they were. Names are compared after
+// normalization.
+func ClearEditable(b api.LanguageBackend, names map[api.PkgName]bool) {
+	readMaybe()
+	initLanguage(b.Name)
+	norm := map[api.PkgName]bool{}
+	for name := range names {
+		norm[b.NormalizePackageName(name)] = true
+	}
+	for name := range st.Languages[b.Name].Editable {
+		if norm[b.NormalizePackageName(api.PkgName(name))] {
+			delete(st.Languages[b.Name].Editable, name)
+		}
+	}
+}
+
+// GetEditable returns a map from the names of packages that were added
+// as editable dependencies to the local paths they were added from.
+// Commands that compare packages against a registry should skip
+// these.
+func GetEditable(b api.LanguageBackend) map[api.PkgName]string {
+	readMaybe()
+	initLanguage(b.Name)
+	pkgs := map[api.PkgName]string{}
+	for name, path := range st.Languages[b.Name].Editable {
+		pkgs[api.PkgName(name)] = path
+	}
+	return pkgs
+}
+
+// AddLink records that the given package has been linked to a local
+// checkout.
+func AddLink(b api.LanguageBackend, name api.PkgName, link Link) {
+	readMaybe()
+	initLanguage(b.Name)
+	if st.Languages[b.Name].Links == nil {
+		st.Languages[b.Name].Links = map[string]Link{}
+	}
+	st.Languages[b.Name].Links[string(name)] = link
+}
+
+// ClearLink forgets that the given package was linked, if it was.
+// Names are compared after normalization.
+func ClearLink(b api.LanguageBackend, name api.PkgName) {
+	readMaybe()
+	initLanguage(b.Name)
+	for linked := range st.Languages[b.Name].Links {
+		if b.NormalizePackageName(api.PkgName(linked)) == b.NormalizePackageName(name) {
+			delete(st.Languages[b.Name].Links, linked)
+		}
+	}
+}
+
+// GetLinks returns a map from the normalized names of packages that
+// are currently linked to local checkouts to the corresponding links.
+func GetLinks(b api.LanguageBackend) map[api.PkgName]Link {
+	readMaybe()
+	initLanguage(b.Name)
+	links := map[api.PkgName]Link{}
+	for name, link := range st.Languages[b.Name].Links {
+		links[b.NormalizePackageName(api.PkgName(name))] = link
+	}
+	return links
+}
+
+// GetReleases returns the latest version of each package in the
+// specfile seen by the last run of 'upm watch-releases'.
+func GetReleases(b api.LanguageBackend) map[api.PkgName]api.PkgVersion {
+	readMaybe()
+	initLanguage(b.Name)
+	releases := map[api.PkgName]api.PkgVersion{}
+	for name, version := range st.Languages[b.Name].Releases {
+		releases[api.PkgName(name)] = version
+	}
+	return releases
+}
+
+// SetReleases replaces the latest versions seen by 'upm
+// watch-releases' with the given ones.
+func SetReleases(b api.LanguageBackend, releases map[api.PkgName]api.PkgVersion) {
+	readMaybe()
+	initLanguage(b.Name)
+	st.Languages[b.Name].Releases = map[string]api.PkgVersion{}
+	for name, version := range releases {
+		st.Languages[b.Name].Releases[string(name)] = version
+	}
+}
+
+// GetLicenses returns the licenses of the packages in the lockfile
+// that the last run of 'upm licenses' found, keyed as SetLicenses was
+// given them. Nothing is returned with --no-cache.
+func GetLicenses(b api.LanguageBackend) map[string]CachedLicense {
+	readMaybe()
+	initLanguage(b.Name)
+	licenses := map[string]CachedLicense{}
+	if config.NoCache {
+		return licenses
+	}
+	for key, cached := range st.Languages[b.Name].Licenses {
+		licenses[key] = cached
+	}
+	return licenses
+}
+
+// SetLicenses replaces the licenses found by 'upm licenses' with the
+// given ones, so that those of packages that have left the lockfile
+// are forgotten.
+func SetLicenses(b api.LanguageBackend, licenses map[string]CachedLicense) {
+	readMaybe()
+	initLanguage(b.Name)
+	st.Languages[b.Name].Licenses = licenses
+}
+
+// Fresh returns true if the license was fetched recently enough,
+// according to --cache-ttl, to be used without fetching it again.
+func (cached CachedLicense) Fresh() bool {
+	return time.Since(cached.Fetched) < config.CacheTTL
+}
+
+// GetChangedGuess returns what the last guess made with --changed-only
+// guessed, or nil if there was none.
+func GetChangedGuess(b api.LanguageBackend) *ChangedGuess {
+	readMaybe()
+	initLanguage(b.Name)
+	return st.Languages[b.Name].ChangedGuess
+}
+
+// SetChangedGuess records what a guess made with --changed-only
+// guessed, for the next one.
+func SetChangedGuess(b api.LanguageBackend, guess *ChangedGuess) {
+	readMaybe()
+	initLanguage(b.Name)
+	st.Languages[b.Name].ChangedGuess = guess
+}
+
+// GetDeclined returns the normalized names of the packages that were
+// declined when they were guessed.
+func GetDeclined(b api.LanguageBackend) map[api.PkgName]bool {
+	readMaybe()
+	initLanguage(b.Name)
+	declined := map[api.PkgName]bool{}
+	for _, name := range st.Languages[b.Name].Declined {
+		declined[api.PkgName(name)] = true
+	}
+	return declined
+}
+
+// AddDeclined records that the given packages were declined when they
+// were guessed.
+func AddDeclined(b api.LanguageBackend, names map[api.PkgName]bool) {
+	declined := GetDeclined(b)
+	for name := range names {
+		declined[b.NormalizePackageName(name)] = true
+	}
+	setDeclined(b, declined)
+}
+
+// ClearDeclined forgets that the given packages were declined, if
+// they were. Names are compared after normalization.
+func ClearDeclined(b api.LanguageBackend, names map[api.PkgName]bool) {
+	declined := GetDeclined(b)
+	for name := range names {
+		delete(declined, b.NormalizePackageName(name))
+	}
+	setDeclined(b, declined)
+}
+
+// setDeclined replaces the declined packages with the given ones,
+// sorted so that the store doesn't change needlessly.
+func setDeclined(b api.LanguageBackend, declined map[api.PkgName]bool) {
+	names := []string{}
+	for name := range declined {
+		names = append(names, string(name))
+	}
+	sort.Strings(names)
+	st.Languages[b.Name].Declined = names
+}
+
+// pkgInfoMutex guards the cache of package information, which search
+// fills in from many goroutines at once.
+var pkgInfoMutex sync.Mutex
+
+// GetPkgInfo returns the information about a package that was cached
+// for the given URL, and whether there was any. Nothing is returned
+// with --no-cache.
+func GetPkgInfo(url string) (CachedPkgInfo, bool) {
+	pkgInfoMutex.Lock()
+	defer pkgInfoMutex.Unlock()
+	if config.NoCache {
+		return CachedPkgInfo{}, false
+	}
+	readMaybe()
+	cached, ok := st.PkgInfo[url]
+	return cached, ok
+}
+
+// PutPkgInfo caches the information about a package that was just
+// fetched from the given URL.
+func PutPkgInfo(url string, info api.PkgInfo) {
+	pkgInfoMutex.Lock()
+	defer pkgInfoMutex.Unlock()
+	readMaybe()
+	if st.PkgInfo == nil {
+		st.PkgInfo = map[string]CachedPkgInfo{}
+	}
+	st.PkgInfo[url] = CachedPkgInfo{
+		Fetched: time.Now().UTC(),
+		Info:    info,
+	}
+}
+
+// CountPkgInfoLookup counts a lookup of information about a package of
+// the given ecosystem, which was answered from the cache if hit is
+// true.
+func CountPkgInfoLookup(ecosystem string, hit bool) {
+	pkgInfoMutex.Lock()
+	defer pkgInfoMutex.Unlock()
+	readMaybe()
+	if st.PkgInfoLookups == nil {
+		st.PkgInfoLookups = map[string]*PkgInfoLookups{}
+	}
+	lookups := st.PkgInfoLookups[ecosystem]
+	if lookups == nil {
+		lookups = &PkgInfoLookups{}
+		st.PkgInfoLookups[ecosystem] = lookups
+	}
+	if hit {
+		lookups.Hits++
+	} else {
+		lookups.Misses++
+	}
+}
+
+// GetPkgInfoLookups returns how the lookups of information about the
+// packages of the given ecosystem were answered, as CountPkgInfoLookup
+// counted them.
+func GetPkgInfoLookups(ecosystem string) PkgInfoLookups {
+	pkgInfoMutex.Lock()
+	defer pkgInfoMutex.Unlock()
+	readMaybe()
+	if lookups := st.PkgInfoLookups[ecosystem]; lookups != nil {
+		return *lookups
+	}
+	return PkgInfoLookups{}
+}
+
+// Fresh returns true if the information was fetched recently enough,
+// according to --cache-ttl, to be used without fetching it again.
+func (cached CachedPkgInfo) Fresh() bool {
+	return time.Since(cached.Fetched) < config.CacheTTL
+}
+
+// The kinds of caches that Stats and Clear know, in the order in which
+// Stats returns them.
+const (
+	// Information about packages fetched from package indexes,
+	// which is shared by the backends that use the same one.
+	CacheKindInfo = "info"
+	// The licenses that 'upm licenses' found.
+	CacheKindLicenses = "licenses"
+	// The last guesses, both full and with --changed-only.
+	CacheKindGuess = "guess"
+	// What 'upm list' last read, for 'upm list --quick'.
+	CacheKindListing = "listing"
+)
+
+// CacheKinds are the kinds of caches in the store, in order.
+var CacheKinds = []string{CacheKindInfo, CacheKindLicenses, CacheKindGuess, CacheKindListing}
+
+// CacheStats is how much one of the caches in the store holds.
+type CacheStats struct {
+	// One of CacheKinds.
+	Kind string `json:"kind"`
+	// The backend that the cache is for, or empty for CacheKindInfo,
+	// which is shared.
+	Backend string `json:"backend,omitempty"`
+	// The number of entries: URLs for CacheKindInfo, packages for
+	// CacheKindLicenses, guesses for CacheKindGuess, and files for
+	// CacheKindListing.
+	Entries int `json:"entries"`
+	// How many of them are fresh enough to use, according to
+	// --cache-ttl, for the caches that expire, or nil for the
+	// others, which last until what they are of changes.
+	Fresh *int `json:"fresh,omitempty"`
+}
+
+// Location returns the file path of the store, which is
+// .upm/store.json unless UPM_STORE says otherwise.
+func Location() string {
+	return getStoreLocation()
+}
+
+// languageStats returns the stats of the caches of the given kind for
+// the given backend, or false if it has no such cache.
+func languageStats(kind string, name string, lang *storeLanguage) (CacheStats, bool) {
+	stats := CacheStats{Kind: kind, Backend: name}
+	switch kind {
+	case CacheKindLicenses:
+		fresh := 0
+		for _, cached := range lang.Licenses {
+			if cached.Fresh() {
+				fresh++
+			}
+		}
+		stats.Entries = len(lang.Licenses)
+		stats.Fresh = &fresh
+	case CacheKindGuess:
+		if lang.GuessedImportsHash != "" {
+			stats.Entries++
+		}
+		if lang.ChangedGuess != nil {
+			stats.Entries++
+		}
+	case CacheKindListing:
+		if lang.SpecfileListing != nil {
+			stats.Entries++
+		}
+		if lang.LockfileListing != nil {
+			stats.Entries++
+		}
+	}
+	return stats, stats.Entries > 0
+}
+
+// Stats returns how much each of the caches in the store holds, in
+// the order of CacheKinds and then by backend, for the backends whose
+// names match is true of. Caches that are empty are left out, other
+// than CacheKindInfo.
+func Stats(match func(backend string) bool) []CacheStats {
+	pkgInfoMutex.Lock()
+	defer pkgInfoMutex.Unlock()
+	readMaybe()
+
+	fresh := 0
+	for _, cached := range st.PkgInfo {
+		if cached.Fresh() {
+			fresh++
+		}
+	}
+	stats := []CacheStats{{Kind: CacheKindInfo, Entries: len(st.PkgInfo), Fresh: &fresh}}
+
+	names := []string{}
+	for name := range st.Languages {
+		if match(name) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, kind := range CacheKinds[1:] {
+		for _, name := range names {
+			if s, ok := languageStats(kind, name, st.Languages[name]); ok {
+				stats = append(stats, s)
+			}
+		}
+	}
+	return stats
+}
+
+// Clear empties the caches of the given kinds, those of CacheKinds
+// that it is true of, for the backends whose names match is true of,
+// and returns how many entries they held. CacheKindInfo, which is shared,
+// is emptied whichever backends match.
+func Clear(kinds map[string]bool, match func(backend string) bool) int {
+	pkgInfoMutex.Lock()
+	defer pkgInfoMutex.Unlock()
+	readMaybe()
+
+	cleared := 0
+	if kinds[CacheKindInfo] {
+		cleared += len(st.PkgInfo)
+		st.PkgInfo = nil
+	}
+	for name, lang := range st.Languages {
+		if !match(name) {
+			continue
+		}
+		for _, kind := range CacheKinds[1:] {
+			if !kinds[kind] {
+				continue
+			}
+			if s, ok := languageStats(kind, name, lang); ok {
+				cleared += s.Entries
+			}
+			switch kind {
+			case CacheKindLicenses:
+				lang.Licenses = nil
+			case CacheKindGuess:
+				lang.GuessedImports = nil
+				lang.GuessedDetails = nil
+				lang.GuessedImportsHash = ""
+				lang.ChangedGuess = nil
+			case CacheKindListing:
+				lang.SpecfileListing = nil
+				lang.LockfileListing = nil
+			}
+		}
+	}
+	return cleared
 }
@@ -1,5 +1,11 @@
 package store
 
+import (
+	"time"
+
+	"github.com/replit/upm/internal/api"
+)
+
 // hash is used in the store to represent a serializable MD5 hash.
 type hash string
 
@@ -15,14 +21,107 @@ type storeLanguage struct {
 	// computed.
 	LockfileHash hash `json:"lockfileHash,omitempty"`
 
+	// The profile that was in use the last time the hashes were
+	// updated, or an empty string if there was none.
+	Profile string `json:"profile,omitempty"`
+
 	// The last return value of b.Guess(), converted to a slice.
 	// This is only set if the language backend provides
 	// GuessRegexps.
 	GuessedImports []string `json:"guessedImports,omitempty"`
 
+	// Map from the names in GuessedImports to what
+	// b.GuessDetails() said about them, for those it said
+	// anything about.
+	GuessedDetails map[string]api.GuessedPkg `json:"guessedDetails,omitempty"`
+
 	// The hash of the last sequence of matches for GuessRegexps
 	// against the project code.
 	GuessedImportsHash hash `json:"guessedImportsHash,omitempty"`
+
+	// Map from the names of packages that were added with 'upm
+	// add --editable' to the local paths they were added from.
+	Editable map[string]string `json:"editable,omitempty"`
+
+	// Map from the names of packages that were swapped for a
+	// local checkout with 'upm link' to what is needed to swap
+	// them back.
+	Links map[string]Link `json:"links,omitempty"`
+
+	// Map from the names of the packages in the specfile to the
+	// latest version of each that 'upm watch-releases' has seen.
+	Releases map[string]api.PkgVersion `json:"releases,omitempty"`
+
+	// The normalized names of the packages that were guessed but
+	// declined in 'upm guess --interactive', which aren't guessed
+	// again until they are added by hand.
+	Declined []string `json:"declined,omitempty"`
+
+	// What the specfile and lockfile listed the last time that
+	// 'upm list' read them, for 'upm list --quick'.
+	SpecfileListing *Listing `json:"specfileListing,omitempty"`
+	LockfileListing *Listing `json:"lockfileListing,omitempty"`
+
+	// What the last guess made with --changed-only guessed, for
+	// the next one to start from.
+	ChangedGuess *ChangedGuess `json:"changedGuess,omitempty"`
+
+	// Map from the packages in the lockfile, as "name@version"
+	// with the name normalized, to the license that 'upm
+	// licenses' found for each.
+	Licenses map[string]CachedLicense `json:"licenses,omitempty"`
+}
+
+// CachedLicense is the license of a package as the registry gave it,
+// and when.
+type CachedLicense struct {
+	Fetched time.Time `json:"fetched"`
+	// The license, or empty if the registry gave none.
+	License string `json:"license"`
+}
+
+// ChangedGuess is what a guess made with --changed-only guessed, and
+// what the project was like then, so that the next one can tell which
+// files have changed since.
+type ChangedGuess struct {
+	// When the guess was made.
+	Guessed time.Time `json:"guessed"`
+	// The commit that HEAD was at, if the project is in a Git
+	// repository, and the files that differed from it.
+	Commit string   `json:"commit,omitempty"`
+	Dirty  []string `json:"dirty,omitempty"`
+	// The GuessCacheKey of the backend then, if it has one.
+	CacheKey string `json:"cacheKey,omitempty"`
+	// Map from the names of the packages that were guessed to
+	// what the backend said about them.
+	Packages map[string]api.GuessedPkg `json:"packages"`
+}
+
+// Listing is what a specfile or lockfile listed when it was read.
+type Listing struct {
+	// The file that was listed, and its hash then.
+	File string `json:"file"`
+	Hash hash   `json:"hash"`
+	// When it was listed.
+	Listed time.Time `json:"listed"`
+	// Map from the names of the packages to their specs, or for a
+	// lockfile, their versions.
+	Packages map[string]string `json:"packages"`
+	// Map from the names of the packages in a dependency group
+	// other than the main one to the group.
+	Groups map[string]string `json:"groups,omitempty"`
+}
+
+// Link records a registry dependency that has been temporarily
+// replaced by an editable install from a local directory.
+type Link struct {
+	// The local directory that the package is installed from.
+	Path string `json:"path"`
+
+	// The name and spec that the package had in the specfile
+	// before it was linked, which are restored by 'upm unlink'.
+	OriginalName api.PkgName `json:"originalName"`
+	OriginalSpec api.PkgSpec `json:"originalSpec,omitempty"`
 }
 
 // store represents the JSON written (by default) to .upm/store.json.
@@ -35,4 +134,29 @@ type store struct {
 
 	// Map from backend names to per-backend data.
 	Languages map[string]*storeLanguage `json:"languages,omitempty"`
+
+	// Map from the URLs that information about packages was
+	// fetched from to what was found there. This is shared by
+	// the backends that use the same package index.
+	PkgInfo map[string]CachedPkgInfo `json:"pkgInfo,omitempty"`
+
+	// Map from ecosystems, such as "pypi", to how often
+	// information about their packages was found in PkgInfo,
+	// for 'upm index status'.
+	PkgInfoLookups map[string]*PkgInfoLookups `json:"pkgInfoLookups,omitempty"`
+}
+
+// PkgInfoLookups counts the lookups of information about the packages
+// of an ecosystem: those that were answered from the cache, and those
+// that had to be fetched.
+type PkgInfoLookups struct {
+	Hits   int `json:"hits"`
+	Misses int `json:"misses"`
+}
+
+// CachedPkgInfo is information about a package that was fetched from a
+// package index, and when.
+type CachedPkgInfo struct {
+	Fetched time.Time   `json:"fetched"`
+	Info    api.PkgInfo `json:"info"`
 }
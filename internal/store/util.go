@@ -1,6 +1,7 @@
 package store
 
 import (
+	"context"
 	"crypto/md5"
 	"encoding/hex"
 	"io/ioutil"
@@ -24,17 +25,18 @@ func hashFile(filename string) hash {
 }
 
 // hashImports computes the MD5 hash of the matches of b.GuessRegexps
-// against b.FilenamePatterns within the project. It is guaranteed to
+// against b.FilenamePatterns within the project, along with
+// b.GuessCacheKey if the backend has one. It is guaranteed to
 // be deterministic as long as the project files do not change in such
 // a way as to change what any of the regexps match against. If there
 // are no regexp matches, then as a special case the empty string is
 // returned.
-func hashImports(b api.LanguageBackend) hash {
+func hashImports(ctx context.Context, b api.LanguageBackend) hash {
 	bytes := []byte{}
 	for _, r := range b.GuessRegexps {
 		// Rely on lexical ordering of filepath.Walk to
 		// guarantee a consistent hash.
-		for _, match := range util.SearchRecursive(r, b.FilenamePatterns) {
+		for _, match := range util.SearchRecursive(ctx, r, b.FilenamePatterns) {
 			if len(match) == 1 {
 				bytes = append(bytes, match[0]...)
 			} else {
@@ -47,6 +49,9 @@ func hashImports(b api.LanguageBackend) hash {
 	if len(bytes) == 0 {
 		return ""
 	}
+	if b.GuessCacheKey != nil {
+		bytes = append(append(bytes, 0), b.GuessCacheKey()...)
+	}
 	sum := md5.Sum(bytes)
 	return hash(hex.EncodeToString(sum[:]))
 }
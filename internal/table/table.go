@@ -9,6 +9,7 @@ import (
 	"os/exec"
 	"reflect"
 	"sort"
+	"strconv"
 	"strings"
 
 	"github.com/replit/upm/internal/util"
@@ -33,9 +34,11 @@ func New(headers ...string) Table {
 // The table headers are generated from the struct field reflection
 // metadata: each struct field must have a reflection metadata key
 // "pretty" whose value is the header to display. The only allowed
-// field types in the struct are string and []string. The strings are
-// used as table cells directly, while the slices are concatenated
-// with commas first.
+// field types in the struct are string, []string, int and int64. The
+// strings are used as table cells directly, the slices are
+// concatenated with commas first, and the integers are written in
+// decimal, leaving the cell empty if they are zero. Columns whose
+// cells are all empty are left out.
 func FromStructs(structs interface{}) Table {
 	sv := reflect.ValueOf(structs)
 	st := reflect.TypeOf(structs).Elem()
@@ -45,7 +48,7 @@ func FromStructs(structs interface{}) Table {
 	for i := 0; i < st.NumField(); i++ {
 		nonempty := false
 		for j := 0; j < sv.Len(); j++ {
-			if sv.Index(j).Field(i).Len() > 0 {
+			if formatField(sv.Index(j).Field(i)) != "" {
 				nonempty = true
 				break
 			}
@@ -62,26 +65,36 @@ func FromStructs(structs interface{}) Table {
 	for j := 0; j < sv.Len(); j++ {
 		row := []string{}
 		for _, i := range indices {
-			var value string
-			rfield := sv.Index(j).Field(i)
-			switch rfield.Kind() {
-			case reflect.String:
-				value = rfield.String()
-			case reflect.Slice:
-				parts := []string{}
-				for j := 0; j < rfield.Len(); j++ {
-					str := rfield.Index(j).String()
-					parts = append(parts, str)
-				}
-				value = strings.Join(parts, ", ")
-			}
-			row = append(row, value)
+			row = append(row, formatField(sv.Index(j).Field(i)))
 		}
 		t.AddRow(row...)
 	}
 	return t
 }
 
+// formatField returns the table cell for a struct field, as described
+// for FromStructs.
+func formatField(rfield reflect.Value) string {
+	switch rfield.Kind() {
+	case reflect.String:
+		return rfield.String()
+	case reflect.Int, reflect.Int64:
+		if n := rfield.Int(); n != 0 {
+			return strconv.FormatInt(n, 10)
+		}
+		return ""
+	case reflect.Slice:
+		parts := []string{}
+		for j := 0; j < rfield.Len(); j++ {
+			str := rfield.Index(j).String()
+			parts = append(parts, str)
+		}
+		return strings.Join(parts, ", ")
+	}
+	util.Panicf("unsupported table field type: %s", rfield.Type())
+	return ""
+}
+
 // AddRow adds a row at the end of a table. The length of the row must
 // be the same as the number of headers in the table, or a panic will
 // be generated.
@@ -144,7 +157,7 @@ func (t *Table) SortBy(header string) {
 // printOrPage either prints text to stdout or invokes the 'less'
 // utility to display it. 'less' is invoked if stdout is connected to
 // a tty, the provided width is too wide for the tty, and 'less' is
-// actually installed.
+// actually installed (and allowed by the command policy).
 func printOrPage(text string, width int) {
 	termWidth, _, err := terminal.GetSize(1)
 	if err != nil || width < termWidth {
@@ -153,7 +166,7 @@ func printOrPage(text string, width int) {
 	}
 
 	less, err := exec.LookPath("less")
-	if err != nil {
+	if err != nil || !util.IsCommandAllowed(less) {
 		fmt.Print(text)
 		return
 	}
@@ -191,11 +204,23 @@ func printOrPage(text string, width int) {
 	}
 }
 
+// String returns the table as text, aligning columns by inserting
+// whitespace.
+func (t *Table) String() string {
+	text, _ := t.render()
+	return text
+}
+
 // Print writes the table to stdout, aligning columns by inserting
 // whitespace. If the table is too wide for the current terminal, and
 // the 'less' utility is installed, Print invokes it with the -S
 // option to truncate long lines and allow horizontal scrolling.
 func (t *Table) Print() {
+	printOrPage(t.render())
+}
+
+// render returns the table as text, and the width of its widest line.
+func (t *Table) render() (string, int) {
 	lines := []string{}
 	widths := make([]int, len(t.headers))
 	for j := range t.headers {
@@ -228,5 +253,5 @@ func (t *Table) Print() {
 	// A bit of a hack; we should really compute this directly
 	// from the widths array, but this is simple.
 	totalWidth := len([]rune(lines[1]))
-	printOrPage(strings.Join(lines, "\n")+"\n", totalWidth)
+	return strings.Join(lines, "\n") + "\n", totalWidth
 }
@@ -0,0 +1,46 @@
+package table
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/replit/upm/internal/api"
+)
+
+func TestFromStructs(t *testing.T) {
+	tbl := FromStructs([]api.PkgInfo{
+		{
+			Name:            "flask",
+			Version:         "3.0.0",
+			Dependencies:    []string{"click", "jinja2"},
+			DependencyCount: 2,
+			Size:            101745,
+		},
+		{
+			Name:    "itsdangerous",
+			Version: "2.1.2",
+		},
+	})
+	require.Equal(t, []string{"Name", "Version", "Dependencies", "Direct dependencies", "Size (bytes)"}, tbl.headers)
+	require.Equal(t, [][]string{
+		{"flask", "3.0.0", "click, jinja2", "2", "101745"},
+		{"itsdangerous", "2.1.2", "", "", ""},
+	}, tbl.rows)
+}
+
+func TestFromStructsDownloads(t *testing.T) {
+	// Download counts are only known for some packages, and the
+	// rows keep the order that they were sorted in.
+	tbl := FromStructs([]api.PkgInfo{
+		{Name: "requests", Downloads: 500000, DownloadsDate: "2024-05-01"},
+		{Name: "requests-oauthlib", Downloads: 12000, DownloadsDate: "2024-05-01"},
+		{Name: "requests-upm-test"},
+	})
+	require.Equal(t, []string{"Name", "Downloads", "Downloads as of"}, tbl.headers)
+	require.Equal(t, [][]string{
+		{"requests", "500000", "2024-05-01"},
+		{"requests-oauthlib", "12000", "2024-05-01"},
+		{"requests-upm-test", "", ""},
+	}, tbl.rows)
+}
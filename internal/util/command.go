@@ -1,11 +1,16 @@
 package util
 
 import (
+	"context"
 	"os"
 	"os/exec"
+	"path/filepath"
 	"strings"
+	"sync"
 
 	"github.com/kballard/go-shellquote"
+
+	"github.com/replit/upm/internal/config"
 )
 
 // quoteCmd escapes shell characters in a command. Additionally, it
@@ -21,14 +26,111 @@ func quoteCmd(cmd []string) string {
 	return shellquote.Join(cleanedCmd...)
 }
 
+// runningCommands counts the subprocesses that are running, so that
+// they can be waited for after the context of the process is
+// cancelled by a signal.
+var runningCommands sync.WaitGroup
+
+// Command returns an exec.Cmd for the given command, which is killed
+// once ctx is done. Every subprocess should be made with Command and
+// run with RunCommand, with the same context.
+func Command(ctx context.Context, cmd []string) *exec.Cmd {
+	return exec.CommandContext(ctx, cmd[0], cmd[1:]...)
+}
+
+// RunCommand runs the given command, made by Command with ctx, and
+// waits for it to exit. The command counts as one of the Jobs while it
+// runs. If ctx is done before the command fails, RunCommand aborts
+// instead of returning the error of the command (see Abort).
+func RunCommand(ctx context.Context, command *exec.Cmd) error {
+	StartJob()
+	defer FinishJob()
+	runningCommands.Add(1)
+	_, err := runRecorded(ctx, command, false)
+	runningCommands.Done()
+	if err != nil {
+		Abort(ctx)
+	}
+	return err
+}
+
+// CommandOutput is like RunCommand, but returns the stdout of the
+// command, like exec.Cmd.Output.
+func CommandOutput(ctx context.Context, command *exec.Cmd) ([]byte, error) {
+	StartJob()
+	defer FinishJob()
+	runningCommands.Add(1)
+	output, err := runRecorded(ctx, command, true)
+	runningCommands.Done()
+	if err != nil {
+		Abort(ctx)
+	}
+	return output, err
+}
+
+// RefuseIfReadOnly terminates the process if UPM is in read-only mode.
+// It should be called before doing anything that could modify the
+// project, which is described by the given action (for example "run
+// npm install").
+func RefuseIfReadOnly(action string) {
+	if config.ReadOnly {
+		Die("refusing to %s in read-only mode", action)
+	}
+}
+
+// IsCommandAllowed returns true if the command policy allows running
+// the given program, which is a name to be looked up on $PATH or a
+// path. The program must resolve to one of the allowed absolute paths,
+// either directly or after following symlinks. Without a policy, every
+// program is allowed.
+func IsCommandAllowed(program string) bool {
+	if config.AllowedCommands == nil {
+		return true
+	}
+	path, err := exec.LookPath(program)
+	if err != nil {
+		return false
+	}
+	path, err = filepath.Abs(path)
+	if err != nil {
+		return false
+	}
+	candidates := []string{path}
+	if realPath, err := filepath.EvalSymlinks(path); err == nil {
+		candidates = append(candidates, realPath)
+	}
+	for _, allowed := range config.AllowedCommands {
+		for _, candidate := range candidates {
+			if filepath.Clean(allowed) == candidate {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// RefuseIfNotAllowed terminates the process unless the command policy
+// allows running the given program. It should be called before
+// running any subprocess.
+func RefuseIfNotAllowed(program string) {
+	if !IsCommandAllowed(program) {
+		Die("refusing to run %s: not allowed by the command policy", program)
+	}
+}
+
 // RunCmd prints and runs the given command, exiting the process on
 // error or command failure. Stdout and stderr go to the terminal.
-func RunCmd(cmd []string) {
+// Since this is how package managers are run to change things,
+// RunCmd refuses to run anything in read-only mode; commands that
+// only query should use GetCmdOutput instead.
+func RunCmd(ctx context.Context, cmd []string) {
+	RefuseIfReadOnly("run " + quoteCmd(cmd))
+	RefuseIfNotAllowed(cmd[0])
 	ProgressMsg(quoteCmd(cmd))
-	command := exec.Command(cmd[0], cmd[1:]...)
+	command := Command(ctx, cmd)
 	command.Stdout = os.Stderr
 	command.Stderr = os.Stderr
-	if err := command.Run(); err != nil {
+	if err := RunCommand(ctx, command); err != nil {
 		Die("%s", err)
 	}
 }
@@ -36,30 +138,80 @@ func RunCmd(cmd []string) {
 // GetCmdOutput prints and runs the given command, returning its
 // stdout as a string. Stderr goes to the terminal. GetCmdOutput exits
 // the process on error or command failure.
-func GetCmdOutput(cmd []string) []byte {
+func GetCmdOutput(ctx context.Context, cmd []string) []byte {
+	RefuseIfNotAllowed(cmd[0])
 	ProgressMsg(quoteCmd(cmd))
-	command := exec.Command(cmd[0], cmd[1:]...)
+	command := Command(ctx, cmd)
 	command.Stderr = os.Stderr
-	output, err := command.Output()
+	output, err := CommandOutput(ctx, command)
 	if err != nil {
 		Die("%s", err)
 	}
 	return output
 }
 
+// GetCmdOutputAndExitCode prints and runs the given command,
+// returning its stdout as a string along with its exit code. Stderr
+// goes to the terminal. Unlike GetCmdOutput, a command that exits
+// with a nonzero status is not an error, so this is for commands like
+// diff that use the exit code to report their result.
+// GetCmdOutputAndExitCode exits the process if the command could not
+// be run at all.
+func GetCmdOutputAndExitCode(ctx context.Context, cmd []string) ([]byte, int) {
+	RefuseIfNotAllowed(cmd[0])
+	ProgressMsg(quoteCmd(cmd))
+	command := Command(ctx, cmd)
+	command.Stderr = os.Stderr
+	output, err := CommandOutput(ctx, command)
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		return output, exitErr.ExitCode()
+	} else if err != nil {
+		Die("%s", err)
+	}
+	return output, 0
+}
+
 // GetExitCode runs a commands, and optionally prints the output to
-// stdout and/or stderr, and it returns the exit code afterwards.
-func GetExitCode(cmd []string, printStdout bool, printStderr bool) int {
+// stdout and/or stderr, and it returns the exit code afterwards. Like
+// RunCmd, it refuses to run anything in read-only mode.
+func GetExitCode(ctx context.Context, cmd []string, printStdout bool, printStderr bool) int {
+	RefuseIfReadOnly("run " + quoteCmd(cmd))
+	RefuseIfNotAllowed(cmd[0])
 	ProgressMsg(quoteCmd(cmd))
-	command := exec.Command(cmd[0], cmd[1:]...)
+	command := Command(ctx, cmd)
 	if printStdout {
 		command.Stdout = os.Stdout
 	}
 	if printStderr {
 		command.Stderr = os.Stderr
 	}
-	if err := command.Run(); err != nil {
-		return err.(*exec.ExitError).ExitCode()
+	if err := RunCommand(ctx, command); err != nil {
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			return exitErr.ExitCode()
+		}
+		Die("%s", err)
 	}
 	return 0
 }
+
+// RunInteractiveCmd prints and runs the given command attached to the
+// terminal, as a program that the user asked for rather than one that
+// UPM runs for its own sake. If the command exits with a nonzero
+// status, so does the process. Like RunCmd, it refuses to run anything
+// in read-only mode.
+func RunInteractiveCmd(ctx context.Context, cmd []string) {
+	RefuseIfReadOnly("run " + quoteCmd(cmd))
+	RefuseIfNotAllowed(cmd[0])
+	ProgressMsg(shellquote.Join(cmd...))
+	command := Command(ctx, cmd)
+	command.Stdin = os.Stdin
+	command.Stdout = os.Stdout
+	command.Stderr = os.Stderr
+	if err := RunCommand(ctx, command); err != nil {
+		if exitErr, ok := err.(*exec.ExitError); ok && exitErr.ExitCode() > 0 {
+			CleanupTemp()
+			os.Exit(exitErr.ExitCode())
+		}
+		Die("%s", err)
+	}
+}
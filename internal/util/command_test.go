@@ -0,0 +1,40 @@
+package util
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/replit/upm/internal/config"
+)
+
+func TestIsCommandAllowed(t *testing.T) {
+	dir := t.TempDir()
+	program := filepath.Join(dir, "tool")
+	require.NoError(t, ioutil.WriteFile(program, []byte("#!/bin/sh\n"), 0755))
+	link := filepath.Join(dir, "tool-link")
+	require.NoError(t, os.Symlink(program, link))
+	other := filepath.Join(dir, "other")
+	require.NoError(t, ioutil.WriteFile(other, []byte("#!/bin/sh\n"), 0755))
+
+	defer func() { config.AllowedCommands = nil }()
+
+	config.AllowedCommands = nil
+	require.True(t, IsCommandAllowed(other))
+
+	config.AllowedCommands = []string{program}
+	require.True(t, IsCommandAllowed(program))
+	require.True(t, IsCommandAllowed(link))
+	require.False(t, IsCommandAllowed(other))
+	require.False(t, IsCommandAllowed(filepath.Join(dir, "missing")))
+
+	t.Setenv("PATH", dir)
+	require.True(t, IsCommandAllowed("tool"))
+	require.False(t, IsCommandAllowed("other"))
+
+	config.AllowedCommands = []string{}
+	require.False(t, IsCommandAllowed(program))
+}
@@ -0,0 +1,46 @@
+package util
+
+import (
+	"context"
+)
+
+// cancellation is what Abort panics with, to unwind an operation
+// whose context is done to the Cancellable that runs it.
+type cancellation struct {
+	err error
+}
+
+// Abort unwinds the operation that is being run under ctx, if ctx is
+// done, to the Cancellable that runs it, which then returns the error
+// of ctx. The functions of this package that take a context call it
+// when they fail, rather than terminating the process or returning an
+// error that says nothing about why (such as "signal: killed" for a
+// subprocess that was killed), so nothing that calls them has to tell
+// a cancellation apart from any other failure. It must be called on
+// the goroutine that runs the operation.
+func Abort(ctx context.Context) {
+	if err := ctx.Err(); err != nil {
+		panic(cancellation{err})
+	}
+}
+
+// Cancellable runs the given operation, such as a call to a backend
+// with a context, and returns the error of that context if the
+// operation was cancelled partway through, or nil if it ran to the
+// end. A program that uses UPM as a library should call backends
+// through it, so that cancelling an operation doesn't terminate the
+// process; any other failure still does, as documented for the
+// function that fails.
+func Cancellable(op func()) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			c, ok := r.(cancellation)
+			if !ok {
+				panic(r)
+			}
+			err = c.err
+		}
+	}()
+	op()
+	return nil
+}
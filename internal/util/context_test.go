@@ -0,0 +1,38 @@
+package util
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCancellable(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	}))
+	defer server.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	err := Cancellable(func() {
+		HTTPGet(ctx, server.URL)
+		t.Fatal("HTTPGet returned after its context was done")
+	})
+	require.Equal(t, context.DeadlineExceeded, err)
+
+	err = Cancellable(func() {
+		RunCommand(ctx, Command(ctx, []string{"sleep", "10"}))
+		t.Fatal("RunCommand returned after its context was done")
+	})
+	require.Equal(t, context.DeadlineExceeded, err)
+	require.Less(t, time.Since(start), 5*time.Second)
+
+	err = Cancellable(func() {})
+	require.NoError(t, err)
+}
@@ -2,6 +2,7 @@ package util
 
 import (
 	"bytes"
+	"context"
 	"io"
 	"io/ioutil"
 	"net/http"
@@ -9,6 +10,7 @@ import (
 	"path"
 	"path/filepath"
 	"regexp"
+	"sync"
 
 	"github.com/natefinch/atomic"
 	sfs "github.com/rakyll/statik/fs"
@@ -24,6 +26,7 @@ var IgnoredPaths = []string{
 	".bundle",
 	".cache",
 	".cask",
+	".conda",
 	".config",
 	".git",
 	".hg",
@@ -31,30 +34,50 @@ var IgnoredPaths = []string{
 	".next",
 	".npm",
 	".svn",
+	".upm",
 	".tox",
 	"__generated__",
+	"__pypackages__",
 	"__pycache__",
 	"__tests__",
+	"Pods",
 	"doc",
 	"docs",
 	"documentation",
 	"examples",
+	"local",
+	"lua_modules",
 	"node_modules",
 	"test",
 	"tests",
+	"vcpkg_installed",
 	"vendor",
 	"venv",
 }
 
+// ScanOnly, if it isn't nil, limits the files that the searches for
+// imports read to those in it, by their paths relative to the project
+// directory, with forward slashes. The directories are still walked,
+// so that a backend can tell which modules are local to the project.
+var ScanOnly map[string]bool
+
+// IsScanned returns whether a search for imports should read the file
+// at the given path, relative to the project directory, according to
+// ScanOnly.
+func IsScanned(path string) bool {
+	return ScanOnly == nil || ScanOnly[filepath.ToSlash(filepath.Clean(path))]
+}
+
 // AddIngoredPaths globally appends to the IngoredPaths list.
 func AddIngoredPaths(paths []string) {
 	IgnoredPaths = append(IgnoredPaths, paths...)
 }
 
 // TryWriteAtomic tries to write contents to filename atomically,
-// retrying non-atomically if it can't. If both attempts fail,
-// TryWriteAtomic terminates the process.
+// retrying non-atomically if it can't. If both attempts fail, or UPM
+// is in read-only mode, TryWriteAtomic terminates the process.
 func TryWriteAtomic(filename string, contents []byte) {
+	RefuseIfReadOnly("write " + filename)
 	if err1 := atomic.WriteFile(filename, bytes.NewReader(contents)); err1 != nil {
 		if err2 := ioutil.WriteFile(filename, contents, 0666); err2 != nil {
 			Die("%s: %s; on non-atomic retry: %s", filename, err1, err2)
@@ -89,14 +112,16 @@ func PatternExists(pattern string) bool {
 // directory. Only files whose basenames match one of the globs in
 // patterns will be searched. The return value is a list of matches as
 // would be returned by regexp.FindAllStringSubmatch. Matches are
-// returned in a deterministic order. If an I/O error occurs,
-// SearchRecursive terminates the process.
-func SearchRecursive(r *regexp.Regexp, patterns []string) [][]string {
-	matches := [][]string{}
+// returned in a deterministic order. The files are read and searched
+// Jobs at a time. If an I/O error occurs, SearchRecursive terminates
+// the process, and if ctx is done, it aborts (see Abort).
+func SearchRecursive(ctx context.Context, r *regexp.Regexp, patterns []string) [][]string {
+	paths := []string{}
 	filepath.Walk(".", func(path string, info os.FileInfo, err error) error {
 		if err != nil {
 			Die("%s: %s", path, err)
 		}
+		Abort(ctx)
 		for _, name := range IgnoredPaths {
 			if filepath.Base(path) == name {
 				return filepath.SkipDir
@@ -116,28 +141,49 @@ func SearchRecursive(r *regexp.Regexp, patterns []string) [][]string {
 		if !didMatch {
 			return nil
 		}
-		if info.Mode().IsRegular() {
-			contentsB, err := ioutil.ReadFile(path)
-			if err != nil {
-				Die("%s: %s", path, err)
-			}
-			contents := string(contentsB)
-
-			matches = append(matches,
-				r.FindAllStringSubmatch(contents, -1)...,
-			)
+		if info.Mode().IsRegular() && IsScanned(path) {
+			paths = append(paths, path)
 		}
 		return nil
 	})
 
+	// The matches of each file, in the order of paths.
+	fileMatches := make([][][]string, len(paths))
+	next := make(chan int)
+	var wg sync.WaitGroup
+	for i := 0; i < Jobs() && i < len(paths); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range next {
+				StartJob()
+				contentsB, err := ioutil.ReadFile(paths[j])
+				FinishJob()
+				if err != nil {
+					Die("%s: %s", paths[j], err)
+				}
+				fileMatches[j] = r.FindAllStringSubmatch(string(contentsB), -1)
+			}
+		}()
+	}
+	for j := range paths {
+		next <- j
+	}
+	close(next)
+	wg.Wait()
+
+	matches := [][]string{}
+	for _, m := range fileMatches {
+		matches = append(matches, m...)
+	}
 	return matches
 }
 
 // DownloadFile emulates wget, overwriting any existing file. See
 // https://golangcode.com/download-a-file-from-a-url/.
-func DownloadFile(filepath string, url string) {
+func DownloadFile(ctx context.Context, filepath string, url string) {
 	ProgressMsg("download " + url)
-	resp, err := http.Get(url)
+	resp, err := HTTPGet(ctx, url)
 	if err != nil {
 		Die("%s: %s", url, err)
 	}
@@ -150,22 +196,11 @@ func DownloadFile(filepath string, url string) {
 	defer out.Close()
 
 	if _, err := io.Copy(out, resp.Body); err != nil {
+		Abort(ctx)
 		Die("%s: %s", filepath, err)
 	}
 }
 
-// TempDir creates and returns the name of temporary directory. If
-// creation fails, it terminates the process. The caller is
-// responsible for cleaning up the temporary directory afterwards.
-func TempDir() string {
-	if tempdir, err := ioutil.TempDir("", "upm"); err != nil {
-		Die("%s", err)
-		return ""
-	} else {
-		return tempdir
-	}
-}
-
 // hfs is the statik http.FileSystem, once initialized.
 var statikFS *http.FileSystem
 
@@ -244,3 +279,44 @@ func ChdirToUPM() {
 		cur = next
 	}
 }
+
+// CopyTree recursively copies the directory src to dst, which must
+// not exist yet. Symlinks are copied as symlinks. If there is an
+// error, CopyTree terminates the process, and if ctx is done, it
+// aborts (see Abort).
+func CopyTree(ctx context.Context, src string, dst string) {
+	err := filepath.Walk(src, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+
+		switch {
+		case info.Mode()&os.ModeSymlink != 0:
+			link, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			return os.Symlink(link, target)
+		case info.IsDir():
+			return os.MkdirAll(target, info.Mode().Perm()|0700)
+		default:
+			contents, err := ioutil.ReadFile(path)
+			if err != nil {
+				return err
+			}
+			return ioutil.WriteFile(target, contents, info.Mode().Perm())
+		}
+	})
+	if err != nil {
+		Abort(ctx)
+		Die("copying %s: %s", src, err)
+	}
+}
@@ -0,0 +1,268 @@
+package util
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// HTTPClient is the client that every request to a registry or other
+// web service should be made with. When a server says that it is
+// rate-limiting UPM, HTTPClient waits as long as it is told to and
+// tries again, rather than failing or sending more requests, and holds
+// back every other request to that host in the meantime, so that bulk
+// operations such as searching (which look up many packages at once)
+// are paced instead of hammering the API. A server that doesn't start
+// responding within ResponseTimeout is given up on, so that UPM never
+// hangs waiting for it; a response that takes longer to arrive, such
+// as a large file, is not cut off. Each request counts as one of the
+// Jobs until its response starts to arrive. A request is given up on
+// once its context is done, so requests should be made with the
+// context of the operation that makes them, as HTTPGet does.
+var HTTPClient = &http.Client{
+	Transport: defaultTransport,
+}
+
+// defaultTransport is the transport of HTTPClient.
+var defaultTransport = &rateLimitTransport{
+	base:    newBaseTransport(),
+	blocked: map[string]time.Time{},
+	next:    map[string]time.Time{},
+}
+
+// SetRateLimit makes HTTPClient send at most the given number of
+// requests a second to any one host, or as many as the Jobs allow if
+// it is zero, which is the default. It is for bulk operations such as
+// crawling a whole registry, which would otherwise send a host Jobs
+// requests at a time for hours.
+func SetRateLimit(perSecond float64) {
+	defaultTransport.setRateLimit(perSecond)
+}
+
+// HTTPGet sends a GET request for the given URL with HTTPClient, under
+// ctx. If ctx is done before the response arrives, HTTPGet aborts
+// instead of returning an error (see Abort), so it must be called on
+// the goroutine that runs the operation.
+func HTTPGet(ctx context.Context, url string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	return HTTPDo(ctx, req)
+}
+
+// HTTPPost is like HTTPGet, but sends a POST request with the given
+// body.
+func HTTPPost(ctx context.Context, url string, contentType string, body io.Reader) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, "POST", url, body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", contentType)
+	return HTTPDo(ctx, req)
+}
+
+// HTTPDo is like HTTPGet, but sends the given request, which is made
+// under ctx.
+func HTTPDo(ctx context.Context, req *http.Request) (*http.Response, error) {
+	resp, err := HTTPClient.Do(req.WithContext(ctx))
+	if err != nil {
+		Abort(ctx)
+	}
+	return resp, err
+}
+
+// ResponseTimeout is how long HTTPClient waits for a server to send
+// the headers of its response, once the request has been sent.
+const ResponseTimeout = 30 * time.Second
+
+// newBaseTransport returns the transport that HTTPClient sends its
+// requests with: the default one, with ResponseTimeout. The default
+// transport already times out connecting and the TLS handshake.
+func newBaseTransport() http.RoundTripper {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.ResponseHeaderTimeout = ResponseTimeout
+	return transport
+}
+
+// Limits on how long HTTPClient waits for a rate limit to pass. A
+// server that asks for a longer wait gets its response returned as
+// is, since UPM shouldn't appear to hang.
+const (
+	maxRateLimitRetries = 5
+	maxRateLimitWait    = 60 * time.Second
+)
+
+// rateLimitTransport is the http.RoundTripper of HTTPClient.
+type rateLimitTransport struct {
+	base http.RoundTripper
+
+	mutex sync.Mutex
+	// Map from each host to the time before which no requests
+	// should be sent to it.
+	blocked map[string]time.Time
+	// The least time between the requests to a host, as set by
+	// SetRateLimit, or zero if there is no limit.
+	interval time.Duration
+	// Map from each host to the time at which the next request to
+	// it may be sent under the rate limit.
+	next map[string]time.Time
+}
+
+// setRateLimit implements SetRateLimit.
+func (t *rateLimitTransport) setRateLimit(perSecond float64) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.interval = 0
+	if perSecond > 0 {
+		t.interval = time.Duration(float64(time.Second) / perSecond)
+	}
+}
+
+// wait blocks until requests may be sent to the given host. Under a
+// rate limit, the request takes the next free slot for the host, so
+// even requests that wait at once are sent one interval apart.
+func (t *rateLimitTransport) wait(req *http.Request) error {
+	t.mutex.Lock()
+	until := t.blocked[req.URL.Host]
+	if t.interval > 0 {
+		if now := time.Now(); until.Before(now) {
+			until = now
+		}
+		if next := t.next[req.URL.Host]; until.Before(next) {
+			until = next
+		}
+		t.next[req.URL.Host] = until.Add(t.interval)
+	}
+	t.mutex.Unlock()
+
+	delay := time.Until(until)
+	if delay <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-req.Context().Done():
+		return req.Context().Err()
+	}
+}
+
+// block holds back requests to the given host until the given time,
+// unless they are already held back for longer.
+func (t *rateLimitTransport) block(host string, until time.Time) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if until.After(t.blocked[host]) {
+		t.blocked[host] = until
+	}
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *rateLimitTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	for attempt := 0; ; attempt++ {
+		if err := t.wait(req); err != nil {
+			return nil, err
+		}
+		StartJob()
+		resp, err := recordRoundTrip(t.base, req)
+		FinishJob()
+		if err != nil {
+			return nil, err
+		}
+
+		now := time.Now()
+		if reset, ok := rateLimitReset(resp.Header, now); ok && reset.Sub(now) <= maxRateLimitWait {
+			t.block(req.URL.Host, reset)
+		}
+		if resp.StatusCode != http.StatusTooManyRequests &&
+			resp.StatusCode != http.StatusServiceUnavailable {
+			return resp, nil
+		}
+
+		delay, ok := retryAfter(resp.Header, now)
+		if !ok {
+			if resp.StatusCode == http.StatusServiceUnavailable {
+				// Without Retry-After, this is an outage
+				// rather than a rate limit.
+				return resp, nil
+			}
+			delay = time.Duration(1<<uint(attempt)) * time.Second
+		}
+		if attempt >= maxRateLimitRetries || delay > maxRateLimitWait {
+			return resp, nil
+		}
+		// A request with a body can only be sent again if the
+		// body can be read again.
+		if req.Body != nil && req.Body != http.NoBody {
+			if req.GetBody == nil {
+				return resp, nil
+			}
+			body, err := req.GetBody()
+			if err != nil {
+				return resp, nil
+			}
+			req = req.Clone(req.Context())
+			req.Body = body
+		}
+		resp.Body.Close()
+
+		ProgressMsg(fmt.Sprintf(
+			"%s is rate-limiting requests, retrying in %s",
+			req.URL.Host, delay.Round(time.Second),
+		))
+		t.block(req.URL.Host, now.Add(delay))
+	}
+}
+
+// retryAfter returns how long the given response headers say to wait
+// before trying again, from Retry-After, which may be either a number
+// of seconds or a date.
+func retryAfter(header http.Header, now time.Time) (time.Duration, bool) {
+	value := strings.TrimSpace(header.Get("Retry-After"))
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		if delay := date.Sub(now); delay > 0 {
+			return delay, true
+		}
+		return 0, true
+	}
+	return 0, false
+}
+
+// rateLimitReset returns the time at which the given response headers
+// say that the rate limit resets, if they say that no requests are
+// left until then. Registries use either the RateLimit-* headers of
+// the IETF draft or the X-RateLimit-* headers made popular by GitHub;
+// the reset is a number of seconds from now in the former and usually
+// a Unix time in the latter, so large values are taken to be Unix
+// times.
+func rateLimitReset(header http.Header, now time.Time) (time.Time, bool) {
+	for _, prefix := range []string{"RateLimit-", "X-RateLimit-"} {
+		remaining, err := strconv.Atoi(strings.TrimSpace(header.Get(prefix + "Remaining")))
+		if err != nil || remaining > 0 {
+			continue
+		}
+		reset, err := strconv.ParseInt(strings.TrimSpace(header.Get(prefix+"Reset")), 10, 64)
+		if err != nil || reset < 0 {
+			continue
+		}
+		if reset > 1000000000 {
+			return time.Unix(reset, 0), true
+		}
+		return now.Add(time.Duration(reset) * time.Second), true
+	}
+	return time.Time{}, false
+}
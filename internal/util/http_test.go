@@ -0,0 +1,101 @@
+package util
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestHTTPClientRetriesAfterRateLimit(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if requests < 3 {
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+
+	resp, err := HTTPClient.Get(server.URL)
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, 200, resp.StatusCode)
+	require.Equal(t, 3, requests)
+}
+
+func TestHTTPClientGivesUpOnLongWaits(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", "3600")
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer server.Close()
+
+	resp, err := HTTPClient.Get(server.URL)
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+}
+
+func TestRetryAfter(t *testing.T) {
+	now := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	delay, ok := retryAfter(http.Header{"Retry-After": {"120"}}, now)
+	require.True(t, ok)
+	require.Equal(t, 2*time.Minute, delay)
+
+	delay, ok = retryAfter(http.Header{"Retry-After": {"Tue, 02 Jan 2024 03:04:35 GMT"}}, now)
+	require.True(t, ok)
+	require.Equal(t, 30*time.Second, delay)
+
+	_, ok = retryAfter(http.Header{}, now)
+	require.False(t, ok)
+}
+
+func TestRateLimitReset(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+
+	reset, ok := rateLimitReset(http.Header{
+		"Ratelimit-Remaining": {"0"},
+		"Ratelimit-Reset":     {"10"},
+	}, now)
+	require.True(t, ok)
+	require.Equal(t, now.Add(10*time.Second), reset)
+
+	reset, ok = rateLimitReset(http.Header{
+		"X-Ratelimit-Remaining": {"0"},
+		"X-Ratelimit-Reset":     {"1700000030"},
+	}, now)
+	require.True(t, ok)
+	require.Equal(t, now.Add(30*time.Second), reset)
+
+	_, ok = rateLimitReset(http.Header{
+		"X-Ratelimit-Remaining": {"42"},
+		"X-Ratelimit-Reset":     {"1700000030"},
+	}, now)
+	require.False(t, ok)
+}
+
+func TestSetRateLimit(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+
+	SetRateLimit(20)
+	defer SetRateLimit(0)
+	start := time.Now()
+	for i := 0; i < 5; i++ {
+		resp, err := HTTPClient.Get(server.URL)
+		require.NoError(t, err)
+		resp.Body.Close()
+	}
+	// The first request is sent at once, and each of the others 50
+	// milliseconds after the last.
+	require.GreaterOrEqual(t, time.Since(start), 200*time.Millisecond)
+}
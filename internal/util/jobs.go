@@ -0,0 +1,54 @@
+package util
+
+import (
+	"sync"
+
+	"github.com/replit/upm/internal/config"
+)
+
+// DefaultJobs is how many operations UPM does at once unless --jobs
+// or UPM_JOBS says otherwise.
+const DefaultJobs = 8
+
+var (
+	// jobsMutex guards jobsRunning.
+	jobsMutex sync.Mutex
+	// jobsCond is signalled when a job is done.
+	jobsCond = sync.NewCond(&jobsMutex)
+	// jobsRunning is how many jobs are running.
+	jobsRunning int
+)
+
+// Jobs returns how many operations UPM may do at once: requests to
+// registries, subprocesses, and reads of files of the project, which
+// share this one budget, so that UPM behaves predictably in a
+// container with few resources.
+func Jobs() int {
+	if config.Jobs > 0 {
+		return config.Jobs
+	}
+	return DefaultJobs
+}
+
+// StartJob waits until fewer than Jobs operations are running, and
+// counts one more, until FinishJob is called. It should only be called
+// around an operation that starts no others, such as a request, so
+// that an operation never waits for one that is waiting for it; the
+// goroutines that make requests, say, should number Jobs, but not hold
+// a job themselves.
+func StartJob() {
+	jobsMutex.Lock()
+	defer jobsMutex.Unlock()
+	for jobsRunning >= Jobs() {
+		jobsCond.Wait()
+	}
+	jobsRunning++
+}
+
+// FinishJob counts one operation less, as started by StartJob.
+func FinishJob() {
+	jobsMutex.Lock()
+	defer jobsMutex.Unlock()
+	jobsRunning--
+	jobsCond.Signal()
+}
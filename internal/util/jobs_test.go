@@ -0,0 +1,40 @@
+package util
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/replit/upm/internal/config"
+)
+
+func TestJobs(t *testing.T) {
+	config.Jobs = 2
+	defer func() { config.Jobs = 0 }()
+
+	var mutex sync.Mutex
+	running, maxRunning := 0, 0
+	var wg sync.WaitGroup
+	for i := 0; i < 6; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			StartJob()
+			defer FinishJob()
+			mutex.Lock()
+			running++
+			if running > maxRunning {
+				maxRunning = running
+			}
+			mutex.Unlock()
+			time.Sleep(10 * time.Millisecond)
+			mutex.Lock()
+			running--
+			mutex.Unlock()
+		}()
+	}
+	wg.Wait()
+	require.Equal(t, 2, maxRunning)
+}
@@ -3,6 +3,8 @@ package util
 import (
 	"fmt"
 	"os"
+	"sync"
+	"sync/atomic"
 
 	"github.com/replit/upm/internal/config"
 )
@@ -21,11 +23,46 @@ func ProgressMsg(msg string) {
 	Log("-->", msg)
 }
 
+// dieHooks are the functions that Die runs before terminating the
+// process.
+var dieHooks = []func(){}
+
+// OnDie arranges for the given function to be run if the process is
+// terminated by Die, for example to record a change that was partly
+// made. The most recently added functions are run first.
+func OnDie(f func()) {
+	dieHooks = append(dieHooks, f)
+}
+
+// exitMutex is held while the process is on its way out, by Die or
+// HandleSignals, so that one doesn't exit while the other is running
+// the functions given to OnDie.
+var exitMutex sync.Mutex
+
+// dying is set to 1 by the first call to Die, which holds exitMutex
+// from then on, so that a function given to OnDie can die too.
+var dying int32
+
+// exitStatus is the status that Die exits with: 1, or the status for
+// the signal that the process got, as set by HandleSignals.
+var exitStatus = 1
+
 // Die is like fmt.Printf, but writes to stderr, adds a newline, and
-// terminates the process.
+// terminates the process, after running any functions given to OnDie
+// and removing any temporary directories.
 func Die(format string, a ...interface{}) {
+	if atomic.CompareAndSwapInt32(&dying, 0, 1) {
+		exitMutex.Lock()
+	}
 	fmt.Fprintf(os.Stderr, format+"\n", a...)
-	os.Exit(1)
+	// If a hook dies too, the others are not run again.
+	hooks := dieHooks
+	dieHooks = nil
+	for i := len(hooks) - 1; i >= 0; i-- {
+		hooks[i]()
+	}
+	CleanupTemp()
+	os.Exit(exitStatus)
 }
 
 // Panicf is a composition of fmt.Sprintf and panic.
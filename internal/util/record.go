@@ -0,0 +1,318 @@
+package util
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+var (
+	// recordDir is the directory that HTTP responses and the
+	// outputs of subprocesses are recorded in, or "".
+	recordDir string
+	// replayDir is the directory that they are replayed from, or
+	// "".
+	replayDir string
+
+	// recordMutex guards recordCounts.
+	recordMutex sync.Mutex
+	// recordCounts is how many times each request or command has
+	// been recorded or replayed, by its key, since the same one may
+	// be made more than once with different results, as for a
+	// listing of the installed packages before and after
+	// installing one.
+	recordCounts = map[string]int{}
+)
+
+// replayCommandEnv is the environment variable that tells UPM to be a
+// replayed subprocess, rather than itself. It is the file of the
+// recording to replay.
+const replayCommandEnv = "UPM_REPLAY_COMMAND"
+
+func init() {
+	if file := os.Getenv(replayCommandEnv); file != "" {
+		exitReplayedCommand(file)
+	}
+}
+
+// SetRecording starts recording every HTTP response from HTTPClient
+// and the output and exit status of every subprocess run with
+// RunCommand or CommandOutput into the directory record, or replaying
+// them from the directory replay instead of making the requests and
+// running the subprocesses, so that a run can be reproduced exactly,
+// offline, for example as a deterministic test or to reproduce a
+// failure that a user recorded. At most one of them may be given; if
+// neither is, nothing is recorded or replayed.
+//
+// A request or command is replayed with the response or output that
+// was recorded for it, by its method, URL, and body or its arguments,
+// once for each time that it was recorded, in order. Paths in the
+// arguments within the working directory and temporary directories
+// are matched wherever those are, so a recording can be replayed on
+// another machine. A request or command that wasn't recorded fails.
+func SetRecording(record string, replay string) {
+	if record != "" && replay != "" {
+		Die("UPM_RECORD and UPM_REPLAY cannot both be set")
+	}
+	if record != "" {
+		if err := os.MkdirAll(record, 0777); err != nil {
+			Die("UPM_RECORD: %s", err)
+		}
+	}
+	if replay != "" {
+		if info, err := os.Stat(replay); err != nil {
+			Die("UPM_REPLAY: %s", err)
+		} else if !info.IsDir() {
+			Die("UPM_REPLAY: %s is not a directory", replay)
+		}
+	}
+	recordDir, replayDir = record, replay
+}
+
+// recordFile returns the file that the request or command with the
+// given key is recorded in, the next time that it is made.
+func recordFile(dir string, kind string, key string) string {
+	sum := sha256.Sum256([]byte(key))
+	name := kind + "-" + hex.EncodeToString(sum[:8])
+	recordMutex.Lock()
+	n := recordCounts[name]
+	recordCounts[name]++
+	recordMutex.Unlock()
+	return filepath.Join(dir, fmt.Sprintf("%s-%d.json", name, n))
+}
+
+// writeRecording writes the given recording to the given file.
+func writeRecording(file string, recording interface{}) {
+	contents, err := json.MarshalIndent(recording, "", "  ")
+	if err != nil {
+		Panicf("writeRecording: %s", err)
+	}
+	if err := ioutil.WriteFile(file, append(contents, '\n'), 0666); err != nil {
+		Die("UPM_RECORD: %s", err)
+	}
+}
+
+// readRecording reads the recording in the given file into recording,
+// returning false if there is no such file.
+func readRecording(file string, recording interface{}) bool {
+	contents, err := ioutil.ReadFile(file)
+	if os.IsNotExist(err) {
+		return false
+	} else if err != nil {
+		Die("UPM_REPLAY: %s", err)
+	}
+	if err := json.Unmarshal(contents, recording); err != nil {
+		Die("UPM_REPLAY: %s: %s", file, err)
+	}
+	return true
+}
+
+// httpRecording is a recorded HTTP response.
+type httpRecording struct {
+	// The request, for whoever reads the file.
+	Method string `json:"method"`
+	URL    string `json:"url"`
+
+	Status int         `json:"status"`
+	Header http.Header `json:"header"`
+	Body   []byte      `json:"body"`
+}
+
+// recordRoundTrip sends the given request with base, recording or
+// replaying it if SetRecording says to.
+func recordRoundTrip(base http.RoundTripper, req *http.Request) (*http.Response, error) {
+	if recordDir == "" && replayDir == "" {
+		return base.RoundTrip(req)
+	}
+
+	key := req.Method + " " + req.URL.String()
+	if req.Body != nil && req.Body != http.NoBody {
+		body, err := ioutil.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		key += "\n" + string(body)
+		req = req.Clone(req.Context())
+		req.Body = ioutil.NopCloser(bytes.NewReader(body))
+	}
+
+	if replayDir != "" {
+		var recording httpRecording
+		if !readRecording(recordFile(replayDir, "http", key), &recording) {
+			return nil, fmt.Errorf("%s %s was not recorded in %s", req.Method, req.URL, replayDir)
+		}
+		return &http.Response{
+			Status:        fmt.Sprintf("%d %s", recording.Status, http.StatusText(recording.Status)),
+			StatusCode:    recording.Status,
+			Proto:         "HTTP/1.1",
+			ProtoMajor:    1,
+			ProtoMinor:    1,
+			Header:        recording.Header,
+			Body:          ioutil.NopCloser(bytes.NewReader(recording.Body)),
+			ContentLength: int64(len(recording.Body)),
+			Request:       req,
+		}, nil
+	}
+
+	file := recordFile(recordDir, "http", key)
+	resp, err := base.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+	writeRecording(file, httpRecording{
+		Method: req.Method,
+		URL:    req.URL.String(),
+		Status: resp.StatusCode,
+		Header: resp.Header,
+		Body:   body,
+	})
+	return resp, nil
+}
+
+// commandRecording is the recorded output and exit status of a
+// subprocess.
+type commandRecording struct {
+	// The command, for whoever reads the file.
+	Args []string `json:"args"`
+
+	Stdout   []byte `json:"stdout"`
+	Stderr   []byte `json:"stderr"`
+	ExitCode int    `json:"exitCode"`
+	// The error if the command couldn't be run at all, such as
+	// when it isn't installed.
+	Error string `json:"error,omitempty"`
+}
+
+// commandKey returns the key that the given command is recorded by:
+// its arguments, with paths in the working directory and in temporary
+// directories written the same way wherever those are.
+func commandKey(command *exec.Cmd) string {
+	replacements := []string{}
+	tempPathsMutex.Lock()
+	for path := range tempPaths {
+		replacements = append(replacements, path, "$TMP")
+	}
+	tempPathsMutex.Unlock()
+	if wd, err := os.Getwd(); err == nil {
+		replacements = append(replacements, wd, "$PWD")
+	}
+	replacer := strings.NewReplacer(replacements...)
+
+	args := make([]string, len(command.Args))
+	for i, arg := range command.Args {
+		args[i] = replacer.Replace(arg)
+	}
+	return strings.Join(args, "\x00")
+}
+
+// runRecorded runs the given command, made by Command with the given
+// context, and waits for it to exit, recording or replaying it if
+// SetRecording says to. With output, it returns the stdout of the
+// command, like exec.Cmd.Output.
+func runRecorded(ctx context.Context, command *exec.Cmd, output bool) ([]byte, error) {
+	run := func(c *exec.Cmd) ([]byte, error) {
+		if output {
+			return c.Output()
+		}
+		return nil, c.Run()
+	}
+	if recordDir == "" && replayDir == "" {
+		return run(command)
+	}
+
+	if replayDir != "" {
+		file := recordFile(replayDir, "command", commandKey(command))
+		var recording commandRecording
+		if !readRecording(file, &recording) {
+			return nil, fmt.Errorf("%s was not recorded in %s", quoteCmd(command.Args), replayDir)
+		}
+		if recording.Error != "" {
+			return nil, fmt.Errorf("%s", recording.Error)
+		}
+		// The command is run as this same program, which only
+		// repeats the recording, so that it fails with an
+		// *exec.ExitError like the original did.
+		self, err := os.Executable()
+		if err != nil {
+			return nil, err
+		}
+		replayed := exec.CommandContext(ctx, self)
+		replayed.Env = append(os.Environ(), replayCommandEnv+"="+file)
+		replayed.Stdin = command.Stdin
+		replayed.Stdout = command.Stdout
+		replayed.Stderr = command.Stderr
+		return run(replayed)
+	}
+
+	file := recordFile(recordDir, "command", commandKey(command))
+	recording := commandRecording{Args: command.Args}
+	var stdout, stderr bytes.Buffer
+	if !output {
+		command.Stdout = teeWriter(command.Stdout, &stdout)
+	}
+	command.Stderr = teeWriter(command.Stderr, &stderr)
+	out, err := run(command)
+	if output {
+		recording.Stdout = out
+	} else {
+		recording.Stdout = stdout.Bytes()
+	}
+	recording.Stderr = stderr.Bytes()
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		recording.ExitCode = exitErr.ExitCode()
+	} else if err != nil {
+		recording.Error = err.Error()
+	}
+	// A command that was cancelled didn't get to do what it would
+	// have, so there is nothing to replay.
+	if ctx.Err() == nil {
+		writeRecording(file, recording)
+	}
+	return out, err
+}
+
+// teeWriter returns a writer that writes to both w, which may be nil,
+// and buf.
+func teeWriter(w io.Writer, buf *bytes.Buffer) io.Writer {
+	if w == nil {
+		return buf
+	}
+	return io.MultiWriter(w, buf)
+}
+
+// exitReplayedCommand writes the output recorded in the given file and
+// exits with the exit status that was recorded, as the subprocess that
+// runRecorded runs to replay a command.
+func exitReplayedCommand(file string) {
+	var recording commandRecording
+	if !readRecording(file, &recording) {
+		fmt.Fprintf(os.Stderr, "UPM_REPLAY: %s: not found\n", file)
+		os.Exit(1)
+	}
+	os.Stdout.Write(recording.Stdout)
+	os.Stderr.Write(recording.Stderr)
+	code := recording.ExitCode
+	if code < 0 {
+		// It was killed by a signal.
+		code = 1
+	}
+	os.Exit(code)
+}
@@ -0,0 +1,74 @@
+package util
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRecordAndReplay(t *testing.T) {
+	dir := t.TempDir()
+	defer SetRecording("", "")
+	restart := func(record string, replay string) {
+		SetRecording(record, replay)
+		recordCounts = map[string]int{}
+	}
+
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		fmt.Fprintf(w, "response %d", requests)
+	}))
+	defer server.Close()
+	get := func() string {
+		resp, err := HTTPClient.Get(server.URL + "/pkg")
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		body, err := ioutil.ReadAll(resp.Body)
+		require.NoError(t, err)
+		return string(body)
+	}
+
+	marker := filepath.Join(t.TempDir(), "marker")
+	cmd := []string{"sh", "-c", "echo out; echo err >&2; touch " + marker + "; exit 3"}
+	run := func() (string, int) {
+		output, err := CommandOutput(context.Background(), Command(context.Background(), cmd))
+		exitErr, ok := err.(*exec.ExitError)
+		require.True(t, ok, "%v", err)
+		return string(output), exitErr.ExitCode()
+	}
+
+	restart(dir, "")
+	require.Equal(t, "response 1", get())
+	require.Equal(t, "response 2", get())
+	output, code := run()
+	require.Equal(t, "out\n", output)
+	require.Equal(t, 3, code)
+	require.FileExists(t, marker)
+
+	// Nothing is requested or run again, and the same request is
+	// answered as it was each time.
+	restart("", dir)
+	require.NoError(t, os.Remove(marker))
+	require.Equal(t, "response 1", get())
+	require.Equal(t, "response 2", get())
+	require.Equal(t, 2, requests)
+	output, code = run()
+	require.Equal(t, "out\n", output)
+	require.Equal(t, 3, code)
+	require.NoFileExists(t, marker)
+
+	// Only as many times as it was recorded.
+	_, err := HTTPClient.Get(server.URL + "/pkg")
+	require.Error(t, err)
+	_, err = CommandOutput(context.Background(), Command(context.Background(), []string{"true"}))
+	require.Error(t, err)
+}
@@ -2,8 +2,10 @@ package util
 
 import (
 	"fmt"
+	"os"
 	"regexp"
 	"strings"
+	"time"
 )
 
 // AuthorInfo represents the author of a package. (Packages may only
@@ -55,3 +57,46 @@ func Regexps(patterns []string) []*regexp.Regexp {
 	}
 	return regexps
 }
+
+// envReferenceRegexp matches $${...} (an escaped reference) or
+// ${NAME} or ${NAME:-default}. The capture groups are the escape, the
+// name, the default marker, and the default.
+var envReferenceRegexp = regexp.MustCompile(`(\$?)\$\{([A-Za-z_][A-Za-z0-9_]*)(?:(:-)([^}]*))?\}`)
+
+// ExpandEnv returns the given string with each ${NAME} replaced by
+// the value of that environment variable. ${NAME:-default} is
+// replaced by default if the variable is unset or empty, and $${NAME}
+// by a literal ${NAME}. Unlike os.ExpandEnv, a reference to a variable
+// that is not set is an error, and nothing else that starts with $ is
+// touched.
+func ExpandEnv(s string) (string, error) {
+	var err error
+	expanded := envReferenceRegexp.ReplaceAllStringFunc(s, func(ref string) string {
+		m := envReferenceRegexp.FindStringSubmatch(ref)
+		if m[1] != "" {
+			return ref[1:]
+		}
+		value, ok := os.LookupEnv(m[2])
+		if m[3] != "" && value == "" {
+			return m[4]
+		}
+		if !ok && err == nil {
+			err = fmt.Errorf("environment variable %s is not set", m[2])
+		}
+		return value
+	})
+	return expanded, err
+}
+
+// FormatTimestamp returns the given date and time, as a registry
+// gives it in RFC 3339 format (possibly with fractional seconds or
+// another time zone), in the form that api.PkgInfo uses: RFC 3339 in
+// UTC, to the second. If the timestamp can't be parsed, it returns the
+// empty string.
+func FormatTimestamp(timestamp string) string {
+	t, err := time.Parse(time.RFC3339Nano, strings.TrimSpace(timestamp))
+	if err != nil {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339)
+}
@@ -0,0 +1,31 @@
+package util
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestExpandEnv(t *testing.T) {
+	os.Setenv("UPM_TEST_TOKEN", "s3cret")
+	os.Setenv("UPM_TEST_EMPTY", "")
+	defer os.Unsetenv("UPM_TEST_TOKEN")
+	defer os.Unsetenv("UPM_TEST_EMPTY")
+
+	for input, expected := range map[string]string{
+		"https://${UPM_TEST_TOKEN}@git.example.com": "https://s3cret@git.example.com",
+		"${UPM_TEST_EMPTY}":                         "",
+		"${UPM_TEST_EMPTY:-fallback}":               "fallback",
+		"${UPM_TEST_UNSET:-fallback}":               "fallback",
+		"$${UPM_TEST_TOKEN}":                        "${UPM_TEST_TOKEN}",
+		"$HOME and ${ not a reference":              "$HOME and ${ not a reference",
+	} {
+		actual, err := ExpandEnv(input)
+		require.NoError(t, err, input)
+		require.Equal(t, expected, actual, input)
+	}
+
+	_, err := ExpandEnv("${UPM_TEST_UNSET}")
+	require.EqualError(t, err, "environment variable UPM_TEST_UNSET is not set")
+}
@@ -0,0 +1,118 @@
+package util
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// tempPaths is the set of temporary files and directories that have
+// been created and not yet removed, so that they can be removed if
+// the process is terminated early.
+var tempPaths = map[string]bool{}
+
+// tempPathsMutex guards tempPaths, since temporary directories may be
+// made from several goroutines at once.
+var tempPathsMutex sync.Mutex
+
+// tempRoot returns the directory that temporary directories are made
+// in: UPM_TMPDIR if it is set, for sandboxes whose /tmp is small or
+// missing, or else the system default. It is created if necessary.
+func tempRoot() string {
+	root := os.Getenv("UPM_TMPDIR")
+	if root == "" {
+		return ""
+	}
+	if err := os.MkdirAll(root, 0777); err != nil {
+		Die("UPM_TMPDIR: %s", err)
+	}
+	return root
+}
+
+// TempDir creates and returns the name of a temporary directory,
+// whose name starts with the given prefix. If creation fails, it
+// terminates the process. The caller should remove the directory with
+// RemoveTemp once it is done with it; until then, it is removed if the
+// process is terminated by Die or a signal, or when CleanupTemp is
+// called.
+func TempDir(prefix string) string {
+	tempdir, err := ioutil.TempDir(tempRoot(), prefix)
+	if err != nil {
+		Die("%s", err)
+	}
+	tempPathsMutex.Lock()
+	defer tempPathsMutex.Unlock()
+	tempPaths[tempdir] = true
+	return tempdir
+}
+
+// RemoveTemp removes the given temporary file or directory, made by
+// TempDir, along with everything in it. If there is an error, it
+// terminates the process.
+func RemoveTemp(path string) {
+	tempPathsMutex.Lock()
+	delete(tempPaths, path)
+	tempPathsMutex.Unlock()
+	if err := os.RemoveAll(path); err != nil {
+		Die("%s: %s", path, err)
+	}
+}
+
+// CleanupTemp removes every temporary file and directory that hasn't
+// been removed yet. Errors are ignored, since it is run on the way
+// out.
+func CleanupTemp() {
+	tempPathsMutex.Lock()
+	defer tempPathsMutex.Unlock()
+	for path := range tempPaths {
+		os.RemoveAll(path)
+		delete(tempPaths, path)
+	}
+}
+
+// signalWait is how long HandleSignals waits for subprocesses to be
+// killed before exiting anyway.
+const signalWait = 5 * time.Second
+
+// HandleSignals returns a context that is cancelled if the process is
+// interrupted or terminated by a signal, so that the subprocesses that
+// are running under it are killed rather than left running on their
+// own. Once they have exited, CleanupTemp is run and the process exits
+// with the status a shell gives a process killed by that signal, which
+// is also the status that Die exits with from then on.
+func HandleSignals() context.Context {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM, syscall.SIGHUP)
+	go func() {
+		sig := <-signals
+		exitMutex.Lock()
+		if sig, ok := sig.(syscall.Signal); ok {
+			exitStatus = 128 + int(sig)
+		}
+		exitMutex.Unlock()
+
+		cancel()
+		done := make(chan struct{})
+		go func() {
+			runningCommands.Wait()
+			close(done)
+		}()
+		select {
+		case <-done:
+		case <-time.After(signalWait):
+		}
+
+		// If the operation is dying because it was cancelled,
+		// let it finish.
+		exitMutex.Lock()
+		CleanupTemp()
+		os.Exit(exitStatus)
+	}()
+	return ctx
+}
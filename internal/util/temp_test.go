@@ -0,0 +1,30 @@
+package util
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTempDir(t *testing.T) {
+	root, err := ioutil.TempDir("", "upm-tmpdir")
+	require.NoError(t, err)
+	defer os.RemoveAll(root)
+	os.Setenv("UPM_TMPDIR", filepath.Join(root, "tmp"))
+	defer os.Unsetenv("UPM_TMPDIR")
+
+	removed := TempDir("upm")
+	kept := TempDir("upm")
+	require.Equal(t, filepath.Join(root, "tmp"), filepath.Dir(removed))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(kept, "file"), nil, 0666))
+
+	RemoveTemp(removed)
+	require.False(t, Exists(removed))
+	require.True(t, Exists(kept))
+
+	CleanupTemp()
+	require.False(t, Exists(kept))
+}
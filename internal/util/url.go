@@ -0,0 +1,180 @@
+package util
+
+import (
+	"net"
+	"net/url"
+	"strings"
+	"unicode/utf8"
+)
+
+// JoinURL returns the given base URL with the given path segments
+// appended, each of them escaped by EscapePathSegment, so that a
+// package name containing "/" (as npm scoped names do), "?", "#", or
+// non-ASCII letters is requested as a single segment rather than
+// changing what the URL refers to. An empty segment gives a trailing
+// slash. A host with
+// non-ASCII letters, as may be configured for a private registry, is
+// converted to its ASCII (punycode) form, since that is the only form
+// that DNS knows. If the base URL is invalid, JoinURL terminates the
+// process.
+func JoinURL(base string, segments ...string) string {
+	u, err := url.Parse(base)
+	if err != nil {
+		Die("invalid URL %q: %s", base, err)
+	}
+	u.Host = asciiHost(u.Host)
+	joined := strings.TrimSuffix(u.String(), "/")
+	for _, segment := range segments {
+		if segment == "" {
+			joined += "/"
+			continue
+		}
+		joined += "/" + EscapePathSegment(segment)
+	}
+	return joined
+}
+
+// EscapePathSegment returns the given package name, or other string
+// taken from the user or from a registry, escaped so that it can be
+// used as one segment of the path of a URL. Since escaping can't stop
+// a server (or a proxy in front of it) from resolving "." and ".."
+// segments, which would let the name change which resource is
+// requested, EscapePathSegment terminates the process for those and
+// for the empty string instead.
+func EscapePathSegment(segment string) string {
+	if segment == "" || segment == "." || segment == ".." {
+		Die("%q cannot be used as part of a URL", segment)
+	}
+	return url.PathEscape(segment)
+}
+
+// asciiHost returns the given host, which may include a port, with
+// each label that has non-ASCII letters converted to punycode, as IDNA
+// specifies: for example "bücher.example" becomes
+// "xn--bcher-kva.example".
+func asciiHost(host string) string {
+	port := ""
+	if h, p, err := net.SplitHostPort(host); err == nil {
+		host, port = h, p
+	}
+	labels := strings.Split(host, ".")
+	for i, label := range labels {
+		if !isASCII(label) {
+			labels[i] = "xn--" + punycodeEncode(strings.ToLower(label))
+		}
+	}
+	host = strings.Join(labels, ".")
+	if port != "" {
+		host = net.JoinHostPort(host, port)
+	}
+	return host
+}
+
+// isASCII returns true if the given string has only ASCII characters.
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= utf8.RuneSelf {
+			return false
+		}
+	}
+	return true
+}
+
+// Parameters of punycode, from RFC 3492.
+const (
+	punycodeBase        = 36
+	punycodeTMin        = 1
+	punycodeTMax        = 26
+	punycodeSkew        = 38
+	punycodeDamp        = 700
+	punycodeInitialBias = 72
+	punycodeInitialN    = 128
+)
+
+// punycodeEncode returns the punycode encoding of the given label,
+// without the "xn--" prefix, as described in section 6.3 of RFC 3492.
+func punycodeEncode(label string) string {
+	runes := []rune(label)
+	out := []byte{}
+	for _, r := range runes {
+		if r < utf8.RuneSelf {
+			out = append(out, byte(r))
+		}
+	}
+	basic := len(out)
+	handled := basic
+	if basic > 0 {
+		out = append(out, '-')
+	}
+
+	n := punycodeInitialN
+	bias := punycodeInitialBias
+	delta := 0
+	for handled < len(runes) {
+		// The smallest code point that hasn't been handled.
+		m := int(utf8.MaxRune) + 1
+		for _, r := range runes {
+			if int(r) >= n && int(r) < m {
+				m = int(r)
+			}
+		}
+		delta += (m - n) * (handled + 1)
+		n = m
+
+		for _, r := range runes {
+			if int(r) < n {
+				delta++
+			}
+			if int(r) != n {
+				continue
+			}
+			q := delta
+			for k := punycodeBase; ; k += punycodeBase {
+				t := k - bias
+				if t < punycodeTMin {
+					t = punycodeTMin
+				} else if t > punycodeTMax {
+					t = punycodeTMax
+				}
+				if q < t {
+					break
+				}
+				out = append(out, punycodeDigit(t+(q-t)%(punycodeBase-t)))
+				q = (q - t) / (punycodeBase - t)
+			}
+			out = append(out, punycodeDigit(q))
+			bias = punycodeAdapt(delta, handled+1, handled == basic)
+			delta = 0
+			handled++
+		}
+		delta++
+		n++
+	}
+	return string(out)
+}
+
+// punycodeAdapt is the bias adaptation function of section 6.1 of RFC
+// 3492.
+func punycodeAdapt(delta int, numPoints int, first bool) int {
+	if first {
+		delta /= punycodeDamp
+	} else {
+		delta /= 2
+	}
+	delta += delta / numPoints
+	k := 0
+	for delta > ((punycodeBase-punycodeTMin)*punycodeTMax)/2 {
+		delta /= punycodeBase - punycodeTMin
+		k += punycodeBase
+	}
+	return k + (punycodeBase-punycodeTMin+1)*delta/(delta+punycodeSkew)
+}
+
+// punycodeDigit returns the character for the given digit, which is
+// from 0 to 35.
+func punycodeDigit(d int) byte {
+	if d < 26 {
+		return byte('a' + d)
+	}
+	return byte('0' + d - 26)
+}
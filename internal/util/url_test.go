@@ -0,0 +1,35 @@
+package util
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPunycodeEncode(t *testing.T) {
+	// Checked against the punycode codec of Python.
+	for input, expected := range map[string]string{
+		"bücher":      "bcher-kva",
+		"münchen":     "mnchen-3ya",
+		"ü":           "tda",
+		"例え":          "r8jz45g",
+		"пример":      "e1afmkfd",
+		"abc-ünicöde": "abc-nicde-67a6d",
+	} {
+		require.Equal(t, expected, punycodeEncode(input), input)
+	}
+}
+
+func TestJoinURL(t *testing.T) {
+	for expected, args := range map[string][]string{
+		"https://pypi.org/pypi/flask/json":            {"https://pypi.org/pypi", "flask", "json"},
+		"https://registry.npmjs.org/@types%2Fnode":    {"https://registry.npmjs.org/", "@types/node"},
+		"https://pub.dev/api/search/":                 {"https://pub.dev", "api", "search", ""},
+		"https://example.com/pkg/caf%C3%A9":           {"https://example.com/pkg", "café"},
+		"https://example.com/a%3Fb%23c":               {"https://example.com", "a?b#c"},
+		"https://example.com/..%2Fadmin":              {"https://example.com", "../admin"},
+		"https://xn--bcher-kva.example:8080/packages": {"https://Bücher.example:8080", "packages"},
+	} {
+		require.Equal(t, expected, JoinURL(args[0], args[1:]...), args)
+	}
+}
@@ -1,6 +1,8 @@
 # This is a Python script that implements bare imports for Python
-# using pipreqs. It takes no arguments, and dumps a list of package
-# names (strings) to stdout in JSON format. The script works in both
+# using pipreqs. It takes the directories to ignore, separated by
+# spaces, and optionally a file that lists the only files to scan, one
+# per line, and dumps a list of package names (strings) to stdout in
+# JSON format. The script works in both
 # Python 2 and Python 3. It expects pipreqs.py to be on the
 # PYTHONPATH. UPM accomplishes this by writing it into the same
 # directory as this script.
@@ -10,7 +12,12 @@ import json
 import pipreqs
 import sys
 
+only_files = None
+if len(sys.argv) > 2:
+    with open(sys.argv[2]) as f:
+        only_files = set(line.rstrip("\n") for line in f if line.strip())
+
 imports, had_errors = pipreqs.get_all_imports(
-    ".", extra_ignore_dirs=sys.argv[1].split()
+    ".", extra_ignore_dirs=sys.argv[1].split(), only_files=only_files
 )
 json.dump({"imports": imports, "success": not had_errors}, sys.stdout)
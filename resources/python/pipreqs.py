@@ -7,13 +7,15 @@
 # The get_all_imports function and supporting code have been pulled out along
 # with a modification to the interface. get_all_imports changed so that it
 # doesn't abort on errors, but rather returns a boolean to indicate whether
-# there were any.
+# there were any, and so that it records which files import each module.
 
 import os
 import sys
 import re
 import codecs
 import ast
+import json
+import shlex
 
 if sys.version_info[0] > 2:
     open_func = open
@@ -24,12 +26,121 @@ else:
     py2_exclude = ["concurrent", "concurrent.futures"]
 
 
+def parse_pragmas(line):
+    """Return the pragmas in a "#upm" comment at the end of the given import
+    statement, such as "# upm package=Pillow version=^10 dev", as a dict with
+    any of the keys "package", "version", "dev", and "ignore". The older
+    "#upm package(Pillow)" form is understood too."""
+    m = re.search(r'#\s*upm\s(.*)$', line)
+    if not m:
+        return {}
+    try:
+        words = shlex.split(m.group(1))
+    except ValueError:
+        words = m.group(1).split()
+    pragmas = {}
+    for word in words:
+        old_style = re.match(r'^package\((.*)\)$', word)
+        key, sep, value = word.partition('=')
+        if old_style:
+            pragmas['package'] = old_style.group(1)
+        elif sep and key in ('package', 'version'):
+            pragmas[key] = value
+        elif not sep and word in ('dev', 'ignore'):
+            pragmas[word] = True
+    return pragmas
+
+
+def scan_imports(contents, file_name, raw_imports):
+    """Add the modules imported by the given source code, from the file
+    with the given name, to raw_imports, with any pragmas and the files
+    that import them. Raises an exception if the code can't be
+    parsed."""
+    # We need to be able to reference a pragma in the comments
+    lines = contents.split('\n')
+    tree = ast.parse(contents)
+    for node in ast.walk(tree):
+        modnames = []
+        if isinstance(node, ast.Import):
+            modnames = [subnode.name for subnode in node.names]
+        elif isinstance(node, ast.ImportFrom) and node.module and not node.level:
+            # A relative import, as in ``from .utils import x``, is
+            # of the project itself. The names imported from a module
+            # may be submodules, as in ``from google.cloud import
+            # storage``, which tell which package of a namespace is
+            # imported. Those that aren't are ignored by UPM, since
+            # they only add to the name of a module it knows.
+            modnames = [node.module] + [node.module + "." + subnode.name
+                                        for subnode in node.names
+                                        if subnode.name != "*"]
+
+        # If the node was an import, look for pragmas
+        for modname in modnames:
+            pragmas = raw_imports.setdefault(modname, {"files": []})
+
+            # Which lines are part of this statement
+            statement_lines = lines[node.lineno - 1:
+                                    node.end_lineno]
+
+            # Reconstruct the statement
+            line = ''.join([l.rstrip('\\')
+                            for l in statement_lines])
+
+            # If this line ends in a pragma add it
+            pragmas.update(parse_pragmas(line))
+
+            # Record the file
+            if file_name not in pragmas["files"]:
+                pragmas["files"].append(file_name)
+
+
+def notebook_cells(contents):
+    """Return the source code of each Python code cell in the given
+    contents of a Jupyter notebook, with IPython magics and shell
+    commands removed, since they aren't Python."""
+    notebook = json.loads(contents)
+    metadata = notebook.get("metadata", {})
+    language = (metadata.get("kernelspec", {}).get("language") or
+                metadata.get("language_info", {}).get("name") or
+                "python")
+    if language.lower() != "python":
+        return []
+
+    # nbformat 4 has the cells at the top level, and nbformat 3 in
+    # worksheets.
+    cells = notebook.get("cells")
+    if cells is None:
+        cells = [cell for worksheet in notebook.get("worksheets", [])
+                 for cell in worksheet.get("cells", [])]
+
+    sources = []
+    for cell in cells:
+        if cell.get("cell_type") != "code":
+            continue
+        source = cell.get("source", cell.get("input", ""))
+        if isinstance(source, list):
+            source = "".join(source)
+        # A cell magic such as %%bash makes the whole cell
+        # something else.
+        if source.lstrip().startswith("%%"):
+            continue
+        lines = []
+        for line in source.split("\n"):
+            if line.lstrip().startswith(("%", "!")):
+                # Keep the line numbers the same.
+                line = ""
+            lines.append(line)
+        sources.append("\n".join(lines))
+    return sources
+
+
 def get_all_imports(
-        path, encoding=None, extra_ignore_dirs=None, follow_links=True):
-    imports = {}
+        path, encoding=None, extra_ignore_dirs=None, follow_links=True,
+        only_files=None):
     raw_imports = {}
     candidates = []
-    ignore_dirs = [".hg", ".svn", ".git", ".tox", "__pycache__", "env", "venv"]
+    ignore_dirs = [".hg", ".svn", ".git", ".tox", "__pycache__", "env", "venv",
+                   ".ipynb_checkpoints"]
 
     if extra_ignore_dirs:
         ignore_dirs_parsed = []
@@ -43,60 +154,56 @@ def get_all_imports(
         dirs[:] = [d for d in dirs if d not in ignore_dirs]
 
         candidates.append(os.path.basename(root))
+        notebooks = [fn for fn in files if os.path.splitext(fn)[1] == ".ipynb"]
         files = [fn for fn in files if os.path.splitext(fn)[1] == ".py"]
 
         candidates += [os.path.splitext(fn)[0] for fn in files]
+        # Every file counts as a local module, but only those in
+        # only_files, if it is given, are read.
+        if only_files is not None:
+            files = [fn for fn in files
+                     if os.path.relpath(os.path.join(root, fn), path).replace(os.sep, "/")
+                     in only_files]
+            notebooks = [fn for fn in notebooks
+                         if os.path.relpath(os.path.join(root, fn), path).replace(os.sep, "/")
+                         in only_files]
         for file_name in files:
             file_name = os.path.join(root, file_name)
             with open_func(file_name, "r", encoding=encoding) as f:
                 contents = f.read()
             try:
-                # We need to be able to reference a pragma in the comments
-                lines = contents.split('\n')
-                tree = ast.parse(contents)
-                for node in ast.walk(tree):
-                    modname = None
-                    if isinstance(node, ast.Import):
-                        for subnode in node.names:
-                            modname = subnode.name
-                    elif isinstance(node, ast.ImportFrom):
-                        modname = node.module
-
-                    # If the node was an import, look for pragmas
-                    pragmas = {}
-                    if modname:
-                        # Which lines are part of this statement
-                        statement_lines = lines[node.lineno - 1:
-                                                node.end_lineno]
-
-                        # Reconstruct the statement
-                        line = ''.join([l.rstrip('\\')
-                                        for l in statement_lines])
-
-                        # If this line ends in a pragma add it
-                        m = re.match('^.*#upm package\\((.*)\\).*$', line)
-                        if m:
-                            pragmas['package'] = m.group(1)
-
-                        # Record the module name
-                        # Name could have been None if the import
-                        # statement was as ``from . import X``. We drop that
-                        # case but including the insert in ``if modname``
-                        raw_imports[modname] = pragmas
+                scan_imports(contents, os.path.relpath(file_name, path),
+                             raw_imports)
             except Exception as exc:
                 had_errors = True
                 continue
 
-    # Clean up imports
-    for name in raw_imports.keys():
-        # Cleanup: We only want to first part of the import.
-        # Ex: from django.conf --> django.conf. But we only want django
-        # as an import.
-        cleaned_name, _, _ = name.partition('.')
-        imports[cleaned_name] = raw_imports[name]
-
-    missing_modules = imports.keys() - (set(candidates) & imports.keys())
-    return {k: imports[k] for k in missing_modules}, had_errors
+        for file_name in notebooks:
+            file_name = os.path.join(root, file_name)
+            with open_func(file_name, "r", encoding="utf-8") as f:
+                contents = f.read()
+            try:
+                cells = notebook_cells(contents)
+            except Exception as exc:
+                had_errors = True
+                continue
+            # Each cell is parsed on its own, so that one that
+            # doesn't parse doesn't hide the imports of the others.
+            for cell in cells:
+                try:
+                    scan_imports(cell, os.path.relpath(file_name, path),
+                                 raw_imports)
+                except Exception as exc:
+                    had_errors = True
+
+    # Local modules are recognized by the first part of the name, as
+    # in ``import mypkg.utils``. The whole dotted name is kept, so that
+    # UPM can tell which package of a namespace such as google.cloud is
+    # imported.
+    candidates = set(candidates)
+    imports = {name: pragmas for name, pragmas in raw_imports.items()
+               if name.partition('.')[0] not in candidates}
+    return imports, had_errors
 
 def join(f):
     return os.path.join(os.path.dirname(__file__), f)
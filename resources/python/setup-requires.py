@@ -0,0 +1,57 @@
+# This is a Python script that reads the dependencies declared in the
+# setup.py given as its argument. It runs the file with setup()
+# replaced, so that nothing is built or installed, and dumps the
+# install_requires and extras_require arguments to stdout in JSON
+# format. The script works in both Python 2 and Python 3.
+
+from __future__ import print_function
+import json
+import os
+import sys
+
+args = {}
+
+
+def setup(**kwargs):
+    args.update(kwargs)
+
+
+def requirements(value):
+    if value is None:
+        return []
+    if isinstance(value, str):
+        value = value.splitlines()
+    return [line.strip() for line in value if line.strip()]
+
+
+try:
+    import setuptools
+
+    setuptools.setup = setup
+except ImportError:
+    pass
+try:
+    import distutils.core
+
+    distutils.core.setup = setup
+except ImportError:
+    pass
+
+path = os.path.abspath(sys.argv[1])
+sys.path.insert(0, os.path.dirname(path))
+sys.argv = [path, "--name"]
+with open(path) as f:
+    code = compile(f.read(), path, "exec")
+exec(code, {"__name__": "__main__", "__file__": path})
+
+json.dump(
+    {
+        "name": args.get("name") or "",
+        "install_requires": requirements(args.get("install_requires")),
+        "extras_require": dict(
+            (extra, requirements(reqs))
+            for extra, reqs in (args.get("extras_require") or {}).items()
+        ),
+    },
+    sys.stdout,
+)
@@ -0,0 +1,16 @@
+# This is a Ruby script which dumps the dependencies of each package in
+# the Gemfile.lock to stdout in JSON format. The JSON is a map from
+# package names to lists of the names of the packages that they depend
+# on directly, all strings.
+
+require 'bundler'
+require 'json'
+
+lockfile = Bundler::LockfileParser.new(Bundler.read_file(Bundler.default_lockfile))
+
+result = {}
+lockfile.specs.each do |spec|
+  result[spec.name] = spec.dependencies.map(&:name).uniq
+end
+
+puts result.to_json
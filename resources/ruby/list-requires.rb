@@ -0,0 +1,51 @@
+require 'parser/current'
+require 'json'
+
+# Lists the paths that the Ruby files of the project require, along with
+# the files that require each, so that UPM can look up the gems that
+# provide them in its map of rubygems.org.
+
+def list_requires(file, code, requires)
+  root = Parser::CurrentRuby.parse(code)
+  traverse_node(file, root, requires)
+end
+
+def traverse_node(file, node, requires)
+  if node.class != Parser::AST::Node
+    return
+  end
+  
+  # Looking for any s(:send, nil, :require, s(:str, "gem"))
+  if node.type == :send && node.children[1] == :require
+    req_node = node.children[2]
+    if req_node.class == Parser::AST::Node && req_node.type == :str
+      process_require(file, req_node.children.first, requires)
+      return
+    end
+  end
+
+  node.children.each { |child|
+    traverse_node(file, child, requires)
+  }
+end
+
+def process_require(file, req_str, requires)
+  if req_str.empty?
+    return
+  end
+
+  # Skip absolute or relative requires
+  if req_str.start_with?('/') || req_str.start_with?('.')
+    return
+  end
+
+  (requires[req_str] ||= []) << file
+end
+
+requires = {}
+
+Dir.glob("**/*.rb").reject {|f| f['./.bundle'] }.each { |file|
+  list_requires(file, File.read(file), requires)
+}
+
+puts requires.to_json()
@@ -22,6 +22,8 @@ buildGo117Module {
     preBuild = ''
         ${statik}/bin/statik -src resources -dest internal -f
         go generate ./internal/backends/python
+        go generate ./internal/backends/nodejs
+        go generate ./internal/backends/ruby
     '';
 
     doCheck = false;